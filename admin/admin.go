@@ -0,0 +1,87 @@
+// Package admin serves the administrative HTTP endpoints, which shouldn't be exposed publicly
+package admin
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/events"
+	"github.com/gopistolet/gopistolet/handlers/queue"
+	"github.com/gopistolet/gopistolet/log"
+)
+
+func New(c *config.Config) *Server {
+	s := &Server{
+		config: c,
+	}
+	c.Events.Subscribe(s.counts.Count)
+	return s
+}
+
+// Server is the admin HTTP listener
+type Server struct {
+	// Queue provides the queue snapshots, the queue endpoints are unavailable if it's nil
+	Queue *queue.Snapshots
+	// Panics returns the numbers of recovered panics per part of the server (e.g. "sessions"), for the metrics
+	Panics func() map[string]uint64
+
+	config *config.Config
+	server *http.Server
+	// counts of the published events, for the metrics
+	counts events.Counts
+}
+
+// Handler returns the handler with all admin endpoints:
+//
+//	/debug/pprof/            the net/http/pprof endpoints
+//	/debug/bundle?seconds=N  a zip file with CPU, heap, goroutine and mutex profiles
+//	/queue/snapshot          the latest queue snapshot as JSON (?download=1 to save it)
+//	/metrics                 the latest queue snapshot, the event counts and the panics in the Prometheus text format
+//	/quota                   the usage and quota of the mailboxes as JSON
+//	/dkim                    the DNS records of the DKIM keys which have to be published
+//	/transcripts             the session transcripts in memory as JSON (?id=... for one as text)
+//	/tables                  the outcome of the reloads of the config, access rules, aliases and transports as JSON
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/bundle", profileBundle)
+	mux.HandleFunc("/queue/snapshot", s.queueSnapshot)
+	mux.HandleFunc("/metrics", s.metrics)
+	mux.HandleFunc("/quota", s.quota)
+	mux.HandleFunc("/dkim", s.dkim)
+	mux.HandleFunc("/transcripts", s.transcripts)
+	mux.HandleFunc("/tables", s.tables)
+	return mux
+}
+
+// Start listens on the admin address in the background,
+// it doesn't do anything if no address is configured
+func (s *Server) Start() {
+	if s.config.Admin.Address == "" {
+		return
+	}
+
+	s.server = &http.Server{
+		Addr:    s.config.Admin.Address,
+		Handler: s.Handler(),
+	}
+	go func() {
+		log.Println("Admin listener on " + s.config.Admin.Address)
+		err := s.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorln("Admin listener:", err)
+		}
+	}()
+}
+
+// Stop closes the admin listener
+func (s *Server) Stop() {
+	if s.server != nil {
+		s.server.Close()
+	}
+}
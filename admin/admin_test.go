@@ -0,0 +1,195 @@
+package admin
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/dkim"
+	"github.com/gopistolet/gopistolet/events"
+	"github.com/gopistolet/gopistolet/handlers/queue"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/spool"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAdmin(t *testing.T) {
+
+	Convey("Testing pprof endpoints", t, func() {
+		h := New(&config.Config{}).Handler()
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+		So(w.Code, ShouldEqual, 200)
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/goroutine?debug=1", nil))
+		So(w.Code, ShouldEqual, 200)
+	})
+
+	Convey("Testing profile bundle", t, func() {
+		h := New(&config.Config{}).Handler()
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/bundle?seconds=1", nil))
+		So(w.Code, ShouldEqual, 200)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "application/zip")
+
+		archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		So(err, ShouldEqual, nil)
+		names := []string{}
+		for _, file := range archive.File {
+			names = append(names, file.Name)
+		}
+		So(names, ShouldResemble, []string{"cpu.pprof", "heap.pprof", "goroutine.pprof", "mutex.pprof", "goroutines.txt"})
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/bundle?seconds=-1", nil))
+		So(w.Code, ShouldEqual, 400)
+	})
+
+	Convey("Testing queue endpoints", t, func() {
+		c := &config.Config{}
+		s := New(c)
+		h := s.Handler()
+
+		// no snapshot yet
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		So(w.Code, ShouldEqual, 503)
+
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		s.Queue = &queue.Snapshots{Store: &spool.FileStore{Dir: dir}}
+		So(s.Queue.Take(), ShouldEqual, nil)
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		So(w.Code, ShouldEqual, 200)
+		So(w.Body.String(), ShouldContainSubstring, "gopistolet_queue_messages 0\n")
+		So(w.Body.String(), ShouldContainSubstring, "gopistolet_queue_age_seconds_bucket{le=\"+Inf\"} 0\n")
+
+		c.Events.Publish(events.ConnectionOpened{Ip: "192.0.2.1"})
+		c.Events.Publish(events.ConnectionOpened{Ip: "192.0.2.2"})
+		c.Events.Publish(events.MessageQueued{})
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		So(w.Body.String(), ShouldContainSubstring, "gopistolet_events_total{event=\"ConnectionOpened\"} 2\ngopistolet_events_total{event=\"MessageQueued\"} 1\n")
+		So(w.Body.String(), ShouldNotContainSubstring, "gopistolet_panics_total")
+
+		s.Panics = func() map[string]uint64 {
+			return map[string]uint64{"sessions": 1, "handlers": 2}
+		}
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		So(w.Body.String(), ShouldContainSubstring, "gopistolet_panics_total{part=\"handlers\"} 2\ngopistolet_panics_total{part=\"sessions\"} 1\n")
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/queue/snapshot?download=1", nil))
+		So(w.Code, ShouldEqual, 200)
+		So(w.Header().Get("Content-Disposition"), ShouldStartWith, "attachment")
+		snapshot := queue.Snapshot{}
+		So(json.Unmarshal(w.Body.Bytes(), &snapshot), ShouldEqual, nil)
+		So(snapshot.Messages, ShouldEqual, 0)
+	})
+
+	Convey("Testing quota endpoint", t, func() {
+		dir, err := ioutil.TempDir("", "maildir")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{}
+		_, err = c.MailboxUsage.Fits(dir, 1000, 10)
+		So(err, ShouldEqual, nil)
+		c.MailboxUsage.Add(dir, 10)
+
+		w := httptest.NewRecorder()
+		New(c).Handler().ServeHTTP(w, httptest.NewRequest("GET", "/quota", nil))
+		So(w.Code, ShouldEqual, 200)
+		usage := []helpers.MailboxUsageEntry{}
+		So(json.Unmarshal(w.Body.Bytes(), &usage), ShouldEqual, nil)
+		So(usage, ShouldResemble, []helpers.MailboxUsageEntry{{Mailbox: dir, Usage: 10, Quota: 1000}})
+	})
+
+	Convey("Testing DKIM endpoint", t, func() {
+		c := &config.Config{}
+		w := httptest.NewRecorder()
+		New(c).Handler().ServeHTTP(w, httptest.NewRequest("GET", "/dkim", nil))
+		So(w.Code, ShouldEqual, 404)
+
+		dir, err := ioutil.TempDir("", "dkim")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		c.Dkim.Dir = dir
+		c.Dkim.Domains = []string{"example.com"}
+		c.Dkim.Algorithm = dkim.AlgorithmEd25519
+		key, err := c.Dkim.Generate("example.com")
+		So(err, ShouldEqual, nil)
+
+		w = httptest.NewRecorder()
+		New(c).Handler().ServeHTTP(w, httptest.NewRequest("GET", "/dkim", nil))
+		So(w.Code, ShouldEqual, 200)
+		So(w.Body.String(), ShouldStartWith, key.Name()+`. IN TXT ( "v=DKIM1; k=ed25519; p=`)
+	})
+
+	Convey("Testing transcripts endpoint", t, func() {
+		c := &config.Config{}
+		w := httptest.NewRecorder()
+		New(c).Handler().ServeHTTP(w, httptest.NewRequest("GET", "/transcripts", nil))
+		So(w.Code, ShouldEqual, 404)
+
+		c.Transcripts.Enabled = true
+		server, client := net.Pipe()
+		go ioutil.ReadAll(client)
+		recorded, transcript := c.Transcripts.Conn(server)
+		recorded.Write([]byte("220 mx.example.com Service Ready\r\n"))
+		c.Transcripts.Finish(transcript, "1")
+		client.Close()
+
+		w = httptest.NewRecorder()
+		New(c).Handler().ServeHTTP(w, httptest.NewRequest("GET", "/transcripts", nil))
+		So(w.Code, ShouldEqual, 200)
+		list := []struct {
+			Id    string
+			Lines int
+		}{}
+		So(json.Unmarshal(w.Body.Bytes(), &list), ShouldEqual, nil)
+		So(len(list), ShouldEqual, 1)
+		So(list[0].Id, ShouldEqual, transcript.Id)
+		So(list[0].Lines, ShouldEqual, 1)
+
+		w = httptest.NewRecorder()
+		New(c).Handler().ServeHTTP(w, httptest.NewRequest("GET", "/transcripts?id="+transcript.Id, nil))
+		So(w.Code, ShouldEqual, 200)
+		So(w.Body.String(), ShouldContainSubstring, "S: 220 mx.example.com Service Ready")
+
+		w = httptest.NewRecorder()
+		New(c).Handler().ServeHTTP(w, httptest.NewRequest("GET", "/transcripts?id=unknown", nil))
+		So(w.Code, ShouldEqual, 404)
+	})
+
+	Convey("Testing tables endpoint", t, func() {
+		c := &config.Config{}
+		c.Reload.Record(config.ReloadAliases, "/etc/aliases", 3, nil)
+		c.Reload.Record(config.ReloadAliases, "/etc/aliases", 0, errors.New("/etc/aliases:4: missing ':'"))
+
+		w := httptest.NewRecorder()
+		New(c).Handler().ServeHTTP(w, httptest.NewRequest("GET", "/tables", nil))
+		So(w.Code, ShouldEqual, 200)
+		status := map[string]config.ReloadStatus{}
+		So(json.Unmarshal(w.Body.Bytes(), &status), ShouldEqual, nil)
+		So(status[config.ReloadAliases].Entries, ShouldEqual, 3)
+		So(status[config.ReloadAliases].Error, ShouldEqual, "/etc/aliases:4: missing ':'")
+		So(status[config.ReloadAliases].Loaded.IsZero(), ShouldBeFalse)
+	})
+
+}
@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// dkim sends the DNS records of the published DKIM keys of all domains in zone file format
+func (s *Server) dkim(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if s.config.Dkim.Dir == "" {
+		http.Error(w, "DKIM keys aren't configured", http.StatusNotFound)
+		return
+	}
+
+	for _, domain := range s.config.Dkim.Domains {
+		keys, err := s.config.Dkim.Published(domain)
+		if err != nil {
+			fmt.Fprintf(w, "; %s: %v\n", domain, err)
+			continue
+		}
+		for _, key := range keys {
+			zone, err := key.Zone()
+			if err != nil {
+				fmt.Fprintf(w, "; %s: %v\n", key.Name(), err)
+				continue
+			}
+			fmt.Fprintln(w, zone)
+		}
+	}
+}
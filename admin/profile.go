@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"time"
+
+	"github.com/gopistolet/gopistolet/log"
+)
+
+const (
+	// defaultProfileSeconds is the duration of the CPU profile if none is given
+	defaultProfileSeconds = 30
+	// maxProfileSeconds limits the duration of the CPU profile
+	maxProfileSeconds = 600
+	// mutexProfileFraction is the fraction of mutex contention events which is reported while profiling
+	mutexProfileFraction = 5
+)
+
+// profileBundle captures a CPU profile for the requested number of seconds
+// (while sampling mutex contention), followed by heap, goroutine and mutex profiles,
+// and sends them as one zip file.
+func profileBundle(w http.ResponseWriter, r *http.Request) {
+	seconds := defaultProfileSeconds
+	if s := r.FormValue("seconds"); s != "" {
+		var err error
+		seconds, err = strconv.Atoi(s)
+		if err != nil || seconds <= 0 || seconds > maxProfileSeconds {
+			http.Error(w, fmt.Sprintf("seconds must be between 1 and %d", maxProfileSeconds), http.StatusBadRequest)
+			return
+		}
+	}
+
+	bundle, err := captureProfiles(r, time.Duration(seconds)*time.Second)
+	if err != nil {
+		log.Errorln("Couldn't capture profiles:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := "gopistolet-profile-" + time.Now().Format("20060102-150405") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Write(bundle)
+}
+
+// captureProfiles returns a zip file with the profiles
+func captureProfiles(r *http.Request, duration time.Duration) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	archive := zip.NewWriter(buffer)
+
+	previousFraction := runtime.SetMutexProfileFraction(mutexProfileFraction)
+	defer runtime.SetMutexProfileFraction(previousFraction)
+
+	cpu, err := archive.Create("cpu.pprof")
+	if err != nil {
+		return nil, err
+	}
+	// fails if another CPU profile is running
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		return nil, err
+	}
+	select {
+	case <-time.After(duration):
+	case <-r.Context().Done():
+	}
+	pprof.StopCPUProfile()
+
+	for _, name := range []string{"heap", "goroutine", "mutex"} {
+		profile, err := archive.Create(name + ".pprof")
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.Lookup(name).WriteTo(profile, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	// stack traces of all goroutines in a readable form
+	goroutines, err := archive.Create("goroutines.txt")
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.Lookup("goroutine").WriteTo(goroutines, 2); err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
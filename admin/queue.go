@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/handlers/queue"
+	"github.com/gopistolet/gopistolet/helpers"
+)
+
+// latestSnapshot returns the latest queue snapshot, or writes an error if there is none
+func (s *Server) latestSnapshot(w http.ResponseWriter) *queue.Snapshot {
+	var snapshot *queue.Snapshot
+	if s.Queue != nil {
+		snapshot = s.Queue.Latest()
+	}
+	if snapshot == nil {
+		http.Error(w, "no queue snapshot available", http.StatusServiceUnavailable)
+	}
+	return snapshot
+}
+
+// queueSnapshot sends the latest queue snapshot as JSON
+func (s *Server) queueSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.latestSnapshot(w)
+	if snapshot == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.FormValue("download") != "" {
+		filename := "gopistolet-queue-" + snapshot.Time.Format("20060102-150405") + ".json"
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(snapshot)
+}
+
+// metrics sends the latest queue snapshot and the counts of the events in the Prometheus text format
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.latestSnapshot(w)
+	if snapshot == nil {
+		return
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintln(b, "# HELP gopistolet_queue_messages Messages in the queue.")
+	fmt.Fprintln(b, "# TYPE gopistolet_queue_messages gauge")
+	fmt.Fprintf(b, "gopistolet_queue_messages %d\n", snapshot.Messages)
+
+	fmt.Fprintln(b, "# HELP gopistolet_queue_class_messages Messages in the queue per priority class.")
+	fmt.Fprintln(b, "# TYPE gopistolet_queue_class_messages gauge")
+	for _, class := range helpers.PriorityClasses {
+		fmt.Fprintf(b, "gopistolet_queue_class_messages{class=%q} %d\n", class, snapshot.Classes[class])
+	}
+
+	fmt.Fprintln(b, "# HELP gopistolet_queue_recipients Pending recipients in the queue per domain.")
+	fmt.Fprintln(b, "# TYPE gopistolet_queue_recipients gauge")
+	domains := make([]string, 0, len(snapshot.Domains))
+	for domain := range snapshot.Domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		fmt.Fprintf(b, "gopistolet_queue_recipients{domain=%q} %d\n", domain, snapshot.Domains[domain])
+	}
+
+	// cumulative, like a Prometheus histogram
+	fmt.Fprintln(b, "# HELP gopistolet_queue_age_seconds Age of the messages in the queue.")
+	fmt.Fprintln(b, "# TYPE gopistolet_queue_age_seconds histogram")
+	count := 0
+	for i, bucket := range queue.AgeBuckets {
+		count += snapshot.Ages[i]
+		fmt.Fprintf(b, "gopistolet_queue_age_seconds_bucket{le=\"%g\"} %d\n", bucket.Seconds(), count)
+	}
+	fmt.Fprintf(b, "gopistolet_queue_age_seconds_bucket{le=\"+Inf\"} %d\n", snapshot.Messages)
+	fmt.Fprintf(b, "gopistolet_queue_age_seconds_sum %g\n", snapshot.AgeSum)
+	fmt.Fprintf(b, "gopistolet_queue_age_seconds_count %d\n", snapshot.Messages)
+
+	fmt.Fprintln(b, "# HELP gopistolet_queue_oldest_seconds Age of the oldest message in the queue.")
+	fmt.Fprintln(b, "# TYPE gopistolet_queue_oldest_seconds gauge")
+	fmt.Fprintf(b, "gopistolet_queue_oldest_seconds %g\n", snapshot.Oldest)
+
+	fmt.Fprintln(b, "# HELP gopistolet_queue_snapshot_timestamp_seconds Time of the queue snapshot.")
+	fmt.Fprintln(b, "# TYPE gopistolet_queue_snapshot_timestamp_seconds gauge")
+	fmt.Fprintf(b, "gopistolet_queue_snapshot_timestamp_seconds %d\n", snapshot.Time.Unix())
+
+	fmt.Fprintln(b, "# HELP gopistolet_events_total Published events per type.")
+	fmt.Fprintln(b, "# TYPE gopistolet_events_total counter")
+	counts := s.counts.All()
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(b, "gopistolet_events_total{event=%q} %d\n", name, counts[name])
+	}
+
+	if s.Panics != nil {
+		fmt.Fprintln(b, "# HELP gopistolet_panics_total Recovered panics per part of the server.")
+		fmt.Fprintln(b, "# TYPE gopistolet_panics_total counter")
+		panics := s.Panics()
+		parts := make([]string, 0, len(panics))
+		for part := range panics {
+			parts = append(parts, part)
+		}
+		sort.Strings(parts)
+		for _, part := range parts {
+			fmt.Fprintf(b, "gopistolet_panics_total{part=%q} %d\n", part, panics[part])
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,14 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// quota sends the usage and quota of the mailboxes as JSON
+func (s *Server) quota(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(s.config.MailboxUsage.All())
+}
@@ -0,0 +1,15 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// tables sends the outcome of the last reloads of the config and the tables as JSON,
+// a table with an Error wasn't replaced
+func (s *Server) tables(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(s.config.Reload.Status())
+}
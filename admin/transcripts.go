@@ -0,0 +1,45 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// transcripts sends the list of the session transcripts in memory as JSON,
+// or the transcript with the id parameter as text
+func (s *Server) transcripts(w http.ResponseWriter, r *http.Request) {
+	transcripts := &s.config.Transcripts
+	if !transcripts.Enabled {
+		http.Error(w, "Transcripts aren't enabled", http.StatusNotFound)
+		return
+	}
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		transcript, found := transcripts.Get(id)
+		if !found {
+			http.Error(w, "Unknown transcript", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(transcript.String()))
+		return
+	}
+
+	type summary struct {
+		Id        string
+		SessionId string
+		Ip        string
+		Start     time.Time
+		End       time.Time
+		Lines     int
+	}
+	list := []summary{}
+	for _, transcript := range transcripts.All() {
+		list = append(list, summary{transcript.Id, transcript.SessionId, transcript.Ip, transcript.Start, transcript.End, len(transcript.Lines)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(list)
+}
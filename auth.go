@@ -0,0 +1,177 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gopistolet/gopistolet/events"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// Reply codes of AUTH (RFC 4954 section 6): the challenges of the exchange,
+// and the refusal of AUTH in sessions without TLS
+const (
+	authChallenge          smtp.StatusCode = 334
+	authMechanismUnknown   smtp.StatusCode = 504
+	authEncryptionRequired smtp.StatusCode = 538
+)
+
+// The challenges of AUTH LOGIN, "Username:" and "Password:" in base64
+const (
+	loginUsername = "VXNlcm5hbWU6"
+	loginPassword = "UGFzc3dvcmQ6"
+)
+
+// authMechanisms are the AUTH mechanisms the session offers. The credentials are only accepted over TLS:
+// LOGIN checks them in the user store, EXTERNAL takes the user of the client certificate.
+func (p *replyProtocol) authMechanisms() []string {
+	if !p.GetState().Secure {
+		return nil
+	}
+	mechanisms := []string{}
+	if tlsState, ok := p.session.ConnectionState(); ok {
+		if _, found := p.config.ClientCerts.User(tlsState); found {
+			mechanisms = append(mechanisms, "EXTERNAL")
+		}
+	}
+	if p.config.Users.Store != nil {
+		mechanisms = append(mechanisms, "LOGIN")
+	}
+	return mechanisms
+}
+
+// handleAuth runs an AUTH exchange (RFC 4954). The user which authenticated is kept in the Logins of the config
+// for the rest of the session, so the handlers let it relay.
+// The failures are counted in the AuthLockout of the config, locked out IPs and users get a temporary failure.
+// The responses of the client are never logged, only the user it claimed.
+func (p *replyProtocol) handleAuth(command helpers.Command) {
+	state := p.GetState()
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	fields := strings.Fields(command.Args)
+	mechanism := ""
+	if len(fields) > 0 {
+		mechanism = strings.ToUpper(fields[0])
+	}
+	offered := false
+	for _, m := range p.authMechanisms() {
+		offered = offered || m == mechanism
+	}
+	switch {
+	case !state.Secure:
+		p.reply(smtp.Answer{Status: authEncryptionRequired, Message: "5.7.11 " + p.text("smtp.tls_required")})
+		return
+	case p.login != "":
+		p.reply(smtp.Answer{Status: smtp.BadSequence, Message: "5.5.1 " + p.text("smtp.auth_again")})
+		return
+	case state.From != nil:
+		p.reply(smtp.Answer{Status: smtp.BadSequence, Message: "5.5.1 " + p.text("smtp.auth_transaction")})
+		return
+	case len(fields) == 0 || len(fields) > 2:
+		p.reply(smtp.Answer{Status: smtp.SyntaxErrorParam, Message: "5.5.4 " + p.text("smtp.auth_syntax")})
+		return
+	case !offered:
+		p.reply(smtp.Answer{Status: authMechanismUnknown, Message: "5.5.4 " + p.text("smtp.auth_mechanism")})
+		return
+	}
+
+	// the initial response replaces the first challenge, "=" is an empty one (RFC 4954 section 4)
+	initial := ""
+	if len(fields) == 2 {
+		initial = fields[1]
+	}
+	var user string
+	var err error
+	switch {
+	case p.config.AuthLockout.Locked(state.Ip.String(), ""):
+		err = helpers.ErrAuthLocked
+	case mechanism == "LOGIN":
+		user, err = p.authLogin(initial)
+	case mechanism == "EXTERNAL":
+		user, err = p.authExternal(initial)
+	}
+
+	switch err {
+	case nil:
+		logger.Infof("AUTH: %s authenticated with %s", user, mechanism)
+		p.config.AuthLockout.Succeeded(state.Ip.String(), user)
+		// the client keeps its connections during a connection flood
+		p.config.Reputation.Add(state.Ip.String())
+		p.login = user
+		p.config.Logins.Set(state.SessionId.String(), user)
+		p.config.Events.Publish(events.AuthSucceeded{Ip: state.Ip.String(), Username: user, Mechanism: mechanism})
+	case helpers.ErrAuthFailed:
+		logger.Warnf("AUTH: %s failed for %q", mechanism, user)
+		p.config.AuthLockout.Failed(state.Ip.String(), user)
+		p.config.Events.Publish(events.AuthFailed{Ip: state.Ip.String(), Username: user, Mechanism: mechanism})
+	default:
+		logger.Warnf("AUTH: %s failed for %q: %v", mechanism, user, err)
+	}
+	code, text := helpers.AuthReply(err)
+	p.reply(smtp.Answer{Status: smtp.StatusCode(code), Message: text})
+}
+
+// authResponse sends a challenge of the AUTH exchange and returns the line the client sent in response
+func (p *replyProtocol) authResponse(challenge string) (string, error) {
+	p.Protocol.Send(smtp.Answer{Status: authChallenge, Message: challenge})
+	if _, err := p.Protocol.GetCmd(); err != nil {
+		return "", err
+	}
+	return p.session.NextCommand().Line, nil
+}
+
+// authLogin runs AUTH LOGIN, the credentials are checked in the user store unless the user is locked out
+func (p *replyProtocol) authLogin(initial string) (string, error) {
+	usernameLine := initial
+	if usernameLine == "" {
+		line, err := p.authResponse(loginUsername)
+		if err != nil {
+			return "", err
+		}
+		usernameLine = line
+	}
+	// the client may cancel before it sends the password
+	if _, err := helpers.DecodeAuthResponse(usernameLine); err != nil {
+		return "", err
+	}
+	passwordLine, err := p.authResponse(loginPassword)
+	if err != nil {
+		return "", err
+	}
+
+	var storeErr error
+	user, err := helpers.AuthLogin(usernameLine, passwordLine, func(username, password string) bool {
+		if p.config.AuthLockout.Locked(p.GetState().Ip.String(), username) {
+			storeErr = helpers.ErrAuthLocked
+			return false
+		}
+		ok, err := p.config.Users.Authenticate(username, password)
+		storeErr = err
+		return ok
+	})
+	if storeErr != nil {
+		return user, storeErr
+	}
+	return user, err
+}
+
+// authExternal runs AUTH EXTERNAL with the client certificate of the TLS session, unless its user is locked out
+func (p *replyProtocol) authExternal(initial string) (string, error) {
+	line := initial
+	if line == "" {
+		response, err := p.authResponse("")
+		if err != nil {
+			return "", err
+		}
+		line = response
+	}
+	tlsState, _ := p.session.ConnectionState()
+	if user, _ := p.config.ClientCerts.User(tlsState); p.config.AuthLockout.Locked(p.GetState().Ip.String(), user) {
+		return user, helpers.ErrAuthLocked
+	}
+	return p.config.ClientCerts.AuthExternal(line, tlsState)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gopistolet/gopistolet/bench"
+)
+
+// runBench runs the bench subcommand: gopistolet bench [flags] host:port
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	sessions := flags.Int("sessions", 10, "number of concurrent sessions")
+	messages := flags.Int("messages", 1000, "total number of messages")
+	perSession := flags.Int("per-session", 1, "messages per session")
+	sizes := flags.String("sizes", "10k", "comma-separated message sizes (e.g. 1k,100k,1m), the messages cycle through them")
+	from := flags.String("from", "bench@localhost", "envelope sender")
+	to := flags.String("to", "bench@localhost", "envelope recipient")
+	helo := flags.String("helo", "localhost", "name to greet the server with")
+	startTls := flags.Bool("starttls", false, "use STARTTLS if the server supports it (the certificate isn't verified)")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of the connection and of every command")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: gopistolet bench [flags] host:port")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	messageSizes, err := bench.ParseSizes(*sizes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	result, err := bench.Run(bench.Options{
+		Target:     flags.Arg(0),
+		Sessions:   *sessions,
+		Messages:   *messages,
+		PerSession: *perSession,
+		Sizes:      messageSizes,
+		From:       *from,
+		To:         *to,
+		Helo:       *helo,
+		StartTLS:   *startTls,
+		Timeout:    *timeout,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Print(result)
+	if result.Messages == 0 {
+		return 1
+	}
+	return 0
+}
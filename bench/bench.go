@@ -0,0 +1,257 @@
+// Package bench generates load on an SMTP server and measures its throughput and latency,
+// to validate deployments and find regressions in the server
+package bench
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopistolet/gopistolet/client"
+)
+
+// Options of a benchmark
+type Options struct {
+	// Target server (host:port)
+	Target string
+	// Sessions is the number of concurrent sessions (default 10)
+	Sessions int
+	// Messages is the total number of messages (default 1000)
+	Messages int
+	// PerSession is the number of messages which are sent in a session before it's ended (default 1)
+	PerSession int
+	// Sizes of the messages in bytes, the messages cycle through them (default 10240)
+	Sizes []int
+	// Envelope of the messages, and the name with which the client greets
+	From, To, Helo string
+	// StartTLS uses STARTTLS if the server supports it (the certificate isn't verified)
+	StartTLS bool
+	// Timeout of the connection and of every command (default 30 seconds)
+	Timeout time.Duration
+}
+
+// Result of a benchmark
+type Result struct {
+	Messages int
+	// Failed is the number of messages which couldn't be sent, Errors the distinct errors with their count
+	Failed   int
+	Errors   map[string]int
+	Bytes    int64
+	Duration time.Duration
+	// Latencies of the messages which were sent (from MAIL FROM up to the reply to the data), sorted
+	Latencies []time.Duration
+}
+
+// Throughput returns the sent messages and megabytes per second
+func (r *Result) Throughput() (messages, megabytes float64) {
+	seconds := r.Duration.Seconds()
+	if seconds <= 0 {
+		return 0, 0
+	}
+	return float64(r.Messages) / seconds, float64(r.Bytes) / 1e6 / seconds
+}
+
+// Percentile returns the latency below which p percent of the messages were sent
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(p/100*float64(len(r.Latencies))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(r.Latencies) {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+func (r *Result) String() string {
+	messages, megabytes := r.Throughput()
+	s := fmt.Sprintf("%d messages sent, %d failed in %v\n", r.Messages, r.Failed, r.Duration.Round(time.Millisecond))
+	s += fmt.Sprintf("throughput: %.1f messages/s, %.2f MB/s\n", messages, megabytes)
+	if len(r.Latencies) > 0 {
+		s += fmt.Sprintf("latency: p50 %v, p90 %v, p99 %v, max %v\n",
+			r.Percentile(50).Round(time.Microsecond), r.Percentile(90).Round(time.Microsecond),
+			r.Percentile(99).Round(time.Microsecond), r.Latencies[len(r.Latencies)-1].Round(time.Microsecond))
+	}
+	errs := make([]string, 0, len(r.Errors))
+	for err := range r.Errors {
+		errs = append(errs, err)
+	}
+	sort.Strings(errs)
+	for _, err := range errs {
+		s += fmt.Sprintf("error (%dx): %s\n", r.Errors[err], err)
+	}
+	return s
+}
+
+// ParseSizes parses a comma-separated list of sizes, with an optional k or m suffix (e.g. 1k,100k,1m)
+func ParseSizes(list string) ([]int, error) {
+	sizes := []int{}
+	for _, field := range strings.Split(list, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		multiplier := 1
+		switch {
+		case strings.HasSuffix(field, "k"):
+			multiplier, field = 1024, strings.TrimSuffix(field, "k")
+		case strings.HasSuffix(field, "m"):
+			multiplier, field = 1024*1024, strings.TrimSuffix(field, "m")
+		}
+		size, err := strconv.Atoi(field)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid size %q", field)
+		}
+		sizes = append(sizes, size*multiplier)
+	}
+	return sizes, nil
+}
+
+// Message returns a message of about size bytes
+func Message(from, to string, size int) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: <%s>\r\nTo: <%s>\r\nSubject: GoPistolet benchmark\r\nDate: %s\r\n\r\n", from, to, time.Now().Format(time.RFC1123Z))
+	line := strings.Repeat("x", 76) + "\r\n"
+	for b.Len()+len(line) <= size {
+		b.WriteString(line)
+	}
+	if rest := size - b.Len() - 2; rest > 0 {
+		b.WriteString(strings.Repeat("x", rest) + "\r\n")
+	}
+	return b.Bytes()
+}
+
+func (o *Options) defaults() error {
+	if o.Target == "" {
+		return errors.New("no target")
+	}
+	if o.Sessions <= 0 {
+		o.Sessions = 10
+	}
+	if o.Messages <= 0 {
+		o.Messages = 1000
+	}
+	if o.PerSession <= 0 {
+		o.PerSession = 1
+	}
+	if len(o.Sizes) == 0 {
+		o.Sizes = []int{10240}
+	}
+	if o.From == "" {
+		o.From = "bench@localhost"
+	}
+	if o.To == "" {
+		o.To = "bench@localhost"
+	}
+	if o.Helo == "" {
+		o.Helo = "localhost"
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 30 * time.Second
+	}
+	return nil
+}
+
+// Run sends the messages over the concurrent sessions and measures the latencies
+func Run(o Options) (*Result, error) {
+	if err := o.defaults(); err != nil {
+		return nil, err
+	}
+	messages := make([][]byte, len(o.Sizes))
+	for i, size := range o.Sizes {
+		messages[i] = Message(o.From, o.To, size)
+	}
+
+	result := &Result{Errors: make(map[string]int)}
+	var mutex sync.Mutex
+	failed := func(err error, count int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		result.Failed += count
+		result.Errors[err.Error()]++
+	}
+
+	var next int64 = -1
+	wg := sync.WaitGroup{}
+	start := time.Now()
+	for i := 0; i < o.Sessions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				// claim the messages of the next session
+				first := int(atomic.AddInt64(&next, int64(o.PerSession))) - o.PerSession + 1
+				if first >= o.Messages {
+					return
+				}
+				count := o.PerSession
+				if first+count > o.Messages {
+					count = o.Messages - first
+				}
+
+				c, err := o.session()
+				if err != nil {
+					failed(err, count)
+					continue
+				}
+				for n := first; n < first+count; n++ {
+					data := messages[n%len(messages)]
+					sent := time.Now()
+					if err := o.send(c, data); err != nil {
+						failed(err, first+count-n)
+						break
+					}
+					latency := time.Since(sent)
+					mutex.Lock()
+					result.Messages++
+					result.Bytes += int64(len(data))
+					result.Latencies = append(result.Latencies, latency)
+					mutex.Unlock()
+				}
+				c.Quit()
+			}
+		}()
+	}
+	wg.Wait()
+	result.Duration = time.Since(start)
+	sort.Slice(result.Latencies, func(i, j int) bool { return result.Latencies[i] < result.Latencies[j] })
+	return result, nil
+}
+
+// session connects to the target and greets it
+func (o *Options) session() (*client.Client, error) {
+	c, err := client.Dial(o.Target, o.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.Timeout = o.Timeout
+	if err := c.Hello(o.Helo); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if o.StartTLS && c.Capabilities != nil && c.Capabilities.StartTLS {
+		if err := c.StartTLS(&tls.Config{InsecureSkipVerify: true}); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// send sends one message in the session
+func (o *Options) send(c *client.Client, data []byte) error {
+	if err := c.Mail(o.From, int64(len(data))); err != nil {
+		return err
+	}
+	if err := c.Rcpt(o.To); err != nil {
+		return err
+	}
+	return c.Data(data)
+}
@@ -0,0 +1,89 @@
+package bench
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gopistolet/smtp/mta"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// serve runs the MTA on a local port, the handler gets the accepted messages
+func serve(handler mta.Handler) (string, func()) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := mta.New(mta.Config{Hostname: "mx.example.com"}, handler)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go server.HandleClient(smtp.NewMtaProtocol(conn))
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func TestBench(t *testing.T) {
+
+	Convey("Testing ParseSizes", t, func() {
+		sizes, err := ParseSizes("100, 1k,2M")
+		So(err, ShouldBeNil)
+		So(sizes, ShouldResemble, []int{100, 1024, 2 * 1024 * 1024})
+
+		_, err = ParseSizes("1k,,2k")
+		So(err, ShouldNotBeNil)
+		_, err = ParseSizes("-1")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Testing Message", t, func() {
+		for _, size := range []int{200, 1000, 10240} {
+			message := Message("a@example.org", "b@example.com", size)
+			So(len(message), ShouldBeBetweenOrEqual, size-2, size)
+			So(string(message), ShouldEndWith, "\r\n")
+		}
+	})
+
+	Convey("Testing Run", t, func() {
+		var mutex sync.Mutex
+		received := 0
+		sizes := map[int]bool{}
+		addr, stop := serve(mta.HandlerFunc(func(state *smtp.State) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			received++
+			sizes[len(state.Data)/1000] = true
+		}))
+		defer stop()
+
+		result, err := Run(Options{Target: addr, Sessions: 4, Messages: 25, PerSession: 3, Sizes: []int{1000, 5000}})
+		So(err, ShouldBeNil)
+		So(result.Failed, ShouldEqual, 0)
+		So(result.Messages, ShouldEqual, 25)
+		So(len(result.Latencies), ShouldEqual, 25)
+		So(result.Percentile(50), ShouldBeLessThanOrEqualTo, result.Percentile(99))
+		So(result.Percentile(100), ShouldEqual, result.Latencies[24])
+		So(result.String(), ShouldContainSubstring, "25 messages sent, 0 failed")
+		mutex.Lock()
+		So(received, ShouldEqual, 25)
+		So(len(sizes), ShouldEqual, 2)
+		mutex.Unlock()
+
+		// nothing listens on the target
+		stop()
+		result, err = Run(Options{Target: addr, Sessions: 2, Messages: 4})
+		So(err, ShouldBeNil)
+		So(result.Messages, ShouldEqual, 0)
+		So(result.Failed, ShouldEqual, 4)
+		So(strings.Contains(result.String(), "error (4x)"), ShouldBeTrue)
+
+		_, err = Run(Options{})
+		So(err, ShouldNotBeNil)
+	})
+
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker pauses delivery attempts to hosts which keep failing.
+// After Threshold consecutive failures (connection refused, 4xx replies, ...) the circuit
+// of the host opens for CoolDown seconds. After the cool-down one attempt is let through:
+// if it succeeds the circuit closes, if it fails the circuit opens again.
+type CircuitBreaker struct {
+	Threshold int
+	CoolDown  int
+
+	// now is time.Now, it can be replaced for testing
+	now func() time.Time
+
+	mutex sync.Mutex
+	hosts map[string]*circuit
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+func (b *CircuitBreaker) time() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+func breakerKey(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// Allow reports whether a delivery attempt to the host may be made.
+// When the cool-down of an open circuit has passed, only the first caller is allowed (to probe the host),
+// the next probe is allowed after another cool-down if the result of this one isn't reported.
+func (b *CircuitBreaker) Allow(host string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c, found := b.hosts[breakerKey(host)]
+	if !found || c.failures < b.threshold() {
+		return true
+	}
+	now := b.time()
+	if now.Before(c.openUntil) {
+		return false
+	}
+	c.openUntil = now.Add(b.coolDown())
+	return true
+}
+
+// Succeeded closes the circuit of the host
+func (b *CircuitBreaker) Succeeded(host string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.hosts, breakerKey(host))
+}
+
+// Failed registers a failed attempt, the circuit opens if the host failed Threshold times in a row
+func (b *CircuitBreaker) Failed(host string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.hosts == nil {
+		b.hosts = make(map[string]*circuit)
+	}
+	key := breakerKey(host)
+	c, found := b.hosts[key]
+	if !found {
+		c = &circuit{}
+		b.hosts[key] = c
+	}
+	c.failures++
+	if c.failures >= b.threshold() {
+		c.openUntil = b.time().Add(b.coolDown())
+	}
+}
+
+func (b *CircuitBreaker) coolDown() time.Duration {
+	if b.CoolDown <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(b.CoolDown) * time.Second
+}
+
+func (b *CircuitBreaker) threshold() int {
+	if b.Threshold <= 0 {
+		return 5
+	}
+	return b.Threshold
+}
+
+// Available returns the hosts (e.g. the MX hosts of a domain, in order of preference)
+// to which delivery may be attempted, so mail goes to the other MX hosts while one is down.
+func (b *CircuitBreaker) Available(hosts []string) []string {
+	available := []string{}
+	for _, host := range hosts {
+		if b.Allow(host) {
+			available = append(available, host)
+		}
+	}
+	return available
+}
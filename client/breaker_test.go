@@ -0,0 +1,45 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+
+	Convey("Testing CircuitBreaker", t, func() {
+		now := time.Date(2016, 10, 5, 14, 0, 0, 0, time.UTC)
+		b := CircuitBreaker{
+			Threshold: 2,
+			CoolDown:  60,
+			now:       func() time.Time { return now },
+		}
+
+		So(b.Allow("mx1.example.com"), ShouldEqual, true)
+		b.Failed("mx1.example.com")
+		So(b.Allow("mx1.example.com"), ShouldEqual, true)
+		b.Failed("MX1.example.com.")
+		So(b.Allow("mx1.example.com"), ShouldEqual, false)
+
+		// other MX hosts are still available
+		So(b.Available([]string{"mx1.example.com", "mx2.example.com"}), ShouldResemble, []string{"mx2.example.com"})
+
+		// after the cool-down, one attempt probes the host
+		now = now.Add(61 * time.Second)
+		So(b.Allow("mx1.example.com"), ShouldEqual, true)
+		So(b.Allow("mx1.example.com"), ShouldEqual, false)
+
+		// failed probe opens the circuit again
+		b.Failed("mx1.example.com")
+		So(b.Allow("mx1.example.com"), ShouldEqual, false)
+
+		now = now.Add(61 * time.Second)
+		So(b.Allow("mx1.example.com"), ShouldEqual, true)
+		b.Succeeded("mx1.example.com")
+		So(b.Allow("mx1.example.com"), ShouldEqual, true)
+		So(b.Allow("mx1.example.com"), ShouldEqual, true)
+	})
+
+}
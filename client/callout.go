@@ -0,0 +1,69 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// calloutTimeout is the timeout of the commands of a callout
+const calloutTimeout = 30 * time.Second
+
+// Verify checks with a callout whether the MX hosts of the domain accept mail for the address:
+// a transaction with the null sender is started and reset after RCPT, no message is sent.
+// It returns a *textproto.Error with a 5xx code if the address is refused,
+// other errors (including a refused null sender) mean the address couldn't be verified.
+func Verify(helo, address string) error {
+	i := strings.LastIndexByte(address, '@')
+	if i < 0 {
+		return fmt.Errorf("invalid address %s", address)
+	}
+	hosts, err := lookupMx(address[i+1:])
+	if err != nil {
+		return err
+	}
+
+	for _, host := range hosts {
+		err = verify(net.JoinHostPort(host, "25"), helo, address)
+		if protoErr, ok := err.(*textproto.Error); err == nil || (ok && protoErr.Code >= 500) {
+			return err
+		}
+	}
+	return err
+}
+
+// verify asks the server at addr (host:port) whether it accepts mail for the address
+func verify(addr, helo, address string) error {
+	c, err := Dial(addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	c.Timeout = calloutTimeout
+
+	if err := c.Hello(helo); err != nil {
+		return temporary(err)
+	}
+	if c.Capabilities == nil || !c.Capabilities.SMTPUTF8 {
+		if address, err = asciiAddress(address); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail("", 0); err != nil {
+		return temporary(err)
+	}
+	err = c.Rcpt(address)
+	c.Reset()
+	c.Quit()
+	return err
+}
+
+// temporary turns a permanent failure of the session (not of the address) into a plain error
+func temporary(err error) error {
+	if protoErr, ok := err.(*textproto.Error); ok {
+		return fmt.Errorf("callout failed: %v", protoErr)
+	}
+	return err
+}
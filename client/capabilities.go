@@ -0,0 +1,127 @@
+// Package client contains an SMTP client, which GoPistolet uses to deliver mail to other servers
+// and which applications that embed GoPistolet can use to send mail
+package client
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Capabilities of a server, as advertised in its EHLO reply (RFC 5321 section 4.1.1.1)
+type Capabilities struct {
+	// Domain the server greeted with
+	Domain string
+
+	// Size is the maximum message size (SIZE extension, RFC 1870),
+	// 0 means the server didn't advertise a limit
+	Size int64
+	// Auth lists the supported AUTH mechanisms (RFC 4954) in upper case
+	Auth []string
+
+	StartTLS            bool
+	EightBitMIME        bool
+	SMTPUTF8            bool
+	Pipelining          bool
+	Chunking            bool
+	BinaryMIME          bool
+	DSN                 bool
+	EnhancedStatusCodes bool
+
+	// Extensions contains all advertised keywords (in upper case) with their parameters
+	Extensions map[string][]string
+}
+
+// ParseCapabilities parses the text lines of an EHLO reply (without the reply code).
+// The first line is the greeting, every other line is 'ehlo-keyword *( SP ehlo-param )'.
+func ParseCapabilities(lines []string) *Capabilities {
+	c := &Capabilities{
+		Extensions: make(map[string][]string),
+	}
+	if len(lines) == 0 {
+		return c
+	}
+
+	if greeting := strings.Fields(lines[0]); len(greeting) > 0 {
+		c.Domain = greeting[0]
+	}
+
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		keyword := strings.ToUpper(fields[0])
+		params := fields[1:]
+
+		// Some old servers advertise 'AUTH=LOGIN PLAIN'
+		if strings.HasPrefix(keyword, "AUTH=") {
+			params = append([]string{keyword[len("AUTH="):]}, params...)
+			keyword = "AUTH"
+		}
+
+		c.Extensions[keyword] = append(c.Extensions[keyword], params...)
+
+		switch keyword {
+		case "SIZE":
+			if len(params) > 0 {
+				size, err := strconv.ParseInt(params[0], 10, 64)
+				if err == nil && size > 0 {
+					c.Size = size
+				}
+			}
+		case "AUTH":
+			for _, mechanism := range params {
+				c.Auth = appendUnique(c.Auth, strings.ToUpper(mechanism))
+			}
+		case "STARTTLS":
+			c.StartTLS = true
+		case "8BITMIME":
+			c.EightBitMIME = true
+		case "SMTPUTF8":
+			c.SMTPUTF8 = true
+		case "PIPELINING":
+			c.Pipelining = true
+		case "CHUNKING":
+			c.Chunking = true
+		case "BINARYMIME":
+			c.BinaryMIME = true
+		case "DSN":
+			c.DSN = true
+		case "ENHANCEDSTATUSCODES":
+			c.EnhancedStatusCodes = true
+		}
+	}
+
+	return c
+}
+
+// Has reports whether the server advertised the extension
+func (c *Capabilities) Has(extension string) bool {
+	_, found := c.Extensions[strings.ToUpper(extension)]
+	return found
+}
+
+// SupportsAuth reports whether the server supports the AUTH mechanism
+func (c *Capabilities) SupportsAuth(mechanism string) bool {
+	mechanism = strings.ToUpper(mechanism)
+	for _, m := range c.Auth {
+		if m == mechanism {
+			return true
+		}
+	}
+	return false
+}
+
+// Fits reports whether a message of the given size is within the server's SIZE limit
+func (c *Capabilities) Fits(size int64) bool {
+	return c.Size == 0 || size <= c.Size
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, e := range list {
+		if e == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
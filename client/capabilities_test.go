@@ -0,0 +1,54 @@
+package client
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseCapabilities(t *testing.T) {
+
+	Convey("Testing ParseCapabilities()", t, func() {
+		c := ParseCapabilities([]string{
+			"mx.example.com at your service",
+			"SIZE 35882577",
+			"8BITMIME",
+			"STARTTLS",
+			"AUTH LOGIN plain XOAUTH2",
+			"AUTH=LOGIN PLAIN",
+			"ENHANCEDSTATUSCODES",
+			"PIPELINING",
+			"chunking",
+			"SMTPUTF8",
+			"X-CUSTOM foo bar",
+		})
+
+		So(c.Domain, ShouldEqual, "mx.example.com")
+		So(c.Size, ShouldEqual, 35882577)
+		So(c.Fits(1000), ShouldEqual, true)
+		So(c.Fits(35882578), ShouldEqual, false)
+		So(c.Auth, ShouldResemble, []string{"LOGIN", "PLAIN", "XOAUTH2"})
+		So(c.SupportsAuth("plain"), ShouldEqual, true)
+		So(c.SupportsAuth("CRAM-MD5"), ShouldEqual, false)
+		So(c.StartTLS, ShouldEqual, true)
+		So(c.EightBitMIME, ShouldEqual, true)
+		So(c.EnhancedStatusCodes, ShouldEqual, true)
+		So(c.Pipelining, ShouldEqual, true)
+		So(c.Chunking, ShouldEqual, true)
+		So(c.SMTPUTF8, ShouldEqual, true)
+		So(c.DSN, ShouldEqual, false)
+		So(c.Has("x-custom"), ShouldEqual, true)
+		So(c.Extensions["X-CUSTOM"], ShouldResemble, []string{"foo", "bar"})
+	})
+
+	Convey("Testing ParseCapabilities() without SIZE limit", t, func() {
+		c := ParseCapabilities([]string{"mx.example.com", "SIZE"})
+		So(c.Has("SIZE"), ShouldEqual, true)
+		So(c.Size, ShouldEqual, 0)
+		So(c.Fits(1<<40), ShouldEqual, true)
+
+		c = ParseCapabilities(nil)
+		So(c.Domain, ShouldEqual, "")
+	})
+
+}
@@ -0,0 +1,256 @@
+package client
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the timeout of a command and its reply if the Client has no Timeout,
+// RFC 5321 section 4.5.3.2 recommends at least 5 minutes for most commands
+const DefaultTimeout = 5 * time.Minute
+
+// enhancedCode matches the enhanced status code at the start of a reply text (RFC 3463)
+var enhancedCode = regexp.MustCompile(`^([245])\.(\d{1,3})\.(\d{1,3})(\s|$)`)
+
+// Client is an SMTP client session with a server. Send and Verify use it,
+// and applications which embed GoPistolet can use it to send mail themselves.
+//
+// The server's replies with an unexpected code are returned as *textproto.Error,
+// EnhancedCode returns their enhanced status code. A message is dot-stuffed when it's sent,
+// and its size is checked against the SIZE the server advertised.
+type Client struct {
+	// Timeout of every command and its reply, and of sending the message (DefaultTimeout if it's 0)
+	Timeout time.Duration
+
+	// Capabilities of the server from its EHLO reply, nil if it only supports HELO
+	Capabilities *Capabilities
+
+	conn      net.Conn
+	text      *textproto.Conn
+	host      string
+	localName string
+	tls       bool
+}
+
+// Dial connects to the server at addr (host:port) and reads its greeting
+func Dial(addr string, timeout time.Duration) (*Client, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient starts a session on an existing connection to the server host, it reads the greeting
+func NewClient(conn net.Conn, host string) (*Client, error) {
+	_, isTls := conn.(*tls.Conn)
+	c := &Client{conn: conn, text: textproto.NewConn(conn), host: host, tls: isTls}
+	c.deadline()
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		c.text.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) deadline() {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	c.conn.SetDeadline(time.Now().Add(timeout))
+}
+
+// cmd sends a command and reads the reply, which must have the expected code (0 accepts any code)
+func (c *Client) cmd(expect int, format string, args ...interface{}) (int, string, error) {
+	c.deadline()
+	id, err := c.text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	c.text.StartResponse(id)
+	defer c.text.EndResponse(id)
+	return c.text.ReadResponse(expect)
+}
+
+// Hello greets the server with EHLO and reads its capabilities,
+// servers which don't support EHLO are greeted with HELO
+func (c *Client) Hello(localName string) error {
+	c.localName = localName
+	_, message, err := c.cmd(250, "EHLO %s", localName)
+	if protoErr, ok := err.(*textproto.Error); ok && protoErr.Code >= 500 {
+		c.Capabilities = nil
+		_, _, err = c.cmd(250, "HELO %s", localName)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	c.Capabilities = ParseCapabilities(strings.Split(message, "\n"))
+	return nil
+}
+
+// StartTLS upgrades the connection with STARTTLS (RFC 3207) and greets the server again,
+// since its capabilities may change
+func (c *Client) StartTLS(config *tls.Config) error {
+	if _, _, err := c.cmd(220, "STARTTLS"); err != nil {
+		return err
+	}
+	c.conn = tls.Client(c.conn, config)
+	c.text = textproto.NewConn(c.conn)
+	c.tls = true
+	return c.Hello(c.localName)
+}
+
+// TLSConnectionState returns the state of the TLS connection, if TLS is used
+func (c *Client) TLSConnectionState() (tls.ConnectionState, bool) {
+	conn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return conn.ConnectionState(), true
+}
+
+// Auth authenticates with the mechanism of a (e.g. smtp.PlainAuth) (RFC 4954),
+// the session is ended if the authentication fails
+func (c *Client) Auth(a smtp.Auth) error {
+	info := &smtp.ServerInfo{Name: c.host, TLS: c.tls}
+	if c.Capabilities != nil {
+		info.Auth = c.Capabilities.Auth
+	}
+	mechanism, response, err := a.Start(info)
+	if err != nil {
+		c.Quit()
+		return err
+	}
+
+	code, message, err := c.cmd(0, strings.TrimSpace(fmt.Sprintf("AUTH %s %s", mechanism, encode(response))))
+	for err == nil {
+		if code == 235 {
+			return nil
+		}
+		if code != 334 {
+			err = &textproto.Error{Code: code, Msg: message}
+			break
+		}
+		var challenge []byte
+		challenge, err = base64.StdEncoding.DecodeString(message)
+		if err == nil {
+			response, err = a.Next(challenge, true)
+		}
+		if err != nil {
+			// cancel the exchange (RFC 4954 section 4)
+			c.cmd(501, "*")
+			break
+		}
+		code, message, err = c.cmd(0, "%s", encode(response))
+	}
+	c.Quit()
+	return err
+}
+
+func encode(response []byte) string {
+	if response == nil {
+		return ""
+	}
+	if len(response) == 0 {
+		// an empty initial response (RFC 4954 section 4)
+		return "="
+	}
+	return base64.StdEncoding.EncodeToString(response)
+}
+
+// Mail starts a transaction with MAIL FROM. The size of the message is announced with SIZE (RFC 1870),
+// a message which is larger than the server accepts fails with 552 5.3.4 before it's sent.
+// BODY=8BITMIME and SMTPUTF8 are added if the server supports them.
+func (c *Client) Mail(from string, size int64) error {
+	command := "MAIL FROM:<" + from + ">"
+	if caps := c.Capabilities; caps != nil {
+		if !caps.Fits(size) {
+			return &textproto.Error{Code: 552, Msg: fmt.Sprintf("5.3.4 Message size %d exceeds the limit of %d of %s", size, caps.Size, c.host)}
+		}
+		if caps.Size > 0 && size > 0 {
+			command += fmt.Sprintf(" SIZE=%d", size)
+		}
+		if caps.EightBitMIME {
+			command += " BODY=8BITMIME"
+		}
+		if caps.SMTPUTF8 {
+			command += " SMTPUTF8"
+		}
+	}
+	_, _, err := c.cmd(250, "%s", command)
+	return err
+}
+
+// Rcpt adds a recipient to the transaction
+func (c *Client) Rcpt(to string) error {
+	_, _, err := c.cmd(25, "RCPT TO:<%s>", to)
+	return err
+}
+
+// Data sends the message, dot-stuffed and with CRLF line endings (RFC 5321 section 4.5.2)
+func (c *Client) Data(data []byte) error {
+	if _, _, err := c.cmd(354, "DATA"); err != nil {
+		return err
+	}
+	c.deadline()
+	w := c.text.DotWriter()
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	_, _, err := c.text.ReadResponse(250)
+	return err
+}
+
+// Reset aborts the transaction with RSET
+func (c *Client) Reset() error {
+	_, _, err := c.cmd(250, "RSET")
+	return err
+}
+
+// Quit ends the session and closes the connection
+func (c *Client) Quit() error {
+	_, _, err := c.cmd(221, "QUIT")
+	c.text.Close()
+	return err
+}
+
+// Close closes the connection without QUIT
+func (c *Client) Close() error {
+	return c.text.Close()
+}
+
+// EnhancedCode returns the enhanced status code (e.g. 5.1.1) of a reply error (RFC 3463),
+// or "" if the server didn't send one
+func EnhancedCode(err error) string {
+	protoErr, ok := err.(*textproto.Error)
+	if !ok {
+		return ""
+	}
+	match := enhancedCode.FindStringSubmatch(protoErr.Msg)
+	if match == nil || int(match[1][0]-'0') != protoErr.Code/100 {
+		return ""
+	}
+	return match[1] + "." + match[2] + "." + match[3]
+}
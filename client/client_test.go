@@ -0,0 +1,165 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// scriptedServer accepts one SMTP session, answers the commands with answer
+// and records the commands and the lines of the message
+func scriptedServer(answer func(line string) string) (string, chan []string) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	session := make(chan []string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		lines := []string{}
+		defer func() { session <- lines }()
+		conn.Write([]byte("220 mx.example.com ESMTP\r\n"))
+		data := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case data && line == ".":
+				data = false
+				conn.Write([]byte("250 queued\r\n"))
+			case data:
+			case line == "DATA":
+				data = true
+				conn.Write([]byte("354 go ahead\r\n"))
+			case line == "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte(answer(line) + "\r\n"))
+			}
+		}
+	}()
+	return l.Addr().String(), session
+}
+
+func TestClient(t *testing.T) {
+
+	Convey("Testing Client", t, func() {
+
+		Convey("Capabilities of the EHLO reply", func() {
+			addr, session := scriptedServer(func(line string) string {
+				if strings.HasPrefix(line, "EHLO") {
+					return "250-mx.example.com\r\n250-SIZE 1000\r\n250 8BITMIME"
+				}
+				return "250 ok"
+			})
+			c, err := Dial(addr, dialTimeout)
+			So(err, ShouldBeNil)
+			So(c.Hello("client.example.com"), ShouldBeNil)
+			So(c.Capabilities, ShouldNotBeNil)
+			So(c.Capabilities.Size, ShouldEqual, 1000)
+
+			So(c.Mail("from@example.com", 100), ShouldBeNil)
+			So(c.Rcpt("to@example.com"), ShouldBeNil)
+			So(c.Data([]byte("Subject: dots\r\n\r\n.hidden\r\n")), ShouldBeNil)
+			So(c.Quit(), ShouldBeNil)
+
+			lines := <-session
+			So(lines, ShouldContain, "MAIL FROM:<from@example.com> SIZE=100 BODY=8BITMIME")
+			So(lines, ShouldContain, "..hidden")
+		})
+
+		Convey("Messages larger than SIZE aren't sent", func() {
+			addr, session := scriptedServer(func(line string) string {
+				if strings.HasPrefix(line, "EHLO") {
+					return "250-mx.example.com\r\n250 SIZE 1000"
+				}
+				return "250 ok"
+			})
+			c, _ := Dial(addr, dialTimeout)
+			c.Hello("client.example.com")
+			err := c.Mail("from@example.com", 2000)
+			So(err, ShouldNotBeNil)
+			So(err.(*textproto.Error).Code, ShouldEqual, 552)
+			So(EnhancedCode(err), ShouldEqual, "5.3.4")
+			c.Quit()
+
+			for _, line := range <-session {
+				So(line, ShouldNotStartWith, "MAIL")
+			}
+		})
+
+		Convey("Servers without EHLO are greeted with HELO", func() {
+			addr, session := scriptedServer(func(line string) string {
+				if strings.HasPrefix(line, "EHLO") {
+					return "502 5.5.1 command not implemented"
+				}
+				return "250 ok"
+			})
+			c, _ := Dial(addr, dialTimeout)
+			So(c.Hello("client.example.com"), ShouldBeNil)
+			So(c.Capabilities, ShouldBeNil)
+			So(c.Mail("from@example.com", 100), ShouldBeNil)
+			c.Quit()
+
+			lines := <-session
+			So(lines, ShouldContain, "HELO client.example.com")
+			So(lines, ShouldContain, "MAIL FROM:<from@example.com>")
+		})
+
+		Convey("Authentication", func() {
+			addr, session := scriptedServer(func(line string) string {
+				switch {
+				case strings.HasPrefix(line, "EHLO"):
+					return "250-mx.example.com\r\n250 AUTH PLAIN LOGIN"
+				case strings.HasPrefix(line, "AUTH PLAIN AHVzZXIAc2VjcmV0"):
+					return "235 2.7.0 Authentication successful"
+				case strings.HasPrefix(line, "AUTH"):
+					return "535 5.7.8 Authentication credentials invalid"
+				}
+				return "250 ok"
+			})
+			c, _ := Dial(addr, dialTimeout)
+			c.Hello("client.example.com")
+			So(c.Auth(smtp.PlainAuth("", "user", "secret", "127.0.0.1")), ShouldBeNil)
+			c.Quit()
+			<-session
+
+			addr, session = scriptedServer(func(line string) string {
+				if strings.HasPrefix(line, "AUTH") {
+					return "535 5.7.8 Authentication credentials invalid"
+				}
+				return "250 ok"
+			})
+			c, _ = Dial(addr, dialTimeout)
+			c.Hello("client.example.com")
+			err := c.Auth(smtp.PlainAuth("", "user", "wrong", "127.0.0.1"))
+			So(EnhancedCode(err), ShouldEqual, "5.7.8")
+			So(<-session, ShouldContain, "QUIT")
+		})
+
+		Convey("Enhanced status codes", func() {
+			So(EnhancedCode(&textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}), ShouldEqual, "5.1.1")
+			So(EnhancedCode(&textproto.Error{Code: 451, Msg: "4.7.1 Try again later"}), ShouldEqual, "4.7.1")
+			// the class has to match the reply code
+			So(EnhancedCode(&textproto.Error{Code: 550, Msg: "4.1.1 User unknown"}), ShouldEqual, "")
+			So(EnhancedCode(&textproto.Error{Code: 550, Msg: "User unknown"}), ShouldEqual, "")
+			So(EnhancedCode(errors.New("5.1.1 not a reply")), ShouldEqual, "")
+		})
+
+	})
+
+}
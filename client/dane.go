@@ -0,0 +1,157 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TLSA certificate usages used for SMTP (RFC 7672 section 3.1.3)
+const (
+	DaneTA = 2
+	DaneEE = 3
+)
+
+// Dane looks up the TLSA records of MX hosts (RFC 6698) and verifies
+// the certificates presented during STARTTLS against them (RFC 7672).
+type Dane struct {
+	// Resolver is the address of a DNSSEC validating resolver,
+	// the first nameserver in /etc/resolv.conf is used if it's empty
+	Resolver string
+	// Timeout for the lookup in seconds
+	Timeout int
+
+	// exchange sends the query, it can be replaced for testing
+	exchange func(m *dns.Msg) (*dns.Msg, error)
+}
+
+// Lookup returns the usable TLSA records of the MX host on port 25.
+// It returns no records if there are none or if they aren't DNSSEC validated,
+// so delivery falls back to opportunistic TLS.
+func (d *Dane) Lookup(host string) ([]*dns.TLSA, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn("_25._tcp."+strings.TrimSuffix(host, ".")), dns.TypeTLSA)
+	m.SetEdns0(4096, true)
+	m.AuthenticatedData = true
+
+	exchange := d.exchange
+	if exchange == nil {
+		exchange = d.exchangeUdp
+	}
+	reply, err := exchange(m)
+	if err != nil {
+		return nil, err
+	}
+
+	switch reply.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+	default:
+		return nil, fmt.Errorf("TLSA lookup for %s failed: %s", host, dns.RcodeToString[reply.Rcode])
+	}
+
+	// Only records which are validated by the resolver can be trusted
+	if !reply.AuthenticatedData {
+		return nil, nil
+	}
+
+	records := []*dns.TLSA{}
+	for _, rr := range reply.Answer {
+		tlsa, ok := rr.(*dns.TLSA)
+		if !ok {
+			continue
+		}
+		if (tlsa.Usage == DaneTA || tlsa.Usage == DaneEE) && tlsa.Selector <= 1 && tlsa.MatchingType <= 2 {
+			records = append(records, tlsa)
+		}
+	}
+	return records, nil
+}
+
+func (d *Dane) exchangeUdp(m *dns.Msg) (*dns.Msg, error) {
+	resolver := d.Resolver
+	if resolver == "" {
+		config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			return nil, err
+		}
+		if len(config.Servers) == 0 {
+			return nil, errors.New("no nameserver in /etc/resolv.conf")
+		}
+		resolver = net.JoinHostPort(config.Servers[0], config.Port)
+	}
+	timeout := time.Duration(d.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	client := &dns.Client{Timeout: timeout}
+	reply, _, err := client.Exchange(m, resolver)
+	if err == nil && reply.Truncated {
+		client.Net = "tcp"
+		reply, _, err = client.Exchange(m, resolver)
+	}
+	return reply, err
+}
+
+// TlsConfig returns the TLS config for STARTTLS to the MX host:
+// with TLSA records, the certificate must match them instead of the usual WebPKI checks.
+func TlsConfig(host string, records []*dns.TLSA) *tls.Config {
+	host = strings.TrimSuffix(host, ".")
+	if len(records) == 0 {
+		return &tls.Config{ServerName: host}
+	}
+	return &tls.Config{
+		ServerName: host,
+		// The certificate is verified against the TLSA records in VerifyConnection
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return VerifyTlsa(host, records, state.PeerCertificates)
+		},
+	}
+}
+
+// VerifyTlsa verifies the certificate chain against the TLSA records (RFC 7672 section 3.1.3):
+// a DANE-EE record must match the leaf certificate (name and expiry aren't checked),
+// a DANE-TA record must match a certificate in the chain which is then the trust anchor
+// for the leaf certificate of the host.
+func VerifyTlsa(host string, records []*dns.TLSA, chain []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return errors.New("DANE: no certificate presented")
+	}
+
+	for _, record := range records {
+		switch record.Usage {
+		case DaneEE:
+			if record.Verify(chain[0]) == nil {
+				return nil
+			}
+		case DaneTA:
+			for i, cert := range chain {
+				if record.Verify(cert) != nil {
+					continue
+				}
+				roots := x509.NewCertPool()
+				roots.AddCert(cert)
+				intermediates := x509.NewCertPool()
+				for _, c := range chain[1:i] {
+					intermediates.AddCert(c)
+				}
+				_, err := chain[0].Verify(x509.VerifyOptions{
+					DNSName:       host,
+					Roots:         roots,
+					Intermediates: intermediates,
+				})
+				if err == nil || (i == 0 && chain[0].VerifyHostname(host) == nil) {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("DANE: certificate of %s doesn't match its TLSA records", host)
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newCertificate creates a certificate for the host, signed by the parent (self-signed if nil)
+func newCertificate(host string, ca bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  ca,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestDane(t *testing.T) {
+
+	ca, caKey := newCertificate("Test CA", true, nil, nil)
+	leaf, _ := newCertificate("mx.example.com", false, ca, caKey)
+	other, _ := newCertificate("mx.example.com", false, nil, nil)
+
+	Convey("Testing VerifyTlsa() with DANE-EE", t, func() {
+		record := &dns.TLSA{}
+		So(record.Sign(DaneEE, 1, 1, leaf), ShouldEqual, nil)
+
+		So(VerifyTlsa("mx.example.com", []*dns.TLSA{record}, []*x509.Certificate{leaf, ca}), ShouldEqual, nil)
+		// the name isn't checked for DANE-EE
+		So(VerifyTlsa("other.example.com", []*dns.TLSA{record}, []*x509.Certificate{leaf}), ShouldEqual, nil)
+		So(VerifyTlsa("mx.example.com", []*dns.TLSA{record}, []*x509.Certificate{other}), ShouldNotEqual, nil)
+		So(VerifyTlsa("mx.example.com", []*dns.TLSA{record}, nil), ShouldNotEqual, nil)
+	})
+
+	Convey("Testing VerifyTlsa() with DANE-TA", t, func() {
+		record := &dns.TLSA{}
+		So(record.Sign(DaneTA, 0, 1, ca), ShouldEqual, nil)
+
+		So(VerifyTlsa("mx.example.com", []*dns.TLSA{record}, []*x509.Certificate{leaf, ca}), ShouldEqual, nil)
+		So(VerifyTlsa("other.example.com", []*dns.TLSA{record}, []*x509.Certificate{leaf, ca}), ShouldNotEqual, nil)
+		So(VerifyTlsa("mx.example.com", []*dns.TLSA{record}, []*x509.Certificate{other, ca}), ShouldNotEqual, nil)
+	})
+
+	Convey("Testing Dane.Lookup()", t, func() {
+		record := &dns.TLSA{
+			Hdr: dns.RR_Header{Name: "_25._tcp.mx.example.com.", Rrtype: dns.TypeTLSA, Class: dns.ClassINET, Ttl: 300},
+		}
+		So(record.Sign(DaneEE, 1, 1, leaf), ShouldEqual, nil)
+		unusable := &dns.TLSA{Hdr: record.Hdr}
+		So(unusable.Sign(1, 1, 1, leaf), ShouldEqual, nil)
+
+		authenticated := true
+		d := Dane{
+			exchange: func(m *dns.Msg) (*dns.Msg, error) {
+				So(m.Question[0].Name, ShouldEqual, "_25._tcp.mx.example.com.")
+				reply := new(dns.Msg)
+				reply.SetReply(m)
+				reply.AuthenticatedData = authenticated
+				reply.Answer = []dns.RR{record, unusable}
+				return reply, nil
+			},
+		}
+
+		records, err := d.Lookup("mx.example.com")
+		So(err, ShouldEqual, nil)
+		So(len(records), ShouldEqual, 1)
+		So(records[0].Usage, ShouldEqual, DaneEE)
+
+		// records which aren't DNSSEC validated are ignored
+		authenticated = false
+		records, err = d.Lookup("mx.example.com")
+		So(err, ShouldEqual, nil)
+		So(len(records), ShouldEqual, 0)
+	})
+
+	Convey("Testing TlsConfig()", t, func() {
+		So(TlsConfig("mx.example.com.", nil).InsecureSkipVerify, ShouldEqual, false)
+		So(TlsConfig("mx.example.com.", nil).ServerName, ShouldEqual, "mx.example.com")
+		So(TlsConfig("mx.example.com", []*dns.TLSA{&dns.TLSA{}}).VerifyConnection, ShouldNotEqual, nil)
+	})
+
+}
@@ -0,0 +1,238 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MTA-STS policy modes (RFC 8461 section 3.2)
+const (
+	StsEnforce = "enforce"
+	StsTesting = "testing"
+	StsNone    = "none"
+)
+
+// maxPolicySize is the maximum size of a policy file
+const maxPolicySize = 64 * 1024
+
+// StsPolicy is an MTA-STS policy of a recipient domain (RFC 8461)
+type StsPolicy struct {
+	Id     string
+	Mode   string
+	Mx     []string
+	MaxAge int
+}
+
+// Matches reports whether the MX host is allowed by the policy,
+// patterns like '*.example.com' match exactly one label
+func (p *StsPolicy) Matches(mx string) bool {
+	mx = strings.TrimSuffix(strings.ToLower(mx), ".")
+	for _, pattern := range p.Mx {
+		pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+		if strings.HasPrefix(pattern, "*.") {
+			i := strings.Index(mx, ".")
+			if i > 0 && mx[i+1:] == pattern[2:] {
+				return true
+			}
+		} else if mx == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Permits reports whether mail may be delivered to the MX host over a connection
+// with (tlsVerified = true) or without a valid TLS certificate.
+// Only policies in enforce mode refuse delivery.
+func (p *StsPolicy) Permits(mx string, tlsVerified bool) bool {
+	if p == nil || p.Mode != StsEnforce {
+		return true
+	}
+	return tlsVerified && p.Matches(mx)
+}
+
+// ParseStsPolicy parses a policy file (RFC 8461 section 3.2)
+func ParseStsPolicy(r io.Reader) (*StsPolicy, error) {
+	p := &StsPolicy{}
+	version := ""
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch key {
+		case "version":
+			version = value
+		case "mode":
+			p.Mode = value
+		case "mx":
+			p.Mx = append(p.Mx, value)
+		case "max_age":
+			maxAge, err := strconv.Atoi(value)
+			if err != nil || maxAge < 0 {
+				return nil, fmt.Errorf("invalid max_age: '%s'", value)
+			}
+			// max_age is at most one year
+			if maxAge > 31557600 {
+				maxAge = 31557600
+			}
+			p.MaxAge = maxAge
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if version != "STSv1" {
+		return nil, fmt.Errorf("unsupported version: '%s'", version)
+	}
+	switch p.Mode {
+	case StsEnforce, StsTesting:
+		if len(p.Mx) == 0 {
+			return nil, errors.New("policy has no mx")
+		}
+	case StsNone:
+	default:
+		return nil, fmt.Errorf("invalid mode: '%s'", p.Mode)
+	}
+	return p, nil
+}
+
+// MtaSts fetches and caches the MTA-STS policies of recipient domains
+type MtaSts struct {
+	// Timeout for fetching a policy in seconds
+	Timeout int
+
+	// lookupTXT and fetch can be replaced for testing
+	lookupTXT func(name string) ([]string, error)
+	fetch     func(domain string) (io.ReadCloser, error)
+
+	mutex sync.Mutex
+	cache map[string]*cachedPolicy
+}
+
+type cachedPolicy struct {
+	policy  *StsPolicy
+	expires time.Time
+}
+
+// Policy returns the policy of the domain, or nil if the domain has no MTA-STS policy
+func (m *MtaSts) Policy(domain string) (*StsPolicy, error) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	m.mutex.Lock()
+	cached, found := m.cache[domain]
+	m.mutex.Unlock()
+
+	id, err := m.lookupId(domain)
+	if err != nil {
+		// Without TXT record, a cached (unexpired) policy still applies (RFC 8461 section 5.1)
+		if found && time.Now().Before(cached.expires) {
+			return cached.policy, nil
+		}
+		return nil, nil
+	}
+
+	if found && cached.policy.Id == id && time.Now().Before(cached.expires) {
+		return cached.policy, nil
+	}
+
+	policy, err := m.fetchPolicy(domain)
+	if err != nil {
+		if found && time.Now().Before(cached.expires) {
+			return cached.policy, nil
+		}
+		return nil, err
+	}
+	policy.Id = id
+
+	m.mutex.Lock()
+	if m.cache == nil {
+		m.cache = make(map[string]*cachedPolicy)
+	}
+	m.cache[domain] = &cachedPolicy{
+		policy:  policy,
+		expires: time.Now().Add(time.Duration(policy.MaxAge) * time.Second),
+	}
+	m.mutex.Unlock()
+
+	return policy, nil
+}
+
+// lookupId finds the policy id in the _mta-sts TXT record
+func (m *MtaSts) lookupId(domain string) (string, error) {
+	lookup := m.lookupTXT
+	if lookup == nil {
+		lookup = net.LookupTXT
+	}
+	records, err := lookup("_mta-sts." + domain)
+	if err != nil {
+		return "", err
+	}
+	for _, record := range records {
+		if !strings.HasPrefix(record, "v=STSv1") {
+			continue
+		}
+		for _, field := range strings.Split(record, ";") {
+			field = strings.TrimSpace(field)
+			if strings.HasPrefix(field, "id=") {
+				return field[len("id="):], nil
+			}
+		}
+	}
+	return "", errors.New("no MTA-STS record")
+}
+
+func (m *MtaSts) fetchPolicy(domain string) (*StsPolicy, error) {
+	fetch := m.fetch
+	if fetch == nil {
+		fetch = m.fetchHttps
+	}
+	body, err := fetch(domain)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ParseStsPolicy(io.LimitReader(body, maxPolicySize))
+}
+
+// fetchHttps gets the policy from https://mta-sts.<domain>/.well-known/mta-sts.txt
+func (m *MtaSts) fetchHttps(domain string) (io.ReadCloser, error) {
+	timeout := time.Duration(m.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		// HTTP redirects are not followed (RFC 8461 section 3.3)
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get("https://mta-sts." + domain + "/.well-known/mta-sts.txt")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching MTA-STS policy: %s", resp.Status)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		resp.Body.Close()
+		return nil, errors.New("MTA-STS policy is not text/plain")
+	}
+	return resp.Body, nil
+}
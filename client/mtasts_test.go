@@ -0,0 +1,86 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMtaSts(t *testing.T) {
+
+	Convey("Testing ParseStsPolicy()", t, func() {
+		p, err := ParseStsPolicy(strings.NewReader("version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmx: *.example.net\r\nmax_age: 86400\r\n"))
+		So(err, ShouldEqual, nil)
+		So(p.Mode, ShouldEqual, StsEnforce)
+		So(p.MaxAge, ShouldEqual, 86400)
+
+		So(p.Matches("mail.example.com"), ShouldEqual, true)
+		So(p.Matches("MAIL.example.com."), ShouldEqual, true)
+		So(p.Matches("mx1.example.net"), ShouldEqual, true)
+		So(p.Matches("a.mx1.example.net"), ShouldEqual, false)
+		So(p.Matches("example.net"), ShouldEqual, false)
+		So(p.Matches("evil.example.org"), ShouldEqual, false)
+
+		So(p.Permits("mail.example.com", true), ShouldEqual, true)
+		So(p.Permits("mail.example.com", false), ShouldEqual, false)
+		So(p.Permits("evil.example.org", true), ShouldEqual, false)
+
+		p.Mode = StsTesting
+		So(p.Permits("evil.example.org", false), ShouldEqual, true)
+
+		var none *StsPolicy
+		So(none.Permits("evil.example.org", false), ShouldEqual, true)
+
+		_, err = ParseStsPolicy(strings.NewReader("version: STSv2\nmode: enforce\nmx: a\nmax_age: 1\n"))
+		So(err, ShouldNotEqual, nil)
+		_, err = ParseStsPolicy(strings.NewReader("version: STSv1\nmode: enforce\nmax_age: 1\n"))
+		So(err, ShouldNotEqual, nil)
+		_, err = ParseStsPolicy(strings.NewReader("version: STSv1\nmode: strict\nmx: a\nmax_age: 1\n"))
+		So(err, ShouldNotEqual, nil)
+	})
+
+	Convey("Testing MtaSts policy cache", t, func() {
+		fetches := 0
+		id := "20160831085700Z"
+		m := MtaSts{
+			lookupTXT: func(name string) ([]string, error) {
+				if name == "_mta-sts.example.com" {
+					return []string{"v=STSv1; id=" + id + ";"}, nil
+				}
+				return nil, errors.New("NXDOMAIN")
+			},
+			fetch: func(domain string) (io.ReadCloser, error) {
+				fetches++
+				return ioutil.NopCloser(strings.NewReader("version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 86400\n")), nil
+			},
+		}
+
+		p, err := m.Policy("example.com")
+		So(err, ShouldEqual, nil)
+		So(p.Mode, ShouldEqual, StsEnforce)
+		So(p.Id, ShouldEqual, id)
+		So(fetches, ShouldEqual, 1)
+
+		// cached
+		p, err = m.Policy("Example.com")
+		So(err, ShouldEqual, nil)
+		So(fetches, ShouldEqual, 1)
+
+		// refetched when the id changes
+		id = "20160901000000Z"
+		p, err = m.Policy("example.com")
+		So(err, ShouldEqual, nil)
+		So(p.Id, ShouldEqual, id)
+		So(fetches, ShouldEqual, 2)
+
+		// no policy
+		p, err = m.Policy("example.org")
+		So(err, ShouldEqual, nil)
+		So(p, ShouldEqual, nil)
+	})
+
+}
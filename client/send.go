@@ -0,0 +1,535 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/miekg/dns"
+)
+
+// Timeout for connecting to a server
+const dialTimeout = 30 * time.Second
+
+// MaxRecipients is the number of recipients sent in one transaction,
+// servers must accept at least 100 (RFC 5321 section 4.5.3.1.8)
+const MaxRecipients = 100
+
+// Send delivers the message to the server at addr (host:port).
+// STARTTLS is used if the server supports it, the certificate isn't verified
+// (opportunistic TLS, RFC 7435), since most MX hosts don't have a certificate for their name.
+// An error with a 5xx reply code is a *textproto.Error, so permanent failures can be told apart.
+// Recipients are sent in transactions of at most MaxRecipients, and the recipients a server
+// refuses with 452 (too many recipients) are sent in the next transaction.
+// It returns the first failure of the recipients, Sender.Send returns the result of each.
+func Send(addr, helo, from string, to []string, data []byte) error {
+	return (&Sender{}).Send(addr, helo, from, to, data).Err()
+}
+
+// Deliver delivers the message to the MX hosts of the domain of the recipient, in order of preference.
+// Domains without MX records are delivered to the domain itself (RFC 5321 section 5.1).
+// The next MX host is only tried after a temporary failure.
+func Deliver(helo, from, to string, data []byte) error {
+	return (&Sender{}).Deliver(helo, from, to, data)
+}
+
+// Result is the outcome of the delivery to a recipient: Err is nil if the server accepted the message for it,
+// the reply to its RCPT if the server refused it, or the failure of the session
+type Result struct {
+	Recipient string
+	Err       error
+}
+
+// Results are the outcomes of a delivery, in the order of the recipients
+type Results []Result
+
+// results returns the same outcome for all recipients
+func results(to []string, err error) Results {
+	r := make(Results, len(to))
+	for i, recipient := range to {
+		r[i] = Result{Recipient: recipient, Err: err}
+	}
+	return r
+}
+
+// Err returns the first failure, nil if all recipients got the message
+func (r Results) Err() error {
+	for _, result := range r {
+		if result.Err != nil {
+			return result.Err
+		}
+	}
+	return nil
+}
+
+// Permanent reports whether the failure is permanent (a 5xx reply)
+func Permanent(err error) bool {
+	protoErr, ok := err.(*textproto.Error)
+	return ok && protoErr.Code >= 500
+}
+
+// Sender sends messages like Send and Deliver, from the source IPs of the pools in Sources.
+// The deliveries to MX hosts follow the MTA-STS policies of the recipient domains if Sts is set,
+// and the TLSA records of the MX hosts if Dane is set. The results of their TLS sessions
+// are collected for the TLS reports of the domains (RFC 8460) if TlsRpt is set.
+// Hosts which keep failing are paused by the Breaker if it's set,
+// and the MX lookups count for the health of the resolver in Dns if it's set.
+type Sender struct {
+	Sources *helpers.SourceIps
+	Sts     *MtaSts
+	Dane    *Dane
+	TlsRpt  *TlsRptCollector
+	Breaker *CircuitBreaker
+	Dns     *helpers.DnsHealth
+}
+
+// errNoStartTls is the failure of a session to an MX host which doesn't offer STARTTLS
+var errNoStartTls = errors.New("STARTTLS not supported")
+
+// tlsPolicy is the TLS policy of a delivery to the MX hosts of a domain,
+// a nil policy is opportunistic TLS
+type tlsPolicy struct {
+	domain string
+	sts    *StsPolicy
+	// tlsa are the TLSA records of the MX host, they take precedence over MTA-STS (RFC 8461 section 2)
+	tlsa []*dns.TLSA
+}
+
+// verifies reports whether the certificate of the host is verified
+func (p *tlsPolicy) verifies() bool {
+	return p != nil && (len(p.tlsa) > 0 || (p.sts != nil && p.sts.Mode == StsEnforce))
+}
+
+// tlsConfig returns the config for STARTTLS to the host: the certificate is verified against
+// the TLSA records, or as usual if the MTA-STS policy is enforced, TLS is opportunistic otherwise.
+// With an MTA-STS policy in testing mode, the verification error is stored in testFailure
+// for the TLS reports, but the session goes on.
+func (p *tlsPolicy) tlsConfig(host string, testFailure *error) *tls.Config {
+	if p.verifies() {
+		return TlsConfig(host, p.tlsa)
+	}
+	config := &tls.Config{ServerName: host, InsecureSkipVerify: true}
+	if p != nil && p.sts != nil && p.sts.Mode == StsTesting {
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			*testFailure = verifyCertificate(host, state.PeerCertificates)
+			return nil
+		}
+	}
+	return config
+}
+
+// verifyCertificate verifies the certificate chain of the host like crypto/tls does
+func verifyCertificate(host string, chain []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return errors.New("no certificate presented")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates})
+	return err
+}
+
+// reportPolicy returns the policy type and the policy string of the TLS reports
+func (p *tlsPolicy) reportPolicy() (string, []string) {
+	if len(p.tlsa) > 0 {
+		records := []string{}
+		for _, record := range p.tlsa {
+			records = append(records, fmt.Sprintf("%d %d %d %s", record.Usage, record.Selector, record.MatchingType, record.Certificate))
+		}
+		return PolicyTlsa, records
+	}
+	if p.sts != nil && p.sts.Mode != StsNone {
+		policy := []string{"version: STSv1", "mode: " + p.sts.Mode}
+		for _, mx := range p.sts.Mx {
+			policy = append(policy, "mx: "+mx)
+		}
+		return PolicySts, append(policy, fmt.Sprintf("max_age: %d", p.sts.MaxAge))
+	}
+	return PolicyNotFound, nil
+}
+
+// tlsResultType returns the result type of a failed TLS session in the TLS reports (RFC 8460 section 4.3)
+func tlsResultType(err error) string {
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var authorityErr x509.UnknownAuthorityError
+	switch {
+	case err == errNoStartTls:
+		return "starttls-not-supported"
+	case errors.As(err, &hostnameErr):
+		return "certificate-host-mismatch"
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return "certificate-expired"
+	case errors.As(err, &authorityErr):
+		return "certificate-not-trusted"
+	}
+	return "validation-failure"
+}
+
+// report counts the TLS session to the MX host in the TLS reports of the domain of the policy,
+// err is nil for a successful session
+func (s *Sender) report(policy *tlsPolicy, host string, err error) {
+	if s.TlsRpt == nil || policy == nil {
+		return
+	}
+	policyType, policyString := policy.reportPolicy()
+	if err == nil {
+		s.TlsRpt.Success(policy.domain, policyType, policyString, host)
+		return
+	}
+	s.TlsRpt.Failure(policy.domain, policyType, policyString, host, TlsFailureDetails{
+		ResultType:          tlsResultType(err),
+		ReceivingMxHostname: host,
+	})
+}
+
+// permits reports whether the message may be sent to the host, over a connection
+// with (tlsVerified = true) or without a verified certificate
+func (p *tlsPolicy) permits(host string, tlsVerified bool) bool {
+	if p == nil {
+		return true
+	}
+	if len(p.tlsa) > 0 {
+		return tlsVerified
+	}
+	return p.sts.Permits(host, tlsVerified)
+}
+
+// Send delivers the message to the server at addr (host:port), see Send.
+// It returns the result of each recipient.
+func (s *Sender) Send(addr, helo, from string, to []string, data []byte) Results {
+	if s.Breaker != nil {
+		host, _, _ := net.SplitHostPort(addr)
+		if !s.Breaker.Allow(host) {
+			return results(to, pausedError(host))
+		}
+	}
+	return s.send(addr, helo, from, to, data, nil)
+}
+
+// pausedError is the temporary failure of a delivery to hosts whose circuit is open
+func pausedError(host string) error {
+	return &textproto.Error{Code: 421, Msg: "4.4.1 delivery to " + host + " is paused after repeated failures"}
+}
+
+// send delivers the message to the server at addr, which is an MX host
+// of the domain of the policy if there is one. The host fails for its circuit
+// if none of the recipients got a reply which tells whether it was accepted.
+func (s *Sender) send(addr, helo, from string, to []string, data []byte, policy *tlsPolicy) Results {
+	r := s.session(addr, helo, from, to, data, policy)
+	if s.Breaker != nil {
+		host, _, _ := net.SplitHostPort(addr)
+		failed := true
+		for _, result := range r {
+			failed = failed && result.Err != nil && !Permanent(result.Err)
+		}
+		if failed {
+			s.Breaker.Failed(host)
+		} else {
+			s.Breaker.Succeeded(host)
+		}
+	}
+	return r
+}
+
+// session sends the message in a session with the server at addr. The recipients of the transactions
+// which completed got the message, if a later transaction fails only the ones which weren't sent yet fail.
+func (s *Sender) session(addr, helo, from string, to []string, data []byte, policy *tlsPolicy) Results {
+	c, err := s.dial(addr, from, to)
+	if err != nil {
+		return results(to, err)
+	}
+	defer c.Close()
+
+	if err := c.Hello(helo); err != nil {
+		return results(to, err)
+	}
+	verified := false
+	if c.Capabilities != nil && c.Capabilities.StartTLS {
+		var testFailure error
+		if err := c.StartTLS(policy.tlsConfig(c.host, &testFailure)); err != nil {
+			s.report(policy, c.host, err)
+			return results(to, err)
+		}
+		s.report(policy, c.host, testFailure)
+		verified = policy.verifies()
+	}
+	if !policy.permits(c.host, verified) {
+		s.report(policy, c.host, errNoStartTls)
+		return results(to, &textproto.Error{Code: 451, Msg: fmt.Sprintf("4.7.5 %s doesn't offer STARTTLS, the TLS policy of %s requires it", c.host, policy.domain)})
+	}
+
+	// the recipients are sent with A-labels to servers without SMTPUTF8, the ones which can't be converted fail
+	r := results(to, nil)
+	pending := []int{}
+	addresses := make([]string, len(to))
+	copy(addresses, to)
+	if c.Capabilities == nil || !c.Capabilities.SMTPUTF8 {
+		if from, err = asciiAddress(from); err != nil {
+			return results(to, err)
+		}
+		for i := range addresses {
+			addresses[i], r[i].Err = asciiAddress(to[i])
+		}
+	}
+	for i := range to {
+		if r[i].Err == nil {
+			pending = append(pending, i)
+		}
+	}
+
+	for len(pending) > 0 {
+		batch := pending
+		if len(batch) > MaxRecipients {
+			batch = batch[:MaxRecipients]
+		}
+		recipients := make([]string, len(batch))
+		for i, index := range batch {
+			recipients[i] = addresses[index]
+		}
+		sent, refused, err := transaction(c, from, recipients, data)
+		if err != nil {
+			// the recipients of the earlier transactions keep their result
+			for _, index := range pending {
+				r[index].Err = err
+			}
+			return r
+		}
+		for i, err := range refused {
+			r[batch[i]].Err = err
+		}
+		pending = pending[sent:]
+	}
+	// the message was sent, a failing QUIT doesn't change that
+	c.Quit()
+	return r
+}
+
+// dial connects to the server at addr from a source IP of the sender's pool,
+// trying the IPs of the server for which the pool has a source IP
+func (s *Sender) dial(addr, from string, to []string) (*Client, error) {
+	if !s.Sources.Enabled() {
+		return Dial(addr, dialTimeout)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	domain := ""
+	if len(to) > 0 {
+		domain = to[0][strings.LastIndexByte(to[0], '@')+1:]
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = net.LookupIP(host); err != nil {
+			return nil, err
+		}
+	}
+
+	err = fmt.Errorf("the pool of %s has no source IP for %s", from, addr)
+	for _, ip := range ips {
+		local, ok := s.Sources.Select(from, domain, ip)
+		if !ok {
+			continue
+		}
+		dialer := net.Dialer{Timeout: dialTimeout}
+		if local != nil {
+			dialer.LocalAddr = &net.TCPAddr{IP: local}
+		}
+		var conn net.Conn
+		conn, err = dialer.Dial("tcp", net.JoinHostPort(ip.String(), port))
+		if err != nil {
+			continue
+		}
+		c, err := NewClient(conn, host)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	return nil, err
+}
+
+// asciiAddress returns the address with A-labels, for servers which don't support SMTPUTF8.
+// Addresses with a non-ASCII local part can't be sent to them (RFC 6531 section 3.2).
+func asciiAddress(address string) (string, error) {
+	i := strings.LastIndexByte(address, '@')
+	if i >= 0 && !helpers.IsAscii(address[:i]) {
+		return "", &textproto.Error{Code: 553, Msg: "5.6.7 Server doesn't support SMTPUTF8 for " + address}
+	}
+	return helpers.AddressToAscii(address)
+}
+
+// transaction sends the message to the recipients in one transaction. It returns the number of recipients
+// which were sent, and the replies of the ones the server refused by their index.
+// The error is the failure of the transaction, none of the recipients got the message then.
+func transaction(c *Client, from string, to []string, data []byte) (int, map[int]error, error) {
+	if err := c.Mail(from, int64(len(data))); err != nil {
+		return 0, nil, err
+	}
+	sent, accepted := 0, 0
+	refused := make(map[int]error)
+	for i, rcpt := range to {
+		err := c.Rcpt(rcpt)
+		if protoErr, ok := err.(*textproto.Error); ok && protoErr.Code == 452 && accepted > 0 {
+			// too many recipients, the rest is sent in the next transaction
+			break
+		}
+		sent++
+		if _, reply := err.(*textproto.Error); reply {
+			refused[i] = err
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		accepted++
+	}
+	if accepted == 0 {
+		return sent, refused, c.Reset()
+	}
+
+	if err := c.Data(data); err != nil {
+		return 0, nil, err
+	}
+	return sent, refused, nil
+}
+
+// Deliver delivers the message to the MX hosts of the domain of the recipient, see Deliver
+func (s *Sender) Deliver(helo, from, to string, data []byte) error {
+	return s.DeliverDomain(helo, from, []string{to}, data).Err()
+}
+
+// DeliverDomain delivers the message to the recipients, which have the same domain, like Deliver.
+// With an enforced MTA-STS policy, only the MX hosts which the policy lists are tried.
+// MX hosts with TLSA records get the message only over STARTTLS with a certificate which matches them,
+// and MX hosts whose TLSA records can't be looked up are skipped (RFC 7672 section 2.2).
+// MX hosts whose circuit is open are skipped, so the mail goes to the others.
+// The recipients which fail temporarily at an MX host are tried at the next one,
+// it returns the result of each recipient.
+func (s *Sender) DeliverDomain(helo, from string, to []string, data []byte) Results {
+	if len(to) == 0 {
+		return nil
+	}
+	i := strings.LastIndexByte(to[0], '@')
+	if i < 0 {
+		return results(to, fmt.Errorf("invalid recipient %s", to[0]))
+	}
+	domain := to[0][i+1:]
+	hosts, err := lookupMx(domain)
+	if _, invalid := err.(*textproto.Error); s.Dns != nil && !invalid {
+		s.Dns.Result(err)
+	}
+	if err != nil {
+		return results(to, err)
+	}
+	if s.Breaker != nil {
+		if hosts = s.Breaker.Available(hosts); len(hosts) == 0 {
+			return results(to, pausedError("the MX hosts of "+domain))
+		}
+	}
+	policy := s.policy(domain)
+
+	r := results(to, &textproto.Error{Code: 451, Msg: "4.7.5 no MX host of " + domain + " matches its MTA-STS policy"})
+	pending := make([]int, len(to))
+	for i := range pending {
+		pending[i] = i
+	}
+	for _, host := range hosts {
+		hostPolicy := *policy
+		if s.Dane != nil && helpers.ParseAddressLiteral(domain) == nil {
+			records, lookupErr := s.Dane.Lookup(host)
+			if lookupErr != nil {
+				if s.TlsRpt != nil {
+					s.TlsRpt.Failure(policy.domain, PolicyTlsa, nil, host, TlsFailureDetails{ResultType: "dnssec-invalid", ReceivingMxHostname: host})
+				}
+				for _, index := range pending {
+					r[index].Err = &textproto.Error{Code: 451, Msg: "4.7.5 " + lookupErr.Error()}
+				}
+				continue
+			}
+			hostPolicy.tlsa = records
+		}
+		if !hostPolicy.permits(host, true) {
+			continue
+		}
+		recipients := make([]string, len(pending))
+		for i, index := range pending {
+			recipients[i] = to[index]
+		}
+		var retry []int
+		for i, result := range s.send(net.JoinHostPort(host, "25"), helo, from, recipients, data, &hostPolicy) {
+			r[pending[i]].Err = result.Err
+			if result.Err != nil && !Permanent(result.Err) {
+				retry = append(retry, pending[i])
+			}
+		}
+		if pending = retry; len(pending) == 0 {
+			break
+		}
+	}
+	return r
+}
+
+// policy returns the TLS policy of the delivery to the MX hosts of the domain.
+// A policy which can't be fetched doesn't apply (RFC 8461 section 5.1).
+func (s *Sender) policy(domain string) *tlsPolicy {
+	policy := &tlsPolicy{domain: domain}
+	if s.Sts == nil || helpers.ParseAddressLiteral(domain) != nil {
+		return policy
+	}
+	ascii, err := helpers.DomainToAscii(domain)
+	if err != nil {
+		return policy
+	}
+	policy.domain = ascii
+	if policy.sts, err = s.Sts.Policy(ascii); err != nil && s.TlsRpt != nil {
+		s.TlsRpt.Failure(ascii, PolicySts, nil, "", TlsFailureDetails{ResultType: "sts-policy-fetch-error"})
+	}
+	return policy
+}
+
+// lookupMx returns the MX hosts of the domain in order of preference,
+// mail for an address literal is delivered to that IP (RFC 5321 section 5.1).
+// Internationalized domains are looked up with their A-labels.
+func lookupMx(domain string) ([]string, error) {
+	if ip := helpers.ParseAddressLiteral(domain); ip != nil {
+		return []string{ip.String()}, nil
+	}
+	ascii, err := helpers.DomainToAscii(domain)
+	if err != nil {
+		return nil, &textproto.Error{Code: 553, Msg: "5.1.3 invalid domain " + domain}
+	}
+	domain = ascii
+	records, err := net.LookupMX(domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return nil, err
+		}
+	}
+	if len(records) == 0 {
+		return []string{domain}, nil
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Pref < records[j].Pref })
+	hosts := []string{}
+	for _, mx := range records {
+		// a null MX (RFC 7505) means the domain doesn't accept mail
+		if mx.Host == "." {
+			return nil, &textproto.Error{Code: 556, Msg: domain + " does not accept mail"}
+		}
+		hosts = append(hosts, strings.TrimSuffix(mx.Host, "."))
+	}
+	return hosts, nil
+}
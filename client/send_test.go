@@ -0,0 +1,340 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/miekg/dns"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeServer accepts one SMTP session, records the commands and message,
+// answers RCPT for rejected recipients with 550 and accepts at most maxRecipients per transaction
+func fakeServer(rejected string, maxRecipients int) (string, chan []string) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	session := make(chan []string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		lines := []string{}
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 mx.example.com ESMTP")
+		data := false
+		recipients := 0
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case data && line == ".":
+				data = false
+				reply("250 queued")
+			case data:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250-mx.example.com\r\n250 8BITMIME")
+			case strings.HasPrefix(line, "RCPT") && rejected != "" && strings.Contains(line, rejected):
+				reply("550 no such user")
+			case strings.HasPrefix(line, "RCPT") && recipients >= maxRecipients:
+				reply("452 too many recipients")
+			case strings.HasPrefix(line, "RCPT"):
+				recipients++
+				reply("250 ok")
+			case strings.HasPrefix(line, "MAIL"):
+				recipients = 0
+				reply("250 ok")
+			case line == "DATA":
+				data = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				reply("221 bye")
+				session <- lines
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+		session <- lines
+	}()
+	return l.Addr().String(), session
+}
+
+func TestSend(t *testing.T) {
+
+	Convey("Testing Send()", t, func() {
+		addr, session := fakeServer("", 100)
+		err := Send(addr, "satellite.example.com", "from@example.com", []string{"to@example.org"}, []byte("Subject: hi\r\n\r\nHello\r\n"))
+		So(err, ShouldEqual, nil)
+
+		lines := <-session
+		So(lines[0], ShouldEqual, "EHLO satellite.example.com")
+		So(lines, ShouldContain, "MAIL FROM:<from@example.com> BODY=8BITMIME")
+		So(lines, ShouldContain, "RCPT TO:<to@example.org>")
+		So(lines, ShouldContain, "Subject: hi")
+	})
+
+	Convey("Testing Send() dot-stuffs the message", t, func() {
+		addr, session := fakeServer("", 100)
+		err := Send(addr, "satellite.example.com", "from@example.com", []string{"to@example.org"}, []byte("Subject: dots\r\n\r\n.\r\n..two\r\n"))
+		So(err, ShouldEqual, nil)
+
+		lines := <-session
+		So(lines, ShouldContain, "..")
+		So(lines, ShouldContain, "...two")
+	})
+
+	Convey("Testing Send() with a rejected recipient", t, func() {
+		addr, session := fakeServer("nobody", 100)
+		err := Send(addr, "satellite.example.com", "from@example.com", []string{"nobody@example.org"}, []byte("Hello\r\n"))
+		So(err, ShouldNotEqual, nil)
+		protoErr, ok := err.(*textproto.Error)
+		So(ok, ShouldEqual, true)
+		So(protoErr.Code, ShouldEqual, 550)
+		<-session
+	})
+
+	Convey("Testing Sender.Send() with a rejected recipient among others", t, func() {
+		addr, session := fakeServer("nobody", 100)
+		r := (&Sender{}).Send(addr, "satellite.example.com", "from@example.com", []string{"nobody@example.org", "bob@example.org", "jürgen@example.org"}, []byte("Hello\r\n"))
+		So(len(r), ShouldEqual, 3)
+		So(r[0].Recipient, ShouldEqual, "nobody@example.org")
+		So(Permanent(r[0].Err), ShouldEqual, true)
+		So(r[1].Err, ShouldEqual, nil)
+		So(EnhancedCode(r[2].Err), ShouldEqual, "5.6.7")
+		So(<-session, ShouldContain, "DATA")
+
+		// nothing is sent when all recipients are refused
+		addr, session = fakeServer("nobody", 100)
+		r = (&Sender{}).Send(addr, "satellite.example.com", "from@example.com", []string{"nobody@example.org"}, []byte("Hello\r\n"))
+		So(Permanent(r.Err()), ShouldEqual, true)
+		lines := <-session
+		So(lines, ShouldContain, "RSET")
+		So(lines, ShouldNotContain, "DATA")
+	})
+
+	Convey("Testing Send() with too many recipients", t, func() {
+		addr, session := fakeServer("", 2)
+		err := Send(addr, "satellite.example.com", "from@example.com", []string{"a@example.org", "b@example.org", "c@example.org"}, []byte("Hello\r\n"))
+		So(err, ShouldEqual, nil)
+
+		transactions := 0
+		for _, line := range <-session {
+			if strings.HasPrefix(line, "MAIL") {
+				transactions++
+			}
+		}
+		So(transactions, ShouldEqual, 2)
+	})
+
+	Convey("Testing Sender.Send() when a later transaction fails", t, func() {
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		go func() {
+			defer l.Close()
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+			reply("220 mx.example.com ESMTP")
+			data, transactions, recipients := false, 0, 0
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				line = strings.TrimRight(line, "\r\n")
+				switch {
+				case data && line == ".":
+					data = false
+					reply("250 queued")
+				case data:
+				case strings.HasPrefix(line, "MAIL") && transactions > 0:
+					// the server goes away after the first transaction
+					reply("421 shutting down")
+					return
+				case strings.HasPrefix(line, "MAIL"):
+					transactions++
+					reply("250 ok")
+				case strings.HasPrefix(line, "RCPT") && recipients >= 2:
+					reply("452 too many recipients")
+				case strings.HasPrefix(line, "RCPT"):
+					recipients++
+					reply("250 ok")
+				case line == "DATA":
+					data = true
+					reply("354 go ahead")
+				default:
+					reply("250 ok")
+				}
+			}
+		}()
+
+		r := (&Sender{}).Send(l.Addr().String(), "satellite.example.com", "from@example.com", []string{"a@example.org", "b@example.org", "c@example.org"}, []byte("Hello\r\n"))
+		So(r[0].Err, ShouldEqual, nil)
+		So(r[1].Err, ShouldEqual, nil)
+		protoErr, ok := r[2].Err.(*textproto.Error)
+		So(ok, ShouldEqual, true)
+		So(protoErr.Code, ShouldEqual, 421)
+	})
+
+	Convey("Testing Send() to a server without SMTPUTF8", t, func() {
+		addr, session := fakeServer("", 100)
+		err := Send(addr, "satellite.example.com", "from@bücher.example", []string{"to@München.example"}, []byte("Hello\r\n"))
+		So(err, ShouldEqual, nil)
+		lines := <-session
+		So(lines, ShouldContain, "MAIL FROM:<from@xn--bcher-kva.example> BODY=8BITMIME")
+		So(lines, ShouldContain, "RCPT TO:<to@xn--mnchen-3ya.example>")
+
+		// non-ASCII local parts can't be sent
+		addr, session = fakeServer("", 100)
+		err = Send(addr, "satellite.example.com", "from@example.com", []string{"jürgen@example.org"}, []byte("Hello\r\n"))
+		protoErr, ok := err.(*textproto.Error)
+		So(ok, ShouldEqual, true)
+		So(protoErr.Code, ShouldEqual, 553)
+		<-session
+	})
+
+	Convey("Testing verify()", t, func() {
+		addr, session := fakeServer("nobody", 100)
+		So(verify(addr, "mx.example.com", "bob@example.org"), ShouldEqual, nil)
+		lines := <-session
+		So(lines, ShouldContain, "MAIL FROM:<> BODY=8BITMIME")
+		So(lines, ShouldContain, "RCPT TO:<bob@example.org>")
+		So(lines, ShouldContain, "RSET")
+		So(lines, ShouldNotContain, "DATA")
+
+		addr, session = fakeServer("nobody", 100)
+		err := verify(addr, "mx.example.com", "nobody@example.org")
+		protoErr, ok := err.(*textproto.Error)
+		So(ok, ShouldEqual, true)
+		So(protoErr.Code, ShouldEqual, 550)
+		<-session
+	})
+
+	Convey("Testing Send() without a server", t, func() {
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		addr := l.Addr().String()
+		l.Close()
+		So(Send(addr, "satellite.example.com", "", []string{"to@example.org"}, nil), ShouldNotEqual, nil)
+	})
+
+}
+
+func TestSender(t *testing.T) {
+
+	Convey("Testing Sender with source IPs", t, func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldEqual, nil)
+		defer l.Close()
+		remotes := make(chan string, 10)
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				remotes <- conn.RemoteAddr().(*net.TCPAddr).IP.String()
+				conn.Write([]byte("220 mx.example.com ESMTP\r\n"))
+				conn.Close()
+			}
+		}()
+
+		// all of 127.0.0.0/8 is the loopback interface
+		s := &Sender{Sources: &helpers.SourceIps{
+			Pools:   map[string][]string{"default": {"127.0.0.2", "::1"}, "bulk": {"127.0.0.3"}},
+			Senders: map[string]string{"news.example.com": "bulk"},
+		}}
+		c, err := s.dial(l.Addr().String(), "bob@example.com", []string{"alice@example.org"})
+		So(err, ShouldEqual, nil)
+		c.Close()
+		So(<-remotes, ShouldEqual, "127.0.0.2")
+
+		c, err = s.dial(l.Addr().String(), "list@news.example.com", []string{"alice@example.org"})
+		So(err, ShouldEqual, nil)
+		c.Close()
+		So(<-remotes, ShouldEqual, "127.0.0.3")
+
+		// a pool without IPv4 addresses doesn't connect to IPv4 servers
+		s.Sources.Pools["bulk"] = []string{"::1"}
+		_, err = s.dial(l.Addr().String(), "list@news.example.com", []string{"alice@example.org"})
+		So(err, ShouldNotEqual, nil)
+		So(err.Error(), ShouldContainSubstring, "has no source IP")
+	})
+
+	Convey("Testing Sender with a circuit breaker", t, func() {
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		addr := l.Addr().String()
+		l.Close()
+		s := &Sender{Breaker: &CircuitBreaker{Threshold: 2}}
+
+		for i := 0; i < 2; i++ {
+			err := s.Send(addr, "satellite.example.com", "", []string{"to@example.org"}, nil).Err()
+			_, ok := err.(*textproto.Error)
+			So(ok, ShouldEqual, false)
+		}
+		// the host is paused after the second failure
+		err := s.Send(addr, "satellite.example.com", "", []string{"to@example.org"}, nil).Err()
+		protoErr, ok := err.(*textproto.Error)
+		So(ok, ShouldEqual, true)
+		So(protoErr.Code, ShouldEqual, 421)
+	})
+
+	Convey("Testing Sender with an MTA-STS policy", t, func() {
+		s := &Sender{}
+		policy := &tlsPolicy{domain: "example.org", sts: &StsPolicy{Mode: StsEnforce, Mx: []string{"127.0.0.1"}}}
+
+		// an enforced policy refuses a server without STARTTLS
+		addr, session := fakeServer("", 100)
+		err := s.send(addr, "satellite.example.com", "from@example.com", []string{"to@example.org"}, []byte("Hello\r\n"), policy).Err()
+		protoErr, ok := err.(*textproto.Error)
+		So(ok, ShouldEqual, true)
+		So(protoErr.Code, ShouldEqual, 451)
+		So(<-session, ShouldNotContain, "MAIL FROM:<from@example.com> BODY=8BITMIME")
+
+		// which is reported
+		s.TlsRpt = &TlsRptCollector{}
+		addr, session = fakeServer("", 100)
+		s.send(addr, "satellite.example.com", "from@example.com", []string{"to@example.org"}, []byte("Hello\r\n"), policy)
+		<-session
+		report := s.TlsRpt.Reports("Satellite Inc.", "")["example.org"]
+		So(report.Policies[0].Policy.PolicyType, ShouldEqual, PolicySts)
+		So(report.Policies[0].FailureDetails[0].ResultType, ShouldEqual, "starttls-not-supported")
+
+		// a policy in testing mode doesn't
+		policy.sts.Mode = StsTesting
+		addr, session = fakeServer("", 100)
+		err = s.send(addr, "satellite.example.com", "from@example.com", []string{"to@example.org"}, []byte("Hello\r\n"), policy).Err()
+		So(err, ShouldEqual, nil)
+		So(<-session, ShouldContain, "MAIL FROM:<from@example.com> BODY=8BITMIME")
+
+		// MX hosts which aren't listed aren't tried
+		policy.sts.Mode = StsEnforce
+		So(policy.permits("mx.example.net", true), ShouldEqual, false)
+		So(policy.permits("127.0.0.1", true), ShouldEqual, true)
+
+		// TLSA records require STARTTLS with a matching certificate, also without MTA-STS
+		policy = &tlsPolicy{domain: "example.org", tlsa: []*dns.TLSA{{Usage: DaneEE, Selector: 1, MatchingType: 1}}}
+		So(policy.tlsConfig("mx.example.org", nil).VerifyConnection, ShouldNotEqual, nil)
+		addr, session = fakeServer("", 100)
+		err = s.send(addr, "satellite.example.com", "from@example.com", []string{"to@example.org"}, []byte("Hello\r\n"), policy).Err()
+		protoErr, ok = err.(*textproto.Error)
+		So(ok, ShouldEqual, true)
+		So(protoErr.Code, ShouldEqual, 451)
+		<-session
+	})
+
+}
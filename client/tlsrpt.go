@@ -0,0 +1,285 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/helpers"
+)
+
+// Policy types in TLS reports (RFC 8460 section 4.4)
+const (
+	PolicySts      = "sts"
+	PolicyTlsa     = "tlsa"
+	PolicyNotFound = "no-policy-found"
+)
+
+// TlsReport is an SMTP TLS aggregate report (RFC 8460 section 4)
+type TlsReport struct {
+	OrganizationName string `json:"organization-name"`
+	DateRange        struct {
+		StartDatetime time.Time `json:"start-datetime"`
+		EndDatetime   time.Time `json:"end-datetime"`
+	} `json:"date-range"`
+	ContactInfo string            `json:"contact-info"`
+	ReportId    string            `json:"report-id"`
+	Policies    []TlsReportPolicy `json:"policies"`
+}
+
+// TlsReportPolicy contains the results for one policy of the reported domain
+type TlsReportPolicy struct {
+	Policy struct {
+		PolicyType   string   `json:"policy-type"`
+		PolicyString []string `json:"policy-string,omitempty"`
+		PolicyDomain string   `json:"policy-domain"`
+		MxHost       []string `json:"mx-host,omitempty"`
+	} `json:"policy"`
+	Summary struct {
+		TotalSuccessfulSessionCount int64 `json:"total-successful-session-count"`
+		TotalFailureSessionCount    int64 `json:"total-failure-session-count"`
+	} `json:"summary"`
+	FailureDetails []TlsFailureDetails `json:"failure-details,omitempty"`
+}
+
+// TlsFailureDetails describes failed sessions with the same cause
+type TlsFailureDetails struct {
+	ResultType            string `json:"result-type"`
+	SendingMtaIp          string `json:"sending-mta-ip,omitempty"`
+	ReceivingMxHostname   string `json:"receiving-mx-hostname,omitempty"`
+	ReceivingIp           string `json:"receiving-ip,omitempty"`
+	FailedSessionCount    int64  `json:"failed-session-count"`
+	AdditionalInformation string `json:"additional-information,omitempty"`
+	FailureReasonCode     string `json:"failure-reason-code,omitempty"`
+}
+
+// ParseTlsReport parses a (gzipped) JSON TLS report
+func ParseTlsReport(data []byte) (*TlsReport, error) {
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	report := &TlsReport{}
+	err := json.Unmarshal(data, report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// TlsRptCollector collects the results of outbound TLS sessions per policy domain,
+// so they can be reported daily to the domains that ask for it
+type TlsRptCollector struct {
+	mutex    sync.Mutex
+	start    time.Time
+	policies map[string]*TlsReportPolicy
+}
+
+func (c *TlsRptCollector) policy(domain, policyType string, policyString []string, mx string) *TlsReportPolicy {
+	if c.policies == nil {
+		c.policies = make(map[string]*TlsReportPolicy)
+		c.start = time.Now().UTC()
+	}
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	key := domain + " " + policyType
+	p, found := c.policies[key]
+	if !found {
+		p = &TlsReportPolicy{}
+		p.Policy.PolicyDomain = domain
+		p.Policy.PolicyType = policyType
+		p.Policy.PolicyString = policyString
+		c.policies[key] = p
+	}
+	if mx != "" && !contains(p.Policy.MxHost, mx) {
+		p.Policy.MxHost = append(p.Policy.MxHost, mx)
+	}
+	return p
+}
+
+// Success counts a successful TLS session to the MX of the domain
+func (c *TlsRptCollector) Success(domain, policyType string, policyString []string, mx string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.policy(domain, policyType, policyString, mx).Summary.TotalSuccessfulSessionCount++
+}
+
+// Failure counts a failed TLS session to the MX of the domain,
+// failures with the same details are aggregated
+func (c *TlsRptCollector) Failure(domain, policyType string, policyString []string, mx string, details TlsFailureDetails) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p := c.policy(domain, policyType, policyString, mx)
+	p.Summary.TotalFailureSessionCount++
+
+	details.FailedSessionCount = 0
+	for i := range p.FailureDetails {
+		existing := p.FailureDetails[i]
+		existing.FailedSessionCount = 0
+		if existing == details {
+			p.FailureDetails[i].FailedSessionCount++
+			return
+		}
+	}
+	details.FailedSessionCount = 1
+	p.FailureDetails = append(p.FailureDetails, details)
+}
+
+// Reports returns the reports per policy domain for the collected period and starts a new one
+func (c *TlsRptCollector) Reports(organization, contact string) map[string]*TlsReport {
+	c.mutex.Lock()
+	policies, start := c.policies, c.start
+	c.policies = nil
+	c.mutex.Unlock()
+
+	end := time.Now().UTC()
+	reports := make(map[string]*TlsReport)
+	for _, p := range policies {
+		domain := p.Policy.PolicyDomain
+		report, found := reports[domain]
+		if !found {
+			report = &TlsReport{
+				OrganizationName: organization,
+				ContactInfo:      contact,
+				ReportId:         fmt.Sprintf("%s_%s", start.Format("2006-01-02T15:04:05Z"), domain),
+			}
+			report.DateRange.StartDatetime = start
+			report.DateRange.EndDatetime = end
+			reports[domain] = report
+		}
+		report.Policies = append(report.Policies, *p)
+	}
+	return reports
+}
+
+// LookupTlsRpt returns the reporting URIs (rua) of the domain from its _smtp._tls TXT record
+func LookupTlsRpt(domain string) ([]string, error) {
+	records, err := net.LookupTXT("_smtp._tls." + strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return nil, err
+	}
+	return parseTlsRptRecord(records)
+}
+
+func parseTlsRptRecord(records []string) ([]string, error) {
+	for _, record := range records {
+		if !strings.HasPrefix(record, "v=TLSRPTv1") {
+			continue
+		}
+		for _, field := range strings.Split(record, ";") {
+			field = strings.TrimSpace(field)
+			if !strings.HasPrefix(field, "rua=") {
+				continue
+			}
+			rua := []string{}
+			for _, uri := range strings.Split(field[len("rua="):], ",") {
+				if uri = strings.TrimSpace(uri); uri != "" {
+					rua = append(rua, uri)
+				}
+			}
+			return rua, nil
+		}
+	}
+	return nil, errors.New("no TLSRPT record")
+}
+
+// SendTlsReport posts the gzipped report to an https reporting URI (RFC 8460 section 5.2)
+func SendTlsReport(report *TlsReport, uri string) error {
+	if !strings.HasPrefix(uri, "https://") {
+		return fmt.Errorf("unsupported reporting URI: '%s'", uri)
+	}
+
+	body, err := gzipReport(report)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Post(uri, "application/tlsrpt+gzip", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sending TLS report: %s", resp.Status)
+	}
+	return nil
+}
+
+// gzipReport returns the gzipped JSON of the report
+func gzipReport(report *TlsReport) ([]byte, error) {
+	body := &bytes.Buffer{}
+	writer := gzip.NewWriter(body)
+	if err := json.NewEncoder(writer).Encode(report); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// TlsReportMessage returns the message which sends the report of the domain
+// to a mailto reporting URI (RFC 8460 section 5.3), hostname is the submitter
+func TlsReportMessage(report *TlsReport, domain, hostname, from, to string) ([]byte, error) {
+	gzipped, err := gzipReport(report)
+	if err != nil {
+		return nil, err
+	}
+	boundary := helpers.NewId()
+	clean := strings.NewReplacer("\r", "", "\n", "")
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "From: <%s>\r\n", clean.Replace(from))
+	fmt.Fprintf(b, "To: <%s>\r\n", clean.Replace(to))
+	fmt.Fprintf(b, "Subject: Report Domain: %s Submitter: %s Report-ID: <%s>\r\n", domain, hostname, clean.Replace(report.ReportId))
+	fmt.Fprintf(b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(b, "Message-ID: %s\r\n", helpers.NewMessageId(hostname))
+	fmt.Fprintf(b, "TLS-Report-Domain: %s\r\n", domain)
+	fmt.Fprintf(b, "TLS-Report-Submitter: %s\r\n", hostname)
+	fmt.Fprintf(b, "Auto-Submitted: auto-generated\r\n")
+	fmt.Fprintf(b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(b, "Content-Type: multipart/report; report-type=\"tlsrpt\";\r\n\tboundary=\"%s\"\r\n\r\n", boundary)
+
+	fmt.Fprintf(b, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n", boundary)
+	fmt.Fprintf(b, "This is an aggregate TLS report for %s from %s.\r\n\r\n", domain, hostname)
+
+	fmt.Fprintf(b, "--%s\r\nContent-Type: application/tlsrpt+gzip\r\n", boundary)
+	fmt.Fprintf(b, "Content-Transfer-Encoding: base64\r\n")
+	fmt.Fprintf(b, "Content-Disposition: attachment;\r\n\tfilename=\"%s!%s!%d!%d.json.gz\"\r\n\r\n", hostname, domain,
+		report.DateRange.StartDatetime.Unix(), report.DateRange.EndDatetime.Unix())
+	encoded := base64.StdEncoding.EncodeToString(gzipped)
+	for len(encoded) > 76 {
+		fmt.Fprintf(b, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(b, "%s\r\n", encoded)
+	fmt.Fprintf(b, "--%s--\r\n", boundary)
+	return b.Bytes(), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTlsRpt(t *testing.T) {
+
+	Convey("Testing TlsRptCollector", t, func() {
+		c := TlsRptCollector{}
+		c.Success("example.com", PolicySts, []string{"version: STSv1", "mode: enforce"}, "mx.example.com")
+		c.Success("Example.com.", PolicySts, nil, "mx.example.com")
+		c.Failure("example.com", PolicySts, nil, "mx2.example.com", TlsFailureDetails{ResultType: "certificate-expired", ReceivingMxHostname: "mx2.example.com"})
+		c.Failure("example.com", PolicySts, nil, "mx2.example.com", TlsFailureDetails{ResultType: "certificate-expired", ReceivingMxHostname: "mx2.example.com"})
+		c.Failure("example.org", PolicyTlsa, nil, "mx.example.org", TlsFailureDetails{ResultType: "tlsa-invalid"})
+
+		reports := c.Reports("GoPistolet", "mailto:postmaster@example.net")
+		So(len(reports), ShouldEqual, 2)
+
+		report := reports["example.com"]
+		So(report.OrganizationName, ShouldEqual, "GoPistolet")
+		So(len(report.Policies), ShouldEqual, 1)
+		p := report.Policies[0]
+		So(p.Policy.PolicyType, ShouldEqual, PolicySts)
+		So(p.Policy.MxHost, ShouldResemble, []string{"mx.example.com", "mx2.example.com"})
+		So(p.Summary.TotalSuccessfulSessionCount, ShouldEqual, 2)
+		So(p.Summary.TotalFailureSessionCount, ShouldEqual, 2)
+		So(len(p.FailureDetails), ShouldEqual, 1)
+		So(p.FailureDetails[0].FailedSessionCount, ShouldEqual, 2)
+
+		// a new period is started
+		So(len(c.Reports("GoPistolet", "")), ShouldEqual, 0)
+	})
+
+	Convey("Testing parseTlsRptRecord()", t, func() {
+		rua, err := parseTlsRptRecord([]string{"v=spf1 -all", "v=TLSRPTv1; rua=mailto:reports@example.com,https://reporting.example.com/v1/tlsrpt"})
+		So(err, ShouldEqual, nil)
+		So(rua, ShouldResemble, []string{"mailto:reports@example.com", "https://reporting.example.com/v1/tlsrpt"})
+
+		_, err = parseTlsRptRecord([]string{"v=spf1 -all"})
+		So(err, ShouldNotEqual, nil)
+	})
+
+	Convey("Testing SendTlsReport() and ParseTlsReport()", t, func() {
+		var received *TlsReport
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			received, _ = ParseTlsReport(data)
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer server.Close()
+
+		// the test server's certificate isn't trusted by default
+		transport := http.DefaultTransport
+		http.DefaultTransport = server.Client().Transport
+		defer func() { http.DefaultTransport = transport }()
+
+		report := &TlsReport{OrganizationName: "GoPistolet", ReportId: "1"}
+		So(SendTlsReport(report, server.URL), ShouldEqual, nil)
+		So(received, ShouldNotEqual, nil)
+		So(received.OrganizationName, ShouldEqual, "GoPistolet")
+
+		So(SendTlsReport(report, "mailto:reports@example.com"), ShouldNotEqual, nil)
+
+		// plain JSON
+		data, _ := json.Marshal(report)
+		parsed, err := ParseTlsReport(data)
+		So(err, ShouldEqual, nil)
+		So(parsed.ReportId, ShouldEqual, "1")
+
+		// gzipped JSON
+		buffer := &bytes.Buffer{}
+		writer := gzip.NewWriter(buffer)
+		writer.Write(data)
+		writer.Close()
+		parsed, err = ParseTlsReport(buffer.Bytes())
+		So(err, ShouldEqual, nil)
+		So(parsed.ReportId, ShouldEqual, "1")
+	})
+
+}
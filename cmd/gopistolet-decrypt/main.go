@@ -0,0 +1,59 @@
+// Command gopistolet-decrypt decrypts messages which GoPistolet stored encrypted in a maildir,
+// for a mail filter of the mailbox server or to read them by hand:
+//
+//	gopistolet-decrypt -key /etc/gopistolet/mail.key [file ...]
+//
+// It decrypts stdin without files. Messages which aren't encrypted are written as they are.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/gopistolet/gopistolet/helpers"
+)
+
+func main() {
+	keyFile := flag.String("key", "", "file with the encryption key")
+	flag.Parse()
+
+	encryption := &helpers.Encryption{KeyFile: *keyFile}
+	if *keyFile != "" {
+		if err := encryption.Load(); err != nil {
+			fail(err)
+		}
+	}
+
+	if flag.NArg() == 0 {
+		decrypt(encryption, "-")
+	}
+	for _, file := range flag.Args() {
+		decrypt(encryption, file)
+	}
+}
+
+// decrypt writes the decrypted file (- is stdin) to stdout
+func decrypt(encryption *helpers.Encryption, file string) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		fail(err)
+	}
+	data, err = encryption.Open(data)
+	if err != nil {
+		fail(fmt.Errorf("%s: %v", file, err))
+	}
+	os.Stdout.Write(data)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "gopistolet-decrypt:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,60 @@
+// Command gopistolet-sendmail reads a message from stdin and injects it through the control socket
+// of GoPistolet, so cron jobs and legacy programs which call sendmail can send mail:
+//
+//	gopistolet-sendmail [-t] [-i] [-f sender] [-F name] [recipient ...]
+//
+// The control socket is $GOPISTOLET_CONTROL_SOCKET, or /var/run/gopistolet/control.sock.
+// The exit codes are the ones of sendmail (sysexits.h).
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+
+	"github.com/gopistolet/gopistolet/control"
+	"github.com/gopistolet/gopistolet/sendmail"
+)
+
+// Exit codes of sendmail
+const (
+	exitUsage    = 64
+	exitDataErr  = 65
+	exitTempFail = 75
+)
+
+func main() {
+	options, err := sendmail.Parse(os.Args[1:])
+	if err != nil {
+		fail(exitUsage, err)
+	}
+	message, err := options.Read(os.Stdin)
+	if err != nil {
+		fail(exitDataErr, err)
+	}
+	message.User = username()
+
+	socket := os.Getenv("GOPISTOLET_CONTROL_SOCKET")
+	if socket == "" {
+		socket = control.DefaultSocket
+	}
+	if _, err := control.Inject(socket, message); err != nil {
+		fail(exitTempFail, err)
+	}
+}
+
+// username returns the name of the user who runs the command
+func username() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	if name := os.Getenv("LOGNAME"); name != "" {
+		return name
+	}
+	return os.Getenv("USER")
+}
+
+func fail(code int, err error) {
+	fmt.Fprintln(os.Stderr, "gopistolet-sendmail:", err)
+	os.Exit(code)
+}
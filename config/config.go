@@ -0,0 +1,586 @@
+package config
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gopistolet/gopistolet/client"
+	"github.com/gopistolet/gopistolet/dkim"
+	"github.com/gopistolet/gopistolet/events"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/plugin"
+	"github.com/gopistolet/gopistolet/script"
+	"github.com/gopistolet/gopistolet/spool"
+	"github.com/gopistolet/gopistolet/user"
+	"github.com/gopistolet/smtp/mta"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// Config contains all GoPistolet settings.
+// The MTA config is embedded, so its fields are at the top level of the config file
+// and it can be handed to the MTA as is.
+type Config struct {
+	mta.Config
+
+	// LogLevel is debug (default), info, warn or error
+	LogLevel string
+
+	// Listeners with their own address and role, sharing the queue, the users and the handlers.
+	// Without listeners, GoPistolet listens on Ip and Port as MTA.
+	Listeners []Listener
+
+	// Domains for which mail is delivered locally, each with their own users
+	LocalDomains helpers.LocalDomains
+
+	// Storage used by the mailboxes, for the quotas of the local domains
+	MailboxUsage helpers.MailboxUsage
+
+	// Sidecar record of the delivery outcomes of submitted messages, per sender
+	DeliveryStatus helpers.DeliveryStatus
+
+	// DSN parameters (NOTIFY, RET, ...) of the transactions, for the success notifications
+	Dsn helpers.DsnRequests `json:"-"`
+
+	// Mailbox which receives the mail for <postmaster> and postmaster@<local domain>,
+	// these recipients are always accepted (RFC 5321 section 4.5.1)
+	Postmaster string
+
+	// Delimiter between user and tag in subaddresses (e.g. + for bob+lists@example.com),
+	// subaddresses are delivered to the mailbox of the user
+	RecipientDelimiter string
+
+	// Which clients may connect, relay and skip the spam checks
+	Access helpers.AccessLists
+
+	// Client certificates which authenticate users with AUTH EXTERNAL on TLS listeners
+	ClientCerts helpers.ClientCerts
+
+	// Upstream proxies (e.g. a Postfix in front of GoPistolet) which may report the client of their sessions
+	// with XCLIENT, its address, name, HELO and login replace the ones of the proxy
+	XclientHosts helpers.Networks
+
+	// Postfix style access rules for clients, senders and recipients
+	AccessRules      []AccessRule
+	accessRulesMutex sync.RWMutex
+
+	// Act as Message Submission Agent for clients which may relay:
+	// add missing Message-ID, Date and From header fields (RFC 6409 section 8)
+	Submission bool
+
+	// Aliases which expand recipients to other recipients or pipes
+	Aliases helpers.Aliases
+
+	// Reloads of the config file and the tables at runtime
+	Reload Reload
+
+	// Users which may authenticate, and where they come from
+	Users Users
+
+	// Encryption of the queued and delivered messages on disk
+	Encryption helpers.Encryption
+
+	// Mailing lists, keyed by list address
+	Lists map[string]List
+
+	// Header rewriting for outbound messages (from clients which may relay)
+	Rewrite Rewrite
+
+	// Footers added to outbound messages, keyed by sender domain
+	Footers map[string]Footer
+
+	// Mail from these domains (and their subdomains) skips the spam scoring if it passes SPF
+	Partners []string
+
+	// Mail from or to these domains (and their subdomains) is only accepted over TLS,
+	// MAIL and RCPT get 530 in sessions which didn't use STARTTLS
+	TlsRequired []string
+
+	// Number of messages and recipients a client IP may send
+	RateLimits helpers.RateLimits
+
+	// Verification of the senders of incoming mail with SMTP callouts
+	Callout Callout
+
+	// Where the rate limits are counted, so the frontends of a cluster enforce them together
+	SharedState SharedState
+
+	// Client IPs which delivered accepted mail or authenticated recently
+	Reputation helpers.Reputation
+
+	// Shedding of the connections of unknown clients when the connection limit is reached
+	Shedding helpers.ConnectionShedding
+
+	// DNS blocklists which are checked for every connecting IP
+	Dnsbl helpers.Dnsbl
+
+	// Detection of DNS resolver outages
+	DnsHealth helpers.DnsHealth
+
+	// Watchdog for the free space on the mailstore and maildir volumes
+	DiskWatchdog helpers.DiskWatchdog
+
+	// Refusal of new sessions and new mail while the queue, the disk or the memory is overloaded
+	Backpressure helpers.Backpressure
+
+	// DKIM keys of the local domains, with their rotation.
+	// Outbound messages from these domains are signed, inbound messages are verified.
+	Dkim dkim.KeyStore
+
+	// Public suffix list to find the organizational domain of domain names
+	PublicSuffixList helpers.PublicSuffixList
+
+	// Directory in which messages which crashed a handler are saved (for bug reports)
+	CrashDir string
+
+	// Seconds after which the lookups of the handlers for a message are canceled (0 means no limit)
+	HandlerTimeout int
+
+	// Action for messages with bare CR or LF line endings (SMTP smuggling), only CRLF.CRLF ends the data:
+	// "reject" (default) refuses the message, "normalize" converts its line endings to CRLF
+	Smuggling string
+
+	// Refuse MAIL from clients which didn't greet with HELO or EHLO (503), and greetings
+	// which aren't a domain or address literal (501). Trusted clients are exempt.
+	StrictHelo bool
+
+	// Maximum number of recipients of a transaction, further RCPTs get 452 (0 means 100,
+	// the minimum a server has to accept, RFC 5321 section 4.5.3.1.8)
+	MaxRecipients int
+
+	// Number of error replies (syntax errors, unknown commands, refused recipients...) after which
+	// the session is closed with 421 (0 means 20)
+	MaxErrors int
+
+	// Unauthenticated clients get the same generic reply (550 5.7.1) for unknown recipients and for senders
+	// and recipients refused by a policy, so they can't find out which users exist. The reason is logged.
+	PrivateReplies bool
+
+	// Maximum number of Received header fields, messages with more or which already passed
+	// this server are bounced as mail loops (0 means 25)
+	MaxHops int
+
+	// Virus scanning with clamd
+	ClamAV ClamAV
+
+	// Spam score of the messages and its thresholds
+	Spam Spam
+
+	// Tests of the spam scanners of the transactions, summed up in the X-Spam-Score header field
+	SpamScores helpers.SpamScores `json:"-"`
+	// SPF and DKIM results of the transactions, for the DMARC check
+	AuthResults helpers.AuthResults `json:"-"`
+
+	// Events in the life of the connections and messages, for the features which follow them
+	Events events.Bus `json:"-"`
+
+	// Transactions whose message couldn't be stored, they get a temporary failure instead of 250,
+	// and the recipients which refused the message of a PRDR transaction
+	Acceptance helpers.Acceptance `json:"-"`
+
+	// Clients which upstream proxies reported with XCLIENT
+	Xclient helpers.XclientSessions `json:"-"`
+
+	// Users which authenticated with AUTH in the sessions
+	Logins helpers.Logins `json:"-"`
+
+	// TLS connection states of the sessions which use TLS
+	Tls helpers.TlsSessions `json:"-"`
+
+	// Lockout of the client IPs and the users after failed AUTH attempts
+	AuthLockout helpers.AuthLockout
+
+	// Address to which other servers send their SMTP TLS reports (RFC 8460)
+	TlsRptAddress string
+
+	// Evaluation of a candidate configuration alongside this one
+	Shadow Shadow
+
+	// Admin HTTP listener with the profiling endpoints
+	Admin Admin
+
+	// Unix socket on which local programs (e.g. gopistolet-sendmail) inject messages
+	Control Control
+
+	// Machine-readable log of the transactions
+	Audit Audit
+
+	// Debug mode which records the exchanges of the sessions, with the credentials redacted
+	Transcripts helpers.Transcripts
+
+	// Out-of-process plugins which are called in the SMTP phases and get the events
+	Plugins plugin.Plugins
+
+	// Scripts which decide on the recipients, the header fields and the routes
+	Scripts script.Hooks
+
+	// Texts of the SMTP replies and the bounces, to brand and translate them
+	Catalog helpers.Catalog
+
+	// Queue statistics
+	Queue Queue
+
+	// Store-and-forward relay of mail for remote recipients
+	Forward Forward
+
+	// Source IPs of the outbound connections, by sender
+	SourceIps helpers.SourceIps
+
+	// Policies of the outbound deliveries
+	Outbound Outbound
+
+	// Sender Rewriting Scheme for mail forwarded from remote senders, and the reversal of its bounces
+	Srs helpers.Srs
+}
+
+// Actions for messages with bare line endings (Smuggling)
+const (
+	SmugglingReject    = "reject"
+	SmugglingNormalize = "normalize"
+)
+
+// Roles of the listeners
+const (
+	// RoleMTA receives mail from other servers (port 25)
+	RoleMTA = "mta"
+	// RoleSubmission receives mail from clients (port 587), which have to authenticate unless they're
+	// in the relay networks, the DNS blocklists aren't checked
+	RoleSubmission = "submission"
+	// RoleSubmissions is submission with implicit TLS (port 465)
+	RoleSubmissions = "submissions"
+)
+
+// Listener is an address on which GoPistolet accepts mail in a role
+type Listener struct {
+	// Ip is an IPv4 or IPv6 address, empty (or ::) listens on all addresses of both families
+	Ip   string
+	Port uint32
+	// Role is mta (default), submission or submissions
+	Role string
+	// Interface listens on the addresses of the network interface (e.g. eth0) instead of Ip
+	Interface string
+	// Family limits the listener to ipv4 or ipv6, both are used if it's empty (dual-stack)
+	Family string
+}
+
+// AllListeners returns the Listeners, or an MTA listener on Ip and Port if there are none
+func (c *Config) AllListeners() []Listener {
+	if len(c.Listeners) == 0 {
+		return []Listener{{Ip: c.Ip, Port: c.Port, Role: RoleMTA}}
+	}
+	return c.Listeners
+}
+
+// MayRelay reports whether the client of the message may relay:
+// it's in the relay networks, or it authenticated with AUTH in its session
+func (c *Config) MayRelay(state *smtp.State) bool {
+	if c.Access.MayRelay(state.Ip) {
+		return true
+	}
+	_, found := c.Logins.Get(state.SessionId.String())
+	return found
+}
+
+// RequiresTls reports whether mail from or to the domain is only accepted over TLS
+func (c *Config) RequiresTls(domain string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return false
+	}
+	for _, required := range c.TlsRequired {
+		required = strings.TrimSuffix(strings.ToLower(required), ".")
+		if domain == required || strings.HasSuffix(domain, "."+required) {
+			return true
+		}
+	}
+	return false
+}
+
+// Forward contains the settings of the store-and-forward relay, for sites which are only
+// connected some of the time. Mail for remote recipients (from clients which may relay)
+// is queued in the spool directory and relayed to the smarthost when the link is up.
+type Forward struct {
+	// Smarthost (host:port) to which mail is relayed, store-and-forward is disabled if it's empty.
+	// With "mx" the mail is delivered to the MX hosts of the recipient domains (see Outbound).
+	Smarthost string
+	// Windows in which the link is up, like "22:00-06:00" (local time)
+	Windows []string
+	// Transports relay the mail for some domains to other hosts than the smarthost
+	Transports helpers.Transports
+	// Probe is an address (host:port) which is dialed to check whether the link is up outside the windows.
+	// Without windows and probe the link is always up.
+	Probe string
+	// Interval between two attempts to flush the queue in seconds (default 60)
+	Interval int
+	// A warning is logged when more than AlarmMessages messages are queued (0 disables the alarm)
+	AlarmMessages int
+	// Workers is the number of messages which are relayed in parallel (default 1)
+	Workers int
+	// DomainConcurrency limits the parallel deliveries to each recipient domain (0 is unlimited)
+	DomainConcurrency int
+	// Priorities relay high priority mail first and throttle bulk mail
+	Priorities helpers.Priorities
+}
+
+// Outbound contains the policies of the outbound deliveries. MTA-STS, DANE and the TLS reports
+// apply to the delivery to the MX hosts of the recipient domains: the mail of the "mx" transport,
+// and the messages GoPistolet sends itself without smarthost.
+type Outbound struct {
+	// Circuit breaker which pauses the deliveries to hosts which keep failing (MX hosts, smarthost and transports)
+	Breaker client.CircuitBreaker
+	// MTA-STS policies of the recipient domains (RFC 8461): with an enforced policy, mail is only
+	// delivered to the MX hosts it lists, over STARTTLS with a valid certificate
+	MtaSts client.MtaSts
+	// DANE (RFC 7672): MX hosts with DNSSEC validated TLSA records only get the mail
+	// over STARTTLS with a certificate which matches them
+	Dane client.Dane
+	// TLS reports (RFC 8460) of these deliveries, which are sent daily to the recipient domains that ask for them
+	TlsRpt TlsReporting
+}
+
+// TlsReporting contains the settings of the TLS reports which GoPistolet sends
+type TlsReporting struct {
+	// Organization which sends the reports, no reports are collected if it's empty
+	Organization string
+	// Contact address in the reports, the reports sent by mail come from it (default postmaster@Hostname)
+	Contact string
+
+	// Results of the TLS sessions since the last reports
+	Collector client.TlsRptCollector `json:"-"`
+}
+
+// Queue contains the settings of the queue and its statistics
+type Queue struct {
+	// Spool directory of the queue (default mailstore)
+	Dir string
+	// Backend is files (default, a JSON file per message), bolt (an embedded database in Dir,
+	// with atomic updates, the retry state and fast scans) or redis (a Redis server which
+	// several instances share, they lease the messages they relay)
+	Backend string
+	// RedisServer of the redis backend
+	RedisServer
+	// Seconds for which an instance leases a message it relays from the redis backend (default 600),
+	// another instance relays it after the lease expired if the instance died
+	LeaseTimeout int
+	// Interval between two snapshots of the queue in seconds (default 60)
+	SnapshotInterval int
+
+	// Store is the opened store of the queued messages (see Open)
+	Store spool.Store `json:"-"`
+}
+
+// Open opens the store of the Backend as Store
+func (q *Queue) Open() error {
+	if q.Backend == spool.Redis {
+		store, err := spool.OpenRedis(q.Address, q.Password, q.Database, q.prefix())
+		if err != nil {
+			return err
+		}
+		q.Store = store
+		return nil
+	}
+	dir := q.Dir
+	if dir == "" {
+		dir = "mailstore"
+	}
+	store, err := spool.Open(q.Backend, dir)
+	if err != nil {
+		return err
+	}
+	q.Store = store
+	return nil
+}
+
+// Backends of the shared state
+const (
+	StateMemory = "memory"
+	StateRedis  = "redis"
+)
+
+// SharedState contains where the policy state (the rate limits of the clients and the callouts) is kept:
+// in memory (default), or in a Redis server which the frontends behind a load balancer share
+type SharedState struct {
+	Backend string
+	RedisServer
+
+	// State is the opened state (see Open), it's nil for the memory backend
+	State helpers.State `json:"-"`
+}
+
+// RedisServer contains the Redis server of the redis backends
+type RedisServer struct {
+	// Address of the server (host:port), its Password and the number of the Database
+	Address  string
+	Password string
+	Database int
+	// Prefix of the keys (default gopistolet:)
+	Prefix string
+}
+
+func (r *RedisServer) prefix() string {
+	if r.Prefix == "" {
+		return "gopistolet:"
+	}
+	return r.Prefix
+}
+
+// Open opens the state of the Backend as State. The state is opened even if the Redis server
+// can't be reached, the limits aren't enforced until it's back.
+func (s *SharedState) Open() error {
+	if s.Backend != StateRedis {
+		return nil
+	}
+	state := helpers.NewRedisState(s.Address, s.Password, s.Database, s.prefix())
+	s.State = state
+	return state.Ping()
+}
+
+// Audit contains the settings of the audit log
+type Audit struct {
+	// File to which a JSON record per transaction is appended, the audit log is disabled if it's empty
+	File string
+}
+
+// Admin contains the settings of the admin HTTP listener
+type Admin struct {
+	// Address to listen on (e.g. 127.0.0.1:8025), the listener is disabled if it's empty.
+	// There's no authentication, so it shouldn't be reachable from the internet.
+	Address string
+}
+
+// Control contains the settings of the control socket
+type Control struct {
+	// Socket is the path of the Unix socket, the control socket is disabled if it's empty.
+	// Every local user may inject messages (like with sendmail), they're handled like mail from 127.0.0.1.
+	Socket string
+}
+
+// Shadow contains the settings for evaluating a candidate configuration on live traffic
+type Shadow struct {
+	// Config is the candidate configuration file, shadow evaluation is disabled if it's empty
+	Config string
+	// Report is the file to which the decisions that would have differed are appended
+	Report string
+}
+
+// List is a simple mailing list
+type List struct {
+	Members []string
+	// Owner receives the bounces and is added as List-Owner
+	Owner string
+	// SubjectPrefix is added to the subject (e.g. [team]) unless it's already in there
+	SubjectPrefix string
+}
+
+// Rewrite contains the header rewriting rules for outbound messages,
+// they're applied to the envelope sender and the From, Sender, Reply-To and Return-Path header fields
+type Rewrite struct {
+	// Canonical maps sender addresses to their canonical address (e.g. bob@example.com: robert.smith@example.com)
+	Canonical map[string]string
+	// Addresses in subdomains of these domains are masqueraded as the domain (user@host.example.com becomes user@example.com)
+	Masquerade []string
+	// Header fields which are removed, a name ending in * matches all fields starting with the rest of the name (e.g. X-Internal-*).
+	// Stripping Received removes all Received fields, including the one added by GoPistolet.
+	Strip []string
+}
+
+// Callout contains the settings of the sender verification: the MX hosts of the sender's domain are asked
+// whether they accept mail for the sender, and RCPT is refused for senders which they refuse.
+// Mail from trusted clients, clients which may relay and local senders isn't verified.
+type Callout struct {
+	Enabled bool
+	// Seconds for which the result for a sender is cached (0 disables the cache)
+	CacheTTL int
+	// Callouts per sender domain, and in total (key ""), senders over the limits aren't verified.
+	// Both limits are required, so GoPistolet can't be used to flood other servers with callouts.
+	Domains helpers.RateLimiter
+	Total   helpers.RateLimiter
+}
+
+// Footer is a disclaimer which is added to the text of outbound messages
+type Footer struct {
+	// Text is added to text/plain parts
+	Text string
+	// Html is added to text/html parts, the escaped Text is used if it's empty
+	Html string
+}
+
+// ClamAV contains the settings of the clamd virus scanner
+type ClamAV struct {
+	// Network ("tcp" or "unix") and Address of clamd, scanning is disabled if there is no address
+	Network string
+	Address string
+	// Timeout in seconds
+	Timeout int
+	// Action for infected messages: "reject" (default) refuses them in the reply to DATA,
+	// "quarantine" accepts them and stores them in the Quarantine maildir instead of delivering them
+	// and "tag" delivers them with a header field and the virus score
+	Action string
+	// Maildir in which infected messages are quarantined
+	Quarantine string
+	// Messages which these authenticated users submit aren't scanned
+	BypassUsers []string
+	// Seconds for which the verdict for a message body is cached,
+	// so identical bulk messages are only scanned once (0 disables the cache)
+	CacheTTL int
+}
+
+// Spam contains the scores of the scanners and the thresholds for the spam score of a message.
+// Users can have their own thresholds in the user store, which are applied when the message is delivered.
+type Spam struct {
+	// Score from which X-Spam-Status is Yes and the message is flagged with X-Spam-Flag (0 means 5)
+	TagScore float64
+	// Score from which the message is delivered in the JunkFolder of the mailbox (0 never does)
+	JunkScore float64
+	// Folder for spam (default Junk)
+	JunkFolder string
+	// Score from which the recipient refuses the message in the reply to DATA (0 never does).
+	// Only clients which negotiated PRDR get these replies, the message is delivered for the others.
+	RejectScore float64
+	// Scores of the SPF results (e.g. {"Fail": 5, "SoftFail": 1})
+	SpfScores map[string]float64
+	// Scores of the DKIM results (e.g. {"fail": 3, "none": 0.5})
+	DkimScores map[string]float64
+	// Scores of the DMARC results (e.g. {"fail": 4}), a failure of a domain whose policy
+	// is quarantine or reject gets the score of quarantine or reject if there is one
+	DmarcScores map[string]float64
+	// Score of viruses which ClamAV found, if they are tagged
+	VirusScore float64
+}
+
+// Thresholds returns the tag and junk scores, with the thresholds of the user if it has them
+func (s *Spam) Thresholds(u *user.User) (tag, junk float64) {
+	tag, junk = s.TagScore, s.JunkScore
+	if u != nil && u.SpamTagScore > 0 {
+		tag = u.SpamTagScore
+	}
+	if u != nil && u.SpamJunkScore > 0 {
+		junk = u.SpamJunkScore
+	}
+	if tag <= 0 {
+		tag = 5
+	}
+	return tag, junk
+}
+
+// RejectThreshold returns the score from which the user refuses the message, 0 if it never does
+func (s *Spam) RejectThreshold(u *user.User) float64 {
+	if u != nil && u.SpamRejectScore > 0 {
+		return u.SpamRejectScore
+	}
+	return s.RejectScore
+}
+
+// AccessRule is a Postfix style access rule, it matches if all of its (non-empty) keys match.
+//
+// Client is an IP or CIDR, Helo a domain.
+// From and To are an address (user@example.com), a domain (example.com, also matching subdomains),
+// or a local part (user@).
+type AccessRule struct {
+	Client string
+	Helo   string
+	From   string
+	To     string
+
+	// Action is OK, REJECT, DEFER or REDIRECT <address>
+	Action string
+}
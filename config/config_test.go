@@ -0,0 +1,38 @@
+package config
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequiresTls(t *testing.T) {
+
+	Convey("Testing RequiresTls", t, func() {
+		c := &Config{TlsRequired: []string{"Partner.example."}}
+
+		So(c.RequiresTls("partner.example"), ShouldEqual, true)
+		So(c.RequiresTls("mail.PARTNER.example"), ShouldEqual, true)
+		So(c.RequiresTls("otherpartner.example"), ShouldEqual, false)
+		So(c.RequiresTls("example.org"), ShouldEqual, false)
+		// the null sender
+		So(c.RequiresTls(""), ShouldEqual, false)
+	})
+
+	Convey("Testing MayRelay", t, func() {
+		c := &Config{}
+		So(json.Unmarshal([]byte(`{"Relay": ["192.0.2.0/24"]}`), &c.Access), ShouldEqual, nil)
+
+		So(c.MayRelay(&smtp.State{Ip: net.ParseIP("192.0.2.1")}), ShouldEqual, true)
+		state := &smtp.State{Ip: net.ParseIP("198.51.100.1"), SessionId: smtp.Id{Timestamp: 1, Counter: 1}}
+		So(c.MayRelay(state), ShouldEqual, false)
+		// the client authenticated with AUTH
+		c.Logins.Set(state.SessionId.String(), "bob")
+		So(c.MayRelay(state), ShouldEqual, true)
+	})
+
+}
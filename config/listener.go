@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// Address families of the listeners
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// Network returns the network the listener listens on: tcp4, tcp6 or tcp for both families.
+// With tcp6 the wildcard address only accepts IPv6 connections.
+func (l *Listener) Network() string {
+	switch l.Family {
+	case FamilyIPv4:
+		return "tcp4"
+	case FamilyIPv6:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// Addresses returns the addresses (host:port) the listener binds to:
+// the address of Ip, or those of the Interface in the Family.
+// Link-local IPv6 addresses of an interface get its zone (fe80::1%eth0).
+func (l *Listener) Addresses() ([]string, error) {
+	port := fmt.Sprint(l.Port)
+	if l.Interface == "" {
+		return []string{net.JoinHostPort(l.Ip, port)}, nil
+	}
+
+	iface, err := net.InterfaceByName(l.Interface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", l.Interface, err)
+	}
+	addresses := []string{}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !l.accepts(ipNet.IP) {
+			continue
+		}
+		host := ipNet.IP.String()
+		if ipNet.IP.To4() == nil && ipNet.IP.IsLinkLocalUnicast() {
+			host += "%" + iface.Name
+		}
+		addresses = append(addresses, net.JoinHostPort(host, port))
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("interface %s has no addresses to listen on", l.Interface)
+	}
+	return addresses, nil
+}
+
+// accepts reports whether the IP is in the Family of the listener
+func (l *Listener) accepts(ip net.IP) bool {
+	switch l.Family {
+	case FamilyIPv4:
+		return ip.To4() != nil
+	case FamilyIPv6:
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// validate checks the address of the listener
+func (l *Listener) validate() error {
+	switch l.Family {
+	case "", FamilyIPv4, FamilyIPv6:
+	default:
+		return fmt.Errorf("Family should be ipv4 or ipv6, not %q", l.Family)
+	}
+	if l.Interface != "" && l.Ip != "" {
+		return fmt.Errorf("Ip and Interface can't be combined")
+	}
+	if l.Ip == "" {
+		return nil
+	}
+	ip := net.ParseIP(l.Ip)
+	if ip == nil {
+		return fmt.Errorf("Ip %q is not an IP address", l.Ip)
+	}
+	// the unspecified address :: accepts both families
+	if !ip.IsUnspecified() && !l.accepts(ip) {
+		return fmt.Errorf("Ip %s isn't an %s address", l.Ip, l.Family)
+	}
+	return nil
+}
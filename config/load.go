@@ -0,0 +1,389 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gopistolet/gopistolet/dkim"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/spool"
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads the config file into c and validates it.
+// The format follows from the extension: .yaml or .yml for YAML, .toml for TOML, JSON otherwise.
+// All formats use the field names of the JSON config, unknown fields are an error.
+func Load(file string, c *Config) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("could not open config: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		var tree interface{}
+		if err := yaml.Unmarshal(data, &tree); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if data, err = toJSON(tree); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	case ".toml":
+		var tree interface{}
+		if _, err := toml.Decode(string(data), &tree); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if data, err = toJSON(tree); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+
+	if err := decodeJSON(data, c); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	return nil
+}
+
+// toJSON converts a decoded YAML or TOML document to JSON, so it's decoded like a JSON config
+func toJSON(tree interface{}) ([]byte, error) {
+	if tree == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(stringKeys(tree))
+}
+
+// stringKeys converts the map[interface{}]interface{} of YAML to map[string]interface{}
+func stringKeys(tree interface{}) interface{} {
+	switch tree := tree.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(tree))
+		for key, value := range tree {
+			m[fmt.Sprint(key)] = stringKeys(value)
+		}
+		return m
+	case map[string]interface{}:
+		for key, value := range tree {
+			tree[key] = stringKeys(value)
+		}
+	case []interface{}:
+		for i, value := range tree {
+			tree[i] = stringKeys(value)
+		}
+	case []map[string]interface{}:
+		list := make([]interface{}, len(tree))
+		for i, value := range tree {
+			list[i] = stringKeys(value)
+		}
+		return list
+	}
+	return tree
+}
+
+// decodeJSON decodes the config, errors contain the line and column in the file
+func decodeJSON(data []byte, c *Config) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(c)
+	if err == nil {
+		return nil
+	}
+
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxError):
+		return fmt.Errorf("%s: %v", position(data, syntaxError.Offset), err)
+	case errors.As(err, &typeError):
+		return fmt.Errorf("%s: %s should be %s, not %s", position(data, typeError.Offset), typeError.Field, typeError.Type, typeError.Value)
+	}
+	return err
+}
+
+// position returns the line and column of the offset
+func position(data []byte, offset int64) string {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	column := offset - int64(bytes.LastIndexByte(data[:offset], '\n'))
+	return fmt.Sprintf("line %d, column %d", line, column)
+}
+
+// Validate checks the settings which can't be checked while decoding,
+// the error lists all problems
+func (c *Config) Validate() error {
+	problems := []string{}
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Hostname == "" {
+		problem("Hostname is empty")
+	}
+	if len(c.Listeners) == 0 {
+		if c.Port == 0 || c.Port > 65535 {
+			problem("Port %d is not a valid port", c.Port)
+		}
+		if c.Ip != "" && net.ParseIP(c.Ip) == nil {
+			problem("Ip %q is not an IP address", c.Ip)
+		}
+	}
+	addresses := make(map[string]bool)
+	for i, listener := range c.Listeners {
+		if listener.Port == 0 || listener.Port > 65535 {
+			problem("Listeners[%d]: Port %d is not a valid port", i, listener.Port)
+		}
+		if err := listener.validate(); err != nil {
+			problem("Listeners[%d]: %v", i, err)
+		}
+		switch listener.Role {
+		case "", RoleMTA, RoleSubmission, RoleSubmissions:
+		default:
+			problem("Listeners[%d]: Role should be mta, submission or submissions, not %q", i, listener.Role)
+		}
+		address := net.JoinHostPort(listener.Ip, fmt.Sprint(listener.Port))
+		if listener.Interface != "" {
+			address = listener.Interface + ":" + fmt.Sprint(listener.Port)
+		}
+		// an IPv4 and an IPv6 listener can share the wildcard address
+		if addresses[listener.Network()+" "+address] {
+			problem("Listeners[%d]: %s is used by another listener", i, address)
+		}
+		addresses[listener.Network()+" "+address] = true
+	}
+
+	domains := make([]string, 0, len(c.LocalDomains))
+	for domain := range c.LocalDomains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		local := c.LocalDomains[domain]
+		if _, err := helpers.DomainToAscii(domain); err != nil || domain == "" || strings.ContainsAny(domain, "@ ") {
+			problem("LocalDomains: %q is not a domain", domain)
+		}
+		if local.Quota < 0 {
+			problem("LocalDomains.%s.Quota is negative", domain)
+		}
+	}
+	footers := make([]string, 0, len(c.Footers))
+	for domain := range c.Footers {
+		footers = append(footers, domain)
+	}
+	sort.Strings(footers)
+	for _, domain := range footers {
+		if footer := c.Footers[domain]; footer.Text == "" && footer.Html == "" {
+			problem("Footers.%s has no Text or Html", domain)
+		}
+	}
+	if c.Postmaster != "" && !strings.Contains(c.Postmaster, "@") {
+		problem("Postmaster %q is not an address", c.Postmaster)
+	}
+	if len(c.RecipientDelimiter) > 1 {
+		problem("RecipientDelimiter %q should be a single character", c.RecipientDelimiter)
+	}
+	if c.MaxRecipients != 0 && c.MaxRecipients < 100 {
+		problem("MaxRecipients %d should be at least 100 (RFC 5321 section 4.5.3.1.8)", c.MaxRecipients)
+	}
+
+	if err := CheckAccessRules(c.AccessRules); err != nil {
+		problem("%v", err)
+	}
+	if err := helpers.CheckAliases(c.Aliases.Map); err != nil {
+		problem("Aliases.Map: %v", err)
+	}
+	if err := c.Forward.Priorities.Check(); err != nil {
+		problem("Forward.Priorities: %v", err)
+	}
+	if err := c.SourceIps.Check(); err != nil {
+		problem("SourceIps: %v", err)
+	}
+	if err := helpers.CheckTransports(c.Forward.Transports.Map); err != nil {
+		problem("Forward.Transports.Map: %v", err)
+	}
+
+	for _, setting := range []struct {
+		name  string
+		value int
+	}{
+		{"RateLimits.Messages.Limit", c.RateLimits.Messages.Limit},
+		{"RateLimits.Messages.Window", c.RateLimits.Messages.Window},
+		{"RateLimits.Recipients.Limit", c.RateLimits.Recipients.Limit},
+		{"RateLimits.Recipients.Window", c.RateLimits.Recipients.Window},
+		{"AuthLockout.MaxFailures", c.AuthLockout.MaxFailures},
+		{"AuthLockout.Window", c.AuthLockout.Window},
+		{"AuthLockout.Lockout", c.AuthLockout.Lockout},
+		{"AuthLockout.MaxLockout", c.AuthLockout.MaxLockout},
+		{"SharedState.Database", c.SharedState.Database},
+		{"Queue.Database", c.Queue.Database},
+		{"Queue.LeaseTimeout", c.Queue.LeaseTimeout},
+		{"Callout.CacheTTL", c.Callout.CacheTTL},
+		{"Dnsbl.CacheTTL", c.Dnsbl.CacheTTL},
+		{"Dnsbl.MaxCacheEntries", c.Dnsbl.MaxCacheEntries},
+		{"Dnsbl.Greylist.Delay", c.Dnsbl.Greylist.Delay},
+		{"Dnsbl.Greylist.Expire", c.Dnsbl.Greylist.Expire},
+		{"Dnsbl.Greylist.Keep", c.Dnsbl.Greylist.Keep},
+		{"DiskWatchdog.Interval", c.DiskWatchdog.Interval},
+		{"Backpressure.MaxQueue", c.Backpressure.MaxQueue},
+		{"Backpressure.ResumeQueue", c.Backpressure.ResumeQueue},
+		{"Backpressure.Interval", c.Backpressure.Interval},
+		{"Shedding.MaxConnections", c.Shedding.MaxConnections},
+		{"Shedding.MaxPerClient", c.Shedding.MaxPerClient},
+		{"MailboxUsage.Rescan", c.MailboxUsage.Rescan},
+		{"Queue.SnapshotInterval", c.Queue.SnapshotInterval},
+		{"Forward.Interval", c.Forward.Interval},
+		{"Reload.Interval", c.Reload.Interval},
+		{"Forward.AlarmMessages", c.Forward.AlarmMessages},
+		{"Forward.Workers", c.Forward.Workers},
+		{"Forward.DomainConcurrency", c.Forward.DomainConcurrency},
+		{"Forward.Priorities.BulkPerFlush", c.Forward.Priorities.BulkPerFlush},
+		{"Srs.MaxAgeDays", c.Srs.MaxAgeDays},
+		{"HandlerTimeout", c.HandlerTimeout},
+		{"MaxHops", c.MaxHops},
+		{"MaxErrors", c.MaxErrors},
+		{"ClamAV.Timeout", c.ClamAV.Timeout},
+		{"ClamAV.CacheTTL", c.ClamAV.CacheTTL},
+		{"Transcripts.MaxFiles", c.Transcripts.MaxFiles},
+		{"Transcripts.Ring", c.Transcripts.Ring},
+		{"Scripts.MaxSteps", c.Scripts.MaxSteps},
+		{"Scripts.Timeout", c.Scripts.Timeout},
+	} {
+		if setting.value < 0 {
+			problem("%s is negative", setting.name)
+		}
+	}
+
+	for i, p := range c.Plugins {
+		if p.Name == "" || len(p.Command) == 0 {
+			problem("Plugins[%d] needs a Name and a Command", i)
+		}
+		if p.Timeout < 0 {
+			problem("Plugins[%d].Timeout is negative", i)
+		}
+	}
+
+	switch c.LogLevel {
+	case "", "debug", "info", "warn", "warning", "error":
+	default:
+		problem("LogLevel should be debug, info, warn or error, not %q", c.LogLevel)
+	}
+
+	if c.Smuggling != "" && c.Smuggling != SmugglingReject && c.Smuggling != SmugglingNormalize {
+		problem("Smuggling should be reject or normalize, not %q", c.Smuggling)
+	}
+
+	if c.Forward.Smarthost != "" && c.Forward.Smarthost != helpers.TransportMx {
+		if _, _, err := net.SplitHostPort(c.Forward.Smarthost); err != nil {
+			problem("Forward.Smarthost %q should be host:port or mx", c.Forward.Smarthost)
+		}
+	}
+	if c.Forward.Probe != "" {
+		if _, _, err := net.SplitHostPort(c.Forward.Probe); err != nil {
+			problem("Forward.Probe %q should be host:port", c.Forward.Probe)
+		}
+	}
+	for _, window := range c.Forward.Windows {
+		var startHour, startMinute, endHour, endMinute int
+		_, err := fmt.Sscanf(strings.TrimSpace(window), "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute)
+		if err != nil || startHour > 23 || endHour > 24 || startMinute > 59 || endMinute > 59 {
+			problem("Forward.Windows: %q should be like 22:00-06:00", window)
+		}
+	}
+
+	if len(c.Srs.Secrets) > 0 && c.Srs.Domain == "" {
+		problem("Srs.Secrets need an Srs.Domain for the rewritten senders")
+	}
+
+	if c.Callout.Enabled && (c.Callout.Domains.Limit <= 0 || c.Callout.Domains.Window <= 0 || c.Callout.Total.Limit <= 0 || c.Callout.Total.Window <= 0) {
+		problem("Callout needs the Domains and Total rate limits")
+	}
+
+	if c.Spam.TagScore < 0 || c.Spam.JunkScore < 0 || c.Spam.RejectScore < 0 {
+		problem("Spam.TagScore, Spam.JunkScore and Spam.RejectScore can't be negative")
+	}
+
+	if c.Admin.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Admin.Address); err != nil {
+			problem("Admin.Address %q should be host:port", c.Admin.Address)
+		}
+	}
+
+	if c.ClamAV.Address != "" && c.ClamAV.Network != "" && c.ClamAV.Network != "tcp" && c.ClamAV.Network != "unix" {
+		problem("ClamAV.Network should be tcp or unix, not %q", c.ClamAV.Network)
+	}
+	for _, list := range c.Dnsbl.Lists {
+		switch list.Action {
+		case "", helpers.DnsblReject, helpers.DnsblGreylist, helpers.DnsblScore:
+		default:
+			problem("Dnsbl action of %s should be reject, greylist or score, not %q", list.Zone, list.Action)
+		}
+	}
+	if c.ClamAV.Action != "" && c.ClamAV.Action != "reject" && c.ClamAV.Action != "quarantine" && c.ClamAV.Action != "tag" {
+		problem("ClamAV.Action should be reject, quarantine or tag, not %q", c.ClamAV.Action)
+	}
+
+	if c.Dkim.Algorithm != "" && c.Dkim.Algorithm != dkim.AlgorithmRSA && c.Dkim.Algorithm != dkim.AlgorithmEd25519 {
+		problem("Dkim.Algorithm should be rsa or ed25519, not %q", c.Dkim.Algorithm)
+	}
+	if c.Dkim.DualSign && c.Dkim.Algorithm == dkim.AlgorithmEd25519 {
+		problem("Dkim.DualSign adds ed25519 signatures to the RSA ones, Dkim.Algorithm should be rsa")
+	}
+	if c.Dkim.Bits != 0 && c.Dkim.Bits < 1024 {
+		problem("Dkim.Bits should be at least 1024")
+	}
+	if c.Dkim.RotateDays < 0 || c.Dkim.OverlapDays < 0 {
+		problem("Dkim.RotateDays and Dkim.OverlapDays can't be negative")
+	}
+
+	if len(c.ClientCerts.Users) > 0 && c.ClientCerts.CAFile == "" {
+		problem("ClientCerts.Users needs a ClientCerts.CAFile to verify the certificates")
+	}
+
+	if err := c.Users.validate(); err != nil {
+		problem("Users: %v", err)
+	}
+
+	switch c.SharedState.Backend {
+	case "", StateMemory:
+	case StateRedis:
+		if c.SharedState.Address == "" {
+			problem("SharedState.Address is needed for the redis backend")
+		}
+	default:
+		problem("SharedState.Backend should be memory or redis, not %q", c.SharedState.Backend)
+	}
+
+	switch c.Queue.Backend {
+	case "", spool.Files, spool.Bolt:
+	case spool.Redis:
+		if c.Queue.Address == "" {
+			problem("Queue.Address is needed for the redis backend")
+		}
+	default:
+		problem("Queue.Backend should be files, bolt or redis, not %q", c.Queue.Backend)
+	}
+
+	if err := c.Catalog.Validate(); err != nil {
+		problem("Catalog: %v", err)
+	}
+
+	if err := c.Scripts.Check(); err != nil {
+		problem("Scripts: %v", err)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
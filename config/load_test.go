@@ -0,0 +1,183 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoad(t *testing.T) {
+
+	Convey("Testing Load", t, func() {
+		dir, err := ioutil.TempDir("", "config")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		write := func(name, content string) string {
+			file := filepath.Join(dir, name)
+			So(ioutil.WriteFile(file, []byte(content), 0600), ShouldEqual, nil)
+			return file
+		}
+
+		Convey("The sample config is valid", func() {
+			c := &Config{}
+			So(Load("../config.sample.json", c), ShouldEqual, nil)
+			So(c.Port, ShouldEqual, 2525)
+			So(c.AllListeners(), ShouldResemble, []Listener{{Port: 2525, Role: RoleMTA}})
+		})
+
+		Convey("YAML and TOML use the same fields", func() {
+			yaml := write("config.yaml", `
+Hostname: mx.example.com
+Port: 25
+LocalDomains:
+  example.com:
+    Users: {bob: ""}
+Access:
+  Relay: [127.0.0.0/8]
+Users:
+  Backend: http
+  HTTP: {URL: "https://users.example.com/"}
+`)
+			c := &Config{}
+			So(Load(yaml, c), ShouldEqual, nil)
+			So(c.Hostname, ShouldEqual, "mx.example.com")
+			So(c.LocalDomains["example.com"].Users, ShouldContainKey, "bob")
+			So(c.Users.HTTP.URL, ShouldEqual, "https://users.example.com/")
+
+			toml := write("config.toml", `
+Hostname = "mx.example.com"
+Port = 25
+
+[LocalDomains."example.com".Users]
+bob = ""
+
+[Forward]
+Windows = ["22:00-06:00"]
+`)
+			c = &Config{}
+			So(Load(toml, c), ShouldEqual, nil)
+			So(c.Port, ShouldEqual, 25)
+			So(c.LocalDomains["example.com"].Users, ShouldContainKey, "bob")
+			So(c.Forward.Windows, ShouldResemble, []string{"22:00-06:00"})
+		})
+
+		Convey("Errors point to the problem", func() {
+			err := Load(write("syntax.json", "{\n  \"Port\": 25,\n}"), &Config{})
+			So(err.Error(), ShouldContainSubstring, "line 3")
+
+			err = Load(write("type.json", "{\n  \"Port\": \"25\"\n}"), &Config{})
+			So(err.Error(), ShouldContainSubstring, "line 2")
+			So(err.Error(), ShouldContainSubstring, "Port should be uint32")
+
+			err = Load(write("unknown.json", `{"Hostname": "localhost", "Prot": 25}`), &Config{})
+			So(err.Error(), ShouldContainSubstring, `unknown field "Prot"`)
+
+			err = Load(write("invalid.json", `{"Hostname": "", "Port": 25, "Smuggling": "ignore",
+				"Forward": {"Windows": ["night"]}, "Users": {"Backend": "nis"}}`), &Config{})
+			So(err.Error(), ShouldContainSubstring, "Hostname is empty")
+			So(err.Error(), ShouldContainSubstring, "Smuggling should be")
+			So(err.Error(), ShouldContainSubstring, `"night"`)
+			So(err.Error(), ShouldContainSubstring, `unknown Backend "nis"`)
+			So(strings.Count(err.Error(), "\n"), ShouldEqual, 4)
+
+			err = Load(write("listeners.json", `{"Hostname": "localhost", "Listeners": [
+				{"Port": 25}, {"Port": 587, "Role": "msa"}, {"Port": 25}]}`), &Config{})
+			So(err.Error(), ShouldContainSubstring, `Role should be mta, submission or submissions, not "msa"`)
+			So(err.Error(), ShouldContainSubstring, ":25 is used by another listener")
+
+			err = Load(write("families.json", `{"Hostname": "localhost", "Listeners": [
+				{"Port": 25, "Family": "ipv4"}, {"Port": 25, "Family": "ipv6"}, {"Ip": "127.0.0.1", "Port": 587, "Family": "ipv6"},
+				{"Port": 465, "Family": "inet"}, {"Ip": "::1", "Interface": "lo", "Port": 2525}]}`), &Config{})
+			So(err.Error(), ShouldContainSubstring, "Listeners[2]: Ip 127.0.0.1 isn't an ipv6 address")
+			So(err.Error(), ShouldContainSubstring, `Listeners[3]: Family should be ipv4 or ipv6, not "inet"`)
+			So(err.Error(), ShouldContainSubstring, "Listeners[4]: Ip and Interface can't be combined")
+			So(err.Error(), ShouldNotContainSubstring, "used by another listener")
+
+			err = Load(write("tables.json", `{"Hostname": "localhost", "AccessRules": [{"Action": "DROP"}], "Queue": {"Backend": "sql"},
+				"Aliases": {"Map": {"bob": []}}, "Forward": {"Transports": {"Map": {"example.com": "mx.example.com"}}}}`), &Config{})
+			So(err.Error(), ShouldContainSubstring, `AccessRules[0]: unknown action "DROP"`)
+			So(err.Error(), ShouldContainSubstring, "Aliases.Map: alias bob has no targets")
+			So(err.Error(), ShouldContainSubstring, `Queue.Backend should be files, bolt or redis, not "sql"`)
+			So(err.Error(), ShouldContainSubstring, `Forward.Transports.Map: transport for example.com: "mx.example.com" should be host:port`)
+
+			err = Load(write("recipients.json", `{"Hostname": "localhost", "MaxRecipients": 20}`), &Config{})
+			So(err.Error(), ShouldContainSubstring, "MaxRecipients 20 should be at least 100")
+
+			err = Load(write("state.json", `{"Hostname": "localhost", "SharedState": {"Backend": "redis"}}`), &Config{})
+			So(err.Error(), ShouldContainSubstring, "SharedState.Address is needed for the redis backend")
+			err = Load(write("state.json", `{"Hostname": "localhost", "SharedState": {"Backend": "memcached"}}`), &Config{})
+			So(err.Error(), ShouldContainSubstring, `SharedState.Backend should be memory or redis, not "memcached"`)
+
+			err = Load(filepath.Join(dir, "missing.json"), &Config{})
+			So(errors.Is(err, os.ErrNotExist), ShouldEqual, true)
+		})
+	})
+
+}
+
+func TestOverrides(t *testing.T) {
+
+	Convey("Testing ApplyOverrides", t, func() {
+		env := map[string]string{
+			"GOPISTOLET_HOSTNAME":  "mx.example.com",
+			"GOPISTOLET_PORT":      "2525",
+			"GOPISTOLET_QUEUE_DIR": "/var/spool/gopistolet",
+			"GOPISTOLET_TLS_KEY":   "/etc/gopistolet/key.pem",
+		}
+		getenv := func(name string) string { return env[name] }
+
+		c := &Config{}
+		c.Hostname = "localhost"
+		c.Port = 25
+		So(ApplyOverrides(c, getenv, map[string]string{"port": "587", "log-level": "info", "tls-cert": "/etc/gopistolet/cert.pem"}), ShouldEqual, nil)
+		So(c.Hostname, ShouldEqual, "mx.example.com")
+		So(c.TlsCert, ShouldEqual, "/etc/gopistolet/cert.pem")
+		So(c.TlsKey, ShouldEqual, "/etc/gopistolet/key.pem")
+		So(c.Queue.Dir, ShouldEqual, "/var/spool/gopistolet")
+		// flags take precedence over environment variables
+		So(c.Port, ShouldEqual, 587)
+		So(c.LogLevel, ShouldEqual, "info")
+
+		err := ApplyOverrides(c, getenv, map[string]string{"port": "smtp"})
+		So(err.Error(), ShouldContainSubstring, "-port")
+
+		err = ApplyOverrides(c, getenv, map[string]string{"log-level": "verbose"})
+		So(err.Error(), ShouldContainSubstring, "LogLevel")
+	})
+
+}
+
+func TestListener(t *testing.T) {
+
+	Convey("Testing Listener", t, func() {
+		listener := Listener{Ip: "::1", Port: 25}
+		So(listener.Network(), ShouldEqual, "tcp")
+		addresses, err := listener.Addresses()
+		So(err, ShouldEqual, nil)
+		So(addresses, ShouldResemble, []string{"[::1]:25"})
+
+		listener = Listener{Port: 25, Family: FamilyIPv6}
+		So(listener.Network(), ShouldEqual, "tcp6")
+		addresses, _ = listener.Addresses()
+		So(addresses, ShouldResemble, []string{":25"})
+
+		_, err = (&Listener{Interface: "missing0", Port: 25}).Addresses()
+		So(err, ShouldNotEqual, nil)
+
+		if _, err := net.InterfaceByName("lo"); err != nil {
+			t.Skip("no loopback interface lo")
+		}
+		addresses, err = (&Listener{Interface: "lo", Port: 25, Family: FamilyIPv4}).Addresses()
+		So(err, ShouldEqual, nil)
+		So(addresses, ShouldContain, "127.0.0.1:25")
+		So(addresses, ShouldNotContain, "[::1]:25")
+	})
+
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Override is a setting which can be given with a command line flag or a GOPISTOLET_ environment variable,
+// so simple deployments don't need a config file.
+// Flags take precedence over environment variables, which take precedence over the config file.
+type Override struct {
+	// Flag is the name of the command line flag
+	Flag  string
+	Usage string
+	Set   func(c *Config, value string) error
+}
+
+// Env returns the name of the environment variable, e.g. GOPISTOLET_QUEUE_DIR for queue-dir
+func (o Override) Env() string {
+	return "GOPISTOLET_" + strings.ToUpper(strings.Replace(o.Flag, "-", "_", -1))
+}
+
+// Overrides are the settings which can be overridden
+var Overrides = []Override{
+	{"hostname", "hostname in the greeting and the Received header fields", func(c *Config, value string) error {
+		c.Hostname = value
+		return nil
+	}},
+	{"ip", "IP address to listen on", func(c *Config, value string) error {
+		c.Ip = value
+		return nil
+	}},
+	{"port", "port to listen on", func(c *Config, value string) error {
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid port %q", value)
+		}
+		c.Port = uint32(port)
+		return nil
+	}},
+	{"log-level", "debug, info, warn or error", func(c *Config, value string) error {
+		c.LogLevel = value
+		return nil
+	}},
+	{"queue-dir", "spool directory of the queue", func(c *Config, value string) error {
+		c.Queue.Dir = value
+		return nil
+	}},
+	{"smarthost", "host:port to which mail for remote recipients is relayed", func(c *Config, value string) error {
+		c.Forward.Smarthost = value
+		return nil
+	}},
+	{"admin", "address of the admin HTTP listener", func(c *Config, value string) error {
+		c.Admin.Address = value
+		return nil
+	}},
+	{"users", "JSON file with the users", func(c *Config, value string) error {
+		c.Users.File = value
+		return nil
+	}},
+	{"tls-cert", "PEM file with the TLS certificate (chain) for STARTTLS and implicit TLS", func(c *Config, value string) error {
+		c.TlsCert = value
+		return nil
+	}},
+	{"tls-key", "PEM file with the private key of the TLS certificate", func(c *Config, value string) error {
+		c.TlsKey = value
+		return nil
+	}},
+}
+
+// ApplyOverrides applies the environment variables (looked up with getenv) and then the flags
+// (keyed by flag name) to the config, and validates the result
+func ApplyOverrides(c *Config, getenv func(string) string, flags map[string]string) error {
+	for _, override := range Overrides {
+		if value := getenv(override.Env()); value != "" {
+			if err := override.Set(c, value); err != nil {
+				return fmt.Errorf("%s: %v", override.Env(), err)
+			}
+		}
+	}
+	for _, override := range Overrides {
+		if value, ok := flags[override.Flag]; ok {
+			if err := override.Set(c, value); err != nil {
+				return fmt.Errorf("-%s: %v", override.Flag, err)
+			}
+		}
+	}
+	return c.Validate()
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"strings"
+)
+
+// KnownRecipient reports whether mail for the address can be delivered, so unknown local users
+// can be rejected with 550 5.1.1 when the recipient is given instead of being dropped later.
+// Addresses in other domains are always known (relaying is decided elsewhere). Local addresses
+// must be the postmaster or a mailing list, or expand through the aliases to pipes,
+// other domains or mailboxes which exist.
+func (c *Config) KnownRecipient(address string) bool {
+	if c.LocalDomains.IsPostmaster(address) || !c.LocalDomains.IsLocal(address) || c.isList(address) {
+		return true
+	}
+
+	targets, err := c.Aliases.Expand(address)
+	if err != nil {
+		// the alias handler delivers to the address itself then
+		targets = []string{address}
+	}
+	for _, target := range targets {
+		if strings.HasPrefix(target, "|") || !c.LocalDomains.IsLocal(target) || c.isList(target) {
+			return true
+		}
+		if _, found := c.LocalDomains.Mailbox(target, c.RecipientDelimiter); found {
+			return true
+		}
+	}
+	return false
+}
+
+// isList reports whether the address is a mailing list
+func (c *Config) isList(address string) bool {
+	for name := range c.Lists {
+		if strings.EqualFold(name, address) {
+			return true
+		}
+	}
+	return false
+}
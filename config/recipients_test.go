@@ -0,0 +1,48 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/gopistolet/gopistolet/helpers"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKnownRecipient(t *testing.T) {
+
+	Convey("Testing KnownRecipient", t, func() {
+		c := &Config{
+			LocalDomains: helpers.LocalDomains{
+				"example.com":      {Users: map[string]string{"bob": ""}},
+				"catchall.example": {CatchAll: "catchall.example/all"},
+			},
+			RecipientDelimiter: "+",
+			Aliases: helpers.Aliases{Map: map[string][]string{
+				"support":   {"bob"},
+				"robot":     {"|/usr/bin/robot"},
+				"forwarded": {"someone@remote.example"},
+				"gone":      {"nobody"},
+			}},
+			Lists: map[string]List{"team@example.com": {Members: []string{"bob@example.com"}}},
+		}
+
+		for _, address := range []string{
+			"bob@example.com",
+			"Bob+lists@Example.com",
+			"postmaster",
+			"postmaster@example.com",
+			"anyone@remote.example",
+			"anyone@catchall.example",
+			"team@example.com",
+			"support@example.com",
+			"robot@example.com",
+			"forwarded@example.com",
+		} {
+			So(c.KnownRecipient(address), ShouldEqual, true)
+		}
+
+		So(c.KnownRecipient("alice@example.com"), ShouldEqual, false)
+		So(c.KnownRecipient("gone@example.com"), ShouldEqual, false)
+	})
+
+}
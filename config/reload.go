@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the config and the tables which are reloaded at runtime
+const (
+	ReloadConfig      = "Config"
+	ReloadAccessRules = "AccessRules"
+	ReloadAliases     = "Aliases"
+	ReloadTransports  = "Transports"
+)
+
+// Reload contains the settings of the reloads at runtime, and keeps their outcome for the admin API.
+// The config file is reloaded on SIGHUP, and when it changes if Interval is set.
+// A table with errors doesn't replace the table which is in use.
+type Reload struct {
+	// Interval in seconds between two checks whether the config file, the alias file
+	// or the transport file changed (0 only reloads on SIGHUP)
+	Interval int
+
+	mutex  sync.Mutex
+	status map[string]ReloadStatus
+}
+
+// ReloadStatus is the outcome of the reloads of the config or a table
+type ReloadStatus struct {
+	// Source is the file the table is loaded from
+	Source string
+	// Entries in the table which is in use
+	Entries int
+	// Loaded is the time of the last successful load
+	Loaded time.Time
+	// Checked is the time of the last attempt
+	Checked time.Time
+	// Error of the last attempt, the table which was loaded before is still in use
+	Error string `json:",omitempty"`
+}
+
+// Record records the outcome of a reload of the config or a table,
+// it reports whether the error (or its absence) differs from the one of the previous attempt
+func (r *Reload) Record(name, source string, entries int, err error) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.status == nil {
+		r.status = make(map[string]ReloadStatus)
+	}
+	status, found := r.status[name]
+	previous := status.Error
+	status.Source = source
+	status.Checked = time.Now()
+	status.Error = ""
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Entries = entries
+		status.Loaded = status.Checked
+	}
+	r.status[name] = status
+	return !found || status.Error != previous
+}
+
+// Status returns the outcome of the reloads, by name
+func (r *Reload) Status() map[string]ReloadStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	status := make(map[string]ReloadStatus, len(r.status))
+	for name, s := range r.status {
+		status[name] = s
+	}
+	return status
+}
+
+// CheckAccessRules checks the actions of the access rules
+func CheckAccessRules(rules []AccessRule) error {
+	for i, rule := range rules {
+		action := strings.Fields(rule.Action)
+		switch {
+		case len(action) == 0:
+			return fmt.Errorf("AccessRules[%d] has no action", i)
+		case strings.EqualFold(action[0], "REDIRECT"):
+			if len(action) < 2 {
+				return fmt.Errorf("AccessRules[%d]: REDIRECT without address", i)
+			}
+		case !strings.EqualFold(action[0], "OK") && !strings.EqualFold(action[0], "REJECT") && !strings.EqualFold(action[0], "DEFER"):
+			return fmt.Errorf("AccessRules[%d]: unknown action %q", i, rule.Action)
+		}
+	}
+	return nil
+}
+
+// CurrentAccessRules returns the access rules which are in use
+func (c *Config) CurrentAccessRules() []AccessRule {
+	c.accessRulesMutex.RLock()
+	defer c.accessRulesMutex.RUnlock()
+	return c.AccessRules
+}
+
+// SetAccessRules replaces the access rules, they should be checked with CheckAccessRules
+func (c *Config) SetAccessRules(rules []AccessRule) {
+	c.accessRulesMutex.Lock()
+	c.AccessRules = rules
+	c.accessRulesMutex.Unlock()
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/user"
+)
+
+// Backends of the user store
+const (
+	UsersFile = "file"
+	UsersSQL  = "sql"
+	UsersLDAP = "ldap"
+	UsersHTTP = "http"
+)
+
+// Users selects the user store. The file backend (default) uses the embedded UserDB,
+// its File is reloaded every Interval seconds when it changes.
+type Users struct {
+	user.UserDB
+
+	// Backend is file, sql, ldap or http
+	Backend string
+
+	SQL  SQL
+	LDAP user.LDAPStore
+	HTTP user.HTTPStore
+
+	// CacheTTL in seconds for which successful authentications against the file, sql and ldap
+	// backends are cached (see helpers.AuthCache), 0 disables the cache.
+	// The http backend has its own cache (see HTTP.CacheTTL).
+	CacheTTL int
+
+	// Store is the opened user store (see Open)
+	Store user.UserStore `json:"-"`
+
+	authCache helpers.AuthCache
+}
+
+// SQL contains the settings of the SQL user store (see user.Schema),
+// the drivers of PostgreSQL (lib/pq) and MySQL (go-sql-driver/mysql) are compiled in
+type SQL struct {
+	// Driver is postgres or mysql
+	Driver string
+	// DSN is the data source name of the driver
+	DSN string
+}
+
+func (u *Users) validate() error {
+	if u.CacheTTL < 0 {
+		return errors.New("CacheTTL is negative")
+	}
+	switch u.Backend {
+	case "", UsersFile:
+		if u.Interval < 0 {
+			return errors.New("Interval is negative")
+		}
+	case UsersSQL:
+		if u.SQL.Driver != "postgres" && u.SQL.Driver != "mysql" {
+			return fmt.Errorf("SQL.Driver should be postgres or mysql, not %q", u.SQL.Driver)
+		}
+		if u.SQL.DSN == "" {
+			return errors.New("SQL.DSN is empty")
+		}
+	case UsersLDAP:
+		if u.LDAP.URL == "" {
+			return errors.New("LDAP.URL is empty")
+		}
+		if u.LDAP.Timeout < 0 || u.LDAP.PoolSize < 0 {
+			return errors.New("LDAP.Timeout and LDAP.PoolSize can't be negative")
+		}
+	case UsersHTTP:
+		if u.HTTP.URL == "" {
+			return errors.New("HTTP.URL is empty")
+		}
+		if u.HTTP.Timeout < 0 || u.HTTP.CacheTTL < 0 {
+			return errors.New("HTTP.Timeout and HTTP.CacheTTL can't be negative")
+		}
+	default:
+		return fmt.Errorf("unknown Backend %q, it should be file, sql, ldap or http", u.Backend)
+	}
+	return nil
+}
+
+// Cleanup removes the expired entries from the caches of the user store, it should be called periodically
+func (u *Users) Cleanup() {
+	if u.Backend == UsersHTTP {
+		u.HTTP.Cleanup()
+	}
+	u.authCache.Cleanup()
+}
+
+// Authenticate checks the credentials with the Store, successful authentications are cached for CacheTTL seconds
+func (u *Users) Authenticate(name, password string) (bool, error) {
+	if u.Backend == UsersHTTP {
+		return u.Store.Authenticate(name, password)
+	}
+
+	var err error
+	ok := u.authCache.Verify(name, password, func(name, password string) bool {
+		var ok bool
+		ok, err = u.Store.Authenticate(name, password)
+		return ok
+	})
+	return ok, err
+}
+
+// Invalidate forgets the cached authentications, so changed passwords are checked with the Store again.
+// It's called when the users of the file backend are reloaded, and should be called when the config is reloaded.
+func (u *Users) Invalidate() {
+	u.authCache.Clear()
+}
+
+// Open opens the user store of the Backend as Store, the file backend isn't loaded
+func (u *Users) Open() error {
+	u.authCache.TTL = u.CacheTTL
+	u.UserDB.OnLoad = u.Invalidate
+
+	switch u.Backend {
+	case "", UsersFile:
+		u.Store = &u.UserDB
+	case UsersSQL:
+		store, err := user.OpenSQL(u.SQL.Driver, u.SQL.DSN)
+		if err != nil {
+			return err
+		}
+		u.Store = store
+	case UsersLDAP:
+		u.Store = &u.LDAP
+	case UsersHTTP:
+		u.Store = &u.HTTP
+	default:
+		return fmt.Errorf("unknown user store backend %q", u.Backend)
+	}
+	return nil
+}
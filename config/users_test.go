@@ -0,0 +1,86 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopistolet/gopistolet/user"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingStore is a user.UserStore which counts the authentications
+type countingStore struct {
+	user.UserDB
+	calls int
+}
+
+func (s *countingStore) Authenticate(name, password string) (bool, error) {
+	s.calls++
+	return s.UserDB.Authenticate(name, password)
+}
+
+func TestUsersAuthenticate(t *testing.T) {
+
+	Convey("Testing the authentication cache of the user store", t, func() {
+		bob := &user.User{Name: "bob@example.com"}
+		So(bob.SetPassword("secret"), ShouldEqual, nil)
+
+		Convey("Authentications are cached", func() {
+			u := &Users{Backend: UsersLDAP, CacheTTL: 60}
+			So(u.Open(), ShouldEqual, nil)
+			store := &countingStore{}
+			store.Add(bob)
+			u.Store = store
+
+			u.Authenticate("bob@example.com", "secret")
+			ok, _ := u.Authenticate("bob@example.com", "secret")
+			So(ok, ShouldEqual, true)
+			So(store.calls, ShouldEqual, 1)
+			ok, _ = u.Authenticate("bob@example.com", "wrong")
+			So(ok, ShouldEqual, false)
+			So(store.calls, ShouldEqual, 2)
+
+			// after a reload of the config
+			u.Invalidate()
+			u.Authenticate("bob@example.com", "secret")
+			So(store.calls, ShouldEqual, 3)
+		})
+
+		Convey("A changed password of the file backend stops working at once", func() {
+			file := filepath.Join(t.TempDir(), "users.json")
+			u := &Users{CacheTTL: 60}
+			u.File = file
+			So(u.Open(), ShouldEqual, nil)
+			u.Add(bob)
+			So(u.Save(file), ShouldEqual, nil)
+			So(u.Load(), ShouldEqual, nil)
+
+			ok, err := u.Authenticate("bob@example.com", "secret")
+			So(err, ShouldEqual, nil)
+			So(ok, ShouldEqual, true)
+
+			changed := &user.User{Name: "bob@example.com"}
+			So(changed.SetPassword("new secret"), ShouldEqual, nil)
+			db := &user.UserDB{}
+			db.Add(changed)
+			So(db.Save(file), ShouldEqual, nil)
+			later := time.Now().Add(time.Minute)
+			So(os.Chtimes(file, later, later), ShouldEqual, nil)
+			So(u.Load(), ShouldEqual, nil)
+
+			ok, _ = u.Authenticate("bob@example.com", "secret")
+			So(ok, ShouldEqual, false)
+			ok, _ = u.Authenticate("bob@example.com", "new secret")
+			So(ok, ShouldEqual, true)
+		})
+
+		Convey("A negative CacheTTL is refused", func() {
+			u := &Users{CacheTTL: -1}
+			So(u.validate(), ShouldNotEqual, nil)
+		})
+	})
+
+}
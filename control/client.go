@@ -0,0 +1,43 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Inject injects the message on the control socket, it returns the session id of the message
+func Inject(socket string, message *Message) (string, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	// the host is ignored, the connection goes to the socket
+	response, err := client.Post("http://control/inject", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		text, _ := ioutil.ReadAll(response.Body)
+		return "", fmt.Errorf("message refused: %s", strings.TrimSpace(string(text)))
+	}
+	result := Result{}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.Id, nil
+}
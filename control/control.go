@@ -0,0 +1,236 @@
+// Package control serves the control socket, a Unix socket on which local programs
+// (e.g. gopistolet-sendmail) inject messages. The protocol is HTTP:
+//
+//	POST /inject  a Message as JSON, the response is a Result as JSON
+//
+// Injected messages go through the handlers like the messages received by the SMTP servers,
+// as if they were sent from 127.0.0.1.
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/handlers"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// DefaultSocket is the control socket gopistolet-sendmail uses if $GOPISTOLET_CONTROL_SOCKET isn't set
+const DefaultSocket = "/var/run/gopistolet/control.sock"
+
+// maxMessageSize limits the size of an injected message
+const maxMessageSize = 64 << 20
+
+// Message is a message which is injected on the control socket
+type Message struct {
+	// From is the envelope sender, <> for the null sender.
+	// If it's empty the sender is User at the hostname of the server.
+	From string
+	// User is the local user who injects the message
+	User string
+	// FullName is the name in the From header field, which is added if the message has none
+	FullName string
+	// To are the recipients, addresses without domain get the hostname of the server
+	To []string
+	// Data is the message, with the header
+	Data []byte
+}
+
+// Result is the response to an injected message
+type Result struct {
+	// Id is the session id of the message in the logs
+	Id string
+}
+
+// counter numbers the sessions of the injected messages
+var counter uint32
+
+func New(c *config.Config) *Server {
+	return &Server{
+		config: c,
+		now:    time.Now,
+	}
+}
+
+// Server is the control socket listener
+type Server struct {
+	// Chain handles the injected messages
+	Chain handlers.Handler
+
+	config *config.Config
+	server *http.Server
+	// now can be replaced for testing
+	now func() time.Time
+}
+
+// Handler returns the handler with the control endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/inject", s.inject)
+	return mux
+}
+
+// Start listens on the control socket in the background,
+// it doesn't do anything if no socket is configured
+func (s *Server) Start() error {
+	socket := s.config.Control.Socket
+	if socket == "" {
+		return nil
+	}
+
+	// a socket which is left behind by a crash is in the way
+	if info, err := os.Lstat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(socket)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socket, 0666); err != nil {
+		listener.Close()
+		return err
+	}
+
+	s.server = &http.Server{Handler: s.Handler()}
+	go func() {
+		log.Println("Control socket on " + socket)
+		err := s.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorln("Control socket:", err)
+		}
+	}()
+	return nil
+}
+
+// Stop closes the control socket
+func (s *Server) Stop() {
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+// inject handles a message
+func (s *Server) inject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	message := Message{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageSize)).Decode(&message); err != nil {
+		http.Error(w, "invalid message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	state, err := s.state(&message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	}).Infof("Control: message from %s injected by %q for %d recipients", message.From, message.User, len(state.To))
+	if s.Chain != nil {
+		s.Chain.Handle(state)
+	}
+	if err := s.config.Acceptance.Take(state.SessionId.String()); err != nil {
+		http.Error(w, "message not stored: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Result{Id: state.SessionId.String()})
+}
+
+// state returns the state of the message, as if it was received from 127.0.0.1
+func (s *Server) state(message *Message) (*smtp.State, error) {
+	if len(message.To) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	if message.From == "" {
+		if message.User == "" {
+			return nil, errors.New("no sender")
+		}
+		message.From = message.User
+	}
+
+	now := s.now()
+	state := &smtp.State{
+		To:       []*smtp.MailAddress{},
+		Ip:       net.IPv4(127, 0, 0, 1),
+		Hostname: "localhost",
+		// the counters of the SMTP sessions start at 1, the high bit keeps the ids apart
+		SessionId: smtp.Id{Timestamp: now.Unix(), Counter: atomic.AddUint32(&counter, 1) | 1<<31},
+	}
+	if message.From != "<>" {
+		from, err := s.address(message.From)
+		if err != nil {
+			return nil, fmt.Errorf("sender %q: %v", message.From, err)
+		}
+		state.From = from
+	}
+	for _, to := range message.To {
+		address, err := s.address(to)
+		if err != nil {
+			return nil, fmt.Errorf("recipient %q: %v", to, err)
+		}
+		state.To = append(state.To, address)
+	}
+	state.Data = s.complete(message.Data, state.From, message.FullName, now)
+	return state, nil
+}
+
+// address parses an address, addresses without domain get the hostname of the server
+func (s *Server) address(address string) (*smtp.MailAddress, error) {
+	if !strings.Contains(address, "@") {
+		address += "@" + s.config.Hostname
+	}
+	parsed, err := smtp.ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// complete converts the line endings of the message to CRLF
+// and adds the From, Date and Message-ID header fields if they're missing
+func (s *Server) complete(data []byte, from *smtp.MailAddress, fullName string, now time.Time) []byte {
+	data = []byte(strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n", "\r\n"))
+	fields, _ := helpers.SplitHeader(data)
+	// a message which starts with its body (e.g. from echo text | sendmail) has no header
+	hasHeader := len(fields) > 0 && helpers.FieldName(fields[0]) != "" && !strings.ContainsAny(helpers.FieldName(fields[0]), " \t")
+	if !hasHeader {
+		fields = nil
+	}
+	missing := map[string]bool{"from": from != nil, "date": true, "message-id": true}
+	for _, field := range fields {
+		delete(missing, strings.ToLower(helpers.FieldName(field)))
+	}
+
+	added := ""
+	if missing["from"] {
+		address := mail.Address{Name: fullName, Address: from.Address}
+		added += "From: " + address.String() + "\r\n"
+	}
+	if missing["date"] {
+		added += "Date: " + now.Format(time.RFC1123Z) + "\r\n"
+	}
+	if missing["message-id"] {
+		added += "Message-ID: " + helpers.NewMessageId(s.config.Hostname) + "\r\n"
+	}
+	if !hasHeader {
+		added += "\r\n"
+	}
+	return append([]byte(added), data...)
+}
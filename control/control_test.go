@@ -0,0 +1,85 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type recorder struct {
+	states []*smtp.State
+}
+
+func (r *recorder) Handle(state *smtp.State) {
+	r.states = append(r.states, state)
+}
+
+func TestControl(t *testing.T) {
+
+	Convey("Testing control socket", t, func() {
+		dir, err := ioutil.TempDir("", "control")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{}
+		c.Hostname = "mx.example.com"
+		c.Control.Socket = filepath.Join(dir, "control.sock")
+		chain := &recorder{}
+		s := New(c)
+		s.Chain = chain
+		s.now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+		So(s.Start(), ShouldEqual, nil)
+		defer s.Stop()
+
+		id, err := Inject(c.Control.Socket, &Message{
+			User:     "cron",
+			FullName: "Cron Daemon",
+			To:       []string{"root", "bob@example.org"},
+			Data:     []byte("Subject: backup\n\ndone\n"),
+		})
+		So(err, ShouldEqual, nil)
+		So(chain.states, ShouldHaveLength, 1)
+		state := chain.states[0]
+		So(id, ShouldEqual, state.SessionId.String())
+		So(state.Ip.String(), ShouldEqual, "127.0.0.1")
+		So(state.From.Address, ShouldEqual, "cron@mx.example.com")
+		So(state.To, ShouldHaveLength, 2)
+		So(state.To[0].Address, ShouldEqual, "root@mx.example.com")
+		So(state.To[1].Address, ShouldEqual, "bob@example.org")
+		data := string(state.Data)
+		So(data, ShouldStartWith, "From: \"Cron Daemon\" <cron@mx.example.com>\r\nDate: Thu, 02 Jan 2020 03:04:05 +0000\r\nMessage-ID: <")
+		So(data, ShouldEndWith, "@mx.example.com>\r\nSubject: backup\r\n\r\ndone\r\n")
+
+		// a null sender and a message without header
+		_, err = Inject(c.Control.Socket, &Message{From: "<>", To: []string{"bob@example.org"}, Data: []byte("hello world\n")})
+		So(err, ShouldEqual, nil)
+		state = chain.states[1]
+		So(state.From, ShouldEqual, nil)
+		So(string(state.Data), ShouldStartWith, "Date: ")
+		So(string(state.Data), ShouldEndWith, ">\r\n\r\nhello world\r\n")
+		So(strings.Count(string(state.Data), "Message-ID:"), ShouldEqual, 1)
+
+		_, err = Inject(c.Control.Socket, &Message{User: "cron", Data: []byte("Subject: x\n\n")})
+		So(err.Error(), ShouldContainSubstring, "no recipients")
+		_, err = Inject(c.Control.Socket, &Message{User: "cron", To: []string{"bob@@"}})
+		So(err.Error(), ShouldContainSubstring, `recipient "bob@@"`)
+		So(chain.states, ShouldHaveLength, 2)
+
+		// a socket which is left behind is replaced
+		s.Stop()
+		_, err = Inject(c.Control.Socket, &Message{User: "cron", To: []string{"root"}})
+		So(err, ShouldNotEqual, nil)
+		s = New(c)
+		So(s.Start(), ShouldEqual, nil)
+		s.Stop()
+	})
+
+}
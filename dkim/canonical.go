@@ -0,0 +1,86 @@
+package dkim
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/helpers"
+)
+
+// Canonicalization algorithms (RFC 6376 section 3.4)
+const (
+	Simple  = "simple"
+	Relaxed = "relaxed"
+)
+
+// splitMessage returns the header fields and the body (without the empty line) of the message
+func splitMessage(message []byte) ([]string, []byte) {
+	fields, body := helpers.SplitHeader(message)
+	if i := bytes.IndexByte(body, '\n'); i >= 0 {
+		return fields, body[i+1:]
+	}
+	return fields, nil
+}
+
+// canonicalHeader returns the canonical form of the header field, ending with CRLF
+func canonicalHeader(field, canonicalization string) string {
+	if canonicalization == Simple {
+		return field
+	}
+	i := strings.IndexByte(field, ':')
+	if i < 0 {
+		return ""
+	}
+	name := strings.ToLower(strings.TrimSpace(field[:i]))
+	value := strings.NewReplacer("\r\n", "", "\n", "").Replace(field[i+1:])
+	return name + ":" + strings.TrimSpace(compressSpace(value)) + "\r\n"
+}
+
+// canonicalBody returns the canonical form of the body, lines end with CRLF
+func canonicalBody(body []byte, canonicalization string) []byte {
+	lines := strings.Split(string(body), "\n")
+	// the body ends with a line break, or the last line has none
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if canonicalization == Relaxed {
+			line = strings.TrimRight(compressSpace(line), " ")
+		}
+		lines[i] = line
+	}
+	// empty lines at the end are ignored
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		if canonicalization == Relaxed {
+			return []byte{}
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// compressSpace replaces sequences of spaces and tabs by a single space
+func compressSpace(s string) string {
+	builder := strings.Builder{}
+	space := false
+	for _, c := range s {
+		if c == ' ' || c == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			builder.WriteByte(' ')
+			space = false
+		}
+		builder.WriteRune(c)
+	}
+	if space {
+		builder.WriteByte(' ')
+	}
+	return builder.String()
+}
@@ -0,0 +1,122 @@
+// Package dkim manages the DKIM keys of the local domains: it generates them,
+// prints their DNS records and rotates the selectors
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Key algorithms
+const (
+	AlgorithmRSA     = "rsa"
+	AlgorithmEd25519 = "ed25519"
+)
+
+// Key is the DKIM key of a domain with a selector
+type Key struct {
+	Domain   string
+	Selector string
+	Signer   crypto.Signer
+}
+
+// GenerateKey generates a key with the algorithm, bits is the size of RSA keys (at least 1024)
+func GenerateKey(algorithm string, bits int) (crypto.Signer, error) {
+	switch algorithm {
+	case "", AlgorithmRSA:
+		if bits < 1024 {
+			return nil, fmt.Errorf("RSA keys should have at least 1024 bits, not %d", bits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case AlgorithmEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unknown DKIM key algorithm %q", algorithm)
+}
+
+// Algorithm returns the algorithm of the key (the k= tag of the DNS record)
+func (k *Key) Algorithm() string {
+	if _, ok := k.Signer.(ed25519.PrivateKey); ok {
+		return AlgorithmEd25519
+	}
+	return AlgorithmRSA
+}
+
+// Name returns the domain name of the DNS record, <selector>._domainkey.<domain>
+func (k *Key) Name() string {
+	return k.Selector + "._domainkey." + strings.TrimSuffix(k.Domain, ".")
+}
+
+// Record returns the value of the TXT record which publishes the public key (RFC 6376 section 3.6.1).
+// Ed25519 keys are published as the raw public key (RFC 8463), RSA keys as SubjectPublicKeyInfo.
+func (k *Key) Record() (string, error) {
+	var public []byte
+	switch key := k.Signer.Public().(type) {
+	case ed25519.PublicKey:
+		public = key
+	case *rsa.PublicKey:
+		var err error
+		public, err = x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unsupported DKIM key type %T", key)
+	}
+	return "v=DKIM1; k=" + k.Algorithm() + "; p=" + base64.StdEncoding.EncodeToString(public), nil
+}
+
+// Zone returns the TXT record in zone file format, split into strings of at most 255 characters
+func (k *Key) Zone() (string, error) {
+	record, err := k.Record()
+	if err != nil {
+		return "", err
+	}
+	parts := []string{}
+	for len(record) > 255 {
+		parts = append(parts, `"`+record[:255]+`"`)
+		record = record[255:]
+	}
+	parts = append(parts, `"`+record+`"`)
+	return k.Name() + ". IN TXT ( " + strings.Join(parts, " ") + " )", nil
+}
+
+// save writes the private key to the file in PKCS #8 PEM format
+func (k *Key) save(file string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Signer)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
+
+// loadSigner reads a private key in PKCS #8 PEM format
+func loadSigner(file string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", file)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New(file + ": not a signing key")
+	}
+	return signer, nil
+}
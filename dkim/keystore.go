@@ -0,0 +1,405 @@
+package dkim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/log"
+)
+
+// KeyStore keeps the DKIM keys of the Domains in Dir, as <Dir>/<domain>/<selector>.pem,
+// and rotates them every RotateDays days.
+//
+// A rotation has an overlap window of OverlapDays days on both sides: the next key is generated
+// OverlapDays before the switch, so its DNS record can be published before it's used for signing,
+// and the previous key is kept for OverlapDays after the switch, so messages which were signed
+// with it can still be verified. The keys which have to be in DNS are returned by Published.
+//
+// With DualSign messages are signed with an RSA key and an ed25519 key (RFC 8463 section 4), since not all
+// verifiers support ed25519 yet. The ed25519 keys are kept and rotated in <Dir>/ed25519, their selectors start with ed.
+type KeyStore struct {
+	Dir     string
+	Domains []string
+	// Algorithm of new keys, rsa (default) or ed25519
+	Algorithm string
+	// Bits of new RSA keys (default 2048)
+	Bits int
+	// RotateDays is the lifetime of a key, keys aren't rotated if it's 0
+	RotateDays int
+	// OverlapDays is the overlap window of a rotation (default 7)
+	OverlapDays int
+	// DualSign also signs with an ed25519 key, Algorithm must be rsa
+	DualSign bool
+
+	// now is time.Now, it can be replaced for testing
+	now func() time.Time
+	// prefix of the selectors (default gp)
+	prefix string
+
+	mutex sync.Mutex
+	stop  chan struct{}
+	ed    *KeyStore
+}
+
+// selectors is the rotation state of a domain, stored in <Dir>/<domain>/selectors.json
+type selectors struct {
+	// Active is the selector which is used for signing
+	Active string
+	// Next is the selector which becomes active at the next rotation
+	Next string `json:",omitempty"`
+	// Previous is the selector which was active before the last rotation
+	Previous string `json:",omitempty"`
+	// Switched is when Active became active
+	Switched time.Time
+}
+
+// ErrNoKey is returned when a domain has no key yet
+var ErrNoKey = errors.New("no DKIM key")
+
+func (s *KeyStore) time() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *KeyStore) overlap() time.Duration {
+	days := s.OverlapDays
+	if days <= 0 {
+		days = 7
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func (s *KeyStore) dir(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	// the domain is used as directory name
+	domain = strings.NewReplacer("/", "_", "\\", "_").Replace(domain)
+	return filepath.Join(s.Dir, strings.TrimLeft(domain, "."))
+}
+
+// load reads the rotation state of the domain, the mutex must be locked
+func (s *KeyStore) load(domain string) (*selectors, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir(domain), "selectors.json"))
+	if os.IsNotExist(err) {
+		return &selectors{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &selectors{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("%s: %v", domain, err)
+	}
+	return state, nil
+}
+
+// save writes the rotation state of the domain, the mutex must be locked
+func (s *KeyStore) save(domain string, state *selectors) error {
+	data, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(s.dir(domain), "selectors.json")
+	if err := ioutil.WriteFile(file+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// key reads the key of the domain with the selector
+func (s *KeyStore) key(domain, selector string) (*Key, error) {
+	signer, err := loadSigner(filepath.Join(s.dir(domain), selector+".pem"))
+	if err != nil {
+		return nil, err
+	}
+	return &Key{Domain: domain, Selector: selector, Signer: signer}, nil
+}
+
+// generate creates a new key for the domain with a selector based on the date, the mutex must be locked
+func (s *KeyStore) generate(domain string) (*Key, error) {
+	dir := s.dir(domain)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	prefix := s.prefix
+	if prefix == "" {
+		prefix = "gp"
+	}
+	base := prefix + s.time().Format("20060102")
+	selector := base
+	for i := 0; ; i++ {
+		if i > 0 {
+			selector = fmt.Sprintf("%s%c", base, 'a'+i-1)
+		}
+		if _, err := os.Stat(filepath.Join(dir, selector+".pem")); os.IsNotExist(err) {
+			break
+		}
+		if i == 26 {
+			return nil, fmt.Errorf("%s: too many keys generated today", domain)
+		}
+	}
+
+	bits := s.Bits
+	if bits == 0 {
+		bits = 2048
+	}
+	signer, err := GenerateKey(s.Algorithm, bits)
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{Domain: domain, Selector: selector, Signer: signer}
+	return key, key.save(filepath.Join(dir, selector+".pem"))
+}
+
+// Generate generates a key for the domain. It becomes the active key if the domain doesn't have one,
+// or the next key otherwise (replacing a next key which was generated before).
+func (s *KeyStore) Generate(domain string) (*Key, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state, err := s.load(domain)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.generate(domain)
+	if err != nil {
+		return nil, err
+	}
+	if state.Active == "" {
+		state.Active = key.Selector
+		state.Switched = s.time()
+	} else {
+		if state.Next != "" {
+			os.Remove(filepath.Join(s.dir(domain), state.Next+".pem"))
+		}
+		state.Next = key.Selector
+	}
+	return key, s.save(domain, state)
+}
+
+// ed25519 returns the store of the ed25519 keys for DualSign, or nil
+func (s *KeyStore) ed25519() *KeyStore {
+	if !s.DualSign {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ed == nil {
+		s.ed = &KeyStore{
+			Dir:         filepath.Join(s.Dir, AlgorithmEd25519),
+			Domains:     s.Domains,
+			Algorithm:   AlgorithmEd25519,
+			RotateDays:  s.RotateDays,
+			OverlapDays: s.OverlapDays,
+			now:         s.now,
+			prefix:      "ed",
+		}
+	}
+	return s.ed
+}
+
+// GenerateAll generates a key for the domain like Generate, and with DualSign an ed25519 key as well
+func (s *KeyStore) GenerateAll(domain string) ([]*Key, error) {
+	key, err := s.Generate(domain)
+	if err != nil {
+		return nil, err
+	}
+	keys := []*Key{key}
+	if ed := s.ed25519(); ed != nil {
+		key, err := ed.Generate(domain)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Active returns the key with which messages from the domain are signed
+func (s *KeyStore) Active(domain string) (*Key, error) {
+	s.mutex.Lock()
+	state, err := s.load(domain)
+	s.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if state.Active == "" {
+		return nil, ErrNoKey
+	}
+	return s.key(domain, state.Active)
+}
+
+// Signing returns the keys with which messages from the domain are signed:
+// the active key, and with DualSign the active ed25519 key as well
+func (s *KeyStore) Signing(domain string) ([]*Key, error) {
+	key, err := s.Active(domain)
+	if err != nil {
+		return nil, err
+	}
+	keys := []*Key{key}
+	if ed := s.ed25519(); ed != nil {
+		key, err := ed.Active(domain)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Published returns the keys of the domain which have to be in DNS: the active one,
+// and the next and previous ones during the overlap windows (of both algorithms with DualSign)
+func (s *KeyStore) Published(domain string) ([]*Key, error) {
+	s.mutex.Lock()
+	state, err := s.load(domain)
+	s.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []*Key{}
+	for _, selector := range []string{state.Active, state.Next, state.Previous} {
+		if selector == "" {
+			continue
+		}
+		key, err := s.key(domain, selector)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if ed := s.ed25519(); ed != nil {
+		published, err := ed.Published(domain)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, published...)
+	}
+	return keys, nil
+}
+
+// Rotate advances the rotation of the domain: it generates the next key when the overlap window
+// before the switch starts, switches to it after RotateDays, and removes the previous key
+// when the overlap window after the switch ends. A domain without key gets one.
+func (s *KeyStore) Rotate(domain string) error {
+	if ed := s.ed25519(); ed != nil {
+		if err := ed.Rotate(domain); err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state, err := s.load(domain)
+	if err != nil {
+		return err
+	}
+	now := s.time()
+	changed := false
+
+	if state.Active == "" {
+		key, err := s.generate(domain)
+		if err != nil {
+			return err
+		}
+		state.Active, state.Switched = key.Selector, now
+		changed = true
+		logRecord("generated", key)
+	}
+
+	age := now.Sub(state.Switched)
+	if s.RotateDays > 0 {
+		lifetime := time.Duration(s.RotateDays) * 24 * time.Hour
+		if state.Next == "" && age >= lifetime-s.overlap() {
+			key, err := s.generate(domain)
+			if err != nil {
+				return err
+			}
+			state.Next = key.Selector
+			changed = true
+			logRecord("generated the next", key)
+		}
+		if state.Next != "" && age >= lifetime {
+			if state.Previous != "" {
+				os.Remove(filepath.Join(s.dir(domain), state.Previous+".pem"))
+			}
+			state.Previous, state.Active, state.Next, state.Switched = state.Active, state.Next, "", now
+			age = 0
+			changed = true
+			log.Printf("DKIM: %s is signed with selector %s now, keep %s in DNS for the overlap window", domain, state.Active, state.Previous)
+		}
+	}
+
+	if state.Previous != "" && age >= s.overlap() {
+		if err := os.Remove(filepath.Join(s.dir(domain), state.Previous+".pem")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		log.Printf("DKIM: the record of selector %s of %s can be removed from DNS", state.Previous, domain)
+		state.Previous = ""
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return s.save(domain, state)
+}
+
+// logRecord logs the DNS record of a new key, which has to be published
+func logRecord(what string, key *Key) {
+	zone, err := key.Zone()
+	if err != nil {
+		log.Errorf("DKIM: %v", err)
+		return
+	}
+	log.Printf("DKIM: %s key of %s, publish it in DNS: %s", what, key.Domain, zone)
+}
+
+// Start rotates the keys of the Domains now and every hour, until Stop is called
+func (s *KeyStore) Start() {
+	if s.Dir == "" {
+		return
+	}
+
+	s.mutex.Lock()
+	s.stop = make(chan struct{})
+	stop := s.stop
+	s.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			for _, domain := range s.Domains {
+				if err := s.Rotate(domain); err != nil {
+					log.Errorf("DKIM: couldn't rotate the key of %s: %v", domain, err)
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops rotating the keys
+func (s *KeyStore) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
@@ -0,0 +1,148 @@
+package dkim
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKey(t *testing.T) {
+
+	Convey("Testing DNS records", t, func() {
+		signer, err := GenerateKey(AlgorithmEd25519, 0)
+		So(err, ShouldEqual, nil)
+		key := &Key{Domain: "example.com.", Selector: "gp20161005", Signer: signer}
+		So(key.Name(), ShouldEqual, "gp20161005._domainkey.example.com")
+
+		record, err := key.Record()
+		So(err, ShouldEqual, nil)
+		So(record, ShouldStartWith, "v=DKIM1; k=ed25519; p=")
+		public, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(record, "v=DKIM1; k=ed25519; p="))
+		So(err, ShouldEqual, nil)
+		So(ed25519.PublicKey(public), ShouldResemble, signer.Public())
+
+		signer, err = GenerateKey(AlgorithmRSA, 2048)
+		So(err, ShouldEqual, nil)
+		key.Signer = signer
+		record, _ = key.Record()
+		der, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(record, "v=DKIM1; k=rsa; p="))
+		So(err, ShouldEqual, nil)
+		parsed, err := x509.ParsePKIXPublicKey(der)
+		So(err, ShouldEqual, nil)
+		So(parsed.(*rsa.PublicKey).N, ShouldResemble, signer.Public().(*rsa.PublicKey).N)
+
+		// long records are split into strings of 255 characters
+		zone, err := key.Zone()
+		So(err, ShouldEqual, nil)
+		So(zone, ShouldStartWith, `gp20161005._domainkey.example.com. IN TXT ( "v=DKIM1; k=rsa; p=`)
+		So(strings.Count(zone, `"`), ShouldEqual, 4)
+
+		_, err = GenerateKey(AlgorithmRSA, 512)
+		So(err, ShouldNotEqual, nil)
+		_, err = GenerateKey("dsa", 0)
+		So(err, ShouldNotEqual, nil)
+	})
+
+}
+
+func TestKeyStore(t *testing.T) {
+
+	Convey("Testing KeyStore rotation", t, func() {
+		dir, err := ioutil.TempDir("", "dkim")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		now := time.Date(2016, 10, 5, 12, 0, 0, 0, time.UTC)
+		s := &KeyStore{Dir: dir, Algorithm: AlgorithmEd25519, RotateDays: 30, OverlapDays: 7}
+		s.now = func() time.Time { return now }
+		day := 24 * time.Hour
+
+		_, err = s.Active("example.com")
+		So(err, ShouldEqual, ErrNoKey)
+
+		selectors := func() []string {
+			keys, err := s.Published("example.com")
+			So(err, ShouldEqual, nil)
+			list := []string{}
+			for _, key := range keys {
+				list = append(list, key.Selector)
+			}
+			return list
+		}
+
+		// the first key is active at once
+		So(s.Rotate("example.com"), ShouldEqual, nil)
+		active, err := s.Active("example.com")
+		So(err, ShouldEqual, nil)
+		So(active.Selector, ShouldEqual, "gp20161005")
+		So(selectors(), ShouldResemble, []string{"gp20161005"})
+
+		// the next key is published a week before the switch
+		now = now.Add(22 * day)
+		So(s.Rotate("example.com"), ShouldEqual, nil)
+		So(selectors(), ShouldResemble, []string{"gp20161005"})
+		now = now.Add(day)
+		So(s.Rotate("example.com"), ShouldEqual, nil)
+		So(selectors(), ShouldResemble, []string{"gp20161005", "gp20161028"})
+		active, _ = s.Active("example.com")
+		So(active.Selector, ShouldEqual, "gp20161005")
+
+		// the switch, the previous key stays published for a week
+		now = now.Add(7 * day)
+		So(s.Rotate("example.com"), ShouldEqual, nil)
+		active, _ = s.Active("example.com")
+		So(active.Selector, ShouldEqual, "gp20161028")
+		So(selectors(), ShouldResemble, []string{"gp20161028", "gp20161005"})
+
+		now = now.Add(7 * day)
+		So(s.Rotate("example.com"), ShouldEqual, nil)
+		So(selectors(), ShouldResemble, []string{"gp20161028"})
+		_, err = os.Stat(dir + "/example.com/gp20161005.pem")
+		So(os.IsNotExist(err), ShouldEqual, true)
+
+		// a generated key replaces the next key, selectors are unique
+		key, err := s.Generate("example.com")
+		So(err, ShouldEqual, nil)
+		So(key.Selector, ShouldEqual, "gp20161111")
+		key, err = s.Generate("example.com")
+		So(err, ShouldEqual, nil)
+		So(key.Selector, ShouldEqual, "gp20161111a")
+		So(selectors(), ShouldResemble, []string{"gp20161028", "gp20161111a"})
+	})
+
+	Convey("Testing KeyStore with DualSign", t, func() {
+		dir, err := ioutil.TempDir("", "dkim")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		s := &KeyStore{Dir: dir, Algorithm: AlgorithmRSA, Bits: 1024, DualSign: true}
+		s.now = func() time.Time { return time.Date(2016, 10, 5, 12, 0, 0, 0, time.UTC) }
+
+		So(s.Rotate("example.com"), ShouldEqual, nil)
+		keys, err := s.Signing("example.com")
+		So(err, ShouldEqual, nil)
+		So(len(keys), ShouldEqual, 2)
+		So(keys[0].Selector, ShouldEqual, "gp20161005")
+		So(keys[0].Algorithm(), ShouldEqual, AlgorithmRSA)
+		So(keys[1].Selector, ShouldEqual, "ed20161005")
+		So(keys[1].Algorithm(), ShouldEqual, AlgorithmEd25519)
+
+		published, err := s.Published("example.com")
+		So(err, ShouldEqual, nil)
+		So(len(published), ShouldEqual, 2)
+
+		keys, err = s.GenerateAll("example.com")
+		So(err, ShouldEqual, nil)
+		So(len(keys), ShouldEqual, 2)
+		So(keys[1].Selector, ShouldEqual, "ed20161005a")
+	})
+
+}
@@ -0,0 +1,117 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gopistolet/gopistolet/helpers"
+)
+
+// Signature algorithms, the a= tag of the signatures
+const (
+	SignatureRSA     = "rsa-sha256"
+	SignatureEd25519 = "ed25519-sha256"
+)
+
+// SignedFields are the header fields which are signed if the message has them
+var SignedFields = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID", "In-Reply-To", "References",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding", "List-Id", "List-Unsubscribe",
+}
+
+// SignatureAlgorithm returns the algorithm of the signatures of the key (the a= tag)
+func (k *Key) SignatureAlgorithm() string {
+	if k.Algorithm() == AlgorithmEd25519 {
+		return SignatureEd25519
+	}
+	return SignatureRSA
+}
+
+// Sign returns the DKIM-Signature header field (ending with CRLF) with which the key signs the message,
+// the header and the body are canonicalized with the relaxed algorithm (RFC 6376).
+// Ed25519 keys sign the SHA-256 hash with PureEdDSA (RFC 8463).
+func (k *Key) Sign(message []byte, now time.Time) (string, error) {
+	fields, body := splitMessage(message)
+	bodyHash := sha256.Sum256(canonicalBody(body, Relaxed))
+
+	hash := sha256.New()
+	names := []string{}
+	used := make(map[int]bool)
+	for _, name := range SignedFields {
+		// all occurrences are signed, starting with the last one
+		for i := selectField(fields, name, used); i >= 0; i = selectField(fields, name, used) {
+			hash.Write([]byte(canonicalHeader(fields[i], Relaxed)))
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	if len(names) == 0 || names[0] != "from" {
+		return "", errors.New("the message has no From field")
+	}
+
+	field := "DKIM-Signature: v=1; a=" + k.SignatureAlgorithm() + "; c=relaxed/relaxed;\r\n" +
+		"\td=" + strings.TrimSuffix(k.Domain, ".") + "; s=" + k.Selector + "; t=" + strconv.FormatInt(now.Unix(), 10) + ";\r\n" +
+		"\th=" + foldNames(names) + ";\r\n" +
+		"\tbh=" + base64.StdEncoding.EncodeToString(bodyHash[:]) + ";\r\n" +
+		"\tb="
+	hash.Write([]byte(strings.TrimSuffix(canonicalHeader(field, Relaxed), "\r\n")))
+
+	signature, err := k.sign(hash.Sum(nil))
+	if err != nil {
+		return "", err
+	}
+	return field + fold(base64.StdEncoding.EncodeToString(signature)) + "\r\n", nil
+}
+
+// sign signs the hash of the header
+func (k *Key) sign(digest []byte) ([]byte, error) {
+	if key, ok := k.Signer.(ed25519.PrivateKey); ok {
+		return ed25519.Sign(key, digest), nil
+	}
+	return k.Signer.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+// selectField returns the index of the last header field with the name which isn't used yet, or -1
+func selectField(fields []string, name string, used map[int]bool) int {
+	for i := len(fields) - 1; i >= 0; i-- {
+		if !used[i] && strings.EqualFold(helpers.FieldName(fields[i]), name) {
+			used[i] = true
+			return i
+		}
+	}
+	return -1
+}
+
+// foldNames joins the names of the signed fields with colons, over lines of at most 76 characters
+func foldNames(names []string) string {
+	folded, line := "", 2
+	for i, name := range names {
+		if i > 0 {
+			folded += ":"
+			line++
+			if line+len(name) > 72 {
+				folded += "\r\n\t"
+				line = 0
+			}
+		}
+		folded += name
+		line += len(name)
+	}
+	return folded
+}
+
+// fold splits the base64 value over lines of at most 76 characters
+func fold(value string) string {
+	lines := []string{}
+	for len(value) > 72 {
+		lines = append(lines, value[:72])
+		value = value[72:]
+	}
+	return strings.Join(append(lines, value), "\r\n\t")
+}
@@ -0,0 +1,250 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gopistolet/gopistolet/helpers"
+)
+
+// Results of the verification of a signature (RFC 8601 section 2.7.1)
+const (
+	StatusPass      = "pass"
+	StatusFail      = "fail"
+	StatusTempError = "temperror"
+	StatusPermError = "permerror"
+)
+
+// Result is the outcome of the verification of a signature
+type Result struct {
+	Domain    string
+	Selector  string
+	Algorithm string
+	Status    string
+	// Err explains why the signature didn't pass
+	Err error
+}
+
+// Verify checks the DKIM signatures of the message with the keys in DNS, it returns a result per signature.
+// Signatures with rsa-sha256 and ed25519-sha256 (RFC 8463) are supported.
+// lookupTXT is net.LookupTXT if it's nil.
+func Verify(message []byte, lookupTXT func(name string) ([]string, error)) []Result {
+	if lookupTXT == nil {
+		lookupTXT = net.LookupTXT
+	}
+	fields, body := splitMessage(message)
+	results := []Result{}
+	for _, field := range fields {
+		if strings.EqualFold(helpers.FieldName(field), "DKIM-Signature") {
+			results = append(results, verify(field, fields, body, lookupTXT, time.Now()))
+		}
+	}
+	return results
+}
+
+// verify checks one signature
+func verify(field string, fields []string, body []byte, lookupTXT func(string) ([]string, error), now time.Time) Result {
+	tags, err := parseTags(helpers.FieldValue(field))
+	result := Result{Domain: tags["d"], Selector: tags["s"], Algorithm: tags["a"]}
+	fail := func(status, format string, args ...interface{}) Result {
+		result.Status = status
+		result.Err = fmt.Errorf(format, args...)
+		return result
+	}
+	if err != nil {
+		return fail(StatusPermError, "%v", err)
+	}
+
+	if tags["v"] != "1" {
+		return fail(StatusPermError, "unsupported version %q", tags["v"])
+	}
+	for _, tag := range []string{"a", "b", "bh", "d", "h", "s"} {
+		if tags[tag] == "" {
+			return fail(StatusPermError, "missing tag %s", tag)
+		}
+	}
+	if result.Algorithm != SignatureRSA && result.Algorithm != SignatureEd25519 {
+		return fail(StatusPermError, "unsupported algorithm %q", result.Algorithm)
+	}
+	headerCanonicalization, bodyCanonicalization := Simple, Simple
+	if c := tags["c"]; c != "" {
+		parts := strings.SplitN(c, "/", 2)
+		headerCanonicalization = parts[0]
+		if len(parts) == 2 {
+			bodyCanonicalization = parts[1]
+		}
+	}
+	for _, c := range []string{headerCanonicalization, bodyCanonicalization} {
+		if c != Simple && c != Relaxed {
+			return fail(StatusPermError, "unsupported canonicalization %q", tags["c"])
+		}
+	}
+	names := strings.Split(tags["h"], ":")
+	signsFrom := false
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+		signsFrom = signsFrom || strings.EqualFold(names[i], "From")
+	}
+	if !signsFrom {
+		return fail(StatusPermError, "From isn't signed")
+	}
+	if x := tags["x"]; x != "" {
+		expires, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return fail(StatusPermError, "invalid expiration %q", x)
+		}
+		if now.Unix() > expires {
+			return fail(StatusFail, "signature expired")
+		}
+	}
+
+	canonical := canonicalBody(body, bodyCanonicalization)
+	if l := tags["l"]; l != "" {
+		length, err := strconv.Atoi(l)
+		if err != nil || length < 0 || length > len(canonical) {
+			return fail(StatusPermError, "invalid body length %q", l)
+		}
+		canonical = canonical[:length]
+	}
+	bodyHash := sha256.Sum256(canonical)
+	if expected, err := base64.StdEncoding.DecodeString(tags["bh"]); err != nil || !bytes.Equal(expected, bodyHash[:]) {
+		return fail(StatusFail, "body hash doesn't match")
+	}
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fail(StatusPermError, "invalid signature")
+	}
+
+	hash := sha256.New()
+	used := make(map[int]bool)
+	for _, name := range names {
+		// fields which are signed but don't exist are skipped
+		if i := selectField(fields, name, used); i >= 0 {
+			hash.Write([]byte(canonicalHeader(fields[i], headerCanonicalization)))
+		}
+	}
+	hash.Write([]byte(strings.TrimSuffix(canonicalHeader(withoutSignature(field), headerCanonicalization), "\r\n")))
+	digest := hash.Sum(nil)
+
+	key, status, err := lookupKey(result.Selector, result.Domain, lookupTXT)
+	if err != nil {
+		return fail(status, "%v", err)
+	}
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		if result.Algorithm != SignatureEd25519 {
+			return fail(StatusPermError, "the key isn't an RSA key")
+		}
+		if !ed25519.Verify(key, digest, signature) {
+			return fail(StatusFail, "signature doesn't verify")
+		}
+	case *rsa.PublicKey:
+		if result.Algorithm != SignatureRSA {
+			return fail(StatusPermError, "the key isn't an ed25519 key")
+		}
+		// RSA keys shorter than 1024 bits aren't secure (RFC 8301 section 3.2)
+		if key.N.BitLen() < 1024 {
+			return fail(StatusPermError, "RSA key with %d bits", key.N.BitLen())
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature) != nil {
+			return fail(StatusFail, "signature doesn't verify")
+		}
+	}
+	result.Status = StatusPass
+	return result
+}
+
+// lookupKey returns the public key of the selector of the domain, and the status if there's an error
+func lookupKey(selector, domain string, lookupTXT func(string) ([]string, error)) (crypto.PublicKey, string, error) {
+	name := selector + "._domainkey." + strings.TrimSuffix(domain, ".")
+	records, err := lookupTXT(name)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, StatusPermError, fmt.Errorf("no key at %s", name)
+		}
+		return nil, StatusTempError, err
+	}
+	if len(records) != 1 {
+		return nil, StatusPermError, fmt.Errorf("%d records at %s", len(records), name)
+	}
+
+	tags, err := parseTags(records[0])
+	if err != nil {
+		return nil, StatusPermError, fmt.Errorf("%s: %v", name, err)
+	}
+	if v := tags["v"]; v != "" && v != "DKIM1" {
+		return nil, StatusPermError, fmt.Errorf("%s: unsupported version %q", name, v)
+	}
+	if tags["p"] == "" {
+		return nil, StatusPermError, fmt.Errorf("%s: key revoked", name)
+	}
+	public, err := base64.StdEncoding.DecodeString(tags["p"])
+	if err != nil {
+		return nil, StatusPermError, fmt.Errorf("%s: invalid key", name)
+	}
+
+	switch tags["k"] {
+	case "", AlgorithmRSA:
+		key, err := x509.ParsePKIXPublicKey(public)
+		if err != nil {
+			// some records contain an RSAPublicKey instead of a SubjectPublicKeyInfo
+			if key, err := x509.ParsePKCS1PublicKey(public); err == nil {
+				return key, "", nil
+			}
+			return nil, StatusPermError, fmt.Errorf("%s: invalid key", name)
+		}
+		if _, ok := key.(*rsa.PublicKey); !ok {
+			return nil, StatusPermError, fmt.Errorf("%s: not an RSA key", name)
+		}
+		return key, "", nil
+	case AlgorithmEd25519:
+		if len(public) != ed25519.PublicKeySize {
+			return nil, StatusPermError, fmt.Errorf("%s: invalid key", name)
+		}
+		return ed25519.PublicKey(public), "", nil
+	}
+	return nil, StatusPermError, fmt.Errorf("%s: unsupported key type %q", name, tags["k"])
+}
+
+// parseTags parses a tag list (RFC 6376 section 3.2), whitespace is removed from the values
+func parseTags(list string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(list, ";") {
+		if strings.TrimSpace(tag) == "" {
+			continue
+		}
+		i := strings.IndexByte(tag, '=')
+		if i < 0 {
+			return tags, fmt.Errorf("invalid tag %q", strings.TrimSpace(tag))
+		}
+		name := strings.TrimSpace(tag[:i])
+		if _, found := tags[name]; found {
+			return tags, errors.New("duplicate tag " + name)
+		}
+		tags[name] = strings.Join(strings.Fields(tag[i+1:]), "")
+	}
+	return tags, nil
+}
+
+// withoutSignature returns the DKIM-Signature field with an empty b= tag, as it was signed
+func withoutSignature(field string) string {
+	i := strings.IndexByte(field, ':')
+	tags := strings.Split(field[i+1:], ";")
+	for j, tag := range tags {
+		if k := strings.IndexByte(tag, '='); k >= 0 && strings.TrimSpace(tag[:k]) == "b" {
+			tags[j] = tag[:k+1]
+		}
+	}
+	return field[:i+1] + strings.Join(tags, ";")
+}
@@ -0,0 +1,156 @@
+package dkim
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// crlf converts the line breaks of the message to CRLF
+func crlf(message string) []byte {
+	return []byte(strings.Replace(message, "\n", "\r\n", -1))
+}
+
+func TestVerify(t *testing.T) {
+
+	Convey("Testing the example of RFC 8463", t, func() {
+		message := crlf(`DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;
+ d=football.example.com; i=@football.example.com;
+ q=dns/txt; s=brisbane; t=1528637909; h=from : to :
+ subject : date : message-id : from : subject : date;
+ bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;
+ b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus
+ Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==
+From: Joe SixPack <joe@football.example.com>
+To: Suzie Q <suzie@shopping.example.net>
+Subject: Is dinner ready?
+Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)
+Message-ID: <20030712040037.46341.5F8J@football.example.com>
+
+Hi.
+
+We lost the game.  Are you hungry yet?
+
+Joe.
+`)
+		lookups := []string{}
+		lookup := func(name string) ([]string, error) {
+			lookups = append(lookups, name)
+			return []string{"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}, nil
+		}
+
+		results := Verify(message, lookup)
+		So(results, ShouldResemble, []Result{{Domain: "football.example.com", Selector: "brisbane", Algorithm: SignatureEd25519, Status: StatusPass}})
+		So(lookups, ShouldResemble, []string{"brisbane._domainkey.football.example.com"})
+
+		// a changed body fails
+		tampered := []byte(strings.Replace(string(message), "lost", "won", 1))
+		results = Verify(tampered, lookup)
+		So(results[0].Status, ShouldEqual, StatusFail)
+		So(results[0].Err.Error(), ShouldEqual, "body hash doesn't match")
+	})
+
+	Convey("Testing Sign and Verify", t, func() {
+		message := crlf("From: Bob <bob@example.com>\nTo:  alice@example.org\nSubject: Hello\n  world\n\nHi Alice,  \n\n\n")
+		long := crlf("From: bob@example.com\nReply-To: bob@example.com\nSubject: Hi\nDate: today\nTo: alice@example.org\nCc: carol@example.org\n" +
+			"Message-ID: <1@example.com>\nIn-Reply-To: <0@example.org>\nReferences: <0@example.org>\nMIME-Version: 1.0\n" +
+			"Content-Type: text/plain\nContent-Transfer-Encoding: 7bit\nList-Id: <list.example.com>\n\nHi\n")
+		now := time.Now()
+
+		for _, algorithm := range []string{AlgorithmRSA, AlgorithmEd25519} {
+			signer, err := GenerateKey(algorithm, 1024)
+			So(err, ShouldEqual, nil)
+			key := &Key{Domain: "example.com", Selector: "sel", Signer: signer}
+			record, err := key.Record()
+			So(err, ShouldEqual, nil)
+			lookup := func(name string) ([]string, error) {
+				if name != "sel._domainkey.example.com" {
+					return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+				}
+				return []string{record}, nil
+			}
+
+			field, err := key.Sign(message, now)
+			So(err, ShouldEqual, nil)
+			So(field, ShouldStartWith, "DKIM-Signature: v=1; a="+key.SignatureAlgorithm()+"; c=relaxed/relaxed;\r\n\td=example.com; s=sel; t=")
+			So(field, ShouldContainSubstring, "h=from:subject:to;")
+			for _, line := range strings.Split(field, "\r\n") {
+				So(len(line), ShouldBeLessThanOrEqualTo, 78)
+			}
+
+			signed := append([]byte(field), message...)
+			results := Verify(signed, lookup)
+			So(len(results), ShouldEqual, 1)
+			So(results[0].Status, ShouldEqual, StatusPass)
+			So(results[0].Algorithm, ShouldEqual, key.SignatureAlgorithm())
+
+			// all fields are signed, and the field is folded
+			field, err = key.Sign(long, now)
+			So(err, ShouldEqual, nil)
+			for _, line := range strings.Split(field, "\r\n") {
+				So(len(line), ShouldBeLessThanOrEqualTo, 78)
+			}
+			So(Verify(append([]byte(field), long...), lookup)[0].Status, ShouldEqual, StatusPass)
+
+			// relaxed canonicalization allows changes in whitespace
+			relaxed := []byte(strings.Replace(string(signed), "Subject: Hello\r\n  world", "subject:Hello world ", 1))
+			So(Verify(relaxed, lookup)[0].Status, ShouldEqual, StatusPass)
+
+			// but not in the content
+			changed := []byte(strings.Replace(string(signed), "Subject: Hello", "Subject: Bye", 1))
+			results = Verify(changed, lookup)
+			So(results[0].Status, ShouldEqual, StatusFail)
+			So(results[0].Err.Error(), ShouldEqual, "signature doesn't verify")
+
+			// the keys of other selectors don't exist
+			moved := []byte(strings.Replace(string(signed), "s=sel;", "s=other;", 1))
+			So(Verify(moved, lookup)[0].Status, ShouldEqual, StatusPermError)
+		}
+
+		_, err := (&Key{Domain: "example.com", Selector: "sel", Signer: nil}).Sign(crlf("To: alice@example.org\n\nHi\n"), now)
+		So(err, ShouldNotEqual, nil)
+	})
+
+	Convey("Testing Verify errors", t, func() {
+		signer, _ := GenerateKey(AlgorithmEd25519, 0)
+		key := &Key{Domain: "example.com", Selector: "sel", Signer: signer}
+		message := crlf("From: bob@example.com\n\nHi\n")
+		field, err := key.Sign(message, time.Now())
+		So(err, ShouldEqual, nil)
+		signed := append([]byte(field), message...)
+
+		results := Verify(signed, func(string) ([]string, error) { return nil, errors.New("timeout") })
+		So(results[0].Status, ShouldEqual, StatusTempError)
+
+		// an RSA key for an ed25519 signature
+		rsaSigner, _ := GenerateKey(AlgorithmRSA, 1024)
+		record, _ := (&Key{Signer: rsaSigner}).Record()
+		results = Verify(signed, func(string) ([]string, error) { return []string{record}, nil })
+		So(results[0].Status, ShouldEqual, StatusPermError)
+
+		// a revoked key
+		results = Verify(signed, func(string) ([]string, error) { return []string{"v=DKIM1; k=ed25519; p="}, nil })
+		So(results[0].Err.Error(), ShouldEndWith, "key revoked")
+
+		results = Verify(crlf("DKIM-Signature: v=1; a=rsa-sha1; d=example.com; s=sel; h=from; bh=AA==; b=AA==\nFrom: bob@example.com\n\nHi\n"), nil)
+		So(results[0].Status, ShouldEqual, StatusPermError)
+		So(results[0].Err.Error(), ShouldEqual, `unsupported algorithm "rsa-sha1"`)
+
+		So(Verify(message, nil), ShouldResemble, []Result{})
+	})
+
+	Convey("Testing canonicalization", t, func() {
+		So(canonicalHeader("Subject:  Hello \r\n\t world  \r\n", Relaxed), ShouldEqual, "subject:Hello world\r\n")
+		So(canonicalHeader("Subject:  Hello\r\n", Simple), ShouldEqual, "Subject:  Hello\r\n")
+		So(string(canonicalBody([]byte(" a  b \r\n\r\n\r\n"), Relaxed)), ShouldEqual, " a b\r\n")
+		So(string(canonicalBody([]byte(" a  b \r\n\r\n"), Simple)), ShouldEqual, " a  b \r\n")
+		So(string(canonicalBody(nil, Relaxed)), ShouldEqual, "")
+		So(string(canonicalBody(nil, Simple)), ShouldEqual, "\r\n")
+		So(string(canonicalBody([]byte("no line break"), Simple)), ShouldEqual, "no line break\r\n")
+	})
+
+}
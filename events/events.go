@@ -0,0 +1,196 @@
+// Package events contains the event bus, on which the events in the life of the connections and messages
+// are published for the features which follow them (metrics, the audit log, ...)
+package events
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+)
+
+// Event is something which happened in the life of a connection or a message,
+// Name is the name of its type (e.g. MessageQueued)
+type Event interface {
+	Name() string
+}
+
+// ConnectionOpened is published when a client connects, Refused if it's sent away by the blacklists
+type ConnectionOpened struct {
+	Ip      string
+	Refused bool
+}
+
+// AuthSucceeded is published when a client authenticated with AUTH
+type AuthSucceeded struct {
+	Ip        string
+	Username  string
+	Mechanism string
+}
+
+// AuthFailed is published when an authentication attempt failed
+type AuthFailed struct {
+	Ip        string
+	Username  string
+	Mechanism string
+}
+
+// MessageReceived is published when the handlers get a message
+type MessageReceived struct {
+	SessionId string
+	Ip        string
+	Helo      string
+	From      string
+	To        []string
+	Size      int
+}
+
+// MessageHandled is published when the handlers are done with a message,
+// Delivered are the recipients which they didn't drop
+type MessageHandled struct {
+	Received  time.Time
+	Completed time.Time
+	SessionId string
+	Ip        string
+	Helo      string
+	From      string
+	To        []string
+	Delivered []string
+	Size      int
+}
+
+// MessageQueued is published when a message is queued for relaying
+type MessageQueued struct {
+	SessionId string
+	From      string
+	To        []string
+	// File is the id of the message in the queue (the name of its file with the files backend)
+	File string
+}
+
+// MessageDelivered is published when a message is delivered to the recipients,
+// Destination is "maildir" or the host it was relayed to
+type MessageDelivered struct {
+	SessionId   string
+	Recipients  []string
+	Destination string
+}
+
+// DeliveryFailed is published when a message couldn't be delivered to the recipients,
+// it's retried later unless the failure is Permanent
+type DeliveryFailed struct {
+	SessionId   string
+	Recipients  []string
+	Destination string
+	Error       string
+	Permanent   bool
+}
+
+func (ConnectionOpened) Name() string { return "ConnectionOpened" }
+func (AuthSucceeded) Name() string    { return "AuthSucceeded" }
+func (AuthFailed) Name() string       { return "AuthFailed" }
+func (MessageReceived) Name() string  { return "MessageReceived" }
+func (MessageHandled) Name() string   { return "MessageHandled" }
+func (MessageQueued) Name() string    { return "MessageQueued" }
+func (MessageDelivered) Name() string { return "MessageDelivered" }
+func (DeliveryFailed) Name() string   { return "DeliveryFailed" }
+
+// Bus passes the published events to the subscribers, so features like metrics and the audit log
+// can follow the connections and messages without being wired into each place where something happens.
+//
+// Subscribers are called synchronously by the publisher, in the order in which they subscribed,
+// so they should hand slow work (like calling a webhook) off to a goroutine. A panicking subscriber is logged
+// and doesn't affect the publisher or the other subscribers.
+type Bus struct {
+	mutex       sync.RWMutex
+	subscribers []*subscriber
+}
+
+type subscriber struct {
+	handle func(Event)
+}
+
+// Subscribe calls handle for every published event until unsubscribe is called
+func (b *Bus) Subscribe(handle func(Event)) (unsubscribe func()) {
+	s := &subscriber{handle: handle}
+	b.mutex.Lock()
+	b.subscribers = append(b.subscribers, s)
+	b.mutex.Unlock()
+
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		for i, other := range b.subscribers {
+			if other == s {
+				b.subscribers = append(b.subscribers[:i:i], b.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish passes the event to the subscribers
+func (b *Bus) Publish(event Event) {
+	b.mutex.RLock()
+	subscribers := b.subscribers
+	b.mutex.RUnlock()
+
+	for _, s := range subscribers {
+		b.call(s, event)
+	}
+}
+
+func (b *Bus) call(s *subscriber, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Event subscriber panicked on %s: %v\n%s", event.Name(), r, debug.Stack())
+		}
+	}()
+	s.handle(event)
+}
+
+// Blacklist returns a blacklist which checks the IPs with blacklist,
+// and publishes a ConnectionOpened event for every client
+func (b *Bus) Blacklist(blacklist helpers.Blacklist) helpers.Blacklist {
+	return &eventBlacklist{bus: b, blacklist: blacklist}
+}
+
+type eventBlacklist struct {
+	bus       *Bus
+	blacklist helpers.Blacklist
+}
+
+func (e *eventBlacklist) CheckIp(ip string) bool {
+	refused := e.blacklist != nil && e.blacklist.CheckIp(ip)
+	e.bus.Publish(ConnectionOpened{Ip: ip, Refused: refused})
+	return refused
+}
+
+// Counts counts the published events per name
+type Counts struct {
+	mutex  sync.Mutex
+	counts map[string]uint64
+}
+
+// Count counts the event, it's meant to be subscribed to a Bus
+func (c *Counts) Count(event Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]uint64)
+	}
+	c.counts[event.Name()]++
+}
+
+// All returns the counts per event name
+func (c *Counts) All() map[string]uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	counts := make(map[string]uint64, len(c.counts))
+	for name, count := range c.counts {
+		counts[name] = count
+	}
+	return counts
+}
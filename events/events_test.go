@@ -0,0 +1,73 @@
+package events
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type blacklist map[string]bool
+
+func (b blacklist) CheckIp(ip string) bool { return b[ip] }
+
+func TestBus(t *testing.T) {
+
+	Convey("Testing Bus", t, func() {
+		bus := &Bus{}
+		// nothing subscribed
+		bus.Publish(MessageQueued{})
+
+		received := []string{}
+		unsubscribe := bus.Subscribe(func(event Event) {
+			received = append(received, "first "+event.Name())
+		})
+		bus.Subscribe(func(event Event) {
+			panic("broken subscriber")
+		})
+		bus.Subscribe(func(event Event) {
+			if queued, ok := event.(MessageQueued); ok {
+				received = append(received, "last "+queued.File)
+			}
+		})
+
+		bus.Publish(MessageQueued{File: "mailstore/1.json"})
+		So(received, ShouldResemble, []string{"first MessageQueued", "last mailstore/1.json"})
+
+		unsubscribe()
+		unsubscribe()
+		received = nil
+		bus.Publish(DeliveryFailed{})
+		bus.Publish(MessageQueued{File: "mailstore/2.json"})
+		So(received, ShouldResemble, []string{"last mailstore/2.json"})
+	})
+
+	Convey("Testing Blacklist", t, func() {
+		bus := &Bus{}
+		opened := []ConnectionOpened{}
+		bus.Subscribe(func(event Event) {
+			opened = append(opened, event.(ConnectionOpened))
+		})
+
+		b := bus.Blacklist(blacklist{"192.0.2.1": true})
+		So(b.CheckIp("192.0.2.1"), ShouldBeTrue)
+		So(b.CheckIp("192.0.2.2"), ShouldBeFalse)
+		So(bus.Blacklist(nil).CheckIp("192.0.2.1"), ShouldBeFalse)
+		So(opened, ShouldResemble, []ConnectionOpened{
+			{Ip: "192.0.2.1", Refused: true},
+			{Ip: "192.0.2.2"},
+			{Ip: "192.0.2.1"},
+		})
+	})
+
+	Convey("Testing Counts", t, func() {
+		bus := &Bus{}
+		counts := &Counts{}
+		So(counts.All(), ShouldResemble, map[string]uint64{})
+		bus.Subscribe(counts.Count)
+		bus.Publish(MessageReceived{})
+		bus.Publish(MessageHandled{})
+		bus.Publish(MessageReceived{})
+		So(counts.All(), ShouldResemble, map[string]uint64{"MessageReceived": 2, "MessageHandled": 1})
+	})
+
+}
@@ -1,11 +1,34 @@
 module github.com/gopistolet/gopistolet
 
-go 1.15
+go 1.17
 
 require (
+	github.com/BurntSushi/toml v0.3.0
+	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/go-ldap/ldap/v3 v3.4.1
+	github.com/go-sql-driver/mysql v1.7.1
+	github.com/gomodule/redigo v1.7.0
 	github.com/gopistolet/gospf v0.0.0-20160422193406-a58dd1fcbf50
 	github.com/gopistolet/smtp v0.0.0-20190814094038-be4f841baca2
+	github.com/lib/pq v1.10.9
+	github.com/miekg/dns v1.1.55
 	github.com/sirupsen/logrus v1.8.1
 	github.com/sloonz/go-maildir v0.0.0-20210417175458-ec35083290ab
 	github.com/smartystreets/goconvey v1.6.4
+	go.etcd.io/bbolt v1.3.6
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09
+	golang.org/x/crypto v0.10.0
+	golang.org/x/net v0.11.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20200615164410-66371956d46c // indirect
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/go-asn1-ber/asn1-ber v1.5.1 // indirect
+	github.com/jtolds/gls v4.20.0+incompatible // indirect
+	github.com/smartystreets/assertions v0.0.0-20180927180507-b2de0cb4f26d // indirect
+	github.com/yuin/gopher-lua v0.0.0-20220504180219-658193537a64 // indirect
+	golang.org/x/sys v0.9.0 // indirect
 )
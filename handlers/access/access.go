@@ -0,0 +1,142 @@
+package access
+
+import (
+	"strings"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// Actions of the access rules
+const (
+	ActionOk       = "OK"
+	ActionReject   = "REJECT"
+	ActionDefer    = "DEFER"
+	ActionRedirect = "REDIRECT"
+)
+
+func New(c *config.Config) *Access {
+	return &Access{
+		config: c,
+	}
+}
+
+// Access evaluates the access rules for every recipient, the first matching rule decides.
+// The SMTP sessions refuse the recipients which are rejected or deferred at RCPT, with Action.
+// The handler replaces the redirected recipients, and removes the rejected ones of the messages
+// which didn't come through an SMTP session (e.g. from the control socket).
+// The rules don't apply to the postmaster.
+//
+// Since the handlers run after the message was accepted, DEFER can't ask the client to retry there:
+// it's logged and the message is accepted.
+type Access struct {
+	config *config.Config
+}
+
+// Action returns the action (e.g. REJECT) and the arguments of the first rule which matches
+// the recipient of the state, an empty action if none matches
+func (handler *Access) Action(state *smtp.State, recipient *smtp.MailAddress) (string, []string) {
+	// the postmaster is always accepted
+	if handler.config.LocalDomains.IsPostmaster(recipient.Address) {
+		return "", nil
+	}
+	rule := Match(handler.config.CurrentAccessRules(), state, recipient)
+	if rule == nil {
+		return "", nil
+	}
+	action := strings.Fields(rule.Action)
+	if len(action) == 0 {
+		return "", nil
+	}
+	return strings.ToUpper(action[0]), action[1:]
+}
+
+func (handler *Access) Handle(state *smtp.State) {
+	if len(handler.config.CurrentAccessRules()) == 0 {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	to := make([]*smtp.MailAddress, 0, len(state.To))
+	for _, recipient := range state.To {
+		action, args := handler.Action(state, recipient)
+		switch action {
+		case ActionReject:
+			logger.Info("Access: rejected recipient ", recipient.String())
+		case ActionRedirect:
+			if len(args) < 1 {
+				logger.Warn("Access: REDIRECT without address for ", recipient.String())
+				to = append(to, recipient)
+				continue
+			}
+			logger.Infof("Access: redirected recipient %s to %s", recipient.String(), args[0])
+			to = append(to, &smtp.MailAddress{Address: args[0]})
+		case ActionDefer:
+			logger.Warn("Access: can't defer recipient ", recipient.String(), " after the message was accepted")
+			to = append(to, recipient)
+		default:
+			to = append(to, recipient)
+		}
+	}
+	state.To = to
+}
+
+// Match returns the first rule which matches the state and the recipient, or nil
+func Match(rules []config.AccessRule, state *smtp.State, recipient *smtp.MailAddress) *config.AccessRule {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Client != "" {
+			networks, err := helpers.ParseNetworks([]string{rule.Client})
+			if err != nil || !networks.Contains(state.Ip) {
+				continue
+			}
+		}
+		if rule.Helo != "" && !matchDomain(rule.Helo, state.Hostname) {
+			continue
+		}
+		if rule.From != "" && (state.From == nil || !matchAddress(rule.From, state.From.Address)) {
+			continue
+		}
+		if rule.To != "" && (recipient == nil || !matchAddress(rule.To, recipient.Address)) {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+// matchDomain matches the domain and its subdomains
+func matchDomain(pattern, domain string) bool {
+	pattern = strings.TrimPrefix(strings.ToLower(pattern), ".")
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	return domain == pattern || strings.HasSuffix(domain, "."+pattern)
+}
+
+// matchAddress matches a full address (user@example.com), a local part (user@)
+// or a domain (example.com or @example.com)
+func matchAddress(pattern, address string) bool {
+	pattern = strings.ToLower(pattern)
+	address = strings.ToLower(address)
+
+	at := strings.LastIndex(address, "@")
+	local, domain := address, ""
+	if at >= 0 {
+		local, domain = address[:at], address[at+1:]
+	}
+
+	switch {
+	case strings.HasPrefix(pattern, "@"):
+		return matchDomain(pattern[1:], domain)
+	case strings.HasSuffix(pattern, "@"):
+		return local == strings.TrimSuffix(pattern, "@")
+	case strings.Contains(pattern, "@"):
+		return address == pattern
+	}
+	return matchDomain(pattern, domain)
+}
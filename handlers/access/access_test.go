@@ -0,0 +1,91 @@
+package access
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccessHandler(t *testing.T) {
+
+	Convey("Testing matchAddress()", t, func() {
+		So(matchAddress("user@example.com", "User@Example.com"), ShouldEqual, true)
+		So(matchAddress("user@example.com", "other@example.com"), ShouldEqual, false)
+		So(matchAddress("example.com", "user@example.com"), ShouldEqual, true)
+		So(matchAddress("example.com", "user@mail.example.com"), ShouldEqual, true)
+		So(matchAddress("example.com", "user@badexample.com"), ShouldEqual, false)
+		So(matchAddress("@example.com", "user@example.com"), ShouldEqual, true)
+		So(matchAddress("postmaster@", "postmaster@example.org"), ShouldEqual, true)
+		So(matchAddress("postmaster@", "bob@example.org"), ShouldEqual, false)
+	})
+
+	Convey("Testing Access handler", t, func() {
+		c := config.Config{
+			AccessRules: []config.AccessRule{
+				{To: "postmaster@", Action: "OK"},
+				{Client: "192.168.66.0/24", Action: "REJECT"},
+				{From: "spam.example", To: "bob@example.com", Action: "REJECT"},
+				{To: "old@example.com", Action: "REDIRECT new@example.com"},
+				{Helo: "deferred.example", Action: "DEFER"},
+			},
+		}
+		h := New(&c)
+
+		newState := func(ip string, from string, to ...string) *smtp.State {
+			state := &smtp.State{
+				From:     &smtp.MailAddress{Address: from},
+				Ip:       net.ParseIP(ip),
+				Hostname: "mail.example.org",
+			}
+			for _, address := range to {
+				state.To = append(state.To, &smtp.MailAddress{Address: address})
+			}
+			return state
+		}
+
+		state := newState("192.168.66.1", "from@test.com", "bob@example.com", "postmaster@example.com")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 1)
+		So(state.To[0].Address, ShouldEqual, "postmaster@example.com")
+
+		// the postmaster of a local domain is accepted without the OK rule
+		c.AccessRules = c.AccessRules[1:]
+		c.LocalDomains = helpers.LocalDomains{"example.com": {}}
+		state = newState("192.168.66.1", "from@test.com", "bob@example.com", "Postmaster@example.com")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 1)
+		So(state.To[0].Address, ShouldEqual, "Postmaster@example.com")
+
+		state = newState("192.168.0.10", "from@mail.spam.example", "bob@example.com", "alice@example.com")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 1)
+		So(state.To[0].Address, ShouldEqual, "alice@example.com")
+
+		state = newState("192.168.0.10", "from@test.com", "old@example.com")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 1)
+		So(state.To[0].Address, ShouldEqual, "new@example.com")
+
+		state = newState("192.168.0.10", "from@test.com", "bob@example.com")
+		state.Hostname = "mx.deferred.example"
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 1)
+
+		// the sessions refuse the recipients at RCPT
+		action, _ := h.Action(state, &smtp.MailAddress{Address: "bob@example.com"})
+		So(action, ShouldEqual, ActionDefer)
+		action, args := h.Action(newState("192.168.0.10", "from@test.com"), &smtp.MailAddress{Address: "old@example.com"})
+		So(action, ShouldEqual, ActionRedirect)
+		So(args, ShouldResemble, []string{"new@example.com"})
+		action, _ = h.Action(newState("192.168.66.1", "from@test.com"), &smtp.MailAddress{Address: "postmaster@example.com"})
+		So(action, ShouldEqual, "")
+		action, _ = h.Action(newState("192.168.66.1", "from@test.com"), &smtp.MailAddress{Address: "bob@example.com"})
+		So(action, ShouldEqual, ActionReject)
+	})
+
+}
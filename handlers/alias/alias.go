@@ -0,0 +1,106 @@
+package alias
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// pipeTimeout is the time a pipe command gets to process a message
+const pipeTimeout = 5 * time.Minute
+
+func New(c *config.Config) *Alias {
+	return &Alias{
+		config: c,
+	}
+}
+
+// Alias replaces the recipients by the targets of their aliases.
+// Messages for pipe targets are handed to the command on stdin.
+type Alias struct {
+	config *config.Config
+
+	// SkipPipes drops the pipe targets without running their commands
+	SkipPipes bool
+}
+
+func (handler *Alias) Handle(state *smtp.State) {
+	handler.HandleContext(context.Background(), state)
+}
+
+// HandleContext kills the pipe commands when the context is canceled
+func (handler *Alias) HandleContext(ctx context.Context, state *smtp.State) {
+	aliases := &handler.config.Aliases
+	if aliases.File == "" && len(aliases.Map) == 0 {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	to := []*smtp.MailAddress{}
+	seen := make(map[string]bool)
+	for _, recipient := range state.To {
+		targets, err := aliases.Expand(recipient.Address)
+		if err != nil {
+			// deliver to the recipient itself instead of bouncing between aliases
+			logger.Errorf("Alias: couldn't expand %s: %v", recipient.Address, err)
+			targets = []string{recipient.Address}
+		}
+
+		for _, target := range targets {
+			if seen[strings.ToLower(target)] {
+				continue
+			}
+			seen[strings.ToLower(target)] = true
+
+			if strings.HasPrefix(target, "|") {
+				if handler.SkipPipes {
+					continue
+				}
+				if err := pipe(ctx, strings.TrimPrefix(target, "|"), state, recipient.Address); err != nil {
+					logger.Errorf("Alias: pipe to '%s' for %s failed: %v", target, recipient.Address, err)
+				} else {
+					logger.Infof("Alias: message for %s piped to '%s'", recipient.Address, target)
+				}
+				continue
+			}
+
+			if target != recipient.Address {
+				logger.Debugf("Alias: %s -> %s", recipient.Address, target)
+			}
+			to = append(to, &smtp.MailAddress{Address: target})
+		}
+	}
+	state.To = to
+}
+
+// pipe runs the command with the message on stdin,
+// the sender and recipient are passed in the SENDER and RECIPIENT environment variables
+func pipe(ctx context.Context, command string, state *smtp.State, recipient string) error {
+	ctx, cancel := context.WithTimeout(ctx, pipeTimeout)
+	defer cancel()
+
+	sender := ""
+	if state.From != nil {
+		sender = state.From.Address
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(state.Data)
+	cmd.Env = append(os.Environ(), "SENDER="+sender, "RECIPIENT="+recipient)
+	output, err := cmd.CombinedOutput()
+	if err != nil && len(output) > 0 {
+		log.Debugf("Alias: output of '%s': %s", command, output)
+	}
+	return err
+}
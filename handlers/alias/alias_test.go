@@ -0,0 +1,82 @@
+package alias
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAlias(t *testing.T) {
+
+	Convey("Testing Alias handler", t, func() {
+		dir, err := ioutil.TempDir("", "alias")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		output := filepath.Join(dir, "piped")
+
+		c := config.Config{
+			Aliases: helpers.Aliases{
+				Map: map[string][]string{
+					"postmaster": {"root"},
+					"root":       {"bob", "alice@example.org"},
+					"tickets":    {"|cat > " + output + "; echo $RECIPIENT >> " + output},
+					"loop1":      {"loop2"},
+					"loop2":      {"loop1"},
+				},
+			},
+		}
+		h := New(&c)
+
+		newState := func(to ...string) *smtp.State {
+			state := &smtp.State{
+				From: &smtp.MailAddress{Address: "from@test.com"},
+				Ip:   net.ParseIP("192.168.0.10"),
+				Data: []byte("Hello world!\n"),
+			}
+			for _, address := range to {
+				state.To = append(state.To, &smtp.MailAddress{Address: address})
+			}
+			return state
+		}
+
+		state := newState("postmaster@example.com", "root@example.com", "carol@example.com")
+		h.Handle(state)
+		addresses := []string{}
+		for _, to := range state.To {
+			addresses = append(addresses, to.Address)
+		}
+		So(addresses, ShouldResemble, []string{"bob@example.com", "alice@example.org", "carol@example.com"})
+
+		// pipes
+		state = newState("tickets@example.com")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 0)
+		piped, err := ioutil.ReadFile(output)
+		So(err, ShouldEqual, nil)
+		So(string(piped), ShouldEqual, "Hello world!\ntickets@example.com\n")
+
+		So(os.Remove(output), ShouldEqual, nil)
+		h.SkipPipes = true
+		state = newState("tickets@example.com")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 0)
+		_, err = os.Stat(output)
+		So(os.IsNotExist(err), ShouldBeTrue)
+		h.SkipPipes = false
+
+		// loops deliver to the recipient itself
+		state = newState("loop1@example.com")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 1)
+		So(state.To[0].Address, ShouldEqual, "loop1@example.com")
+	})
+
+}
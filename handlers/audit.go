@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/events"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// Dispositions of an audited transaction
+const (
+	// DispositionAccepted means that the message was handed to delivery for at least one recipient
+	DispositionAccepted = "accepted"
+	// DispositionDropped means that the handlers removed all recipients (rejected, quarantined, ...)
+	DispositionDropped = "dropped"
+	// DispositionDeferred means that the message couldn't be stored, the client got a temporary failure (451)
+	DispositionDeferred = "deferred"
+	// DispositionRejected means that the handlers refused the message with a permanent failure (e.g. 553)
+	DispositionRejected = "rejected"
+)
+
+/**
+ * Audit writes one JSON record per transaction to an audit log, for SIEM tooling.
+ *
+ * It records the MessageHandled events of the event bus, or the messages of Handler if it's used as handler.
+ * The record contains the recipients which were delivered (Delivered) next to the envelope
+ * as the client sent it (To), the user which authenticated and the ID of the message in the queue
+ * (from the MessageQueued events). Transactions which failed (see helpers.Acceptance) are deferred
+ * or rejected, what was stored for them is taken back.
+ */
+type Audit struct {
+	Handler Handler
+
+	// File to which the records are appended (one JSON object per line)
+	File string
+
+	// Logins and Xclient have the users which authenticated, Acceptance the failed transactions (they may be nil)
+	Logins     *helpers.Logins
+	Xclient    *helpers.XclientSessions
+	Acceptance *helpers.Acceptance
+
+	mutex sync.Mutex
+	// queued are the queue IDs of the messages which are being handled, by session ID
+	queued map[string]string
+}
+
+// AuditRecord is an audit log entry for a transaction
+type AuditRecord struct {
+	// Received is when the handlers got the message, Completed when they were done
+	Received  time.Time
+	Completed time.Time
+	SessionId string
+	// QueueId is the ID of the message in the queue, empty if it wasn't queued for relaying
+	QueueId string
+	Ip      string
+	Helo    string
+	// User which authenticated with AUTH, or at the proxy which reported the client with XCLIENT
+	User        string `json:",omitempty"`
+	From        string
+	To          []string
+	Delivered   []string
+	Size        int
+	Disposition string
+	// Error is the failure of a deferred or rejected transaction
+	Error string `json:",omitempty"`
+}
+
+func (a *Audit) Handle(state *smtp.State) {
+	a.HandleContext(context.Background(), state)
+}
+
+func (a *Audit) HandleContext(ctx context.Context, state *smtp.State) {
+	a.write(a.record(track(ctx, a.Handler, state), ""))
+}
+
+// Record writes the record of a MessageHandled event, and keeps the queue ID of a MessageQueued event
+// for it. Other events are ignored.
+func (a *Audit) Record(event events.Event) {
+	switch event := event.(type) {
+	case events.MessageQueued:
+		a.mutex.Lock()
+		if a.queued == nil {
+			a.queued = make(map[string]string)
+		}
+		// the message for the envelope recipients is queued first, the expanded lists later
+		if _, found := a.queued[event.SessionId]; !found {
+			a.queued[event.SessionId] = event.File
+		}
+		a.mutex.Unlock()
+	case events.MessageHandled:
+		a.mutex.Lock()
+		queueId := a.queued[event.SessionId]
+		delete(a.queued, event.SessionId)
+		a.mutex.Unlock()
+		a.write(a.record(event, queueId))
+	}
+}
+
+// record returns the record of the transaction, while its session still exists
+func (a *Audit) record(handled events.MessageHandled, queueId string) *AuditRecord {
+	record := &AuditRecord{
+		Received:    handled.Received,
+		Completed:   handled.Completed,
+		SessionId:   handled.SessionId,
+		QueueId:     queueId,
+		Ip:          handled.Ip,
+		Helo:        handled.Helo,
+		From:        handled.From,
+		To:          handled.To,
+		Delivered:   handled.Delivered,
+		Size:        handled.Size,
+		Disposition: DispositionAccepted,
+	}
+	if user, found := a.Logins.Get(handled.SessionId); found {
+		record.User = user
+	} else if client, found := a.Xclient.Get(handled.SessionId); found {
+		record.User = client.Login
+	}
+
+	if err := a.Acceptance.Failed(handled.SessionId); err != nil {
+		record.Disposition = DispositionDeferred
+		if e, ok := err.(*helpers.EsmtpError); ok && e.Code >= 500 {
+			record.Disposition = DispositionRejected
+		}
+		record.Error = err.Error()
+		record.Delivered = []string{}
+	} else if len(handled.Delivered) == 0 {
+		record.Disposition = DispositionDropped
+	}
+	return record
+}
+
+func (a *Audit) write(record *AuditRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("Audit: couldn't encode record: %v", err)
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	file, err := os.OpenFile(a.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Errorf("Audit: couldn't open audit log: %v", err)
+		return
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		log.Errorf("Audit: couldn't write audit log: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/events"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAudit(t *testing.T) {
+
+	Convey("Testing Audit handler", t, func() {
+		dir, err := ioutil.TempDir("", "audit")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "audit.log")
+
+		newState := func() *smtp.State {
+			return &smtp.State{
+				From:     &smtp.MailAddress{Address: "from@test.com"},
+				To:       []*smtp.MailAddress{{Address: "to@test.com"}},
+				Data:     []byte("Subject: test\r\n\r\nHello\r\n"),
+				Ip:       net.ParseIP("192.0.2.1"),
+				Hostname: "client.test.com",
+			}
+		}
+
+		audit := &Audit{Handler: &HandlerMachanism{Handlers: []Handler{&HeaderHandler{Header: "X-Spam: no"}}}, File: file}
+		audit.Handle(newState())
+		audit.Handler = &DropHandler{}
+		audit.Handle(newState())
+
+		content, err := ioutil.ReadFile(file)
+		So(err, ShouldEqual, nil)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		So(len(lines), ShouldEqual, 2)
+
+		records := make([]AuditRecord, 2)
+		for i, line := range lines {
+			So(json.Unmarshal([]byte(line), &records[i]), ShouldEqual, nil)
+		}
+		So(records[0].Ip, ShouldEqual, "192.0.2.1")
+		So(records[0].Helo, ShouldEqual, "client.test.com")
+		So(records[0].From, ShouldEqual, "from@test.com")
+		So(records[0].To, ShouldResemble, []string{"to@test.com"})
+		So(records[0].Delivered, ShouldResemble, []string{"to@test.com"})
+		So(records[0].Size, ShouldEqual, len(newState().Data))
+		So(records[0].Disposition, ShouldEqual, DispositionAccepted)
+		So(records[0].Completed.Before(records[0].Received), ShouldEqual, false)
+
+		So(records[1].Delivered, ShouldResemble, []string{})
+		So(records[1].Disposition, ShouldEqual, DispositionDropped)
+	})
+
+	Convey("Testing Audit of the events", t, func() {
+		dir, err := ioutil.TempDir("", "audit")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "audit.log")
+
+		audit := &Audit{File: file, Logins: &helpers.Logins{}, Acceptance: &helpers.Acceptance{}}
+		audit.Logins.Set("1", "bob@example.com")
+		audit.Record(events.MessageQueued{SessionId: "1", File: "queued-1"})
+		audit.Record(events.MessageQueued{SessionId: "1", File: "queued-list"})
+		audit.Record(events.MessageHandled{SessionId: "1", To: []string{"to@test.com"}, Delivered: []string{"to@test.com"}})
+		// the message couldn't be stored
+		audit.Acceptance.Fail("2", errors.New("disk full"))
+		audit.Record(events.MessageHandled{SessionId: "2", To: []string{"to@test.com"}, Delivered: []string{"to@test.com"}})
+		// the handlers refused the sender
+		audit.Acceptance.Fail("3", &helpers.EsmtpError{Code: 553, Message: "5.7.1 Sender not allowed"})
+		audit.Record(events.MessageHandled{SessionId: "3", To: []string{"to@test.com"}})
+
+		content, err := ioutil.ReadFile(file)
+		So(err, ShouldEqual, nil)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		So(len(lines), ShouldEqual, 3)
+		records := make([]AuditRecord, 3)
+		for i, line := range lines {
+			So(json.Unmarshal([]byte(line), &records[i]), ShouldEqual, nil)
+		}
+
+		So(records[0].SessionId, ShouldEqual, "1")
+		So(records[0].QueueId, ShouldEqual, "queued-1")
+		So(records[0].User, ShouldEqual, "bob@example.com")
+		So(records[0].Disposition, ShouldEqual, DispositionAccepted)
+		So(audit.queued, ShouldBeEmpty)
+
+		So(records[1].QueueId, ShouldEqual, "")
+		So(records[1].User, ShouldEqual, "")
+		So(records[1].Disposition, ShouldEqual, DispositionDeferred)
+		So(records[1].Delivered, ShouldResemble, []string{})
+		So(records[1].Error, ShouldEqual, "disk full")
+
+		So(records[2].Disposition, ShouldEqual, DispositionRejected)
+		So(records[2].Error, ShouldStartWith, "553 5.7.1")
+	})
+
+}
@@ -0,0 +1,85 @@
+package callout
+
+import (
+	"net/textproto"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/client"
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// verified is the cached verdict of senders which were accepted
+const verified = "ok"
+
+func New(c *config.Config) *Callout {
+	return &Callout{
+		config:   c,
+		verdicts: &helpers.VerdictCache{TTL: c.Callout.CacheTTL},
+		verify:   client.Verify,
+	}
+}
+
+// Callout verifies the senders of incoming mail with SMTP callouts (see config.Callout).
+// The session checks the sender at RCPT, senders which the MX hosts of their domain refuse are refused,
+// since their messages can't be answered or bounced. Senders which can't be verified (timeouts,
+// servers which refuse the null sender, rate limits) are accepted.
+type Callout struct {
+	config *config.Config
+
+	// verdicts of the senders which were verified recently, the refusal if they were refused
+	verdicts *helpers.VerdictCache
+
+	// verify can be replaced for testing
+	verify func(helo, address string) error
+}
+
+// Check verifies the sender of the transaction, it returns the reply of the MX hosts
+// if they refuse the sender, "" if it's accepted or couldn't be verified
+func (callout *Callout) Check(state *smtp.State) string {
+	c := &callout.config.Callout
+	if !c.Enabled || state.From == nil || state.From.Address == "" {
+		return ""
+	}
+	if callout.config.Access.Trusted(state.Ip) || callout.config.MayRelay(state) || callout.config.LocalDomains.IsLocal(state.From.Address) {
+		return ""
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	sender := strings.ToLower(state.From.Address)
+	verdict, cached := callout.verdicts.Get(sender)
+	if !cached {
+		domain := strings.ToLower(state.From.GetDomain())
+		c.Domains.Add(domain, 1)
+		c.Total.Add("", 1)
+		if c.Domains.Exceeded(domain) || c.Total.Exceeded("") {
+			logger.Debugf("Callout: rate limit reached, sender %s not verified", sender)
+			return ""
+		}
+
+		err := callout.verify(callout.config.Hostname, state.From.Address)
+		protoErr, refused := err.(*textproto.Error)
+		switch {
+		case err == nil:
+			verdict = verified
+		case refused && protoErr.Code >= 500:
+			verdict = protoErr.Error()
+		default:
+			logger.Debugf("Callout: couldn't verify sender %s: %v", sender, err)
+			return ""
+		}
+		callout.verdicts.Set(sender, verdict)
+	}
+
+	if verdict == verified {
+		return ""
+	}
+	logger.Warnf("Callout: refused undeliverable sender %s: %s", sender, verdict)
+	return verdict
+}
@@ -0,0 +1,76 @@
+package callout
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCallout(t *testing.T) {
+
+	Convey("Testing Callout", t, func() {
+		c := &config.Config{
+			LocalDomains: helpers.LocalDomains{"example.com": {}},
+			Callout: config.Callout{
+				Enabled:  true,
+				CacheTTL: 60,
+				Domains:  helpers.RateLimiter{Limit: 2, Window: 60},
+				Total:    helpers.RateLimiter{Limit: 4, Window: 60},
+			},
+		}
+		h := New(c)
+		callouts := []string{}
+		h.verify = func(helo, address string) error {
+			callouts = append(callouts, address)
+			switch address {
+			case "nobody@example.org":
+				return &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}
+			case "busy@example.org":
+				return &textproto.Error{Code: 451, Msg: "4.3.0 Try again later"}
+			case "down@example.net":
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+
+		check := func(from string) string {
+			return h.Check(&smtp.State{
+				From: &smtp.MailAddress{Address: from},
+				Ip:   net.ParseIP("192.0.2.1"),
+			})
+		}
+
+		So(check("alice@example.org"), ShouldEqual, "")
+		So(check("nobody@example.org"), ShouldEqual, `550 "5.1.1 User unknown"`)
+
+		// verdicts are cached
+		So(check("Nobody@example.org"), ShouldEqual, `550 "5.1.1 User unknown"`)
+		So(check("alice@example.org"), ShouldEqual, "")
+		So(callouts, ShouldResemble, []string{"alice@example.org", "nobody@example.org"})
+
+		// senders which can't be verified are accepted and not cached
+		So(check("down@example.net"), ShouldEqual, "")
+		So(check("down@example.net"), ShouldEqual, "")
+		So(len(callouts), ShouldEqual, 4)
+
+		// rate limits: example.org had its 2 callouts, and 4 callouts were made in total
+		So(check("busy@example.org"), ShouldEqual, "")
+		So(check("other@example.info"), ShouldEqual, "")
+		So(len(callouts), ShouldEqual, 4)
+
+		// null senders, local senders and disabled callouts
+		So(check(""), ShouldEqual, "")
+		So(check("bob@example.com"), ShouldEqual, "")
+		c.Callout.Enabled = false
+		So(check("nobody@example.org"), ShouldEqual, "")
+		So(len(callouts), ShouldEqual, 4)
+	})
+
+}
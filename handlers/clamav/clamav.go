@@ -0,0 +1,201 @@
+package clamav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+	"github.com/sloonz/go-maildir"
+)
+
+// chunkSize is the size of the chunks sent to clamd, it must be smaller than clamd's StreamMaxLength
+const chunkSize = 64 * 1024
+
+// virusFound is the reply code of DATA for infected messages which are rejected
+const virusFound = 554
+
+func New(c *config.Config) *ClamAV {
+	return &ClamAV{
+		config:   c,
+		verdicts: &helpers.VerdictCache{TTL: c.ClamAV.CacheTTL},
+	}
+}
+
+// ClamAV scans messages for viruses with clamd.
+// Infected messages are rejected in the reply to DATA, quarantined (stored in a separate maildir
+// and not delivered) or tagged with a header.
+type ClamAV struct {
+	config     *config.Config
+	quarantine *maildir.Maildir
+
+	// verdicts of bodies which were scanned recently (bulk mail is only scanned once)
+	verdicts *helpers.VerdictCache
+}
+
+func (handler *ClamAV) Handle(state *smtp.State) {
+	c := handler.config.ClamAV
+	if c.Address == "" {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	if user, found := handler.config.Logins.Get(state.SessionId.String()); found && bypass(&c, user) {
+		logger.Debug("ClamAV: skipping scan for trusted user " + user)
+		return
+	}
+
+	hash := helpers.BodyHash(state.Data)
+	virus, cached := handler.verdicts.Get(hash)
+	if cached {
+		logger.Debug("ClamAV: using cached verdict for body " + hash)
+	} else {
+		var err error
+		virus, err = scan(&c, state.Data)
+		if err != nil {
+			// Don't lose mail because the scanner is down
+			logger.Errorf("ClamAV: couldn't scan message: %v", err)
+			return
+		}
+		handler.verdicts.Set(hash, virus)
+	}
+
+	if virus == "" {
+		headerField := fmt.Sprintf("X-Virus-Scanned: ClamAV on %s\r\n", handler.config.Hostname)
+		state.Data = append([]byte(headerField), state.Data...)
+		return
+	}
+
+	logger.Warnf("ClamAV: message infected with %s", virus)
+
+	if c.Action == "tag" {
+		headerField := fmt.Sprintf("X-Virus-Found: %s\r\n", virus)
+		state.Data = append([]byte(headerField), state.Data...)
+		handler.config.SpamScores.Add(state.SessionId.String(), "VIRUS", handler.config.Spam.VirusScore)
+		return
+	}
+
+	if c.Action == "" || c.Action == "reject" {
+		// the client gets the refusal instead of the 250, and bounces the message itself
+		handler.config.Acceptance.Fail(state.SessionId.String(), &helpers.EsmtpError{
+			Code:    virusFound,
+			Message: "5.7.1 Message refused, it contains a virus (" + virus + ")",
+		})
+		state.To = nil
+		return
+	}
+
+	// Quarantine the message
+	if handler.quarantine == nil {
+		path := c.Quarantine
+		if path == "" {
+			path = "./quarantine"
+		}
+		var err error
+		handler.quarantine, err = maildir.New(path, true)
+		if err != nil {
+			logger.Errorf("ClamAV: could not open quarantine maildir: %v", err)
+			handler.quarantine = nil
+		}
+	}
+	if handler.quarantine != nil {
+		data, err := handler.config.Encryption.Seal(state.Data)
+		filename := ""
+		if err == nil {
+			filename, err = handler.quarantine.CreateMail(bytes.NewReader(data))
+		}
+		if err != nil {
+			logger.Errorf("ClamAV: could not quarantine message: %v", err)
+		} else {
+			logger.Info("ClamAV: message quarantined in file: " + filename)
+		}
+	}
+
+	// Drop the message
+	state.To = nil
+}
+
+// bypass reports whether the messages of the authenticated user aren't scanned
+func bypass(c *config.ClamAV, user string) bool {
+	for _, u := range c.BypassUsers {
+		if strings.EqualFold(u, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// scan streams the data to clamd using the INSTREAM command,
+// it returns the name of the virus or an empty string if the data is clean.
+func scan(c *config.ClamAV, data []byte) (string, error) {
+	network := c.Network
+	if network == "" {
+		network = "tcp"
+	}
+	timeout := time.Duration(c.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	conn, err := net.DialTimeout(network, c.Address, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// zINSTREAM\0 followed by chunks, each prefixed with their length (4 bytes, network byte order).
+	// A chunk of length 0 ends the stream.
+	_, err = conn.Write([]byte("zINSTREAM\x00"))
+	if err != nil {
+		return "", err
+	}
+	size := make([]byte, 4)
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		data = data[len(chunk):]
+
+		binary.BigEndian.PutUint32(size, uint32(len(chunk)))
+		if _, err = conn.Write(size); err != nil {
+			return "", err
+		}
+		if _, err = conn.Write(chunk); err != nil {
+			return "", err
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err = conn.Write(size); err != nil {
+		return "", err
+	}
+
+	// Reply is 'stream: OK', 'stream: <virus> FOUND' or '<error> ERROR'
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	result := strings.TrimSpace(strings.TrimRight(string(reply), "\x00"))
+
+	switch {
+	case strings.HasSuffix(result, " OK"):
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		result = strings.TrimPrefix(result, "stream: ")
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", errors.New("clamd: " + result)
+}
@@ -0,0 +1,171 @@
+package clamav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeClamd accepts INSTREAM connections and reports the EICAR test string as a virus
+func fakeClamd(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			command := make([]byte, len("zINSTREAM\x00"))
+			if _, err := io.ReadFull(conn, command); err != nil {
+				return
+			}
+			data := []byte{}
+			size := make([]byte, 4)
+			for {
+				if _, err := io.ReadFull(conn, size); err != nil {
+					return
+				}
+				length := binary.BigEndian.Uint32(size)
+				if length == 0 {
+					break
+				}
+				chunk := make([]byte, length)
+				if _, err := io.ReadFull(conn, chunk); err != nil {
+					return
+				}
+				data = append(data, chunk...)
+			}
+			if bytes.Contains(data, []byte("EICAR")) {
+				conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+		}(conn)
+	}
+}
+
+func TestClamAVHandler(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go fakeClamd(listener)
+
+	quarantine, err := ioutil.TempDir("", "quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(quarantine)
+
+	c := config.Config{}
+	c.Hostname = "mx.example.com"
+	c.ClamAV = config.ClamAV{
+		Address:    listener.Addr().String(),
+		Quarantine: quarantine,
+	}
+
+	newState := func(data string) *smtp.State {
+		return &smtp.State{
+			From: &smtp.MailAddress{Address: "from@test.com"},
+			To:   []*smtp.MailAddress{&smtp.MailAddress{Address: "to@test.com"}},
+			Data: []byte(data),
+			Ip:   net.ParseIP("192.168.0.10"),
+		}
+	}
+
+	Convey("Testing scan()", t, func() {
+		virus, err := scan(&c.ClamAV, []byte(strings.Repeat("Hello world!\r\n", 10000)))
+		So(err, ShouldEqual, nil)
+		So(virus, ShouldEqual, "")
+
+		virus, err = scan(&c.ClamAV, []byte("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"))
+		So(err, ShouldEqual, nil)
+		So(virus, ShouldEqual, "Eicar-Test-Signature")
+	})
+
+	Convey("Testing ClamAV handler", t, func() {
+		h := New(&c)
+
+		state := newState("Hello world!")
+		h.Handle(state)
+		So(string(state.Data), ShouldStartWith, "X-Virus-Scanned: ClamAV on mx.example.com\r\n")
+		So(len(state.To), ShouldEqual, 1)
+
+		// infected messages are rejected in the reply to DATA
+		state = newState("EICAR")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 0)
+		err := c.Acceptance.Take(state.SessionId.String())
+		So(err, ShouldResemble, &helpers.EsmtpError{Code: 554, Message: "5.7.1 Message refused, it contains a virus (Eicar-Test-Signature)"})
+
+		// or quarantined
+		c.ClamAV.Action = "quarantine"
+		state = newState("EICAR")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 0)
+		So(c.Acceptance.Take(state.SessionId.String()), ShouldEqual, nil)
+		files, _ := ioutil.ReadDir(filepath.Join(quarantine, "new"))
+		So(len(files), ShouldEqual, 1)
+
+		// or tagged
+		c.ClamAV.Action = "tag"
+		state = newState("EICAR")
+		h.Handle(state)
+		So(string(state.Data), ShouldStartWith, "X-Virus-Found: Eicar-Test-Signature\r\n")
+		So(len(state.To), ShouldEqual, 1)
+		c.ClamAV.Action = ""
+
+		// trusted users aren't scanned
+		c.ClamAV.BypassUsers = []string{"bob@example.com"}
+		state = newState("EICAR")
+		c.Logins.Set(state.SessionId.String(), "Bob@example.com")
+		h.Handle(state)
+		So(string(state.Data), ShouldEqual, "EICAR")
+		So(len(state.To), ShouldEqual, 1)
+
+		// other users are
+		c.Logins.Set(state.SessionId.String(), "alice@example.com")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 0)
+		c.Acceptance.Take(state.SessionId.String())
+	})
+
+	Convey("Testing ClamAV verdict cache", t, func() {
+		c.ClamAV.BypassUsers = nil
+		c.ClamAV.CacheTTL = 60
+		h := New(&c)
+
+		state := newState("To: a@example.com\r\n\r\nEICAR")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 0)
+
+		// copies of the message use the cached verdict, even if clamd is unreachable
+		address := c.ClamAV.Address
+		c.ClamAV.Address = "127.0.0.1:1"
+		state = newState("To: b@example.com\r\n\r\nEICAR")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 0)
+
+		// other bodies are scanned
+		state = newState("To: b@example.com\r\n\r\nHello world!")
+		h.Handle(state)
+		So(string(state.Data), ShouldStartWith, "To: b@example.com")
+		So(len(state.To), ShouldEqual, 1)
+		c.ClamAV.Address = address
+	})
+
+}
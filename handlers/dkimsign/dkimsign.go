@@ -0,0 +1,86 @@
+package dkimsign
+
+import (
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/dkim"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *Sign {
+	return &Sign{
+		config: c,
+	}
+}
+
+// Sign adds DKIM signatures to outbound messages (messages from clients which may relay)
+// of which the domain of the From header field has DKIM keys. With Dkim.DualSign a message
+// gets an RSA and an ed25519 signature. It runs after the handlers which change the message.
+type Sign struct {
+	config *config.Config
+}
+
+func (handler *Sign) Handle(state *smtp.State) {
+	store := &handler.config.Dkim
+	if store.Dir == "" || len(state.To) == 0 || !handler.config.MayRelay(state) {
+		return
+	}
+	domain := fromDomain(state.Data)
+	if !signs(store, domain) {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+	keys, err := store.Signing(domain)
+	if err != nil {
+		logger.Errorf("DKIM: no key to sign for %s: %v", domain, err)
+		return
+	}
+
+	signatures := ""
+	now := time.Now()
+	for _, key := range keys {
+		signature, err := key.Sign(state.Data, now)
+		if err != nil {
+			logger.Errorf("DKIM: couldn't sign with %s: %v", key.Name(), err)
+			return
+		}
+		signatures += signature
+	}
+	state.Data = append([]byte(signatures), state.Data...)
+	logger.Debugf("DKIM: signed for %s with %d keys", domain, len(keys))
+}
+
+// fromDomain returns the domain of the address in the From header field
+func fromDomain(data []byte) string {
+	fields, _ := helpers.SplitHeader(data)
+	for _, field := range fields {
+		if !strings.EqualFold(helpers.FieldName(field), "From") {
+			continue
+		}
+		address, err := mail.ParseAddress(helpers.FieldValue(field))
+		if err != nil {
+			return ""
+		}
+		return address.Address[strings.LastIndexByte(address.Address, '@')+1:]
+	}
+	return ""
+}
+
+// signs reports whether the store has keys for the domain
+func signs(store *dkim.KeyStore, domain string) bool {
+	for _, d := range store.Domains {
+		if domain != "" && strings.EqualFold(strings.TrimSuffix(d, "."), domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package dkimsign
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/dkim"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSign(t *testing.T) {
+
+	Convey("Testing Sign", t, func() {
+		dir, err := ioutil.TempDir("", "dkim")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{}
+		c.Dkim.Dir = dir
+		c.Dkim.Domains = []string{"example.com"}
+		c.Dkim.Bits = 1024
+		c.Dkim.DualSign = true
+		So(c.Dkim.Rotate("example.com"), ShouldEqual, nil)
+		So(json.Unmarshal([]byte(`{"Relay": ["192.168.0.0/24"]}`), &c.Access), ShouldEqual, nil)
+
+		records := map[string]string{}
+		keys, err := c.Dkim.Published("example.com")
+		So(err, ShouldEqual, nil)
+		for _, key := range keys {
+			records[key.Name()], _ = key.Record()
+		}
+		lookup := func(name string) ([]string, error) {
+			return []string{records[name]}, nil
+		}
+
+		data := "From: Bob <bob@example.com>\r\nTo: alice@example.org\r\nSubject: test\r\n\r\nHello\r\n"
+		state := &smtp.State{
+			Ip:   net.ParseIP("192.168.0.10"),
+			From: &smtp.MailAddress{Address: "bounces@example.com"},
+			To:   []*smtp.MailAddress{{Address: "alice@example.org"}},
+			Data: []byte(data),
+		}
+		New(c).Handle(state)
+		So(strings.Count(string(state.Data), "DKIM-Signature:"), ShouldEqual, 2)
+		So(string(state.Data), ShouldEndWith, data)
+
+		// an RSA and an ed25519 signature
+		results := dkim.Verify(state.Data, lookup)
+		So(len(results), ShouldEqual, 2)
+		So(results[0].Algorithm, ShouldEqual, dkim.SignatureRSA)
+		So(results[0].Status, ShouldEqual, dkim.StatusPass)
+		So(results[1].Algorithm, ShouldEqual, dkim.SignatureEd25519)
+		So(results[1].Status, ShouldEqual, dkim.StatusPass)
+
+		// inbound messages and other domains aren't signed
+		state.Ip, state.Data = net.ParseIP("10.0.0.1"), []byte(data)
+		New(c).Handle(state)
+		So(string(state.Data), ShouldEqual, data)
+
+		other := strings.Replace(data, "bob@example.com", "bob@example.net", 1)
+		state.Ip, state.Data = net.ParseIP("192.168.0.10"), []byte(other)
+		New(c).Handle(state)
+		So(string(state.Data), ShouldEqual, other)
+	})
+
+}
@@ -0,0 +1,69 @@
+package dkimverify
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/dkim"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *Verify {
+	return &Verify{
+		config: c,
+	}
+}
+
+// Verify checks the DKIM signatures of inbound messages and adds the results
+// to an Authentication-Results header field (RFC 8601). The score of the result
+// (pass if one of the signatures passes) is added to the spam score.
+type Verify struct {
+	config *config.Config
+
+	// lookupTXT is net.LookupTXT if it's nil, it can be replaced for testing
+	lookupTXT func(name string) ([]string, error)
+}
+
+func (handler *Verify) Handle(state *smtp.State) {
+	// trusted clients skip the spam checks, and outbound messages are signed instead
+	if handler.config.Access.Trusted(state.Ip) || handler.config.MayRelay(state) {
+		return
+	}
+
+	results := dkim.Verify(state.Data, handler.lookupTXT)
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	// header field is defined in RFC 8601 section 2.2
+	// Authentication-Results: receiver.example.org; dkim=pass header.d=example.com header.s=sel header.a=ed25519-sha256
+	methods := []string{}
+	overall := "none"
+	for _, result := range results {
+		method := fmt.Sprintf("dkim=%s", result.Status)
+		if result.Err != nil {
+			method += fmt.Sprintf(" reason=%q", result.Err.Error())
+		}
+		method += fmt.Sprintf(" header.d=%s header.s=%s header.a=%s", result.Domain, result.Selector, result.Algorithm)
+		methods = append(methods, method)
+		logger.Infof("DKIM returned %s for %s (%s)", result.Status, result.Domain, result.Algorithm)
+		handler.config.AuthResults.Add(state.SessionId.String(), helpers.AuthResult{Method: "dkim", Result: result.Status, Domain: result.Domain})
+
+		if overall == "none" || result.Status == dkim.StatusPass {
+			overall = result.Status
+		}
+	}
+	if len(methods) == 0 {
+		methods = append(methods, "dkim=none")
+	}
+	headerField := fmt.Sprintf("Authentication-Results: %s; %s\r\n", handler.config.Hostname, strings.Join(methods, "; "))
+	state.Data = append([]byte(headerField), state.Data...)
+
+	if score, found := handler.config.Spam.DkimScores[overall]; found {
+		handler.config.SpamScores.Add(state.SessionId.String(), "DKIM_"+strings.ToUpper(overall), score)
+	}
+}
@@ -0,0 +1,56 @@
+package dkimverify
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/dkim"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/mta"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerify(t *testing.T) {
+
+	Convey("Testing Verify", t, func() {
+		signer, err := dkim.GenerateKey(dkim.AlgorithmEd25519, 0)
+		So(err, ShouldEqual, nil)
+		key := &dkim.Key{Domain: "example.org", Selector: "sel", Signer: signer}
+		record, _ := key.Record()
+
+		c := &config.Config{Config: mta.Config{Hostname: "mx.example.com"}}
+		c.Spam.DkimScores = map[string]float64{"fail": 3}
+		handler := New(c)
+		handler.lookupTXT = func(name string) ([]string, error) {
+			return []string{record}, nil
+		}
+
+		data := "From: alice@example.org\r\nSubject: test\r\n\r\nHello\r\n"
+		field, err := key.Sign([]byte(data), time.Now())
+		So(err, ShouldEqual, nil)
+		state := &smtp.State{
+			Ip:   net.ParseIP("10.0.0.1"),
+			From: &smtp.MailAddress{Address: "alice@example.org"},
+			Data: []byte(field + data),
+		}
+		handler.Handle(state)
+		So(string(state.Data), ShouldStartWith, "Authentication-Results: mx.example.com; dkim=pass header.d=example.org header.s=sel header.a=ed25519-sha256\r\n")
+		So(c.SpamScores.Take(state.SessionId.String()), ShouldBeEmpty)
+		So(c.AuthResults.Take(state.SessionId.String()), ShouldResemble, []helpers.AuthResult{{Method: "dkim", Result: "pass", Domain: "example.org"}})
+
+		state.Data = []byte(field + strings.Replace(data, "Hello", "Bye", 1))
+		handler.Handle(state)
+		So(string(state.Data), ShouldStartWith, `Authentication-Results: mx.example.com; dkim=fail reason="body hash doesn't match" header.d=example.org`)
+		So(c.SpamScores.Take(state.SessionId.String()), ShouldResemble, []helpers.SpamTest{{Name: "DKIM_FAIL", Score: 3}})
+
+		state.Data = []byte(data)
+		handler.Handle(state)
+		So(string(state.Data), ShouldStartWith, "Authentication-Results: mx.example.com; dkim=none\r\n")
+	})
+
+}
@@ -0,0 +1,198 @@
+package dmarc
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *Dmarc {
+	return &Dmarc{
+		config: c,
+	}
+}
+
+// Dmarc checks whether the SPF or DKIM results of inbound messages are aligned with the domain
+// of their From header field (RFC 7489), and adds the result to an Authentication-Results header field.
+// Relaxed alignment compares the organizational domains of the PublicSuffixList.
+// The score of the result (or of the policy of a failing domain) is added to the spam score.
+type Dmarc struct {
+	config *config.Config
+
+	// lookupTXT is net.LookupTXT if it's nil, it can be replaced for testing
+	lookupTXT func(name string) ([]string, error)
+}
+
+// policy is a DMARC record (RFC 7489 section 6.3)
+type policy struct {
+	// Policy is p=, Subdomain is sp= (the policy of the subdomains which have no record)
+	Policy    string
+	Subdomain string
+	// Adkim and Aspf are the alignment modes, r (relaxed) or s (strict)
+	Adkim string
+	Aspf  string
+}
+
+func (handler *Dmarc) Handle(state *smtp.State) {
+	results := handler.config.AuthResults.Take(state.SessionId.String())
+	// trusted clients skip the spam checks, and outbound messages are signed instead
+	if handler.config.Access.Trusted(state.Ip) || handler.config.MayRelay(state) {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	from := fromDomain(state.Data)
+	if from == "" {
+		// without a single From address there's no domain to check
+		return
+	}
+
+	result, disposition := "none", ""
+	p, err := handler.lookup(from)
+	switch {
+	case err != nil:
+		logger.Warnf("DMARC: couldn't look up the policy of %s: %v", from, err)
+		result = "temperror"
+	case p != nil:
+		result = "fail"
+		for _, r := range results {
+			if r.Result != "pass" {
+				continue
+			}
+			if r.Method == "spf" && handler.aligned(r.Domain, from, p.Aspf) || r.Method == "dkim" && handler.aligned(r.Domain, from, p.Adkim) {
+				result = "pass"
+			}
+		}
+		if result == "fail" {
+			disposition = p.Policy
+		}
+	}
+	logger.Infof("DMARC returned %s for %s", result, from)
+
+	// header field is defined in RFC 7489 section 11.2
+	// Authentication-Results: receiver.example.org; dmarc=fail (p=reject) header.from=example.com
+	method := "dmarc=" + result
+	if disposition != "" {
+		method += " (p=" + disposition + ")"
+	}
+	headerField := fmt.Sprintf("Authentication-Results: %s; %s header.from=%s\r\n", handler.config.Hostname, method, from)
+	state.Data = append([]byte(headerField), state.Data...)
+
+	if score, found := handler.config.Spam.DmarcScores[disposition]; found && disposition != "none" {
+		handler.config.SpamScores.Add(state.SessionId.String(), "DMARC_"+strings.ToUpper(disposition), score)
+	} else if score, found := handler.config.Spam.DmarcScores[result]; found {
+		handler.config.SpamScores.Add(state.SessionId.String(), "DMARC_"+strings.ToUpper(result), score)
+	}
+}
+
+// aligned reports whether the authenticated domain is aligned with the From domain,
+// in strict mode the domains have to be the same, in relaxed mode their organizational domains
+func (handler *Dmarc) aligned(domain, from, mode string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if mode == "s" {
+		return domain == from
+	}
+	psl := &handler.config.PublicSuffixList
+	return psl.OrganizationalDomain(domain) == psl.OrganizationalDomain(from)
+}
+
+// lookup returns the policy of the domain, or of its organizational domain if the domain has none
+// (RFC 7489 section 6.6.3). It returns nil if neither has a policy.
+func (handler *Dmarc) lookup(domain string) (*policy, error) {
+	p, err := handler.record(domain)
+	if p != nil || err != nil {
+		return p, err
+	}
+	organizational := handler.config.PublicSuffixList.OrganizationalDomain(domain)
+	if organizational == domain {
+		return nil, nil
+	}
+	p, err = handler.record(organizational)
+	if p != nil && p.Subdomain != "" {
+		p.Policy = p.Subdomain
+	}
+	return p, err
+}
+
+// record returns the DMARC record of the domain, or nil if it has none
+func (handler *Dmarc) record(domain string) (*policy, error) {
+	lookupTXT := handler.lookupTXT
+	if lookupTXT == nil {
+		lookupTXT = net.LookupTXT
+	}
+	txts, err := lookupTXT("_dmarc." + domain)
+	if helpers.IsDnsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records := []map[string]string{}
+	for _, txt := range txts {
+		if tags := parseTags(txt); tags["v"] == "DMARC1" {
+			records = append(records, tags)
+		}
+	}
+	// a domain with several records has none
+	if len(records) != 1 {
+		return nil, nil
+	}
+	tags := records[0]
+	p := &policy{
+		Policy:    strings.ToLower(tags["p"]),
+		Subdomain: strings.ToLower(tags["sp"]),
+		Adkim:     strings.ToLower(tags["adkim"]),
+		Aspf:      strings.ToLower(tags["aspf"]),
+	}
+	if p.Policy == "" {
+		p.Policy = "none"
+	}
+	return p, nil
+}
+
+// parseTags parses a tag-value list (RFC 7489 section 6.4), e.g. v=DMARC1; p=reject
+func parseTags(txt string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(txt, ";") {
+		if i := strings.IndexByte(tag, '='); i > 0 {
+			tags[strings.TrimSpace(tag[:i])] = strings.TrimSpace(tag[i+1:])
+		}
+	}
+	return tags
+}
+
+// fromDomain returns the lower case domain of the single address of the From header field,
+// or an empty string if there isn't exactly one From address
+func fromDomain(data []byte) string {
+	fields, _ := helpers.SplitHeader(data)
+	domain := ""
+	for _, field := range fields {
+		if !strings.EqualFold(helpers.FieldName(field), "From") {
+			continue
+		}
+		if domain != "" {
+			return ""
+		}
+		addresses, err := mail.ParseAddressList(helpers.FieldValue(field))
+		if err != nil || len(addresses) != 1 {
+			return ""
+		}
+		i := strings.LastIndexByte(addresses[0].Address, '@')
+		if i < 0 {
+			return ""
+		}
+		domain = strings.TrimSuffix(strings.ToLower(addresses[0].Address[i+1:]), ".")
+	}
+	return domain
+}
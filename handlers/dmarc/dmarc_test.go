@@ -0,0 +1,96 @@
+package dmarc
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/mta"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDmarc(t *testing.T) {
+
+	Convey("Testing Dmarc", t, func() {
+		c := &config.Config{Config: mta.Config{Hostname: "mx.example.com"}}
+		c.Spam.DmarcScores = map[string]float64{"fail": 2, "reject": 6}
+		handler := New(c)
+		records := map[string][]string{
+			"_dmarc.example.co.uk": {"v=DMARC1; p=reject; sp=quarantine"},
+			"_dmarc.example.org":   {"v=DMARC1; p=reject; adkim=s"},
+			"_dmarc.example.net":   {"v=DMARC1; p=none"},
+		}
+		handler.lookupTXT = func(name string) ([]string, error) {
+			if name == "_dmarc.broken.example" {
+				return nil, errors.New("server misbehaving")
+			}
+			if txts, found := records[name]; found {
+				return txts, nil
+			}
+			return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+		}
+
+		check := func(from string, results ...helpers.AuthResult) string {
+			state := &smtp.State{
+				Ip:   net.ParseIP("10.0.0.1"),
+				Data: []byte("From: Alice <alice@" + from + ">\r\nSubject: test\r\n\r\nHello\r\n"),
+			}
+			for _, result := range results {
+				c.AuthResults.Add(state.SessionId.String(), result)
+			}
+			handler.Handle(state)
+			fields, _ := helpers.SplitHeader(state.Data)
+			return fields[0]
+		}
+
+		// relaxed alignment uses the organizational domains of the public suffix list
+		So(check("mail.example.co.uk", helpers.AuthResult{Method: "spf", Result: "pass", Domain: "bounces.example.co.uk"}),
+			ShouldEqual, "Authentication-Results: mx.example.com; dmarc=pass header.from=mail.example.co.uk\r\n")
+		So(check("mail.example.co.uk", helpers.AuthResult{Method: "dkim", Result: "pass", Domain: "other.co.uk"}),
+			ShouldEqual, "Authentication-Results: mx.example.com; dmarc=fail (p=quarantine) header.from=mail.example.co.uk\r\n")
+		So(check("mail.example.co.uk", helpers.AuthResult{Method: "spf", Result: "fail", Domain: "example.co.uk"}),
+			ShouldEqual, "Authentication-Results: mx.example.com; dmarc=fail (p=quarantine) header.from=mail.example.co.uk\r\n")
+
+		// strict alignment
+		So(check("example.org", helpers.AuthResult{Method: "dkim", Result: "pass", Domain: "example.org"}),
+			ShouldEqual, "Authentication-Results: mx.example.com; dmarc=pass header.from=example.org\r\n")
+		So(check("example.org", helpers.AuthResult{Method: "dkim", Result: "pass", Domain: "mail.example.org"}),
+			ShouldEqual, "Authentication-Results: mx.example.com; dmarc=fail (p=reject) header.from=example.org\r\n")
+
+		So(check("example.net"), ShouldEqual, "Authentication-Results: mx.example.com; dmarc=fail (p=none) header.from=example.net\r\n")
+		So(check("example.com"), ShouldEqual, "Authentication-Results: mx.example.com; dmarc=none header.from=example.com\r\n")
+		So(check("broken.example"), ShouldEqual, "Authentication-Results: mx.example.com; dmarc=temperror header.from=broken.example\r\n")
+	})
+
+	Convey("Testing the scores", t, func() {
+		c := &config.Config{Config: mta.Config{Hostname: "mx.example.com"}}
+		c.Spam.DmarcScores = map[string]float64{"fail": 2, "reject": 6}
+		handler := New(c)
+		handler.lookupTXT = func(name string) ([]string, error) {
+			if name == "_dmarc.example.org" {
+				return []string{"v=DMARC1; p=reject"}, nil
+			}
+			return []string{"v=DMARC1; p=none"}, nil
+		}
+
+		state := &smtp.State{Ip: net.ParseIP("10.0.0.1"), Data: []byte("From: alice@example.org\r\n\r\nHello\r\n")}
+		handler.Handle(state)
+		So(c.SpamScores.Take(state.SessionId.String()), ShouldResemble, []helpers.SpamTest{{Name: "DMARC_REJECT", Score: 6}})
+
+		state.Data = []byte("From: alice@example.net\r\n\r\nHello\r\n")
+		handler.Handle(state)
+		So(c.SpamScores.Take(state.SessionId.String()), ShouldResemble, []helpers.SpamTest{{Name: "DMARC_FAIL", Score: 2}})
+	})
+
+	Convey("Testing fromDomain()", t, func() {
+		So(fromDomain([]byte("From: Alice <Alice@Example.ORG>\r\n\r\n")), ShouldEqual, "example.org")
+		So(fromDomain([]byte("From: alice@example.org, bob@example.net\r\n\r\n")), ShouldEqual, "")
+		So(fromDomain([]byte("From: alice@example.org\r\nFrom: bob@example.net\r\n\r\n")), ShouldEqual, "")
+		So(fromDomain([]byte("Subject: test\r\n\r\n")), ShouldEqual, "")
+	})
+
+}
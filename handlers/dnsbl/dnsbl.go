@@ -0,0 +1,56 @@
+package dnsbl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *Dnsbl {
+	return &Dnsbl{
+		config: c,
+	}
+}
+
+// Dnsbl adds a header for every (scoring) DNS blocklist in which the client IP or HELO domain is listed.
+// Rejecting lists are already checked by the MTA when the client connects (the HELO domain at MAIL),
+// greylisting lists at RCPT.
+type Dnsbl struct {
+	config *config.Config
+}
+
+func (handler *Dnsbl) Handle(state *smtp.State) {
+	handler.HandleContext(context.Background(), state)
+}
+
+// HandleContext stops the lookups when the context is canceled
+func (handler *Dnsbl) HandleContext(ctx context.Context, state *smtp.State) {
+	// trusted clients skip the spam checks
+	if handler.config.Access.Trusted(state.Ip) {
+		return
+	}
+
+	listed := []string{}
+	for _, list := range handler.config.Dnsbl.Listed(ctx, state.Ip.String(), "", helpers.DnsblScore) {
+		listed = append(listed, fmt.Sprintf("X-DNSBL: %s listed in %s; score=%.1f\r\n", state.Ip, list.Zone, list.Score))
+		handler.config.SpamScores.Add(state.SessionId.String(), "DNSBL_"+list.Zone, list.Score)
+	}
+	for _, list := range handler.config.Dnsbl.Listed(ctx, "", state.Hostname, helpers.DnsblScore) {
+		listed = append(listed, fmt.Sprintf("X-DNSBL: %s listed in %s; score=%.1f\r\n", state.Hostname, list.Zone, list.Score))
+		handler.config.SpamScores.Add(state.SessionId.String(), "DNSBL_"+list.Zone, list.Score)
+	}
+
+	for _, headerField := range listed {
+		state.Data = append([]byte(headerField), state.Data...)
+
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+			"Hostname":  state.Hostname,
+		}).Info("Client listed in DNSBL: '", headerField, "'")
+	}
+}
@@ -0,0 +1,63 @@
+package dnsbl
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDnsbl(t *testing.T) {
+
+	Convey("Testing Dnsbl handler", t, func() {
+		c := &config.Config{
+			Dnsbl: helpers.Dnsbl{
+				Lists: []helpers.DnsblList{
+					{Zone: "reject.example.com"},
+					{Zone: "grey.example.com", Action: helpers.DnsblGreylist},
+					{Zone: "score.example.com", Action: helpers.DnsblScore, Score: 1.5},
+					{Zone: "dbl.example.com", Action: helpers.DnsblScore, Score: 2.5, Domain: true},
+				},
+				Lookup: func(ctx context.Context, host string) ([]string, error) {
+					if strings.HasPrefix(host, "2.0.0.127.") || strings.HasPrefix(host, "spam.example.org.") {
+						return []string{"127.0.0.2"}, nil
+					}
+					return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+				},
+			},
+		}
+		So(json.Unmarshal([]byte(`{"Trusted": ["192.168.0.0/24"]}`), &c.Access), ShouldEqual, nil)
+		h := New(c)
+
+		// only the scoring lists add a header field and a score
+		state := &smtp.State{Ip: net.ParseIP("127.0.0.2"), Hostname: "spam.example.org", Data: []byte("Subject: test\r\n\r\nHello\r\n")}
+		h.Handle(state)
+		data := string(state.Data)
+		So(data, ShouldContainSubstring, "X-DNSBL: 127.0.0.2 listed in score.example.com; score=1.5\r\n")
+		So(data, ShouldContainSubstring, "X-DNSBL: spam.example.org listed in dbl.example.com; score=2.5\r\n")
+		So(data, ShouldNotContainSubstring, "reject.example.com")
+		So(data, ShouldNotContainSubstring, "grey.example.com")
+		So(c.SpamScores.Take(state.SessionId.String()), ShouldResemble, []helpers.SpamTest{
+			{Name: "DNSBL_score.example.com", Score: 1.5},
+			{Name: "DNSBL_dbl.example.com", Score: 2.5},
+		})
+
+		// clients which aren't listed
+		state = &smtp.State{Ip: net.ParseIP("127.0.0.3"), Hostname: "ham.example.org", Data: []byte("Subject: test\r\n\r\nHello\r\n")}
+		h.Handle(state)
+		So(string(state.Data), ShouldEqual, "Subject: test\r\n\r\nHello\r\n")
+
+		// trusted clients skip the checks
+		state = &smtp.State{Ip: net.ParseIP("192.168.0.10"), Hostname: "spam.example.org", Data: []byte("Subject: test\r\n\r\nHello\r\n")}
+		h.Handle(state)
+		So(string(state.Data), ShouldEqual, "Subject: test\r\n\r\nHello\r\n")
+	})
+
+}
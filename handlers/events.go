@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gopistolet/gopistolet/events"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+/**
+ * Events publishes a MessageReceived event when a message comes in,
+ * and a MessageHandled event when Handler is done with it.
+ */
+type Events struct {
+	Handler Handler
+	Bus     *events.Bus
+}
+
+func (e *Events) Handle(state *smtp.State) {
+	e.HandleContext(context.Background(), state)
+}
+
+func (e *Events) HandleContext(ctx context.Context, state *smtp.State) {
+	received := events.MessageReceived{
+		SessionId: state.SessionId.String(),
+		Ip:        state.Ip.String(),
+		Helo:      state.Hostname,
+		To:        addresses(state.To),
+		Size:      len(state.Data),
+	}
+	if state.From != nil {
+		received.From = state.From.Address
+	}
+	e.Bus.Publish(received)
+
+	e.Bus.Publish(track(ctx, e.Handler, state))
+}
+
+// track calls the handler and returns what it did with the message
+func track(ctx context.Context, handler Handler, state *smtp.State) events.MessageHandled {
+	handled := events.MessageHandled{
+		Received:  time.Now(),
+		SessionId: state.SessionId.String(),
+		Ip:        state.Ip.String(),
+		Helo:      state.Hostname,
+		To:        addresses(state.To),
+		Size:      len(state.Data),
+	}
+	if state.From != nil {
+		handled.From = state.From.Address
+	}
+
+	call(ctx, handler, state)
+
+	handled.Completed = time.Now()
+	handled.Delivered = addresses(state.To)
+	return handled
+}
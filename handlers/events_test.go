@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/events"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEvents(t *testing.T) {
+
+	Convey("Testing Events handler", t, func() {
+		bus := &events.Bus{}
+		published := []events.Event{}
+		bus.Subscribe(func(event events.Event) {
+			published = append(published, event)
+		})
+
+		state := &smtp.State{
+			From:     &smtp.MailAddress{Address: "from@test.com"},
+			To:       []*smtp.MailAddress{{Address: "to@test.com"}},
+			Data:     []byte("Subject: test\r\n\r\nHello\r\n"),
+			Ip:       net.ParseIP("192.0.2.1"),
+			Hostname: "client.test.com",
+		}
+		handler := &Events{Handler: &DropHandler{}, Bus: bus}
+		handler.Handle(state)
+
+		So(len(published), ShouldEqual, 2)
+		received := published[0].(events.MessageReceived)
+		So(received.Ip, ShouldEqual, "192.0.2.1")
+		So(received.Helo, ShouldEqual, "client.test.com")
+		So(received.From, ShouldEqual, "from@test.com")
+		So(received.To, ShouldResemble, []string{"to@test.com"})
+		handled := published[1].(events.MessageHandled)
+		So(handled.To, ShouldResemble, []string{"to@test.com"})
+		So(handled.Delivered, ShouldResemble, []string{})
+		So(handled.Completed.Before(handled.Received), ShouldBeFalse)
+	})
+
+}
@@ -0,0 +1,281 @@
+package footer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"html"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *Footer {
+	return &Footer{
+		config: c,
+	}
+}
+
+// Footer adds the footer of the sender's domain to outbound messages (messages from clients which may relay).
+// The footer goes into the text of the message: the text/plain and text/html parts of multipart/alternative,
+// or the first part of multipart/mixed and multipart/related. The parts are decoded and encoded again,
+// so quoted-printable and base64 text get a readable footer. Signed and encrypted messages are left alone.
+type Footer struct {
+	config *config.Config
+}
+
+func (handler *Footer) Handle(state *smtp.State) {
+	if len(handler.config.Footers) == 0 || state.From == nil || !handler.config.MayRelay(state) {
+		return
+	}
+
+	domain := state.From.GetDomain()
+	for name, footer := range handler.config.Footers {
+		if !strings.EqualFold(name, domain) {
+			continue
+		}
+
+		logger := log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+		})
+		data, added := AddFooter(state.Data, footer)
+		if !added {
+			logger.Debugf("Footer: no text part for the footer of %s", name)
+			return
+		}
+		state.Data = data
+		logger.Debugf("Footer: added footer of %s", name)
+		return
+	}
+}
+
+// AddFooter adds the footer to the text of the message (or MIME entity),
+// it reports whether there was a text part for the footer
+func AddFooter(entity []byte, footer config.Footer) ([]byte, bool) {
+	fields, body := helpers.SplitHeader(entity)
+	contentType, encoding := "text/plain", ""
+	for _, field := range fields {
+		switch strings.ToLower(helpers.FieldName(field)) {
+		case "content-type":
+			contentType = helpers.FieldValue(field)
+		case "content-transfer-encoding":
+			encoding = strings.ToLower(helpers.FieldValue(field))
+		}
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return entity, false
+	}
+
+	// the body starts with the empty line which ends the header
+	separator := []byte("\r\n")
+	if i := bytes.IndexByte(body, '\n'); i >= 0 {
+		separator, body = body[:i+1], body[i+1:]
+	}
+
+	switch mediaType {
+	case "multipart/alternative", "multipart/mixed", "multipart/related":
+		if params["boundary"] == "" {
+			return entity, false
+		}
+		body, added := addToParts(body, params["boundary"], mediaType == "multipart/alternative", footer)
+		if !added {
+			return entity, false
+		}
+		return join(fields, separator, body), true
+
+	case "text/plain", "text/html":
+		text := footer.Text
+		if mediaType == "text/html" {
+			text = footer.Html
+			if text == "" && footer.Text != "" {
+				text = "<p>" + strings.Replace(html.EscapeString(footer.Text), "\n", "<br>\n", -1) + "</p>"
+			}
+		}
+		if text == "" {
+			return entity, false
+		}
+		text = strings.Replace(strings.Replace(text, "\r\n", "\n", -1), "\n", "\r\n", -1)
+
+		// the footer can't be converted to other charsets
+		charset := strings.ToLower(params["charset"])
+		if !isAscii(text) && charset != "utf-8" {
+			return entity, false
+		}
+
+		content, err := decode(body, encoding)
+		if err != nil {
+			return entity, false
+		}
+		if mediaType == "text/html" {
+			content = addHtml(content, text)
+		} else {
+			content = addText(content, text)
+		}
+
+		// 7bit and unencoded bodies get non-ASCII footers as quoted-printable
+		if !isAscii(text) && (encoding == "" || encoding == "7bit") {
+			encoding = "quoted-printable"
+			fields = setField(fields, "Content-Transfer-Encoding", encoding)
+		}
+		return join(fields, separator, encode(content, encoding)), true
+	}
+	return entity, false
+}
+
+// addToParts adds the footer to all parts of a multipart/alternative body, or to the first part of other multipart bodies.
+// The delimiters, the preamble and the epilogue are kept as they are.
+func addToParts(body []byte, boundary string, alternative bool, footer config.Footer) ([]byte, bool) {
+	delimiter := "--" + boundary
+	buffer := bytes.Buffer{}
+	added := false
+	first := true
+
+	// the line break before a delimiter belongs to the delimiter (RFC 2046 section 5.1.1)
+	var part []byte
+	inPart := false
+	closed := false
+	rest := body
+	for len(rest) > 0 {
+		end := bytes.IndexByte(rest, '\n') + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		line := rest[:end]
+		rest = rest[end:]
+
+		trimmed := strings.TrimRight(string(line), " \t\r\n")
+		if closed || (trimmed != delimiter && trimmed != delimiter+"--") {
+			if inPart {
+				part = append(part, line...)
+			} else {
+				buffer.Write(line)
+			}
+			continue
+		}
+
+		if inPart {
+			content, lineBreak := splitLineBreak(part)
+			if first || alternative {
+				if withFooter, ok := AddFooter(content, footer); ok {
+					content, added = withFooter, true
+				}
+			}
+			first = false
+			buffer.Write(content)
+			buffer.Write(lineBreak)
+		}
+		buffer.Write(line)
+		part, inPart = nil, trimmed == delimiter
+		closed = trimmed == delimiter+"--"
+	}
+	if inPart {
+		// missing close delimiter
+		return body, false
+	}
+	return buffer.Bytes(), added
+}
+
+// splitLineBreak splits the line break at the end of a part from its content
+func splitLineBreak(part []byte) (content, lineBreak []byte) {
+	switch {
+	case bytes.HasSuffix(part, []byte("\r\n")):
+		return part[:len(part)-2], part[len(part)-2:]
+	case bytes.HasSuffix(part, []byte("\n")):
+		return part[:len(part)-1], part[len(part)-1:]
+	}
+	return part, nil
+}
+
+// addText appends the footer to plain text, on its own lines
+func addText(content []byte, text string) []byte {
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, "\r\n"...)
+	}
+	return append(content, "\r\n"+text+"\r\n"...)
+}
+
+// addHtml inserts the footer before the end of the HTML body
+func addHtml(content []byte, text string) []byte {
+	lower := bytes.ToLower(content)
+	i := bytes.LastIndex(lower, []byte("</body>"))
+	if i < 0 {
+		i = bytes.LastIndex(lower, []byte("</html>"))
+	}
+	if i < 0 {
+		return addText(content, text)
+	}
+	result := append([]byte{}, content[:i]...)
+	result = append(result, text+"\r\n"...)
+	return append(result, content[i:]...)
+}
+
+func decode(content []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", "7bit", "8bit", "binary":
+		return content, nil
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(content)))
+	case "base64":
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(content)), ""))
+	}
+	return nil, errors.New("unknown transfer encoding " + encoding)
+}
+
+func encode(content []byte, encoding string) []byte {
+	switch encoding {
+	case "quoted-printable":
+		buffer := bytes.Buffer{}
+		writer := quotedprintable.NewWriter(&buffer)
+		writer.Write(content)
+		writer.Close()
+		return buffer.Bytes()
+	case "base64":
+		encoded := base64.StdEncoding.EncodeToString(content)
+		buffer := bytes.Buffer{}
+		for len(encoded) > 76 {
+			buffer.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		buffer.WriteString(encoded + "\r\n")
+		return buffer.Bytes()
+	}
+	return content
+}
+
+// setField replaces the value of the header field, or adds it
+func setField(fields []string, name, value string) []string {
+	result := []string{}
+	for _, field := range fields {
+		if !strings.EqualFold(helpers.FieldName(field), name) {
+			result = append(result, field)
+		}
+	}
+	return append(result, name+": "+value+"\r\n")
+}
+
+func join(fields []string, separator, body []byte) []byte {
+	buffer := bytes.Buffer{}
+	for _, field := range fields {
+		buffer.WriteString(field)
+	}
+	buffer.Write(separator)
+	buffer.Write(body)
+	return buffer.Bytes()
+}
+
+func isAscii(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package footer
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFooter(t *testing.T) {
+
+	footer := config.Footer{Text: "Example Inc.\nExample City"}
+
+	Convey("Testing AddFooter() on text", t, func() {
+		data, added := AddFooter([]byte("Subject: test\r\n\r\nHello"), footer)
+		So(added, ShouldEqual, true)
+		So(string(data), ShouldEqual, "Subject: test\r\n\r\nHello\r\n\r\nExample Inc.\r\nExample City\r\n")
+
+		data, added = AddFooter([]byte("Content-Type: text/html\r\n\r\n<html><body><p>Hello</p></BODY></html>\r\n"), footer)
+		So(added, ShouldEqual, true)
+		So(string(data), ShouldEqual, "Content-Type: text/html\r\n\r\n<html><body><p>Hello</p><p>Example Inc.<br>\r\nExample City</p>\r\n</BODY></html>\r\n")
+
+		// encoded bodies are decoded and encoded again
+		data, added = AddFooter([]byte("Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nH=C3=A9llo=\r\n world\r\n"), footer)
+		So(added, ShouldEqual, true)
+		So(string(data), ShouldEqual, "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nH=C3=A9llo world\r\n\r\nExample Inc.\r\nExample City\r\n")
+
+		data, added = AddFooter([]byte("Content-Type: text/plain\r\nContent-Transfer-Encoding: base64\r\n\r\nSGVsbG8K\r\n"), footer)
+		So(added, ShouldEqual, true)
+		So(string(data), ShouldEqual, "Content-Type: text/plain\r\nContent-Transfer-Encoding: base64\r\n\r\n"+
+			"SGVsbG8KDQpFeGFtcGxlIEluYy4NCkV4YW1wbGUgQ2l0eQ0K\r\n")
+
+		// non-ASCII footers need UTF-8, and quoted-printable for 7bit bodies
+		unicode := config.Footer{Text: "Café"}
+		_, added = AddFooter([]byte("Content-Type: text/plain; charset=iso-8859-1\r\n\r\nHello\r\n"), unicode)
+		So(added, ShouldEqual, false)
+		data, added = AddFooter([]byte("Content-Type: text/plain; charset=utf-8\r\n\r\nHello\r\n"), unicode)
+		So(added, ShouldEqual, true)
+		So(string(data), ShouldEqual, "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nHello\r\n\r\nCaf=C3=A9\r\n")
+
+		// attachments only, and signed messages
+		_, added = AddFooter([]byte("Content-Type: application/pdf\r\n\r\n%PDF"), footer)
+		So(added, ShouldEqual, false)
+		_, added = AddFooter([]byte("Content-Type: multipart/signed; boundary=b\r\n\r\n--b\r\n\r\nHello\r\n--b--\r\n"), footer)
+		So(added, ShouldEqual, false)
+	})
+
+	Convey("Testing AddFooter() on multipart messages", t, func() {
+		alternative := "Content-Type: multipart/alternative; boundary=\"alt\"\r\n\r\n" +
+			"--alt\r\nContent-Type: text/plain\r\n\r\nHello\r\n" +
+			"--alt\r\nContent-Type: text/html\r\n\r\n<p>Hello</p>\r\n" +
+			"--alt--\r\n"
+		data, added := AddFooter([]byte(alternative), footer)
+		So(added, ShouldEqual, true)
+		So(string(data), ShouldEqual, "Content-Type: multipart/alternative; boundary=\"alt\"\r\n\r\n"+
+			"--alt\r\nContent-Type: text/plain\r\n\r\nHello\r\n\r\nExample Inc.\r\nExample City\r\n\r\n"+
+			"--alt\r\nContent-Type: text/html\r\n\r\n<p>Hello</p>\r\n\r\n<p>Example Inc.<br>\r\nExample City</p>\r\n\r\n"+
+			"--alt--\r\n")
+
+		// only the first part of a mixed message is the text, the attachment is kept
+		mixed := "Content-Type: multipart/mixed; boundary=mixed\r\n\r\nThis is a MIME message.\r\n" +
+			"--mixed\r\n" + alternative +
+			"\r\n--mixed\r\nContent-Type: text/plain\r\nContent-Disposition: attachment\r\n\r\nnotes\r\n" +
+			"--mixed--\r\nepilogue\r\n"
+		data, added = AddFooter([]byte(mixed), footer)
+		So(added, ShouldEqual, true)
+		So(strings.Count(string(data), "Example City"), ShouldEqual, 2)
+		So(string(data), ShouldStartWith, "Content-Type: multipart/mixed; boundary=mixed\r\n\r\nThis is a MIME message.\r\n--mixed\r\n")
+		So(string(data), ShouldEndWith, "Content-Disposition: attachment\r\n\r\nnotes\r\n--mixed--\r\nepilogue\r\n")
+
+		// the close delimiter is missing
+		_, added = AddFooter([]byte("Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nHello\r\n"), footer)
+		So(added, ShouldEqual, false)
+	})
+
+	Convey("Testing Footer handler", t, func() {
+		c := &config.Config{Footers: map[string]config.Footer{"Example.com": footer}}
+		So(json.Unmarshal([]byte(`{"Relay": ["192.168.0.0/24"]}`), &c.Access), ShouldEqual, nil)
+		h := New(c)
+
+		state := &smtp.State{
+			From: &smtp.MailAddress{Address: "bob@example.com"},
+			Data: []byte("Subject: test\r\n\r\nHello\r\n"),
+			Ip:   net.ParseIP("10.0.0.1"),
+		}
+		h.Handle(state)
+		So(string(state.Data), ShouldNotContainSubstring, "Example City")
+
+		state.Ip = net.ParseIP("192.168.0.10")
+		state.From.Address = "bob@example.org"
+		h.Handle(state)
+		So(string(state.Data), ShouldNotContainSubstring, "Example City")
+
+		state.From.Address = "bob@example.com"
+		h.Handle(state)
+		So(string(state.Data), ShouldContainSubstring, "Example City")
+	})
+
+}
@@ -1,6 +1,14 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
 	"github.com/gopistolet/smtp/smtp"
 )
 
@@ -13,16 +21,121 @@ type Handler interface {
 	Handle(state *smtp.State)
 }
 
+// ContextHandler is a Handler which gets the context of the transaction. The context is canceled
+// when the server shuts down or the chain takes longer than its timeout, so slow lookups can be aborted.
+type ContextHandler interface {
+	Handler
+	HandleContext(ctx context.Context, state *smtp.State)
+}
+
+// call calls HandleContext if the handler is a ContextHandler, Handle otherwise
+func call(ctx context.Context, handler Handler, state *smtp.State) {
+	if handler, ok := handler.(ContextHandler); ok {
+		handler.HandleContext(ctx, state)
+		return
+	}
+	handler.Handle(state)
+}
+
 /**
  * HandlerMechanism contains a list of all handlers and executes the chain
  * it is meant to be passed to the MTA as mta.Handler interface
+ *
+ * A handler can drop the message (e.g. when it's quarantined) by removing all recipients,
+ * the rest of the chain is skipped then.
+ *
+ * A panicking handler doesn't take down the whole daemon: the panic is recovered and logged,
+ * the rest of the chain is skipped and the transaction fails in Acceptance (if it's set),
+ * so the client gets a temporary failure and retries the message instead of losing it.
+ * Chains which run after a part of the message was delivered (like the deliveries of the mailing lists)
+ * don't have Acceptance, the retry would deliver that part again.
  */
 type HandlerMachanism struct {
+	// Panics counts the recovered panics (first field, so it's 64-bit aligned for atomic operations)
+	Panics uint64
+
 	Handlers []Handler
+
+	// CrashDir is where the states which made a handler panic are saved for bug reports,
+	// nothing is saved if it's empty
+	CrashDir string
+
+	// Acceptance gets the failure of the transactions in which a handler panicked
+	Acceptance *helpers.Acceptance
+
+	// Context is canceled when the server shuts down, and Timeout limits the time the chain
+	// may take for a message (0 means no limit). They're passed on to the ContextHandlers.
+	Context context.Context
+	Timeout time.Duration
 }
 
 func (h *HandlerMachanism) Handle(state *smtp.State) {
-    for _, handler := range h.Handlers {
-        handler.Handle(state)
-    }
+	ctx := h.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	h.HandleContext(ctx, state)
+}
+
+func (h *HandlerMachanism) HandleContext(ctx context.Context, state *smtp.State) {
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	for _, handler := range h.Handlers {
+		if err := h.handle(ctx, handler, state); err != nil {
+			if h.Acceptance != nil && state != nil {
+				h.Acceptance.Fail(state.SessionId.String(), err)
+			}
+			break
+		}
+		if state != nil && len(state.To) == 0 {
+			break
+		}
+	}
+}
+
+// panics counts the recovered panics of all chains
+var panics uint64
+
+// Panics returns the number of panics which were recovered in the handler chains, for the metrics
+func Panics() uint64 {
+	return atomic.LoadUint64(&panics)
+}
+
+// handle calls a single handler and recovers from its panics, it returns an error if the handler panicked
+func (h *HandlerMachanism) handle(ctx context.Context, handler Handler, state *smtp.State) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		atomic.AddUint64(&h.Panics, 1)
+		atomic.AddUint64(&panics, 1)
+		err = fmt.Errorf("handler %T panicked: %v", handler, r)
+
+		fields := log.Fields{
+			"Handler": fmt.Sprintf("%T", handler),
+		}
+		if state != nil {
+			fields["Ip"] = state.Ip.String()
+			fields["SessionId"] = state.SessionId.String()
+		}
+		logger := log.WithFields(fields)
+		logger.Errorf("Handler panicked: %v\n%s", r, debug.Stack())
+
+		if h.CrashDir != "" && state != nil {
+			filename := h.CrashDir + "/" + helpers.NewId() + ".json"
+			err := helpers.EncodeFile(filename, state)
+			if err != nil {
+				logger.Errorf("Couldn't save crash state: %v", err)
+			} else {
+				logger.Error("Saved crash state to ", filename)
+			}
+		}
+	}()
+
+	call(ctx, handler, state)
+	return nil
 }
@@ -1,19 +1,145 @@
 package handlers
 
 import (
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/handlers/access"
+	"github.com/gopistolet/gopistolet/handlers/alias"
+	"github.com/gopistolet/gopistolet/handlers/clamav"
+	"github.com/gopistolet/gopistolet/handlers/dkimsign"
+	"github.com/gopistolet/gopistolet/handlers/dkimverify"
+	"github.com/gopistolet/gopistolet/handlers/dmarc"
+	"github.com/gopistolet/gopistolet/handlers/dnsbl"
+	"github.com/gopistolet/gopistolet/handlers/footer"
+	"github.com/gopistolet/gopistolet/handlers/idna"
+	"github.com/gopistolet/gopistolet/handlers/loop"
 	"github.com/gopistolet/gopistolet/handlers/maildir"
+	"github.com/gopistolet/gopistolet/handlers/plugins"
+	"github.com/gopistolet/gopistolet/handlers/postmaster"
+	"github.com/gopistolet/gopistolet/handlers/queue"
+	"github.com/gopistolet/gopistolet/handlers/ratelimit"
 	"github.com/gopistolet/gopistolet/handlers/received"
+	"github.com/gopistolet/gopistolet/handlers/reputation"
+	"github.com/gopistolet/gopistolet/handlers/rewrite"
+	"github.com/gopistolet/gopistolet/handlers/scripts"
+	"github.com/gopistolet/gopistolet/handlers/spam"
 	"github.com/gopistolet/gopistolet/handlers/spf"
-	"github.com/gopistolet/smtp/mta"
+	"github.com/gopistolet/gopistolet/handlers/srs"
+	"github.com/gopistolet/gopistolet/handlers/submission"
+	"github.com/gopistolet/gopistolet/handlers/tlsrpt"
+	"github.com/gopistolet/gopistolet/log"
 )
 
 // LoadHandlers creates a HandlerMechanism object with the needed/available loaders
-func LoadHandlers(c *mta.Config) *HandlerMachanism {
+func LoadHandlers(c *config.Config) *HandlerMachanism {
+	handlers := loadFilters(c)
+
+	// Evaluate the candidate configuration alongside the active one
+	if c.Shadow.Config != "" {
+		candidate := &config.Config{Config: c.Config}
+		err := config.Load(c.Shadow.Config, candidate)
+		if err != nil {
+			log.Warnln(err, "- Shadow evaluation disabled.")
+		} else {
+			// the candidate's panics don't fail the message, the ones of the active filters fail it before it's delivered
+			handlers = []Handler{
+				&Shadow{
+					Active:    &HandlerMachanism{Handlers: handlers},
+					Candidate: &HandlerMachanism{Handlers: loadShadowFilters(candidate), CrashDir: c.CrashDir},
+					Report:    c.Shadow.Report,
+
+					Config:          c,
+					CandidateConfig: candidate,
+				},
+			}
+		}
+	}
+
+	// Mail for remote recipients is queued for the smarthost, the rest is delivered to the maildirs.
+	// Mailing lists get their own copy of the message, which is delivered separately.
+	forward := queue.NewForward(c)
+	delivery := maildir.New(c)
+	lists := &Lists{
+		Lists: c.Lists,
+		// the message was delivered to the other recipients already, so a panic doesn't fail it
+		Delivery: &HandlerMachanism{Handlers: []Handler{forward, delivery}, CrashDir: c.CrashDir},
+	}
+
+	chain := &HandlerMachanism{
+		Handlers:   append(handlers, reputation.New(c), forward, lists, delivery),
+		CrashDir:   c.CrashDir,
+		Acceptance: &c.Acceptance,
+		Timeout:    time.Duration(c.HandlerTimeout) * time.Second,
+	}
+
+	// Publish the lifecycle of every message, the audit log records the outcome of every transaction
+	if c.Audit.File != "" {
+		audit := &Audit{File: c.Audit.File, Logins: &c.Logins, Xclient: &c.Xclient, Acceptance: &c.Acceptance}
+		c.Events.Subscribe(audit.Record)
+	}
 	return &HandlerMachanism{
-		Handlers: []Handler{
-			received.New(c),
-			spf.New(c),
-			maildir.New(),
-		},
+		Handlers:   []Handler{&Events{Handler: chain, Bus: &c.Events}},
+		CrashDir:   c.CrashDir,
+		Acceptance: &c.Acceptance,
+	}
+}
+
+// loadFilters returns the handlers which check and annotate the message before it's delivered
+func loadFilters(c *config.Config) []Handler {
+	// Spam scoring, which mail from partner domains skips
+	var scoring Handler = dnsbl.New(c)
+	if len(c.Partners) > 0 {
+		scoring = &Partners{
+			Domains:  c.Partners,
+			Handlers: []Handler{scoring},
+		}
+	}
+
+	return []Handler{
+		loop.New(c),
+		idna.New(c),
+		submission.New(c),
+		received.New(&c.Config, &c.Xclient, &c.Logins, &c.Tls),
+		ratelimit.New(c),
+		access.New(c),
+		spf.New(c),
+		dkimverify.New(c),
+		dmarc.New(c),
+		scoring,
+		clamav.New(c),
+		spam.New(c),
+		plugins.New(c),
+		scripts.New(c),
+		tlsrpt.New(c),
+		srs.New(c),
+		postmaster.New(c),
+		alias.New(c),
+		rewrite.New(c),
+		footer.New(c),
+		dkimsign.New(c),
+	}
+}
+
+// loadShadowFilters returns the filters of loadFilters which can run a second time on every message:
+// the scanners (which query the network or clamd), the plugins, the rate limits (which count the message)
+// and the pipe commands of the aliases are left out.
+func loadShadowFilters(c *config.Config) []Handler {
+	aliases := alias.New(c)
+	aliases.SkipPipes = true
+
+	return []Handler{
+		loop.New(c),
+		idna.New(c),
+		submission.New(c),
+		received.New(&c.Config, &c.Xclient, &c.Logins, &c.Tls),
+		access.New(c),
+		scripts.New(c),
+		srs.New(c),
+		postmaster.New(c),
+		aliases,
+		rewrite.New(c),
+		footer.New(c),
+		dkimsign.New(c),
 	}
 }
@@ -1,6 +1,11 @@
 package handlers
 
 import (
+	"context"
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
 	"github.com/gopistolet/smtp/smtp"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -16,6 +21,37 @@ func (th *TestHandler) Handle(state *smtp.State) {
 	count++
 }
 
+type DropHandler struct {
+}
+
+func (dh *DropHandler) Handle(state *smtp.State) {
+	state.To = nil
+}
+
+type PanicHandler struct {
+}
+
+func (ph *PanicHandler) Handle(state *smtp.State) {
+	panic("something went terribly wrong")
+}
+
+// ContextTestHandler waits until its context is canceled
+type ContextTestHandler struct {
+	err error
+}
+
+func (ch *ContextTestHandler) Handle(state *smtp.State) {
+	ch.HandleContext(context.Background(), state)
+}
+
+func (ch *ContextTestHandler) HandleContext(ctx context.Context, state *smtp.State) {
+	select {
+	case <-ctx.Done():
+		ch.err = ctx.Err()
+	case <-time.After(time.Second):
+	}
+}
+
 func TestHandlersAddress(t *testing.T) {
 
 	// Very stupid test to make sure it does something (and keeps doing)
@@ -34,4 +70,104 @@ func TestHandlersAddress(t *testing.T) {
 
 	})
 
+	Convey("Testing dropping a message in the HandlerMechanism", t, func() {
+
+		count = 0
+		hm := HandlerMachanism{
+			Handlers: []Handler{
+				&TestHandler{},
+				&DropHandler{},
+				&TestHandler{},
+			},
+		}
+
+		hm.Handle(&smtp.State{
+			To: []*smtp.MailAddress{&smtp.MailAddress{Address: "to@test.com"}},
+		})
+
+		So(count, ShouldEqual, 1)
+
+	})
+
+	Convey("Testing panic recovery in the HandlerMechanism", t, func() {
+
+		count = 0
+		hm := HandlerMachanism{
+			Handlers: []Handler{
+				&PanicHandler{},
+				&TestHandler{},
+			},
+			Acceptance: &helpers.Acceptance{},
+		}
+
+		state := &smtp.State{
+			To: []*smtp.MailAddress{&smtp.MailAddress{Address: "to@test.com"}},
+		}
+		hm.Handle(state)
+
+		// the rest of the chain is skipped and the client has to retry the message
+		So(count, ShouldEqual, 0)
+		So(hm.Panics, ShouldEqual, 1)
+		So(hm.Acceptance.Take(state.SessionId.String()), ShouldNotEqual, nil)
+		So(Panics(), ShouldBeGreaterThanOrEqualTo, 1)
+
+		// a panic of the active filters of a Shadow stops the chain before the delivery
+		count = 0
+		hm.Handlers = []Handler{
+			&Shadow{
+				Active:    &HandlerMachanism{Handlers: []Handler{&PanicHandler{}}},
+				Candidate: &HandlerMachanism{},
+			},
+			&TestHandler{},
+		}
+		hm.Handle(state)
+		So(count, ShouldEqual, 0)
+		So(hm.Acceptance.Take(state.SessionId.String()), ShouldNotEqual, nil)
+
+		// the deliveries of the mailing lists don't fail the message, which was delivered to the others
+		hm.Handlers = []Handler{
+			&TestHandler{},
+			&Lists{
+				Lists:    map[string]config.List{"team@test.com": {Members: []string{"a@test.com"}}},
+				Delivery: &HandlerMachanism{Handlers: []Handler{&PanicHandler{}}},
+			},
+		}
+		state.To = []*smtp.MailAddress{{Address: "to@test.com"}, {Address: "team@test.com"}}
+		hm.Handle(state)
+		So(count, ShouldEqual, 1)
+		So(hm.Acceptance.Take(state.SessionId.String()), ShouldEqual, nil)
+
+	})
+
+	Convey("Testing the context in the HandlerMechanism", t, func() {
+
+		count = 0
+		waiting := &ContextTestHandler{}
+		hm := HandlerMachanism{
+			Handlers: []Handler{
+				&Partners{Handlers: []Handler{waiting}},
+				&TestHandler{},
+			},
+			Timeout: 10 * time.Millisecond,
+		}
+
+		hm.Handle(&smtp.State{
+			To: []*smtp.MailAddress{&smtp.MailAddress{Address: "to@test.com"}},
+		})
+
+		// the handlers after the canceled one still run
+		So(waiting.err == context.DeadlineExceeded, ShouldBeTrue)
+		So(count, ShouldEqual, 1)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		hm.Context = ctx
+		hm.Timeout = 0
+		hm.Handle(&smtp.State{
+			To: []*smtp.MailAddress{&smtp.MailAddress{Address: "to@test.com"}},
+		})
+		So(waiting.err == context.Canceled, ShouldBeTrue)
+
+	})
+
 }
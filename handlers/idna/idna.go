@@ -0,0 +1,53 @@
+package idna
+
+import (
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *Idna {
+	return &Idna{
+		config: c,
+	}
+}
+
+// Idna normalizes the internationalized domains of the sender and the recipients to U-labels,
+// so the handlers see one form of every domain (A-labels are used again when the message is sent,
+// see client.Send). Recipients with invalid domains are dropped, and so is the message if the sender's
+// domain is invalid.
+//
+// The smtp library doesn't negotiate SMTPUTF8, so clients can only use A-labels in MAIL and RCPT.
+type Idna struct {
+	config *config.Config
+}
+
+func (handler *Idna) Handle(state *smtp.State) {
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	if state.From != nil && state.From.Address != "" {
+		address, err := helpers.AddressToUnicode(state.From.Address)
+		if err != nil {
+			logger.Warnf("Idna: dropped message from invalid sender %s", state.From.Address)
+			state.To = nil
+			return
+		}
+		state.From.Address = address
+	}
+
+	to := []*smtp.MailAddress{}
+	for _, recipient := range state.To {
+		address, err := helpers.AddressToUnicode(recipient.Address)
+		if err != nil {
+			logger.Warnf("Idna: dropped invalid recipient %s", recipient.Address)
+			continue
+		}
+		recipient.Address = address
+		to = append(to, recipient)
+	}
+	state.To = to
+}
@@ -0,0 +1,41 @@
+package idna
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIdna(t *testing.T) {
+
+	Convey("Testing Idna handler", t, func() {
+		h := New(&config.Config{})
+
+		state := &smtp.State{
+			From: &smtp.MailAddress{Address: "alice@XN--BCHER-KVA.example"},
+			To: []*smtp.MailAddress{
+				{Address: "bob@example.com"},
+				{Address: "jürgen@MÜNCHEN.example"},
+				{Address: "postmaster"},
+				{Address: "bob@xn--bcher.example"},
+			},
+			Ip: net.ParseIP("192.0.2.1"),
+		}
+		h.Handle(state)
+		So(state.From.Address, ShouldEqual, "alice@bücher.example")
+		addresses := []string{}
+		for _, to := range state.To {
+			addresses = append(addresses, to.Address)
+		}
+		So(addresses, ShouldResemble, []string{"bob@example.com", "jürgen@münchen.example", "postmaster"})
+
+		state.From.Address = "alice@-bücher.example"
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 0)
+	})
+
+}
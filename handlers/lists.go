@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+/**
+ * Lists expands mailing list addresses to their members.
+ *
+ * Every list gets its own copy of the message, with Sender and List-* header fields
+ * (and an optional subject prefix), which is handed to Delivery.
+ * The list addresses are removed from the original message, so the other recipients
+ * get it as it was sent.
+ */
+type Lists struct {
+	Lists    map[string]config.List
+	Delivery Handler
+}
+
+func (l *Lists) Handle(state *smtp.State) {
+	l.HandleContext(context.Background(), state)
+}
+
+func (l *Lists) HandleContext(ctx context.Context, state *smtp.State) {
+	if len(l.Lists) == 0 {
+		return
+	}
+
+	to := []*smtp.MailAddress{}
+	for _, recipient := range state.To {
+		address, list, found := l.lookup(recipient.Address)
+		if !found {
+			to = append(to, recipient)
+			continue
+		}
+
+		logger := log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+			"List":      address,
+		})
+
+		// don't send messages from the list to the list again
+		fields, _ := helpers.SplitHeader(state.Data)
+		if hasField(fields, "List-Id", listId(address)) {
+			logger.Warn("List: message was already sent to the list, dropping it")
+			continue
+		}
+
+		listState := copyState(state)
+		listState.Data = listMessage(state.Data, address, list)
+		listState.To = []*smtp.MailAddress{}
+		for _, member := range list.Members {
+			listState.To = append(listState.To, &smtp.MailAddress{Address: member})
+		}
+		// bounces go to the owner
+		if list.Owner != "" {
+			listState.From = &smtp.MailAddress{Address: list.Owner}
+		}
+
+		logger.Infof("List: sending message to %d members", len(listState.To))
+		if len(listState.To) > 0 {
+			call(ctx, l.Delivery, listState)
+		}
+	}
+	state.To = to
+}
+
+// lookup returns the list with the address
+func (l *Lists) lookup(address string) (string, config.List, bool) {
+	for name, list := range l.Lists {
+		if strings.EqualFold(name, address) {
+			return strings.ToLower(name), list, true
+		}
+	}
+	return "", config.List{}, false
+}
+
+// listId returns the List-Id of a list address (RFC 2919): team@example.com has <team.example.com>
+func listId(address string) string {
+	return "<" + strings.Replace(address, "@", ".", 1) + ">"
+}
+
+// hasField reports whether one of the fields has the name and value
+func hasField(fields []string, name, value string) bool {
+	for _, field := range fields {
+		if strings.EqualFold(helpers.FieldName(field), name) && strings.EqualFold(helpers.FieldValue(field), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// listMessage returns the message with the list header fields
+func listMessage(data []byte, address string, list config.List) []byte {
+	sender := list.Owner
+	if sender == "" {
+		sender = address
+	}
+
+	buffer := bytes.Buffer{}
+	buffer.WriteString("Sender: <" + sender + ">\r\n")
+	buffer.WriteString("List-Id: " + listId(address) + "\r\n")
+	buffer.WriteString("List-Post: <mailto:" + address + ">\r\n")
+	if list.Owner != "" {
+		buffer.WriteString("List-Owner: <mailto:" + list.Owner + ">\r\n")
+	}
+
+	fields, body := helpers.SplitHeader(data)
+	subject := false
+	for _, field := range fields {
+		switch strings.ToLower(helpers.FieldName(field)) {
+		case "sender", "list-id", "list-post", "list-owner":
+			continue
+		case "subject":
+			subject = true
+			if list.SubjectPrefix != "" && !strings.Contains(helpers.FieldValue(field), list.SubjectPrefix) {
+				i := strings.IndexByte(field, ':')
+				field = field[:i+1] + " " + list.SubjectPrefix + " " + strings.TrimLeft(field[i+1:], " \t")
+			}
+		}
+		buffer.WriteString(field)
+	}
+	if !subject && list.SubjectPrefix != "" {
+		buffer.WriteString("Subject: " + list.SubjectPrefix + "\r\n")
+	}
+	buffer.Write(body)
+	return buffer.Bytes()
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// CaptureHandler remembers the states it handled
+type CaptureHandler struct {
+	states []*smtp.State
+}
+
+func (ch *CaptureHandler) Handle(state *smtp.State) {
+	ch.states = append(ch.states, state)
+}
+
+func TestLists(t *testing.T) {
+
+	Convey("Testing Lists", t, func() {
+		delivery := &CaptureHandler{}
+		l := Lists{
+			Lists: map[string]config.List{
+				"Team@example.com": {
+					Members:       []string{"bob@example.com", "alice@example.org"},
+					Owner:         "postmaster@example.com",
+					SubjectPrefix: "[team]",
+				},
+			},
+			Delivery: delivery,
+		}
+
+		data := "Subject: Hello\r\nSender: someone@example.org\r\n\r\nHello world!"
+		state := &smtp.State{
+			From: &smtp.MailAddress{Address: "from@test.com"},
+			To: []*smtp.MailAddress{
+				&smtp.MailAddress{Address: "team@example.com"},
+				&smtp.MailAddress{Address: "carol@example.com"},
+			},
+			Ip:   net.ParseIP("192.168.0.10"),
+			Data: []byte(data),
+		}
+		l.Handle(state)
+
+		// the other recipients get the original message
+		So(len(state.To), ShouldEqual, 1)
+		So(state.To[0].Address, ShouldEqual, "carol@example.com")
+		So(state.From.Address, ShouldEqual, "from@test.com")
+		So(string(state.Data), ShouldEqual, data)
+
+		So(len(delivery.states), ShouldEqual, 1)
+		list := delivery.states[0]
+		So(addresses(list.To), ShouldResemble, []string{"bob@example.com", "alice@example.org"})
+		So(list.From.Address, ShouldEqual, "postmaster@example.com")
+		So(string(list.Data), ShouldEqual, "Sender: <postmaster@example.com>\r\n"+
+			"List-Id: <team.example.com>\r\n"+
+			"List-Post: <mailto:team@example.com>\r\n"+
+			"List-Owner: <mailto:postmaster@example.com>\r\n"+
+			"Subject: [team] Hello\r\n"+
+			"\r\nHello world!")
+
+		// messages from the list aren't sent to it again
+		state = &smtp.State{
+			From: &smtp.MailAddress{Address: "postmaster@example.com"},
+			To:   []*smtp.MailAddress{&smtp.MailAddress{Address: "team@example.com"}},
+			Ip:   net.ParseIP("192.168.0.10"),
+			Data: list.Data,
+		}
+		l.Handle(state)
+		So(len(state.To), ShouldEqual, 0)
+		So(len(delivery.states), ShouldEqual, 1)
+	})
+
+}
@@ -0,0 +1,117 @@
+package loop
+
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/handlers/queue"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// defaultMaxHops is the hop limit if MaxHops isn't set (see RFC 5321 section 6.3)
+const defaultMaxHops = 25
+
+func New(c *config.Config) *Loop {
+	return &Loop{
+		config: c,
+		send:   queue.Send,
+	}
+}
+
+// Loop breaks mail loops, which aliases and forwarders can create: messages with more Received
+// header fields than MaxHops, or which already passed this server (its hostname is in the trace),
+// are bounced to the sender and not delivered.
+//
+// The server has accepted the message already, so it can't be rejected anymore.
+type Loop struct {
+	config *config.Config
+
+	// send can be replaced for testing
+	send func(c *config.Config, from, to string, data []byte) error
+}
+
+func (handler *Loop) Handle(state *smtp.State) {
+	msg, err := mail.ReadMessage(bytes.NewReader(state.Data))
+	if err != nil {
+		return
+	}
+	trace := msg.Header["Received"]
+
+	maxHops := handler.config.MaxHops
+	if maxHops <= 0 {
+		maxHops = defaultMaxHops
+	}
+	diagnostic := ""
+	switch {
+	case len(trace) > maxHops:
+		diagnostic = "554 5.4.6 Too many hops"
+	case receivedBy(trace, handler.config.Hostname):
+		diagnostic = "554 5.4.6 Mail loop detected"
+	default:
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	}).Warnf("Loop: dropped message with %d Received header fields: %s", len(trace), diagnostic)
+	handler.bounce(state, diagnostic)
+	state.To = nil
+}
+
+// receivedBy reports whether one of the Received header fields was added by the host
+func receivedBy(trace []string, hostname string) bool {
+	if hostname == "" {
+		return false
+	}
+	for _, received := range trace {
+		fields := strings.Fields(received)
+		for i := 0; i+1 < len(fields); i++ {
+			if strings.EqualFold(fields[i], "by") && strings.EqualFold(strings.TrimSuffix(fields[i+1], ";"), hostname) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bounce returns the message to the sender, unless it's a bounce itself
+// or the recipients asked not to be notified of failures (RFC 3461 section 4.1)
+func (handler *Loop) bounce(state *smtp.State, diagnostic string) {
+	if state.From == nil || state.From.Address == "" {
+		return
+	}
+
+	recipients := []helpers.DsnRecipient{}
+	for _, to := range state.To {
+		request, found := handler.config.Dsn.Get(state.SessionId.String(), to.Address)
+		if found {
+			handler.config.Dsn.Forget(state.SessionId.String(), to.Address)
+			if !request.Wants("FAILURE") {
+				continue
+			}
+		}
+		recipients = append(recipients, helpers.DsnRecipient{
+			Recipient:  to.Address,
+			Action:     helpers.DsnFailed,
+			Request:    request,
+			Status:     "5.4.6",
+			Diagnostic: diagnostic,
+		})
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	dsn := helpers.NewFailureDsn(&handler.config.Catalog, handler.config.Hostname, state.From.Address, recipients, state.Data)
+	if err := handler.send(handler.config, "", state.From.Address, dsn); err != nil {
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+		}).Errorf("Loop: couldn't send delivery status notification: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package loop
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/smtp/mta"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoop(t *testing.T) {
+
+	Convey("Testing Loop handler", t, func() {
+		c := &config.Config{
+			Config:  mta.Config{Hostname: "mx.example.com"},
+			MaxHops: 3,
+		}
+		h := New(c)
+		sent := map[string]string{}
+		h.send = func(c *config.Config, from, to string, data []byte) error {
+			sent[to] = string(data)
+			return nil
+		}
+
+		newState := func(from string, trace ...string) *smtp.State {
+			header := ""
+			for _, by := range trace {
+				header += "Received: from somewhere.example (192.0.2.1)\r\n\tby " + by + " with ESMTP id 1; Wed, 5 Oct 2016 14:57:46 +0200\r\n"
+			}
+			return &smtp.State{
+				From: &smtp.MailAddress{Address: from},
+				To:   []*smtp.MailAddress{{Address: "bob@example.com"}},
+				Data: []byte(header + "Subject: test\r\n\r\nHello world!\r\n"),
+				Ip:   net.ParseIP("192.0.2.1"),
+			}
+		}
+
+		state := newState("alice@example.org", "a.example", "b.example", "c.example")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 1)
+		So(len(sent), ShouldEqual, 0)
+
+		// too many hops
+		state = newState("alice@example.org", "a.example", "b.example", "c.example", "d.example")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 0)
+		So(sent["alice@example.org"], ShouldContainSubstring, "Status: 5.4.6")
+		So(sent["alice@example.org"], ShouldContainSubstring, "Too many hops")
+
+		// the message passed this server before
+		state = newState("alice@example.org", "MX.example.com")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 0)
+		So(sent["alice@example.org"], ShouldContainSubstring, "Mail loop detected")
+
+		// bounces aren't bounced
+		sent = map[string]string{}
+		state = newState("", strings.Split("a b c d e", " ")...)
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 0)
+		So(len(sent), ShouldEqual, 0)
+	})
+
+}
@@ -3,47 +3,368 @@ package maildir
 import (
 	"bytes"
 	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/events"
+	"github.com/gopistolet/gopistolet/handlers/queue"
+	"github.com/gopistolet/gopistolet/helpers"
 	"github.com/gopistolet/gopistolet/log"
 	"github.com/gopistolet/smtp/smtp"
 	"github.com/sloonz/go-maildir"
 )
 
-func New() *Maildir {
-	return &Maildir{}
+// root is the maildir in which all mail is stored if there are no local domains,
+// the mailboxes of local domains are maildirs below it
+const root = "./maildir"
+
+func New(c *config.Config) *Maildir {
+	return &Maildir{
+		config:   c,
+		maildirs: make(map[string]*maildir.Maildir),
+		scripts:  make(map[string]cachedScript),
+		replied:  make(map[string]time.Time),
+	}
 }
 
 type Maildir struct {
-	mailDir *maildir.Maildir
+	config *config.Config
+
+	mutex    sync.Mutex
+	maildirs map[string]*maildir.Maildir
+	scripts  map[string]cachedScript
+	// replied contains until when correspondents don't get another vacation message
+	replied map[string]time.Time
 }
 
 func (m *Maildir) Handle(state *smtp.State) {
-	err := errors.New("")
+	// Without local domains, all mail goes to one maildir
+	domains := m.config.LocalDomains
+	if len(domains) == 0 {
+		filename, err := m.deliver(root, state, nil, false)
+		if err != nil {
+			m.config.Acceptance.Fail(state.SessionId.String(), err)
+		} else {
+			m.undo(state, []string{filename}, nil)
+		}
+		delivered := []string{}
+		for _, to := range state.To {
+			m.record(state, to.Address, err)
+			if err == nil {
+				delivered = append(delivered, to.Address)
+			}
+		}
+		m.notifySuccess(state, delivered)
+		return
+	}
 
-	// Open maildir if it's not yet open
-	if m.mailDir == nil {
+	// Every mailbox gets one copy, mail for other domains goes to the root maildir
+	paths := []string{}
+	recipients := make(map[string][]string)
+	tags := make(map[string][]string)
+	flagged := make(map[string]bool)
+	// the mailbox (with the quota) of each path, paths of subfolders count for the mailbox
+	quotaDirs := make(map[string]string)
+	for _, to := range state.To {
+		mailboxes := []string{""}
+		quotaDir := ""
+		if domains.IsLocal(to.Address) {
+			mailbox, found := domains.Mailbox(to.Address, m.config.RecipientDelimiter)
+			if !found {
+				log.WithFields(log.Fields{
+					"Ip":        state.Ip.String(),
+					"SessionId": state.SessionId.String(),
+				}).Warn("Maildir: unknown local user " + to.Address)
+				m.record(state, to.Address, errors.New("unknown user"))
+				m.refuse(state, to.Address, "550 5.1.1 User unknown")
+				continue
+			}
+			quotaDir = filepath.Join(root, filepath.FromSlash(mailbox))
+			if !m.fits(state, to.Address, quotaDir) {
+				// the session refuses full mailboxes at RCPT, the message may still be too large for the rest
+				m.record(state, to.Address, errors.New("552 5.2.2 Mailbox full"))
+				request, _ := m.config.Dsn.Get(state.SessionId.String(), to.Address)
+				if !m.refuse(state, to.Address, "552 5.2.2 Mailbox full") {
+					m.notifyFailure(state, to.Address, request, "5.2.2", "552 5.2.2 Mailbox full")
+				}
+				continue
+			}
+			flag, junk, reject := m.spam(state, to.Address)
+			// spam is only refused in the reply to DATA, it isn't bounced
+			if reject && m.config.Acceptance.Reject(state.SessionId.String(), to.Address, "550 5.7.1 Message refused as spam") {
+				m.record(state, to.Address, errors.New("550 5.7.1 Message refused as spam"))
+				m.config.Dsn.Forget(state.SessionId.String(), to.Address)
+				continue
+			}
+			mailboxes = m.filter(state, to.Address, mailbox)
+			if len(mailboxes) == 0 {
+				// discarded by the Sieve script
+				m.record(state, to.Address, nil)
+				continue
+			}
+			if junk {
+				mailboxes = m.junk(state, mailbox, mailboxes)
+			}
+			if flag {
+				for _, mailbox := range mailboxes {
+					flagged[filepath.Join(root, filepath.FromSlash(mailbox))] = true
+				}
+			}
+		}
+		for _, mailbox := range mailboxes {
+			path := filepath.Join(root, filepath.FromSlash(mailbox))
+			if _, seen := recipients[path]; !seen {
+				paths = append(paths, path)
+			}
+			recipients[path] = append(recipients[path], to.Address)
+			if quotaDir != "" && (path == quotaDir || strings.HasPrefix(path, quotaDir+string(filepath.Separator))) {
+				quotaDirs[path] = quotaDir
+			}
+			if domains.TagOriginal(to.Address, m.config.RecipientDelimiter) {
+				tags[path] = append(tags[path], to.Address)
+			}
+		}
+	}
 
-		// Open a maildir. If it does not exist, create it.
-		m.mailDir, err = maildir.New("./maildir", true)
-		if err != nil {
-			log.Errorf("Could not open maildir: %v", err)
-			return
+	// the message is written to all mailboxes or to none: the client retries a failed message
+	// for all recipients, so the copies which were written are removed again
+	written, added := []string{}, []string{}
+	var err error
+	for _, path := range paths {
+		var filename string
+		if filename, err = m.deliver(path, state, tags[path], flagged[path]); err != nil {
+			break
+		}
+		written = append(written, filename)
+		if quotaDirs[path] != "" {
+			m.config.MailboxUsage.Add(quotaDirs[path], int64(len(state.Data)))
+			added = append(added, quotaDirs[path])
 		}
 	}
+	if err != nil {
+		m.config.Acceptance.Fail(state.SessionId.String(), err)
+	}
+	// the copies are also removed if a later failure of the transaction makes the client retry it
+	m.undo(state, written, added)
 
-	dataReader := bytes.NewReader(state.Data)
+	delivered := []string{}
+	for _, path := range paths {
+		for _, recipient := range recipients[path] {
+			m.record(state, recipient, err)
+			if err == nil {
+				delivered = append(delivered, recipient)
+			}
+		}
+	}
+	m.notifySuccess(state, delivered)
+}
 
-	// Save mail in maildir
-	filename, err := m.mailDir.CreateMail(dataReader)
+// undo has the files of the message removed from the mailboxes, and their size from the usage
+// of the quota dirs, if the transaction fails (see helpers.Acceptance)
+func (m *Maildir) undo(state *smtp.State, files []string, quotaDirs []string) {
+	if len(files) == 0 {
+		return
+	}
+	m.config.Acceptance.Undo(state.SessionId.String(), func() {
+		for _, filename := range files {
+			if err := os.Remove(filename); err != nil {
+				log.WithFields(log.Fields{
+					"Ip":        state.Ip.String(),
+					"SessionId": state.SessionId.String(),
+				}).Errorf("Maildir: couldn't remove %s of the failed message: %v", filename, err)
+			}
+		}
+		for _, dir := range quotaDirs {
+			m.config.MailboxUsage.Add(dir, -int64(len(state.Data)))
+		}
+	})
+}
+
+// refuse refuses the message for the recipient in the reply to DATA if the client negotiated PRDR,
+// it returns false otherwise. Unknown users aren't bounced then: the session refuses them at RCPT already,
+// and a bounce to a sender which may be forged would be backscatter.
+func (m *Maildir) refuse(state *smtp.State, recipient, reply string) bool {
+	m.config.Dsn.Forget(state.SessionId.String(), recipient)
+	return m.config.Acceptance.Reject(state.SessionId.String(), recipient, reply)
+}
+
+// notifyFailure sends a delivery status notification to the sender for a recipient whose mailbox
+// refused the message after it was accepted, unless the recipient asked not to be notified of failures
+func (m *Maildir) notifyFailure(state *smtp.State, recipient string, request helpers.DsnRequest, status, diagnostic string) {
+	if state.From == nil || state.From.Address == "" || !request.WantsFailure() {
+		return
+	}
+
+	recipients := []helpers.DsnRecipient{{Recipient: recipient, Action: helpers.DsnFailed, Request: request, Status: status, Diagnostic: diagnostic}}
+	dsn := helpers.NewFailureDsn(&m.config.Catalog, m.config.Hostname, state.From.Address, recipients, state.Data)
+	if err := queue.Send(m.config, "", state.From.Address, dsn); err != nil {
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+		}).Errorf("Maildir: couldn't send delivery status notification: %v", err)
+	}
+}
+
+// MailboxFull reports whether the mailbox of a local recipient has reached its quota,
+// so the session can refuse the recipient at RCPT before the size of the message is known
+func MailboxFull(c *config.Config, recipient string) bool {
+	if !c.LocalDomains.IsLocal(recipient) {
+		return false
+	}
+	quota := c.LocalDomains.Quota(recipient, c.RecipientDelimiter)
+	mailbox, found := c.LocalDomains.Mailbox(recipient, c.RecipientDelimiter)
+	if quota <= 0 || !found {
+		return false
+	}
+	// a full mailbox has no room for a message of a single byte,
+	// and mail isn't refused because the usage couldn't be measured
+	fits, err := c.MailboxUsage.Fits(filepath.Join(root, filepath.FromSlash(mailbox)), quota, 1)
+	return err == nil && !fits
+}
+
+// notifySuccess sends a delivery status notification to the sender
+// for the delivered recipients which asked for it with NOTIFY=SUCCESS
+func (m *Maildir) notifySuccess(state *smtp.State, delivered []string) {
+	if state.From == nil || state.From.Address == "" {
+		return
+	}
+
+	recipients := []helpers.DsnRecipient{}
+	seen := make(map[string]bool)
+	for _, recipient := range delivered {
+		request, found := m.config.Dsn.Get(state.SessionId.String(), recipient)
+		if !found || seen[recipient] {
+			continue
+		}
+		seen[recipient] = true
+		m.config.Dsn.Forget(state.SessionId.String(), recipient)
+		if request.Wants("SUCCESS") {
+			recipients = append(recipients, helpers.DsnRecipient{Recipient: recipient, Action: helpers.DsnDelivered, Request: request})
+		}
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	dsn := helpers.NewSuccessDsn(&m.config.Catalog, m.config.Hostname, state.From.Address, recipients, state.Data)
+	if err := queue.Send(m.config, "", state.From.Address, dsn); err != nil {
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+		}).Errorf("Maildir: couldn't send delivery status notification: %v", err)
+	}
+}
+
+// fits reports whether the message fits in the quota of the recipient's mailbox at dir
+func (m *Maildir) fits(state *smtp.State, recipient string, dir string) bool {
+	quota := m.config.LocalDomains.Quota(recipient, m.config.RecipientDelimiter)
+	if quota <= 0 {
+		return true
+	}
+	fits, err := m.config.MailboxUsage.Fits(dir, quota, int64(len(state.Data)))
 	if err != nil {
+		// don't refuse mail because the usage couldn't be measured
 		log.WithFields(log.Fields{
 			"Ip":        state.Ip.String(),
 			"SessionId": state.SessionId.String(),
-		}).Error(err)
+		}).Errorf("Maildir: couldn't measure usage of %s: %v", dir, err)
+		return true
+	}
+	if !fits {
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+			"To":        recipient,
+		}).Warn("Maildir: mailbox full, message not delivered")
+	}
+	return fits
+}
+
+// record publishes the delivery outcome, and records it for the sender of a submitted message
+func (m *Maildir) record(state *smtp.State, recipient string, err error) {
+	if err == nil {
+		m.config.Events.Publish(events.MessageDelivered{SessionId: state.SessionId.String(), Recipients: []string{recipient}, Destination: "maildir"})
 	} else {
+		m.config.Events.Publish(events.DeliveryFailed{
+			SessionId:   state.SessionId.String(),
+			Recipients:  []string{recipient},
+			Destination: "maildir",
+			Error:       err.Error(),
+			Permanent:   true,
+		})
+	}
+
+	status := &m.config.DeliveryStatus
+	if status.Dir == "" || state.From == nil || !m.config.MayRelay(state) {
+		return
+	}
+
+	messageId := helpers.MessageId(state.Data)
+	outcome := helpers.DeliveryOutcome{Recipient: recipient, Outcome: helpers.DeliveryDelivered}
+	if err != nil {
+		outcome.Outcome, outcome.Detail = helpers.DeliveryFailed, err.Error()
+	}
+	if err := status.Record(state.From.Address, messageId, outcome); err != nil {
 		log.WithFields(log.Fields{
 			"Ip":        state.Ip.String(),
 			"SessionId": state.SessionId.String(),
-		}).Info("Maildir: mail written to file: " + filename)
+		}).Errorf("Maildir: couldn't record delivery status: %v", err)
+	}
+}
+
+// deliver saves the message in the maildir at path, it returns the name of its file.
+// It has an X-Original-To header field for each of the tagged recipients and X-Spam-Flag if it's flagged as spam.
+func (m *Maildir) deliver(path string, state *smtp.State, tagged []string, flagged bool) (string, error) {
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	m.mutex.Lock()
+	mailDir, found := m.maildirs[path]
+	if !found {
+		// Open a maildir. If it does not exist, create it.
+		var err error
+		mailDir, err = maildir.New(path, true)
+		if err != nil {
+			m.mutex.Unlock()
+			logger.Errorf("Could not open maildir %s: %v", path, err)
+			return "", err
+		}
+		m.maildirs[path] = mailDir
+	}
+	m.mutex.Unlock()
+
+	data := state.Data
+	for _, recipient := range tagged {
+		data = append([]byte("X-Original-To: "+recipient+"\r\n"), data...)
+	}
+	if flagged {
+		data = append([]byte("X-Spam-Flag: YES\r\n"), data...)
+	}
+	data, err := m.config.Encryption.Seal(data)
+	if err != nil {
+		logger.Error(err)
+		return "", err
+	}
+
+	// Save mail in maildir
+	filename, err := mailDir.CreateMail(bytes.NewReader(data))
+	if err == nil {
+		// the file is flushed, but it was renamed to new/ afterwards
+		if err = helpers.SyncDir(filepath.Dir(filename)); err != nil {
+			// the client retries the message, the copy which may not last isn't kept
+			os.Remove(filename)
+		}
+	}
+	if err != nil {
+		logger.Error(err)
+		return "", err
 	}
+	logger.Info("Maildir: mail written to file: " + filename)
+	return filename, nil
 }
@@ -0,0 +1,82 @@
+package maildir
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQuota(t *testing.T) {
+
+	Convey("Testing full mailboxes", t, func() {
+		dir, err := ioutil.TempDir("", "maildir")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		wd, err := os.Getwd()
+		So(err, ShouldEqual, nil)
+		So(os.Chdir(dir), ShouldEqual, nil)
+		defer os.Chdir(wd)
+
+		c := &config.Config{
+			LocalDomains: helpers.LocalDomains{
+				"example.com": {Users: map[string]string{"bob": "", "alice": ""}, Quota: 1},
+			},
+		}
+		So(os.MkdirAll(filepath.Join(root, "example.com", "bob", "new"), 0700), ShouldEqual, nil)
+		So(ioutil.WriteFile(filepath.Join(root, "example.com", "bob", "new", "1"), make([]byte, 1<<20), 0600), ShouldEqual, nil)
+
+		So(MailboxFull(c, "bob@example.com"), ShouldBeTrue)
+		So(MailboxFull(c, "alice@example.com"), ShouldBeFalse)
+		So(MailboxFull(c, "bob@example.org"), ShouldBeFalse)
+		c.LocalDomains["example.com"] = helpers.LocalDomain{Users: map[string]string{"bob": ""}}
+		So(MailboxFull(c, "bob@example.com"), ShouldBeFalse)
+	})
+
+}
+
+func TestAllOrNothing(t *testing.T) {
+
+	Convey("Testing a message which can't be written to all mailboxes", t, func() {
+		dir, err := ioutil.TempDir("", "maildir")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		wd, err := os.Getwd()
+		So(err, ShouldEqual, nil)
+		So(os.Chdir(dir), ShouldEqual, nil)
+		defer os.Chdir(wd)
+
+		c := &config.Config{
+			LocalDomains: helpers.LocalDomains{
+				"example.com": {Users: map[string]string{"alice": "", "bob": ""}},
+			},
+		}
+		// the maildir of bob can't be created
+		So(os.MkdirAll(filepath.Join(root, "example.com"), 0700), ShouldEqual, nil)
+		So(ioutil.WriteFile(filepath.Join(root, "example.com", "bob"), nil, 0600), ShouldEqual, nil)
+
+		state := &smtp.State{
+			To:   []*smtp.MailAddress{{Address: "alice@example.com"}, {Address: "bob@example.com"}},
+			Data: []byte("Subject: hello\r\n\r\nHello\r\n"),
+		}
+		New(c).Handle(state)
+		So(c.Acceptance.Take(state.SessionId.String()), ShouldNotEqual, nil)
+		// the copy of alice is removed, the client retries the message for both
+		files, _ := filepath.Glob(filepath.Join(root, "example.com", "alice", "new", "*"))
+		So(files, ShouldBeEmpty)
+
+		// a message which was written is kept
+		state.To = state.To[:1]
+		New(c).Handle(state)
+		So(c.Acceptance.Take(state.SessionId.String()), ShouldEqual, nil)
+		files, _ = filepath.Glob(filepath.Join(root, "example.com", "alice", "new", "*"))
+		So(len(files), ShouldEqual, 1)
+	})
+
+}
@@ -0,0 +1,154 @@
+package maildir
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gopistolet/gopistolet/handlers/queue"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/gopistolet/sieve"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// cachedScript is a parsed Sieve script, it's parsed again when the file changes
+type cachedScript struct {
+	script   *sieve.Script
+	modified time.Time
+}
+
+// filter runs the Sieve script of the recipient (if any) and returns the mailboxes
+// the message should be delivered to. Without a script, or if the script fails, the message is kept.
+func (m *Maildir) filter(state *smtp.State, recipient string, mailbox string) []string {
+	file := m.config.LocalDomains.Script(recipient, m.config.RecipientDelimiter)
+	if file == "" {
+		if reply := m.config.LocalDomains.AutoReply(recipient, m.config.RecipientDelimiter); reply != nil {
+			m.vacation(state, recipient, autoReply{AutoReply: *reply})
+		}
+		return []string{mailbox}
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+		"To":        recipient,
+	})
+
+	script, err := m.script(file)
+	if err != nil {
+		logger.Errorf("Sieve: couldn't load script %s: %v", file, err)
+		return []string{mailbox}
+	}
+	from := ""
+	if state.From != nil {
+		from = state.From.Address
+	}
+	message := sieve.NewMessage(state.Data, from, recipient)
+	message.Delimiter = m.config.RecipientDelimiter
+	actions, err := script.Execute(message)
+	if err != nil {
+		logger.Errorf("Sieve: script %s failed: %v", file, err)
+		return []string{mailbox}
+	}
+
+	mailboxes := []string{}
+	for _, action := range actions {
+		switch action.Type {
+		case sieve.ActionKeep:
+			mailboxes = append(mailboxes, mailbox)
+		case sieve.ActionFileInto:
+			folder, valid := folder(action.Target)
+			if !valid {
+				logger.Warnf("Sieve: invalid folder %q, keeping message", action.Target)
+				mailboxes = append(mailboxes, mailbox)
+				continue
+			}
+			mailboxes = append(mailboxes, path.Join(mailbox, folder))
+		case sieve.ActionRedirect:
+			// local redirects are delivered without running the script of the new recipient, so they can't loop
+			if target, found := m.config.LocalDomains.Mailbox(action.Target, m.config.RecipientDelimiter); found {
+				mailboxes = append(mailboxes, target)
+				continue
+			}
+			if err := m.redirect(state, action.Target); err != nil {
+				logger.Errorf("Sieve: couldn't redirect to %s, keeping message: %v", action.Target, err)
+				mailboxes = append(mailboxes, mailbox)
+				continue
+			}
+			logger.Infof("Sieve: redirected to %s", action.Target)
+		case sieve.ActionVacation:
+			v := action.Vacation
+			m.vacation(state, recipient, autoReply{
+				AutoReply: helpers.AutoReply{Subject: v.Subject, Message: v.Reason, Days: v.Days, Addresses: v.Addresses},
+				From:      v.From,
+				Handle:    v.Handle,
+				Mime:      v.Mime,
+			})
+		}
+	}
+	if len(mailboxes) == 0 {
+		logger.Info("Sieve: message discarded")
+	}
+	return mailboxes
+}
+
+// redirect sends the message to a remote address with the envelope sender of the message,
+// which is rewritten with SRS for senders of other domains so their SPF records still pass
+func (m *Maildir) redirect(state *smtp.State, to string) error {
+	from := ""
+	if state.From != nil {
+		from = state.From.Address
+		if m.config.Srs.Enabled() && !m.config.LocalDomains.IsLocal(from) {
+			from = m.config.Srs.Forward(from)
+		}
+	}
+	return queue.Send(m.config, from, to, state.Data)
+}
+
+// script returns the parsed script in the file
+func (m *Maildir) script(file string) (*sieve.Script, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	cached, found := m.scripts[file]
+	m.mutex.Unlock()
+	if found && cached.modified.Equal(info.ModTime()) {
+		return cached.script, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	script, err := sieve.Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	m.scripts[file] = cachedScript{script: script, modified: info.ModTime()}
+	m.mutex.Unlock()
+	return script, nil
+}
+
+// folder returns the Maildir++ folder of a mailbox name: Lists/Go becomes .Lists.Go,
+// false is returned for names which would escape the maildir
+func folder(name string) (string, bool) {
+	name = strings.Trim(strings.Replace(name, "/", ".", -1), ".")
+	if strings.EqualFold(name, "INBOX") {
+		return "", true
+	}
+	if len(name) > 6 && strings.EqualFold(name[:6], "INBOX.") {
+		name = name[6:]
+	}
+	if name == "" || strings.Contains(name, "..") || strings.ContainsAny(name, "\\\x00") {
+		return "", false
+	}
+	return "." + name, true
+}
@@ -0,0 +1,60 @@
+package maildir
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRedirect(t *testing.T) {
+
+	Convey("Testing Sieve redirects", t, func() {
+		dir, err := ioutil.TempDir("", "maildir")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		script := filepath.Join(dir, "bob.sieve")
+		c := &config.Config{
+			LocalDomains: helpers.LocalDomains{
+				"example.com": {Users: map[string]string{"alice": "", "bob": ""}, Sieve: map[string]string{"bob": script}},
+			},
+			Queue:   config.Queue{Dir: filepath.Join(dir, "queue")},
+			Forward: config.Forward{Smarthost: "smarthost.example.net:25"},
+			Srs:     helpers.Srs{Domain: "example.com", Secrets: []string{"secret"}},
+		}
+		So(c.Queue.Open(), ShouldEqual, nil)
+		m := New(c)
+		state := &smtp.State{
+			From: &smtp.MailAddress{Address: "carol@example.org"},
+			Data: []byte("Subject: hello\r\n\r\nHello\r\n"),
+		}
+
+		// local addresses are delivered to their mailbox
+		So(ioutil.WriteFile(script, []byte(`redirect "alice@example.com";`), 0600), ShouldEqual, nil)
+		So(m.filter(state, "bob@example.com", "example.com/bob"), ShouldResemble, []string{"example.com/alice"})
+
+		// remote addresses are queued with an SRS sender
+		So(ioutil.WriteFile(script, []byte(`redirect "bob@example.net";`), 0600), ShouldEqual, nil)
+		os.Chtimes(script, time.Now(), time.Now().Add(time.Minute))
+		So(m.filter(state, "bob@example.com", "example.com/bob"), ShouldBeEmpty)
+		files, _ := filepath.Glob(filepath.Join(dir, "queue", "*.json"))
+		So(len(files), ShouldEqual, 1)
+		queued := &smtp.State{}
+		So(helpers.DecodeFile(files[0], queued), ShouldEqual, nil)
+		So(queued.To[0].Address, ShouldEqual, "bob@example.net")
+		So(queued.From.Address, ShouldStartWith, "SRS0=")
+
+		// the message is kept when it can't be sent
+		c.Queue.Store = nil
+		So(m.filter(state, "bob@example.com", "example.com/bob"), ShouldResemble, []string{"example.com/bob"})
+	})
+
+}
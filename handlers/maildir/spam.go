@@ -0,0 +1,63 @@
+package maildir
+
+import (
+	"path"
+
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/gopistolet/user"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// spam reports whether the message is flagged as spam for the recipient, whether it goes
+// to the junk folder and whether the recipient refuses it, by its X-Spam-Score and the thresholds of the user (see config.Spam)
+func (m *Maildir) spam(state *smtp.State, recipient string) (flag, junk, reject bool) {
+	score, found := helpers.SpamScore(state.Data)
+	if !found {
+		return false, false, false
+	}
+
+	var u *user.User
+	if store := m.config.Users.Store; store != nil {
+		var err error
+		u, err = store.Get(recipient)
+		if err != nil {
+			// the thresholds of the config still apply
+			log.WithFields(log.Fields{
+				"Ip":        state.Ip.String(),
+				"SessionId": state.SessionId.String(),
+				"To":        recipient,
+			}).Errorf("Maildir: couldn't look up user for spam thresholds: %v", err)
+		}
+	}
+
+	tag, junkScore := m.config.Spam.Thresholds(u)
+	rejectScore := m.config.Spam.RejectThreshold(u)
+	return score >= tag, junkScore > 0 && score >= junkScore, rejectScore > 0 && score >= rejectScore
+}
+
+// junk moves the message from the inbox of the mailbox to its junk folder,
+// folders chosen by the Sieve script are kept
+func (m *Maildir) junk(state *smtp.State, mailbox string, mailboxes []string) []string {
+	name := m.config.Spam.JunkFolder
+	if name == "" {
+		name = "Junk"
+	}
+	junk, valid := folder(name)
+	if !valid || junk == "" {
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+		}).Warnf("Maildir: invalid junk folder %q, keeping message in inbox", name)
+		return mailboxes
+	}
+
+	moved := make([]string, len(mailboxes))
+	for i, target := range mailboxes {
+		moved[i] = target
+		if target == mailbox {
+			moved[i] = path.Join(mailbox, junk)
+		}
+	}
+	return moved
+}
@@ -0,0 +1,53 @@
+package maildir
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/user"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSpam(t *testing.T) {
+
+	Convey("Testing spam thresholds", t, func() {
+		db := &user.UserDB{}
+		db.Add(&user.User{Name: "careful@example.com", SpamTagScore: 2, SpamJunkScore: 4, SpamRejectScore: 8})
+		c := &config.Config{Spam: config.Spam{JunkScore: 10}}
+		c.Users.Store = db
+		m := New(c)
+
+		state := &smtp.State{Ip: net.ParseIP("192.0.2.1")}
+		spam := func(score, recipient string) []bool {
+			state.Data = []byte("X-Spam-Score: " + score + "\r\nSubject: test\r\n\r\nHello\r\n")
+			flag, junk, reject := m.spam(state, recipient)
+			return []bool{flag, junk, reject}
+		}
+
+		So(spam("1.0", "bob@example.com"), ShouldResemble, []bool{false, false, false})
+		So(spam("5.0", "bob@example.com"), ShouldResemble, []bool{true, false, false})
+		So(spam("12.0", "bob@example.com"), ShouldResemble, []bool{true, true, false})
+		So(spam("3.0", "careful@example.com"), ShouldResemble, []bool{true, false, false})
+		So(spam("4.0", "careful@example.com"), ShouldResemble, []bool{true, true, false})
+		So(spam("8.0", "careful@example.com"), ShouldResemble, []bool{true, true, true})
+		c.Spam.RejectScore = 20
+		So(spam("20.0", "bob@example.com"), ShouldResemble, []bool{true, true, true})
+
+		// messages without a score aren't spam
+		state.Data = []byte("Subject: test\r\n\r\nHello\r\n")
+		flag, junk, reject := m.spam(state, "careful@example.com")
+		So(flag || junk || reject, ShouldEqual, false)
+
+		// only the inbox is replaced by the junk folder
+		So(m.junk(state, "example.com/bob", []string{"example.com/bob", "example.com/bob/.Lists"}), ShouldResemble,
+			[]string{"example.com/bob/.Junk", "example.com/bob/.Lists"})
+		c.Spam.JunkFolder = "INBOX/Spam"
+		So(m.junk(state, "example.com/bob", []string{"example.com/bob"}), ShouldResemble, []string{"example.com/bob/.Spam"})
+		c.Spam.JunkFolder = "INBOX"
+		So(m.junk(state, "example.com/bob", []string{"example.com/bob"}), ShouldResemble, []string{"example.com/bob"})
+	})
+
+}
@@ -0,0 +1,179 @@
+package maildir
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gopistolet/gopistolet/handlers/queue"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// autoReply is a vacation message, from the config or from a Sieve script
+type autoReply struct {
+	helpers.AutoReply
+	// From is the address the reply is sent from, defaults to the recipient
+	From string
+	// Handle identifies the vacation message, correspondents get one reply per handle every Days days
+	Handle string
+	// Mime means the message is a MIME entity with its own header fields
+	Mime bool
+}
+
+// listFields are the header fields which mark mailing list mail (RFC 2369, RFC 2919)
+var listFields = []string{"List-Id", "List-Post", "List-Unsubscribe", "List-Help", "List-Owner"}
+
+// vacation sends the vacation message to the sender of the message, unless it shouldn't be answered
+// (RFC 3834 section 2, RFC 5230 section 4.5) or the sender got a reply recently
+func (m *Maildir) vacation(state *smtp.State, recipient string, reply autoReply) {
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+		"To":        recipient,
+	})
+
+	sender := ""
+	if state.From != nil {
+		sender = state.From.Address
+	}
+	user, _ := helpers.SplitSubaddress(recipient, m.config.RecipientDelimiter)
+	fields, _ := helpers.SplitHeader(state.Data)
+	if reason := suppressReply(fields, sender, append([]string{recipient, user, reply.From}, reply.Addresses...)); reason != "" {
+		logger.Debug("Vacation: not replying, ", reason)
+		return
+	}
+
+	days := reply.Days
+	if days <= 0 {
+		days = 7
+	}
+	key := strings.ToLower(user + "|" + sender + "|" + reply.Handle)
+	now := time.Now()
+	m.mutex.Lock()
+	if until, found := m.replied[key]; found && now.Before(until) {
+		m.mutex.Unlock()
+		logger.Debug("Vacation: already replied to ", sender)
+		return
+	}
+	m.replied[key] = now.Add(time.Duration(days) * 24 * time.Hour)
+	// forget the correspondents which may get a reply again
+	if len(m.replied) > 1000 {
+		for k, until := range m.replied {
+			if now.After(until) {
+				delete(m.replied, k)
+			}
+		}
+	}
+	m.mutex.Unlock()
+
+	from := reply.From
+	if from == "" {
+		from = user
+	}
+	data := m.replyMessage(fields, from, sender, reply)
+
+	// replies are sent with the null sender, so they can't bounce back (RFC 3834 section 3.3)
+	err := queue.Send(m.config, "", sender, data)
+	if err != nil {
+		logger.Errorf("Vacation: couldn't send reply to %s: %v", sender, err)
+		return
+	}
+	logger.Info("Vacation: replied to ", sender)
+}
+
+// suppressReply returns why the message shouldn't get an automatic reply,
+// or an empty string if it may be answered
+func suppressReply(fields []string, sender string, addresses []string) string {
+	if sender == "" {
+		return "null sender"
+	}
+	local := strings.ToLower(sender)
+	if i := strings.LastIndexByte(local, '@'); i >= 0 {
+		local = local[:i]
+	}
+	switch {
+	case local == "mailer-daemon", local == "listserv", local == "majordomo",
+		local == "noreply", local == "no-reply", local == "do-not-reply",
+		strings.HasPrefix(local, "owner-"), strings.HasSuffix(local, "-request"):
+		return "sender is a list or daemon"
+	}
+
+	addressed := false
+	for _, field := range fields {
+		name, value := helpers.FieldName(field), helpers.FieldValue(field)
+		switch {
+		case strings.EqualFold(name, "Auto-Submitted") && !strings.EqualFold(value, "no"):
+			return "message is auto-submitted"
+		case strings.EqualFold(name, "Precedence"):
+			switch strings.ToLower(value) {
+			case "bulk", "list", "junk":
+				return "message is bulk mail"
+			}
+		}
+		for _, list := range listFields {
+			if strings.EqualFold(name, list) {
+				return "message is from a mailing list"
+			}
+		}
+		switch strings.ToLower(name) {
+		case "to", "cc", "bcc", "resent-to", "resent-cc":
+			list, _ := mail.ParseAddressList(value)
+			for _, a := range list {
+				for _, address := range addresses {
+					if address != "" && strings.EqualFold(a.Address, address) {
+						addressed = true
+					}
+				}
+			}
+		}
+	}
+	if !addressed {
+		return "recipient isn't in To or Cc"
+	}
+	for _, address := range addresses {
+		if strings.EqualFold(sender, address) {
+			return "message is from the recipient"
+		}
+	}
+	return ""
+}
+
+// replyMessage creates the vacation message
+func (m *Maildir) replyMessage(fields []string, from, to string, reply autoReply) []byte {
+	subject, messageId, references := "", "", ""
+	for _, field := range fields {
+		switch strings.ToLower(helpers.FieldName(field)) {
+		case "subject":
+			subject = helpers.FieldValue(field)
+		case "message-id":
+			messageId = helpers.FieldValue(field)
+		case "references":
+			references = helpers.FieldValue(field)
+		}
+	}
+	if reply.Subject != "" {
+		subject = reply.Subject
+	} else {
+		subject = m.config.Catalog.Text("vacation.subject", "subject", subject)
+	}
+	// the values end up in header fields
+	clean := strings.NewReplacer("\r", "", "\n", "")
+
+	header := fmt.Sprintf("From: <%s>\r\nTo: <%s>\r\nSubject: %s\r\nDate: %s\r\nMessage-ID: <%s@%s>\r\nAuto-Submitted: auto-replied\r\n",
+		clean.Replace(from), clean.Replace(to), clean.Replace(subject), time.Now().Format(time.RFC1123Z), helpers.NewId(), m.config.Hostname)
+	if messageId != "" {
+		header += "In-Reply-To: " + clean.Replace(messageId) + "\r\n"
+		header += "References: " + strings.TrimSpace(clean.Replace(references+" "+messageId)) + "\r\n"
+	}
+	header += "MIME-Version: 1.0\r\n"
+
+	body := strings.Replace(strings.Replace(reply.Message, "\r\n", "\n", -1), "\n", "\r\n", -1)
+	if reply.Mime {
+		// the message starts with its own header fields (e.g. Content-Type)
+		return []byte(header + body)
+	}
+	return []byte(header + "Content-Type: text/plain; charset=utf-8\r\n\r\n" + body)
+}
@@ -0,0 +1,61 @@
+package maildir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/mta"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVacation(t *testing.T) {
+
+	header := func(fields ...string) []string {
+		return append([]string{"To: Bob <bob@example.com>\r\n", "Subject: Lunch\r\n", "Message-ID: <1@example.org>\r\n"}, fields...)
+	}
+	addresses := []string{"bob+tag@example.com", "bob@example.com"}
+
+	Convey("Testing suppressReply()", t, func() {
+		So(suppressReply(header(), "alice@example.org", addresses), ShouldEqual, "")
+
+		So(suppressReply(header(), "", addresses), ShouldNotEqual, "")
+		So(suppressReply(header(), "MAILER-DAEMON@example.org", addresses), ShouldNotEqual, "")
+		So(suppressReply(header(), "owner-list@example.org", addresses), ShouldNotEqual, "")
+		So(suppressReply(header(), "list-request@example.org", addresses), ShouldNotEqual, "")
+		So(suppressReply(header(), "bob@example.com", addresses), ShouldNotEqual, "")
+
+		So(suppressReply(header("Auto-Submitted: auto-replied\r\n"), "alice@example.org", addresses), ShouldNotEqual, "")
+		So(suppressReply(header("Auto-Submitted: no\r\n"), "alice@example.org", addresses), ShouldEqual, "")
+		So(suppressReply(header("Precedence: bulk\r\n"), "alice@example.org", addresses), ShouldNotEqual, "")
+		So(suppressReply(header("List-Id: <team.example.org>\r\n"), "alice@example.org", addresses), ShouldNotEqual, "")
+
+		// not addressed to the user
+		So(suppressReply([]string{"To: carol@example.com\r\n"}, "alice@example.org", addresses), ShouldNotEqual, "")
+		So(suppressReply([]string{"To: carol@example.com\r\n", "Cc: bob@example.com\r\n"}, "alice@example.org", addresses), ShouldEqual, "")
+	})
+
+	Convey("Testing replyMessage()", t, func() {
+		m := New(&config.Config{Config: mta.Config{Hostname: "mx.example.com"}})
+		data := string(m.replyMessage(header(), "bob@example.com", "alice@example.org", autoReply{
+			AutoReply: helpers.AutoReply{Message: "I'm away.\nBack on Monday."},
+		}))
+
+		fields, body := helpers.SplitHeader([]byte(data))
+		values := map[string]string{}
+		for _, field := range fields {
+			values[helpers.FieldName(field)] = helpers.FieldValue(field)
+		}
+		So(values["From"], ShouldEqual, "<bob@example.com>")
+		So(values["To"], ShouldEqual, "<alice@example.org>")
+		So(values["Subject"], ShouldEqual, "Auto: Lunch")
+		So(values["Auto-Submitted"], ShouldEqual, "auto-replied")
+		So(values["In-Reply-To"], ShouldEqual, "<1@example.org>")
+		So(values["References"], ShouldEqual, "<1@example.org>")
+		So(strings.HasSuffix(values["Message-ID"], "@mx.example.com>"), ShouldEqual, true)
+		So(string(body), ShouldEqual, "\r\nI'm away.\r\nBack on Monday.")
+	})
+
+}
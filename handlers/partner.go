@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gopistolet/gopistolet/handlers/spf"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+/**
+ * Partners runs the spam checks for all messages, except for mail from partner domains.
+ *
+ * A message is from a partner if its MAIL FROM domain (or a parent domain) is in Domains
+ * and the client passes SPF for that domain, so the bypass can't be used with a forged sender.
+ * Business critical feeds are never scored (or delayed) this way.
+ */
+type Partners struct {
+	// Bypassed and Checked count the messages which did and didn't skip the handlers
+	// (first fields, so they're 64-bit aligned for atomic operations)
+	Bypassed uint64
+	Checked  uint64
+
+	Domains  []string
+	Handlers []Handler
+
+	// check returns the SPF result, it can be replaced for testing
+	check func(state *smtp.State) (string, error)
+}
+
+func (p *Partners) Handle(state *smtp.State) {
+	p.HandleContext(context.Background(), state)
+}
+
+func (p *Partners) HandleContext(ctx context.Context, state *smtp.State) {
+	if p.isPartner(state) {
+		bypassed := atomic.AddUint64(&p.Bypassed, 1)
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+			"From":      state.From.String(),
+			"Bypassed":  bypassed,
+			"Checked":   atomic.LoadUint64(&p.Checked),
+		}).Info("Message from partner domain, skipping spam checks")
+		return
+	}
+
+	atomic.AddUint64(&p.Checked, 1)
+	for _, handler := range p.Handlers {
+		call(ctx, handler, state)
+		if len(state.To) == 0 {
+			return
+		}
+	}
+}
+
+// isPartner reports whether the message is from a partner domain and passes SPF
+func (p *Partners) isPartner(state *smtp.State) bool {
+	if state.From == nil || !matchPartner(p.Domains, state.From.GetDomain()) {
+		return false
+	}
+
+	check := p.check
+	if check == nil {
+		check = spf.Check
+	}
+	result, err := check(state)
+	if err != nil || !strings.EqualFold(result, "pass") {
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+			"From":      state.From.String(),
+		}).Warnf("Message from partner domain failed SPF (%s, %v), not skipping spam checks", result, err)
+		return false
+	}
+	return true
+}
+
+// matchPartner reports whether the domain or one of its parent domains is in the list
+func matchPartner(domains []string, domain string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return false
+	}
+	for _, partner := range domains {
+		partner = strings.TrimSuffix(strings.ToLower(partner), ".")
+		if domain == partner || strings.HasSuffix(domain, "."+partner) {
+			return true
+		}
+	}
+	return false
+}
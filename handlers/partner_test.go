@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartners(t *testing.T) {
+
+	Convey("Testing matchPartner()", t, func() {
+		domains := []string{"partner.example", "Other.Example."}
+		So(matchPartner(domains, "partner.example"), ShouldEqual, true)
+		So(matchPartner(domains, "mail.partner.example"), ShouldEqual, true)
+		So(matchPartner(domains, "other.example"), ShouldEqual, true)
+		So(matchPartner(domains, "notpartner.example"), ShouldEqual, false)
+		So(matchPartner(domains, ""), ShouldEqual, false)
+	})
+
+	Convey("Testing Partners", t, func() {
+		spfResults := map[string]string{
+			"partner.example": "Pass",
+			"forged.example":  "Fail",
+		}
+		p := Partners{
+			Domains:  []string{"partner.example", "forged.example", "broken.example"},
+			Handlers: []Handler{&TestHandler{}},
+			check: func(state *smtp.State) (string, error) {
+				result, found := spfResults[state.From.GetDomain()]
+				if !found {
+					return "", errors.New("DNS error")
+				}
+				return result, nil
+			},
+		}
+
+		newState := func(from string) *smtp.State {
+			return &smtp.State{
+				From: &smtp.MailAddress{Address: from},
+				To:   []*smtp.MailAddress{&smtp.MailAddress{Address: "to@test.com"}},
+				Ip:   net.ParseIP("192.168.0.10"),
+			}
+		}
+
+		count = 0
+		p.Handle(newState("news@partner.example"))
+		So(count, ShouldEqual, 0)
+		So(p.Bypassed, ShouldEqual, 1)
+
+		// partner domains which don't pass SPF are checked
+		p.Handle(newState("news@forged.example"))
+		p.Handle(newState("news@broken.example"))
+		p.Handle(newState("someone@test.com"))
+		So(count, ShouldEqual, 3)
+		So(p.Bypassed, ShouldEqual, 1)
+		So(p.Checked, ShouldEqual, 3)
+	})
+
+}
@@ -0,0 +1,92 @@
+package plugins
+
+import (
+	"strings"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/gopistolet/plugin"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *Plugins {
+	return &Plugins{
+		config: c,
+	}
+}
+
+// Plugins hands the messages to the plugins which registered for the data phase.
+// They can drop a message, add header fields to it or replace its recipients.
+type Plugins struct {
+	config *config.Config
+}
+
+func (handler *Plugins) Handle(state *smtp.State) {
+	if len(handler.config.Plugins) == 0 || len(state.To) == 0 {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	for _, p := range handler.config.Plugins {
+		reply := p.Call(plugin.PhaseData, plugin.Request{Message: message(state)})
+		if reply == nil {
+			continue
+		}
+
+		switch reply.Action {
+		case "", plugin.ActionContinue:
+		case plugin.ActionReject, plugin.ActionDrop:
+			logger.Warnf("Plugin %s dropped the message: %s", p.Name, reply.Message)
+			state.To = nil
+			return
+		default:
+			logger.Warnf("Plugin %s replied with unknown action %q", p.Name, reply.Action)
+		}
+
+		if len(reply.AddHeaders) > 0 {
+			fields := ""
+			for _, field := range reply.AddHeaders {
+				fields += strings.TrimRight(field, "\r\n") + "\r\n"
+			}
+			state.Data = append([]byte(fields), state.Data...)
+		}
+
+		if reply.Recipients != nil {
+			to := []*smtp.MailAddress{}
+			for _, recipient := range reply.Recipients {
+				address, err := smtp.ParseAddress(recipient)
+				if err != nil {
+					logger.Warnf("Plugin %s returned invalid recipient %q", p.Name, recipient)
+					continue
+				}
+				to = append(to, &address)
+			}
+			state.To = to
+			if len(to) == 0 {
+				logger.Infof("Plugin %s removed all recipients", p.Name)
+				return
+			}
+		}
+	}
+}
+
+// message is the message of the state, as it's sent to the plugins
+func message(state *smtp.State) *plugin.Message {
+	m := &plugin.Message{
+		SessionId: state.SessionId.String(),
+		Ip:        state.Ip.String(),
+		Helo:      state.Hostname,
+		Data:      state.Data,
+	}
+	if state.From != nil {
+		m.From = state.From.Address
+	}
+	for _, recipient := range state.To {
+		m.To = append(m.To, recipient.Address)
+	}
+	return m
+}
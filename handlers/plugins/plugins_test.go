@@ -0,0 +1,66 @@
+package plugins
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/plugin"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// script is a plugin which tags the messages and sends them to bob, except the ones with DROP in them
+const script = `#!/bin/sh
+while read -r line; do
+	id=$(echo "$line" | sed -n 's/.*"Id":\([0-9]*\).*/\1/p')
+	case "$line" in
+	*'"Type":"hello"'*) echo "{\"Id\":$id,\"Phases\":[\"data\"]}" ;;
+	*RFJPU*) echo "{\"Id\":$id,\"Action\":\"drop\"}" ;;
+	*) echo "{\"Id\":$id,\"AddHeaders\":[\"X-Plugin: yes\"],\"Recipients\":[\"bob@example.com\"]}" ;;
+	esac
+done
+`
+
+func TestPlugins(t *testing.T) {
+
+	Convey("Testing plugins handler", t, func() {
+		dir, err := ioutil.TempDir("", "plugin")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "plugin.sh")
+		So(ioutil.WriteFile(path, []byte(script), 0755), ShouldEqual, nil)
+
+		c := &config.Config{}
+		p := &plugin.Plugin{Name: "test", Command: []string{path}, Timeout: 2}
+		So(p.Start("mx.example.com"), ShouldEqual, nil)
+		defer p.Stop()
+		c.Plugins = plugin.Plugins{p}
+
+		newState := func(data string) *smtp.State {
+			return &smtp.State{
+				Ip:   net.ParseIP("192.0.2.1"),
+				From: &smtp.MailAddress{Address: "alice@example.com"},
+				To:   []*smtp.MailAddress{{Address: "carol@example.com"}},
+				Data: []byte(data),
+			}
+		}
+
+		state := newState("Subject: hi\r\n\r\nhi\r\n")
+		New(c).Handle(state)
+		So(string(state.Data), ShouldEqual, "X-Plugin: yes\r\nSubject: hi\r\n\r\nhi\r\n")
+		So(len(state.To), ShouldEqual, 1)
+		So(state.To[0].Address, ShouldEqual, "bob@example.com")
+
+		// "DROP" is RFJPU in base64
+		state = newState("DROP")
+		New(c).Handle(state)
+		So(state.To, ShouldBeEmpty)
+		So(string(state.Data), ShouldEqual, "DROP")
+	})
+
+}
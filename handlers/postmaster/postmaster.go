@@ -0,0 +1,47 @@
+package postmaster
+
+import (
+	"strings"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *Postmaster {
+	return &Postmaster{
+		config: c,
+	}
+}
+
+// Postmaster routes the mail for <postmaster> and postmaster@<local domain> to the Postmaster mailbox
+// of the config. Without Postmaster mailbox, <postmaster> is delivered to postmaster@<hostname>
+// and the postmaster of a local domain to that domain.
+type Postmaster struct {
+	config *config.Config
+}
+
+func (handler *Postmaster) Handle(state *smtp.State) {
+	for i, recipient := range state.To {
+		if !handler.config.LocalDomains.IsPostmaster(recipient.Address) {
+			continue
+		}
+
+		target := handler.config.Postmaster
+		if target == "" {
+			if strings.Contains(recipient.Address, "@") {
+				continue
+			}
+			target = "postmaster@" + handler.config.Hostname
+		}
+		if strings.EqualFold(target, recipient.Address) {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+		}).Infof("Postmaster: routed %s to %s", recipient.Address, target)
+		state.To[i] = &smtp.MailAddress{Address: target}
+	}
+}
@@ -0,0 +1,52 @@
+package postmaster
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/mta"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPostmaster(t *testing.T) {
+
+	Convey("Testing Postmaster handler", t, func() {
+		c := &config.Config{
+			Config: mta.Config{Hostname: "mx.example.com"},
+			LocalDomains: helpers.LocalDomains{
+				"example.com": {},
+			},
+		}
+		h := New(c)
+
+		newState := func(to ...string) *smtp.State {
+			state := &smtp.State{Ip: net.ParseIP("192.0.2.1")}
+			for _, address := range to {
+				state.To = append(state.To, &smtp.MailAddress{Address: address})
+			}
+			return state
+		}
+		addresses := func(state *smtp.State) []string {
+			list := []string{}
+			for _, to := range state.To {
+				list = append(list, to.Address)
+			}
+			return list
+		}
+
+		// without Postmaster mailbox
+		state := newState("Postmaster", "POSTMASTER@Example.com", "postmaster@remote.example")
+		h.Handle(state)
+		So(addresses(state), ShouldResemble, []string{"postmaster@mx.example.com", "POSTMASTER@Example.com", "postmaster@remote.example"})
+
+		c.Postmaster = "admin@example.com"
+		state = newState("postmaster", "PostMaster@example.com", "bob@example.com")
+		h.Handle(state)
+		So(addresses(state), ShouldResemble, []string{"admin@example.com", "admin@example.com", "bob@example.com"})
+	})
+
+}
@@ -0,0 +1,734 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/client"
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/events"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/gopistolet/script"
+	"github.com/gopistolet/gopistolet/spool"
+	"github.com/gopistolet/smtp/smtp"
+	"go.starlark.net/starlark"
+)
+
+// probeTimeout is the timeout for dialing the probe address
+const probeTimeout = 5 * time.Second
+
+// maxDnsRetries is the number of times a message which isn't queued is retried while the resolver is down
+const maxDnsRetries = 10
+
+func NewForward(c *config.Config) *Forward {
+	sender := newSender(c)
+	return &Forward{
+		config:        c,
+		send:          sender.Send,
+		deliver:       sender.Deliver,
+		deliverDomain: sender.DeliverDomain,
+		probe: func(addr string) bool {
+			conn, err := net.DialTimeout("tcp", addr, probeTimeout)
+			if err != nil {
+				return false
+			}
+			conn.Close()
+			return true
+		},
+		now: time.Now,
+	}
+}
+
+// newSender returns the sender of the outbound connections, with the policies of the config
+func newSender(c *config.Config) *client.Sender {
+	sender := &client.Sender{
+		Sources: &c.SourceIps,
+		Sts:     &c.Outbound.MtaSts,
+		Dane:    &c.Outbound.Dane,
+		Breaker: &c.Outbound.Breaker,
+		Dns:     &c.DnsHealth,
+	}
+	if c.Outbound.TlsRpt.Organization != "" {
+		sender.TlsRpt = &c.Outbound.TlsRpt.Collector
+	}
+	return sender
+}
+
+// Forward queues mail for remote recipients in the spool directory
+// and relays it to the smarthost when the link is up (see config.Forward)
+type Forward struct {
+	config *config.Config
+
+	// send, deliver, deliverDomain, probe and now can be replaced for testing
+	send          func(addr, helo, from string, to []string, data []byte) client.Results
+	deliver       func(helo, from, to string, data []byte) error
+	deliverDomain func(helo, from string, to []string, data []byte) client.Results
+	probe         func(addr string) bool
+	now           func() time.Time
+
+	mutex   sync.Mutex
+	alarmed bool
+	stop    chan struct{}
+	// the deliveries to MX hosts are paused until dnsPause while the resolver is down
+	dnsPause time.Time
+}
+
+// Store returns the store of the queue, which has to be opened with config.Queue.Open
+func Store(c *config.Config) (spool.Store, error) {
+	if c.Queue.Store == nil {
+		return nil, errors.New("the queue isn't opened")
+	}
+	return c.Queue.Store, nil
+}
+
+// Enqueue saves the message in the store of the queue, so it's relayed to the smarthost.
+// It returns the id of the message in the queue.
+func Enqueue(c *config.Config, state *smtp.State, class string) (string, error) {
+	store, err := Store(c)
+	if err != nil {
+		return "", err
+	}
+	sealed := *state
+	if sealed.Data, err = c.Encryption.Seal(state.Data); err != nil {
+		return "", err
+	}
+	return store.Add(&sealed, class)
+}
+
+// Send sends a message generated by GoPistolet (e.g. a vacation message or a bounce),
+// through the store-and-forward queue if there's a smarthost, or directly to the MX hosts otherwise
+func Send(c *config.Config, from, to string, data []byte) error {
+	if c.Forward.Smarthost != "" {
+		state := &smtp.State{
+			To:   []*smtp.MailAddress{{Address: to}},
+			Data: data,
+		}
+		if from != "" {
+			state.From = &smtp.MailAddress{Address: from}
+		}
+		_, err := Enqueue(c, state, c.Forward.Priorities.Class(from, data))
+		return err
+	}
+
+	// don't hold up the delivery of the message
+	go func() {
+		sender := newSender(c)
+		err := sender.Deliver(c.Hostname, from, to, data)
+		// while the resolver is down, the delivery is retried with its backoff
+		for retries := 0; err != nil && retries < maxDnsRetries && c.DnsHealth.Down(); retries++ {
+			time.Sleep(c.DnsHealth.Backoff())
+			err = sender.Deliver(c.Hostname, from, to, data)
+		}
+		if err != nil {
+			log.Errorf("Couldn't deliver message to %s: %v", to, err)
+		}
+	}()
+	return nil
+}
+
+// Handle queues the message for the remote recipients and removes them from the state,
+// so they aren't delivered locally
+func (f *Forward) Handle(state *smtp.State) {
+	if f.config.Forward.Smarthost == "" || !f.config.MayRelay(state) {
+		return
+	}
+
+	local := []*smtp.MailAddress{}
+	remote := []*smtp.MailAddress{}
+	for _, to := range state.To {
+		if f.config.LocalDomains.IsLocal(to.Address) {
+			local = append(local, to)
+		} else {
+			remote = append(remote, to)
+		}
+	}
+	if len(remote) == 0 {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	queued := *state
+	queued.To = remote
+	if f.config.Srs.Enabled() && queued.From != nil && !f.config.LocalDomains.IsLocal(queued.From.Address) {
+		// the sender's SPF record doesn't allow our servers, so forward with an SRS address
+		queued.From = &smtp.MailAddress{Address: f.config.Srs.Forward(queued.From.Address)}
+	}
+	from := ""
+	if queued.From != nil {
+		from = queued.From.Address
+	}
+	class := f.config.Forward.Priorities.Class(from, queued.Data)
+	id, err := Enqueue(f.config, &queued, class)
+	if err != nil {
+		// the client gets a temporary failure and retries the whole message later
+		logger.Errorf("Forward: couldn't queue message, it's deferred: %v", err)
+		f.config.Acceptance.Fail(state.SessionId.String(), err)
+		state.To = nil
+		return
+	}
+	logger.Infof("Forward: queued %s message for %d remote recipients: %s", class, len(remote), id)
+	// a later handler may fail the transaction, the client retries it for the remote recipients too
+	f.config.Acceptance.Undo(state.SessionId.String(), func() {
+		store, err := Store(f.config)
+		if err == nil {
+			err = store.Remove(id)
+		}
+		if err != nil {
+			logger.Errorf("Forward: couldn't remove %s of the failed message: %v", id, err)
+			return
+		}
+		logger.Infof("Forward: removed %s, the client retries the message", id)
+	})
+	queuedEvent := events.MessageQueued{SessionId: state.SessionId.String(), File: id, From: from}
+	outcomes := []helpers.DeliveryOutcome{}
+	for _, to := range remote {
+		queuedEvent.To = append(queuedEvent.To, to.Address)
+		outcomes = append(outcomes, helpers.DeliveryOutcome{Recipient: to.Address, Outcome: helpers.DeliveryQueued})
+	}
+	f.config.Events.Publish(queuedEvent)
+	f.recordStatus(&queued, outcomes, true)
+
+	state.To = local
+}
+
+// Connected reports whether the link to the smarthost is up:
+// during one of the windows, or if the probe address can be reached
+func (f *Forward) Connected() bool {
+	windows := f.config.Forward.Windows
+	if len(windows) == 0 && f.config.Forward.Probe == "" {
+		return true
+	}
+	for _, window := range windows {
+		in, err := inWindow(window, f.now())
+		if err != nil {
+			log.Warnf("Forward: %v", err)
+			continue
+		}
+		if in {
+			return true
+		}
+	}
+	return f.config.Forward.Probe != "" && f.probe(f.config.Forward.Probe)
+}
+
+// inWindow reports whether the time of day is in the window (e.g. "08:00-12:30"),
+// windows which end before they start wrap around midnight
+func inWindow(window string, now time.Time) (bool, error) {
+	var startHour, startMinute, endHour, endMinute int
+	_, err := fmt.Sscanf(strings.TrimSpace(window), "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute)
+	if err != nil || startHour > 23 || endHour > 24 || startMinute > 59 || endMinute > 59 {
+		return false, fmt.Errorf("invalid window %q", window)
+	}
+	start := startHour*60 + startMinute
+	end := endHour*60 + endMinute
+	minute := now.Hour()*60 + now.Minute()
+	if start <= end {
+		return minute >= start && minute < end, nil
+	}
+	return minute >= start || minute < end, nil
+}
+
+// Flush relays the queued messages to the smarthost with Workers parallel workers (default 1).
+// The recipients of a message are relayed per domain, with at most DomainConcurrency deliveries
+// per domain at a time, so one slow domain can't hold up the others.
+// A domain which is deferred (4xx) is skipped for the rest of the flush, and so are the domains
+// relayed to a host which can't be reached. The deliveries go through the sender's circuit breaker,
+// and those to MX hosts through the DNS backoff, MTA-STS and DANE (see client.Sender).
+// Recipients which are rejected permanently (5xx) are moved aside (see spool.Store.Fail).
+// The messages are relayed by priority class, high first and bulk last,
+// at most Priorities.BulkPerFlush bulk messages are relayed per flush.
+func (f *Forward) Flush() {
+	store, err := Store(f.config)
+	if err != nil {
+		log.Warnf("Forward: %v", err)
+		return
+	}
+	entries, err := store.List()
+	if err != nil {
+		log.Warnf("Forward: couldn't read the queue: %v", err)
+		return
+	}
+
+	workers := f.config.Forward.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	flush := &flush{
+		deferred: make(map[string]bool),
+		down:     make(map[string]bool),
+		slots:    make(map[string]chan struct{}),
+	}
+
+	ids := make(chan string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				f.relay(flush, store, id)
+			}
+		}()
+	}
+	// the entries are sorted by class, high first and bulk last
+	bulk := 0
+	for _, entry := range entries {
+		if entry.Class == helpers.PriorityBulk {
+			bulk++
+			if limit := f.config.Forward.Priorities.BulkPerFlush; limit > 0 && bulk > limit {
+				break
+			}
+		}
+		ids <- entry.Id
+	}
+	close(ids)
+	wg.Wait()
+}
+
+// flush is the state of a Flush which is shared by its workers
+type flush struct {
+	mutex sync.Mutex
+	// deferred contains the domains which got a temporary failure
+	deferred map[string]bool
+	// down contains the destination hosts which couldn't be reached
+	down map[string]bool
+	// slots limits the concurrent deliveries per domain
+	slots map[string]chan struct{}
+}
+
+func (fl *flush) isDeferred(domain, destination string) bool {
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+	return fl.deferred[domain] || fl.down[destination]
+}
+
+func (fl *flush) deferDomain(domain string) {
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+	fl.deferred[domain] = true
+}
+
+func (fl *flush) hostDown(destination string) {
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+	fl.down[destination] = true
+}
+
+// acquire waits for a delivery slot for the domain, limit 0 means unlimited
+func (fl *flush) acquire(domain string, limit int) chan struct{} {
+	if limit <= 0 {
+		return nil
+	}
+	fl.mutex.Lock()
+	slot, found := fl.slots[domain]
+	if !found {
+		slot = make(chan struct{}, limit)
+		fl.slots[domain] = slot
+	}
+	fl.mutex.Unlock()
+	slot <- struct{}{}
+	return slot
+}
+
+func release(slot chan struct{}) {
+	if slot != nil {
+		<-slot
+	}
+}
+
+// relay relays the queued message with the id to the smarthost, per recipient domain.
+// A message of a shared queue is skipped while another instance holds its lease.
+func (f *Forward) relay(fl *flush, store spool.Store, id string) {
+	if leaser, ok := store.(spool.Leaser); ok {
+		timeout := time.Duration(f.config.Queue.LeaseTimeout) * time.Second
+		if timeout <= 0 {
+			timeout = 10 * time.Minute
+		}
+		leased, err := leaser.Lease(id, timeout)
+		if err != nil {
+			log.Warnf("Forward: couldn't lease %s: %v", id, err)
+			return
+		}
+		if !leased {
+			// another instance is relaying it
+			return
+		}
+	}
+
+	// stored is kept as it is in the store, the message is only decrypted to be relayed
+	stored := smtp.State{}
+	if err := store.Read(id, &stored); err != nil {
+		log.Warnf("Forward: couldn't read %s: %v", id, err)
+		return
+	}
+	state := stored
+	var err error
+	if state.Data, err = f.config.Encryption.Open(stored.Data); err != nil {
+		log.Errorf("Forward: couldn't decrypt %s: %v", id, err)
+		return
+	}
+
+	from := ""
+	if state.From != nil {
+		from = state.From.Address
+	}
+	domains := []string{}
+	recipients := make(map[string][]string)
+	for _, rcpt := range state.To {
+		domain := strings.ToLower(rcpt.GetDomain())
+		if _, seen := recipients[domain]; !seen {
+			domains = append(domains, domain)
+		}
+		recipients[domain] = append(recipients[domain], rcpt.Address)
+	}
+
+	logger := log.WithFields(log.Fields{
+		"SessionId": state.SessionId.String(),
+		"Smarthost": f.config.Forward.Smarthost,
+	})
+
+	relayed, failed, remaining := []string{}, []client.Result{}, []string{}
+	outcomes := []helpers.DeliveryOutcome{}
+	// reason is the error of the last deferred attempt
+	reason := ""
+	for _, domain := range domains {
+		to := recipients[domain]
+		destination := f.route(&state, domain, to)
+		if fl.isDeferred(domain, destination) || (destination == helpers.TransportMx && f.dnsPaused()) {
+			remaining = append(remaining, to...)
+			continue
+		}
+		slot := fl.acquire(domain, f.config.Forward.DomainConcurrency)
+		var results client.Results
+		if destination == helpers.TransportMx {
+			results = f.deliverDomain(f.config.Hostname, from, to, state.Data)
+			f.checkDns()
+		} else {
+			results = f.send(destination, f.config.Hostname, from, to, state.Data)
+		}
+		release(slot)
+
+		// the recipients the server refused fail on their own, the others still get the message
+		delivered := []string{}
+		for _, result := range results {
+			err := result.Err
+			_, isProtoErr := err.(*textproto.Error)
+			outcome := helpers.DeliveryOutcome{Recipient: result.Recipient, Outcome: helpers.DeliveryDeferred}
+			if err != nil {
+				outcome.Detail = err.Error()
+			}
+			switch {
+			case err == nil:
+				delivered = append(delivered, result.Recipient)
+				outcomes = append(outcomes, helpers.DeliveryOutcome{Recipient: result.Recipient, Outcome: helpers.DeliveryDelivered})
+				continue
+			case client.Permanent(err):
+				logger.Errorf("Forward: recipient %s of %s rejected: %v", result.Recipient, id, err)
+				failed = append(failed, result)
+				outcome.Outcome = helpers.DeliveryFailed
+			case isProtoErr || destination == helpers.TransportMx:
+				// the MX hosts of one domain being unreachable doesn't stop the flush
+				logger.Warnf("Forward: recipient %s of %s deferred, retrying later: %v", result.Recipient, id, err)
+				fl.deferDomain(domain)
+				remaining = append(remaining, result.Recipient)
+				reason = err.Error()
+			default:
+				logger.Warnf("Forward: couldn't relay %s to %s for %s, retrying later: %v", id, destination, result.Recipient, err)
+				fl.hostDown(destination)
+				remaining = append(remaining, result.Recipient)
+				reason = err.Error()
+			}
+			outcomes = append(outcomes, outcome)
+			f.config.Events.Publish(events.DeliveryFailed{
+				SessionId:   state.SessionId.String(),
+				Recipients:  []string{result.Recipient},
+				Destination: destination,
+				Error:       err.Error(),
+				Permanent:   client.Permanent(err),
+			})
+		}
+		if len(delivered) > 0 {
+			relayed = append(relayed, delivered...)
+			f.config.Events.Publish(events.MessageDelivered{SessionId: state.SessionId.String(), Recipients: delivered, Destination: destination})
+		}
+	}
+
+	f.recordStatus(&state, outcomes, false)
+
+	if len(failed) > 0 {
+		rejected := make([]string, len(failed))
+		for i, result := range failed {
+			rejected[i] = result.Recipient
+		}
+		if err := store.Fail(id, withRecipients(&stored, rejected)); err != nil {
+			logger.Errorf("Forward: couldn't save rejected recipients of %s: %v", id, err)
+		}
+		f.notifyFailed(&state, failed)
+	}
+	if len(remaining) > 0 {
+		// the message is only rewritten when recipients are done
+		var retried interface{}
+		if len(remaining) < len(state.To) {
+			retried = withRecipients(&stored, remaining)
+		}
+		if retried != nil || reason != "" {
+			if err := store.Retry(id, retried, reason); err != nil {
+				logger.Errorf("Forward: couldn't update %s: %v", id, err)
+			}
+		}
+	} else {
+		if len(relayed) > 0 {
+			logger.Infof("Forward: relayed %s", id)
+		}
+		if err := store.Remove(id); err != nil {
+			logger.Errorf("Forward: couldn't remove %s: %v", id, err)
+		}
+	}
+	f.notifyRelayed(&state, relayed)
+}
+
+// recordStatus appends the outcomes to the delivery status of the message (see helpers.DeliveryStatus).
+// Only the messages of clients which may relay are tracked: they're recorded when they're queued,
+// the outcomes of the relay attempts are recorded for the tracked messages.
+func (f *Forward) recordStatus(state *smtp.State, outcomes []helpers.DeliveryOutcome, queued bool) {
+	status := &f.config.DeliveryStatus
+	if status.Dir == "" || state.From == nil || len(outcomes) == 0 {
+		return
+	}
+	messageId := helpers.MessageId(state.Data)
+	if !queued && !status.Tracked(state.From.Address, messageId) {
+		return
+	}
+	for _, outcome := range outcomes {
+		if err := status.Record(state.From.Address, messageId, outcome); err != nil {
+			log.WithFields(log.Fields{
+				"SessionId": state.SessionId.String(),
+			}).Errorf("Forward: couldn't record delivery status: %v", err)
+			return
+		}
+	}
+}
+
+// dnsPaused reports whether the deliveries to MX hosts are paused because the resolver is down
+func (f *Forward) dnsPaused() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now().Before(f.dnsPause)
+}
+
+// checkDns pauses the deliveries to MX hosts for the backoff of the resolver while it's down,
+// so the MX lookups are retried later instead of failing one message after the other
+func (f *Forward) checkDns() {
+	backoff := f.config.DnsHealth.Backoff()
+	if backoff <= 0 {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if pause := f.now().Add(backoff); pause.After(f.dnsPause) {
+		log.Warnf("Forward: the DNS resolver is down, the deliveries to MX hosts are paused for %v", backoff)
+		f.dnsPause = pause
+	}
+}
+
+// route returns the host to which the recipients of the domain are relayed:
+// the one the route script picks with route("host:port"), the one in the transports, or the smarthost
+func (f *Forward) route(state *smtp.State, domain string, to []string) string {
+	fallback := f.config.Forward.Smarthost
+	if host, found := f.config.Forward.Transports.Lookup(domain); found {
+		fallback = host
+	}
+	if !f.config.Scripts.Enabled(script.HookRoute) {
+		return fallback
+	}
+	destination := fallback
+
+	message := &script.Message{
+		SessionId: state.SessionId.String(),
+		Ip:        state.Ip.String(),
+		Helo:      state.Hostname,
+		To:        to,
+		Data:      state.Data,
+	}
+	if state.From != nil {
+		message.From = state.From.Address
+	}
+	err := f.config.Scripts.Run(script.HookRoute, message, starlark.StringDict{
+		"domain": starlark.String(domain),
+		"route": starlark.NewBuiltin("route", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var host string
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &host); err != nil {
+				return nil, err
+			}
+			if _, _, err := net.SplitHostPort(host); err != nil && host != helpers.TransportMx {
+				return nil, fmt.Errorf("route %q should be host:port or mx", host)
+			}
+			destination = host
+			return starlark.None, nil
+		}),
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"SessionId": state.SessionId.String(),
+		}).Errorf("Forward: route script failed, relaying to %s: %v", fallback, err)
+		return fallback
+	}
+	return destination
+}
+
+// withRecipients returns a copy of the state with the recipients
+func withRecipients(state *smtp.State, recipients []string) *smtp.State {
+	c := *state
+	c.To = make([]*smtp.MailAddress, len(recipients))
+	for i, recipient := range recipients {
+		c.To[i] = &smtp.MailAddress{Address: recipient}
+	}
+	return &c
+}
+
+// notifyRelayed sends a delivery status notification to the sender
+// for the relayed recipients which asked for it with NOTIFY=SUCCESS
+func (f *Forward) notifyRelayed(state *smtp.State, relayed []string) {
+	if state.From == nil || state.From.Address == "" {
+		return
+	}
+
+	recipients := []helpers.DsnRecipient{}
+	for _, recipient := range relayed {
+		request, found := f.config.Dsn.Get(state.SessionId.String(), recipient)
+		if !found {
+			continue
+		}
+		f.config.Dsn.Forget(state.SessionId.String(), recipient)
+		if request.Wants("SUCCESS") {
+			recipients = append(recipients, helpers.DsnRecipient{Recipient: recipient, Action: helpers.DsnRelayed, Request: request})
+		}
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	dsn := helpers.NewSuccessDsn(&f.config.Catalog, f.config.Hostname, state.From.Address, recipients, state.Data)
+	if err := f.deliver(f.config.Hostname, "", state.From.Address, dsn); err != nil {
+		log.WithFields(log.Fields{
+			"SessionId": state.SessionId.String(),
+		}).Errorf("Forward: couldn't send delivery status notification: %v", err)
+	}
+}
+
+// notifyFailed sends a delivery status notification to the sender for the recipients
+// which were rejected, unless they asked not to be notified of failures
+func (f *Forward) notifyFailed(state *smtp.State, failed []client.Result) {
+	if state.From == nil || state.From.Address == "" {
+		return
+	}
+
+	recipients := []helpers.DsnRecipient{}
+	for _, result := range failed {
+		request, _ := f.config.Dsn.Get(state.SessionId.String(), result.Recipient)
+		f.config.Dsn.Forget(state.SessionId.String(), result.Recipient)
+		if !request.WantsFailure() {
+			continue
+		}
+		status := client.EnhancedCode(result.Err)
+		if status == "" {
+			status = "5.0.0"
+		}
+		recipients = append(recipients, helpers.DsnRecipient{
+			Recipient:  result.Recipient,
+			Action:     helpers.DsnFailed,
+			Request:    request,
+			Status:     status,
+			Diagnostic: result.Err.Error(),
+		})
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	dsn := helpers.NewFailureDsn(&f.config.Catalog, f.config.Hostname, state.From.Address, recipients, state.Data)
+	if err := f.deliver(f.config.Hostname, "", state.From.Address, dsn); err != nil {
+		log.WithFields(log.Fields{
+			"SessionId": state.SessionId.String(),
+		}).Errorf("Forward: couldn't send delivery status notification: %v", err)
+	}
+}
+
+// checkAlarm logs a warning when the number of queued messages exceeds AlarmMessages,
+// and when it's back below
+func (f *Forward) checkAlarm() {
+	limit := f.config.Forward.AlarmMessages
+	if limit <= 0 {
+		return
+	}
+	store, err := Store(f.config)
+	if err != nil {
+		return
+	}
+	snapshot, err := TakeSnapshot(store)
+	if err != nil {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if snapshot.Messages > limit && !f.alarmed {
+		log.Warnf("Forward: %d messages queued (alarm at %d), oldest is %.0f seconds old", snapshot.Messages, limit, snapshot.Oldest)
+		f.alarmed = true
+	} else if snapshot.Messages <= limit && f.alarmed {
+		log.Printf("Forward: %d messages queued, below the alarm again", snapshot.Messages)
+		f.alarmed = false
+	}
+}
+
+// Start flushes the queue every Interval seconds while the link is up, until Stop is called
+func (f *Forward) Start() {
+	if f.config.Forward.Smarthost == "" {
+		return
+	}
+	interval := time.Duration(f.config.Forward.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	f.mutex.Lock()
+	f.stop = make(chan struct{})
+	stop := f.stop
+	f.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if f.Connected() {
+				f.Flush()
+			}
+			f.checkAlarm()
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops flushing the queue
+func (f *Forward) Stop() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+	}
+}
@@ -0,0 +1,692 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gopistolet/gopistolet/client"
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/mta"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestForward(t *testing.T) {
+
+	Convey("Testing inWindow()", t, func() {
+		at := func(hour, minute int) time.Time {
+			return time.Date(2016, 10, 5, hour, minute, 0, 0, time.Local)
+		}
+		in, err := inWindow("08:00-12:30", at(10, 0))
+		So(err, ShouldEqual, nil)
+		So(in, ShouldEqual, true)
+		in, _ = inWindow("08:00-12:30", at(12, 30))
+		So(in, ShouldEqual, false)
+		in, _ = inWindow("22:00-06:00", at(23, 0))
+		So(in, ShouldEqual, true)
+		in, _ = inWindow("22:00-06:00", at(5, 59))
+		So(in, ShouldEqual, true)
+		in, _ = inWindow("22:00-06:00", at(12, 0))
+		So(in, ShouldEqual, false)
+		_, err = inWindow("whenever", at(12, 0))
+		So(err, ShouldNotEqual, nil)
+	})
+
+	Convey("Testing Forward", t, func() {
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{
+			Config:       mta.Config{Hostname: "satellite.example.com"},
+			LocalDomains: helpers.LocalDomains{"example.com": {CatchAll: "catchall"}},
+			Queue:        config.Queue{Dir: dir},
+			Forward: config.Forward{
+				Smarthost: "smarthost.example.net:25",
+				Windows:   []string{"22:00-06:00"},
+				Probe:     "smarthost.example.net:25",
+			},
+		}
+		So(json.Unmarshal([]byte(`{"Relay": ["192.168.0.0/24"]}`), &c.Access), ShouldEqual, nil)
+
+		So(c.Queue.Open(), ShouldEqual, nil)
+		f := NewForward(c)
+		reachable := false
+		f.probe = func(addr string) bool { return reachable }
+		f.now = func() time.Time { return time.Date(2016, 10, 5, 12, 0, 0, 0, time.Local) }
+		sent := [][]string{}
+		var sendErr error
+		f.send = func(addr, helo, from string, to []string, data []byte) client.Results {
+			if sendErr != nil {
+				return results(to, sendErr)
+			}
+			sent = append(sent, to)
+			return results(to, nil)
+		}
+		notifications := map[string]string{}
+		f.deliver = func(helo, from, to string, data []byte) error {
+			notifications[to] = string(data)
+			return nil
+		}
+
+		state := &smtp.State{
+			From: &smtp.MailAddress{Address: "from@example.com"},
+			To: []*smtp.MailAddress{
+				{Address: "local@example.com"},
+				{Address: "remote@example.org"},
+			},
+			Data: []byte("Hello world!"),
+			Ip:   net.ParseIP("192.168.0.10"),
+		}
+
+		// only mail from clients which may relay is forwarded
+		state.Ip = net.ParseIP("10.0.0.1")
+		f.Handle(state)
+		So(len(state.To), ShouldEqual, 2)
+
+		state.Ip = net.ParseIP("192.168.0.10")
+		c.Dsn.Set(state.SessionId.String(), "remote@example.org", nil, map[string]string{"NOTIFY": "SUCCESS,FAILURE"})
+		f.Handle(state)
+		So(len(state.To), ShouldEqual, 1)
+		So(state.To[0].Address, ShouldEqual, "local@example.com")
+
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		So(len(files), ShouldEqual, 1)
+
+		// the link is down outside the window, unless the probe succeeds
+		So(f.Connected(), ShouldEqual, false)
+		reachable = true
+		So(f.Connected(), ShouldEqual, true)
+		f.now = func() time.Time { return time.Date(2016, 10, 5, 23, 0, 0, 0, time.Local) }
+		reachable = false
+		So(f.Connected(), ShouldEqual, true)
+
+		// temporary failures keep the message queued
+		sendErr = errors.New("connection refused")
+		f.Flush()
+		files, _ = filepath.Glob(filepath.Join(dir, "*.json"))
+		So(len(files), ShouldEqual, 1)
+
+		sendErr = nil
+		f.Flush()
+		So(sent, ShouldResemble, [][]string{{"remote@example.org"}})
+		files, _ = filepath.Glob(filepath.Join(dir, "*.json"))
+		So(len(files), ShouldEqual, 0)
+
+		// the sender asked for a success notification
+		So(notifications["from@example.com"], ShouldContainSubstring, "Final-Recipient: rfc822; remote@example.org\r\nAction: relayed\r\n")
+
+		// permanent failures are moved aside
+		state.To = append(state.To, &smtp.MailAddress{Address: "nobody@example.org"})
+		f.Handle(state)
+		sendErr = &textproto.Error{Code: 550, Msg: "no such user"}
+		f.Flush()
+		files, _ = filepath.Glob(filepath.Join(dir, "*.json.failed"))
+		So(len(files), ShouldEqual, 1)
+
+		// queue size alarm
+		c.Forward.AlarmMessages = 1
+		f.Handle(&smtp.State{To: []*smtp.MailAddress{{Address: "a@example.org"}}, Ip: net.ParseIP("192.168.0.10")})
+		f.Handle(&smtp.State{To: []*smtp.MailAddress{{Address: "b@example.org"}}, Ip: net.ParseIP("192.168.0.10")})
+		f.checkAlarm()
+		So(f.alarmed, ShouldEqual, true)
+		sendErr = nil
+		f.Flush()
+		f.checkAlarm()
+		So(f.alarmed, ShouldEqual, false)
+	})
+
+	Convey("Testing Forward with SRS", t, func() {
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{
+			LocalDomains: helpers.LocalDomains{"example.com": {}},
+			Queue:        config.Queue{Dir: dir},
+			Forward:      config.Forward{Smarthost: "smarthost.example.net:25"},
+			Srs:          helpers.Srs{Domain: "example.com", Secrets: []string{"secret"}},
+		}
+		So(json.Unmarshal([]byte(`{"Relay": ["192.168.0.0/24"]}`), &c.Access), ShouldEqual, nil)
+		So(c.Queue.Open(), ShouldEqual, nil)
+		f := NewForward(c)
+
+		queued := func() *smtp.State {
+			files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+			So(len(files), ShouldEqual, 1)
+			state := &smtp.State{}
+			So(helpers.DecodeFile(files[0], state), ShouldEqual, nil)
+			os.Remove(files[0])
+			return state
+		}
+
+		// remote senders are rewritten, local senders aren't
+		f.Handle(&smtp.State{
+			From: &smtp.MailAddress{Address: "bob@example.org"},
+			To:   []*smtp.MailAddress{{Address: "remote@example.net"}},
+			Ip:   net.ParseIP("192.168.0.10"),
+		})
+		from := queued().From.Address
+		So(from, ShouldStartWith, "SRS0=")
+		sender, err := c.Srs.Reverse(from)
+		So(err, ShouldEqual, nil)
+		So(sender, ShouldEqual, "bob@example.org")
+
+		f.Handle(&smtp.State{
+			From: &smtp.MailAddress{Address: "alice@example.com"},
+			To:   []*smtp.MailAddress{{Address: "remote@example.net"}},
+			Ip:   net.ParseIP("192.168.0.10"),
+		})
+		So(queued().From.Address, ShouldEqual, "alice@example.com")
+	})
+
+	Convey("Testing parallel Flush", t, func() {
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{
+			Config: mta.Config{Hostname: "satellite.example.com"},
+			Queue:  config.Queue{Dir: dir},
+			Forward: config.Forward{
+				Smarthost:         "smarthost.example.net:25",
+				Workers:           4,
+				DomainConcurrency: 1,
+			},
+		}
+		So(c.Queue.Open(), ShouldEqual, nil)
+		f := NewForward(c)
+
+		mutex := sync.Mutex{}
+		active := map[string]int{}
+		maxActive := map[string]int{}
+		sent := []string{}
+		f.send = func(addr, helo, from string, to []string, data []byte) client.Results {
+			domain := strings.SplitN(to[0], "@", 2)[1]
+			mutex.Lock()
+			active[domain]++
+			if active[domain] > maxActive[domain] {
+				maxActive[domain] = active[domain]
+			}
+			mutex.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			active[domain]--
+			if domain == "busy.example" {
+				return results(to, &textproto.Error{Code: 451, Msg: "try again later"})
+			}
+			sent = append(sent, to...)
+			return results(to, nil)
+		}
+
+		for i := 0; i < 4; i++ {
+			_, err := Enqueue(c, &smtp.State{To: []*smtp.MailAddress{
+				{Address: fmt.Sprintf("user%d@a.example", i)},
+				{Address: fmt.Sprintf("user%d@b.example", i)},
+			}}, helpers.PriorityNormal)
+			So(err, ShouldEqual, nil)
+		}
+		_, err = Enqueue(c, &smtp.State{To: []*smtp.MailAddress{
+			{Address: "user@a.example"},
+			{Address: "user@busy.example"},
+		}}, helpers.PriorityNormal)
+		So(err, ShouldEqual, nil)
+
+		f.Flush()
+		So(len(sent), ShouldEqual, 9)
+		So(maxActive["a.example"], ShouldEqual, 1)
+		So(maxActive["b.example"], ShouldEqual, 1)
+
+		// the deferred recipient stays queued
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		So(len(files), ShouldEqual, 1)
+		state := smtp.State{}
+		So(helpers.DecodeFile(files[0], &state), ShouldEqual, nil)
+		So(state.To, ShouldResemble, []*smtp.MailAddress{{Address: "user@busy.example"}})
+	})
+
+	Convey("Testing Forward when the message can't be queued", t, func() {
+		file, err := ioutil.TempFile("", "mailstore")
+		So(err, ShouldEqual, nil)
+		file.Close()
+		defer os.Remove(file.Name())
+
+		// the spool directory is a file
+		c := &config.Config{
+			Queue:   config.Queue{Dir: file.Name()},
+			Forward: config.Forward{Smarthost: "smarthost.example.net:25"},
+		}
+		So(json.Unmarshal([]byte(`{"Relay": ["192.168.0.0/24"]}`), &c.Access), ShouldEqual, nil)
+		So(c.Queue.Open(), ShouldEqual, nil)
+		f := NewForward(c)
+
+		state := &smtp.State{
+			To: []*smtp.MailAddress{{Address: "remote@example.org"}},
+			Ip: net.ParseIP("192.168.0.10"),
+		}
+		f.Handle(state)
+		// the message is dropped, the client retries it after the temporary failure
+		So(state.To, ShouldBeEmpty)
+		So(c.Acceptance.Take(state.SessionId.String()), ShouldNotEqual, nil)
+	})
+
+	Convey("Testing Forward when a later handler fails the message", t, func() {
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{
+			Queue:   config.Queue{Dir: dir},
+			Forward: config.Forward{Smarthost: "smarthost.example.net:25"},
+		}
+		So(json.Unmarshal([]byte(`{"Relay": ["192.168.0.0/24"]}`), &c.Access), ShouldEqual, nil)
+		So(c.Queue.Open(), ShouldEqual, nil)
+		f := NewForward(c)
+
+		state := &smtp.State{
+			To: []*smtp.MailAddress{{Address: "remote@example.org"}},
+			Ip: net.ParseIP("192.168.0.10"),
+		}
+		f.Handle(state)
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		So(len(files), ShouldEqual, 1)
+
+		// the client retries the message, so the queued copy is removed
+		c.Acceptance.Fail(state.SessionId.String(), errors.New("no space left on device"))
+		So(c.Acceptance.Take(state.SessionId.String()), ShouldNotEqual, nil)
+		files, _ = filepath.Glob(filepath.Join(dir, "*.json"))
+		So(files, ShouldBeEmpty)
+	})
+
+	Convey("Testing instances sharing a queue", t, func() {
+		server, err := miniredis.Run()
+		So(err, ShouldEqual, nil)
+		defer server.Close()
+
+		mutex := sync.Mutex{}
+		sent := map[string]int{}
+		forwards := []*Forward{}
+		for i := 0; i < 2; i++ {
+			c := &config.Config{
+				Queue:   config.Queue{Backend: "redis", RedisServer: config.RedisServer{Address: server.Addr()}},
+				Forward: config.Forward{Smarthost: "smarthost.example.net:25", Workers: 4},
+			}
+			So(c.Queue.Open(), ShouldEqual, nil)
+			defer c.Queue.Store.Close()
+			f := NewForward(c)
+			f.send = func(addr, helo, from string, to []string, data []byte) client.Results {
+				time.Sleep(time.Millisecond)
+				mutex.Lock()
+				defer mutex.Unlock()
+				sent[to[0]]++
+				return results(to, nil)
+			}
+			forwards = append(forwards, f)
+		}
+
+		// both instances accept mail and relay the queue at the same time
+		for i := 0; i < 20; i++ {
+			_, err := Enqueue(forwards[i%2].config, &smtp.State{To: []*smtp.MailAddress{{Address: fmt.Sprintf("user%d@example.org", i)}}}, "")
+			So(err, ShouldEqual, nil)
+		}
+		wg := sync.WaitGroup{}
+		for _, f := range forwards {
+			wg.Add(1)
+			go func(f *Forward) {
+				defer wg.Done()
+				f.Flush()
+			}(f)
+		}
+		wg.Wait()
+
+		So(len(sent), ShouldEqual, 20)
+		for _, count := range sent {
+			So(count, ShouldEqual, 1)
+		}
+		length, _ := forwards[0].config.Queue.Store.Len()
+		So(length, ShouldEqual, 0)
+	})
+
+	Convey("Testing encrypted queue", t, func() {
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		key := filepath.Join(dir, "key")
+		So(ioutil.WriteFile(key, []byte(strings.Repeat("ab", 32)), 0600), ShouldEqual, nil)
+
+		c := &config.Config{
+			Queue:      config.Queue{Dir: filepath.Join(dir, "queue")},
+			Forward:    config.Forward{Smarthost: "smarthost.example.net:25"},
+			Encryption: helpers.Encryption{KeyFile: key},
+		}
+		So(c.Encryption.Load(), ShouldEqual, nil)
+		So(c.Queue.Open(), ShouldEqual, nil)
+		f := NewForward(c)
+
+		data := []byte("Subject: secret\r\n\r\nbody\r\n")
+		_, err = Enqueue(c, &smtp.State{
+			To:   []*smtp.MailAddress{{Address: "a@a.example"}, {Address: "b@busy.example"}},
+			Data: data,
+		}, helpers.PriorityNormal)
+		So(err, ShouldEqual, nil)
+
+		// the message isn't readable in the spool
+		files, _ := filepath.Glob(filepath.Join(dir, "queue", "*.json"))
+		So(len(files), ShouldEqual, 1)
+		spooled, _ := ioutil.ReadFile(files[0])
+		So(string(spooled), ShouldNotContainSubstring, "secret")
+
+		// it's decrypted to be relayed, and stays encrypted for the next attempt
+		relayed := [][]byte{}
+		f.send = func(addr, helo, from string, to []string, data []byte) client.Results {
+			if strings.HasSuffix(to[0], "@busy.example") {
+				return results(to, &textproto.Error{Code: 451, Msg: "try again later"})
+			}
+			relayed = append(relayed, data)
+			return results(to, nil)
+		}
+		f.Flush()
+		So(relayed, ShouldResemble, [][]byte{data})
+		state := smtp.State{}
+		So(helpers.DecodeFile(files[0], &state), ShouldEqual, nil)
+		So(state.To, ShouldResemble, []*smtp.MailAddress{{Address: "b@busy.example"}})
+		So(helpers.IsEncrypted(state.Data), ShouldEqual, true)
+	})
+
+	Convey("Testing priority classes", t, func() {
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{
+			Config:  mta.Config{Hostname: "satellite.example.com"},
+			Queue:   config.Queue{Dir: dir},
+			Forward: config.Forward{Smarthost: "smarthost.example.net:25"},
+		}
+		c.Forward.Priorities.BulkPerFlush = 1
+		So(c.Queue.Open(), ShouldEqual, nil)
+		f := NewForward(c)
+		sent := []string{}
+		f.send = func(addr, helo, from string, to []string, data []byte) client.Results {
+			sent = append(sent, to...)
+			return results(to, nil)
+		}
+
+		for _, message := range []struct{ to, class string }{
+			{"bulk1@example.org", helpers.PriorityBulk},
+			{"normal@example.org", helpers.PriorityNormal},
+			{"bulk2@example.org", helpers.PriorityBulk},
+			{"high@example.org", helpers.PriorityHigh},
+		} {
+			_, err := Enqueue(c, &smtp.State{To: []*smtp.MailAddress{{Address: message.to}}}, message.class)
+			So(err, ShouldEqual, nil)
+		}
+		snapshot, err := TakeSnapshot(c.Queue.Store)
+		So(err, ShouldEqual, nil)
+		So(snapshot.Classes, ShouldResemble, map[string]int{"high": 1, "normal": 1, "bulk": 2})
+
+		// high first, bulk last and one per flush
+		f.Flush()
+		So(sent, ShouldResemble, []string{"high@example.org", "normal@example.org", "bulk1@example.org"})
+		f.Flush()
+		So(sent[3:], ShouldResemble, []string{"bulk2@example.org"})
+	})
+
+	Convey("Testing route script", t, func() {
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		routeScript := filepath.Join(dir, "route.star")
+		So(ioutil.WriteFile(routeScript, []byte(`
+if domain == "partner.example":
+    route("mx.partner.example:2525")
+elif domain == "broken.example":
+    route("no port")
+`), 0644), ShouldEqual, nil)
+
+		c := &config.Config{
+			Config:  mta.Config{Hostname: "satellite.example.com"},
+			Queue:   config.Queue{Dir: dir},
+			Forward: config.Forward{Smarthost: "smarthost.example.net:25"},
+		}
+		c.Scripts.Route = routeScript
+		// the script overrides the transports, which override the smarthost
+		c.Forward.Transports.Map = map[string]string{
+			"partner.example": "mx2.partner.example:25",
+			".other.example":  "relay.other.example:25",
+			"broken.example":  "mx.broken.example:25",
+			"direct.example":  "mx",
+		}
+		So(c.Queue.Open(), ShouldEqual, nil)
+		f := NewForward(c)
+		destinations := map[string]string{}
+		f.send = func(addr, helo, from string, to []string, data []byte) client.Results {
+			destinations[to[0]] = addr
+			return results(to, nil)
+		}
+		f.deliverDomain = func(helo, from string, to []string, data []byte) client.Results {
+			destinations[to[0]] = "MX hosts"
+			return results(to, nil)
+		}
+
+		_, err = Enqueue(c, &smtp.State{To: []*smtp.MailAddress{
+			{Address: "user@partner.example"},
+			{Address: "user@other.example"},
+			{Address: "user@sub.other.example"},
+			{Address: "user@broken.example"},
+			{Address: "user@direct.example"},
+		}}, helpers.PriorityNormal)
+		So(err, ShouldEqual, nil)
+		f.Flush()
+		So(destinations, ShouldResemble, map[string]string{
+			"user@partner.example":   "mx.partner.example:2525",
+			"user@other.example":     "smarthost.example.net:25",
+			"user@sub.other.example": "relay.other.example:25",
+			"user@broken.example":    "mx.broken.example:25",
+			"user@direct.example":    "MX hosts",
+		})
+	})
+
+	Convey("Testing the deliveries to MX hosts while the resolver is down", t, func() {
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{
+			Config:  mta.Config{Hostname: "satellite.example.com"},
+			Queue:   config.Queue{Dir: dir},
+			Forward: config.Forward{Smarthost: helpers.TransportMx},
+		}
+		So(c.Queue.Open(), ShouldEqual, nil)
+		f := NewForward(c)
+		now := time.Date(2016, 10, 5, 12, 0, 0, 0, time.Local)
+		f.now = func() time.Time { return now }
+		attempts := 0
+		f.deliverDomain = func(helo, from string, to []string, data []byte) client.Results {
+			attempts++
+			for i := 0; i < 5; i++ {
+				c.DnsHealth.Result(errors.New("server misbehaving"))
+			}
+			return results(to, errors.New("server misbehaving"))
+		}
+
+		_, err = Enqueue(c, &smtp.State{To: []*smtp.MailAddress{{Address: "user@example.org"}}}, helpers.PriorityNormal)
+		So(err, ShouldEqual, nil)
+		f.Flush()
+		So(attempts, ShouldEqual, 1)
+
+		// the MX lookups are paused for the backoff, the message stays queued
+		f.Flush()
+		So(attempts, ShouldEqual, 1)
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		So(len(files), ShouldEqual, 1)
+
+		now = now.Add(c.DnsHealth.Backoff())
+		f.Flush()
+		So(attempts, ShouldEqual, 2)
+	})
+
+	Convey("Testing Flush with an unreachable transport", t, func() {
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{
+			Config:  mta.Config{Hostname: "satellite.example.com"},
+			Queue:   config.Queue{Dir: dir},
+			Forward: config.Forward{Smarthost: "smarthost.example.net:25"},
+		}
+		c.Forward.Transports.Map = map[string]string{"partner.example": "relay.partner.example:25"}
+		So(c.Queue.Open(), ShouldEqual, nil)
+		f := NewForward(c)
+		attempts := map[string]int{}
+		f.send = func(addr, helo, from string, to []string, data []byte) client.Results {
+			attempts[addr]++
+			if addr == "relay.partner.example:25" {
+				return results(to, errors.New("connection refused"))
+			}
+			return results(to, nil)
+		}
+
+		for i := 0; i < 2; i++ {
+			_, err = Enqueue(c, &smtp.State{To: []*smtp.MailAddress{
+				{Address: "user@partner.example"},
+				{Address: "user@other.example"},
+			}}, helpers.PriorityNormal)
+			So(err, ShouldEqual, nil)
+		}
+		f.Flush()
+		// the host which can't be reached is only tried once, the other domains are relayed
+		So(attempts, ShouldResemble, map[string]int{"relay.partner.example:25": 1, "smarthost.example.net:25": 2})
+	})
+
+	Convey("Testing Flush with a recipient the smarthost refuses", t, func() {
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{
+			Config:  mta.Config{Hostname: "satellite.example.com"},
+			Queue:   config.Queue{Dir: dir},
+			Forward: config.Forward{Smarthost: "smarthost.example.net:25"},
+		}
+		So(c.Queue.Open(), ShouldEqual, nil)
+		f := NewForward(c)
+		sent := []string{}
+		f.send = func(addr, helo, from string, to []string, data []byte) client.Results {
+			r := results(to, nil)
+			for i, recipient := range to {
+				if recipient == "nobody@example.org" {
+					r[i].Err = &textproto.Error{Code: 550, Msg: "5.1.1 no such user"}
+				} else {
+					sent = append(sent, recipient)
+				}
+			}
+			return r
+		}
+		notifications := map[string]string{}
+		f.deliver = func(helo, from, to string, data []byte) error {
+			notifications[to] = string(data)
+			return nil
+		}
+
+		_, err = Enqueue(c, &smtp.State{
+			From: &smtp.MailAddress{Address: "from@example.com"},
+			To: []*smtp.MailAddress{
+				{Address: "somebody@example.org"},
+				{Address: "nobody@example.org"},
+			},
+		}, helpers.PriorityNormal)
+		So(err, ShouldEqual, nil)
+		f.Flush()
+
+		// the other recipient of the domain is relayed, the refused one is bounced and moved aside
+		So(sent, ShouldResemble, []string{"somebody@example.org"})
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		So(len(files), ShouldEqual, 0)
+		files, _ = filepath.Glob(filepath.Join(dir, "*.json.failed"))
+		So(len(files), ShouldEqual, 1)
+		So(notifications["from@example.com"], ShouldContainSubstring, "Final-Recipient: rfc822; nobody@example.org\r\nAction: failed\r\nStatus: 5.1.1\r\n")
+		So(notifications["from@example.com"], ShouldNotContainSubstring, "somebody@example.org")
+	})
+
+	Convey("Testing the delivery status of relayed messages", t, func() {
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{
+			Config:         mta.Config{Hostname: "satellite.example.com"},
+			Queue:          config.Queue{Dir: dir},
+			Forward:        config.Forward{Smarthost: "smarthost.example.net:25"},
+			DeliveryStatus: helpers.DeliveryStatus{Dir: filepath.Join(dir, "status")},
+		}
+		So(json.Unmarshal([]byte(`{"Relay": ["192.168.0.0/24"]}`), &c.Access), ShouldEqual, nil)
+		So(c.Queue.Open(), ShouldEqual, nil)
+		f := NewForward(c)
+		f.send = func(addr, helo, from string, to []string, data []byte) client.Results {
+			r := results(to, nil)
+			for i, recipient := range to {
+				if recipient == "nobody@example.org" {
+					r[i].Err = &textproto.Error{Code: 550, Msg: "5.1.1 no such user"}
+				}
+			}
+			return r
+		}
+		f.deliver = func(helo, from, to string, data []byte) error { return nil }
+
+		data := []byte("Message-ID: <1@example.com>\r\n\r\nHello world!")
+		f.Handle(&smtp.State{
+			From: &smtp.MailAddress{Address: "from@example.com"},
+			To:   []*smtp.MailAddress{{Address: "somebody@example.org"}, {Address: "nobody@example.org"}},
+			Data: data,
+			Ip:   net.ParseIP("192.168.0.10"),
+		})
+		// messages which weren't submitted by clients which may relay aren't tracked (e.g. bounces)
+		_, err = Enqueue(c, &smtp.State{
+			From: &smtp.MailAddress{Address: "other@example.com"},
+			To:   []*smtp.MailAddress{{Address: "somebody@example.org"}},
+			Data: data,
+		}, helpers.PriorityNormal)
+		So(err, ShouldEqual, nil)
+		f.Flush()
+
+		outcomes, err := c.DeliveryStatus.Outcomes("from@example.com", "<1@example.com>")
+		So(err, ShouldEqual, nil)
+		So(len(outcomes), ShouldEqual, 4)
+		So(outcomes[0].Outcome, ShouldEqual, helpers.DeliveryQueued)
+		So(outcomes[1].Outcome, ShouldEqual, helpers.DeliveryQueued)
+		So(outcomes[2].Recipient, ShouldEqual, "somebody@example.org")
+		So(outcomes[2].Outcome, ShouldEqual, helpers.DeliveryDelivered)
+		So(outcomes[3].Recipient, ShouldEqual, "nobody@example.org")
+		So(outcomes[3].Outcome, ShouldEqual, helpers.DeliveryFailed)
+		So(outcomes[3].Detail, ShouldContainSubstring, "no such user")
+		So(c.DeliveryStatus.Tracked("other@example.com", "<1@example.com>"), ShouldEqual, false)
+	})
+
+}
+
+// results returns the same outcome for all recipients
+func results(to []string, err error) client.Results {
+	r := make(client.Results, len(to))
+	for i, recipient := range to {
+		r[i] = client.Result{Recipient: recipient, Err: err}
+	}
+	return r
+}
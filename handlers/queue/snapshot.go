@@ -0,0 +1,147 @@
+package queue
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/gopistolet/spool"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// AgeBuckets are the upper bounds of the age histogram in a Snapshot
+var AgeBuckets = []time.Duration{
+	5 * time.Minute,
+	time.Hour,
+	4 * time.Hour,
+	24 * time.Hour,
+	5 * 24 * time.Hour,
+}
+
+// Snapshot summarizes the messages in the queue at one point in time
+type Snapshot struct {
+	Time       time.Time
+	Messages   int
+	Recipients int
+	// Pending recipients per domain
+	Domains map[string]int
+	// Messages per priority class
+	Classes map[string]int
+	// Ages counts the messages per age bucket, Ages[i] is the number of messages younger
+	// than AgeBuckets[i] (and older than AgeBuckets[i-1]), the last element counts the older messages
+	Ages []int
+	// Oldest is the age of the oldest message and AgeSum the sum of all ages in seconds
+	Oldest float64
+	AgeSum float64
+}
+
+// TakeSnapshot reads the messages in the store of the queue and summarizes them
+func TakeSnapshot(store spool.Store) (*Snapshot, error) {
+	entries, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	snapshot := &Snapshot{
+		Time:    now,
+		Domains: make(map[string]int),
+		Classes: make(map[string]int),
+		Ages:    make([]int, len(AgeBuckets)+1),
+	}
+	for _, entry := range entries {
+		state := smtp.State{}
+		if err := store.Read(entry.Id, &state); err != nil {
+			// the message may have been delivered in the meantime
+			continue
+		}
+
+		snapshot.Messages++
+		snapshot.Classes[entry.Class]++
+		snapshot.Recipients += len(state.To)
+		for _, to := range state.To {
+			snapshot.Domains[strings.ToLower(to.GetDomain())]++
+		}
+
+		age := now.Sub(entry.Queued)
+		bucket := 0
+		for bucket < len(AgeBuckets) && age >= AgeBuckets[bucket] {
+			bucket++
+		}
+		snapshot.Ages[bucket]++
+		snapshot.AgeSum += age.Seconds()
+		if age.Seconds() > snapshot.Oldest {
+			snapshot.Oldest = age.Seconds()
+		}
+	}
+	return snapshot, nil
+}
+
+// Snapshots takes a snapshot of the queue in Store every Interval seconds,
+// so the queue statistics can be read without scanning the spool on every request.
+type Snapshots struct {
+	Store    spool.Store
+	Interval int
+
+	mutex  sync.RWMutex
+	latest *Snapshot
+	stop   chan struct{}
+}
+
+// Latest returns the most recent snapshot, or nil if there is none yet
+func (s *Snapshots) Latest() *Snapshot {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.latest
+}
+
+// Take takes a new snapshot
+func (s *Snapshots) Take() error {
+	snapshot, err := TakeSnapshot(s.Store)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.latest = snapshot
+	s.mutex.Unlock()
+	return nil
+}
+
+// Start takes a snapshot every Interval seconds until Stop is called
+func (s *Snapshots) Start() {
+	interval := time.Duration(s.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	s.mutex.Lock()
+	s.stop = make(chan struct{})
+	stop := s.stop
+	s.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.Take(); err != nil {
+				log.Warnln("Couldn't take queue snapshot: ", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops taking snapshots
+func (s *Snapshots) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/spool"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSnapshot(t *testing.T) {
+
+	Convey("Testing TakeSnapshot()", t, func() {
+		dir, err := ioutil.TempDir("", "mailstore")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		save := func(name string, age time.Duration, to ...string) {
+			state := smtp.State{
+				From: &smtp.MailAddress{Address: "from@test.com"},
+				Data: []byte("Hello world!"),
+				Ip:   net.ParseIP("192.168.0.10"),
+			}
+			for _, address := range to {
+				state.To = append(state.To, &smtp.MailAddress{Address: address})
+			}
+			filename := filepath.Join(dir, name)
+			So(helpers.EncodeFile(filename, &state), ShouldEqual, nil)
+			modTime := time.Now().Add(-age)
+			So(os.Chtimes(filename, modTime, modTime), ShouldEqual, nil)
+		}
+		save("1.json", time.Minute, "a@example.com", "b@example.com")
+		save("2.json", 2*time.Hour, "a@Example.org")
+		save("3.json", 10*24*time.Hour, "c@example.com")
+		ioutil.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("not a message"), 0644)
+
+		snapshot, err := TakeSnapshot(&spool.FileStore{Dir: dir})
+		So(err, ShouldEqual, nil)
+		So(snapshot.Messages, ShouldEqual, 3)
+		So(snapshot.Recipients, ShouldEqual, 4)
+		So(snapshot.Domains, ShouldResemble, map[string]int{"example.com": 3, "example.org": 1})
+		So(snapshot.Ages, ShouldResemble, []int{1, 0, 1, 0, 0, 1})
+		So(snapshot.Oldest, ShouldBeGreaterThan, 9*24*3600)
+
+		// no spool directory yet
+		snapshot, err = TakeSnapshot(&spool.FileStore{Dir: filepath.Join(dir, "missing")})
+		So(err, ShouldEqual, nil)
+		So(snapshot.Messages, ShouldEqual, 0)
+	})
+
+}
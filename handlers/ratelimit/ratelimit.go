@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *RateLimit {
+	return &RateLimit{
+		config: c,
+	}
+}
+
+// RateLimit counts the messages and recipients per client IP.
+// Clients which exceed the limits are refused by the blacklist when they connect again.
+type RateLimit struct {
+	config *config.Config
+}
+
+func (handler *RateLimit) Handle(state *smtp.State) {
+	// trusted clients aren't limited
+	if handler.config.Access.Trusted(state.Ip) {
+		return
+	}
+
+	ip := state.Ip.String()
+	handler.config.RateLimits.Count(ip, len(state.To))
+
+	if handler.config.RateLimits.CheckIp(ip) {
+		log.WithFields(log.Fields{
+			"Ip":        ip,
+			"SessionId": state.SessionId.String(),
+		}).Warn("Client exceeded its rate limit")
+	}
+}
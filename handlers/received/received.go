@@ -1,22 +1,32 @@
 package received
 
 import (
+	"crypto/tls"
 	"fmt"
 	"time"
 
+	"github.com/gopistolet/gopistolet/helpers"
 	"github.com/gopistolet/gopistolet/log"
 	"github.com/gopistolet/smtp/mta"
 	"github.com/gopistolet/smtp/smtp"
 )
 
-func New(c *mta.Config) *Received {
+// New returns the handler, clients which a proxy reported with XCLIENT are looked up in xclient,
+// the users which authenticated with AUTH in logins and the TLS connections in tlsSessions (which may be nil)
+func New(c *mta.Config, xclient *helpers.XclientSessions, logins *helpers.Logins, tlsSessions *helpers.TlsSessions) *Received {
 	return &Received{
-		config: c,
+		config:      c,
+		xclient:     xclient,
+		logins:      logins,
+		tlsSessions: tlsSessions,
 	}
 }
 
 type Received struct {
-	config *mta.Config
+	config      *mta.Config
+	xclient     *helpers.XclientSessions
+	logins      *helpers.Logins
+	tlsSessions *helpers.TlsSessions
 }
 
 func (handler *Received) Handle(state *smtp.State) {
@@ -34,19 +44,80 @@ func (handler *Received) Handle(state *smtp.State) {
 	       received-token  =   word / angle-addr / addr-spec / domain
 
 
+	   RFC 5321 4.4.
+
+	       Time-stamp-line = "Received:" FWS Stamp <CRLF>
+	       Stamp           = From-domain By-domain Opt-info [CFWS] ";" FWS date-time
+	       From-domain     = "FROM" FWS Extended-Domain
+	       By-domain       = CFWS "BY" FWS Extended-Domain
+	       Opt-info        = [Via] [With] [ID] [For] [Additional-Registered-Clauses]
+	       For             = CFWS "FOR" FWS ( Path / Mailbox )
+
+	   The FOR clause is only added when there is exactly one recipient,
+	   so the other recipients aren't disclosed.
+
+
 	   Example:
 
-	       Received: from mail.example.com (192.168.0.10) by some.mail.server.example.com (192.168.0.11) with Microsoft SMTP Server id 14.3.319.2; Wed, 5 Oct 2016 14:57:46 +0200
+	       Received: from mail.example.com ([192.168.0.10])
+	               by some.mail.server.example.com ([192.168.0.11])
+	               with ESMTP id 06gk87ektfv75jd6vvt0evbq
+	               for <to@test.com>; Wed, 5 Oct 2016 14:57:46 +0200
+
+	   IPs are written as address literals (RFC 5321 section 4.1.3): [192.168.0.10] or [IPv6:2001:db8::1]
+
+	   For clients which a proxy reported with XCLIENT, the name of the client is added to its address,
+	   and ESMTPA (RFC 3848) says the client authenticated at the proxy, or with AUTH:
+
+	       Received: from mail.example.com (mail.example.com [192.168.0.10])
+
+	   Sessions with TLS are ESMTPS or ESMTPSA (RFC 3848), the TLS version and cipher are added as comment:
+
+	       with ESMTPS (version=TLSv1.3 cipher=TLS_AES_128_GCM_SHA256) id 06gk87ektfv75jd6vvt0evbq
 	*/
+	id := helpers.NewId()
 	date := time.Now().Format(time.RFC1123Z) // date-time in RFC 5322 is like RFC 1123Z
-	headerField := fmt.Sprintf("Received: from %s (%s) by %s (%s) with GoPistolet; %s\r\n", state.Hostname, state.Ip, handler.config.Hostname, handler.config.Ip, date)
-	state.Data = append([]byte(headerField), state.Data...)
 
-	// TODO: 'by IP' is not necessarily set in config
+	from := state.Hostname
+	if from == "" {
+		from = helpers.AddressLiteral(state.Ip)
+	}
+	client, _ := handler.xclient.Get(state.SessionId.String())
+	tcpInfo := helpers.AddressLiteral(state.Ip)
+	if client.Name != "" {
+		tcpInfo = client.Name + " " + tcpInfo
+	}
+	headerField := fmt.Sprintf("Received: from %s (%s)\r\n", from, tcpInfo)
+
+	// 'by IP' is not necessarily set in config
+	headerField += "\tby " + handler.config.Hostname
+	if ip := helpers.ParseIp(handler.config.Ip); ip != nil {
+		headerField += " (" + helpers.AddressLiteral(ip) + ")"
+	}
+	protocol := "ESMTP"
+	if state.Secure {
+		protocol += "S"
+	}
+	if _, login := handler.logins.Get(state.SessionId.String()); login || client.Login != "" {
+		protocol += "A"
+	}
+	headerField += "\r\n\twith " + protocol
+	if tlsState, ok := handler.tlsSessions.Get(state.SessionId.String()); ok && state.Secure {
+		headerField += fmt.Sprintf(" (version=%s cipher=%s)", helpers.TlsVersion(tlsState.Version), tls.CipherSuiteName(tlsState.CipherSuite))
+	}
+	headerField += " id " + id
+
+	if len(state.To) == 1 {
+		headerField += "\r\n\tfor <" + state.To[0].Address + ">"
+	}
+	headerField += "; " + date + "\r\n"
+
+	state.Data = append([]byte(headerField), state.Data...)
 
 	log.WithFields(log.Fields{
 		"Ip":        state.Ip.String(),
 		"SessionId": state.SessionId.String(),
 		"Hostname":  state.Hostname,
+		"Id":        id,
 	}).Debug("Added 'received' header: '", headerField, "'")
 }
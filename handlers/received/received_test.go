@@ -2,10 +2,12 @@ package received
 
 import (
 	"bytes"
+	"crypto/tls"
 	"net"
 	"strings"
 	"testing"
 
+	"github.com/gopistolet/gopistolet/helpers"
 	"github.com/gopistolet/smtp/mta"
 	"github.com/gopistolet/smtp/smtp"
 
@@ -29,20 +31,136 @@ func TestReceivedHandler(t *testing.T) {
 			Hostname: "mail.example.com",
 		}
 
-		h := New(&c)
+		h := New(&c, nil, nil, nil)
 		h.Handle(&state)
 
-		buffer := bytes.NewBuffer(state.Data)
-
-		header, err := buffer.ReadString('\n')
+		header := receivedHeader(state.Data)
 
 		// strip date from header for testing
 		So(len(strings.Split(header, ";")), ShouldEqual, 2)
 		header = strings.Split(header, ";")[0]
 
-		So(err, ShouldEqual, nil)
-		So(header, ShouldEqual, "Received: from mail.example.com (192.168.0.10) by some.mail.server.example.com (192.168.0.11) with GoPistolet")
+		prefix := "Received: from mail.example.com ([192.168.0.10]) by some.mail.server.example.com ([192.168.0.11]) with ESMTP id "
+		So(header, ShouldStartWith, prefix)
+		So(header, ShouldEndWith, " for <to@test.com>")
+		So(len(strings.TrimSuffix(strings.TrimPrefix(header, prefix), " for <to@test.com>")), ShouldEqual, 24)
+
+		// the header should be folded
+		So(string(state.Data), ShouldStartWith, "Received: from mail.example.com ([192.168.0.10])\r\n\tby ")
 
 	})
 
+	Convey("Testing headerReceived() handler with multiple recipients", t, func() {
+
+		c := mta.Config{
+			Hostname: "some.mail.server.example.com",
+		}
+
+		state := smtp.State{
+			From: &smtp.MailAddress{Address: "from@test.com"},
+			To: []*smtp.MailAddress{
+				&smtp.MailAddress{Address: "to@test.com"},
+				&smtp.MailAddress{Address: "bcc@test.com"},
+			},
+			Data:     []byte("Hello world!"),
+			Ip:       net.ParseIP("192.168.0.10"),
+			Hostname: "mail.example.com",
+		}
+
+		h := New(&c, nil, nil, nil)
+		h.Handle(&state)
+
+		header := strings.Split(receivedHeader(state.Data), ";")[0]
+		So(header, ShouldStartWith, "Received: from mail.example.com ([192.168.0.10]) by some.mail.server.example.com with ESMTP id ")
+		So(header, ShouldNotContainSubstring, " for ")
+		So(header, ShouldNotContainSubstring, "bcc@test.com")
+
+	})
+
+	Convey("Testing headerReceived() handler with IPv6", t, func() {
+
+		c := mta.Config{
+			Hostname: "some.mail.server.example.com",
+			Ip:       "2001:db8::11",
+		}
+
+		state := smtp.State{
+			From:     &smtp.MailAddress{Address: "from@test.com"},
+			To:       []*smtp.MailAddress{&smtp.MailAddress{Address: "to@test.com"}},
+			Data:     []byte("Hello world!"),
+			Ip:       net.ParseIP("2001:db8::10"),
+			Hostname: "[IPv6:2001:db8::10]",
+		}
+
+		h := New(&c, nil, nil, nil)
+		h.Handle(&state)
+
+		header := receivedHeader(state.Data)
+		So(header, ShouldStartWith, "Received: from [IPv6:2001:db8::10] ([IPv6:2001:db8::10]) by some.mail.server.example.com ([IPv6:2001:db8::11]) with ESMTP id ")
+
+	})
+
+	Convey("Testing headerReceived() handler with a client reported by a proxy", t, func() {
+
+		c := mta.Config{
+			Hostname: "some.mail.server.example.com",
+		}
+
+		state := smtp.State{
+			SessionId: smtp.Id{Timestamp: 1, Counter: 1},
+			Data:      []byte("Hello world!"),
+			Ip:        net.ParseIP("192.168.0.10"),
+			Hostname:  "mail.example.com",
+		}
+		xclient := &helpers.XclientSessions{}
+		xclient.Set(state.SessionId.String(), helpers.Xclient{Name: "mx.example.com", Addr: state.Ip, Login: "bob"})
+
+		h := New(&c, xclient, nil, nil)
+		h.Handle(&state)
+
+		header := receivedHeader(state.Data)
+		So(header, ShouldStartWith, "Received: from mail.example.com (mx.example.com [192.168.0.10]) by some.mail.server.example.com with ESMTPA id ")
+
+		// a client which authenticated with AUTH
+		state.Data = []byte("Hello world!")
+		logins := &helpers.Logins{}
+		logins.Set(state.SessionId.String(), "bob")
+		New(&c, nil, logins, nil).Handle(&state)
+		header = receivedHeader(state.Data)
+		So(header, ShouldStartWith, "Received: from mail.example.com ([192.168.0.10]) by some.mail.server.example.com with ESMTPA id ")
+
+		// a client which authenticated with AUTH after STARTTLS
+		state.Data = []byte("Hello world!")
+		state.Secure = true
+		tlsSessions := &helpers.TlsSessions{}
+		tlsSessions.Set(state.SessionId.String(), tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256})
+		New(&c, nil, logins, tlsSessions).Handle(&state)
+		header = receivedHeader(state.Data)
+		So(header, ShouldStartWith, "Received: from mail.example.com ([192.168.0.10]) by some.mail.server.example.com "+
+			"with ESMTPSA (version=TLSv1.3 cipher=TLS_AES_128_GCM_SHA256) id ")
+
+		// a client which didn't authenticate
+		state.Data = []byte("Hello world!")
+		New(&c, nil, nil, tlsSessions).Handle(&state)
+		So(receivedHeader(state.Data), ShouldContainSubstring, " with ESMTPS (version=TLSv1.3 ")
+
+	})
+
+}
+
+// receivedHeader returns the unfolded first header field of the message
+func receivedHeader(data []byte) string {
+	end := bytes.Index(data, []byte("\r\n"))
+	for end >= 0 && end+2 < len(data) && (data[end+2] == ' ' || data[end+2] == '\t') {
+		next := bytes.Index(data[end+2:], []byte("\r\n"))
+		if next < 0 {
+			break
+		}
+		end += 2 + next
+	}
+	if end < 0 {
+		return ""
+	}
+	unfolded := strings.Replace(string(data[:end]), "\r\n", "", -1)
+	return strings.Replace(unfolded, "\t", " ", -1)
 }
@@ -0,0 +1,25 @@
+package reputation
+
+import (
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *Reputation {
+	return &Reputation{
+		config: c,
+	}
+}
+
+// Reputation registers the client IPs of messages which passed all checks,
+// it should be the last handler before delivery.
+type Reputation struct {
+	config *config.Config
+}
+
+func (handler *Reputation) Handle(state *smtp.State) {
+	if len(state.To) == 0 {
+		return
+	}
+	handler.config.Reputation.Add(state.Ip.String())
+}
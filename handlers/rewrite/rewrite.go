@@ -0,0 +1,128 @@
+package rewrite
+
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// senderFields are the header fields with sender addresses which are rewritten
+var senderFields = []string{"From", "Sender", "Reply-To", "Return-Path"}
+
+func New(c *config.Config) *Rewrite {
+	return &Rewrite{
+		config: c,
+	}
+}
+
+// Rewrite rewrites the sender addresses and strips header fields of outbound messages
+// (messages from clients which may relay) before they're delivered.
+type Rewrite struct {
+	config *config.Config
+}
+
+func (handler *Rewrite) Handle(state *smtp.State) {
+	c := &handler.config.Rewrite
+	if len(c.Canonical) == 0 && len(c.Masquerade) == 0 && len(c.Strip) == 0 {
+		return
+	}
+	if !handler.config.MayRelay(state) {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	// envelope sender
+	if state.From != nil && state.From.Address != "" {
+		if address := rewriteAddress(c, state.From.Address); address != state.From.Address {
+			logger.Debugf("Rewrite: envelope sender %s -> %s", state.From.Address, address)
+			state.From.Address = address
+		}
+	}
+
+	fields, body := helpers.SplitHeader(state.Data)
+	buffer := bytes.Buffer{}
+	for _, field := range fields {
+		name := helpers.FieldName(field)
+		if stripField(c.Strip, name) {
+			logger.Debugf("Rewrite: stripped %s header field", name)
+			continue
+		}
+		if isSenderField(name) {
+			field = rewriteField(c, field)
+		}
+		buffer.WriteString(field)
+	}
+	buffer.Write(body)
+	state.Data = buffer.Bytes()
+}
+
+// rewriteField rewrites the addresses in a header field,
+// only the addresses are replaced, so display names and comments are kept as they are
+func rewriteField(c *config.Rewrite, field string) string {
+	addresses, err := mail.ParseAddressList(helpers.FieldValue(field))
+	if err != nil {
+		return field
+	}
+	for _, a := range addresses {
+		if rewritten := rewriteAddress(c, a.Address); rewritten != a.Address {
+			field = strings.Replace(field, a.Address, rewritten, -1)
+		}
+	}
+	return field
+}
+
+// rewriteAddress maps the address to its canonical address and masquerades its domain
+func rewriteAddress(c *config.Rewrite, address string) string {
+	for from, to := range c.Canonical {
+		if strings.EqualFold(from, address) {
+			address = to
+			break
+		}
+	}
+
+	i := strings.LastIndexByte(address, '@')
+	if i < 0 {
+		return address
+	}
+	domain := strings.ToLower(address[i+1:])
+	for _, masquerade := range c.Masquerade {
+		masquerade = strings.ToLower(masquerade)
+		if strings.HasSuffix(domain, "."+masquerade) {
+			return address[:i+1] + masquerade
+		}
+	}
+	return address
+}
+
+// stripField reports whether the header field should be removed,
+// names ending in * match all fields starting with the rest of the name (e.g. X-Internal-*)
+func stripField(strip []string, name string) bool {
+	for _, s := range strip {
+		if strings.HasSuffix(s, "*") {
+			if strings.HasPrefix(strings.ToLower(name), strings.ToLower(strings.TrimSuffix(s, "*"))) {
+				return true
+			}
+		} else if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSenderField(name string) bool {
+	for _, f := range senderFields {
+		if strings.EqualFold(f, name) {
+			return true
+		}
+	}
+	return false
+}
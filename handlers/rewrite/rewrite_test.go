@@ -0,0 +1,75 @@
+package rewrite
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRewrite(t *testing.T) {
+
+	c := config.Config{
+		Rewrite: config.Rewrite{
+			Canonical:  map[string]string{"bob@example.com": "robert.smith@example.com"},
+			Masquerade: []string{"example.com"},
+			Strip:      []string{"Received", "X-Internal-*"},
+		},
+	}
+	err := json.Unmarshal([]byte(`{"Relay": ["192.168.0.0/24"]}`), &c.Access)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Testing rewriteAddress()", t, func() {
+		So(rewriteAddress(&c.Rewrite, "Bob@example.com"), ShouldEqual, "robert.smith@example.com")
+		So(rewriteAddress(&c.Rewrite, "alice@host.example.com"), ShouldEqual, "alice@example.com")
+		So(rewriteAddress(&c.Rewrite, "alice@example.com"), ShouldEqual, "alice@example.com")
+		So(rewriteAddress(&c.Rewrite, "alice@badexample.com"), ShouldEqual, "alice@badexample.com")
+	})
+
+	Convey("Testing stripField()", t, func() {
+		So(stripField(c.Rewrite.Strip, "received"), ShouldEqual, true)
+		So(stripField(c.Rewrite.Strip, "X-Internal-Host"), ShouldEqual, true)
+		So(stripField(c.Rewrite.Strip, "X-Mailer"), ShouldEqual, false)
+	})
+
+	Convey("Testing Rewrite handler", t, func() {
+		h := New(&c)
+
+		data := "Received: from internal.example.com\r\n\tby mx.example.com; date\r\n" +
+			"From: \"Bob\" <bob@example.com>\r\n" +
+			"To: <alice@host.example.com>\r\n" +
+			"X-Internal-Host: build01\r\n" +
+			"\r\n" +
+			"Hello bob@example.com!"
+
+		state := &smtp.State{
+			From: &smtp.MailAddress{Address: "bob@example.com"},
+			To:   []*smtp.MailAddress{&smtp.MailAddress{Address: "carol@test.com"}},
+			Ip:   net.ParseIP("192.168.0.10"),
+			Data: []byte(data),
+		}
+		h.Handle(state)
+		So(state.From.Address, ShouldEqual, "robert.smith@example.com")
+		So(string(state.Data), ShouldEqual, "From: \"Bob\" <robert.smith@example.com>\r\n"+
+			"To: <alice@host.example.com>\r\n"+
+			"\r\n"+
+			"Hello bob@example.com!")
+
+		// inbound messages aren't rewritten
+		state = &smtp.State{
+			From: &smtp.MailAddress{Address: "bob@example.com"},
+			Ip:   net.ParseIP("10.0.0.1"),
+			Data: []byte(data),
+		}
+		h.Handle(state)
+		So(state.From.Address, ShouldEqual, "bob@example.com")
+		So(string(state.Data), ShouldEqual, data)
+	})
+
+}
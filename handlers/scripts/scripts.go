@@ -0,0 +1,199 @@
+package scripts
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/gopistolet/script"
+	"github.com/gopistolet/smtp/smtp"
+	"go.starlark.net/starlark"
+)
+
+func New(c *config.Config) *Scripts {
+	return &Scripts{
+		config: c,
+	}
+}
+
+// Scripts runs the rcpt script for every recipient, which can call accept(), reject(reason) or redirect(address)
+// (the recipient is in rcpt; a rejected recipient gets a 550 in the reply to DATA with PRDR), and the headers script, which can call add_header(name, value) and remove_header(name).
+// A script which fails doesn't change anything. The postmaster is always accepted.
+type Scripts struct {
+	config *config.Config
+}
+
+func (handler *Scripts) Handle(state *smtp.State) {
+	hooks := &handler.config.Scripts
+	if !hooks.Enabled(script.HookRcpt) && !hooks.Enabled(script.HookHeaders) {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	if hooks.Enabled(script.HookRcpt) {
+		to := make([]*smtp.MailAddress, 0, len(state.To))
+		for _, recipient := range state.To {
+			if handler.config.LocalDomains.IsPostmaster(recipient.Address) {
+				to = append(to, recipient)
+				continue
+			}
+			decided, err := handler.rcpt(state, recipient)
+			if err != nil {
+				logger.Errorf("Scripts: rcpt script failed for %s: %v", recipient.Address, err)
+				to = append(to, recipient)
+				continue
+			}
+			to = append(to, decided...)
+		}
+		state.To = to
+	}
+
+	if hooks.Enabled(script.HookHeaders) && len(state.To) > 0 {
+		if err := handler.headers(state); err != nil {
+			logger.Errorf("Scripts: headers script failed: %v", err)
+		}
+	}
+}
+
+// rcpt runs the rcpt script for the recipient, it returns the recipients which replace it.
+// A rejected recipient refuses the message in the reply to DATA if the client negotiated PRDR,
+// it's dropped otherwise: a bounce to a sender which may be forged would be backscatter.
+func (handler *Scripts) rcpt(state *smtp.State, recipient *smtp.MailAddress) ([]*smtp.MailAddress, error) {
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	decided := []*smtp.MailAddress{recipient}
+	reply := ""
+	err := handler.config.Scripts.Run(script.HookRcpt, message(state), starlark.StringDict{
+		"rcpt": starlark.String(recipient.Address),
+		"accept": starlark.NewBuiltin("accept", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+				return nil, err
+			}
+			decided, reply = []*smtp.MailAddress{recipient}, ""
+			return starlark.None, nil
+		}),
+		"reject": starlark.NewBuiltin("reject", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			reason := "Recipient rejected"
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0, &reason); err != nil {
+				return nil, err
+			}
+			// a reason with line breaks would break the protocol
+			reason = strings.NewReplacer("\r", " ", "\n", " ").Replace(reason)
+			decided, reply = nil, "550 5.7.1 "+reason
+			return starlark.None, nil
+		}),
+		"redirect": starlark.NewBuiltin("redirect", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var to string
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &to); err != nil {
+				return nil, err
+			}
+			address, err := smtp.ParseAddress(to)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", to)
+			}
+			decided, reply = []*smtp.MailAddress{&address}, ""
+			return starlark.None, nil
+		}),
+	})
+	if err != nil {
+		return []*smtp.MailAddress{recipient}, err
+	}
+
+	switch {
+	case reply != "":
+		logger.Infof("Scripts: rejected recipient %s: %s", recipient.Address, reply)
+		handler.config.Dsn.Forget(state.SessionId.String(), recipient.Address)
+		handler.config.Acceptance.Reject(state.SessionId.String(), recipient.Address, reply)
+	case decided[0] != recipient:
+		logger.Infof("Scripts: redirected recipient %s to %s", recipient.Address, decided[0].Address)
+	}
+	return decided, nil
+}
+
+// headers runs the headers script and applies its changes
+func (handler *Scripts) headers(state *smtp.State) error {
+	add := []string{}
+	remove := make(map[string]bool)
+	err := handler.config.Scripts.Run(script.HookHeaders, message(state), starlark.StringDict{
+		"add_header": starlark.NewBuiltin("add_header", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name, value string
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &name, &value); err != nil {
+				return nil, err
+			}
+			if !validName(name) || strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf("invalid header field %q", name)
+			}
+			add = append(add, name+": "+value+"\r\n")
+			return starlark.None, nil
+		}),
+		"remove_header": starlark.NewBuiltin("remove_header", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &name); err != nil {
+				return nil, err
+			}
+			remove[strings.ToLower(name)] = true
+			return starlark.None, nil
+		}),
+	})
+	if err != nil {
+		return err
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		return nil
+	}
+
+	buffer := bytes.Buffer{}
+	for _, field := range add {
+		buffer.WriteString(field)
+	}
+	fields, body := helpers.SplitHeader(state.Data)
+	for _, field := range fields {
+		if remove[strings.ToLower(helpers.FieldName(field))] {
+			continue
+		}
+		buffer.WriteString(field)
+	}
+	buffer.Write(body)
+	state.Data = buffer.Bytes()
+	return nil
+}
+
+// validName reports whether name is a valid field name (RFC 5322 section 3.6.8)
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c < 33 || c > 126 || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// message is the message of the state, as the scripts know it
+func message(state *smtp.State) *script.Message {
+	m := &script.Message{
+		SessionId: state.SessionId.String(),
+		Ip:        state.Ip.String(),
+		Helo:      state.Hostname,
+		Data:      state.Data,
+	}
+	if state.From != nil {
+		m.From = state.From.Address
+	}
+	for _, recipient := range state.To {
+		m.To = append(m.To, recipient.Address)
+	}
+	return m
+}
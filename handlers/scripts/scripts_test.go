@@ -0,0 +1,103 @@
+package scripts
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const rcptScript = `
+if rcpt.startswith("old@"):
+    redirect("new@example.com")
+elif "spam" in (header("Subject") or "").lower() and ip.startswith("192.0.2."):
+    reject("no spam for " + rcpt)
+elif rcpt == "broken@example.com":
+    redirect("not an address")
+`
+
+const headersScript = `
+remove_header("X-Internal")
+add_header("X-Envelope-From", sender)
+if size > 1000:
+    add_header("X-Large", "yes")
+`
+
+func TestScriptsHandler(t *testing.T) {
+
+	Convey("Testing Scripts handler", t, func() {
+		dir, err := ioutil.TempDir("", "scripts")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		c := &config.Config{LocalDomains: helpers.LocalDomains{"example.com": {}}}
+		c.Scripts.Rcpt = filepath.Join(dir, "rcpt.star")
+		c.Scripts.Headers = filepath.Join(dir, "headers.star")
+		So(ioutil.WriteFile(c.Scripts.Rcpt, []byte(rcptScript), 0644), ShouldEqual, nil)
+		So(ioutil.WriteFile(c.Scripts.Headers, []byte(headersScript), 0644), ShouldEqual, nil)
+		h := New(c)
+
+		newState := func(subject string, to ...string) *smtp.State {
+			state := &smtp.State{
+				From: &smtp.MailAddress{Address: "alice@example.org"},
+				Ip:   net.ParseIP("192.0.2.1"),
+				Data: []byte("Subject: " + subject + "\r\nX-Internal: secret\r\n\r\nHello\r\n"),
+			}
+			for _, address := range to {
+				state.To = append(state.To, &smtp.MailAddress{Address: address})
+			}
+			return state
+		}
+		addresses := func(state *smtp.State) []string {
+			list := []string{}
+			for _, to := range state.To {
+				list = append(list, to.Address)
+			}
+			return list
+		}
+
+		state := newState("Hello", "old@example.com", "bob@example.com", "broken@example.com")
+		h.Handle(state)
+		So(addresses(state), ShouldResemble, []string{"new@example.com", "bob@example.com", "broken@example.com"})
+		So(string(state.Data), ShouldEqual, "X-Envelope-From: alice@example.org\r\nSubject: Hello\r\n\r\nHello\r\n")
+
+		// the postmaster is always accepted
+		state = newState("Cheap SPAM", "bob@example.com", "postmaster@example.com")
+		h.Handle(state)
+		So(addresses(state), ShouldResemble, []string{"postmaster@example.com"})
+
+		state = newState("Cheap SPAM", "bob@example.com")
+		h.Handle(state)
+		So(state.To, ShouldBeEmpty)
+		So(string(state.Data), ShouldStartWith, "Subject: Cheap SPAM\r\n")
+		So(c.Acceptance.Rejected(state.SessionId.String()), ShouldBeEmpty)
+
+		// with PRDR the rejected recipient refuses the message in the reply to DATA
+		state = newState("Cheap SPAM", "bob@example.com", "carol@example.com")
+		c.Acceptance.Prdr(state.SessionId.String(), true)
+		h.Handle(state)
+		So(state.To, ShouldBeEmpty)
+		So(c.Acceptance.Rejected(state.SessionId.String()), ShouldResemble, map[string]string{
+			"bob@example.com":   "550 5.7.1 no spam for bob@example.com",
+			"carol@example.com": "550 5.7.1 no spam for carol@example.com",
+		})
+		So(c.Acceptance.Take(state.SessionId.String()), ShouldEqual, nil)
+
+		// a failing script doesn't change the message
+		So(ioutil.WriteFile(c.Scripts.Headers, []byte("add_header('Bad Name', 'x')\n"), 0644), ShouldEqual, nil)
+		later := time.Now().Add(time.Second)
+		So(os.Chtimes(c.Scripts.Headers, later, later), ShouldEqual, nil)
+		state = newState("Hello", "bob@example.com")
+		h.Handle(state)
+		So(string(state.Data), ShouldEqual, "Subject: Hello\r\nX-Internal: secret\r\n\r\nHello\r\n")
+	})
+
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+/**
+ * Shadow evaluates a candidate chain of filters alongside the active one.
+ *
+ * The active chain handles the message as usual, the candidate chain gets a copy
+ * so it can't affect the message. Decisions which would have differed
+ * (dropped recipients, added header fields) are logged and appended to the report file,
+ * so operators can validate a new configuration on live traffic before enabling it.
+ * The candidate chain shouldn't have side effects, since it runs on every message.
+ * It sees the session of the message like the active chain: its login, the client reported with XCLIENT
+ * and the DSN parameters are copied from the active configuration to the candidate's.
+ */
+type Shadow struct {
+	// Active are the filters of the message, the Shadow doesn't recover their panics
+	Active    *HandlerMachanism
+	Candidate *HandlerMachanism
+
+	// Config and CandidateConfig are the configurations of both chains, for the state of the sessions
+	Config          *config.Config
+	CandidateConfig *config.Config
+
+	// Report is the file to which the differences are appended (one JSON object per line)
+	Report string
+
+	mutex sync.Mutex
+}
+
+// ShadowDifference is a report entry for a message on which both chains disagreed
+type ShadowDifference struct {
+	Time        time.Time
+	SessionId   string
+	Ip          string
+	From        string
+	Differences []string
+}
+
+func (s *Shadow) Handle(state *smtp.State) {
+	s.HandleContext(context.Background(), state)
+}
+
+func (s *Shadow) HandleContext(ctx context.Context, state *smtp.State) {
+	candidateState := copyState(state)
+
+	// the panics of the active filters aren't recovered here, the chain of the message stops then
+	// and the transaction fails before the message is delivered
+	for _, handler := range s.Active.Handlers {
+		call(ctx, handler, state)
+		if len(state.To) == 0 {
+			break
+		}
+	}
+	// the candidate may drop recipients, their session state is forgotten too
+	recipients := addresses(candidateState.To)
+	s.copySession(candidateState)
+	s.Candidate.HandleContext(ctx, candidateState)
+	s.forgetSession(candidateState.SessionId.String(), recipients)
+
+	differences := compareStates(state, candidateState)
+	if len(differences) == 0 {
+		return
+	}
+
+	diff := ShadowDifference{
+		Time:        time.Now(),
+		SessionId:   state.SessionId.String(),
+		Ip:          state.Ip.String(),
+		Differences: differences,
+	}
+	if state.From != nil {
+		diff.From = state.From.Address
+	}
+
+	log.WithFields(log.Fields{
+		"Ip":        diff.Ip,
+		"SessionId": diff.SessionId,
+	}).Info("Shadow: candidate configuration would have decided differently: ", strings.Join(differences, ", "))
+
+	if s.Report != "" {
+		s.writeReport(&diff)
+	}
+}
+
+// copySession copies the state of the session of the message to the candidate's configuration
+func (s *Shadow) copySession(state *smtp.State) {
+	if s.Config == nil || s.CandidateConfig == nil {
+		return
+	}
+	sessionId := state.SessionId.String()
+	if login, found := s.Config.Logins.Get(sessionId); found {
+		s.CandidateConfig.Logins.Set(sessionId, login)
+	}
+	if client, found := s.Config.Xclient.Get(sessionId); found {
+		s.CandidateConfig.Xclient.Set(sessionId, client)
+	}
+	if tlsState, found := s.Config.Tls.Get(sessionId); found {
+		s.CandidateConfig.Tls.Set(sessionId, tlsState)
+	}
+	for _, to := range state.To {
+		if request, found := s.Config.Dsn.Get(sessionId, to.Address); found {
+			s.CandidateConfig.Dsn.Set(sessionId, to.Address,
+				map[string]string{"RET": request.Ret, "ENVID": request.EnvId},
+				map[string]string{"NOTIFY": request.Notify, "ORCPT": request.Orcpt})
+		}
+	}
+}
+
+// forgetSession removes the state of the session of the message from the candidate's configuration,
+// with the outcome of its transaction
+func (s *Shadow) forgetSession(sessionId string, recipients []string) {
+	if s.CandidateConfig == nil {
+		return
+	}
+	s.CandidateConfig.Logins.Forget(sessionId)
+	s.CandidateConfig.Xclient.Forget(sessionId)
+	s.CandidateConfig.Tls.Forget(sessionId)
+	for _, recipient := range recipients {
+		s.CandidateConfig.Dsn.Forget(sessionId, recipient)
+	}
+	s.CandidateConfig.Acceptance.Take(sessionId)
+}
+
+func (s *Shadow) writeReport(diff *ShadowDifference) {
+	line, err := json.Marshal(diff)
+	if err != nil {
+		log.Errorf("Shadow: couldn't encode report: %v", err)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, err := os.OpenFile(s.Report, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Errorf("Shadow: couldn't open report: %v", err)
+		return
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		log.Errorf("Shadow: couldn't write report: %v", err)
+	}
+}
+
+// copyState copies the state, so the copy can be changed without affecting the original
+func copyState(state *smtp.State) *smtp.State {
+	c := *state
+	c.Data = append([]byte{}, state.Data...)
+	c.To = make([]*smtp.MailAddress, len(state.To))
+	for i, to := range state.To {
+		address := *to
+		c.To[i] = &address
+	}
+	return &c
+}
+
+// compareStates lists the differences in recipients and header fields between both states.
+// Received header fields are ignored, since they contain a unique ID and the date,
+// and so are the header fields of the scanners, which only run in the active chain.
+func compareStates(active, candidate *smtp.State) []string {
+	differences := []string{}
+
+	for _, to := range difference(addresses(active.To), addresses(candidate.To)) {
+		differences = append(differences, "candidate drops recipient "+to)
+	}
+	for _, to := range difference(addresses(candidate.To), addresses(active.To)) {
+		differences = append(differences, "candidate keeps recipient "+to)
+	}
+
+	for _, field := range difference(headerFields(active.Data), headerFields(candidate.Data)) {
+		differences = append(differences, "candidate doesn't add '"+field+"'")
+	}
+	for _, field := range difference(headerFields(candidate.Data), headerFields(active.Data)) {
+		differences = append(differences, "candidate adds '"+field+"'")
+	}
+
+	return differences
+}
+
+func addresses(list []*smtp.MailAddress) []string {
+	s := make([]string, len(list))
+	for i, address := range list {
+		s[i] = address.Address
+	}
+	return s
+}
+
+// headerFields returns the (unfolded) header fields of the message, with their volatile values normalized
+func headerFields(data []byte) []string {
+	fields := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		fields = append(fields, line)
+	}
+
+	filtered := fields[:0]
+	for _, field := range fields {
+		if !ignoredFields[strings.ToLower(helpers.FieldName(field))] {
+			filtered = append(filtered, normalizeField(field))
+		}
+	}
+	return filtered
+}
+
+// normalizeField drops the values of a header field which differ every time it's generated, even if both chains
+// decided the same: the generated Message-ID and Date, and the time and signature of a DKIM-Signature
+func normalizeField(field string) string {
+	name := helpers.FieldName(field)
+	switch strings.ToLower(name) {
+	case "message-id", "date":
+		return name + ":"
+	case "dkim-signature":
+		tags := strings.Split(helpers.FieldValue(field), ";")
+		for i, tag := range tags {
+			tag = strings.TrimSpace(tag)
+			for _, volatile := range []string{"t", "x", "b"} {
+				if strings.HasPrefix(strings.ReplaceAll(tag, " ", ""), volatile+"=") {
+					tag = volatile + "="
+				}
+			}
+			tags[i] = tag
+		}
+		return name + ": " + strings.Join(tags, "; ")
+	}
+	return field
+}
+
+// ignoredFields are the header fields which aren't compared
+var ignoredFields = map[string]bool{
+	"received":               true,
+	"authentication-results": true,
+	"x-dnsbl":                true,
+	"x-virus-scanned":        true,
+	"x-virus-found":          true,
+	"x-spam-score":           true,
+	"x-spam-status":          true,
+}
+
+// difference returns the elements of a which are not in b
+func difference(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, s := range b {
+		in[s] = true
+	}
+	diff := []string{}
+	for _, s := range a {
+		if !in[s] {
+			diff = append(diff, s)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type HeaderHandler struct {
+	Header string
+}
+
+func (hh *HeaderHandler) Handle(state *smtp.State) {
+	state.Data = append([]byte(hh.Header+"\r\n"), state.Data...)
+}
+
+// LoginHandler records the login of the session of the message
+type LoginHandler struct {
+	Config *config.Config
+	Login  string
+}
+
+func (lh *LoginHandler) Handle(state *smtp.State) {
+	lh.Login, _ = lh.Config.Logins.Get(state.SessionId.String())
+}
+
+func TestShadow(t *testing.T) {
+
+	Convey("Testing Shadow handler", t, func() {
+
+		shadow := Shadow{
+			Active: &HandlerMachanism{
+				Handlers: []Handler{
+					&HeaderHandler{Header: "Received: from somewhere"},
+					&HeaderHandler{Header: "X-Spam: no"},
+				},
+			},
+			Candidate: &HandlerMachanism{
+				Handlers: []Handler{
+					&HeaderHandler{Header: "Received: from somewhere else"},
+					&HeaderHandler{Header: "X-Spam: yes"},
+					&DropHandler{},
+				},
+			},
+		}
+
+		state := smtp.State{
+			To:   []*smtp.MailAddress{&smtp.MailAddress{Address: "to@test.com"}},
+			Data: []byte("Subject: test\r\n\r\nHello world!"),
+		}
+		candidateState := copyState(&state)
+
+		shadow.Handle(&state)
+
+		// the message isn't affected by the candidate
+		So(len(state.To), ShouldEqual, 1)
+		So(string(state.Data), ShouldEqual, "X-Spam: no\r\nReceived: from somewhere\r\nSubject: test\r\n\r\nHello world!")
+
+		shadow.Candidate.Handle(candidateState)
+		So(compareStates(&state, candidateState), ShouldResemble, []string{
+			"candidate drops recipient to@test.com",
+			"candidate doesn't add 'X-Spam: no'",
+			"candidate adds 'X-Spam: yes'",
+		})
+
+		// the header fields of the scanners, which the candidate doesn't run, aren't compared
+		state.Data = append([]byte("X-Spam-Score: 3.0\r\nAuthentication-Results: example.com; spf=pass\r\n"), state.Data...)
+		So(compareStates(&state, candidateState), ShouldResemble, []string{
+			"candidate drops recipient to@test.com",
+			"candidate doesn't add 'X-Spam: no'",
+			"candidate adds 'X-Spam: yes'",
+		})
+
+		// the generated values differ every time, only their presence is compared
+		active := &smtp.State{Data: []byte("Message-ID: <1@example.com>\r\nDate: Wed, 5 Oct 2016 14:57:46 +0200\r\n" +
+			"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=mail; t=1475672266;\r\n\tbh=abc; b=signature1\r\n\r\nHello")}
+		candidate := &smtp.State{Data: []byte("Message-ID: <2@example.com>\r\nDate: Wed, 5 Oct 2016 14:57:47 +0200\r\n" +
+			"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=mail; t=1475672267; bh=abc; b=signature2\r\n\r\nHello")}
+		So(compareStates(active, candidate), ShouldBeEmpty)
+		candidate.Data = []byte("Message-ID: <2@example.com>\r\n\r\nHello")
+		So(compareStates(active, candidate), ShouldResemble, []string{
+			"candidate doesn't add 'DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=mail; t=; bh=abc; b='",
+			"candidate doesn't add 'Date:'",
+		})
+	})
+
+	Convey("Testing Shadow with the state of the session", t, func() {
+		c, candidate := &config.Config{}, &config.Config{}
+		handler := &LoginHandler{Config: candidate}
+		shadow := Shadow{
+			Active:          &HandlerMachanism{},
+			Candidate:       &HandlerMachanism{Handlers: []Handler{handler}},
+			Config:          c,
+			CandidateConfig: candidate,
+		}
+		state := &smtp.State{To: []*smtp.MailAddress{{Address: "to@test.com"}}, Data: []byte("Subject: test\r\n\r\nHello")}
+		c.Logins.Set(state.SessionId.String(), "bob@example.com")
+		c.Dsn.Set(state.SessionId.String(), "to@test.com", nil, map[string]string{"NOTIFY": "NEVER"})
+
+		shadow.Handle(state)
+		// the candidate sees the login, which it forgets afterwards
+		So(handler.Login, ShouldEqual, "bob@example.com")
+		_, found := candidate.Logins.Get(state.SessionId.String())
+		So(found, ShouldBeFalse)
+		_, found = candidate.Dsn.Get(state.SessionId.String(), "to@test.com")
+		So(found, ShouldBeFalse)
+	})
+
+}
@@ -0,0 +1,64 @@
+package spam
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *Spam {
+	return &Spam{
+		config: c,
+	}
+}
+
+// Spam sums up the scores of the spam scanners which ran (DNSBL, SPF, ClamAV) in the X-Spam-Score
+// and X-Spam-Status header fields. The spam header fields the message already had are removed,
+// so senders can't pass them off as ours. The thresholds of the users are applied at delivery.
+type Spam struct {
+	config *config.Config
+}
+
+func (handler *Spam) Handle(state *smtp.State) {
+	tests := handler.config.SpamScores.Take(state.SessionId.String())
+	score := 0.0
+	names := []string{}
+	for _, test := range tests {
+		score += test.Score
+		names = append(names, test.Name)
+	}
+	if len(names) == 0 {
+		names = append(names, "none")
+	}
+
+	tag, _ := handler.config.Spam.Thresholds(nil)
+	status := "No"
+	if score >= tag {
+		status = "Yes"
+	}
+
+	buffer := bytes.Buffer{}
+	fmt.Fprintf(&buffer, "X-Spam-Score: %.1f\r\n", score)
+	fmt.Fprintf(&buffer, "X-Spam-Status: %s, score=%.1f required=%.1f tests=%s\r\n", status, score, tag, strings.Join(names, ","))
+
+	fields, body := helpers.SplitHeader(state.Data)
+	for _, field := range fields {
+		switch strings.ToLower(helpers.FieldName(field)) {
+		case "x-spam-score", "x-spam-status", "x-spam-flag":
+			continue
+		}
+		buffer.WriteString(field)
+	}
+	buffer.Write(body)
+	state.Data = buffer.Bytes()
+
+	log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	}).Infof("Spam: score %.1f (%s)", score, strings.Join(names, ","))
+}
@@ -0,0 +1,39 @@
+package spam
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSpam(t *testing.T) {
+
+	Convey("Testing Spam handler", t, func() {
+		c := &config.Config{}
+		h := New(c)
+
+		state := &smtp.State{
+			Data: []byte("X-Spam-Score: -100\r\nX-Spam-Flag: NO\r\nSubject: test\r\n\r\nX-Spam-Score: body\r\n"),
+			Ip:   net.ParseIP("192.0.2.1"),
+		}
+		h.Handle(state)
+		So(string(state.Data), ShouldEqual, "X-Spam-Score: 0.0\r\n"+
+			"X-Spam-Status: No, score=0.0 required=5.0 tests=none\r\n"+
+			"Subject: test\r\n\r\nX-Spam-Score: body\r\n")
+
+		c.SpamScores.Add(state.SessionId.String(), "DNSBL_zen.example", 3)
+		c.SpamScores.Add(state.SessionId.String(), "SPF_FAIL", 2.5)
+		h.Handle(state)
+		So(string(state.Data), ShouldStartWith, "X-Spam-Score: 5.5\r\n"+
+			"X-Spam-Status: Yes, score=5.5 required=5.0 tests=DNSBL_zen.example,SPF_FAIL\r\n"+
+			"Subject: test\r\n")
+
+		// the scores are taken
+		So(c.SpamScores.Take(state.SessionId.String()), ShouldBeNil)
+	})
+
+}
@@ -4,36 +4,31 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
 	"github.com/gopistolet/gopistolet/log"
 	"github.com/gopistolet/gospf"
 	"github.com/gopistolet/gospf/dns"
-	"github.com/gopistolet/smtp/mta"
 	"github.com/gopistolet/smtp/smtp"
 )
 
-func New(c *mta.Config) *Spf {
+func New(c *config.Config) *Spf {
 	return &Spf{
 		config: c,
 	}
 }
 
 type Spf struct {
-	config *mta.Config
+	config *config.Config
 }
 
 func (handler *Spf) Handle(state *smtp.State) {
-	// create SPF instance
-	spf, err := gospf.New(state.From.GetDomain(), &dns.GoSPFDNS{})
-	if err != nil {
-		log.WithFields(log.Fields{
-			"Ip":        state.Ip.String(),
-			"SessionId": state.SessionId.String(),
-		}).Infof("Could not create spf: %v", err)
+	// trusted clients skip the spam checks
+	if handler.config.Access.Trusted(state.Ip) {
 		return
 	}
 
-	// check the given IP on that instance
-	check, err := spf.CheckIP(state.Ip.String())
+	check, err := Check(state)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"Ip":        state.Ip.String(),
@@ -54,5 +49,25 @@ func (handler *Spf) Handle(state *smtp.State) {
 	// Authentication-Results: receiver.example.org; spf=pass smtp.mailfrom=example.com;
 	headerField := fmt.Sprintf("Authentication-Results: %s; spf=%s smtp.mailfrom=%s;\r\n", handler.config.Hostname, strings.ToLower(check), state.From.GetDomain())
 	state.Data = append([]byte(headerField), state.Data...)
+	handler.config.AuthResults.Add(state.SessionId.String(), helpers.AuthResult{Method: "spf", Result: strings.ToLower(check), Domain: state.From.GetDomain()})
+
+	if score, found := handler.config.Spam.SpfScores[check]; found {
+		handler.config.SpamScores.Add(state.SessionId.String(), "SPF_"+strings.ToUpper(check), score)
+	}
+}
+
+// Check returns the SPF result (e.g. "Pass") for the client IP and the MAIL FROM domain
+func Check(state *smtp.State) (string, error) {
+	// SPF records are published by domains, an address literal has none
+	if helpers.ParseAddressLiteral(state.From.GetDomain()) != nil {
+		return "None", nil
+	}
+
+	spf, err := gospf.New(state.From.GetDomain(), &dns.GoSPFDNS{})
+	if err != nil {
+		return "", fmt.Errorf("could not create spf: %v", err)
+	}
 
+	// IPv4-mapped IPv6 addresses must be checked as IPv4 addresses
+	return spf.CheckIP(helpers.CanonicalIp(state.Ip).String())
 }
@@ -0,0 +1,61 @@
+package srs
+
+import (
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/handlers/queue"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *Srs {
+	return &Srs{
+		config: c,
+		send:   queue.Send,
+	}
+}
+
+// Srs sends bounces to the SRS addresses of forwarded mail back to the original senders (see helpers.Srs).
+// Addresses with an invalid hash or which have expired are dropped, so the SRS domain can't be used
+// as an open relay.
+type Srs struct {
+	config *config.Config
+
+	// send can be replaced for testing
+	send func(c *config.Config, from, to string, data []byte) error
+}
+
+func (handler *Srs) Handle(state *smtp.State) {
+	if !handler.config.Srs.Enabled() {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	from := ""
+	if state.From != nil {
+		from = state.From.Address
+	}
+
+	to := []*smtp.MailAddress{}
+	for _, recipient := range state.To {
+		if !handler.config.Srs.IsSrs(recipient.Address) {
+			to = append(to, recipient)
+			continue
+		}
+
+		sender, err := handler.config.Srs.Reverse(recipient.Address)
+		if err != nil {
+			logger.Warnf("Srs: dropped recipient %s: %v", recipient.Address, err)
+			continue
+		}
+		if err := handler.send(handler.config, from, sender, state.Data); err != nil {
+			logger.Errorf("Srs: couldn't send message for %s to %s: %v", recipient.Address, sender, err)
+			continue
+		}
+		logger.Infof("Srs: sent message for %s to %s", recipient.Address, sender)
+	}
+	state.To = to
+}
@@ -0,0 +1,55 @@
+package srs
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSrs(t *testing.T) {
+
+	Convey("Testing Srs handler", t, func() {
+		c := &config.Config{
+			LocalDomains: helpers.LocalDomains{"forwarder.example": {}},
+			Srs: helpers.Srs{
+				Domain:  "forwarder.example",
+				Secrets: []string{"secret"},
+			},
+		}
+		h := New(c)
+		sent := map[string]string{}
+		h.send = func(c *config.Config, from, to string, data []byte) error {
+			sent[to] = string(data)
+			return nil
+		}
+
+		address := c.Srs.Forward("bob@example.org")
+		state := &smtp.State{
+			To: []*smtp.MailAddress{
+				{Address: "alice@forwarder.example"},
+				{Address: address},
+				// a forged hash
+				{Address: "SRS0=XXXX" + address[len("SRS0=XXXX"):]},
+			},
+			Data: []byte("bounce"),
+			Ip:   net.ParseIP("192.0.2.1"),
+		}
+
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 1)
+		So(state.To[0].Address, ShouldEqual, "alice@forwarder.example")
+		So(sent, ShouldResemble, map[string]string{"bob@example.org": "bounce"})
+
+		// without secrets SRS addresses are delivered like other addresses
+		c.Srs.Secrets = nil
+		state.To = []*smtp.MailAddress{{Address: address}}
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 1)
+	})
+
+}
@@ -0,0 +1,165 @@
+package submission
+
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/gopistolet/user"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *Submission {
+	return &Submission{
+		config: c,
+	}
+}
+
+// Submission fixes up messages from submission clients (clients which may relay),
+// as RFC 6409 section 8 permits for a Message Submission Agent:
+// missing Message-ID, Date and From header fields are added.
+// Messages of authenticated users whose From header field has an address they may not send as are refused.
+type Submission struct {
+	config *config.Config
+}
+
+func (handler *Submission) Handle(state *smtp.State) {
+	if login, found := handler.config.Logins.Get(state.SessionId.String()); found {
+		// the session checked the envelope sender at MAIL already
+		u, err := LoginUser(handler.config.Users.Store, login)
+		if err == nil {
+			err = CheckSender(handler.config.Users.Store, u, state, true)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Ip":        state.Ip.String(),
+				"SessionId": state.SessionId.String(),
+			}).Warnf("Submission: refused message of %s: %v", login, err)
+			handler.config.Acceptance.Fail(state.SessionId.String(), err)
+			state.To = nil
+			return
+		}
+	}
+	if !handler.config.Submission || !handler.config.MayRelay(state) {
+		return
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(state.Data))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+		}).Warnf("Could not parse header of submitted message: %v", err)
+		return
+	}
+
+	headerFields := missingFields(msg.Header, state, handler.config.Hostname)
+	if headerFields == "" {
+		return
+	}
+	state.Data = append([]byte(headerFields), state.Data...)
+
+	log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	}).Debug("Added header fields to submitted message: '", headerFields, "'")
+}
+
+// missingFields returns the Message-ID, Date and From header fields which are missing from the header
+func missingFields(header mail.Header, state *smtp.State, hostname string) string {
+	headerFields := ""
+
+	// RFC 6409 section 8.3
+	if header.Get("Message-Id") == "" {
+		headerFields += "Message-ID: " + helpers.NewMessageId(hostname) + "\r\n"
+	}
+
+	// RFC 6409 section 8.2
+	if header.Get("Date") == "" {
+		headerFields += "Date: " + time.Now().Format(time.RFC1123Z) + "\r\n"
+	}
+
+	// a message without From header field is invalid (RFC 5322 section 3.6),
+	// use the envelope sender unless it's the null sender
+	sender := ""
+	if state.From != nil {
+		sender = state.From.Address
+	}
+	from, err := header.AddressList("From")
+	switch {
+	case err == mail.ErrHeaderNotPresent && sender != "":
+		headerFields += "From: <" + sender + ">\r\n"
+	case err == nil && sender != "" && !containsAddress(from, sender):
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+			"From":      sender,
+		}).Info("From header field doesn't match the envelope sender of submitted message")
+	}
+
+	return headerFields
+}
+
+// containsAddress reports whether the address is in the list (case insensitive)
+func containsAddress(list []*mail.Address, address string) bool {
+	for _, a := range list {
+		if strings.EqualFold(a.Address, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoginUser returns the user of the login of a session, a user without entries in the store
+// (e.g. of a client certificate) may only send as its own address
+func LoginUser(store user.UserStore, login string) (*user.User, error) {
+	if store == nil {
+		return &user.User{Name: login}, nil
+	}
+	u, err := store.Get(login)
+	if err != nil {
+		return nil, &helpers.EsmtpError{Code: 451, Message: "4.3.0 Could not check the sender"}
+	}
+	if u == nil {
+		return &user.User{Name: login}, nil
+	}
+	return u, nil
+}
+
+// CheckSender enforces that a submission client only sends as the authenticated user (see user.MaySendAs):
+// the envelope sender, and with header also the addresses in the From header field.
+// Spoofed senders get 553 (RFC 5321 section 4.2.2).
+func CheckSender(store user.UserStore, u *user.User, state *smtp.State, header bool) error {
+	senders := []string{}
+	if state.From != nil {
+		senders = append(senders, state.From.Address)
+	}
+	if header {
+		msg, err := mail.ReadMessage(bytes.NewReader(state.Data))
+		if err != nil {
+			return &helpers.EsmtpError{Code: 554, Message: "5.6.0 Could not parse the header"}
+		}
+		from, err := msg.Header.AddressList("From")
+		if err != nil && err != mail.ErrHeaderNotPresent {
+			return &helpers.EsmtpError{Code: 554, Message: "5.6.0 Invalid From header field"}
+		}
+		for _, address := range from {
+			senders = append(senders, address.Address)
+		}
+	}
+
+	for _, sender := range senders {
+		allowed, err := user.MaySendAs(store, u, sender)
+		if err != nil {
+			return &helpers.EsmtpError{Code: 451, Message: "4.3.0 Could not check the sender"}
+		}
+		if !allowed {
+			return &helpers.EsmtpError{Code: 553, Message: "5.7.1 Sender address " + sender + " not owned by " + u.Name}
+		}
+	}
+	return nil
+}
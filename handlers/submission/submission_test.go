@@ -0,0 +1,113 @@
+package submission
+
+import (
+	"encoding/json"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/user"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// aliasStore is a UserStore with only aliases
+type aliasStore map[string][]string
+
+func (s aliasStore) Get(name string) (*user.User, error)              { return nil, nil }
+func (s aliasStore) Authenticate(name, password string) (bool, error) { return false, nil }
+func (s aliasStore) HasDomain(domain string) (bool, error)            { return false, nil }
+func (s aliasStore) Alias(address string) ([]string, error)           { return s[address], nil }
+
+func TestSubmission(t *testing.T) {
+
+	Convey("Testing Submission handler", t, func() {
+		c := config.Config{Submission: true}
+		c.Hostname = "mail.example.com"
+		err := json.Unmarshal([]byte(`{"Relay": ["192.168.0.0/24"]}`), &c.Access)
+		So(err, ShouldEqual, nil)
+		h := New(&c)
+
+		newState := func(ip string, data string) *smtp.State {
+			return &smtp.State{
+				From: &smtp.MailAddress{Address: "from@example.com"},
+				To:   []*smtp.MailAddress{&smtp.MailAddress{Address: "to@test.com"}},
+				Ip:   net.ParseIP(ip),
+				Data: []byte(data),
+			}
+		}
+
+		// missing header fields are added
+		state := newState("192.168.0.10", "Subject: test\r\n\r\nHello world!")
+		h.Handle(state)
+		msg, err := mail.ReadMessage(strings.NewReader(string(state.Data)))
+		So(err, ShouldEqual, nil)
+		So(msg.Header.Get("Message-ID"), ShouldEndWith, "@mail.example.com>")
+		So(msg.Header.Get("From"), ShouldEqual, "<from@example.com>")
+		So(msg.Header.Get("Subject"), ShouldEqual, "test")
+		_, err = msg.Header.Date()
+		So(err, ShouldEqual, nil)
+
+		// existing header fields are kept
+		data := "From: Someone <someone@example.com>\r\nDate: Wed, 5 Oct 2016 14:57:46 +0200\r\nMessage-ID: <1@example.com>\r\n\r\nHello world!"
+		state = newState("192.168.0.10", data)
+		h.Handle(state)
+		So(string(state.Data), ShouldEqual, data)
+
+		// only messages from submission clients are fixed up
+		state = newState("10.0.0.1", "Subject: test\r\n\r\nHello world!")
+		h.Handle(state)
+		So(string(state.Data), ShouldEqual, "Subject: test\r\n\r\nHello world!")
+
+		// authenticated users may only use their addresses in the From header field
+		state = newState("192.168.0.10", "From: <from@example.com>\r\n\r\nHello world!")
+		c.Logins.Set(state.SessionId.String(), "from@example.com")
+		h.Handle(state)
+		So(len(state.To), ShouldEqual, 1)
+		So(c.Acceptance.Take(state.SessionId.String()), ShouldEqual, nil)
+		state.Data = []byte("From: <ceo@example.com>\r\n\r\nHello world!")
+		h.Handle(state)
+		So(state.To, ShouldBeEmpty)
+		err = c.Acceptance.Take(state.SessionId.String())
+		So(err, ShouldNotEqual, nil)
+		So(err.(*helpers.EsmtpError).Code, ShouldEqual, 553)
+	})
+
+	Convey("Testing CheckSender()", t, func() {
+		store := aliasStore{"info@example.com": {"bob@example.com", "alice@example.com"}}
+		bob := &user.User{Name: "bob@example.com"}
+		newState := func(from, header string) *smtp.State {
+			return &smtp.State{
+				From: &smtp.MailAddress{Address: from},
+				Data: []byte("From: " + header + "\r\nSubject: test\r\n\r\nHello"),
+			}
+		}
+		code := func(err error) int {
+			if err == nil {
+				return 0
+			}
+			return err.(*helpers.EsmtpError).Code
+		}
+
+		So(code(CheckSender(store, bob, newState("Bob@example.com", "<bob@example.com>"), true)), ShouldEqual, 0)
+		So(code(CheckSender(store, bob, newState("info@example.com", "Info <info@example.com>"), true)), ShouldEqual, 0)
+		So(code(CheckSender(store, bob, newState("", "<bob@example.com>"), true)), ShouldEqual, 0)
+		So(code(CheckSender(store, bob, newState("carol@example.com", "<bob@example.com>"), true)), ShouldEqual, 553)
+
+		// the header is only checked on request
+		So(code(CheckSender(store, bob, newState("bob@example.com", "<carol@example.com>"), false)), ShouldEqual, 0)
+		So(code(CheckSender(store, bob, newState("bob@example.com", "<carol@example.com>"), true)), ShouldEqual, 553)
+
+		// send-as permissions
+		bob.SendAs = []string{"@lists.example.com", "noreply@example.org"}
+		So(code(CheckSender(store, bob, newState("team@lists.example.com", "<noreply@example.org>"), true)), ShouldEqual, 0)
+		So(code(CheckSender(store, bob, newState("carol@example.com", "<bob@example.com>"), true)), ShouldEqual, 553)
+		bob.SendAs = []string{"*"}
+		So(code(CheckSender(store, bob, newState("carol@example.com", "<carol@example.com>"), true)), ShouldEqual, 0)
+	})
+
+}
@@ -0,0 +1,167 @@
+package tlsrpt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/client"
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/handlers/queue"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+func New(c *config.Config) *TlsRpt {
+	return &TlsRpt{
+		config: c,
+	}
+}
+
+// TlsRpt parses the SMTP TLS reports (RFC 8460) which are sent to our reporting address
+// and logs their summaries. The reports are delivered as usual.
+type TlsRpt struct {
+	config *config.Config
+}
+
+func (handler *TlsRpt) Handle(state *smtp.State) {
+	address := strings.ToLower(handler.config.TlsRptAddress)
+	if address == "" {
+		return
+	}
+
+	forUs := false
+	for _, to := range state.To {
+		if strings.ToLower(to.Address) == address {
+			forUs = true
+		}
+	}
+	if !forUs {
+		return
+	}
+
+	reports, err := ParseReports(state.Data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+		}).Warnf("TLSRPT: couldn't parse report: %v", err)
+		return
+	}
+
+	for _, report := range reports {
+		for _, p := range report.Policies {
+			log.WithFields(log.Fields{
+				"Ip":           state.Ip.String(),
+				"SessionId":    state.SessionId.String(),
+				"Organization": report.OrganizationName,
+				"ReportId":     report.ReportId,
+				"Domain":       p.Policy.PolicyDomain,
+				"PolicyType":   p.Policy.PolicyType,
+				"Successful":   p.Summary.TotalSuccessfulSessionCount,
+				"Failed":       p.Summary.TotalFailureSessionCount,
+			}).Info("TLSRPT: received report")
+		}
+	}
+}
+
+// SendReports sends the TLS reports of the deliveries since the last call to the recipient domains
+// which ask for them in their TLSRPT record, by HTTPS or by mail
+func SendReports(c *config.Config) {
+	reporting := &c.Outbound.TlsRpt
+	if reporting.Organization == "" {
+		return
+	}
+	for domain, report := range reporting.Collector.Reports(reporting.Organization, reporting.Contact) {
+		uris, err := client.LookupTlsRpt(domain)
+		if err != nil {
+			// most domains don't ask for reports
+			continue
+		}
+		for _, uri := range uris {
+			if err := sendReport(c, report, domain, uri); err != nil {
+				log.Warnf("TLSRPT: couldn't send the report for %s to %s: %v", domain, uri, err)
+			}
+		}
+	}
+}
+
+// sendReport sends the report to a reporting URI
+func sendReport(c *config.Config, report *client.TlsReport, domain, uri string) error {
+	if !strings.HasPrefix(uri, "mailto:") {
+		return client.SendTlsReport(report, uri)
+	}
+	to := strings.SplitN(uri[len("mailto:"):], "?", 2)[0]
+	to, err := url.PathUnescape(to)
+	if err != nil {
+		return err
+	}
+	from := c.Outbound.TlsRpt.Contact
+	if from == "" {
+		from = "postmaster@" + c.Hostname
+	}
+	message, err := client.TlsReportMessage(report, domain, c.Hostname, from, to)
+	if err != nil {
+		return err
+	}
+	return queue.Send(c, from, to, message)
+}
+
+// ParseReports returns the TLS reports attached to the message
+func ParseReports(data []byte) ([]*client.TlsReport, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return parsePart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+}
+
+func parsePart(contentType, encoding string, body io.Reader) ([]*client.TlsReport, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reports := []*client.TlsReport{}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return reports, err
+			}
+			r, err := parsePart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return reports, err
+			}
+			reports = append(reports, r...)
+		}
+		return reports, nil
+	}
+
+	if mediaType != "application/tlsrpt+gzip" && mediaType != "application/tlsrpt+json" {
+		return nil, nil
+	}
+
+	if strings.ToLower(strings.TrimSpace(encoding)) == "base64" {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	report, err := client.ParseTlsReport(content)
+	if err != nil {
+		return nil, err
+	}
+	return []*client.TlsReport{report}, nil
+}
@@ -0,0 +1,71 @@
+package tlsrpt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"testing"
+
+	"github.com/gopistolet/gopistolet/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseReports(t *testing.T) {
+
+	Convey("Testing ParseReports()", t, func() {
+		report := `{"organization-name":"Company-X","date-range":{"start-datetime":"2016-04-01T00:00:00Z","end-datetime":"2016-04-01T23:59:59Z"},` +
+			`"contact-info":"sts-reporting@company-x.example","report-id":"5065427c-23d3-47ca-b6e0-946ea0e8c4be",` +
+			`"policies":[{"policy":{"policy-type":"sts","policy-domain":"example.net"},"summary":{"total-successful-session-count":5326,"total-failure-session-count":303}}]}`
+
+		buffer := &bytes.Buffer{}
+		writer := gzip.NewWriter(buffer)
+		writer.Write([]byte(report))
+		writer.Close()
+
+		message := "From: tlsrpt@mail.sender.example.com\r\n" +
+			"Subject: Report Domain: example.net\r\n" +
+			"MIME-Version: 1.0\r\n" +
+			"Content-Type: multipart/report; report-type=\"tlsrpt\"; boundary=\"----=_NextPart_000_024E_01CC9B0A.AFE54C00\"\r\n" +
+			"\r\n" +
+			"------=_NextPart_000_024E_01CC9B0A.AFE54C00\r\n" +
+			"Content-Type: text/plain; charset=\"us-ascii\"\r\n" +
+			"\r\n" +
+			"This is an aggregate TLS report from mail.sender.example.com\r\n" +
+			"------=_NextPart_000_024E_01CC9B0A.AFE54C00\r\n" +
+			"Content-Type: application/tlsrpt+gzip\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"Content-Disposition: attachment; filename=\"report.json.gz\"\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString(buffer.Bytes()) + "\r\n" +
+			"------=_NextPart_000_024E_01CC9B0A.AFE54C00--\r\n"
+
+		reports, err := ParseReports([]byte(message))
+		So(err, ShouldEqual, nil)
+		So(len(reports), ShouldEqual, 1)
+		So(reports[0].OrganizationName, ShouldEqual, "Company-X")
+		So(reports[0].Policies[0].Policy.PolicyDomain, ShouldEqual, "example.net")
+		So(reports[0].Policies[0].Summary.TotalFailureSessionCount, ShouldEqual, 303)
+
+		reports, err = ParseReports([]byte("Subject: test\r\n\r\nHello world!"))
+		So(err, ShouldEqual, nil)
+		So(len(reports), ShouldEqual, 0)
+	})
+
+	Convey("Testing the reports sent by mail", t, func() {
+		collector := &client.TlsRptCollector{}
+		collector.Success("example.net", client.PolicySts, []string{"version: STSv1", "mode: enforce"}, "mx.example.net")
+		collector.Failure("example.net", client.PolicySts, nil, "mx2.example.net", client.TlsFailureDetails{ResultType: "starttls-not-supported"})
+		report := collector.Reports("Satellite Inc.", "tlsrpt@example.com")["example.net"]
+
+		message, err := client.TlsReportMessage(report, "example.net", "satellite.example.com", "tlsrpt@example.com", "reports@example.net")
+		So(err, ShouldEqual, nil)
+		So(string(message), ShouldContainSubstring, "TLS-Report-Domain: example.net\r\n")
+		reports, err := ParseReports(message)
+		So(err, ShouldEqual, nil)
+		So(len(reports), ShouldEqual, 1)
+		So(reports[0].OrganizationName, ShouldEqual, "Satellite Inc.")
+		So(reports[0].Policies[0].Summary.TotalSuccessfulSessionCount, ShouldEqual, 1)
+		So(reports[0].Policies[0].Summary.TotalFailureSessionCount, ShouldEqual, 1)
+	})
+
+}
@@ -0,0 +1,136 @@
+package helpers
+
+import (
+	"sync"
+	"time"
+)
+
+// acceptanceTTL is how long a transaction is kept for the reply to the DATA command
+const acceptanceTTL = time.Hour
+
+// Acceptance keeps the outcome of the transactions for the reply after DATA, keyed by session ID.
+// Transactions whose message couldn't be stored durably get a temporary failure instead of the 250,
+// so the client keeps the message to retry it later (RFC 5321 section 6.1).
+// If the client negotiated PRDR, every recipient gets a reply of its own,
+// so the handlers can refuse the message for some of them instead of bouncing it.
+//
+// The client retries a failed transaction as a whole, so what the handlers stored
+// for it before the failure is taken back (see Undo), the recipients don't get the message twice.
+type Acceptance struct {
+	mutex        sync.Mutex
+	transactions map[string]*acceptanceTransaction
+}
+
+type acceptanceTransaction struct {
+	err error
+	// prdr is true if the recipients get a reply each, rejected contains the replies of the refused ones
+	prdr     bool
+	rejected map[string]string
+	// undo takes back what the handlers stored, if the transaction fails
+	undo    []func()
+	expires time.Time
+}
+
+// transaction returns the transaction of the session, a new one if create is true. The mutex must be locked.
+func (a *Acceptance) transaction(sessionId string, create bool) *acceptanceTransaction {
+	if transaction, found := a.transactions[sessionId]; found || !create {
+		return transaction
+	}
+	if a.transactions == nil {
+		a.transactions = make(map[string]*acceptanceTransaction)
+	}
+	now := time.Now()
+	for id, transaction := range a.transactions {
+		if now.After(transaction.expires) {
+			delete(a.transactions, id)
+		}
+	}
+	transaction := &acceptanceTransaction{rejected: make(map[string]string), expires: now.Add(acceptanceTTL)}
+	a.transactions[sessionId] = transaction
+	return transaction
+}
+
+// Fail records that the message of the transaction couldn't be stored
+func (a *Acceptance) Fail(sessionId string, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	transaction := a.transaction(sessionId, true)
+	if transaction.err == nil {
+		transaction.err = err
+	}
+}
+
+// Undo registers a function which takes back what a handler stored for the transaction
+// (e.g. removes a file), Take runs it if the transaction fails
+func (a *Acceptance) Undo(sessionId string, undo func()) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	transaction := a.transaction(sessionId, true)
+	transaction.undo = append(transaction.undo, undo)
+}
+
+// Prdr records whether the client asked for a reply per recipient (PRDR) in the transaction
+func (a *Acceptance) Prdr(sessionId string, negotiated bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if transaction := a.transaction(sessionId, negotiated); transaction != nil {
+		transaction.prdr = negotiated
+	}
+}
+
+// Reject records the reply (e.g. "550 5.1.1 User unknown") for a recipient which refuses the message.
+// It returns false if the client didn't negotiate PRDR, the message has to be bounced then.
+func (a *Acceptance) Reject(sessionId, recipient, reply string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	transaction := a.transaction(sessionId, false)
+	if transaction == nil || !transaction.prdr {
+		return false
+	}
+	transaction.rejected[recipient] = reply
+	return true
+}
+
+// Rejected returns the replies of the recipients which refused the message, by recipient
+func (a *Acceptance) Rejected(sessionId string) map[string]string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	rejected := make(map[string]string)
+	if transaction := a.transaction(sessionId, false); transaction != nil {
+		for recipient, reply := range transaction.rejected {
+			rejected[recipient] = reply
+		}
+	}
+	return rejected
+}
+
+// Failed returns the first failure of the transaction, nil if its message was stored so far
+func (a *Acceptance) Failed(sessionId string) error {
+	if a == nil {
+		return nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if transaction := a.transaction(sessionId, false); transaction != nil {
+		return transaction.err
+	}
+	return nil
+}
+
+// Take returns the first failure of the transaction, nil if its message was stored, and forgets the transaction.
+// If it failed, what the handlers stored for it is taken back.
+func (a *Acceptance) Take(sessionId string) error {
+	a.mutex.Lock()
+	transaction := a.transaction(sessionId, false)
+	delete(a.transactions, sessionId)
+	a.mutex.Unlock()
+	if transaction == nil {
+		return nil
+	}
+	if transaction.err != nil {
+		for _, undo := range transaction.undo {
+			undo()
+		}
+	}
+	return transaction.err
+}
@@ -0,0 +1,57 @@
+package helpers
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAcceptance(t *testing.T) {
+
+	Convey("Testing Acceptance", t, func() {
+		a := &Acceptance{}
+		So(a.Take("1"), ShouldEqual, nil)
+
+		disk := errors.New("no space left on device")
+		a.Fail("1", disk)
+		a.Fail("1", errors.New("read-only file system"))
+		So(a.Failed("1"), ShouldEqual, disk)
+		So(a.Failed("2"), ShouldEqual, nil)
+		So(a.Take("2"), ShouldEqual, nil)
+		So(a.Take("1"), ShouldEqual, disk)
+		// the next message of the session is acknowledged again
+		So(a.Take("1"), ShouldEqual, nil)
+
+		// what was stored is only taken back if the transaction fails
+		undone := 0
+		a.Undo("1", func() { undone++ })
+		So(a.Take("1"), ShouldEqual, nil)
+		So(undone, ShouldEqual, 0)
+		a.Undo("1", func() { undone++ })
+		a.Fail("1", disk)
+		So(a.Take("1"), ShouldEqual, disk)
+		So(undone, ShouldEqual, 1)
+	})
+
+	Convey("Testing Acceptance with PRDR", t, func() {
+		a := &Acceptance{}
+		// without PRDR the recipients can't refuse the message
+		So(a.Reject("1", "bob@example.com", "550 5.1.1 User unknown"), ShouldEqual, false)
+		a.Prdr("1", false)
+		So(a.Reject("1", "bob@example.com", "550 5.1.1 User unknown"), ShouldEqual, false)
+
+		a.Prdr("1", true)
+		So(a.Reject("1", "bob@example.com", "550 5.1.1 User unknown"), ShouldEqual, true)
+		So(a.Reject("1", "eve@example.com", "552 5.2.2 Mailbox full"), ShouldEqual, true)
+		So(a.Rejected("2"), ShouldBeEmpty)
+		So(a.Rejected("1"), ShouldResemble, map[string]string{
+			"bob@example.com": "550 5.1.1 User unknown",
+			"eve@example.com": "552 5.2.2 Mailbox full",
+		})
+		So(a.Take("1"), ShouldEqual, nil)
+		So(a.Rejected("1"), ShouldBeEmpty)
+		So(a.Reject("1", "bob@example.com", "550 5.1.1 User unknown"), ShouldEqual, false)
+	})
+
+}
@@ -0,0 +1,105 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+)
+
+// AccessLists decides what clients are allowed to do based on the network they're in.
+// The lists can be replaced at runtime with Set.
+type AccessLists struct {
+	mutex sync.RWMutex
+	lists accessLists
+}
+
+type accessLists struct {
+	// Clients in Deny may not connect, if Allow isn't empty only clients in Allow may connect
+	Allow Networks
+	Deny  Networks
+	// Clients in Relay may relay without authentication
+	Relay Networks
+	// Clients in Trusted skip the spam checks
+	Trusted Networks
+}
+
+// MayConnect reports whether the client may connect
+func (a *AccessLists) MayConnect(ip net.IP) bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.lists.Deny.Contains(ip) {
+		return false
+	}
+	return len(a.lists.Allow) == 0 || a.lists.Allow.Contains(ip)
+}
+
+// MayRelay reports whether the client may relay without authentication
+func (a *AccessLists) MayRelay(ip net.IP) bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.lists.Relay.Contains(ip)
+}
+
+// Trusted reports whether the client may skip the spam checks
+func (a *AccessLists) Trusted(ip net.IP) bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.lists.Trusted.Contains(ip)
+}
+
+// Blacklist returns a Blacklist which blacklists the clients that may not connect
+// and checks all other clients, except the trusted ones, in the given blacklist
+func (a *AccessLists) Blacklist(bl Blacklist) Blacklist {
+	return &accessBlacklist{
+		access:    a,
+		blacklist: bl,
+	}
+}
+
+type accessBlacklist struct {
+	access    *AccessLists
+	blacklist Blacklist
+}
+
+func (a *accessBlacklist) CheckIp(ip string) bool {
+	parsed := ParseIp(ip)
+	if parsed == nil {
+		return false
+	}
+	if !a.access.MayConnect(parsed) {
+		return true
+	}
+	if a.access.Trusted(parsed) || a.blacklist == nil {
+		return false
+	}
+	return a.blacklist.CheckIp(ip)
+}
+
+// Set replaces the lists with the ones from other
+func (a *AccessLists) Set(other *AccessLists) {
+	other.mutex.RLock()
+	lists := other.lists
+	other.mutex.RUnlock()
+
+	a.mutex.Lock()
+	a.lists = lists
+	a.mutex.Unlock()
+}
+
+func (a *AccessLists) UnmarshalJSON(data []byte) error {
+	lists := accessLists{}
+	err := json.Unmarshal(data, &lists)
+	if err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	a.lists = lists
+	a.mutex.Unlock()
+	return nil
+}
+
+func (a *AccessLists) MarshalJSON() ([]byte, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return json.Marshal(a.lists)
+}
@@ -0,0 +1,49 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccessLists(t *testing.T) {
+
+	Convey("Testing AccessLists", t, func() {
+		a := AccessLists{}
+		err := json.Unmarshal([]byte(`{
+			"Deny": ["192.168.66.0/24"],
+			"Relay": ["192.168.0.0/24"],
+			"Trusted": ["192.168.0.10"]
+		}`), &a)
+		So(err, ShouldEqual, nil)
+
+		So(a.MayConnect(net.ParseIP("192.168.66.1")), ShouldEqual, false)
+		So(a.MayConnect(net.ParseIP("8.8.8.8")), ShouldEqual, true)
+
+		bl := a.Blacklist(&Nixspam{IpList: []string{"192.168.0.10", "192.168.0.11"}})
+		So(bl.CheckIp("192.168.66.1"), ShouldEqual, true)
+		So(bl.CheckIp("8.8.8.8"), ShouldEqual, false)
+		So(bl.CheckIp("192.168.0.11"), ShouldEqual, true)
+		// trusted clients aren't checked in the blacklist
+		So(bl.CheckIp("192.168.0.10"), ShouldEqual, false)
+
+		So(a.MayRelay(net.ParseIP("192.168.0.11")), ShouldEqual, true)
+		So(a.MayRelay(net.ParseIP("8.8.8.8")), ShouldEqual, false)
+
+		So(a.Trusted(net.ParseIP("192.168.0.10")), ShouldEqual, true)
+		So(a.Trusted(net.ParseIP("192.168.0.11")), ShouldEqual, false)
+
+		// reload with an allow list
+		b := AccessLists{}
+		err = json.Unmarshal([]byte(`{"Allow": ["10.0.0.0/8"]}`), &b)
+		So(err, ShouldEqual, nil)
+		a.Set(&b)
+
+		So(a.MayConnect(net.ParseIP("10.1.2.3")), ShouldEqual, true)
+		So(a.MayConnect(net.ParseIP("8.8.8.8")), ShouldEqual, false)
+		So(a.MayRelay(net.ParseIP("192.168.0.11")), ShouldEqual, false)
+	})
+
+}
@@ -0,0 +1,221 @@
+package helpers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAliasDepth limits the number of nested aliases
+const maxAliasDepth = 10
+
+// Aliases expands recipients to one or more targets: local names (other aliases or mailboxes),
+// addresses or pipes to a command ("|/usr/bin/procmail").
+// Aliases are keyed by address (bob@example.com) or by local part (bob, for all domains).
+//
+// The File has the format of /etc/aliases:
+//
+//	# comment
+//	postmaster: root
+//	root: bob, alice@example.org
+//	tickets: "|/usr/local/bin/create-ticket"
+//
+// Aliases in Map (from the config file) are combined with the ones from File.
+// A File with errors doesn't replace the aliases which were loaded before.
+type Aliases struct {
+	File string
+	Map  map[string][]string
+
+	mutex   sync.RWMutex
+	aliases map[string][]string
+	modTime time.Time
+}
+
+// CheckAliases checks that all aliases have a name and targets
+func CheckAliases(aliases map[string][]string) error {
+	for name, targets := range aliases {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("alias without name")
+		}
+		if len(targets) == 0 {
+			return fmt.Errorf("alias %s has no targets", name)
+		}
+	}
+	return nil
+}
+
+// Load (re)loads the aliases from File if it has changed
+func (a *Aliases) Load() error {
+	if a.File == "" {
+		return nil
+	}
+
+	info, err := os.Stat(a.File)
+	if err != nil {
+		return err
+	}
+	a.mutex.RLock()
+	unchanged := a.aliases != nil && info.ModTime().Equal(a.modTime)
+	a.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	file, err := os.Open(a.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	aliases := make(map[string][]string)
+	lineNumber := 0
+	name := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		// lines starting with whitespace continue the previous alias
+		if line[0] == ' ' || line[0] == '\t' {
+			if name == "" {
+				return fmt.Errorf("%s:%d: continuation line without alias", a.File, lineNumber)
+			}
+			aliases[name] = append(aliases[name], splitTargets(line)...)
+			continue
+		}
+
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return fmt.Errorf("%s:%d: missing ':'", a.File, lineNumber)
+		}
+		name = strings.ToLower(strings.TrimSpace(line[:i]))
+		aliases[name] = append(aliases[name], splitTargets(line[i+1:])...)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := CheckAliases(aliases); err != nil {
+		return fmt.Errorf("%s: %v", a.File, err)
+	}
+
+	a.mutex.Lock()
+	a.aliases = aliases
+	a.modTime = info.ModTime()
+	a.mutex.Unlock()
+
+	return nil
+}
+
+// splitTargets splits a comma separated list of targets, quotes (around pipes) are removed
+func splitTargets(s string) []string {
+	targets := []string{}
+	for _, target := range strings.Split(s, ",") {
+		target = strings.Trim(strings.TrimSpace(target), `"`)
+		if target != "" {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// SetMap replaces Map, it should be checked with CheckAliases
+func (a *Aliases) SetMap(aliases map[string][]string) {
+	a.mutex.Lock()
+	a.Map = aliases
+	a.mutex.Unlock()
+}
+
+// Len returns the number of aliases
+func (a *Aliases) Len() int {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return len(a.Map) + len(a.aliases)
+}
+
+// lookup returns the targets of the alias, and whether there is one
+func (a *Aliases) lookup(name string) ([]string, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	for alias, targets := range a.Map {
+		if strings.EqualFold(alias, name) {
+			return targets, true
+		}
+	}
+	name = strings.ToLower(name)
+	targets, found := a.aliases[name]
+	return targets, found
+}
+
+// Expand returns the targets to which mail for the address should be delivered:
+// addresses and pipes ("|command"). Addresses without alias expand to themselves.
+// Local names without domain get the domain of the address which is expanded.
+// An error is returned if the aliases loop.
+func (a *Aliases) Expand(address string) ([]string, error) {
+	targets := []string{}
+	seen := make(map[string]bool)
+	err := a.expand(address, 0, []string{}, seen, &targets)
+	return targets, err
+}
+
+func (a *Aliases) expand(address string, depth int, path []string, seen map[string]bool, targets *[]string) error {
+	key := strings.ToLower(address)
+	for _, p := range path {
+		if p == key {
+			return fmt.Errorf("alias loop: %s -> %s", strings.Join(path, " -> "), address)
+		}
+	}
+	if depth > maxAliasDepth {
+		return fmt.Errorf("aliases nested too deep: %s", strings.Join(path, " -> "))
+	}
+
+	local, domain := address, ""
+	if i := strings.LastIndexByte(address, '@'); i >= 0 {
+		local, domain = address[:i], address[i+1:]
+	}
+
+	expansion, found := a.lookup(address)
+	if !found {
+		expansion, found = a.lookup(local)
+	}
+	if !found {
+		if !seen[key] {
+			seen[key] = true
+			*targets = append(*targets, address)
+		}
+		return nil
+	}
+
+	path = append(path, key)
+	for _, target := range expansion {
+		switch {
+		case strings.HasPrefix(target, "|"):
+			if !seen[target] {
+				seen[target] = true
+				*targets = append(*targets, target)
+			}
+			continue
+		case !strings.Contains(target, "@") && domain != "":
+			target = target + "@" + domain
+		}
+
+		// an alias which includes itself delivers to the mailbox (e.g. bob: bob, bob@example.org)
+		if strings.EqualFold(target, address) {
+			if !seen[key] {
+				seen[key] = true
+				*targets = append(*targets, address)
+			}
+			continue
+		}
+
+		if err := a.expand(target, depth+1, path, seen, targets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAliases(t *testing.T) {
+
+	Convey("Testing Aliases.Load()", t, func() {
+		file, err := ioutil.TempFile("", "aliases")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(file.Name())
+		file.WriteString("# comment\n\nPostmaster: root\nroot: bob,\n\talice@example.org\ntickets: \"|/usr/local/bin/create-ticket\"\n")
+		file.Close()
+
+		a := Aliases{File: file.Name()}
+		So(a.Load(), ShouldEqual, nil)
+
+		targets, err := a.Expand("postmaster@example.com")
+		So(err, ShouldEqual, nil)
+		So(targets, ShouldResemble, []string{"bob@example.com", "alice@example.org"})
+
+		targets, err = a.Expand("tickets@example.com")
+		So(err, ShouldEqual, nil)
+		So(targets, ShouldResemble, []string{"|/usr/local/bin/create-ticket"})
+
+		ioutil.WriteFile(file.Name(), []byte("no colon\n"), 0644)
+		os.Chtimes(file.Name(), a.modTime.AddDate(0, 0, 1), a.modTime.AddDate(0, 0, 1))
+		So(a.Load(), ShouldNotEqual, nil)
+		// the old aliases are kept
+		targets, _ = a.Expand("postmaster@example.com")
+		So(len(targets), ShouldEqual, 2)
+
+		ioutil.WriteFile(file.Name(), []byte("postmaster: root\nroot:\n"), 0644)
+		os.Chtimes(file.Name(), a.modTime.AddDate(0, 0, 2), a.modTime.AddDate(0, 0, 2))
+		So(a.Load().Error(), ShouldEndWith, "alias root has no targets")
+		So(a.Len(), ShouldEqual, 3)
+	})
+
+	Convey("Testing Aliases.Expand()", t, func() {
+		a := Aliases{
+			Map: map[string][]string{
+				"bob@example.com": {"bob", "bob@example.org"},
+				"team":            {"bob", "alice", "bob@example.org"},
+				"loop1":           {"loop2"},
+				"loop2":           {"loop1"},
+			},
+		}
+
+		targets, err := a.Expand("nobody@example.com")
+		So(err, ShouldEqual, nil)
+		So(targets, ShouldResemble, []string{"nobody@example.com"})
+
+		// an alias which includes itself
+		targets, err = a.Expand("Bob@example.com")
+		So(err, ShouldEqual, nil)
+		So(targets, ShouldResemble, []string{"Bob@example.com", "bob@example.org"})
+
+		// duplicates are removed
+		targets, err = a.Expand("team@example.com")
+		So(err, ShouldEqual, nil)
+		So(targets, ShouldResemble, []string{"bob@example.com", "bob@example.org", "alice@example.com"})
+
+		_, err = a.Expand("loop1@example.com")
+		So(err, ShouldNotEqual, nil)
+	})
+
+}
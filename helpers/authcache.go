@@ -0,0 +1,114 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthCache remembers credentials which were verified recently, so clients which
+// reconnect often don't hit a slow authentication backend (LDAP, bcrypt, ...) every time.
+// Only successful verifications are cached, for TTL seconds (the cache is disabled if TTL is 0).
+// The passwords are stored as HMACs with a random key which never leaves the process.
+type AuthCache struct {
+	TTL int
+
+	// now is time.Now, it can be replaced for testing
+	now func() time.Time
+
+	mutex   sync.Mutex
+	key     []byte
+	entries map[string]authCacheEntry
+}
+
+type authCacheEntry struct {
+	mac     []byte
+	expires time.Time
+}
+
+func (a *AuthCache) time() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+// mac returns the HMAC of the credentials, the mutex must be locked
+func (a *AuthCache) mac(username, password string) []byte {
+	if a.key == nil {
+		a.key = make([]byte, 32)
+		if _, err := rand.Read(a.key); err != nil {
+			panic("couldn't read random bytes: " + err.Error())
+		}
+	}
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(username))
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	return h.Sum(nil)
+}
+
+// Verify checks the credentials in the cache, or with the backend if they aren't cached.
+// Credentials which are accepted by the backend replace the cached ones of the user,
+// so the old password stops working as soon as the new one is used.
+func (a *AuthCache) Verify(username, password string, backend func(username, password string) bool) bool {
+	if a.TTL <= 0 {
+		return backend(username, password)
+	}
+
+	key := strings.ToLower(username)
+
+	a.mutex.Lock()
+	mac := a.mac(username, password)
+	entry, found := a.entries[key]
+	a.mutex.Unlock()
+
+	if found && a.time().Before(entry.expires) && hmac.Equal(entry.mac, mac) {
+		return true
+	}
+
+	if !backend(username, password) {
+		return false
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.entries == nil {
+		a.entries = make(map[string]authCacheEntry)
+	}
+	a.entries[key] = authCacheEntry{
+		mac:     mac,
+		expires: a.time().Add(time.Duration(a.TTL) * time.Second),
+	}
+	return true
+}
+
+// Invalidate removes the cached credentials of the user, it should be called when the password changes
+func (a *AuthCache) Invalidate(username string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.entries, strings.ToLower(username))
+}
+
+// Clear removes the cached credentials of all users, e.g. when the users are reloaded
+func (a *AuthCache) Clear() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.entries = nil
+}
+
+// Cleanup removes expired entries, it should be called periodically
+func (a *AuthCache) Cleanup() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := a.time()
+	for key, entry := range a.entries {
+		if now.After(entry.expires) {
+			delete(a.entries, key)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuthCache(t *testing.T) {
+
+	Convey("Testing AuthCache", t, func() {
+		now := time.Date(2016, 10, 5, 14, 0, 0, 0, time.UTC)
+		password := "secret"
+		calls := 0
+		backend := func(username, p string) bool {
+			calls++
+			return username == "bob" && p == password
+		}
+		a := AuthCache{
+			TTL: 60,
+			now: func() time.Time { return now },
+		}
+
+		So(a.Verify("bob", "secret", backend), ShouldEqual, true)
+		So(a.Verify("bob", "secret", backend), ShouldEqual, true)
+		So(calls, ShouldEqual, 1)
+
+		// wrong passwords always go to the backend
+		So(a.Verify("bob", "wrong", backend), ShouldEqual, false)
+		So(calls, ShouldEqual, 2)
+
+		// expired
+		now = now.Add(61 * time.Second)
+		So(a.Verify("bob", "secret", backend), ShouldEqual, true)
+		So(calls, ShouldEqual, 3)
+
+		// password change
+		password = "new secret"
+		a.Invalidate("bob")
+		So(a.Verify("bob", "secret", backend), ShouldEqual, false)
+		So(a.Verify("bob", "new secret", backend), ShouldEqual, true)
+		So(a.Verify("bob", "new secret", backend), ShouldEqual, true)
+		So(calls, ShouldEqual, 5)
+
+		now = now.Add(61 * time.Second)
+		a.Cleanup()
+		So(len(a.entries), ShouldEqual, 0)
+
+		a.Verify("bob", "new secret", backend)
+		a.Clear()
+		a.Verify("bob", "new secret", backend)
+		So(calls, ShouldEqual, 7)
+
+		// disabled cache
+		d := AuthCache{}
+		d.Verify("bob", "new secret", backend)
+		d.Verify("bob", "new secret", backend)
+		So(calls, ShouldEqual, 9)
+	})
+
+}
@@ -0,0 +1,101 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Errors of an AUTH exchange
+var (
+	// ErrAuthCancelled means the client cancelled the exchange with "*" (RFC 4954 section 4)
+	ErrAuthCancelled = errors.New("authentication cancelled")
+	// ErrAuthMalformed means the client sent a line which isn't valid base64
+	ErrAuthMalformed = errors.New("invalid base64 in authentication exchange")
+	// ErrAuthFailed means the credentials are invalid
+	ErrAuthFailed = errors.New("authentication credentials invalid")
+	// ErrAuthLocked means the client or the user is locked out after too many failures (see AuthLockout)
+	ErrAuthLocked = errors.New("locked out after too many failed authentication attempts")
+)
+
+// DecodeAuthResponse decodes a line the client sent in reply to a 334 challenge.
+// The line ending is trimmed, and a line with only "*" cancels the exchange.
+func DecodeAuthResponse(line string) ([]byte, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if line == "*" {
+		return nil, ErrAuthCancelled
+	}
+	decoded, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		return nil, ErrAuthMalformed
+	}
+	return decoded, nil
+}
+
+// AuthLogin runs the AUTH LOGIN exchange with the username and password lines of the client
+// and checks the credentials with verify. The password is never logged or returned.
+func AuthLogin(usernameLine, passwordLine string, verify func(username, password string) bool) (string, error) {
+	username, err := DecodeAuthResponse(usernameLine)
+	if err != nil {
+		return "", err
+	}
+	password, err := DecodeAuthResponse(passwordLine)
+	if err != nil {
+		return "", err
+	}
+	if !verify(string(username), string(password)) {
+		return string(username), ErrAuthFailed
+	}
+	return string(username), nil
+}
+
+// AuthReply returns the reply code and text for the result of an AUTH exchange (RFC 4954 section 6)
+func AuthReply(err error) (int, string) {
+	switch err {
+	case nil:
+		return 235, "2.7.0 Authentication successful"
+	case ErrAuthCancelled:
+		return 501, "5.7.0 Authentication cancelled"
+	case ErrAuthMalformed:
+		return 501, "5.5.2 Cannot decode response"
+	case ErrAuthFailed:
+		return 535, "5.7.8 Authentication credentials invalid"
+	}
+	return 454, "4.7.0 Temporary authentication failure"
+}
+
+// Logins keeps the users which authenticated with AUTH, keyed by session ID,
+// for the handlers which treat their mail as submitted
+type Logins struct {
+	mutex  sync.Mutex
+	logins map[string]string
+}
+
+// Set records the user of the session
+func (l *Logins) Set(sessionId, user string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.logins == nil {
+		l.logins = make(map[string]string)
+	}
+	l.logins[sessionId] = user
+}
+
+// Get returns the user of the session, if it authenticated
+func (l *Logins) Get(sessionId string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	user, found := l.logins[sessionId]
+	return user, found
+}
+
+// Forget removes the user when the session ends
+func (l *Logins) Forget(sessionId string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.logins, sessionId)
+}
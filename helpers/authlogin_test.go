@@ -0,0 +1,71 @@
+package helpers
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuthLogin(t *testing.T) {
+
+	Convey("Testing DecodeAuthResponse()", t, func() {
+		decoded, err := DecodeAuthResponse("Ym9i\r\n")
+		So(err, ShouldEqual, nil)
+		So(string(decoded), ShouldEqual, "bob")
+
+		_, err = DecodeAuthResponse("*\r\n")
+		So(err, ShouldEqual, ErrAuthCancelled)
+		_, err = DecodeAuthResponse("not base64!\r\n")
+		So(err, ShouldEqual, ErrAuthMalformed)
+	})
+
+	Convey("Testing AuthLogin()", t, func() {
+		verify := func(username, password string) bool {
+			return username == "bob" && password == "secret"
+		}
+
+		username, err := AuthLogin("Ym9i\r\n", "c2VjcmV0\r\n", verify)
+		So(err, ShouldEqual, nil)
+		So(username, ShouldEqual, "bob")
+
+		_, err = AuthLogin("Ym9i\r\n", "d3Jvbmc=\r\n", verify)
+		So(err, ShouldEqual, ErrAuthFailed)
+		_, err = AuthLogin("Ym9i\r\n", "*\r\n", verify)
+		So(err, ShouldEqual, ErrAuthCancelled)
+	})
+
+	Convey("Testing AuthReply()", t, func() {
+		code, _ := AuthReply(nil)
+		So(code, ShouldEqual, 235)
+		code, _ = AuthReply(ErrAuthCancelled)
+		So(code, ShouldEqual, 501)
+		code, _ = AuthReply(ErrAuthMalformed)
+		So(code, ShouldEqual, 501)
+		code, _ = AuthReply(ErrAuthFailed)
+		So(code, ShouldEqual, 535)
+		code, _ = AuthReply(errors.New("backend down"))
+		So(code, ShouldEqual, 454)
+		// a lockout looks like a temporary failure
+		code, _ = AuthReply(ErrAuthLocked)
+		So(code, ShouldEqual, 454)
+	})
+
+	Convey("Testing Logins", t, func() {
+		l := &Logins{}
+		_, found := l.Get("1")
+		So(found, ShouldEqual, false)
+		l.Set("1", "bob")
+		user, found := l.Get("1")
+		So(found, ShouldEqual, true)
+		So(user, ShouldEqual, "bob")
+		l.Forget("1")
+		_, found = l.Get("1")
+		So(found, ShouldEqual, false)
+
+		// the handlers work without them
+		_, found = (*Logins)(nil).Get("1")
+		So(found, ShouldEqual, false)
+	})
+
+}
@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"sync"
+	"time"
+)
+
+// AuthResult is the result of an SPF or DKIM check of a message
+type AuthResult struct {
+	// Method is spf or dkim
+	Method string
+	// Result is the lower case result, e.g. pass
+	Result string
+	// Domain is the domain which was authenticated (the MAIL FROM domain, or the d= of the signature)
+	Domain string
+}
+
+// AuthResults collects the SPF and DKIM results of a transaction, keyed by session ID, so DMARC can check
+// whether they are aligned with the From header field. Results which aren't taken expire like spam scores.
+type AuthResults struct {
+	mutex   sync.Mutex
+	results map[string]authResults
+}
+
+type authResults struct {
+	results []AuthResult
+	expires time.Time
+}
+
+// Add adds a result of the transaction
+func (a *AuthResults) Add(sessionId string, result AuthResult) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.results == nil {
+		a.results = make(map[string]authResults)
+	}
+	now := time.Now()
+	for id, results := range a.results {
+		if now.After(results.expires) {
+			delete(a.results, id)
+		}
+	}
+	results := a.results[sessionId]
+	results.results = append(results.results, result)
+	results.expires = now.Add(spamScoresTTL)
+	a.results[sessionId] = results
+}
+
+// Take returns and forgets the results of the transaction
+func (a *AuthResults) Take(sessionId string) []AuthResult {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	results := a.results[sessionId]
+	delete(a.results, sessionId)
+	return results.results
+}
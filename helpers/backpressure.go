@@ -0,0 +1,135 @@
+package helpers
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/log"
+)
+
+// Backpressure refuses new mail while the server is overloaded, so we don't accept mail we can't safely store:
+// the sessions get 421 4.3.2 at the greeting and 452 4.3.1 at MAIL (it can be used as Blacklist as well). The server is overloaded when the queue holds
+// more than MaxQueue messages or the heap grows beyond MaxMemoryMB (low disk space is handled by DiskWatchdog).
+// It stays overloaded until the queue is back at ResumeQueue and the heap at ResumeMemoryMB,
+// so it doesn't flap around the thresholds. A threshold of 0 isn't checked.
+type Backpressure struct {
+	MaxQueue       int
+	ResumeQueue    int
+	MaxMemoryMB    uint64
+	ResumeMemoryMB uint64
+	// Interval between two checks in seconds
+	Interval int
+
+	// QueueDepth returns the number of queued messages
+	QueueDepth func() (int, error) `json:"-"`
+
+	// memory is heapMB, it can be replaced for testing
+	memory func() uint64
+
+	mutex      sync.Mutex
+	overloaded bool
+	stop       chan struct{}
+}
+
+// heapMB returns the memory used by the heap in MB
+func heapMB() uint64 {
+	stats := runtime.MemStats{}
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc / (1024 * 1024)
+}
+
+// CheckIp refuses all clients while the server is overloaded
+func (b *Backpressure) CheckIp(ip string) bool {
+	return b.Overloaded()
+}
+
+// Overloaded reports whether new mail should be refused
+func (b *Backpressure) Overloaded() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.overloaded
+}
+
+// Check measures the queue depth and the memory usage and updates the state
+func (b *Backpressure) Check() {
+	b.mutex.Lock()
+	wasOverloaded := b.overloaded
+	b.mutex.Unlock()
+
+	reason := ""
+	if b.MaxQueue > 0 && b.QueueDepth != nil {
+		resume := b.ResumeQueue
+		if resume <= 0 || resume > b.MaxQueue {
+			resume = b.MaxQueue
+		}
+		depth, err := b.QueueDepth()
+		if err != nil {
+			log.Warnf("Backpressure: couldn't get the queue depth: %v", err)
+		} else if depth > b.MaxQueue || (wasOverloaded && depth > resume) {
+			reason = "the queue holds too many messages"
+		}
+	}
+	if reason == "" && b.MaxMemoryMB > 0 {
+		memory := b.memory
+		if memory == nil {
+			memory = heapMB
+		}
+		resume := b.ResumeMemoryMB
+		if resume == 0 || resume > b.MaxMemoryMB {
+			resume = b.MaxMemoryMB
+		}
+		used := memory()
+		if used > b.MaxMemoryMB || (wasOverloaded && used > resume) {
+			reason = "the heap uses too much memory"
+		}
+	}
+
+	overloaded := reason != ""
+	b.mutex.Lock()
+	b.overloaded = overloaded
+	b.mutex.Unlock()
+
+	if overloaded && !wasOverloaded {
+		log.Errorf("Backpressure: refusing new mail, %s", reason)
+	} else if !overloaded && wasOverloaded {
+		log.Println("Backpressure: accepting new mail again")
+	}
+}
+
+// Start checks the load every Interval seconds until Stop is called
+func (b *Backpressure) Start() {
+	interval := time.Duration(b.Interval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	b.mutex.Lock()
+	b.stop = make(chan struct{})
+	stop := b.stop
+	b.mutex.Unlock()
+
+	b.Check()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.Check()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic checks
+func (b *Backpressure) Stop() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.stop != nil {
+		close(b.stop)
+		b.stop = nil
+	}
+}
@@ -0,0 +1,59 @@
+package helpers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBackpressure(t *testing.T) {
+
+	Convey("Testing Backpressure.Check()", t, func() {
+		depth, memory := 10, uint64(100)
+
+		b := Backpressure{
+			MaxQueue:       100,
+			ResumeQueue:    50,
+			MaxMemoryMB:    500,
+			ResumeMemoryMB: 400,
+			QueueDepth:     func() (int, error) { return depth, nil },
+			memory:         func() uint64 { return memory },
+		}
+
+		b.Check()
+		So(b.CheckIp("192.0.2.1"), ShouldEqual, false)
+
+		// the queue
+		depth = 150
+		b.Check()
+		So(b.CheckIp("192.0.2.1"), ShouldEqual, true)
+
+		depth = 80
+		b.Check()
+		So(b.Overloaded(), ShouldEqual, true)
+
+		depth = 40
+		b.Check()
+		So(b.Overloaded(), ShouldEqual, false)
+
+		// the memory
+		memory = 600
+		b.Check()
+		So(b.Overloaded(), ShouldEqual, true)
+
+		memory = 450
+		b.Check()
+		So(b.Overloaded(), ShouldEqual, true)
+
+		memory = 300
+		b.Check()
+		So(b.Overloaded(), ShouldEqual, false)
+	})
+
+	Convey("Testing Backpressure without thresholds", t, func() {
+		b := Backpressure{memory: func() uint64 { return 1 << 20 }}
+		b.Check()
+		So(b.Overloaded(), ShouldEqual, false)
+	})
+
+}
@@ -0,0 +1,177 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultTexts are the texts GoPistolet sends, keyed by the name under which they can be replaced.
+// {name} is replaced by the value of the variable with that name.
+var DefaultTexts = map[string]string{
+	// SMTP replies, the codes are fixed
+	"smtp.banner":               "{hostname} Service Ready",
+	"smtp.helo":                 "{hostname}",
+	"smtp.ok":                   "OK",
+	"smtp.bye":                  "Bye!",
+	"smtp.shutting_down":        "Server is going down.",
+	"smtp.line_too_long":        "Line too long.",
+	"smtp.sender_ok":            "Sender ok",
+	"smtp.sender_8bitmime_ok":   "Sender and 8BITMIME ok",
+	"smtp.sender_specified":     "Sender already specified",
+	"smtp.need_mail":            "Need mail before RCPT",
+	"smtp.need_mail_data":       "Need mail before DATA",
+	"smtp.need_rcpt":            "Need RCPT before DATA",
+	"smtp.start_data":           "Start mail input; end with <CRLF>.<CRLF>",
+	"smtp.start_data_8bitmime":  "Start 8BITMIME mail input; end with <CRLF>.<CRLF>",
+	"smtp.data_incomplete":      "Could not parse mail data",
+	"smtp.delivered":            "Mail delivered",
+	"smtp.not_stored":           "4.3.0 Message not stored, try again later",
+	"smtp.bare_line_ending":     "5.6.0 Message refused, its lines must end with <CR><LF>",
+	"smtp.prdr_start":           "Content analysis has started",
+	"smtp.prdr_accepted":        "<{recipient}> Message accepted",
+	"smtp.prdr_rejected":        "No recipient accepted the message",
+	"smtp.xclient_unauthorized": "Insufficient authorization for XCLIENT",
+	"smtp.xclient_transaction":  "MAIL transaction in progress",
+	"smtp.xclient_syntax":       "Bad XCLIENT command: {error}",
+	"smtp.xclient_rejected":     "Client rejected",
+	"smtp.helo_invalid":         "Greet with a domain name or address literal",
+	"smtp.need_helo":            "Send HELO or EHLO first",
+	"smtp.too_many_errors":      "Too many errors, closing connection",
+	"smtp.local_error":          "Local error, closing connection",
+	"smtp.overloaded":           "System not accepting network messages, try again later",
+	"smtp.system_full":          "Mail system full, try again later",
+	"smtp.insufficient_storage": "Insufficient system storage, try again later",
+	"smtp.dnsbl_rejected":       "Client host rejected, it's listed in a DNS blocklist",
+	"smtp.greylisted":           "Greylisted, try again later",
+	"smtp.rejected":             "Address rejected",
+	"smtp.sender_rejected":      "Sender address rejected, it can't receive mail",
+	"smtp.user_unknown":         "User unknown",
+	"smtp.access_rejected":      "Access denied",
+	"smtp.access_deferred":      "Try again later",
+	"smtp.mailbox_full":         "Mailbox full",
+	"smtp.too_many_recipients":  "Too many recipients",
+	"smtp.tls_required":         "Must issue a STARTTLS command first",
+	"smtp.auth_required":        "Authentication required",
+	"smtp.auth_syntax":          "Syntax: AUTH mechanism [initial-response]",
+	"smtp.auth_mechanism":       "Unrecognized authentication type",
+	"smtp.auth_again":           "Already authenticated",
+	"smtp.auth_transaction":     "MAIL transaction in progress",
+	"smtp.starttls_unavailable": "STARTTLS is not implemented",
+	"smtp.already_tls":          "Already in TLS mode",
+	"smtp.ready_tls":            "Ready for TLS handshake",
+	"smtp.not_implemented":      "Command not implemented",
+	"smtp.not_recognized":       "Command not recognized",
+
+	// delivery status notifications (bounces)
+	"dsn.from":                "Mail Delivery System",
+	"dsn.intro":               "This is the mail system at host {hostname}.",
+	"dsn.success.subject":     "Successful Mail Delivery Report",
+	"dsn.success.explanation": "Your message was successfully delivered to the destination(s) listed below.\nIf the message was relayed, you may not receive further notifications.",
+	"dsn.failure.subject":     "Undelivered Mail Returned to Sender",
+	"dsn.failure.explanation": "Your message could not be delivered to the recipient(s) listed below.",
+	"dsn.delivered":           "delivered to mailbox",
+	"dsn.relayed":             "relayed to the next server",
+	"dsn.failed":              "delivery failed",
+
+	// vacation messages without a subject of their own
+	"vacation.subject": "Auto: {subject}",
+}
+
+// Catalog contains the human-readable texts GoPistolet sends (SMTP replies, bounces and vacation subjects),
+// so operators can brand and translate them. The reply codes and enhanced status codes aren't part
+// of the texts, so they stay the same. Texts which aren't in the catalog keep their default.
+type Catalog struct {
+	// File is a JSON object with texts, e.g. a translation
+	File string
+	// Texts override the ones in File
+	Texts map[string]string
+
+	mutex  sync.Mutex
+	loaded bool
+	file   map[string]string
+}
+
+// load reads File, once
+func (c *Catalog) load() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.loaded || c.File == "" {
+		return nil
+	}
+	c.loaded = true
+	data, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &c.file); err != nil {
+		return fmt.Errorf("%s: %v", c.File, err)
+	}
+	return nil
+}
+
+// Validate loads File and checks that all keys are known
+func (c *Catalog) Validate() error {
+	if err := c.load(); err != nil {
+		return err
+	}
+	unknown := []string{}
+	for _, texts := range []map[string]string{c.file, c.Texts} {
+		for key := range texts {
+			if _, known := DefaultTexts[key]; !known {
+				unknown = append(unknown, key)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown texts %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// Customized reports whether one of the texts with the prefix (e.g. "smtp.") is replaced
+func (c *Catalog) Customized(prefix string) bool {
+	if c == nil {
+		return false
+	}
+	c.load()
+	for _, texts := range []map[string]string{c.file, c.Texts} {
+		for key := range texts {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Text returns the text, with the variables (name and value pairs) filled in.
+// The catalog may be nil, the default texts are used then.
+func (c *Catalog) Text(key string, variables ...string) string {
+	text, found := DefaultTexts[key]
+	if c != nil {
+		if err := c.load(); err == nil {
+			c.mutex.Lock()
+			if t, ok := c.file[key]; ok {
+				text, found = t, true
+			}
+			c.mutex.Unlock()
+		}
+		if t, ok := c.Texts[key]; ok {
+			text, found = t, true
+		}
+	}
+	if !found {
+		return key
+	}
+
+	pairs := make([]string, 0, len(variables))
+	for i := 0; i+1 < len(variables); i += 2 {
+		pairs = append(pairs, "{"+variables[i]+"}", variables[i+1])
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
@@ -0,0 +1,40 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCatalog(t *testing.T) {
+
+	Convey("Testing Catalog", t, func() {
+		var none *Catalog
+		So(none.Text("smtp.banner", "hostname", "mx.example.com"), ShouldEqual, "mx.example.com Service Ready")
+		So(none.Text("unknown.key"), ShouldEqual, "unknown.key")
+		So(none.Customized("smtp."), ShouldBeFalse)
+
+		dir, err := ioutil.TempDir("", "catalog")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "nl.json")
+		So(ioutil.WriteFile(file, []byte(`{"smtp.banner": "{hostname} staat klaar", "smtp.bye": "Tot ziens"}`), 0644), ShouldEqual, nil)
+
+		c := &Catalog{File: file, Texts: map[string]string{"smtp.bye": "Dag!"}}
+		So(c.Validate(), ShouldEqual, nil)
+		So(c.Customized("smtp."), ShouldBeTrue)
+		So(c.Customized("dsn."), ShouldBeFalse)
+		So(c.Text("smtp.banner", "hostname", "mx.example.com"), ShouldEqual, "mx.example.com staat klaar")
+		So(c.Text("smtp.bye"), ShouldEqual, "Dag!")
+		So(c.Text("smtp.ok"), ShouldEqual, "OK")
+
+		c = &Catalog{Texts: map[string]string{"smtp.banenr": "typo"}}
+		So(c.Validate().Error(), ShouldEqual, "unknown texts smtp.banenr")
+		c = &Catalog{File: filepath.Join(dir, "missing.json")}
+		So(c.Validate(), ShouldNotEqual, nil)
+	})
+
+}
@@ -0,0 +1,105 @@
+package helpers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// ClientCerts verifies the client certificates on TLS listeners and maps them to users,
+// so machines can relay with AUTH EXTERNAL (RFC 4422 appendix A) instead of a password.
+type ClientCerts struct {
+	// CAFile contains the PEM certificates of the CAs which issue the client certificates
+	CAFile string
+	// Required refuses TLS handshakes without a valid client certificate
+	Required bool
+	// Users maps the identities of a certificate to users: its e-mail addresses and DNS names
+	// (subject alternative names) or its subject common name, which are tried in that order
+	Users map[string]string
+
+	mutex sync.Mutex
+	pool  *x509.CertPool
+}
+
+// Load reads the CA certificates
+func (c *ClientCerts) Load() error {
+	data, err := ioutil.ReadFile(c.CAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return errors.New(c.CAFile + ": no PEM certificates")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pool = pool
+	return nil
+}
+
+// TLSConfig returns a copy of the TLS config of a listener which asks for client certificates
+// (or requires them) and verifies them with the CAs, the config is returned as is if there are no CAs
+func (c *ClientCerts) TLSConfig(config *tls.Config) *tls.Config {
+	c.mutex.Lock()
+	pool := c.pool
+	c.mutex.Unlock()
+	if pool == nil {
+		return config
+	}
+
+	config = config.Clone()
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if c.Required {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config
+}
+
+// User returns the user of the verified client certificate of the connection
+func (c *ClientCerts) User(state tls.ConnectionState) (string, bool) {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	cert := state.VerifiedChains[0][0]
+
+	identities := append(append([]string{}, cert.EmailAddresses...), cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	for _, identity := range identities {
+		for name, user := range c.Users {
+			if strings.EqualFold(name, identity) {
+				return user, true
+			}
+		}
+	}
+	return "", false
+}
+
+// AuthExternal runs the AUTH EXTERNAL exchange: the client sends the identity it wants to act as
+// (authzid), or "=" for the identity of its certificate. The authzid must be the user of the
+// certificate, a certificate can't be used to act as another user.
+func (c *ClientCerts) AuthExternal(authzidLine string, state tls.ConnectionState) (string, error) {
+	authzid := ""
+	if strings.TrimRight(authzidLine, "\r\n") != "=" {
+		decoded, err := DecodeAuthResponse(authzidLine)
+		if err != nil {
+			return "", err
+		}
+		authzid = string(decoded)
+	}
+
+	user, found := c.User(state)
+	if !found {
+		return authzid, ErrAuthFailed
+	}
+	if authzid != "" && !strings.EqualFold(authzid, user) {
+		return authzid, ErrAuthFailed
+	}
+	return user, nil
+}
@@ -0,0 +1,105 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientCerts(t *testing.T) {
+
+	Convey("Testing ClientCerts", t, func() {
+		dir, err := ioutil.TempDir("", "clientcerts")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		// a CA and a client certificate issued by it
+		caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		caTemplate := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "Test CA"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+		caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+		So(err, ShouldEqual, nil)
+		ca, _ := x509.ParseCertificate(caDer)
+
+		clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		clientTemplate := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "backup.example.com"},
+			DNSNames:     []string{"backup.example.com"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		clientDer, err := x509.CreateCertificate(rand.Reader, clientTemplate, ca, &clientKey.PublicKey, caKey)
+		So(err, ShouldEqual, nil)
+		client, _ := x509.ParseCertificate(clientDer)
+
+		caFile := filepath.Join(dir, "ca.pem")
+		So(ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}), 0600), ShouldEqual, nil)
+
+		c := &ClientCerts{
+			CAFile: caFile,
+			Users:  map[string]string{"Backup.example.com": "backup"},
+		}
+
+		// without CAs the TLS config isn't changed
+		base := &tls.Config{}
+		So(c.TLSConfig(base), ShouldEqual, base)
+
+		So(c.Load(), ShouldEqual, nil)
+		config := c.TLSConfig(base)
+		So(config.ClientAuth, ShouldEqual, tls.VerifyClientCertIfGiven)
+		So(config.ClientCAs, ShouldNotBeNil)
+		So(base.ClientCAs, ShouldBeNil)
+		c.Required = true
+		So(c.TLSConfig(base).ClientAuth, ShouldEqual, tls.RequireAndVerifyClientCert)
+
+		chains, err := client.Verify(x509.VerifyOptions{Roots: config.ClientCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		So(err, ShouldEqual, nil)
+		state := tls.ConnectionState{VerifiedChains: chains}
+
+		user, found := c.User(state)
+		So(found, ShouldEqual, true)
+		So(user, ShouldEqual, "backup")
+
+		user, err = c.AuthExternal("=", state)
+		So(err, ShouldEqual, nil)
+		So(user, ShouldEqual, "backup")
+
+		user, err = c.AuthExternal(base64.StdEncoding.EncodeToString([]byte("backup")), state)
+		So(err, ShouldEqual, nil)
+		So(user, ShouldEqual, "backup")
+
+		_, err = c.AuthExternal(base64.StdEncoding.EncodeToString([]byte("admin")), state)
+		So(err, ShouldEqual, ErrAuthFailed)
+
+		// unverified or unknown certificates
+		_, err = c.AuthExternal("=", tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}})
+		So(err, ShouldEqual, ErrAuthFailed)
+
+		c.Users = map[string]string{"other.example.com": "other"}
+		_, err = c.AuthExternal("=", state)
+		So(err, ShouldEqual, ErrAuthFailed)
+	})
+
+}
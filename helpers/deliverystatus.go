@@ -0,0 +1,122 @@
+package helpers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery outcomes
+const (
+	DeliveryQueued    = "queued"
+	DeliveryDelivered = "delivered"
+	DeliveryDeferred  = "deferred"
+	DeliveryFailed    = "failed"
+)
+
+// DeliveryOutcome is the result of delivering a message to one recipient
+type DeliveryOutcome struct {
+	Time      time.Time
+	Recipient string
+	Outcome   string
+	Detail    string `json:",omitempty"`
+}
+
+// DeliveryStatus keeps a sidecar record of the delivery outcomes of the messages of each sender,
+// so mail clients (or a plugin for the IMAP server) can show the delivery status of sent messages.
+// The outcomes of a message are appended to <Dir>/<sender>/<hash of the Message-ID>.json as they happen,
+// one JSON object per line. Recording is disabled if Dir is empty.
+type DeliveryStatus struct {
+	Dir string
+
+	mutex sync.Mutex
+}
+
+// path returns the file with the outcomes of the message
+func (d *DeliveryStatus) path(sender, messageId string) string {
+	// the sender is used as directory name, so it may not contain path separators or start with a dot
+	safe := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == 0 {
+			return '_'
+		}
+		return r
+	}, strings.ToLower(sender))
+	safe = strings.TrimLeft(safe, ".")
+	if safe == "" {
+		safe = "_"
+	}
+	hash := sha256.Sum256([]byte(messageId))
+	return filepath.Join(d.Dir, safe, hex.EncodeToString(hash[:])+".json")
+}
+
+// Record appends the outcome for a recipient of the message
+func (d *DeliveryStatus) Record(sender, messageId string, outcome DeliveryOutcome) error {
+	if d.Dir == "" || sender == "" || messageId == "" {
+		return nil
+	}
+	if outcome.Time.IsZero() {
+		outcome.Time = time.Now()
+	}
+	line, err := json.Marshal(outcome)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	path := d.path(sender, messageId)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Tracked reports whether outcomes of the message were recorded
+func (d *DeliveryStatus) Tracked(sender, messageId string) bool {
+	if d.Dir == "" || sender == "" || messageId == "" {
+		return false
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	_, err := os.Stat(d.path(sender, messageId))
+	return err == nil
+}
+
+// Outcomes returns the recorded outcomes of the message
+func (d *DeliveryStatus) Outcomes(sender, messageId string) ([]DeliveryOutcome, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	file, err := os.Open(d.path(sender, messageId))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	outcomes := []DeliveryOutcome{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		outcome := DeliveryOutcome{}
+		if err := json.Unmarshal(scanner.Bytes(), &outcome); err != nil {
+			return nil, err
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes, scanner.Err()
+}
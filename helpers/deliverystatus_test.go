@@ -0,0 +1,44 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeliveryStatus(t *testing.T) {
+
+	Convey("Testing DeliveryStatus", t, func() {
+		dir, err := ioutil.TempDir("", "status")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		d := DeliveryStatus{Dir: dir}
+		So(d.Record("Bob@example.com", "<1@example.com>", DeliveryOutcome{Recipient: "alice@example.org", Outcome: DeliveryDelivered}), ShouldEqual, nil)
+		So(d.Record("bob@example.com", "<1@example.com>", DeliveryOutcome{Recipient: "carol@example.org", Outcome: DeliveryFailed, Detail: "unknown user"}), ShouldEqual, nil)
+
+		outcomes, err := d.Outcomes("bob@example.com", "<1@example.com>")
+		So(err, ShouldEqual, nil)
+		So(len(outcomes), ShouldEqual, 2)
+		So(outcomes[0].Recipient, ShouldEqual, "alice@example.org")
+		So(outcomes[1].Outcome, ShouldEqual, DeliveryFailed)
+		So(outcomes[1].Detail, ShouldEqual, "unknown user")
+
+		outcomes, err = d.Outcomes("bob@example.com", "<2@example.com>")
+		So(err, ShouldEqual, nil)
+		So(len(outcomes), ShouldEqual, 0)
+		So(d.Tracked("bob@example.com", "<1@example.com>"), ShouldEqual, true)
+		So(d.Tracked("bob@example.com", "<2@example.com>"), ShouldEqual, false)
+
+		// senders can't escape the directory
+		So(d.Record("../../etc/passwd", "<1@example.com>", DeliveryOutcome{Recipient: "alice@example.org"}), ShouldEqual, nil)
+		path, err := filepath.Rel(dir, d.path("../../etc/passwd", "<1@example.com>"))
+		So(err, ShouldEqual, nil)
+		So(strings.HasPrefix(path, ".."), ShouldEqual, false)
+	})
+
+}
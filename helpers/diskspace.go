@@ -0,0 +1,123 @@
+package helpers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/log"
+)
+
+// DiskWatchdog monitors the free space on the volumes of the given paths (spool, maildir, ...).
+// When one of them drops below MinFreeMB, the watchdog reports low disk space until
+// all of them have at least ResumeFreeMB free again, so it doesn't flap around the threshold.
+type DiskWatchdog struct {
+	Paths        []string
+	MinFreeMB    uint64
+	ResumeFreeMB uint64
+	// Interval between two checks in seconds
+	Interval int
+
+	// OnLow and OnRecover are called when the state changes
+	OnLow     func(path string, freeMB uint64)
+	OnRecover func()
+
+	// freeSpace is diskFree, it can be replaced for testing
+	freeSpace func(path string) (uint64, error)
+
+	mutex sync.Mutex
+	low   bool
+	stop  chan struct{}
+}
+
+// Low reports whether one of the watched volumes is running out of space
+func (w *DiskWatchdog) Low() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.low
+}
+
+// Check measures the free space on all volumes and updates the state
+func (w *DiskWatchdog) Check() {
+	freeSpace := w.freeSpace
+	if freeSpace == nil {
+		freeSpace = diskFree
+	}
+
+	resume := w.ResumeFreeMB
+	if resume < w.MinFreeMB {
+		resume = w.MinFreeMB
+	}
+
+	w.mutex.Lock()
+	wasLow := w.low
+	w.mutex.Unlock()
+
+	low := false
+	lowPath, lowFree := "", uint64(0)
+	for _, path := range w.Paths {
+		free, err := freeSpace(path)
+		if err != nil {
+			log.Warnf("Disk watchdog: couldn't get free space of %s: %v", path, err)
+			continue
+		}
+		free = free / (1024 * 1024)
+		if free < w.MinFreeMB || (wasLow && free < resume) {
+			low = true
+			lowPath, lowFree = path, free
+			break
+		}
+	}
+
+	w.mutex.Lock()
+	w.low = low
+	w.mutex.Unlock()
+
+	if low && !wasLow {
+		log.Errorf("Disk watchdog: only %d MB free on %s (minimum is %d MB)", lowFree, lowPath, w.MinFreeMB)
+		if w.OnLow != nil {
+			w.OnLow(lowPath, lowFree)
+		}
+	} else if !low && wasLow {
+		log.Println("Disk watchdog: free disk space recovered")
+		if w.OnRecover != nil {
+			w.OnRecover()
+		}
+	}
+}
+
+// Start checks the free space every Interval seconds until Stop is called
+func (w *DiskWatchdog) Start() {
+	interval := time.Duration(w.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	w.mutex.Lock()
+	w.stop = make(chan struct{})
+	stop := w.stop
+	w.mutex.Unlock()
+
+	w.Check()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.Check()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic checks
+func (w *DiskWatchdog) Stop() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+}
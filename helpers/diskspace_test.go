@@ -0,0 +1,50 @@
+package helpers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDiskWatchdog(t *testing.T) {
+
+	Convey("Testing DiskWatchdog.Check()", t, func() {
+		free := map[string]uint64{
+			"mailstore": 500,
+			"maildir":   500,
+		}
+		lows, recoveries := 0, 0
+
+		w := DiskWatchdog{
+			Paths:        []string{"mailstore", "maildir"},
+			MinFreeMB:    100,
+			ResumeFreeMB: 200,
+			OnLow:        func(path string, freeMB uint64) { lows++ },
+			OnRecover:    func() { recoveries++ },
+			freeSpace: func(path string) (uint64, error) {
+				return free[path] * 1024 * 1024, nil
+			},
+		}
+
+		w.Check()
+		So(w.Low(), ShouldEqual, false)
+
+		free["maildir"] = 50
+		w.Check()
+		So(w.Low(), ShouldEqual, true)
+		So(lows, ShouldEqual, 1)
+
+		// don't resume before there's enough space again
+		free["maildir"] = 150
+		w.Check()
+		So(w.Low(), ShouldEqual, true)
+		So(recoveries, ShouldEqual, 0)
+
+		free["maildir"] = 250
+		w.Check()
+		So(w.Low(), ShouldEqual, false)
+		So(lows, ShouldEqual, 1)
+		So(recoveries, ShouldEqual, 1)
+	})
+
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package helpers
+
+import "syscall"
+
+// diskFree returns the number of bytes available on the volume of the given path
+func diskFree(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package helpers
+
+import "errors"
+
+// diskFree is not implemented on Windows
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("free disk space check not supported on windows")
+}
@@ -0,0 +1,240 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/log"
+)
+
+// Actions which can be taken when a DNS blocklist lists an IP or domain
+const (
+	// DnsblReject rejects the connection (554), or MAIL for the HELO domain
+	DnsblReject = "reject"
+	// DnsblGreylist refuses the recipients temporarily until the client retries (see Greylist)
+	DnsblGreylist = "greylist"
+	// DnsblScore only adds a score to the message
+	DnsblScore = "score"
+)
+
+// defaultDnsblCacheSize is the number of cached results if MaxCacheEntries isn't set
+const defaultDnsblCacheSize = 10000
+
+// DnsblList is a single DNS blocklist (e.g. zen.spamhaus.org)
+type DnsblList struct {
+	// Zone of the blocklist, the reversed IP (or the domain) is prepended to it
+	Zone string
+	// Action to take when the list matches, defaults to DnsblReject
+	Action string
+	// Score added to the message when Action is DnsblScore
+	Score float64
+	// Domain lists (RHSBL) are queried with domain names instead of IPs
+	Domain bool
+}
+
+// action returns the Action of the list, with its default
+func (l DnsblList) action() string {
+	if l.Action == "" {
+		return DnsblReject
+	}
+	return l.Action
+}
+
+// Dnsbl is a Blacklist implementation which looks up IPs and domains in DNS blocklists.
+// Results are cached for CacheTTL seconds, so repeated connections don't hammer the lists,
+// the cache holds at most MaxCacheEntries results (default 10000).
+// When the resolver fails (as opposed to NXDOMAIN), the result isn't cached and the lookup
+// fails open (not listed), or fails closed (listed, so the client is refused and retries later)
+// if FailClosed is set.
+type Dnsbl struct {
+	Lists           []DnsblList
+	CacheTTL        int
+	MaxCacheEntries int
+	FailClosed      bool
+	// Greylist holds the clients which are listed in lists with the greylist action
+	Greylist Greylist
+
+	// Health keeps track of resolver failures
+	Health *DnsHealth `json:"-"`
+
+	// Lookup resolves the queries, it's net.DefaultResolver.LookupHost if it's nil
+	Lookup func(ctx context.Context, host string) ([]string, error) `json:"-"`
+
+	mutex sync.Mutex
+	cache map[string]dnsblCacheEntry
+}
+
+type dnsblCacheEntry struct {
+	listed  bool
+	expires time.Time
+}
+
+// CheckIp will return true if the IP is listed in one of the lists with the reject action
+func (d *Dnsbl) CheckIp(ip string) bool {
+	return len(d.Listed(context.Background(), ip, "", DnsblReject)) > 0
+}
+
+// Listed returns the lists with the action in which the IP or the domain is listed,
+// an empty IP or domain isn't looked up
+func (d *Dnsbl) Listed(ctx context.Context, ip, domain, action string) []DnsblList {
+	listed := []DnsblList{}
+	for _, list := range append(d.LookupIp(ctx, ip), d.LookupDomain(ctx, domain)...) {
+		if list.action() == action {
+			listed = append(listed, list)
+		}
+	}
+	return listed
+}
+
+// LookupIp returns all IP lists in which the IP is listed,
+// lookups which are canceled by the context count as failed
+func (d *Dnsbl) LookupIp(ctx context.Context, ip string) []DnsblList {
+	reversed, err := reverseIp(ip)
+	if err != nil {
+		return nil
+	}
+
+	listed := []DnsblList{}
+	for _, list := range d.Lists {
+		if list.Domain {
+			continue
+		}
+		if d.query(ctx, reversed+"."+list.Zone, list.action()) {
+			listed = append(listed, list)
+		}
+	}
+	return listed
+}
+
+// LookupDomain returns all domain lists in which the domain is listed
+func (d *Dnsbl) LookupDomain(ctx context.Context, domain string) []DnsblList {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" || net.ParseIP(domain) != nil || ParseAddressLiteral(domain) != nil {
+		return nil
+	}
+
+	listed := []DnsblList{}
+	for _, list := range d.Lists {
+		if !list.Domain {
+			continue
+		}
+		if d.query(ctx, domain+"."+list.Zone, list.action()) {
+			listed = append(listed, list)
+		}
+	}
+	return listed
+}
+
+// query looks up the given host and reports whether it's listed (has an A record)
+func (d *Dnsbl) query(ctx context.Context, host string, action string) bool {
+	d.mutex.Lock()
+	if d.cache == nil {
+		d.cache = make(map[string]dnsblCacheEntry)
+	}
+	entry, found := d.cache[host]
+	d.mutex.Unlock()
+
+	if found && time.Now().Before(entry.expires) {
+		return entry.listed
+	}
+
+	lookup := d.Lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupHost
+	}
+	addrs, err := lookup(ctx, host)
+
+	// a canceled lookup says nothing about the resolver
+	if d.Health != nil && ctx.Err() == nil {
+		d.Health.Result(err)
+	}
+	if err != nil && !IsDnsNotFound(err) {
+		log.Warnf("DNSBL: lookup of %s failed: %v", host, err)
+		// only rejecting lists fail closed, a score can't make up for the missing result
+		return d.FailClosed && action == DnsblReject
+	}
+
+	// blocklists answer with 127.0.0.x if listed, NXDOMAIN otherwise
+	listed := false
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, "127.") {
+			listed = true
+		}
+	}
+
+	if d.CacheTTL > 0 {
+		d.store(host, listed)
+	}
+	return listed
+}
+
+// store caches the result of the host, an arbitrary entry makes room when the cache is full
+func (d *Dnsbl) store(host string, listed bool) {
+	size := d.MaxCacheEntries
+	if size <= 0 {
+		size = defaultDnsblCacheSize
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, found := d.cache[host]; !found && len(d.cache) >= size {
+		for key := range d.cache {
+			delete(d.cache, key)
+			break
+		}
+	}
+	d.cache[host] = dnsblCacheEntry{
+		listed:  listed,
+		expires: time.Now().Add(time.Duration(d.CacheTTL) * time.Second),
+	}
+}
+
+// Cleanup removes the expired results and greylisted clients, it should be called periodically
+func (d *Dnsbl) Cleanup() {
+	d.Greylist.Cleanup()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	now := time.Now()
+	for host, entry := range d.cache {
+		if now.After(entry.expires) {
+			delete(d.cache, host)
+		}
+	}
+}
+
+// reverseIp reverses the IP the way DNS blocklists expect it:
+// 1.2.3.4 becomes 4.3.2.1 and IPv6 addresses are reversed nibble by nibble
+func reverseIp(s string) (string, error) {
+	ip := ParseIp(s)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP: %s", s)
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d", ip4[3], ip4[2], ip4[1], ip4[0]), nil
+	}
+
+	nibbles := make([]string, 0, 32)
+	for i := len(ip) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, fmt.Sprintf("%x", ip[i]&0x0f), fmt.Sprintf("%x", ip[i]>>4))
+	}
+	return strings.Join(nibbles, "."), nil
+}
+
+// Blacklists combines multiple blacklists,
+// an IP is blacklisted if one of the blacklists contains it
+type Blacklists []Blacklist
+
+func (bl Blacklists) CheckIp(ip string) bool {
+	for _, b := range bl {
+		if b.CheckIp(ip) {
+			return true
+		}
+	}
+	return false
+}
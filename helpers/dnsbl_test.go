@@ -0,0 +1,158 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDnsbl(t *testing.T) {
+
+	Convey("Testing reverseIp()", t, func() {
+		reversed, err := reverseIp("192.168.0.10")
+		So(err, ShouldEqual, nil)
+		So(reversed, ShouldEqual, "10.0.168.192")
+
+		reversed, err = reverseIp("2001:db8::1")
+		So(err, ShouldEqual, nil)
+		So(reversed, ShouldEqual, "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2")
+
+		_, err = reverseIp("not an ip")
+		So(err, ShouldNotEqual, nil)
+	})
+
+	Convey("Testing CheckIp() and LookupDomain()", t, func() {
+		queries := 0
+		d := Dnsbl{
+			Lists: []DnsblList{
+				{Zone: "reject.example.com", Action: DnsblReject},
+				{Zone: "score.example.com", Action: DnsblScore, Score: 1.5},
+				{Zone: "dbl.example.com", Action: DnsblScore, Domain: true},
+			},
+			CacheTTL: 60,
+			Lookup: func(ctx context.Context, host string) ([]string, error) {
+				queries++
+				switch host {
+				case "2.0.0.127.reject.example.com", "2.0.0.127.score.example.com", "3.0.0.127.score.example.com", "spam.example.org.dbl.example.com":
+					return []string{"127.0.0.2"}, nil
+				}
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			},
+		}
+
+		So(d.CheckIp("127.0.0.2"), ShouldEqual, true)
+		So(d.CheckIp("127.0.0.3"), ShouldEqual, false)
+		So(d.CheckIp("127.0.0.4"), ShouldEqual, false)
+		So(len(d.LookupIp(context.Background(), "127.0.0.3")), ShouldEqual, 1)
+
+		So(len(d.LookupDomain(context.Background(), "spam.example.org")), ShouldEqual, 1)
+		So(len(d.LookupDomain(context.Background(), "ham.example.org")), ShouldEqual, 0)
+
+		// results should be cached
+		before := queries
+		d.CheckIp("127.0.0.2")
+		d.CheckIp("127.0.0.4")
+		So(queries, ShouldEqual, before)
+	})
+
+	Convey("Testing Listed()", t, func() {
+		d := Dnsbl{
+			Lists: []DnsblList{
+				{Zone: "reject.example.com"},
+				{Zone: "grey.example.com", Action: DnsblGreylist},
+				{Zone: "dbl.example.com", Action: DnsblReject, Domain: true},
+			},
+			Lookup: func(ctx context.Context, host string) ([]string, error) {
+				return []string{"127.0.0.2"}, nil
+			},
+		}
+
+		So(len(d.Listed(context.Background(), "192.0.2.1", "", DnsblReject)), ShouldEqual, 1)
+		So(len(d.Listed(context.Background(), "192.0.2.1", "", DnsblGreylist)), ShouldEqual, 1)
+		So(len(d.Listed(context.Background(), "192.0.2.1", "", DnsblScore)), ShouldEqual, 0)
+		So(len(d.Listed(context.Background(), "", "spam.example.org", DnsblReject)), ShouldEqual, 1)
+		So(len(d.Listed(context.Background(), "192.0.2.1", "spam.example.org", DnsblReject)), ShouldEqual, 2)
+		So(d.Listed(context.Background(), "", "", DnsblReject), ShouldBeEmpty)
+	})
+
+	Convey("Testing the size of the cache", t, func() {
+		d := Dnsbl{
+			Lists:           []DnsblList{{Zone: "reject.example.com"}},
+			CacheTTL:        60,
+			MaxCacheEntries: 2,
+			Lookup: func(ctx context.Context, host string) ([]string, error) {
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			},
+		}
+
+		for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"} {
+			d.CheckIp(ip)
+		}
+		So(len(d.cache), ShouldEqual, 2)
+
+		d.cache["expired"] = dnsblCacheEntry{expires: time.Now().Add(-time.Second)}
+		d.Cleanup()
+		So(len(d.cache), ShouldEqual, 2)
+		So(d.cache, ShouldNotContainKey, "expired")
+
+		// without CacheTTL nothing is cached
+		d = Dnsbl{Lists: d.Lists, Lookup: d.Lookup}
+		d.CheckIp("192.0.2.1")
+		So(d.cache, ShouldBeEmpty)
+	})
+
+	Convey("Testing resolver failures", t, func() {
+		health := &DnsHealth{Threshold: 2}
+		d := Dnsbl{
+			Lists: []DnsblList{
+				{Zone: "reject.example.com", Action: DnsblReject},
+				{Zone: "score.example.com", Action: DnsblScore},
+			},
+			CacheTTL: 60,
+			Health:   health,
+			Lookup: func(ctx context.Context, host string) ([]string, error) {
+				return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+			},
+		}
+
+		// fail open
+		So(d.CheckIp("127.0.0.2"), ShouldEqual, false)
+		So(health.Down(), ShouldEqual, true)
+		So(health.Backoff(), ShouldBeGreaterThan, 0)
+
+		// fail closed, only for rejecting lists
+		d.FailClosed = true
+		So(d.CheckIp("127.0.0.2"), ShouldEqual, true)
+		So(len(d.LookupIp(context.Background(), "127.0.0.2")), ShouldEqual, 1)
+
+		// failures aren't cached
+		d.Lookup = func(ctx context.Context, host string) ([]string, error) {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		So(d.CheckIp("127.0.0.2"), ShouldEqual, false)
+		So(health.Down(), ShouldEqual, false)
+		So(health.Backoff(), ShouldEqual, 0)
+	})
+
+	Convey("Testing IsDnsNotFound()", t, func() {
+		So(IsDnsNotFound(&net.DNSError{IsNotFound: true}), ShouldEqual, true)
+		So(IsDnsNotFound(&net.DNSError{IsTimeout: true}), ShouldEqual, false)
+		So(IsDnsNotFound(errors.New("NXDOMAIN")), ShouldEqual, false)
+		So(IsDnsNotFound(nil), ShouldEqual, false)
+	})
+
+	Convey("Testing Blacklists", t, func() {
+		bl := Blacklists{
+			&Nixspam{IpList: []string{"192.168.0.10"}},
+			&Nixspam{IpList: []string{"192.168.0.11"}},
+		}
+		So(bl.CheckIp("192.168.0.10"), ShouldEqual, true)
+		So(bl.CheckIp("192.168.0.11"), ShouldEqual, true)
+		So(bl.CheckIp("192.168.0.12"), ShouldEqual, false)
+	})
+
+}
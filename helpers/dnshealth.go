@@ -0,0 +1,79 @@
+package helpers
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/log"
+)
+
+// IsDnsNotFound reports whether the error means that the name or record doesn't exist (NXDOMAIN),
+// as opposed to a resolver failure (timeout, SERVFAIL, ...)
+func IsDnsNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// DnsHealth keeps track of resolver failures, so a DNS outage isn't mistaken for missing records.
+// After Threshold consecutive failures the resolver is considered down (which is logged as an error)
+// until a lookup succeeds again.
+type DnsHealth struct {
+	Threshold int
+
+	mutex    sync.Mutex
+	failures int
+	down     bool
+	since    time.Time
+}
+
+// Result registers the result of a lookup, NXDOMAIN counts as a successful lookup
+func (h *DnsHealth) Result(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err == nil || IsDnsNotFound(err) {
+		if h.down {
+			log.Println("DNS resolver recovered after " + time.Since(h.since).Round(time.Second).String())
+		}
+		h.failures, h.down = 0, false
+		return
+	}
+
+	h.failures++
+	threshold := h.Threshold
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if !h.down && h.failures >= threshold {
+		h.down, h.since = true, time.Now()
+		log.Errorf("DNS resolver failing (%d lookups in a row), last error: %v", h.failures, err)
+	}
+}
+
+// Down reports whether the resolver is considered down
+func (h *DnsHealth) Down() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.down
+}
+
+// Backoff returns how long lookups which can wait (e.g. MX resolution for outbound delivery)
+// should be paused: 0 if the resolver works, growing exponentially up to 10 minutes while it's down
+func (h *DnsHealth) Backoff() time.Duration {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.down {
+		return 0
+	}
+	backoff := 10 * time.Minute
+	if h.failures < 10 {
+		backoff = time.Second << uint(h.failures)
+	}
+	if backoff > 10*time.Minute {
+		backoff = 10 * time.Minute
+	}
+	return backoff
+}
@@ -0,0 +1,187 @@
+package helpers
+
+import (
+	"path"
+	"strings"
+)
+
+// LocalDomains are the domains for which mail is delivered locally, keyed by domain name.
+// Every domain has its own users, so bob@domain-a and bob@domain-b are different mailboxes.
+type LocalDomains map[string]LocalDomain
+
+// LocalDomain maps the users (local parts) of a domain to their mailboxes
+type LocalDomain struct {
+	// Users maps local parts to mailboxes, an empty mailbox means <domain>/<local part>
+	Users map[string]string
+	// CatchAll is the mailbox for unknown users, mail for unknown users is refused if it's empty
+	CatchAll string
+	// TagCatchAll adds an X-Original-To header field with the original recipient to mail for the CatchAll mailbox
+	TagCatchAll bool
+	// Sieve maps local parts to the Sieve script which is run when mail for the user is delivered
+	Sieve map[string]string
+	// Vacation maps local parts to the automatic reply which is sent to their correspondents
+	Vacation map[string]AutoReply
+	// Quota is the size limit of each mailbox in MB, Quotas overrides it for some users (0 is unlimited)
+	Quota  int64
+	Quotas map[string]int64
+}
+
+// AutoReply is a vacation message (RFC 3834)
+type AutoReply struct {
+	// Subject of the reply, defaults to "Auto: " and the subject of the message
+	Subject string
+	Message string
+	// Days before the same correspondent gets another reply (default 7)
+	Days int
+	// Other addresses of the user, mail which isn't addressed to the user
+	// (or one of these addresses) in To or Cc isn't answered
+	Addresses []string
+}
+
+// domain returns the local domain of the address
+func (d LocalDomains) domain(address string) (string, LocalDomain, bool) {
+	i := strings.LastIndexByte(address, '@')
+	if i < 0 {
+		return "", LocalDomain{}, false
+	}
+	name := strings.TrimSuffix(address[i+1:], ".")
+	for domain, local := range d {
+		if EqualDomains(domain, name) {
+			return strings.ToLower(domain), local, true
+		}
+	}
+	return "", LocalDomain{}, false
+}
+
+// IsLocal reports whether the address is in one of the local domains
+func (d LocalDomains) IsLocal(address string) bool {
+	_, _, found := d.domain(address)
+	return found
+}
+
+// IsPostmaster reports whether the address is <postmaster> or postmaster@<local domain> (case insensitive),
+// which must always be accepted (RFC 5321 section 4.5.1)
+func (d LocalDomains) IsPostmaster(address string) bool {
+	if strings.EqualFold(address, "postmaster") {
+		return true
+	}
+	i := strings.LastIndexByte(address, '@')
+	return i >= 0 && strings.EqualFold(address[:i], "postmaster") && d.IsLocal(address)
+}
+
+// Mailbox returns the mailbox of a local address,
+// false is returned if the domain isn't local or the user doesn't exist.
+// With a delimiter, user+tag@domain is delivered to the mailbox of user@domain
+// (unless user+tag is a user itself).
+func (d LocalDomains) Mailbox(address string, delimiter string) (string, bool) {
+	mailbox, _, found := d.lookup(address, delimiter)
+	return mailbox, found
+}
+
+// TagOriginal reports whether the original recipient should be tagged in a header field,
+// which is the case for mail to the catch-all mailbox of a domain with TagCatchAll
+func (d LocalDomains) TagOriginal(address string, delimiter string) bool {
+	_, catchAll, found := d.lookup(address, delimiter)
+	if !found || !catchAll {
+		return false
+	}
+	_, local, _ := d.domain(address)
+	return local.TagCatchAll
+}
+
+// Script returns the file of the Sieve script of a local address,
+// an empty string is returned if the user doesn't have one
+func (d LocalDomains) Script(address string, delimiter string) string {
+	_, local, found := d.domain(address)
+	if !found {
+		return ""
+	}
+	for _, u := range localParts(address, delimiter) {
+		for name, script := range local.Sieve {
+			if strings.EqualFold(name, u) {
+				return script
+			}
+		}
+	}
+	return ""
+}
+
+// AutoReply returns the vacation message of a local address, or nil if the user doesn't have one
+func (d LocalDomains) AutoReply(address string, delimiter string) *AutoReply {
+	_, local, found := d.domain(address)
+	if !found {
+		return nil
+	}
+	for _, u := range localParts(address, delimiter) {
+		for name, reply := range local.Vacation {
+			if strings.EqualFold(name, u) {
+				return &reply
+			}
+		}
+	}
+	return nil
+}
+
+// Quota returns the quota of a local address in bytes, 0 means unlimited
+func (d LocalDomains) Quota(address string, delimiter string) int64 {
+	_, local, found := d.domain(address)
+	if !found {
+		return 0
+	}
+	for _, u := range localParts(address, delimiter) {
+		for name, quota := range local.Quotas {
+			if strings.EqualFold(name, u) {
+				return quota << 20
+			}
+		}
+	}
+	return local.Quota << 20
+}
+
+// localParts returns the local part of the address and the local part without the subaddress,
+// in the order in which users are looked up
+func localParts(address string, delimiter string) []string {
+	user := address[:strings.LastIndexByte(address, '@')]
+	base, _ := SplitSubaddress(user, delimiter)
+	return []string{user, base}
+}
+
+// lookup returns the mailbox of a local address and whether it's the catch-all mailbox
+func (d LocalDomains) lookup(address string, delimiter string) (string, bool, bool) {
+	domain, local, found := d.domain(address)
+	if !found {
+		return "", false, false
+	}
+	for _, u := range localParts(address, delimiter) {
+		for name, mailbox := range local.Users {
+			if strings.EqualFold(name, u) {
+				if mailbox == "" {
+					mailbox = path.Join(domain, strings.ToLower(name))
+				}
+				return mailbox, false, true
+			}
+		}
+	}
+	if local.CatchAll != "" {
+		return local.CatchAll, true, true
+	}
+	return "", false, false
+}
+
+// SplitSubaddress splits a local part (or address) in the user and the tag (RFC 5233):
+// bob+lists@example.com becomes bob@example.com and lists for delimiter +.
+// If there's no delimiter (or no tag) the address is returned as is with an empty tag.
+func SplitSubaddress(address string, delimiter string) (string, string) {
+	if delimiter == "" {
+		return address, ""
+	}
+	local, domain := address, ""
+	if i := strings.LastIndexByte(address, '@'); i >= 0 {
+		local, domain = address[:i], address[i:]
+	}
+	i := strings.Index(local, delimiter)
+	if i <= 0 {
+		return address, ""
+	}
+	return local[:i] + domain, local[i+len(delimiter):]
+}
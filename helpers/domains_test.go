@@ -0,0 +1,97 @@
+package helpers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLocalDomains(t *testing.T) {
+
+	Convey("Testing LocalDomains", t, func() {
+		d := LocalDomains{
+			"Domain-A.example": {Users: map[string]string{"bob": "", "postmaster": "admins"}, Sieve: map[string]string{"bob": "bob.sieve"}, Vacation: map[string]AutoReply{"Bob": {Message: "Away"}}},
+			"domain-b.example": {Users: map[string]string{"bob": ""}, CatchAll: "domain-b.example/catchall", TagCatchAll: true, Quota: 10, Quotas: map[string]int64{"bob": 100}},
+		}
+
+		So(d.IsLocal("bob@domain-a.example"), ShouldEqual, true)
+		So(d.IsLocal("bob@example.com"), ShouldEqual, false)
+		So(d.IsLocal("bob"), ShouldEqual, false)
+		So(LocalDomains{"xn--bcher-kva.example": {}}.IsLocal("bob@Bücher.example"), ShouldEqual, true)
+		So(LocalDomains{"bücher.example": {}}.IsLocal("bob@xn--bcher-kva.example"), ShouldEqual, true)
+
+		So(d.IsPostmaster("PostMaster"), ShouldEqual, true)
+		So(d.IsPostmaster("postmaster@DOMAIN-B.example"), ShouldEqual, true)
+		So(d.IsPostmaster("postmaster@example.com"), ShouldEqual, false)
+		So(d.IsPostmaster("bob@domain-b.example"), ShouldEqual, false)
+
+		mailbox, found := d.Mailbox("Bob@domain-a.example", "")
+		So(found, ShouldEqual, true)
+		So(mailbox, ShouldEqual, "domain-a.example/bob")
+
+		mailbox, _ = d.Mailbox("bob@domain-b.example", "")
+		So(mailbox, ShouldEqual, "domain-b.example/bob")
+
+		mailbox, _ = d.Mailbox("postmaster@domain-a.example", "")
+		So(mailbox, ShouldEqual, "admins")
+
+		_, found = d.Mailbox("alice@domain-a.example", "")
+		So(found, ShouldEqual, false)
+
+		mailbox, found = d.Mailbox("alice@domain-b.example", "")
+		So(found, ShouldEqual, true)
+		So(mailbox, ShouldEqual, "domain-b.example/catchall")
+
+		_, found = d.Mailbox("bob@example.com", "")
+		So(found, ShouldEqual, false)
+
+		So(d.TagOriginal("alice@domain-b.example", ""), ShouldEqual, true)
+		So(d.TagOriginal("bob@domain-b.example", ""), ShouldEqual, false)
+		So(d.TagOriginal("alice@domain-a.example", ""), ShouldEqual, false)
+
+		// subaddresses
+		mailbox, found = d.Mailbox("bob+lists@domain-a.example", "+")
+		So(found, ShouldEqual, true)
+		So(mailbox, ShouldEqual, "domain-a.example/bob")
+		_, found = d.Mailbox("bob+lists@domain-a.example", "")
+		So(found, ShouldEqual, false)
+		mailbox, _ = d.Mailbox("bob+lists@domain-b.example", "+")
+		So(mailbox, ShouldEqual, "domain-b.example/bob")
+		So(d.TagOriginal("bob+lists@domain-b.example", "+"), ShouldEqual, false)
+
+		// sieve scripts
+		So(d.Script("bob@domain-a.example", ""), ShouldEqual, "bob.sieve")
+		So(d.Script("bob+lists@domain-a.example", "+"), ShouldEqual, "bob.sieve")
+		So(d.Script("postmaster@domain-a.example", ""), ShouldEqual, "")
+		So(d.Script("bob@domain-b.example", ""), ShouldEqual, "")
+
+		// vacation messages
+		So(d.AutoReply("bob+lists@domain-a.example", "+").Message, ShouldEqual, "Away")
+		So(d.AutoReply("postmaster@domain-a.example", ""), ShouldEqual, nil)
+		So(d.AutoReply("bob@example.com", ""), ShouldEqual, nil)
+
+		// quotas
+		So(d.Quota("bob@domain-a.example", ""), ShouldEqual, 0)
+		So(d.Quota("bob+lists@domain-b.example", "+"), ShouldEqual, 100<<20)
+		So(d.Quota("alice@domain-b.example", ""), ShouldEqual, 10<<20)
+	})
+
+	Convey("Testing SplitSubaddress()", t, func() {
+		user, tag := SplitSubaddress("bob+lists@example.com", "+")
+		So(user, ShouldEqual, "bob@example.com")
+		So(tag, ShouldEqual, "lists")
+
+		user, tag = SplitSubaddress("bob--a+b", "--")
+		So(user, ShouldEqual, "bob")
+		So(tag, ShouldEqual, "a+b")
+
+		user, tag = SplitSubaddress("+bob@example.com", "+")
+		So(user, ShouldEqual, "+bob@example.com")
+		So(tag, ShouldEqual, "")
+
+		user, tag = SplitSubaddress("bob+lists@example.com", "")
+		So(user, ShouldEqual, "bob+lists@example.com")
+		So(tag, ShouldEqual, "")
+	})
+
+}
@@ -0,0 +1,219 @@
+package helpers
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions of a delivery status notification (RFC 3464 section 2.3.3)
+const (
+	DsnDelivered = "delivered"
+	DsnRelayed   = "relayed"
+	DsnFailed    = "failed"
+)
+
+// dsnRequestTTL is how long the DSN parameters of a transaction are kept,
+// messages which are still queued after that don't get a success notification
+const dsnRequestTTL = 5 * 24 * time.Hour
+
+// DsnRequest contains the DSN parameters (RFC 3461) of a recipient
+type DsnRequest struct {
+	// Notify is the NOTIFY parameter of RCPT (e.g. SUCCESS,FAILURE)
+	Notify string
+	// Orcpt is the ORCPT parameter of RCPT (e.g. rfc822;bob@example.com)
+	Orcpt string
+	// Ret and EnvId are the RET and ENVID parameters of MAIL
+	Ret   string
+	EnvId string
+
+	expires time.Time
+}
+
+// WantsFailure reports whether the sender wants to be notified of a failure,
+// which is the default without NOTIFY (RFC 3461 section 4.1)
+func (r DsnRequest) WantsFailure() bool {
+	return r.Notify == "" || r.Wants("FAILURE")
+}
+
+// Wants reports whether the sender asked for the notification (SUCCESS, FAILURE or DELAY)
+func (r DsnRequest) Wants(notification string) bool {
+	for _, n := range strings.Split(r.Notify, ",") {
+		if strings.EqualFold(strings.TrimSpace(n), notification) {
+			return true
+		}
+	}
+	return false
+}
+
+// DsnRequests remembers the DSN parameters given with MAIL and RCPT, keyed by session ID and recipient,
+// so the delivery handlers know which notifications the sender asked for.
+// The session registers the parameters with Set when it accepts RCPT.
+type DsnRequests struct {
+	mutex    sync.Mutex
+	requests map[string]DsnRequest
+}
+
+func dsnKey(sessionId, recipient string) string {
+	return sessionId + "\x00" + strings.ToLower(recipient)
+}
+
+// Set registers the parameters of MAIL and RCPT for the recipient of the transaction
+func (d *DsnRequests) Set(sessionId, recipient string, mailParams, rcptParams map[string]string) {
+	request := DsnRequest{
+		Notify:  rcptParams["NOTIFY"],
+		Orcpt:   rcptParams["ORCPT"],
+		Ret:     mailParams["RET"],
+		EnvId:   mailParams["ENVID"],
+		expires: time.Now().Add(dsnRequestTTL),
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.requests == nil {
+		d.requests = make(map[string]DsnRequest)
+	}
+	d.requests[dsnKey(sessionId, recipient)] = request
+}
+
+// Get returns the parameters for the recipient of the transaction
+func (d *DsnRequests) Get(sessionId, recipient string) (DsnRequest, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	request, found := d.requests[dsnKey(sessionId, recipient)]
+	if found && time.Now().After(request.expires) {
+		return DsnRequest{}, false
+	}
+	return request, found
+}
+
+// Forget removes the parameters for the recipient, when its final notification is sent
+func (d *DsnRequests) Forget(sessionId, recipient string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.requests, dsnKey(sessionId, recipient))
+}
+
+// Cleanup removes the expired parameters, it should be called periodically
+func (d *DsnRequests) Cleanup() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	now := time.Now()
+	for key, request := range d.requests {
+		if now.After(request.expires) {
+			delete(d.requests, key)
+		}
+	}
+}
+
+// DsnRecipient is a recipient in a delivery status notification
+type DsnRecipient struct {
+	Recipient string
+	// Action is DsnDelivered, DsnRelayed or DsnFailed
+	Action  string
+	Request DsnRequest
+	// Status is the enhanced status code (RFC 3463), 2.0.0 if it's empty
+	Status string
+	// Diagnostic is the reply of the failed delivery, e.g. "550 5.1.1 User unknown"
+	Diagnostic string
+}
+
+// NewSuccessDsn creates a delivery status notification (RFC 3464) for the sender of the message,
+// reporting that it was delivered or relayed to the recipients.
+// The original message is included as a whole if RET=FULL was asked for, only its header otherwise.
+// The texts come from the catalog, which may be nil.
+func NewSuccessDsn(texts *Catalog, hostname, sender string, recipients []DsnRecipient, original []byte) []byte {
+	return newDsn(texts, "dsn.success", hostname, sender, recipients, original)
+}
+
+// NewFailureDsn creates a delivery status notification (RFC 3464) for the sender of the message,
+// reporting that it couldn't be delivered to the recipients
+func NewFailureDsn(texts *Catalog, hostname, sender string, recipients []DsnRecipient, original []byte) []byte {
+	return newDsn(texts, "dsn.failure", hostname, sender, recipients, original)
+}
+
+// newDsn creates the notification, kind is the prefix of its subject and explanation in the catalog
+func newDsn(texts *Catalog, kind, hostname, sender string, recipients []DsnRecipient, original []byte) []byte {
+	boundary := NewId()
+	now := time.Now().Format(time.RFC1123Z)
+	clean := strings.NewReplacer("\r", "", "\n", "")
+
+	b := &bytes.Buffer{}
+	// the texts end up in the header fields and the body, whose lines end in CRLF
+	text := func(key string) string {
+		return texts.Text(key, "hostname", hostname)
+	}
+	lines := strings.NewReplacer("\r\n", "\r\n", "\n", "\r\n")
+
+	fmt.Fprintf(b, "From: %s <MAILER-DAEMON@%s>\r\n", clean.Replace(text("dsn.from")), hostname)
+	fmt.Fprintf(b, "To: <%s>\r\n", clean.Replace(sender))
+	fmt.Fprintf(b, "Subject: %s\r\n", clean.Replace(text(kind+".subject")))
+	fmt.Fprintf(b, "Date: %s\r\n", now)
+	fmt.Fprintf(b, "Message-ID: %s\r\n", NewMessageId(hostname))
+	fmt.Fprintf(b, "Auto-Submitted: auto-replied\r\n")
+	fmt.Fprintf(b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(b, "Content-Type: multipart/report; report-type=delivery-status;\r\n\tboundary=\"%s\"\r\n\r\n", boundary)
+
+	// human readable part
+	fmt.Fprintf(b, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n", boundary)
+	fmt.Fprintf(b, "%s\r\n\r\n", lines.Replace(text("dsn.intro")))
+	fmt.Fprintf(b, "%s\r\n\r\n", lines.Replace(text(kind+".explanation")))
+	for _, r := range recipients {
+		what := text("dsn.delivered")
+		switch r.Action {
+		case DsnRelayed:
+			what = text("dsn.relayed")
+		case DsnFailed:
+			what = text("dsn.failed")
+			if r.Diagnostic != "" {
+				what += ": " + clean.Replace(r.Diagnostic)
+			}
+		}
+		fmt.Fprintf(b, "<%s>: %s\r\n", clean.Replace(r.Recipient), what)
+	}
+	b.WriteString("\r\n")
+
+	// machine readable part
+	fmt.Fprintf(b, "--%s\r\nContent-Type: message/delivery-status\r\n\r\n", boundary)
+	fmt.Fprintf(b, "Reporting-MTA: dns; %s\r\n", hostname)
+	if len(recipients) > 0 && recipients[0].Request.EnvId != "" {
+		fmt.Fprintf(b, "Original-Envelope-Id: %s\r\n", clean.Replace(recipients[0].Request.EnvId))
+	}
+	fmt.Fprintf(b, "Arrival-Date: %s\r\n", now)
+	for _, r := range recipients {
+		b.WriteString("\r\n")
+		fmt.Fprintf(b, "Final-Recipient: rfc822; %s\r\n", clean.Replace(r.Recipient))
+		if r.Request.Orcpt != "" {
+			fmt.Fprintf(b, "Original-Recipient: %s\r\n", clean.Replace(r.Request.Orcpt))
+		}
+		fmt.Fprintf(b, "Action: %s\r\n", r.Action)
+		status := r.Status
+		if status == "" {
+			status = "2.0.0"
+		}
+		fmt.Fprintf(b, "Status: %s\r\n", status)
+		if r.Diagnostic != "" {
+			fmt.Fprintf(b, "Diagnostic-Code: smtp; %s\r\n", clean.Replace(r.Diagnostic))
+		}
+	}
+	b.WriteString("\r\n")
+
+	// the original message, or its header
+	if len(recipients) > 0 && strings.EqualFold(recipients[0].Request.Ret, "FULL") {
+		fmt.Fprintf(b, "--%s\r\nContent-Type: message/rfc822\r\n\r\n", boundary)
+		b.Write(original)
+	} else {
+		fmt.Fprintf(b, "--%s\r\nContent-Type: text/rfc822-headers\r\n\r\n", boundary)
+		fields, _ := SplitHeader(original)
+		for _, field := range fields {
+			b.WriteString(field)
+		}
+	}
+	if !bytes.HasSuffix(b.Bytes(), []byte("\r\n")) {
+		b.WriteString("\r\n")
+	}
+	fmt.Fprintf(b, "\r\n--%s--\r\n", boundary)
+	return b.Bytes()
+}
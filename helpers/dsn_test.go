@@ -0,0 +1,80 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDsn(t *testing.T) {
+
+	Convey("Testing DsnRequests", t, func() {
+		d := DsnRequests{}
+		d.Set("1.1", "Bob@example.com", map[string]string{"RET": "HDRS", "ENVID": "abc"}, map[string]string{"NOTIFY": "SUCCESS,FAILURE"})
+
+		request, found := d.Get("1.1", "bob@example.com")
+		So(found, ShouldEqual, true)
+		So(request.Wants("SUCCESS"), ShouldEqual, true)
+		So(request.Wants("DELAY"), ShouldEqual, false)
+		So(request.EnvId, ShouldEqual, "abc")
+
+		_, found = d.Get("1.2", "bob@example.com")
+		So(found, ShouldEqual, false)
+
+		d.Forget("1.1", "bob@example.com")
+		_, found = d.Get("1.1", "bob@example.com")
+		So(found, ShouldEqual, false)
+
+		So(DsnRequest{Notify: "NEVER"}.Wants("SUCCESS"), ShouldEqual, false)
+	})
+
+	Convey("Testing NewSuccessDsn", t, func() {
+		original := []byte("Subject: Hello\r\nMessage-ID: <1@example.com>\r\n\r\nSecret body\r\n")
+		recipients := []DsnRecipient{
+			{Recipient: "bob@example.com", Action: DsnDelivered, Request: DsnRequest{Notify: "SUCCESS", Orcpt: "rfc822;bob@example.com", EnvId: "abc"}},
+			{Recipient: "alice@example.org", Action: DsnRelayed, Request: DsnRequest{Notify: "SUCCESS"}},
+		}
+
+		dsn := string(NewSuccessDsn(nil, "mx.example.com", "sender@example.net", recipients, original))
+		So(dsn, ShouldContainSubstring, "To: <sender@example.net>\r\n")
+		So(dsn, ShouldContainSubstring, "Content-Type: multipart/report; report-type=delivery-status;")
+		So(dsn, ShouldContainSubstring, "Reporting-MTA: dns; mx.example.com\r\nOriginal-Envelope-Id: abc\r\n")
+		So(dsn, ShouldContainSubstring, "Final-Recipient: rfc822; bob@example.com\r\nOriginal-Recipient: rfc822;bob@example.com\r\nAction: delivered\r\nStatus: 2.0.0\r\n")
+		So(dsn, ShouldContainSubstring, "Final-Recipient: rfc822; alice@example.org\r\nAction: relayed\r\nStatus: 2.0.0\r\n")
+		So(dsn, ShouldContainSubstring, "Content-Type: text/rfc822-headers\r\n\r\nSubject: Hello\r\n")
+		So(dsn, ShouldNotContainSubstring, "Secret body")
+		So(strings.HasSuffix(dsn, "--\r\n"), ShouldEqual, true)
+
+		// RET=FULL includes the whole message
+		recipients[0].Request.Ret = "FULL"
+		dsn = string(NewSuccessDsn(nil, "mx.example.com", "sender@example.net", recipients, original))
+		So(dsn, ShouldContainSubstring, "Content-Type: message/rfc822\r\n\r\nSubject: Hello\r\n")
+		So(dsn, ShouldContainSubstring, "Secret body")
+	})
+
+	Convey("Testing NewFailureDsn", t, func() {
+		original := []byte("Subject: Hello\r\n\r\nBody\r\n")
+		recipients := []DsnRecipient{
+			{Recipient: "nobody@example.com", Action: DsnFailed, Status: "5.1.1", Diagnostic: "550 5.1.1 User unknown"},
+		}
+
+		dsn := string(NewFailureDsn(nil, "mx.example.com", "sender@example.net", recipients, original))
+		So(dsn, ShouldContainSubstring, "Subject: Undelivered Mail Returned to Sender\r\n")
+		So(dsn, ShouldContainSubstring, "<nobody@example.com>: delivery failed: 550 5.1.1 User unknown\r\n")
+		So(dsn, ShouldContainSubstring, "Final-Recipient: rfc822; nobody@example.com\r\nAction: failed\r\nStatus: 5.1.1\r\nDiagnostic-Code: smtp; 550 5.1.1 User unknown\r\n")
+
+		// the texts can be replaced, the status codes stay
+		texts := &Catalog{Texts: map[string]string{
+			"dsn.failure.subject": "Onbestelbaar bericht",
+			"dsn.intro":           "Dit is de mailserver {hostname}.",
+			"dsn.failed":          "niet afgeleverd",
+		}}
+		dsn = string(NewFailureDsn(texts, "mx.example.com", "sender@example.net", recipients, original))
+		So(dsn, ShouldContainSubstring, "Subject: Onbestelbaar bericht\r\n")
+		So(dsn, ShouldContainSubstring, "Dit is de mailserver mx.example.com.\r\n\r\nYour message could not be delivered")
+		So(dsn, ShouldContainSubstring, "<nobody@example.com>: niet afgeleverd: 550 5.1.1 User unknown\r\n")
+		So(dsn, ShouldContainSubstring, "Status: 5.1.1\r\n")
+	})
+
+}
@@ -0,0 +1,42 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFileDurably writes the data to a temporary file next to the file, flushes it to the disk
+// and renames it, so after a crash the file is either complete or unchanged.
+// The directory is flushed as well, so the renamed file survives the crash.
+func WriteFileDurably(fileName string, data []byte, perm os.FileMode) error {
+	tmp := fileName + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, fileName)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return SyncDir(filepath.Dir(fileName))
+}
+
+// SyncDir flushes the entries of the directory to the disk
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,32 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteFileDurably(t *testing.T) {
+
+	Convey("Testing WriteFileDurably()", t, func() {
+		dir, err := ioutil.TempDir("", "durable")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		file := filepath.Join(dir, "message.json")
+		So(WriteFileDurably(file, []byte("{}"), 0600), ShouldEqual, nil)
+		So(WriteFileDurably(file, []byte(`{"To": []}`), 0600), ShouldEqual, nil)
+		data, _ := ioutil.ReadFile(file)
+		So(string(data), ShouldEqual, `{"To": []}`)
+
+		// the temporary file is gone
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		So(files, ShouldResemble, []string{file})
+
+		So(WriteFileDurably(filepath.Join(dir, "missing", "message.json"), nil, 0600), ShouldNotEqual, nil)
+	})
+
+}
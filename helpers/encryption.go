@@ -0,0 +1,85 @@
+package helpers
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+// encryptedMagic starts the messages which were encrypted with Seal
+var encryptedMagic = []byte("GPENC1\n")
+
+// ErrNoKey is returned when an encrypted message is opened without a key
+var ErrNoKey = errors.New("message is encrypted, but there's no encryption key")
+
+// Encryption encrypts the messages at rest (in the queue and in the maildirs) with AES-256-GCM,
+// so they can't be read from a leaked disk without the key. KeyFile contains the 32 byte key
+// hex encoded (e.g. openssl rand -hex 32), the messages aren't encrypted without it.
+// Messages which were stored before the encryption was enabled are still read.
+type Encryption struct {
+	KeyFile string
+
+	aead cipher.AEAD
+}
+
+// Load reads the key from the KeyFile
+func (e *Encryption) Load() error {
+	data, err := ioutil.ReadFile(e.KeyFile)
+	if err != nil {
+		return err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return errors.New(e.KeyFile + ": the key should be 32 hex encoded bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	e.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// Enabled reports whether the messages are encrypted
+func (e *Encryption) Enabled() bool {
+	return e.aead != nil
+}
+
+// IsEncrypted reports whether the data was encrypted with Seal
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// Seal encrypts the data, it's returned as is if the encryption isn't enabled
+func (e *Encryption) Seal(data []byte) ([]byte, error) {
+	if !e.Enabled() || IsEncrypted(data) {
+		return data, nil
+	}
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append([]byte{}, encryptedMagic...), nonce...)
+	return e.aead.Seal(sealed, nonce, data, encryptedMagic), nil
+}
+
+// Open decrypts the data which was encrypted with Seal, other data is returned as is
+func (e *Encryption) Open(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	if !e.Enabled() {
+		return nil, ErrNoKey
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < e.aead.NonceSize() {
+		return nil, errors.New("encrypted message is truncated")
+	}
+	nonce := data[:e.aead.NonceSize()]
+	return e.aead.Open(nil, nonce, data[len(nonce):], encryptedMagic)
+}
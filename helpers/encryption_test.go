@@ -0,0 +1,59 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncryption(t *testing.T) {
+
+	Convey("Testing Encryption", t, func() {
+		file, err := ioutil.TempFile("", "key")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(file.Name())
+		file.WriteString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n")
+		file.Close()
+
+		message := []byte("Subject: secret\r\n\r\nbody\r\n")
+
+		// without a key, the messages are stored as they are
+		e := &Encryption{}
+		So(e.Enabled(), ShouldEqual, false)
+		data, err := e.Seal(message)
+		So(err, ShouldEqual, nil)
+		So(data, ShouldResemble, message)
+
+		e.KeyFile = file.Name()
+		So(e.Load(), ShouldEqual, nil)
+		So(e.Enabled(), ShouldEqual, true)
+		sealed, err := e.Seal(message)
+		So(err, ShouldEqual, nil)
+		So(IsEncrypted(sealed), ShouldEqual, true)
+		So(string(sealed), ShouldNotContainSubstring, "secret")
+		opened, err := e.Open(sealed)
+		So(err, ShouldEqual, nil)
+		So(opened, ShouldResemble, message)
+
+		// every message has its own nonce
+		again, _ := e.Seal(message)
+		So(again, ShouldNotResemble, sealed)
+
+		// messages which were stored before are still read
+		opened, err = e.Open(message)
+		So(err, ShouldEqual, nil)
+		So(opened, ShouldResemble, message)
+
+		sealed[len(sealed)-1] ^= 1
+		_, err = e.Open(sealed)
+		So(err, ShouldNotEqual, nil)
+		_, err = (&Encryption{}).Open(again)
+		So(err, ShouldEqual, ErrNoKey)
+
+		ioutil.WriteFile(file.Name(), []byte("0001"), 0600)
+		So(e.Load(), ShouldNotEqual, nil)
+	})
+
+}
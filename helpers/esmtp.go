@@ -0,0 +1,170 @@
+package helpers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EsmtpError is an error in the arguments of MAIL or RCPT, with the reply code to send:
+// 501 for syntax errors and 555 for parameters which aren't recognized or implemented
+type EsmtpError struct {
+	Code    int
+	Message string
+}
+
+func (e *EsmtpError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+// esmtpParam describes a parameter of MAIL or RCPT
+type esmtpParam struct {
+	command   string
+	extension string
+	// validate checks the value, nil means the parameter has no value
+	validate func(value string) bool
+}
+
+// esmtpParams are the supported parameters, keyed by (upper case) keyword
+var esmtpParams = map[string]esmtpParam{
+	"SIZE":     {"MAIL", "SIZE", isNumber},
+	"BODY":     {"MAIL", "8BITMIME", oneOf("7BIT", "8BITMIME")}, // BINARYMIME needs CHUNKING (RFC 3030)
+	"AUTH":     {"MAIL", "AUTH", func(v string) bool { return v == "<>" || isXtext(v) }},
+	"SMTPUTF8": {"MAIL", "SMTPUTF8", nil},
+	"RET":      {"MAIL", "DSN", oneOf("FULL", "HDRS")},
+	"ENVID":    {"MAIL", "DSN", isXtext},
+	"NOTIFY":   {"RCPT", "DSN", isNotify},
+	"ORCPT":    {"RCPT", "DSN", isOrcpt},
+	"PRDR":     {"MAIL", "PRDR", nil},
+}
+
+// ParseEsmtpArgs parses the argument of MAIL FROM: or RCPT TO:, the path followed by
+// esmtp-params (RFC 5321 section 4.1.2): '<user@example.com> SIZE=1000 BODY=8BITMIME'.
+// The path is checked with ParsePath and returned without its angle brackets.
+// The keywords of the parameters are returned in upper case, parameters without value map to "".
+func ParseEsmtpArgs(args string) (string, map[string]string, error) {
+	args = strings.TrimSpace(args)
+	if !strings.HasPrefix(args, "<") {
+		return "", nil, errPathSyntax
+	}
+	end := pathEnd(args)
+	if end < 0 {
+		return "", nil, errPathSyntax
+	}
+	path := args[1:end]
+	if _, _, err := ParsePath(path); err != nil {
+		return "", nil, err
+	}
+	if rest := args[end+1:]; rest != "" && rest[0] != ' ' {
+		return "", nil, errPathSyntax
+	}
+
+	params := make(map[string]string)
+	for _, param := range strings.Fields(args[end+1:]) {
+		keyword, value := param, ""
+		hasValue := false
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			keyword, value, hasValue = param[:i], param[i+1:], true
+		}
+		if !isEsmtpKeyword(keyword) || (hasValue && value == "") {
+			return "", nil, &EsmtpError{501, "Syntax error in parameter " + param}
+		}
+		keyword = strings.ToUpper(keyword)
+		if _, duplicate := params[keyword]; duplicate {
+			return "", nil, &EsmtpError{501, "Duplicate parameter " + keyword}
+		}
+		params[keyword] = value
+	}
+	return path, params, nil
+}
+
+// ValidateEsmtpParams checks the parameters of a MAIL or RCPT command against the
+// advertised extensions (upper case EHLO keywords)
+func ValidateEsmtpParams(command string, params map[string]string, extensions map[string]bool) error {
+	command = strings.ToUpper(command)
+	for keyword, value := range params {
+		param, known := esmtpParams[keyword]
+		if !known || param.command != command || !extensions[param.extension] {
+			return &EsmtpError{555, keyword + " parameter not recognized or not implemented"}
+		}
+		if param.validate == nil && value != "" {
+			return &EsmtpError{501, keyword + " parameter doesn't take a value"}
+		}
+		if param.validate != nil && !param.validate(value) {
+			return &EsmtpError{501, "Invalid value for " + keyword + " parameter"}
+		}
+	}
+	return nil
+}
+
+// isEsmtpKeyword checks esmtp-keyword = (ALPHA / DIGIT) *(ALPHA / DIGIT / "-")
+func isEsmtpKeyword(s string) bool {
+	if s == "" || s[0] == '-' {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+func oneOf(values ...string) func(string) bool {
+	return func(s string) bool {
+		for _, v := range values {
+			if strings.EqualFold(s, v) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// isXtext checks xtext (RFC 3461 section 4): printable ASCII except + and =, or +XX hex escapes
+func isXtext(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '+':
+			if i+2 >= len(s) || !isUpperHex(s[i+1]) || !isUpperHex(s[i+2]) {
+				return false
+			}
+			i += 2
+		case c < '!' || c > '~' || c == '=':
+			return false
+		}
+	}
+	return true
+}
+
+func isUpperHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'F'
+}
+
+// isNotify checks the NOTIFY parameter (RFC 3461 section 4.1): NEVER or a list of SUCCESS, FAILURE and DELAY
+func isNotify(s string) bool {
+	if strings.EqualFold(s, "NEVER") {
+		return true
+	}
+	for _, v := range strings.Split(s, ",") {
+		if !oneOf("SUCCESS", "FAILURE", "DELAY")(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// isOrcpt checks the ORCPT parameter (RFC 3461 section 4.2): addr-type ";" xtext
+func isOrcpt(s string) bool {
+	i := strings.IndexByte(s, ';')
+	return i > 0 && isEsmtpKeyword(s[:i]) && isXtext(s[i+1:])
+}
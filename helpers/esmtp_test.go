@@ -0,0 +1,66 @@
+package helpers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEsmtpParams(t *testing.T) {
+
+	Convey("Testing ParseEsmtpArgs()", t, func() {
+		path, params, err := ParseEsmtpArgs("<from@example.com> size=1000 BODY=8BITMIME SMTPUTF8")
+		So(err, ShouldEqual, nil)
+		So(path, ShouldEqual, "from@example.com")
+		So(params, ShouldResemble, map[string]string{"SIZE": "1000", "BODY": "8BITMIME", "SMTPUTF8": ""})
+
+		path, params, err = ParseEsmtpArgs("<>")
+		So(err, ShouldEqual, nil)
+		So(path, ShouldEqual, "")
+		So(params, ShouldBeEmpty)
+
+		code := func(args string) int {
+			_, _, err := ParseEsmtpArgs(args)
+			if err == nil {
+				return 0
+			}
+			return err.(*EsmtpError).Code
+		}
+		So(code("from@example.com"), ShouldEqual, 501)
+		So(code("<from@example.com"), ShouldEqual, 501)
+		So(code("<from@example.com> SIZE="), ShouldEqual, 501)
+		So(code("<from@example.com> SI_ZE=1"), ShouldEqual, 501)
+		So(code("<from@example.com> SIZE=1 size=2"), ShouldEqual, 501)
+	})
+
+	Convey("Testing ValidateEsmtpParams()", t, func() {
+		extensions := map[string]bool{"SIZE": true, "8BITMIME": true, "DSN": true}
+		code := func(command string, args string) int {
+			_, params, err := ParseEsmtpArgs(args)
+			So(err, ShouldEqual, nil)
+			err = ValidateEsmtpParams(command, params, extensions)
+			if err == nil {
+				return 0
+			}
+			return err.(*EsmtpError).Code
+		}
+
+		So(code("MAIL", "<a@example.com> SIZE=1000 BODY=7bit RET=HDRS ENVID=abc+2B1"), ShouldEqual, 0)
+		So(code("RCPT", "<b@example.com> NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;b@example.com"), ShouldEqual, 0)
+		So(code("RCPT", "<b@example.com> NOTIFY=NEVER"), ShouldEqual, 0)
+
+		// not advertised, unknown or for the other command
+		So(code("MAIL", "<a@example.com> SMTPUTF8"), ShouldEqual, 555)
+		So(code("MAIL", "<a@example.com> AUTH=<>"), ShouldEqual, 555)
+		So(code("MAIL", "<a@example.com> X-FOO=1"), ShouldEqual, 555)
+		So(code("MAIL", "<a@example.com> NOTIFY=NEVER"), ShouldEqual, 555)
+
+		// malformed values
+		So(code("MAIL", "<a@example.com> SIZE=big"), ShouldEqual, 501)
+		So(code("MAIL", "<a@example.com> BODY=9BIT"), ShouldEqual, 501)
+		So(code("MAIL", "<a@example.com> ENVID=a+zz"), ShouldEqual, 501)
+		So(code("RCPT", "<b@example.com> NOTIFY=SOMETIMES"), ShouldEqual, 501)
+		So(code("RCPT", "<b@example.com> ORCPT=b@example.com"), ShouldEqual, 501)
+	})
+
+}
@@ -0,0 +1,112 @@
+package helpers
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Greylist temporarily refuses the first attempt to deliver mail from a sender to a recipient
+// from a client (a triplet), and accepts the retry which comes after Delay seconds (default 5 minutes).
+// Legitimate servers retry, most spamware doesn't. A triplet which wasn't retried within Expire seconds
+// (default 4 hours) starts over, a triplet which passed is accepted for Keep seconds (default 36 days).
+// Clients are grouped by their /24 (IPv4) or /64 (IPv6) network, as servers of a pool retry from other hosts.
+type Greylist struct {
+	Delay  int
+	Expire int
+	Keep   int
+
+	// now is time.Now, it can be replaced for testing
+	now func() time.Time
+
+	mutex    sync.Mutex
+	triplets map[string]greylistEntry
+}
+
+type greylistEntry struct {
+	firstSeen time.Time
+	passed    time.Time
+}
+
+func (g *Greylist) time() time.Time {
+	if g.now != nil {
+		return g.now()
+	}
+	return time.Now()
+}
+
+func (g *Greylist) delay() time.Duration {
+	if g.Delay <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(g.Delay) * time.Second
+}
+
+func (g *Greylist) expire() time.Duration {
+	if g.Expire <= 0 {
+		return 4 * time.Hour
+	}
+	return time.Duration(g.Expire) * time.Second
+}
+
+func (g *Greylist) keep() time.Duration {
+	if g.Keep <= 0 {
+		return 36 * 24 * time.Hour
+	}
+	return time.Duration(g.Keep) * time.Second
+}
+
+// Check reports whether mail from the sender to the recipient is accepted from the IP,
+// the first attempt of a triplet is refused and remembered
+func (g *Greylist) Check(ip, from, to string) bool {
+	key := greylistNetwork(ip) + "/" + strings.ToLower(from) + "/" + strings.ToLower(to)
+	now := g.time()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.triplets == nil {
+		g.triplets = make(map[string]greylistEntry)
+	}
+	entry, found := g.triplets[key]
+	switch {
+	case found && !entry.passed.IsZero() && now.Sub(entry.passed) < g.keep():
+		entry.passed = now
+	case found && entry.passed.IsZero() && now.Sub(entry.firstSeen) < g.expire():
+		if now.Sub(entry.firstSeen) < g.delay() {
+			return false
+		}
+		entry.passed = now
+	default:
+		g.triplets[key] = greylistEntry{firstSeen: now}
+		return false
+	}
+	g.triplets[key] = entry
+	return true
+}
+
+// Cleanup forgets the triplets which expired, it should be called periodically
+func (g *Greylist) Cleanup() {
+	now := g.time()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for key, entry := range g.triplets {
+		if entry.passed.IsZero() && now.Sub(entry.firstSeen) >= g.expire() ||
+			!entry.passed.IsZero() && now.Sub(entry.passed) >= g.keep() {
+			delete(g.triplets, key)
+		}
+	}
+}
+
+// greylistNetwork returns the /24 (IPv4) or /64 (IPv6) network of the IP
+func greylistNetwork(s string) string {
+	ip := CanonicalIp(ParseIp(s))
+	switch len(ip) {
+	case net.IPv4len:
+		return ip.Mask(net.CIDRMask(24, 32)).String()
+	case net.IPv6len:
+		return ip.Mask(net.CIDRMask(64, 128)).String()
+	}
+	return s
+}
@@ -0,0 +1,52 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGreylist(t *testing.T) {
+
+	Convey("Testing Greylist.Check()", t, func() {
+		now := time.Now()
+		g := Greylist{now: func() time.Time { return now }}
+
+		// the first attempt and early retries are refused
+		So(g.Check("192.0.2.1", "alice@example.org", "bob@example.com"), ShouldBeFalse)
+		now = now.Add(time.Minute)
+		So(g.Check("192.0.2.1", "alice@example.org", "bob@example.com"), ShouldBeFalse)
+
+		// a retry after the delay passes, also from another host of the network
+		now = now.Add(5 * time.Minute)
+		So(g.Check("192.0.2.2", "Alice@example.org", "bob@example.com"), ShouldBeTrue)
+		So(g.Check("192.0.2.1", "alice@example.org", "bob@example.com"), ShouldBeTrue)
+
+		// other triplets start over
+		So(g.Check("198.51.100.1", "alice@example.org", "bob@example.com"), ShouldBeFalse)
+		So(g.Check("192.0.2.1", "alice@example.org", "carol@example.com"), ShouldBeFalse)
+
+		// a triplet which isn't retried in time starts over
+		now = now.Add(5 * time.Hour)
+		So(g.Check("198.51.100.1", "alice@example.org", "bob@example.com"), ShouldBeFalse)
+		So(g.Check("192.0.2.1", "alice@example.org", "bob@example.com"), ShouldBeTrue)
+	})
+
+	Convey("Testing Greylist.Cleanup()", t, func() {
+		now := time.Now()
+		g := Greylist{Expire: 60, Keep: 3600, now: func() time.Time { return now }}
+
+		g.Check("192.0.2.1", "alice@example.org", "bob@example.com")
+		now = now.Add(30 * time.Second)
+		g.Check("192.0.2.1", "alice@example.org", "carol@example.com")
+		now = now.Add(45 * time.Second)
+		g.Cleanup()
+		So(len(g.triplets), ShouldEqual, 1)
+
+		now = now.Add(time.Hour)
+		g.Cleanup()
+		So(g.triplets, ShouldBeEmpty)
+	})
+
+}
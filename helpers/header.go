@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"bytes"
+	"strings"
+)
+
+// SplitHeader splits a message in its header fields and body.
+// The fields are returned as they are, including folding whitespace and line endings,
+// so a message can be reassembled without changing the fields which weren't touched.
+func SplitHeader(data []byte) (fields []string, body []byte) {
+	rest := data
+	for len(rest) > 0 {
+		end := bytes.IndexByte(rest, '\n') + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		line := rest[:end]
+
+		// empty line between header and body
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return fields, rest
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += string(line)
+		} else {
+			fields = append(fields, string(line))
+		}
+		rest = rest[end:]
+	}
+	return fields, rest
+}
+
+// FieldName returns the name of a header field (the part before the colon)
+func FieldName(field string) string {
+	i := strings.IndexByte(field, ':')
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(field[:i])
+}
+
+// MessageId returns the value of the Message-ID header field of the message, or "" if it has none
+func MessageId(data []byte) string {
+	fields, _ := SplitHeader(data)
+	for _, field := range fields {
+		if strings.EqualFold(FieldName(field), "Message-ID") {
+			return FieldValue(field)
+		}
+	}
+	return ""
+}
+
+// FieldValue returns the unfolded value of a header field (the part after the colon)
+func FieldValue(field string) string {
+	i := strings.IndexByte(field, ':')
+	if i < 0 {
+		return ""
+	}
+	value := strings.NewReplacer("\r\n", "", "\n", "").Replace(field[i+1:])
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,32 @@
+package helpers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHeader(t *testing.T) {
+
+	Convey("Testing SplitHeader()", t, func() {
+		fields, body := SplitHeader([]byte("Received: from a\r\n\tby b; date\r\nSubject: test\r\n\r\nHello world!\r\n"))
+		So(fields, ShouldResemble, []string{"Received: from a\r\n\tby b; date\r\n", "Subject: test\r\n"})
+		So(string(body), ShouldEqual, "\r\nHello world!\r\n")
+
+		fields, body = SplitHeader([]byte("Subject: no body"))
+		So(fields, ShouldResemble, []string{"Subject: no body"})
+		So(len(body), ShouldEqual, 0)
+	})
+
+	Convey("Testing FieldName() and FieldValue()", t, func() {
+		So(FieldName("Received: from a\r\n\tby b; date\r\n"), ShouldEqual, "Received")
+		So(FieldValue("Received: from a\r\n\tby b; date\r\n"), ShouldEqual, "from a\tby b; date")
+		So(FieldName("no colon"), ShouldEqual, "")
+	})
+
+	Convey("Testing MessageId()", t, func() {
+		So(MessageId([]byte("Subject: test\r\nMessage-Id: <1@example.com>\r\n\r\nbody")), ShouldEqual, "<1@example.com>")
+		So(MessageId([]byte("Subject: test\r\n\r\nMessage-ID: <1@example.com>")), ShouldEqual, "")
+	})
+
+}
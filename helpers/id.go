@@ -0,0 +1,69 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	mathrand "math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idEncoding is base32 with an alphabet that sorts in the same order as the bytes it encodes
+var idEncoding = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+var (
+	idMutex sync.Mutex
+	lastId  [15]byte
+
+	// randRead is crypto/rand's Read, it can be replaced for testing
+	randRead = rand.Read
+	// fallback is used when randRead fails, it's seeded with the time and the process ID
+	fallback *mathrand.Rand
+)
+
+// NewId generates a sortable, collision resistant identifier for queue IDs, filenames, logs, ...
+// It consists of a 48 bit timestamp in milliseconds followed by 72 random bits,
+// encoded in 24 base32 characters.
+// IDs generated in the same millisecond are incremented instead of randomized,
+// so IDs from one process are strictly increasing.
+// If crypto/rand fails, the random bits come from math/rand instead,
+// which is good enough for uniqueness, IDs don't have to be unpredictable.
+func NewId() string {
+	var id [15]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	binary.BigEndian.PutUint64(id[0:8], ms<<16)
+
+	idMutex.Lock()
+	defer idMutex.Unlock()
+
+	if string(id[0:6]) == string(lastId[0:6]) {
+		id = lastId
+		for i := len(id) - 1; i >= 6; i-- {
+			id[i]++
+			if id[i] != 0 {
+				break
+			}
+		}
+	} else if _, err := randRead(id[6:]); err != nil {
+		if fallback == nil {
+			fallback = mathrand.New(mathrand.NewSource(time.Now().UnixNano() ^ int64(os.Getpid())<<32))
+		}
+		fallback.Read(id[6:])
+	}
+	lastId = id
+
+	return idEncoding.EncodeToString(id[:])
+}
+
+// NewMessageId generates a value for the Message-ID header field
+// in the form <id@hostname>, as described in RFC 5322 section 3.6.4
+func NewMessageId(hostname string) string {
+	hostname = strings.Trim(hostname, "[]")
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	return "<" + NewId() + "@" + hostname + ">"
+}
@@ -0,0 +1,52 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewId(t *testing.T) {
+
+	Convey("Testing NewId()", t, func() {
+		ids := make([]string, 1000)
+		seen := make(map[string]bool)
+		for i := range ids {
+			ids[i] = NewId()
+			So(len(ids[i]), ShouldEqual, 24)
+			seen[ids[i]] = true
+		}
+
+		// all unique
+		So(len(seen), ShouldEqual, len(ids))
+
+		// and sorted
+		So(sort.StringsAreSorted(ids), ShouldEqual, true)
+	})
+
+	Convey("Testing NewId() without crypto/rand", t, func() {
+		randRead = func([]byte) (int, error) { return 0, errors.New("no entropy") }
+		defer func() { randRead = rand.Read }()
+
+		first := NewId()
+		time.Sleep(2 * time.Millisecond)
+		second := NewId()
+		So(len(second), ShouldEqual, 24)
+		So(second[10:], ShouldNotEqual, first[10:])
+		So(second > first, ShouldEqual, true)
+	})
+
+	Convey("Testing NewMessageId()", t, func() {
+		id := NewMessageId("mail.example.com")
+		So(strings.HasPrefix(id, "<"), ShouldEqual, true)
+		So(strings.HasSuffix(id, "@mail.example.com>"), ShouldEqual, true)
+
+		So(strings.HasSuffix(NewMessageId(""), "@localhost>"), ShouldEqual, true)
+	})
+
+}
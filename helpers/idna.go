@@ -0,0 +1,322 @@
+package helpers
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// acePrefix is the prefix of A-labels (RFC 5890 section 2.3.2.1)
+const acePrefix = "xn--"
+
+// errIdna is returned for labels which aren't valid IDNA2008 labels
+var errIdna = errors.New("invalid internationalized domain name")
+
+// dots are the label separators which are mapped to a full stop (RFC 3490 section 3.1)
+var dots = strings.NewReplacer("。", ".", "．", ".", "｡", ".")
+
+// DomainToUnicode returns the domain with U-labels, the form in which GoPistolet handles domains.
+// A-labels are decoded and non-ASCII labels are lower-cased, the other labels are kept as they are.
+// It fails if a label isn't valid according to IDNA2008 (RFC 5891 section 5.4), but it doesn't
+// check the Unicode normalization and the contextual rules of RFC 5892.
+func DomainToUnicode(domain string) (string, error) {
+	return mapLabels(domain, func(label string) (string, error) {
+		switch {
+		case len(label) > len(acePrefix) && strings.EqualFold(label[:len(acePrefix)], acePrefix):
+			return decodeALabel(label)
+		case !IsAscii(label):
+			label = strings.ToLower(label)
+			if err := checkULabel(label); err != nil {
+				return "", err
+			}
+			if _, err := encodeULabel(label); err != nil {
+				return "", err
+			}
+		}
+		return label, nil
+	})
+}
+
+// DomainToAscii returns the domain with A-labels (xn--...), the form for DNS lookups
+// and for servers which don't support SMTPUTF8
+func DomainToAscii(domain string) (string, error) {
+	return mapLabels(domain, func(label string) (string, error) {
+		switch {
+		case len(label) > len(acePrefix) && strings.EqualFold(label[:len(acePrefix)], acePrefix):
+			if _, err := decodeALabel(label); err != nil {
+				return "", err
+			}
+		case !IsAscii(label):
+			label = strings.ToLower(label)
+			if err := checkULabel(label); err != nil {
+				return "", err
+			}
+			return encodeULabel(label)
+		}
+		return label, nil
+	})
+}
+
+// AddressToUnicode returns the address with the U-labels of its domain (see DomainToUnicode)
+func AddressToUnicode(address string) (string, error) {
+	return mapDomain(address, DomainToUnicode)
+}
+
+// AddressToAscii returns the address with the A-labels of its domain (see DomainToAscii),
+// the local part isn't changed
+func AddressToAscii(address string) (string, error) {
+	return mapDomain(address, DomainToAscii)
+}
+
+// EqualDomains reports whether the domains are the same, comparing U-labels with A-labels (case insensitive)
+func EqualDomains(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	if IsAscii(a) && IsAscii(b) && !strings.Contains(strings.ToLower(a+b), acePrefix) {
+		return false
+	}
+	a, errA := DomainToUnicode(a)
+	b, errB := DomainToUnicode(b)
+	return errA == nil && errB == nil && strings.EqualFold(a, b)
+}
+
+func mapDomain(address string, convert func(string) (string, error)) (string, error) {
+	i := strings.LastIndexByte(address, '@')
+	if i < 0 {
+		return address, nil
+	}
+	domain, err := convert(address[i+1:])
+	if err != nil {
+		return "", err
+	}
+	return address[:i+1] + domain, nil
+}
+
+// mapLabels converts the labels of the domain, address literals aren't changed
+func mapLabels(domain string, convert func(string) (string, error)) (string, error) {
+	if strings.HasPrefix(domain, "[") {
+		return domain, nil
+	}
+	labels := strings.Split(dots.Replace(domain), ".")
+	for i, label := range labels {
+		if label == "" {
+			continue
+		}
+		converted, err := convert(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = converted
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// checkULabel checks the U-label against the rules of RFC 5891 section 5.4, which can be checked without
+// the Unicode tables: letters, marks, digits and hyphens only, no hyphens at the start, the end
+// or in the third and fourth position, no combining mark at the start, and no upper case
+func checkULabel(label string) error {
+	runes := []rune(label)
+	if len(runes) == 0 || runes[0] == '-' || runes[len(runes)-1] == '-' || unicode.IsMark(runes[0]) {
+		return errIdna
+	}
+	if len(runes) >= 4 && runes[2] == '-' && runes[3] == '-' {
+		return errIdna
+	}
+	for _, r := range runes {
+		if r == '-' || unicode.IsDigit(r) || unicode.IsMark(r) || unicode.IsLetter(r) && !unicode.IsUpper(r) {
+			continue
+		}
+		return errIdna
+	}
+	return nil
+}
+
+// decodeALabel returns the U-label of the A-label, which must be encoded the way DomainToAscii encodes it
+func decodeALabel(label string) (string, error) {
+	label = strings.ToLower(label)
+	decoded, err := punycodeDecode(label[len(acePrefix):])
+	if err != nil || IsAscii(decoded) {
+		return "", errIdna
+	}
+	if err := checkULabel(decoded); err != nil {
+		return "", err
+	}
+	if encoded, err := encodeULabel(decoded); err != nil || encoded != label {
+		return "", errIdna
+	}
+	return decoded, nil
+}
+
+// encodeULabel returns the A-label of the U-label, at most 63 octets (RFC 5890 section 2.3.2.1)
+func encodeULabel(label string) (string, error) {
+	encoded := acePrefix + punycodeEncode(label)
+	if len(encoded) > 63 {
+		return "", errIdna
+	}
+	return encoded, nil
+}
+
+// IsAscii reports whether the string only contains ASCII characters
+func IsAscii(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Punycode parameters (RFC 3492 section 5)
+const (
+	punycodeBase        = 36
+	punycodeTmin        = 1
+	punycodeTmax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+)
+
+// punycodeAdapt is the bias adaptation function (RFC 3492 section 6.1)
+func punycodeAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > ((punycodeBase-punycodeTmin)*punycodeTmax)/2 {
+		delta /= punycodeBase - punycodeTmin
+		k += punycodeBase
+	}
+	return k + (punycodeBase-punycodeTmin+1)*delta/(delta+punycodeSkew)
+}
+
+func punycodeThreshold(k, bias int) int {
+	switch {
+	case k <= bias:
+		return punycodeTmin
+	case k >= bias+punycodeTmax:
+		return punycodeTmax
+	}
+	return k - bias
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+// punycodeEncode encodes the string with Punycode (RFC 3492 section 6.3)
+func punycodeEncode(s string) string {
+	input := []rune(s)
+	output := []byte{}
+	for _, r := range input {
+		if r < 0x80 {
+			output = append(output, byte(r))
+		}
+	}
+	basic := len(output)
+	if basic > 0 {
+		output = append(output, '-')
+	}
+
+	n, delta, bias := punycodeInitialN, 0, punycodeInitialBias
+	for handled := basic; handled < len(input); {
+		m := int(unicode.MaxRune) + 1
+		for _, r := range input {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+		for _, r := range input {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := punycodeBase; ; k += punycodeBase {
+				t := punycodeThreshold(k, bias)
+				if q < t {
+					break
+				}
+				output = append(output, punycodeDigit(t+(q-t)%(punycodeBase-t)))
+				q = (q - t) / (punycodeBase - t)
+			}
+			output = append(output, punycodeDigit(q))
+			bias = punycodeAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(output)
+}
+
+// punycodeDecode decodes a Punycode string (RFC 3492 section 6.2)
+func punycodeDecode(s string) (string, error) {
+	output := []rune{}
+	rest := s
+	if b := strings.LastIndexByte(s, '-'); b >= 0 {
+		for _, c := range s[:b] {
+			if c >= 0x80 {
+				return "", errIdna
+			}
+			output = append(output, c)
+		}
+		rest = s[b+1:]
+	}
+
+	n, i, bias := punycodeInitialN, 0, punycodeInitialBias
+	for pos := 0; pos < len(rest); {
+		old, w := i, 1
+		for k := punycodeBase; ; k += punycodeBase {
+			if pos >= len(rest) {
+				return "", errIdna
+			}
+			c := rest[pos]
+			pos++
+			digit := 0
+			switch {
+			case c >= 'a' && c <= 'z':
+				digit = int(c - 'a')
+			case c >= 'A' && c <= 'Z':
+				digit = int(c - 'A')
+			case c >= '0' && c <= '9':
+				digit = int(c-'0') + 26
+			default:
+				return "", errIdna
+			}
+			i += digit * w
+			if i > unicode.MaxRune*(len(output)+1) {
+				return "", errIdna
+			}
+			t := punycodeThreshold(k, bias)
+			if digit < t {
+				break
+			}
+			w *= punycodeBase - t
+		}
+		bias = punycodeAdapt(i-old, len(output)+1, old == 0)
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+		if n > unicode.MaxRune || n >= 0xD800 && n <= 0xDFFF {
+			return "", errIdna
+		}
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+	return string(output), nil
+}
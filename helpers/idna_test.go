@@ -0,0 +1,103 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIdna(t *testing.T) {
+
+	Convey("Testing Punycode", t, func() {
+		// examples of RFC 3492 section 7.1
+		for decoded, encoded := range map[string]string{
+			"bücher":            "bcher-kva",
+			"münchen":           "mnchen-3ya",
+			"他们为什么不说中文":         "ihqwcrb4cv8a8dqg056pqjye",
+			"ليهمابتكلموشعربي؟": "egbpdaj6bu4bxfgehfvwxn",
+			"3年B組金八先生":          "3B-ww4c5e180e575a65lsy2b",
+		} {
+			So(punycodeEncode(decoded), ShouldEqual, encoded)
+			result, err := punycodeDecode(encoded)
+			So(err, ShouldEqual, nil)
+			So(result, ShouldEqual, decoded)
+		}
+
+		for _, invalid := range []string{"bcher-kv!", "bcher-k", "99999999999999999999", "ü-kva"} {
+			_, err := punycodeDecode(invalid)
+			So(err, ShouldNotEqual, nil)
+		}
+	})
+
+	Convey("Testing DomainToAscii() and DomainToUnicode()", t, func() {
+		ascii, err := DomainToAscii("Bücher.Example.")
+		So(err, ShouldEqual, nil)
+		So(ascii, ShouldEqual, "xn--bcher-kva.Example.")
+
+		ascii, err = DomainToAscii("bücher。example")
+		So(err, ShouldEqual, nil)
+		So(ascii, ShouldEqual, "xn--bcher-kva.example")
+
+		unicode, err := DomainToUnicode("XN--BCHER-KVA.example")
+		So(err, ShouldEqual, nil)
+		So(unicode, ShouldEqual, "bücher.example")
+
+		unicode, err = DomainToUnicode("BÜCHER.example")
+		So(err, ShouldEqual, nil)
+		So(unicode, ShouldEqual, "bücher.example")
+
+		// ASCII labels and address literals aren't changed
+		for _, domain := range []string{"Example.COM", "under_score.example", "[192.0.2.1]", "[IPv6:2001:db8::1]"} {
+			ascii, err = DomainToAscii(domain)
+			So(err, ShouldEqual, nil)
+			So(ascii, ShouldEqual, domain)
+			unicode, err = DomainToUnicode(domain)
+			So(err, ShouldEqual, nil)
+			So(unicode, ShouldEqual, domain)
+		}
+
+		for _, invalid := range []string{
+			"-bücher.example",
+			"bücher-.example",
+			"bü--cher.example",
+			"bü cher.example",
+			"bü_cher.example",
+			"́bücher.example",
+			"xn--bcher.example",
+			"xn--bcher-kva-.example",
+			"xn--abc-.example",
+			"xn--Bcher-kva-.example",
+			"xn--ab-cde.example",
+			"bücher" + strings.Repeat("a", 60) + ".example",
+		} {
+			_, err = DomainToAscii(invalid)
+			So(err, ShouldNotEqual, nil)
+			_, err = DomainToUnicode(invalid)
+			So(err, ShouldNotEqual, nil)
+		}
+	})
+
+	Convey("Testing addresses", t, func() {
+		address, err := AddressToAscii("Jürgen@Bücher.example")
+		So(err, ShouldEqual, nil)
+		So(address, ShouldEqual, "Jürgen@xn--bcher-kva.example")
+
+		address, err = AddressToUnicode("bob@xn--bcher-kva.example")
+		So(err, ShouldEqual, nil)
+		So(address, ShouldEqual, "bob@bücher.example")
+
+		address, err = AddressToUnicode("postmaster")
+		So(err, ShouldEqual, nil)
+		So(address, ShouldEqual, "postmaster")
+
+		_, err = AddressToAscii("bob@-bücher.example")
+		So(err, ShouldNotEqual, nil)
+
+		So(EqualDomains("bücher.example", "XN--BCHER-KVA.Example"), ShouldEqual, true)
+		So(EqualDomains("Example.com", "example.COM"), ShouldEqual, true)
+		So(EqualDomains("bücher.example", "bucher.example"), ShouldEqual, false)
+		So(EqualDomains("example.com", "example.org"), ShouldEqual, false)
+	})
+
+}
@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"net"
+	"strings"
+)
+
+// ParseIp parses an IP address and canonicalizes it:
+// zone IDs (fe80::1%eth0) and brackets are stripped and IPv4-mapped IPv6 addresses
+// (::ffff:192.168.0.10) are returned as IPv4 addresses.
+// It returns nil if the address is invalid.
+func ParseIp(s string) net.IP {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if strings.HasPrefix(strings.ToLower(s), "ipv6:") {
+		s = s[len("ipv6:"):]
+	}
+	if i := strings.Index(s, "%"); i >= 0 {
+		s = s[:i]
+	}
+	return CanonicalIp(net.ParseIP(s))
+}
+
+// CanonicalIp returns IPv4 (and IPv4-mapped) addresses in their 4 byte form
+func CanonicalIp(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// AddressLiteral formats the IP as an address literal (RFC 5321 section 4.1.3):
+// [192.168.0.10] or [IPv6:2001:db8::1]
+func AddressLiteral(ip net.IP) string {
+	ip = CanonicalIp(ip)
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return "[" + ip.String() + "]"
+	}
+	return "[IPv6:" + ip.String() + "]"
+}
+
+// ParseAddressLiteral parses an address literal in a path or an EHLO argument (RFC 5321 section 4.1.3):
+// [192.168.0.10] or [IPv6:2001:db8::1]. It returns nil if s isn't a valid IPv4 or IPv6 literal.
+func ParseAddressLiteral(s string) net.IP {
+	if len(s) < 3 || s[0] != '[' || s[len(s)-1] != ']' {
+		return nil
+	}
+	s = s[1 : len(s)-1]
+	if len(s) > 5 && strings.EqualFold(s[:5], "ipv6:") {
+		ip := net.ParseIP(s[5:])
+		if ip == nil || !strings.Contains(s[5:], ":") {
+			return nil
+		}
+		return CanonicalIp(ip)
+	}
+	ip := net.ParseIP(s)
+	if ip == nil || ip.To4() == nil || strings.Contains(s, ":") {
+		return nil
+	}
+	return ip.To4()
+}
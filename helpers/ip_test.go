@@ -0,0 +1,40 @@
+package helpers
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIp(t *testing.T) {
+
+	Convey("Testing ParseIp()", t, func() {
+		So(ParseIp("192.168.0.10").String(), ShouldEqual, "192.168.0.10")
+		So(len(ParseIp("192.168.0.10")), ShouldEqual, 4)
+		So(len(ParseIp("::ffff:192.168.0.10")), ShouldEqual, 4)
+		So(ParseIp("fe80::1%eth0").String(), ShouldEqual, "fe80::1")
+		So(ParseIp("[IPv6:2001:db8::1]").String(), ShouldEqual, "2001:db8::1")
+		So(ParseIp("[192.168.0.10]").String(), ShouldEqual, "192.168.0.10")
+		So(ParseIp("mail.example.com"), ShouldEqual, nil)
+	})
+
+	Convey("Testing AddressLiteral()", t, func() {
+		So(AddressLiteral(net.ParseIP("192.168.0.10")), ShouldEqual, "[192.168.0.10]")
+		So(AddressLiteral(net.ParseIP("::ffff:192.168.0.10")), ShouldEqual, "[192.168.0.10]")
+		So(AddressLiteral(net.ParseIP("2001:0db8:0000::1")), ShouldEqual, "[IPv6:2001:db8::1]")
+		So(AddressLiteral(nil), ShouldEqual, "")
+	})
+
+	Convey("Testing ParseAddressLiteral()", t, func() {
+		So(ParseAddressLiteral("[192.168.0.10]").String(), ShouldEqual, "192.168.0.10")
+		So(ParseAddressLiteral("[IPv6:2001:db8::1]").String(), ShouldEqual, "2001:db8::1")
+		So(ParseAddressLiteral("[ipv6:::1]").String(), ShouldEqual, "::1")
+		So(ParseAddressLiteral("192.168.0.10"), ShouldBeNil)
+		So(ParseAddressLiteral("[2001:db8::1]"), ShouldBeNil)
+		So(ParseAddressLiteral("[IPv6:192.168.0.10]"), ShouldBeNil)
+		So(ParseAddressLiteral("[192.168.0]"), ShouldBeNil)
+		So(ParseAddressLiteral("[example.com]"), ShouldBeNil)
+	})
+
+}
@@ -14,6 +14,7 @@ func DecodeFile(fileName string, object interface{}) error {
 	if err != nil {
 		return errors.New("Could not open file: " + err.Error())
 	}
+	defer file.Close()
 
 	jsonParser := json.NewDecoder(file)
 	err = jsonParser.Decode(object)
@@ -0,0 +1,153 @@
+package helpers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gopistolet/gopistolet/log"
+)
+
+// AuthLockout counts failed AUTH attempts per IP and per username.
+// After MaxFailures failures within Window seconds, the IP or username is locked out
+// for Lockout seconds. Every following lockout of the same key lasts twice as long,
+// up to MaxLockout seconds.
+// The state is kept in memory, unless the lockout shares a State with other instances (see Share).
+type AuthLockout struct {
+	MaxFailures int
+	Window      int
+	Lockout     int
+	MaxLockout  int
+
+	// now is time.Now, it can be replaced for testing
+	now func() time.Time
+
+	memory MemoryState
+	state  State
+}
+
+// lockoutEntry is the lockout of a key, it's forgotten a Window after the lockout ended
+type lockoutEntry struct {
+	Lockouts    int
+	LockedUntil time.Time
+}
+
+func (l *AuthLockout) time() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+func (l *AuthLockout) window() time.Duration {
+	if l.Window <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(l.Window) * time.Second
+}
+
+// Share keeps the failures and the lockouts in the state
+func (l *AuthLockout) Share(state State) {
+	l.state = state
+}
+
+func (l *AuthLockout) entries() State {
+	if l.state != nil {
+		return l.state
+	}
+	return &l.memory
+}
+
+func lockoutKeys(ip, username string) []string {
+	keys := []string{"lockout:ip:" + ip}
+	if username != "" {
+		keys = append(keys, "lockout:user:"+strings.ToLower(username))
+	}
+	return keys
+}
+
+// Locked reports whether the IP or the username is locked out
+func (l *AuthLockout) Locked(ip, username string) bool {
+	now := l.time()
+	for _, key := range lockoutKeys(ip, username) {
+		entry := lockoutEntry{}
+		found, err := l.entries().Get(key, now, &entry)
+		if err != nil {
+			log.Errorf("AUTH lockout: couldn't check %s: %v", key, err)
+		}
+		if found && now.Before(entry.LockedUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// Failed registers a failed authentication attempt
+func (l *AuthLockout) Failed(ip, username string) {
+	maxFailures := l.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 5
+	}
+	lockout := time.Duration(l.Lockout) * time.Second
+	if lockout <= 0 {
+		lockout = 5 * time.Minute
+	}
+	maxLockout := time.Duration(l.MaxLockout) * time.Second
+	if maxLockout < lockout {
+		maxLockout = 24 * time.Hour
+	}
+
+	now := l.time()
+	state := l.entries()
+	for _, key := range lockoutKeys(ip, username) {
+		if err := l.failed(state, key, now, maxFailures, lockout, maxLockout); err != nil {
+			log.Errorf("AUTH lockout: couldn't count failure of %s: %v", key, err)
+		}
+	}
+}
+
+// failed counts a failure of the key, and locks it out after too many
+func (l *AuthLockout) failed(state State, key string, now time.Time, maxFailures int, lockout, maxLockout time.Duration) error {
+	failures := key + ":failures"
+	if err := state.AddEvents(failures, now, 1, l.window()); err != nil {
+		return err
+	}
+	count, err := state.CountEvents(failures, now, l.window())
+	if err != nil || count < maxFailures {
+		return err
+	}
+
+	entry := lockoutEntry{}
+	if _, err := state.Get(key, now, &entry); err != nil {
+		return err
+	}
+	duration := lockout << uint(entry.Lockouts)
+	if duration > maxLockout || duration <= 0 {
+		duration = maxLockout
+	}
+	entry.LockedUntil = now.Add(duration)
+	entry.Lockouts++
+	if err := state.Set(key, now, &entry, duration+l.window()); err != nil {
+		return err
+	}
+	return state.Delete(failures)
+}
+
+// Succeeded resets the failure counter of the username. The one of the IP expires on its own,
+// otherwise a client which guesses the passwords of other users could reset it with its own account.
+func (l *AuthLockout) Succeeded(ip, username string) {
+	if username == "" {
+		return
+	}
+	for _, key := range lockoutKeys(ip, username)[1:] {
+		for _, k := range []string{key, key + ":failures"} {
+			if err := l.entries().Delete(k); err != nil {
+				log.Errorf("AUTH lockout: couldn't reset %s: %v", k, err)
+			}
+		}
+	}
+}
+
+// Cleanup removes entries which are no longer needed, it should be called periodically
+func (l *AuthLockout) Cleanup() {
+	l.memory.Cleanup(l.time())
+}
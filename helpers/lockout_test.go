@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuthLockout(t *testing.T) {
+
+	Convey("Testing AuthLockout", t, func() {
+		now := time.Date(2016, 10, 5, 14, 0, 0, 0, time.UTC)
+		l := AuthLockout{
+			MaxFailures: 3,
+			Window:      60,
+			Lockout:     300,
+			MaxLockout:  900,
+			now:         func() time.Time { return now },
+		}
+
+		l.Failed("192.168.0.10", "bob")
+		l.Failed("192.168.0.10", "bob")
+		So(l.Locked("192.168.0.10", "bob"), ShouldEqual, false)
+
+		l.Failed("192.168.0.10", "bob")
+		So(l.Locked("192.168.0.10", "bob"), ShouldEqual, true)
+		// both the IP and the user are locked out
+		So(l.Locked("192.168.0.11", "BOB"), ShouldEqual, true)
+		So(l.Locked("192.168.0.10", "alice"), ShouldEqual, true)
+		So(l.Locked("192.168.0.11", "alice"), ShouldEqual, false)
+
+		now = now.Add(301 * time.Second)
+		So(l.Locked("192.168.0.10", "bob"), ShouldEqual, false)
+
+		// second lockout lasts twice as long
+		l.Failed("192.168.0.10", "bob")
+		l.Failed("192.168.0.10", "bob")
+		l.Failed("192.168.0.10", "bob")
+		now = now.Add(301 * time.Second)
+		So(l.Locked("192.168.0.10", "bob"), ShouldEqual, true)
+		now = now.Add(300 * time.Second)
+		So(l.Locked("192.168.0.10", "bob"), ShouldEqual, false)
+
+		// failures outside the window aren't counted
+		l.Failed("192.168.0.12", "")
+		l.Failed("192.168.0.12", "")
+		now = now.Add(61 * time.Second)
+		l.Failed("192.168.0.12", "")
+		So(l.Locked("192.168.0.12", ""), ShouldEqual, false)
+
+		// success resets the counter of the user, not the one of the IP
+		l.Failed("192.168.0.13", "carol")
+		l.Failed("192.168.0.14", "carol")
+		l.Succeeded("192.168.0.12", "carol")
+		l.Failed("192.168.0.15", "carol")
+		So(l.Locked("192.168.0.15", "carol"), ShouldEqual, false)
+		l.Failed("192.168.0.12", "dave")
+		l.Failed("192.168.0.12", "erin")
+		So(l.Locked("192.168.0.12", ""), ShouldEqual, true)
+	})
+
+}
@@ -0,0 +1,69 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Networks is a list of IP ranges, in the config file it's a list of CIDRs or IPs:
+//
+//	["192.168.0.0/16", "10.0.0.1", "2001:db8::/32"]
+type Networks []*net.IPNet
+
+// ParseNetworks parses a list of CIDRs (or single IPs) into Networks
+func ParseNetworks(list []string) (Networks, error) {
+	networks := make(Networks, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP or CIDR: '%s'", s)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP or CIDR: '%s'", s)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Contains reports whether the IP is in one of the networks
+func (n Networks) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Networks) UnmarshalJSON(data []byte) error {
+	list := []string{}
+	err := json.Unmarshal(data, &list)
+	if err != nil {
+		return err
+	}
+	*n, err = ParseNetworks(list)
+	return err
+}
+
+func (n Networks) MarshalJSON() ([]byte, error) {
+	list := make([]string, len(n))
+	for i, network := range n {
+		list[i] = network.String()
+	}
+	return json.Marshal(list)
+}
@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNetworks(t *testing.T) {
+
+	Convey("Testing ParseNetworks() and Contains()", t, func() {
+		networks, err := ParseNetworks([]string{"192.168.0.0/16", "10.0.0.1", "2001:db8::/32"})
+		So(err, ShouldEqual, nil)
+
+		So(networks.Contains(net.ParseIP("192.168.5.10")), ShouldEqual, true)
+		So(networks.Contains(net.ParseIP("10.0.0.1")), ShouldEqual, true)
+		So(networks.Contains(net.ParseIP("10.0.0.2")), ShouldEqual, false)
+		So(networks.Contains(net.ParseIP("2001:db8::25")), ShouldEqual, true)
+		So(networks.Contains(net.ParseIP("2001:db9::25")), ShouldEqual, false)
+		So(networks.Contains(nil), ShouldEqual, false)
+
+		_, err = ParseNetworks([]string{"192.168.0.0/33"})
+		So(err, ShouldNotEqual, nil)
+		_, err = ParseNetworks([]string{"not an ip"})
+		So(err, ShouldNotEqual, nil)
+	})
+
+	Convey("Testing Networks JSON", t, func() {
+		var networks Networks
+		err := json.Unmarshal([]byte(`["127.0.0.0/8", "::1"]`), &networks)
+		So(err, ShouldEqual, nil)
+		So(len(networks), ShouldEqual, 2)
+
+		data, err := json.Marshal(networks)
+		So(err, ShouldEqual, nil)
+		So(string(data), ShouldEqual, `["127.0.0.0/8","::1/128"]`)
+	})
+
+}
@@ -0,0 +1,159 @@
+package helpers
+
+import (
+	"strings"
+)
+
+// errPathSyntax is returned for paths which don't follow the grammar of RFC 5321
+var errPathSyntax = &EsmtpError{501, "Syntax error in path"}
+
+// ParsePath parses a reverse-path or forward-path without its angle brackets (RFC 5321 section 4.1.2):
+//
+//	[ A-d-l ":" ] Local-part "@" ( Domain / address-literal )
+//
+// The source route (A-d-l) is accepted and ignored, an address literal is only accepted
+// if it's a valid IPv4 or IPv6 address (see ParseAddressLiteral). The local part is returned as it was written,
+// so a quoted local part keeps its quotes. The null path ("") and "postmaster" without domain
+// (RFC 5321 section 4.5.1) return an empty domain, the callers decide where they're allowed.
+// UTF-8 is accepted in the local part and the domain (RFC 6531).
+func ParsePath(path string) (string, string, error) {
+	if path == "" {
+		return "", "", nil
+	}
+	if strings.EqualFold(path, "postmaster") {
+		return path, "", nil
+	}
+
+	if strings.HasPrefix(path, "@") {
+		end := strings.IndexByte(path, ':')
+		if end < 0 {
+			return "", "", errPathSyntax
+		}
+		for _, atDomain := range strings.Split(path[:end], ",") {
+			if !strings.HasPrefix(atDomain, "@") || !isDomain(atDomain[1:]) {
+				return "", "", errPathSyntax
+			}
+		}
+		path = path[end+1:]
+	}
+
+	var local string
+	if strings.HasPrefix(path, `"`) {
+		end := quotedStringEnd(path)
+		if end < 0 {
+			return "", "", errPathSyntax
+		}
+		local, path = path[:end], path[end:]
+	} else {
+		at := strings.LastIndexByte(path, '@')
+		if at < 0 {
+			return "", "", errPathSyntax
+		}
+		local, path = path[:at], path[at:]
+		if !isDotString(local) {
+			return "", "", errPathSyntax
+		}
+	}
+	if len(local) > 64 {
+		return "", "", &EsmtpError{501, "Local part too long"}
+	}
+
+	if !strings.HasPrefix(path, "@") {
+		return "", "", errPathSyntax
+	}
+	domain := path[1:]
+	if !isDomain(domain) && ParseAddressLiteral(domain) == nil {
+		return "", "", errPathSyntax
+	}
+	return local, domain, nil
+}
+
+// pathEnd returns the index of the '>' which closes the path at the start of args,
+// skipping quoted strings in the local part, or -1 if there's none
+func pathEnd(args string) int {
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case '"':
+			end := quotedStringEnd(args[i:])
+			if end < 0 {
+				return -1
+			}
+			i += end - 1
+		case '>':
+			return i
+		}
+	}
+	return -1
+}
+
+// quotedStringEnd returns the length of the Quoted-string at the start of s, or -1 if it isn't valid:
+// DQUOTE *( qtextSMTP / quoted-pairSMTP ) DQUOTE
+func quotedStringEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return i + 1
+		case c == '\\':
+			if i+1 >= len(s) || s[i+1] < ' ' || s[i+1] > '~' {
+				return -1
+			}
+			i++
+		case c < ' ' || c == 127:
+			return -1
+		}
+	}
+	return -1
+}
+
+// isDotString checks Dot-string = Atom *("." Atom)
+func isDotString(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, atom := range strings.Split(s, ".") {
+		if atom == "" {
+			return false
+		}
+		for i := 0; i < len(atom); i++ {
+			if !isAtext(atom[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isAtext checks atext (RFC 5322 section 3.2.3), and the UTF-8 bytes of RFC 6531
+func isAtext(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) >= 0 || c >= 0x80
+}
+
+// isDomain checks Domain = sub-domain *("." sub-domain), where sub-domain = Let-dig [Ldh-str].
+// An all-numeric top-level label isn't a domain (RFC 3696 section 2), so a bare IP isn't either.
+func isDomain(s string) bool {
+	if s == "" || len(s) > 255 {
+		return false
+	}
+	labels := strings.Split(s, ".")
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c >= 0x80) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsHeloArgument checks the argument of EHLO or HELO: Domain / address-literal
+func IsHeloArgument(s string) bool {
+	return isDomain(strings.TrimSuffix(s, ".")) || ParseAddressLiteral(s) != nil
+}
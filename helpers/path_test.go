@@ -0,0 +1,82 @@
+package helpers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPath(t *testing.T) {
+
+	Convey("Testing ParsePath()", t, func() {
+		tests := []struct {
+			path   string
+			local  string
+			domain string
+		}{
+			{"", "", ""},
+			{"Postmaster", "Postmaster", ""},
+			{"user@example.com", "user", "example.com"},
+			{"first.last+tag@mail.example.com", "first.last+tag", "mail.example.com"},
+			{`"john doe"@example.com`, `"john doe"`, "example.com"},
+			{`"a>b@c"@example.com`, `"a>b@c"`, "example.com"},
+			{`"quote\"d"@example.com`, `"quote\"d"`, "example.com"},
+			{"@relay.example,@other.example:user@example.com", "user", "example.com"},
+			{"user@[192.0.2.1]", "user", "[192.0.2.1]"},
+			{"user@[IPv6:2001:db8::1]", "user", "[IPv6:2001:db8::1]"},
+			{"jöran@bücher.example", "jöran", "bücher.example"},
+		}
+		for _, test := range tests {
+			local, domain, err := ParsePath(test.path)
+			So(err, ShouldEqual, nil)
+			So(local, ShouldEqual, test.local)
+			So(domain, ShouldEqual, test.domain)
+		}
+
+		for _, path := range []string{
+			"user",
+			"user@",
+			"@example.com",
+			"user..name@example.com",
+			".user@example.com",
+			"john doe@example.com",
+			`"unterminated@example.com`,
+			"user@-example.com",
+			"user@example..com",
+			"user@exa_mple.com",
+			"user@192.0.2.1",
+			"user@[192.0.2.1",
+			"user@[192.0.2.256]",
+			"user@[2001:db8::1]",
+			"@relay.example:",
+			"@relay.example,other.example:user@example.com",
+			"01234567890123456789012345678901234567890123456789012345678901234@example.com",
+		} {
+			_, _, err := ParsePath(path)
+			So(err, ShouldNotEqual, nil)
+		}
+	})
+
+	Convey("Testing IsHeloArgument()", t, func() {
+		So(IsHeloArgument("mail.example.com"), ShouldEqual, true)
+		So(IsHeloArgument("mail.example.com."), ShouldEqual, true)
+		So(IsHeloArgument("[192.0.2.1]"), ShouldEqual, true)
+		So(IsHeloArgument("[IPv6:2001:db8::1]"), ShouldEqual, true)
+		So(IsHeloArgument("192.0.2.1]"), ShouldEqual, false)
+		So(IsHeloArgument("mail example"), ShouldEqual, false)
+		So(IsHeloArgument(""), ShouldEqual, false)
+	})
+
+	Convey("Testing ParseEsmtpArgs() with quoted local parts", t, func() {
+		path, params, err := ParseEsmtpArgs(`<"a> b"@example.com> SIZE=10`)
+		So(err, ShouldEqual, nil)
+		So(path, ShouldEqual, `"a> b"@example.com`)
+		So(params, ShouldResemble, map[string]string{"SIZE": "10"})
+
+		_, _, err = ParseEsmtpArgs("<user@example.com>SIZE=10")
+		So(err, ShouldNotEqual, nil)
+		_, _, err = ParseEsmtpArgs("<user name@example.com>")
+		So(err, ShouldNotEqual, nil)
+	})
+
+}
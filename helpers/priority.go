@@ -0,0 +1,71 @@
+package helpers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Priority classes of the queued messages
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityBulk   = "bulk"
+)
+
+// PriorityClasses are the classes in the order in which they're relayed
+var PriorityClasses = []string{PriorityHigh, PriorityNormal, PriorityBulk}
+
+// Priorities assigns the queued messages to priority classes: high (e.g. transactional) mail is relayed first,
+// bulk mail last and with its own limit. Messages with a positive MT-Priority field (RFC 6758, -9 to 9) are high,
+// those with a negative one or with Precedence bulk, list or junk are bulk.
+// The class of a sender in Senders overrides the header.
+type Priorities struct {
+	// Senders assigns sender addresses and domains to a class (high, normal or bulk)
+	Senders map[string]string
+	// BulkPerFlush limits the bulk messages which are relayed per flush of the queue (0 is unlimited),
+	// the others wait for the next flush
+	BulkPerFlush int
+}
+
+// Check checks the classes of the senders
+func (p *Priorities) Check() error {
+	for sender, class := range p.Senders {
+		if class != PriorityHigh && class != PriorityNormal && class != PriorityBulk {
+			return fmt.Errorf("sender %s: class should be high, normal or bulk, not %q", sender, class)
+		}
+	}
+	return nil
+}
+
+// Class returns the class of the message from the sender
+func (p *Priorities) Class(sender string, data []byte) string {
+	domain := sender[strings.LastIndexByte(sender, '@')+1:]
+	for _, key := range []string{sender, domain} {
+		for assigned, class := range p.Senders {
+			if key != "" && strings.EqualFold(assigned, key) {
+				return class
+			}
+		}
+	}
+
+	fields, _ := SplitHeader(data)
+	for _, field := range fields {
+		value := strings.ToLower(FieldValue(field))
+		switch strings.ToLower(FieldName(field)) {
+		case "mt-priority":
+			priority, err := strconv.Atoi(value)
+			if err == nil && priority > 0 {
+				return PriorityHigh
+			}
+			if err == nil && priority < 0 {
+				return PriorityBulk
+			}
+		case "precedence":
+			if value == "bulk" || value == "list" || value == "junk" {
+				return PriorityBulk
+			}
+		}
+	}
+	return PriorityNormal
+}
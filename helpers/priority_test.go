@@ -0,0 +1,32 @@
+package helpers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPriorities(t *testing.T) {
+
+	Convey("Testing Priorities.Class()", t, func() {
+		p := &Priorities{}
+		So(p.Class("bob@example.com", []byte("Subject: hi\r\n\r\nbody\r\n")), ShouldEqual, PriorityNormal)
+		So(p.Class("bob@example.com", []byte("MT-Priority: 4\r\n\r\n")), ShouldEqual, PriorityHigh)
+		So(p.Class("bob@example.com", []byte("MT-Priority: -2\r\n\r\n")), ShouldEqual, PriorityBulk)
+		So(p.Class("bob@example.com", []byte("MT-Priority: 0\r\n\r\n")), ShouldEqual, PriorityNormal)
+		So(p.Class("bob@example.com", []byte("Precedence: List\r\n\r\n")), ShouldEqual, PriorityBulk)
+		So(p.Class("", []byte("Subject: bounce\r\n\r\n")), ShouldEqual, PriorityNormal)
+
+		// the senders override the header
+		p.Senders = map[string]string{"news.example.com": PriorityBulk, "alerts@news.example.com": PriorityHigh}
+		So(p.Class("weekly@news.example.com", []byte("MT-Priority: 9\r\n\r\n")), ShouldEqual, PriorityBulk)
+		So(p.Class("Alerts@news.example.com", nil), ShouldEqual, PriorityHigh)
+	})
+
+	Convey("Testing Priorities.Check()", t, func() {
+		So((&Priorities{Senders: map[string]string{"example.com": "bulk"}}).Check(), ShouldEqual, nil)
+		err := (&Priorities{Senders: map[string]string{"example.com": "urgent"}}).Check()
+		So(err.Error(), ShouldContainSubstring, `"urgent"`)
+	})
+
+}
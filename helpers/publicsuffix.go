@@ -0,0 +1,178 @@
+package helpers
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/log"
+	"golang.org/x/net/publicsuffix"
+)
+
+// PublicSuffixList finds the organizational (registrable) domain of a domain name,
+// e.g. example.co.uk for mail.example.co.uk.
+// Without a list File, the snapshot bundled with golang.org/x/net/publicsuffix is used.
+// The File (in the format of https://publicsuffix.org/list/public_suffix_list.dat)
+// is reloaded every Interval seconds, so the list can be refreshed without a restart.
+type PublicSuffixList struct {
+	File     string
+	Interval int
+
+	mutex      sync.RWMutex
+	rules      map[string]bool
+	wildcards  map[string]bool
+	exceptions map[string]bool
+	modTime    time.Time
+	stop       chan struct{}
+}
+
+// Load (re)loads the list from File if it has changed
+func (p *PublicSuffixList) Load() error {
+	if p.File == "" {
+		return nil
+	}
+
+	info, err := os.Stat(p.File)
+	if err != nil {
+		return err
+	}
+	p.mutex.RLock()
+	unchanged := p.rules != nil && info.ModTime().Equal(p.modTime)
+	p.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	file, err := os.Open(p.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rules := make(map[string]bool)
+	wildcards := make(map[string]bool)
+	exceptions := make(map[string]bool)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		// rules end at the first whitespace
+		line = strings.ToLower(strings.Fields(line)[0])
+
+		switch {
+		case strings.HasPrefix(line, "!"):
+			exceptions[line[1:]] = true
+		case strings.HasPrefix(line, "*."):
+			wildcards[line[2:]] = true
+		default:
+			rules[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	p.rules, p.wildcards, p.exceptions = rules, wildcards, exceptions
+	p.modTime = info.ModTime()
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// PublicSuffix returns the public suffix of the domain (e.g. co.uk)
+func (p *PublicSuffixList) PublicSuffix(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.rules == nil {
+		suffix, _ := publicsuffix.PublicSuffix(domain)
+		return suffix
+	}
+
+	// Find the longest matching rule, from the full domain down to the TLD
+	labels := strings.Split(domain, ".")
+	for i := range labels {
+		candidate := strings.Join(labels[i:], ".")
+		if p.exceptions[candidate] {
+			// an exception rule means the parent domain is the suffix
+			return strings.Join(labels[i+1:], ".")
+		}
+		if p.rules[candidate] {
+			return candidate
+		}
+		if i+1 < len(labels) && p.wildcards[strings.Join(labels[i+1:], ".")] {
+			return candidate
+		}
+	}
+
+	// the default rule is "*"
+	return labels[len(labels)-1]
+}
+
+// OrganizationalDomain returns the registrable domain (public suffix + one label),
+// or the domain itself if it's a public suffix
+func (p *PublicSuffixList) OrganizationalDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	suffix := p.PublicSuffix(domain)
+	if len(domain) <= len(suffix) {
+		return domain
+	}
+	rest := strings.TrimSuffix(domain[:len(domain)-len(suffix)], ".")
+	if i := strings.LastIndex(rest, "."); i >= 0 {
+		rest = rest[i+1:]
+	}
+	return rest + "." + suffix
+}
+
+// Start loads the list and reloads it every Interval seconds until Stop is called
+func (p *PublicSuffixList) Start() {
+	if p.File == "" {
+		return
+	}
+	if err := p.Load(); err != nil {
+		log.Warnln("Couldn't load public suffix list: ", err, "- Using bundled list instead.")
+	}
+
+	interval := time.Duration(p.Interval) * time.Second
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+
+	p.mutex.Lock()
+	p.stop = make(chan struct{})
+	stop := p.stop
+	p.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := p.Load(); err != nil {
+					log.Warnln("Couldn't reload public suffix list: ", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops reloading the list
+func (p *PublicSuffixList) Stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
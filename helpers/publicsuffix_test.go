@@ -0,0 +1,42 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPublicSuffixList(t *testing.T) {
+
+	Convey("Testing bundled public suffix list", t, func() {
+		psl := PublicSuffixList{}
+		So(psl.PublicSuffix("mail.example.co.uk"), ShouldEqual, "co.uk")
+		So(psl.OrganizationalDomain("mail.example.co.uk"), ShouldEqual, "example.co.uk")
+		So(psl.OrganizationalDomain("Mail.Example.COM."), ShouldEqual, "example.com")
+		So(psl.OrganizationalDomain("example.com"), ShouldEqual, "example.com")
+		So(psl.OrganizationalDomain("co.uk"), ShouldEqual, "co.uk")
+	})
+
+	Convey("Testing public suffix list file", t, func() {
+		file, err := ioutil.TempFile("", "psl")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(file.Name())
+
+		file.WriteString("// comment\n\ncom\nuk\nco.uk\n*.ck\n!www.ck\n")
+		file.Close()
+
+		psl := PublicSuffixList{File: file.Name()}
+		So(psl.Load(), ShouldEqual, nil)
+
+		So(psl.OrganizationalDomain("mail.example.co.uk"), ShouldEqual, "example.co.uk")
+		So(psl.OrganizationalDomain("a.b.example.com"), ShouldEqual, "example.com")
+		So(psl.PublicSuffix("foo.bar.ck"), ShouldEqual, "bar.ck")
+		So(psl.OrganizationalDomain("mail.foo.bar.ck"), ShouldEqual, "foo.bar.ck")
+		So(psl.OrganizationalDomain("mail.www.ck"), ShouldEqual, "www.ck")
+		// default rule
+		So(psl.OrganizationalDomain("mail.example.unknown"), ShouldEqual, "example.unknown")
+	})
+
+}
@@ -0,0 +1,119 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MailboxUsage keeps track of the storage used by the mailboxes (maildirs), so quotas can be enforced.
+// The usage of a mailbox is measured by walking its maildir, and measured again after Rescan seconds
+// (default 300) to account for messages which were removed by the user. Deliveries in between are added.
+type MailboxUsage struct {
+	Rescan int
+
+	mutex     sync.Mutex
+	mailboxes map[string]*mailboxUsage
+}
+
+type mailboxUsage struct {
+	bytes    int64
+	quota    int64
+	measured time.Time
+}
+
+// MailboxUsageEntry is the usage and quota of one mailbox
+type MailboxUsageEntry struct {
+	Mailbox string
+	Usage   int64
+	// Quota in bytes, 0 means unlimited
+	Quota int64
+}
+
+// Fits reports whether a message of size bytes fits in the quota of the maildir at dir,
+// a quota of 0 means unlimited
+func (u *MailboxUsage) Fits(dir string, quota int64, size int64) (bool, error) {
+	usage, err := u.usage(dir)
+	if err != nil {
+		return false, err
+	}
+	u.mutex.Lock()
+	usage.quota = quota
+	used := usage.bytes
+	u.mutex.Unlock()
+	return quota <= 0 || used+size <= quota, nil
+}
+
+// Add adds the size of a message which was delivered to the maildir at dir
+func (u *MailboxUsage) Add(dir string, size int64) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if usage, found := u.mailboxes[dir]; found {
+		usage.bytes += size
+	}
+}
+
+// All returns the usage of all mailboxes which received mail, sorted by mailbox
+func (u *MailboxUsage) All() []MailboxUsageEntry {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	entries := []MailboxUsageEntry{}
+	for dir, usage := range u.mailboxes {
+		entries = append(entries, MailboxUsageEntry{Mailbox: dir, Usage: usage.bytes, Quota: usage.quota})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Mailbox < entries[j].Mailbox })
+	return entries
+}
+
+// usage returns the usage of the maildir at dir, it's measured if it's unknown or outdated
+func (u *MailboxUsage) usage(dir string) (*mailboxUsage, error) {
+	rescan := time.Duration(u.Rescan) * time.Second
+	if rescan <= 0 {
+		rescan = 5 * time.Minute
+	}
+
+	u.mutex.Lock()
+	if u.mailboxes == nil {
+		u.mailboxes = make(map[string]*mailboxUsage)
+	}
+	usage, found := u.mailboxes[dir]
+	u.mutex.Unlock()
+	if found && time.Since(usage.measured) < rescan {
+		return usage, nil
+	}
+
+	bytes, err := DirSize(dir)
+	if err != nil {
+		return nil, err
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if usage == nil {
+		usage = &mailboxUsage{}
+		u.mailboxes[dir] = usage
+	}
+	usage.bytes, usage.measured = bytes, time.Now()
+	return usage, nil
+}
+
+// DirSize returns the total size of the files in the directory and its subdirectories,
+// a directory which doesn't exist is empty
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// files may be moved (new to cur) or removed while walking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
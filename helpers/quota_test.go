@@ -0,0 +1,46 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMailboxUsage(t *testing.T) {
+
+	Convey("Testing MailboxUsage", t, func() {
+		dir, err := ioutil.TempDir("", "maildir")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		So(os.MkdirAll(filepath.Join(dir, "cur"), 0700), ShouldEqual, nil)
+		So(os.MkdirAll(filepath.Join(dir, ".Junk", "new"), 0700), ShouldEqual, nil)
+		So(ioutil.WriteFile(filepath.Join(dir, "cur", "1"), make([]byte, 600), 0600), ShouldEqual, nil)
+		So(ioutil.WriteFile(filepath.Join(dir, ".Junk", "new", "2"), make([]byte, 300), 0600), ShouldEqual, nil)
+
+		size, err := DirSize(dir)
+		So(err, ShouldEqual, nil)
+		So(size, ShouldEqual, 900)
+		size, err = DirSize(filepath.Join(dir, "missing"))
+		So(err, ShouldEqual, nil)
+		So(size, ShouldEqual, 0)
+
+		u := MailboxUsage{}
+		fits, err := u.Fits(dir, 1000, 100)
+		So(err, ShouldEqual, nil)
+		So(fits, ShouldEqual, true)
+		u.Add(dir, 100)
+
+		// the usage isn't measured again, deliveries are added
+		fits, _ = u.Fits(dir, 1000, 1)
+		So(fits, ShouldEqual, false)
+		fits, _ = u.Fits(dir, 0, 1000000)
+		So(fits, ShouldEqual, true)
+
+		So(u.All(), ShouldResemble, []MailboxUsageEntry{{Mailbox: dir, Usage: 1000, Quota: 0}})
+	})
+
+}
@@ -0,0 +1,113 @@
+package helpers
+
+import (
+	"time"
+
+	"github.com/gopistolet/gopistolet/log"
+)
+
+// RateLimiter counts events per key (IP, user, domain, ...) within a sliding window of Window seconds.
+// A key exceeds the limit when more than Limit events were counted in the window.
+// A Limit of 0 disables the limiter.
+// The events are kept in memory, unless the limiter shares a State with other instances (see Share).
+type RateLimiter struct {
+	Limit  int
+	Window int
+
+	// now is time.Now, it can be replaced for testing
+	now func() time.Time
+
+	memory MemoryState
+	// state and name (the prefix of its keys) are set by Share
+	state State
+	name  string
+}
+
+func (r *RateLimiter) time() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+func (r *RateLimiter) window() time.Duration {
+	if r.Window <= 0 {
+		return time.Hour
+	}
+	return time.Duration(r.Window) * time.Second
+}
+
+// Share keeps the events in the state, under keys which start with the name
+func (r *RateLimiter) Share(state State, name string) {
+	r.state, r.name = state, name
+}
+
+func (r *RateLimiter) events() State {
+	if r.state != nil {
+		return r.state
+	}
+	return &r.memory
+}
+
+// Add counts n events for the key
+func (r *RateLimiter) Add(key string, n int) {
+	if r.Limit <= 0 || n <= 0 {
+		return
+	}
+	if err := r.events().AddEvents(r.name+key, r.time(), n, r.window()); err != nil {
+		log.Errorf("Rate limit: couldn't count events of %s: %v", key, err)
+	}
+}
+
+// Count returns the number of events for the key in the current window,
+// the events aren't counted if the state can't be reached
+func (r *RateLimiter) Count(key string) int {
+	count, err := r.events().CountEvents(r.name+key, r.time(), r.window())
+	if err != nil {
+		log.Errorf("Rate limit: couldn't count events of %s: %v", key, err)
+	}
+	return count
+}
+
+// Exceeded reports whether the key exceeded the limit
+func (r *RateLimiter) Exceeded(key string) bool {
+	if r.Limit <= 0 {
+		return false
+	}
+	return r.Count(key) > r.Limit
+}
+
+// Cleanup removes the keys without events in the window, it should be called periodically
+func (r *RateLimiter) Cleanup() {
+	r.memory.Cleanup(r.time())
+}
+
+// RateLimits limits the number of messages and recipients per client IP
+type RateLimits struct {
+	Messages   RateLimiter
+	Recipients RateLimiter
+}
+
+// Count registers a message from the IP with the given number of recipients
+func (r *RateLimits) Count(ip string, recipients int) {
+	r.Messages.Add(ip, 1)
+	r.Recipients.Add(ip, recipients)
+}
+
+// CheckIp implements Blacklist: clients which exceeded one of the limits are refused
+// until enough events slid out of the window
+func (r *RateLimits) CheckIp(ip string) bool {
+	return r.Messages.Exceeded(ip) || r.Recipients.Exceeded(ip)
+}
+
+// Share keeps the counters in the state
+func (r *RateLimits) Share(state State) {
+	r.Messages.Share(state, "ratelimit:messages:")
+	r.Recipients.Share(state, "ratelimit:recipients:")
+}
+
+// Cleanup removes the IPs without messages in the windows
+func (r *RateLimits) Cleanup() {
+	r.Messages.Cleanup()
+	r.Recipients.Cleanup()
+}
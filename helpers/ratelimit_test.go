@@ -0,0 +1,61 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimiter(t *testing.T) {
+
+	Convey("Testing RateLimiter", t, func() {
+		now := time.Date(2016, 10, 5, 14, 0, 0, 0, time.UTC)
+		r := RateLimiter{
+			Limit:  3,
+			Window: 60,
+			now:    func() time.Time { return now },
+		}
+
+		r.Add("192.168.0.10", 2)
+		now = now.Add(30 * time.Second)
+		r.Add("192.168.0.10", 1)
+		So(r.Count("192.168.0.10"), ShouldEqual, 3)
+		So(r.Exceeded("192.168.0.10"), ShouldEqual, false)
+
+		r.Add("192.168.0.10", 1)
+		So(r.Exceeded("192.168.0.10"), ShouldEqual, true)
+		So(r.Exceeded("192.168.0.11"), ShouldEqual, false)
+
+		// the first events slide out of the window
+		now = now.Add(31 * time.Second)
+		So(r.Count("192.168.0.10"), ShouldEqual, 2)
+		So(r.Exceeded("192.168.0.10"), ShouldEqual, false)
+
+		now = now.Add(time.Hour)
+		r.Cleanup()
+		So(r.memory.Len(), ShouldEqual, 0)
+	})
+
+	Convey("Testing RateLimits", t, func() {
+		r := RateLimits{
+			Messages:   RateLimiter{Limit: 2, Window: 60},
+			Recipients: RateLimiter{Limit: 10, Window: 60},
+		}
+
+		r.Count("192.168.0.10", 1)
+		r.Count("192.168.0.10", 1)
+		So(r.CheckIp("192.168.0.10"), ShouldEqual, false)
+		r.Count("192.168.0.10", 1)
+		So(r.CheckIp("192.168.0.10"), ShouldEqual, true)
+
+		r.Count("192.168.0.11", 11)
+		So(r.CheckIp("192.168.0.11"), ShouldEqual, true)
+
+		// disabled limits are never exceeded
+		r = RateLimits{}
+		r.Count("192.168.0.10", 100)
+		So(r.CheckIp("192.168.0.10"), ShouldEqual, false)
+	})
+
+}
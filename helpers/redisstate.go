@@ -0,0 +1,137 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisState is a State in a Redis server, so the frontends of a cluster enforce the same limits.
+// Events are kept in a sorted set per key, scored by their time in milliseconds,
+// and values as JSON strings. Every key expires by itself, so there's nothing to clean up.
+type RedisState struct {
+	// Prefix of the keys, so several clusters can share a server
+	Prefix string
+
+	pool *redis.Pool
+}
+
+// NewRedisState returns the state in the database of the Redis server at address (host:port)
+func NewRedisState(address, password string, database int, prefix string) *RedisState {
+	return &RedisState{
+		Prefix: prefix,
+		pool: &redis.Pool{
+			MaxIdle:     8,
+			IdleTimeout: 5 * time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", address,
+					redis.DialPassword(password),
+					redis.DialDatabase(database),
+					redis.DialConnectTimeout(time.Second),
+					redis.DialReadTimeout(time.Second),
+					redis.DialWriteTimeout(time.Second))
+			},
+		},
+	}
+}
+
+// Ping checks that the server can be reached
+func (s *RedisState) Ping() error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return err
+}
+
+// Close closes the connections to the server
+func (s *RedisState) Close() error {
+	return s.pool.Close()
+}
+
+func milliseconds(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// ttl returns the duration in milliseconds, Redis refuses 0
+func ttl(d time.Duration) int64 {
+	if ms := int64(d / time.Millisecond); ms > 0 {
+		return ms
+	}
+	return 1
+}
+
+// AddEvents implements State, the member of an event is <time>:<n>:<unique id>
+func (s *RedisState) AddEvents(key string, now time.Time, n int, window time.Duration) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	key = s.Prefix + key
+	conn.Send("MULTI")
+	conn.Send("ZADD", key, milliseconds(now), fmt.Sprintf("%d:%d:%s", milliseconds(now), n, NewId()))
+	conn.Send("ZREMRANGEBYSCORE", key, "-inf", milliseconds(now.Add(-window)))
+	conn.Send("PEXPIRE", key, ttl(window))
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// CountEvents implements State
+func (s *RedisState) CountEvents(key string, now time.Time, window time.Duration) (int, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	events, err := redis.Strings(conn.Do("ZRANGEBYSCORE", s.Prefix+key, fmt.Sprintf("(%d", milliseconds(now.Add(-window))), "+inf"))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, event := range events {
+		parts := strings.SplitN(event, ":", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		n, _ := strconv.Atoi(parts[1])
+		count += n
+	}
+	return count, nil
+}
+
+// Get implements State
+func (s *RedisState) Get(key string, now time.Time, value interface{}) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", s.Prefix+key))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, value)
+}
+
+// Set implements State
+func (s *RedisState) Set(key string, now time.Time, value interface{}, expiry time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("SET", s.Prefix+key, data, "PX", ttl(expiry))
+	return err
+}
+
+// Delete implements State
+func (s *RedisState) Delete(key string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", s.Prefix+key)
+	return err
+}
@@ -0,0 +1,81 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRedisState(t *testing.T) {
+
+	Convey("Testing RedisState", t, func() {
+		server, err := miniredis.Run()
+		So(err, ShouldEqual, nil)
+		defer server.Close()
+
+		s := NewRedisState(server.Addr(), "", 0, "gopistolet:")
+		defer s.Close()
+		So(s.Ping(), ShouldEqual, nil)
+
+		now := time.Date(2016, 10, 5, 14, 0, 0, 0, time.UTC)
+		So(s.AddEvents("ip", now, 2, time.Minute), ShouldEqual, nil)
+		So(s.AddEvents("ip", now, 2, time.Minute), ShouldEqual, nil)
+		So(s.AddEvents("ip", now.Add(30*time.Second), 1, time.Minute), ShouldEqual, nil)
+		count, err := s.CountEvents("ip", now.Add(30*time.Second), time.Minute)
+		So(err, ShouldEqual, nil)
+		So(count, ShouldEqual, 5)
+		count, _ = s.CountEvents("ip", now.Add(61*time.Second), time.Minute)
+		So(count, ShouldEqual, 1)
+		So(server.Exists("gopistolet:ip"), ShouldEqual, true)
+
+		value := lockoutEntry{}
+		found, err := s.Get("lockout", now, &value)
+		So(err, ShouldEqual, nil)
+		So(found, ShouldEqual, false)
+		So(s.Set("lockout", now, &lockoutEntry{Lockouts: 2}, time.Minute), ShouldEqual, nil)
+		found, err = s.Get("lockout", now, &value)
+		So(err, ShouldEqual, nil)
+		So(found, ShouldEqual, true)
+		So(value.Lockouts, ShouldEqual, 2)
+		server.FastForward(time.Minute)
+		found, _ = s.Get("lockout", now, &value)
+		So(found, ShouldEqual, false)
+
+		So(s.Delete("ip"), ShouldEqual, nil)
+		count, _ = s.CountEvents("ip", now.Add(30*time.Second), time.Minute)
+		So(count, ShouldEqual, 0)
+	})
+
+	Convey("Testing limits shared by two instances", t, func() {
+		server, err := miniredis.Run()
+		So(err, ShouldEqual, nil)
+		defer server.Close()
+
+		first, second := &RateLimits{}, &RateLimits{}
+		for _, r := range []*RateLimits{first, second} {
+			r.Messages = RateLimiter{Limit: 2, Window: 60}
+			r.Share(NewRedisState(server.Addr(), "", 0, ""))
+		}
+		first.Count("192.168.0.10", 1)
+		second.Count("192.168.0.10", 1)
+		So(first.CheckIp("192.168.0.10"), ShouldEqual, false)
+		second.Count("192.168.0.10", 1)
+		So(first.CheckIp("192.168.0.10"), ShouldEqual, true)
+
+		a, b := &AuthLockout{MaxFailures: 2}, &AuthLockout{MaxFailures: 2}
+		a.Share(NewRedisState(server.Addr(), "", 0, ""))
+		b.Share(NewRedisState(server.Addr(), "", 0, ""))
+		a.Failed("192.168.0.10", "bob")
+		b.Failed("192.168.0.11", "bob")
+		So(a.Locked("192.168.0.12", "bob"), ShouldEqual, true)
+		So(b.Locked("192.168.0.10", ""), ShouldEqual, false)
+
+		// the limits don't apply while the server is down
+		server.Close()
+		So(first.CheckIp("192.168.0.10"), ShouldEqual, false)
+	})
+
+}
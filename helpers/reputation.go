@@ -0,0 +1,122 @@
+package helpers
+
+import (
+	"sync"
+	"time"
+)
+
+// Reputation remembers the client IPs which delivered accepted mail or authenticated recently,
+// so they can be preferred over unknown clients when the server is under load.
+// IPs are forgotten TTL seconds (default 30 days) after their last accepted message or AUTH.
+type Reputation struct {
+	TTL int
+
+	// now is time.Now, it can be replaced for testing
+	now func() time.Time
+
+	mutex    sync.RWMutex
+	lastSeen map[string]time.Time
+}
+
+func (r *Reputation) time() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+func (r *Reputation) ttl() time.Duration {
+	if r.TTL <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return time.Duration(r.TTL) * time.Second
+}
+
+// Add registers an accepted message or a successful AUTH from the IP
+func (r *Reputation) Add(ip string) {
+	ip = CanonicalIp(ParseIp(ip)).String()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.lastSeen == nil {
+		r.lastSeen = make(map[string]time.Time)
+	}
+	r.lastSeen[ip] = r.time()
+}
+
+// Known reports whether the IP delivered accepted mail within the TTL
+func (r *Reputation) Known(ip string) bool {
+	ip = CanonicalIp(ParseIp(ip)).String()
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	lastSeen, found := r.lastSeen[ip]
+	return found && r.time().Sub(lastSeen) < r.ttl()
+}
+
+// Cleanup forgets the IPs which weren't seen within the TTL, it should be called periodically
+func (r *Reputation) Cleanup() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.time()
+	for ip, lastSeen := range r.lastSeen {
+		if now.Sub(lastSeen) >= r.ttl() {
+			delete(r.lastSeen, ip)
+		}
+	}
+}
+
+// ConnectionShedding refuses the connections of unknown clients while MaxConnections connections are open,
+// so clients with a good Reputation (which delivered accepted mail or authenticated recently)
+// and trusted clients keep working during a connection flood. While shedding, a client which isn't trusted
+// may have MaxPerClient connections open (if it's not 0). Shedding is disabled if MaxConnections is 0.
+type ConnectionShedding struct {
+	MaxConnections int
+	MaxPerClient   int
+
+	Reputation *Reputation `json:"-"`
+
+	mutex     sync.Mutex
+	open      int
+	perClient map[string]int
+}
+
+// Open registers a new connection of the client, it returns false if the connection is refused.
+// Trusted clients are never refused. The connections which were accepted have to be closed with Close.
+func (s *ConnectionShedding) Open(ip string, trusted bool) bool {
+	if s.MaxConnections <= 0 {
+		return true
+	}
+	ip = CanonicalIp(ParseIp(ip)).String()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.open >= s.MaxConnections && !trusted {
+		known := s.Reputation != nil && s.Reputation.Known(ip)
+		if !known || (s.MaxPerClient > 0 && s.perClient[ip] >= s.MaxPerClient) {
+			return false
+		}
+	}
+	if s.perClient == nil {
+		s.perClient = make(map[string]int)
+	}
+	s.open++
+	s.perClient[ip]++
+	return true
+}
+
+// Close registers the end of a connection which Open accepted
+func (s *ConnectionShedding) Close(ip string) {
+	if s.MaxConnections <= 0 {
+		return
+	}
+	ip = CanonicalIp(ParseIp(ip)).String()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.open--
+	if s.perClient[ip]--; s.perClient[ip] <= 0 {
+		delete(s.perClient, ip)
+	}
+}
@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReputation(t *testing.T) {
+
+	now := time.Date(2016, 10, 5, 14, 0, 0, 0, time.UTC)
+
+	Convey("Testing Reputation", t, func() {
+		r := Reputation{TTL: 60, now: func() time.Time { return now }}
+		r.Add("192.168.0.10")
+		So(r.Known("192.168.0.10"), ShouldEqual, true)
+		So(r.Known("::ffff:192.168.0.10"), ShouldEqual, true)
+		So(r.Known("192.168.0.11"), ShouldEqual, false)
+
+		now = now.Add(time.Minute)
+		So(r.Known("192.168.0.10"), ShouldEqual, false)
+		r.Cleanup()
+		So(len(r.lastSeen), ShouldEqual, 0)
+	})
+
+	Convey("Testing ConnectionShedding", t, func() {
+		r := Reputation{now: func() time.Time { return now }}
+		r.Add("192.168.0.10")
+		s := ConnectionShedding{
+			MaxConnections: 2,
+			MaxPerClient:   2,
+			Reputation:     &r,
+		}
+
+		So(s.Open("10.0.0.1", false), ShouldEqual, true)
+		So(s.Open("10.0.0.2", false), ShouldEqual, true)
+		// the limit is reached: only known and trusted clients are accepted
+		So(s.Open("10.0.0.3", false), ShouldEqual, false)
+		So(s.Open("10.0.0.3", true), ShouldEqual, true)
+		So(s.Open("192.168.0.10", false), ShouldEqual, true)
+		So(s.Open("::ffff:192.168.0.10", false), ShouldEqual, true)
+		// with up to MaxPerClient connections
+		So(s.Open("192.168.0.10", false), ShouldEqual, false)
+		So(s.perClient["192.168.0.10"], ShouldEqual, 2)
+
+		s.Close("10.0.0.1")
+		s.Close("10.0.0.2")
+		s.Close("10.0.0.3")
+		So(s.Open("10.0.0.4", false), ShouldEqual, false)
+		s.Close("192.168.0.10")
+		So(s.Open("10.0.0.4", false), ShouldEqual, true)
+		So(s.open, ShouldEqual, 2)
+		So(len(s.perClient), ShouldEqual, 2)
+
+		// disabled
+		d := ConnectionShedding{}
+		So(d.Open("10.0.0.1", false), ShouldEqual, true)
+		d.Close("10.0.0.1")
+		So(d.open, ShouldEqual, 0)
+	})
+
+}
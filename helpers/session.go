@@ -0,0 +1,272 @@
+package helpers
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// maxCommandLine is the longest command line (with the CRLF) the MTA parses, it refuses longer lines
+const maxCommandLine = 512
+
+// errBareLineEnding makes the MTA abort the message, so it isn't handled
+var errBareLineEnding = errors.New("bare <CR> or <LF> in the message")
+
+// Position in the line of the message data
+const (
+	lineStart = iota
+	// lineDot is after a dot at the start of the line
+	lineDot
+	lineData
+)
+
+// Command is a command line which the client sent
+type Command struct {
+	Verb string
+	// Params are the arguments of the command by upper case keyword, with the value after the = (if any),
+	// e.g. PRDR and SIZE of MAIL FROM
+	Params map[string]string
+	// Args is the line after the verb, e.g. FROM:<alice@example.org> SIZE=1000
+	Args string
+	// Line is the whole line without the line ending, e.g. a response in an AUTH exchange
+	Line string
+}
+
+// SessionConn follows the SMTP dialogue of a connection for the extensions the MTA doesn't know about:
+// it keeps the parameters of the commands, which the MTA's parser drops, and tells the commands from the message data.
+// NextCommand returns the commands in the order the MTA reads them.
+//
+// It also guards the message data against SMTP smuggling: only <CR><LF>.<CR><LF> ends it, but the MTA also accepts
+// a dot line with a bare <LF>, which would let a client hide a second message in the data.
+// The MTA gets every line ending as <CR><LF> and the dot lines which don't end the data dot-stuffed.
+// Messages with bare <CR> or <LF> line endings are aborted (see Refused), unless Normalize is true.
+type SessionConn struct {
+	net.Conn
+	// Normalize accepts bare <CR> and <LF> line endings as <CR><LF>
+	Normalize bool
+
+	mutex    sync.Mutex
+	buffer   [4096]byte
+	line     []byte
+	long     bool
+	commands []Command
+	// out is what the MTA reads, err is returned once it's read up to refuseAt (if refusing)
+	out      []byte
+	err      error
+	refusing bool
+	refuseAt int
+	// data is true while the client sends a message, position is the position in its line
+	// and cr is true after a <CR> which may start a line ending
+	data     bool
+	position int
+	cr       bool
+	bare     bool
+	refused  bool
+}
+
+// NewSessionConn follows the dialogue on the connection
+func NewSessionConn(conn net.Conn) *SessionConn {
+	return &SessionConn{Conn: conn}
+}
+
+func (c *SessionConn) Read(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for len(c.out) == 0 && c.err == nil && !c.refusing {
+		c.mutex.Unlock()
+		n, err := c.Conn.Read(c.buffer[:])
+		c.mutex.Lock()
+		c.follow(c.buffer[:n])
+		c.err = err
+	}
+
+	end := len(c.out)
+	if c.refusing {
+		end = c.refuseAt
+	}
+	if end == 0 {
+		err := c.err
+		if c.refusing {
+			c.refusing, err = false, errBareLineEnding
+		} else {
+			c.err = nil
+		}
+		return 0, err
+	}
+	n := copy(b, c.out[:end])
+	c.out = c.out[n:]
+	if c.refusing {
+		c.refuseAt -= n
+	}
+	return n, nil
+}
+
+// follow parses the commands and passes them on, the message data is passed on with canonical line endings
+func (c *SessionConn) follow(b []byte) {
+	for _, char := range b {
+		if c.data {
+			c.dataByte(char)
+			continue
+		}
+		c.out = append(c.out, char)
+		if len(c.line) < maxCommandLine {
+			c.line = append(c.line, char)
+		} else {
+			c.long = true
+		}
+		if char != '\n' {
+			continue
+		}
+		command := Command{}
+		if !c.long {
+			command = parseCommand(string(c.line))
+		}
+		c.commands = append(c.commands, command)
+		c.line, c.long = c.line[:0], false
+	}
+}
+
+// dataByte follows a byte of the message data
+func (c *SessionConn) dataByte(char byte) {
+	if c.cr {
+		c.cr = false
+		if char == '\n' {
+			if c.position == lineDot {
+				c.endData()
+				return
+			}
+			c.emit('\r', '\n')
+			c.position = lineStart
+			return
+		}
+		// a bare <CR> ends the line, the byte after it starts the next one
+		c.bareLineEnding()
+	}
+
+	switch {
+	case char == '\r':
+		c.cr = true
+	case char == '\n':
+		c.bareLineEnding()
+	case char == '.' && c.position == lineStart:
+		c.position = lineDot
+	default:
+		if c.position == lineDot {
+			c.emit('.')
+		}
+		c.emit(char)
+		c.position = lineData
+	}
+}
+
+// bareLineEnding ends the line at a bare <CR> or <LF>, a dot line is stuffed so it doesn't end the data
+func (c *SessionConn) bareLineEnding() {
+	c.bare = true
+	if c.position == lineDot {
+		c.emit('.', '.')
+	}
+	c.emit('\r', '\n')
+	c.position = lineStart
+}
+
+// emit passes on message data, nothing is passed on after a bare line ending unless it's normalized
+func (c *SessionConn) emit(data ...byte) {
+	if c.bare && !c.Normalize {
+		return
+	}
+	c.out = append(c.out, data...)
+}
+
+// endData passes on the end of the data, the MTA reads an error instead if the message is refused
+func (c *SessionConn) endData() {
+	c.data = false
+	if c.bare && !c.Normalize {
+		c.refused = true
+		c.refusing, c.refuseAt = true, len(c.out)
+		return
+	}
+	c.out = append(c.out, ".\r\n"...)
+}
+
+func (c *SessionConn) Write(b []byte) (int, error) {
+	// only DATA is answered with 354, the message follows it
+	if strings.HasPrefix(string(b), "354") {
+		c.mutex.Lock()
+		c.data = true
+		c.position, c.cr, c.bare = lineStart, false, false
+		c.mutex.Unlock()
+	}
+	return c.Conn.Write(b)
+}
+
+// NextCommand returns the next command line which was read, lines which are too long have no verb
+func (c *SessionConn) NextCommand() Command {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.commands) == 0 {
+		return Command{}
+	}
+	command := c.commands[0]
+	c.commands = c.commands[1:]
+	return command
+}
+
+// StartTls starts TLS on the connection, after the reply to STARTTLS. Commands which the client
+// pipelined after STARTTLS are discarded, they were sent before the session was encrypted (RFC 3207 section 4.2).
+func (c *SessionConn) StartTls(config *tls.Config) error {
+	c.mutex.Lock()
+	c.out, c.commands, c.line, c.long = nil, nil, c.line[:0], false
+	c.mutex.Unlock()
+	conn := tls.Server(c.Conn, config)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	c.Conn = conn
+	return nil
+}
+
+// ConnectionState returns the state of the TLS connection, if STARTTLS started it
+// or the connection was accepted with TLS (below the transcript of the session)
+func (c *SessionConn) ConnectionState() (tls.ConnectionState, bool) {
+	conn := c.Conn
+	for {
+		switch wrapped := conn.(type) {
+		case *tls.Conn:
+			return wrapped.ConnectionState(), true
+		case *transcriptConn:
+			conn = wrapped.Conn
+		default:
+			return tls.ConnectionState{}, false
+		}
+	}
+}
+
+// Refused reports whether the last message was aborted because of its bare line endings,
+// the MTA replies that it couldn't parse the data then
+func (c *SessionConn) Refused() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	refused := c.refused
+	c.refused = false
+	return refused
+}
+
+// parseCommand splits the line in the verb and the parameters
+func parseCommand(line string) Command {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Command{}
+	}
+	command := Command{Verb: strings.ToUpper(fields[0]), Params: make(map[string]string), Line: strings.TrimRight(line, "\r\n")}
+	command.Args = strings.TrimSpace(line[strings.Index(line, fields[0])+len(fields[0]):])
+	for _, field := range fields[1:] {
+		key, value := field, ""
+		if i := strings.IndexByte(field, '='); i >= 0 {
+			key, value = field[:i], field[i+1:]
+		}
+		command.Params[strings.ToUpper(key)] = value
+	}
+	return command
+}
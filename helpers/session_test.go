@@ -0,0 +1,113 @@
+package helpers
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSessionConn(t *testing.T) {
+
+	Convey("Testing SessionConn", t, func() {
+		conn := &scriptConn{client: strings.NewReader("")}
+		session := NewSessionConn(conn)
+		send := func(lines string) {
+			conn.client = strings.NewReader(lines)
+			ioutil.ReadAll(session)
+		}
+
+		send("EHLO client.example.org\r\n")
+		session.Write([]byte("250-mx.example.com\r\n250-PRDR\r\n250 OK\r\n"))
+		// pipelined commands are read at once
+		send("MAIL FROM:<alice@example.org> BODY=8BITMIME prdr\r\nRCPT TO:<bob@example.com>\r\nDATA\r\n")
+		So(session.NextCommand().Verb, ShouldEqual, "EHLO")
+		mail := session.NextCommand()
+		So(mail.Verb, ShouldEqual, "MAIL")
+		So(mail.Params["BODY"], ShouldEqual, "8BITMIME")
+		So(mail.Args, ShouldEqual, "FROM:<alice@example.org> BODY=8BITMIME prdr")
+		_, prdr := mail.Params["PRDR"]
+		So(prdr, ShouldEqual, true)
+		rcpt := session.NextCommand()
+		So(rcpt.Verb, ShouldEqual, "RCPT")
+		_, prdr = rcpt.Params["PRDR"]
+		So(prdr, ShouldEqual, false)
+		So(session.NextCommand().Verb, ShouldEqual, "DATA")
+		_, tls := session.ConnectionState()
+		So(tls, ShouldBeFalse)
+
+		// the message isn't read as commands
+		session.Write([]byte("354 Start mail input; end with <CRLF>.<CRLF>\r\n"))
+		send("Subject: test\r\n\r\nMAIL FROM:<eve@example.org> PRDR\r\n.\r\nQUIT\r\n")
+		So(session.NextCommand().Verb, ShouldEqual, "QUIT")
+		So(session.NextCommand().Verb, ShouldEqual, "")
+
+		// lines which are too long are no commands, but the MTA parses them
+		send("MAIL FROM:<alice@example.org> " + strings.Repeat("X", maxCommandLine) + "\r\nRSET\r\n")
+		So(session.NextCommand().Verb, ShouldEqual, "")
+		So(session.NextCommand().Verb, ShouldEqual, "RSET")
+
+		// an empty message
+		session.Write([]byte("354 Start mail input; end with <CRLF>.<CRLF>\r\n"))
+		send(".\r\nNOOP\r\n")
+		So(session.NextCommand().Verb, ShouldEqual, "NOOP")
+
+		// the responses of AUTH exchanges are case sensitive
+		send("dXNlcg==\r\n")
+		So(session.NextCommand().Line, ShouldEqual, "dXNlcg==")
+	})
+
+	Convey("Testing SessionConn on a connection which was accepted with TLS", t, func() {
+		conn := &scriptConn{client: strings.NewReader("")}
+		transcriptConn, _ := (&Transcripts{}).Conn(tls.Server(conn, &tls.Config{}))
+		_, secure := NewSessionConn(transcriptConn).ConnectionState()
+		So(secure, ShouldBeTrue)
+	})
+
+	Convey("Testing the message data of SessionConn", t, func() {
+		conn := &scriptConn{client: strings.NewReader("")}
+		session := NewSessionConn(conn)
+		// read returns what the MTA reads until the end of the data, or until the message is aborted
+		read := func(data string) (string, error) {
+			session.Write([]byte("354 Start mail input; end with <CRLF>.<CRLF>\r\n"))
+			conn.client = strings.NewReader(data)
+			read, err := ioutil.ReadAll(session)
+			return string(read), err
+		}
+
+		data, err := read("Subject: test\r\n\r\n..\r\n.\r\n")
+		So(err, ShouldEqual, nil)
+		So(data, ShouldEqual, "Subject: test\r\n\r\n..\r\n.\r\n")
+		So(session.Refused(), ShouldEqual, false)
+
+		// a smuggled message isn't read as commands
+		data, err = read("Subject: test\r\n\r\nHello\n.\nMAIL FROM:<eve@example.org>\r\nRCPT TO:<bob@example.com>\r\n.\r\nQUIT\r\n")
+		So(err, ShouldEqual, errBareLineEnding)
+		So(data, ShouldEqual, "Subject: test\r\n\r\nHello")
+		So(session.Refused(), ShouldEqual, true)
+		So(session.Refused(), ShouldEqual, false)
+		// the commands after it are read next
+		rest, _ := ioutil.ReadAll(session)
+		So(string(rest), ShouldEqual, "QUIT\r\n")
+		So(session.NextCommand().Verb, ShouldEqual, "QUIT")
+		So(session.NextCommand().Verb, ShouldEqual, "")
+
+		// a bare <CR> can't end the data either
+		_, err = read("Hello\r\n.\rMAIL FROM:<eve@example.org>\r\n.\r\n")
+		So(err, ShouldEqual, errBareLineEnding)
+		So(session.Refused(), ShouldEqual, true)
+
+		// normalized line endings, the dot lines are stuffed
+		session.Normalize = true
+		data, err = read("Hello\n.\nMAIL FROM:<eve@example.org>\rBye\r\r\n.\r\n")
+		So(err, ShouldEqual, nil)
+		So(data, ShouldEqual, "Hello\r\n..\r\nMAIL FROM:<eve@example.org>\r\nBye\r\n\r\n.\r\n")
+		So(session.Refused(), ShouldEqual, false)
+		So(session.NextCommand().Verb, ShouldEqual, "")
+		data, _ = read(".\n.\r\n")
+		So(data, ShouldEqual, "..\r\n.\r\n")
+	})
+
+}
@@ -0,0 +1,108 @@
+package helpers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// Policies which pick the source IP of a pool
+const (
+	// SourceRoundRobin uses the IPs of the pool in turn
+	SourceRoundRobin = "round-robin"
+	// SourceDomain pins every recipient domain to one IP of the pool
+	SourceDomain = "domain"
+)
+
+// DefaultPool is the pool of the senders which aren't assigned to a pool
+const DefaultPool = "default"
+
+// SourceIps picks the source IP of outbound connections, so the reputation of senders can be kept apart.
+// The senders are assigned to pools of IPs, the Policy picks one of the IPs for a connection.
+// Only IPs of the address family of the destination are used: a pool with only IPv4 addresses
+// doesn't connect to IPv6 destinations. Without pools the system picks the source IP.
+type SourceIps struct {
+	// Pools of IPs, by name. Senders without pool use the default pool,
+	// or the source IP the system picks if there's no default pool.
+	Pools map[string][]string
+	// Senders assigns sender addresses and domains to pools
+	Senders map[string]string
+	// Policy is round-robin (default) or domain
+	Policy string
+
+	next uint32
+}
+
+// Enabled reports whether there are pools
+func (s *SourceIps) Enabled() bool {
+	return s != nil && len(s.Pools) > 0
+}
+
+// Check checks the IPs of the pools, the pools of the senders and the policy
+func (s *SourceIps) Check() error {
+	if s.Policy != "" && s.Policy != SourceRoundRobin && s.Policy != SourceDomain {
+		return fmt.Errorf("Policy should be round-robin or domain, not %q", s.Policy)
+	}
+	for name, ips := range s.Pools {
+		if len(ips) == 0 {
+			return fmt.Errorf("pool %s has no IPs", name)
+		}
+		for _, ip := range ips {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("pool %s: %q is not an IP", name, ip)
+			}
+		}
+	}
+	for sender, pool := range s.Senders {
+		if _, found := s.Pools[pool]; !found {
+			return fmt.Errorf("sender %s: unknown pool %q", sender, pool)
+		}
+	}
+	return nil
+}
+
+// Pool returns the name of the pool of the sender, which is assigned by address or by domain
+func (s *SourceIps) Pool(sender string) string {
+	domain := sender[strings.LastIndexByte(sender, '@')+1:]
+	for _, key := range []string{sender, domain} {
+		for assigned, pool := range s.Senders {
+			if key != "" && strings.EqualFold(assigned, key) {
+				return pool
+			}
+		}
+	}
+	return DefaultPool
+}
+
+// Select returns the source IP for a connection to the remote IP with mail from the sender for the domain.
+// It returns nil if the system picks the source IP, and false if the pool has no IP of the family of the remote IP.
+func (s *SourceIps) Select(sender, domain string, remote net.IP) (net.IP, bool) {
+	if !s.Enabled() {
+		return nil, true
+	}
+	ips, found := s.Pools[s.Pool(sender)]
+	if !found {
+		return nil, true
+	}
+
+	ipv4 := remote.To4() != nil
+	candidates := []net.IP{}
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed != nil && (parsed.To4() != nil) == ipv4 {
+			candidates = append(candidates, parsed)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	if s.Policy == SourceDomain {
+		hash := fnv.New32a()
+		hash.Write([]byte(strings.ToLower(domain)))
+		return candidates[hash.Sum32()%uint32(len(candidates))], true
+	}
+	return candidates[(atomic.AddUint32(&s.next, 1)-1)%uint32(len(candidates))], true
+}
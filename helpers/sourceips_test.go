@@ -0,0 +1,58 @@
+package helpers
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSourceIps(t *testing.T) {
+
+	Convey("Testing SourceIps.Select()", t, func() {
+		s := &SourceIps{}
+		ip, ok := s.Select("bob@example.com", "example.org", net.ParseIP("192.0.2.1"))
+		So(ip, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+
+		s = &SourceIps{
+			Pools: map[string][]string{
+				"default": {"198.51.100.1", "198.51.100.2", "2001:db8::1"},
+				"bulk":    {"203.0.113.1"},
+			},
+			Senders: map[string]string{"news.example.com": "bulk", "ceo@example.com": "default"},
+		}
+		So(s.Check(), ShouldEqual, nil)
+		So(s.Pool("Letter@NEWS.example.com"), ShouldEqual, "bulk")
+		So(s.Pool("bob@example.com"), ShouldEqual, DefaultPool)
+
+		// round-robin over the IPs of the family of the remote IP
+		ip, _ = s.Select("bob@example.com", "example.org", net.ParseIP("192.0.2.1"))
+		So(ip.String(), ShouldEqual, "198.51.100.1")
+		ip, _ = s.Select("bob@example.com", "example.org", net.ParseIP("192.0.2.1"))
+		So(ip.String(), ShouldEqual, "198.51.100.2")
+		ip, _ = s.Select("bob@example.com", "example.org", net.ParseIP("2001:db8::25"))
+		So(ip.String(), ShouldEqual, "2001:db8::1")
+
+		// the bulk pool has no IPv6 address
+		ip, ok = s.Select("letter@news.example.com", "example.org", net.ParseIP("2001:db8::25"))
+		So(ip, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+
+		// the domain policy pins every domain to one IP
+		s.Policy = SourceDomain
+		first, _ := s.Select("bob@example.com", "example.org", net.ParseIP("192.0.2.1"))
+		for i := 0; i < 5; i++ {
+			ip, _ = s.Select("bob@example.com", "Example.org", net.ParseIP("192.0.2.1"))
+			So(ip, ShouldResemble, first)
+		}
+	})
+
+	Convey("Testing SourceIps.Check()", t, func() {
+		So((&SourceIps{Policy: "random"}).Check(), ShouldNotEqual, nil)
+		So((&SourceIps{Pools: map[string][]string{"default": {"not an ip"}}}).Check(), ShouldNotEqual, nil)
+		So((&SourceIps{Pools: map[string][]string{"default": {}}}).Check(), ShouldNotEqual, nil)
+		So((&SourceIps{Senders: map[string]string{"example.com": "missing"}}).Check(), ShouldNotEqual, nil)
+	})
+
+}
@@ -0,0 +1,69 @@
+package helpers
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spamScoresTTL is how long the scores of a transaction are kept if they aren't taken
+const spamScoresTTL = time.Hour
+
+// SpamTest is a check of a spam scanner which matched the message, e.g. a DNS blocklist
+type SpamTest struct {
+	Name  string
+	Score float64
+}
+
+// SpamScores collects the tests of the spam scanners which ran for a transaction, keyed by session ID,
+// so the scores can be summed up in the X-Spam-Score and X-Spam-Status header fields
+type SpamScores struct {
+	mutex sync.Mutex
+	tests map[string]spamTests
+}
+
+type spamTests struct {
+	tests   []SpamTest
+	expires time.Time
+}
+
+// Add adds a test which matched the message of the transaction
+func (s *SpamScores) Add(sessionId, name string, score float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.tests == nil {
+		s.tests = make(map[string]spamTests)
+	}
+	now := time.Now()
+	for id, tests := range s.tests {
+		if now.After(tests.expires) {
+			delete(s.tests, id)
+		}
+	}
+	tests := s.tests[sessionId]
+	tests.tests = append(tests.tests, SpamTest{Name: name, Score: score})
+	tests.expires = now.Add(spamScoresTTL)
+	s.tests[sessionId] = tests
+}
+
+// Take returns and forgets the tests of the transaction
+func (s *SpamScores) Take(sessionId string) []SpamTest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tests := s.tests[sessionId]
+	delete(s.tests, sessionId)
+	return tests.tests
+}
+
+// SpamScore returns the score of the X-Spam-Score header field of the message
+func SpamScore(data []byte) (float64, bool) {
+	fields, _ := SplitHeader(data)
+	for _, field := range fields {
+		if strings.EqualFold(FieldName(field), "X-Spam-Score") {
+			score, err := strconv.ParseFloat(FieldValue(field), 64)
+			return score, err == nil
+		}
+	}
+	return 0, false
+}
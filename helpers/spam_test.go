@@ -0,0 +1,34 @@
+package helpers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSpam(t *testing.T) {
+
+	Convey("Testing SpamScores", t, func() {
+		s := &SpamScores{}
+		So(s.Take("1"), ShouldBeNil)
+
+		s.Add("1", "DNSBL_zen.example", 3)
+		s.Add("1", "SPF_FAIL", 2.5)
+		s.Add("2", "SPF_SOFTFAIL", 1)
+		So(s.Take("1"), ShouldResemble, []SpamTest{{"DNSBL_zen.example", 3}, {"SPF_FAIL", 2.5}})
+		So(s.Take("1"), ShouldBeNil)
+		So(s.Take("2"), ShouldResemble, []SpamTest{{"SPF_SOFTFAIL", 1}})
+	})
+
+	Convey("Testing SpamScore()", t, func() {
+		score, found := SpamScore([]byte("X-Spam-Score: 5.5\r\nSubject: test\r\n\r\nX-Spam-Score: 9\r\n"))
+		So(found, ShouldEqual, true)
+		So(score, ShouldEqual, 5.5)
+
+		_, found = SpamScore([]byte("Subject: test\r\n\r\nX-Spam-Score: 9\r\n"))
+		So(found, ShouldEqual, false)
+		_, found = SpamScore([]byte("X-Spam-Score: lots\r\n\r\n"))
+		So(found, ShouldEqual, false)
+	})
+
+}
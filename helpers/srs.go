@@ -0,0 +1,169 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Errors of reversing an SRS address
+var (
+	ErrSrsInvalid = errors.New("invalid SRS address")
+	ErrSrsHash    = errors.New("SRS address has an invalid hash")
+	ErrSrsExpired = errors.New("SRS address has expired")
+)
+
+// srsTimeAlphabet is the base32 alphabet of the SRS timestamps
+const srsTimeAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+// srsTimeSlots is the period of the timestamps in days (2 base32 characters)
+const srsTimeSlots = 32 * 32
+
+// Srs rewrites the envelope sender of forwarded mail with the Sender Rewriting Scheme, so the message
+// passes the SPF checks of the destination: sender@example.org is sent as
+// SRS0=HHHH=TT=example.org=sender@<Domain>, where HHHH is an HMAC of the rest and TT the day.
+// Mail which was already forwarded with SRS gets an SRS1 address, which points to the first forwarder.
+// Bounces to the SRS addresses are reversed to the original sender while the address is younger than
+// MaxAgeDays. The first of the Secrets signs new addresses, the others are still accepted
+// so the secret can be rotated. SRS is disabled without secrets.
+type Srs struct {
+	Domain     string
+	Secrets    []string
+	MaxAgeDays int
+
+	// now is time.Now, it can be replaced for testing
+	now func() time.Time
+}
+
+// Enabled reports whether senders are rewritten
+func (s *Srs) Enabled() bool {
+	return len(s.Secrets) > 0 && s.Domain != ""
+}
+
+func (s *Srs) time() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// IsSrs reports whether the address is an SRS address of the Domain
+func (s *Srs) IsSrs(address string) bool {
+	i := strings.LastIndexByte(address, '@')
+	if i < 0 || !strings.EqualFold(address[i+1:], s.Domain) {
+		return false
+	}
+	local := strings.ToUpper(address[:i])
+	return strings.HasPrefix(local, "SRS0=") || strings.HasPrefix(local, "SRS1=")
+}
+
+// srsHash returns the first 4 characters of the base64 HMAC-SHA1 of the parts (lower case) with the secret
+func srsHash(secret string, parts ...string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	for _, part := range parts {
+		mac.Write([]byte(strings.ToLower(part)))
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))[:4]
+}
+
+// checkHash checks the hash against all secrets, case insensitive since some servers change the case
+func (s *Srs) checkHash(hash string, parts ...string) bool {
+	for _, secret := range s.Secrets {
+		if strings.EqualFold(hash, srsHash(secret, parts...)) {
+			return true
+		}
+	}
+	return false
+}
+
+// srsTimestamp returns the day of t as 2 base32 characters
+func srsTimestamp(t time.Time) string {
+	day := t.Unix() / (24 * 60 * 60) % srsTimeSlots
+	return string([]byte{srsTimeAlphabet[day/32], srsTimeAlphabet[day%32]})
+}
+
+// checkTimestamp checks that the timestamp isn't older than MaxAgeDays (default 21)
+func (s *Srs) checkTimestamp(timestamp string) bool {
+	if len(timestamp) != 2 {
+		return false
+	}
+	timestamp = strings.ToUpper(timestamp)
+	high := strings.IndexByte(srsTimeAlphabet, timestamp[0])
+	low := strings.IndexByte(srsTimeAlphabet, timestamp[1])
+	if high < 0 || low < 0 {
+		return false
+	}
+	maxAge := s.MaxAgeDays
+	if maxAge <= 0 {
+		maxAge = 21
+	}
+	today := s.time().Unix() / (24 * 60 * 60) % srsTimeSlots
+	age := (today - int64(high*32+low) + srsTimeSlots) % srsTimeSlots
+	return age <= int64(maxAge)
+}
+
+// Forward returns the SRS address for the sender. The null sender and senders in the Domain
+// aren't rewritten.
+func (s *Srs) Forward(sender string) string {
+	i := strings.LastIndexByte(sender, '@')
+	if !s.Enabled() || i < 0 || strings.EqualFold(sender[i+1:], s.Domain) && !s.IsSrs(sender) {
+		return sender
+	}
+	local, domain := sender[:i], sender[i+1:]
+	secret := s.Secrets[0]
+
+	upper := strings.ToUpper(local)
+	switch {
+	case strings.HasPrefix(upper, "SRS0="):
+		// SRS1=HHHH=example.net==<the SRS0 address without SRS0>
+		rest := local[len("SRS0"):]
+		return "SRS1=" + srsHash(secret, domain, rest) + "=" + domain + "=" + rest + "@" + s.Domain
+	case strings.HasPrefix(upper, "SRS1="):
+		// keep pointing to the first forwarder, with our own hash
+		parts := strings.SplitN(local, "=", 4)
+		if len(parts) == 4 {
+			return "SRS1=" + srsHash(secret, parts[2], parts[3]) + "=" + parts[2] + "=" + parts[3] + "@" + s.Domain
+		}
+	}
+
+	timestamp := srsTimestamp(s.time())
+	return "SRS0=" + srsHash(secret, timestamp, domain, local) + "=" + timestamp + "=" + domain + "=" + local + "@" + s.Domain
+}
+
+// Reverse returns the address to which a bounce to the SRS address should be sent:
+// the original sender for SRS0 addresses, and the SRS0 address of the first forwarder for SRS1 addresses
+func (s *Srs) Reverse(address string) (string, error) {
+	if !s.IsSrs(address) {
+		return "", ErrSrsInvalid
+	}
+	local := address[:strings.LastIndexByte(address, '@')]
+
+	if strings.EqualFold(local[:4], "SRS1") {
+		// SRS1=HHHH=example.net==HHHH=TT=example.org=sender
+		parts := strings.SplitN(local, "=", 4)
+		if len(parts) != 4 || parts[2] == "" || !strings.HasPrefix(parts[3], "=") {
+			return "", ErrSrsInvalid
+		}
+		if !s.checkHash(parts[1], parts[2], parts[3]) {
+			return "", ErrSrsHash
+		}
+		return "SRS0" + parts[3] + "@" + parts[2], nil
+	}
+
+	// SRS0=HHHH=TT=example.org=sender, the local part of the sender may contain =
+	parts := strings.SplitN(local, "=", 5)
+	if len(parts) != 5 || parts[3] == "" || parts[4] == "" {
+		return "", ErrSrsInvalid
+	}
+	hash, timestamp, domain, sender := parts[1], parts[2], parts[3], parts[4]
+	if !s.checkHash(hash, timestamp, domain, sender) {
+		return "", ErrSrsHash
+	}
+	if !s.checkTimestamp(timestamp) {
+		return "", ErrSrsExpired
+	}
+	return sender + "@" + domain, nil
+}
@@ -0,0 +1,88 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSrs(t *testing.T) {
+
+	Convey("Testing Srs", t, func() {
+		now := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+		s := &Srs{
+			Domain:  "forwarder.example",
+			Secrets: []string{"secret"},
+			now:     func() time.Time { return now },
+		}
+
+		So((&Srs{Domain: "forwarder.example"}).Forward("bob@example.org"), ShouldEqual, "bob@example.org")
+		So(s.Forward(""), ShouldEqual, "")
+		So(s.Forward("alice@forwarder.example"), ShouldEqual, "alice@forwarder.example")
+
+		address := s.Forward("bob=x@example.org")
+		So(strings.HasPrefix(address, "SRS0="), ShouldEqual, true)
+		So(strings.HasSuffix(address, "=example.org=bob=x@forwarder.example"), ShouldEqual, true)
+		So(s.IsSrs(address), ShouldEqual, true)
+		So(s.IsSrs(strings.ToLower(address)), ShouldEqual, true)
+		So(s.IsSrs("bob@example.org"), ShouldEqual, false)
+
+		sender, err := s.Reverse(address)
+		So(err, ShouldEqual, nil)
+		So(sender, ShouldEqual, "bob=x@example.org")
+
+		// the case of the hash may be changed
+		sender, err = s.Reverse(strings.ToLower(address))
+		So(err, ShouldEqual, nil)
+		So(sender, ShouldEqual, "bob=x@example.org")
+
+		// rotated secrets are still accepted
+		rotated := &Srs{Domain: s.Domain, Secrets: []string{"new", "secret"}, now: s.now}
+		_, err = rotated.Reverse(address)
+		So(err, ShouldEqual, nil)
+		_, err = (&Srs{Domain: s.Domain, Secrets: []string{"new"}, now: s.now}).Reverse(address)
+		So(err, ShouldEqual, ErrSrsHash)
+
+		_, err = s.Reverse("SRS0=AAAA" + address[9:])
+		So(err, ShouldEqual, ErrSrsHash)
+		_, err = s.Reverse("SRS0=AAAA@forwarder.example")
+		So(err, ShouldEqual, ErrSrsInvalid)
+		_, err = s.Reverse("bob@forwarder.example")
+		So(err, ShouldEqual, ErrSrsInvalid)
+
+		// addresses expire after MaxAgeDays
+		now = now.Add(21 * 24 * time.Hour)
+		_, err = s.Reverse(address)
+		So(err, ShouldEqual, nil)
+		now = now.Add(24 * time.Hour)
+		_, err = s.Reverse(address)
+		So(err, ShouldEqual, ErrSrsExpired)
+		now = now.Add(-22 * 24 * time.Hour)
+
+		Convey("Forwarding SRS addresses", func() {
+			next := &Srs{Domain: "next.example", Secrets: []string{"other"}, now: s.now}
+
+			srs1 := next.Forward(address)
+			So(strings.HasPrefix(srs1, "SRS1="), ShouldEqual, true)
+			So(strings.HasSuffix(srs1, "=forwarder.example=="+address[len("SRS0="):strings.IndexByte(address, '@')]+"@next.example"), ShouldEqual, true)
+
+			original, err := next.Reverse(srs1)
+			So(err, ShouldEqual, nil)
+			So(original, ShouldEqual, address)
+
+			// a third forwarder keeps pointing to the first one
+			last := &Srs{Domain: "last.example", Secrets: []string{"last"}, now: s.now}
+			srs1 = last.Forward(srs1)
+			So(strings.HasPrefix(srs1, "SRS1="), ShouldEqual, true)
+			original, err = last.Reverse(srs1)
+			So(err, ShouldEqual, nil)
+			So(original, ShouldEqual, address)
+
+			_, err = last.Reverse("SRS1=AAAA" + srs1[9:])
+			So(err, ShouldEqual, ErrSrsHash)
+		})
+	})
+
+}
@@ -0,0 +1,158 @@
+package helpers
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// State keeps the state of the policies which the frontends of a cluster have to agree on
+// (the rate limit counters and the AUTH lockouts). MemoryState keeps it in the process,
+// RedisState in a Redis server which is shared by the frontends behind a load balancer.
+type State interface {
+	// AddEvents counts n events for the key at now, the events older than the window are forgotten
+	AddEvents(key string, now time.Time, n int, window time.Duration) error
+	// CountEvents returns the number of events for the key within the window before now
+	CountEvents(key string, now time.Time, window time.Duration) (int, error)
+	// Get decodes the value of the key, found is false if it isn't set or it expired
+	Get(key string, now time.Time, value interface{}) (found bool, err error)
+	// Set sets the value of the key, it expires after the ttl
+	Set(key string, now time.Time, value interface{}, ttl time.Duration) error
+	// Delete removes the events or the value of the key
+	Delete(key string) error
+}
+
+// MemoryState is the State of a single instance, the zero value is ready to use
+type MemoryState struct {
+	mutex  sync.Mutex
+	events map[string]*memoryEvents
+	values map[string]memoryValue
+}
+
+type memoryEvents struct {
+	events  []rateEvent
+	expires time.Time
+}
+
+type rateEvent struct {
+	time  time.Time
+	count int
+}
+
+type memoryValue struct {
+	data    []byte
+	expires time.Time
+}
+
+// AddEvents implements State
+func (s *MemoryState) AddEvents(key string, now time.Time, n int, window time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.events == nil {
+		s.events = make(map[string]*memoryEvents)
+	}
+	entry := s.expire(key, now, window)
+	if entry == nil {
+		entry = &memoryEvents{}
+		s.events[key] = entry
+	}
+	entry.events = append(entry.events, rateEvent{time: now, count: n})
+	entry.expires = now.Add(window)
+	return nil
+}
+
+// CountEvents implements State
+func (s *MemoryState) CountEvents(key string, now time.Time, window time.Duration) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	if entry := s.expire(key, now, window); entry != nil {
+		for _, event := range entry.events {
+			count += event.count
+		}
+	}
+	return count, nil
+}
+
+// expire removes the events of the key outside the window, the mutex must be held
+func (s *MemoryState) expire(key string, now time.Time, window time.Duration) *memoryEvents {
+	entry, found := s.events[key]
+	if !found {
+		return nil
+	}
+	start := now.Add(-window)
+	i := 0
+	for i < len(entry.events) && !entry.events[i].time.After(start) {
+		i++
+	}
+	entry.events = entry.events[i:]
+	if len(entry.events) == 0 {
+		delete(s.events, key)
+		return nil
+	}
+	return entry
+}
+
+// Get implements State
+func (s *MemoryState) Get(key string, now time.Time, value interface{}) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, found := s.values[key]
+	if !found || !now.Before(v.expires) {
+		return false, nil
+	}
+	return true, json.Unmarshal(v.data, value)
+}
+
+// Set implements State
+func (s *MemoryState) Set(key string, now time.Time, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.values == nil {
+		s.values = make(map[string]memoryValue)
+	}
+	s.values[key] = memoryValue{data: data, expires: now.Add(ttl)}
+	return nil
+}
+
+// Delete implements State
+func (s *MemoryState) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.events, key)
+	delete(s.values, key)
+	return nil
+}
+
+// Len returns the number of keys with events or a value
+func (s *MemoryState) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.events) + len(s.values)
+}
+
+// Cleanup removes the keys which expired, it should be called periodically
+func (s *MemoryState) Cleanup(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, entry := range s.events {
+		if !now.Before(entry.expires) {
+			delete(s.events, key)
+		}
+	}
+	for key, v := range s.values {
+		if !now.Before(v.expires) {
+			delete(s.values, key)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package helpers
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdListenFdsStart is the first file descriptor passed by systemd (SD_LISTEN_FDS_START)
+const sdListenFdsStart = 3
+
+// SdNotify sends the state (e.g. "READY=1") to systemd (see sd_notify(3)).
+// It returns false if GoPistolet wasn't started by systemd with Type=notify.
+func SdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// abstract sockets start with @
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SdWatchdog returns the interval in which systemd expects "WATCHDOG=1" notifications,
+// or 0 if the watchdog isn't enabled for this process (see sd_watchdog_enabled(3))
+func SdWatchdog() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// StartSdWatchdog notifies the systemd watchdog at half its interval until stop is closed
+func StartSdWatchdog(stop <-chan struct{}) {
+	interval := SdWatchdog()
+	if interval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				SdNotify("WATCHDOG=1")
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// SdListeners returns the sockets passed by systemd socket activation (see sd_listen_fds(3)),
+// keyed by their FileDescriptorName (or their address if they have no name).
+// The environment variables are unset, so child processes don't inherit them.
+func SdListeners() (map[string]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make(map[string]net.Listener)
+	for fd := sdListenFdsStart; fd < sdListenFdsStart+count; fd++ {
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+
+		name := listener.Addr().String()
+		if i := fd - sdListenFdsStart; i < len(names) && names[i] != "" && names[i] != "unknown" {
+			name = names[i]
+		}
+		listeners[name] = listener
+	}
+	return listeners, nil
+}
@@ -0,0 +1,77 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSystemd(t *testing.T) {
+
+	Convey("Testing SdNotify", t, func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		ok, err := SdNotify("READY=1")
+		So(err, ShouldEqual, nil)
+		So(ok, ShouldEqual, false)
+
+		dir, err := ioutil.TempDir("", "systemd")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		socket := filepath.Join(dir, "notify")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+		So(err, ShouldEqual, nil)
+		defer conn.Close()
+
+		os.Setenv("NOTIFY_SOCKET", socket)
+		defer os.Unsetenv("NOTIFY_SOCKET")
+		ok, err = SdNotify("READY=1")
+		So(err, ShouldEqual, nil)
+		So(ok, ShouldEqual, true)
+
+		buffer := make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buffer)
+		So(err, ShouldEqual, nil)
+		So(string(buffer[:n]), ShouldEqual, "READY=1")
+	})
+
+	Convey("Testing SdWatchdog", t, func() {
+		defer os.Unsetenv("WATCHDOG_USEC")
+		defer os.Unsetenv("WATCHDOG_PID")
+
+		os.Unsetenv("WATCHDOG_USEC")
+		So(SdWatchdog(), ShouldEqual, 0)
+
+		os.Setenv("WATCHDOG_USEC", "30000000")
+		So(SdWatchdog(), ShouldEqual, 30*time.Second)
+
+		// the watchdog is meant for another process
+		os.Setenv("WATCHDOG_PID", "1")
+		So(SdWatchdog(), ShouldEqual, 0)
+		os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+		So(SdWatchdog(), ShouldEqual, 30*time.Second)
+	})
+
+	Convey("Testing SdListeners", t, func() {
+		// the sockets are meant for another process
+		os.Setenv("LISTEN_PID", "1")
+		os.Setenv("LISTEN_FDS", "1")
+		listeners, err := SdListeners()
+		So(err, ShouldEqual, nil)
+		So(listeners, ShouldEqual, nil)
+		So(os.Getenv("LISTEN_FDS"), ShouldEqual, "")
+
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		os.Setenv("LISTEN_FDS", "0")
+		listeners, err = SdListeners()
+		So(err, ShouldEqual, nil)
+		So(listeners, ShouldEqual, nil)
+	})
+
+}
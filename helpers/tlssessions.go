@@ -0,0 +1,57 @@
+package helpers
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+)
+
+// TlsSessions keeps the TLS connection states of the sessions which use TLS, keyed by session ID,
+// for the handlers which report how the message was received (e.g. the Received header field)
+type TlsSessions struct {
+	mutex    sync.Mutex
+	sessions map[string]tls.ConnectionState
+}
+
+// Set records the TLS connection state of the session
+func (t *TlsSessions) Set(sessionId string, state tls.ConnectionState) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.sessions == nil {
+		t.sessions = make(map[string]tls.ConnectionState)
+	}
+	t.sessions[sessionId] = state
+}
+
+// Get returns the TLS connection state of the session, if it uses TLS
+func (t *TlsSessions) Get(sessionId string) (tls.ConnectionState, bool) {
+	if t == nil {
+		return tls.ConnectionState{}, false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	state, found := t.sessions[sessionId]
+	return state, found
+}
+
+// Forget removes the TLS connection state when the session ends
+func (t *TlsSessions) Forget(sessionId string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.sessions, sessionId)
+}
+
+// TlsVersion returns the name of the TLS version, e.g. TLSv1.3
+func TlsVersion(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
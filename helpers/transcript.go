@@ -0,0 +1,301 @@
+package helpers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTranscriptLine is the length at which a line in a transcript is cut off
+const maxTranscriptLine = 1000
+
+// Transcripts records the exchanges between the clients and the server per session, to diagnose interop
+// problems with picky clients. The last Ring transcripts are kept in memory for the admin API, and if Dir
+// isn't empty every transcript is written to a file in it, of which the last MaxFiles are kept.
+//
+// The credentials of AUTH exchanges are redacted, and the message data is only recorded with Data.
+// After STARTTLS the rest of the session isn't recorded, since only its ciphertext can be seen.
+type Transcripts struct {
+	// Enabled records the sessions, it's a debug mode which shouldn't be left on
+	Enabled bool
+	// Dir in which a file is written per session, transcripts are only kept in memory if it's empty
+	Dir string
+	// MaxFiles is the number of files which are kept in Dir (0 means 1000)
+	MaxFiles int
+	// Ring is the number of transcripts which are kept in memory (0 means 100)
+	Ring int
+	// Data records the message data too, instead of its size
+	Data bool
+
+	mutex   sync.Mutex
+	ring    []*Transcript
+	counter uint64
+}
+
+// Transcript of a session, the lines the client sent start with "C: " and those of the server with "S: "
+type Transcript struct {
+	Id        string
+	SessionId string
+	Ip        string
+	Start     time.Time
+	End       time.Time
+	Lines     []string
+
+	mutex       sync.Mutex
+	recordData  bool
+	client      []byte
+	server      []byte
+	dataPending bool
+	data        bool
+	dataBytes   int
+	auth        bool
+	tlsPending  bool
+	tls         bool
+}
+
+func (t *Transcripts) maxFiles() int {
+	if t.MaxFiles <= 0 {
+		return 1000
+	}
+	return t.MaxFiles
+}
+
+func (t *Transcripts) ringSize() int {
+	if t.Ring <= 0 {
+		return 100
+	}
+	return t.Ring
+}
+
+// Conn returns a connection which records what's read and written on conn in a new transcript,
+// the transcript has to be finished with Finish when the session is over
+func (t *Transcripts) Conn(conn net.Conn) (net.Conn, *Transcript) {
+	t.mutex.Lock()
+	t.counter++
+	counter := t.counter
+	t.mutex.Unlock()
+
+	start := time.Now()
+	transcript := &Transcript{
+		Id:         fmt.Sprintf("%s-%06d", start.Format("20060102-150405"), counter),
+		Start:      start,
+		recordData: t.Data,
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		transcript.Ip = addr.IP.String()
+	}
+	return &transcriptConn{Conn: conn, transcript: transcript}, transcript
+}
+
+// Finish stores the transcript of the session with the id, in memory and in Dir
+func (t *Transcripts) Finish(transcript *Transcript, sessionId string) error {
+	transcript.mutex.Lock()
+	transcript.flush()
+	transcript.SessionId = sessionId
+	transcript.End = time.Now()
+	transcript.mutex.Unlock()
+
+	t.mutex.Lock()
+	t.ring = append(t.ring, transcript)
+	if len(t.ring) > t.ringSize() {
+		t.ring = t.ring[len(t.ring)-t.ringSize():]
+	}
+	t.mutex.Unlock()
+
+	if t.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(t.Dir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(t.Dir, transcript.Id+".txt")
+	if err := ioutil.WriteFile(path, []byte(transcript.String()), 0600); err != nil {
+		return err
+	}
+	return t.rotate()
+}
+
+// rotate removes the oldest files in Dir if there are more than MaxFiles
+func (t *Transcripts) rotate() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(t.Dir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	// the names start with the time of the session
+	sort.Strings(files)
+	for len(files) > t.maxFiles() {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// All returns the transcripts in memory, the oldest first
+func (t *Transcripts) All() []*Transcript {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]*Transcript{}, t.ring...)
+}
+
+// Get returns the transcript in memory with the id
+func (t *Transcripts) Get(id string) (*Transcript, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, transcript := range t.ring {
+		if transcript.Id == id {
+			return transcript, true
+		}
+	}
+	return nil, false
+}
+
+func (t *Transcript) String() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	header := fmt.Sprintf("Session %s from %s, %s - %s\n", t.SessionId, t.Ip, t.Start.Format(time.RFC3339), t.End.Format(time.RFC3339))
+	if len(t.Lines) == 0 {
+		return header
+	}
+	return header + strings.Join(t.Lines, "\n") + "\n"
+}
+
+// record adds the complete lines in buffer, and returns the rest
+func (t *Transcript) record(buffer []byte, line func(string)) []byte {
+	for {
+		i := bytes.IndexByte(buffer, '\n')
+		if i < 0 {
+			break
+		}
+		line(strings.TrimRight(string(buffer[:i]), "\r"))
+		buffer = buffer[i+1:]
+	}
+	if len(buffer) > maxTranscriptLine {
+		line(string(buffer))
+		buffer = nil
+	}
+	return buffer
+}
+
+func (t *Transcript) add(prefix, line string) {
+	if len(line) > maxTranscriptLine {
+		line = line[:maxTranscriptLine] + "..."
+	}
+	t.Lines = append(t.Lines, prefix+line)
+}
+
+func (t *Transcript) read(b []byte) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.tls {
+		return
+	}
+	t.client = t.record(append(t.client, b...), t.clientLine)
+}
+
+func (t *Transcript) write(b []byte) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.tls {
+		return
+	}
+	t.server = t.record(append(t.server, b...), t.serverLine)
+}
+
+// flush adds the incomplete lines of a session which ended
+func (t *Transcript) flush() {
+	if len(t.client) > 0 && !t.tls {
+		t.clientLine(string(t.client))
+	}
+	if len(t.server) > 0 && !t.tls {
+		t.serverLine(string(t.server))
+	}
+	t.client, t.server = nil, nil
+}
+
+func (t *Transcript) clientLine(line string) {
+	switch {
+	case t.data && line == ".":
+		t.data = false
+		if !t.recordData {
+			t.add("C: ", fmt.Sprintf("[%d bytes of message data]", t.dataBytes))
+		}
+		t.add("C: ", line)
+	case t.data:
+		t.dataBytes += len(line) + 2
+		if t.recordData {
+			t.add("C: ", line)
+		}
+	case t.auth:
+		// a response to a challenge of an AUTH exchange
+		t.add("C: ", "[redacted]")
+	default:
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "AUTH "):
+			// keep the mechanism, drop the initial response
+			fields := strings.Fields(line)
+			if len(fields) > 2 {
+				line = fields[0] + " " + fields[1] + " [redacted]"
+			}
+		case command == "DATA":
+			t.dataPending = true
+		case command == "STARTTLS":
+			t.tlsPending = true
+		}
+		t.add("C: ", line)
+	}
+}
+
+func (t *Transcript) serverLine(line string) {
+	t.add("S: ", line)
+	// the last line of a reply has a space (or nothing) after the code
+	if len(line) > 3 && line[3] == '-' {
+		return
+	}
+	code := line
+	if len(code) > 3 {
+		code = code[:3]
+	}
+	t.auth = code == "334"
+	if t.dataPending {
+		t.dataPending = false
+		t.data = code == "354"
+		t.dataBytes = 0
+	}
+	if t.tlsPending {
+		t.tlsPending = false
+		if code == "220" {
+			t.tls = true
+			t.Lines = append(t.Lines, "-- TLS started, the rest of the session isn't recorded")
+		}
+	}
+}
+
+// transcriptConn records the bytes which are read and written in a transcript
+type transcriptConn struct {
+	net.Conn
+	transcript *Transcript
+}
+
+func (c *transcriptConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.transcript.read(b[:n])
+	return n, err
+}
+
+func (c *transcriptConn) Write(b []byte) (int, error) {
+	c.transcript.write(b)
+	return c.Conn.Write(b)
+}
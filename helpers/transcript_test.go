@@ -0,0 +1,143 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// scriptConn is a connection on which the client sends the lines of a script
+type scriptConn struct {
+	net.Conn
+	client  *strings.Reader
+	written []byte
+}
+
+func (c *scriptConn) Read(b []byte) (int, error) { return c.client.Read(b) }
+
+func (c *scriptConn) Write(b []byte) (int, error) {
+	c.written = append(c.written, b...)
+	return len(b), nil
+}
+
+func (c *scriptConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12345}
+}
+
+// session replays a session: client lines are sent, server lines are written
+func session(t *Transcripts, lines ...string) *Transcript {
+	conn := &scriptConn{client: strings.NewReader("")}
+	recorded, transcript := t.Conn(conn)
+	for _, line := range lines {
+		if strings.HasPrefix(line, "C: ") {
+			conn.client = strings.NewReader(line[3:] + "\r\n")
+			ioutil.ReadAll(recorded)
+		} else {
+			recorded.Write([]byte(line[3:] + "\r\n"))
+		}
+	}
+	t.Finish(transcript, "1")
+	return transcript
+}
+
+func TestTranscripts(t *testing.T) {
+
+	Convey("Testing Transcripts", t, func() {
+		transcripts := &Transcripts{Enabled: true, Ring: 2}
+
+		transcript := session(transcripts,
+			"S: 220 mx.example.com Service Ready",
+			"C: EHLO client.example.org",
+			"S: 250-mx.example.com",
+			"S: 250 8BITMIME",
+			"C: AUTH PLAIN AHVzZXIAc2VjcmV0",
+			"S: 500 Command not recognized",
+			"C: AUTH LOGIN",
+			"S: 334 VXNlcm5hbWU6",
+			"C: dXNlcg==",
+			"S: 334 UGFzc3dvcmQ6",
+			"C: c2VjcmV0",
+			"S: 235 ok",
+			"C: MAIL FROM:<alice@example.org>",
+			"S: 250 ok",
+			"C: DATA",
+			"S: 354 Start mail input",
+			"C: Subject: secret",
+			"C: ",
+			"C: the content",
+			"C: .",
+			"S: 250 Mail delivered",
+			"C: STARTTLS",
+			"S: 220 Ready for TLS handshake",
+			"C: \x16\x03\x01 handshake",
+		)
+		So(transcript.Ip, ShouldEqual, "192.0.2.1")
+		So(transcript.SessionId, ShouldEqual, "1")
+		So(transcript.Lines, ShouldResemble, []string{
+			"S: 220 mx.example.com Service Ready",
+			"C: EHLO client.example.org",
+			"S: 250-mx.example.com",
+			"S: 250 8BITMIME",
+			"C: AUTH PLAIN [redacted]",
+			"S: 500 Command not recognized",
+			"C: AUTH LOGIN",
+			"S: 334 VXNlcm5hbWU6",
+			"C: [redacted]",
+			"S: 334 UGFzc3dvcmQ6",
+			"C: [redacted]",
+			"S: 235 ok",
+			"C: MAIL FROM:<alice@example.org>",
+			"S: 250 ok",
+			"C: DATA",
+			"S: 354 Start mail input",
+			"C: [32 bytes of message data]",
+			"C: .",
+			"S: 250 Mail delivered",
+			"C: STARTTLS",
+			"S: 220 Ready for TLS handshake",
+			"-- TLS started, the rest of the session isn't recorded",
+		})
+		So(transcript.String(), ShouldStartWith, "Session 1 from 192.0.2.1")
+
+		// with the message data
+		transcripts.Data = true
+		transcript = session(transcripts, "C: DATA", "S: 354 go ahead", "C: ..hidden", "C: .", "S: 250 ok")
+		So(transcript.Lines, ShouldResemble, []string{"C: DATA", "S: 354 go ahead", "C: ..hidden", "C: .", "S: 250 ok"})
+
+		// refused DATA
+		transcript = session(transcripts, "C: DATA", "S: 503 Need RCPT before DATA", "C: QUIT")
+		So(transcript.Lines[2], ShouldEqual, "C: QUIT")
+
+		// only the last sessions are kept in memory
+		all := transcripts.All()
+		So(len(all), ShouldEqual, 2)
+		So(all[1].Id, ShouldEqual, transcript.Id)
+		_, found := transcripts.Get(transcript.Id)
+		So(found, ShouldBeTrue)
+		_, found = transcripts.Get("unknown")
+		So(found, ShouldBeFalse)
+	})
+
+	Convey("Testing Transcripts files", t, func() {
+		dir, err := ioutil.TempDir("", "transcripts")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		transcripts := &Transcripts{Enabled: true, Dir: dir, MaxFiles: 2}
+		ids := []string{}
+		for i := 0; i < 3; i++ {
+			ids = append(ids, session(transcripts, "C: QUIT", "S: 221 Bye!").Id)
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*.txt"))
+		So(len(files), ShouldEqual, 2)
+		So(filepath.Base(files[0]), ShouldEqual, ids[1]+".txt")
+		data, _ := ioutil.ReadFile(files[1])
+		So(string(data), ShouldContainSubstring, "C: QUIT\nS: 221 Bye!\n")
+	})
+
+}
@@ -0,0 +1,153 @@
+package helpers
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransportMx is the host of the transports (and the smarthost) which delivers the mail
+// to the MX hosts of the recipient domains instead of relaying it
+const TransportMx = "mx"
+
+// Transports map recipient domains to the host (host:port) their mail is relayed to instead of the smarthost.
+// A domain starting with a dot (.example.com) matches the subdomains, * matches all domains.
+//
+// The File has a domain and a host per line:
+//
+//	# comment
+//	example.com     mx.example.com:25
+//	.example.org    relay.example.org:2525
+//	*               mx
+//
+// Transports in Map (from the config file) take precedence over the ones from File.
+type Transports struct {
+	File string
+	Map  map[string]string
+
+	mutex      sync.RWMutex
+	transports map[string]string
+	modTime    time.Time
+}
+
+// CheckTransports checks that all domains have a host:port
+func CheckTransports(transports map[string]string) error {
+	for domain, host := range transports {
+		if err := checkTransport(domain, host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkTransport(domain, host string) error {
+	if strings.TrimSpace(domain) == "" {
+		return fmt.Errorf("transport without domain")
+	}
+	if host == TransportMx {
+		return nil
+	}
+	if _, port, err := net.SplitHostPort(host); err != nil || port == "" {
+		return fmt.Errorf("transport for %s: %q should be host:port", domain, host)
+	}
+	return nil
+}
+
+// Load (re)loads the transports from File if it has changed.
+// The current transports are kept if the file has an error.
+func (t *Transports) Load() error {
+	if t.File == "" {
+		return nil
+	}
+
+	info, err := os.Stat(t.File)
+	if err != nil {
+		return err
+	}
+	t.mutex.RLock()
+	unchanged := t.transports != nil && info.ModTime().Equal(t.modTime)
+	t.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	file, err := os.Open(t.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	transports := make(map[string]string)
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected a domain and a host", t.File, lineNumber)
+		}
+		if err := checkTransport(fields[0], fields[1]); err != nil {
+			return fmt.Errorf("%s:%d: %v", t.File, lineNumber, err)
+		}
+		transports[strings.ToLower(fields[0])] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	t.transports = transports
+	t.modTime = info.ModTime()
+	t.mutex.Unlock()
+
+	return nil
+}
+
+// SetMap replaces Map, it should be checked with CheckTransports
+func (t *Transports) SetMap(transports map[string]string) {
+	t.mutex.Lock()
+	t.Map = transports
+	t.mutex.Unlock()
+}
+
+// Len returns the number of transports
+func (t *Transports) Len() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return len(t.Map) + len(t.transports)
+}
+
+// Lookup returns the host to which mail for the domain is relayed, and whether there is one.
+// The most specific match wins: the domain, its parent domains, and then *.
+func (t *Transports) Lookup(domain string) (string, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	keys := []string{domain}
+	for rest := domain; strings.Contains(rest, "."); {
+		rest = rest[strings.IndexByte(rest, '.')+1:]
+		keys = append(keys, "."+rest)
+	}
+	keys = append(keys, "*")
+
+	for _, key := range keys {
+		for d, host := range t.Map {
+			if strings.EqualFold(d, key) {
+				return host, true
+			}
+		}
+		if host, found := t.transports[key]; found {
+			return host, true
+		}
+	}
+	return "", false
+}
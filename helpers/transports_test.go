@@ -0,0 +1,64 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTransports(t *testing.T) {
+
+	Convey("Testing Transports.Load()", t, func() {
+		file, err := ioutil.TempFile("", "transports")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(file.Name())
+		file.WriteString("# comment\n\nExample.com  mx.example.com:25\n.example.org relay.example.org:2525\n")
+		file.Close()
+
+		transports := Transports{File: file.Name()}
+		So(transports.Load(), ShouldEqual, nil)
+		So(transports.Len(), ShouldEqual, 2)
+
+		host, found := transports.Lookup("example.com")
+		So(found, ShouldBeTrue)
+		So(host, ShouldEqual, "mx.example.com:25")
+
+		ioutil.WriteFile(file.Name(), []byte("example.com mx.example.com\n"), 0644)
+		os.Chtimes(file.Name(), transports.modTime.AddDate(0, 0, 1), transports.modTime.AddDate(0, 0, 1))
+		So(transports.Load().Error(), ShouldContainSubstring, ":1: transport for example.com")
+		// the old transports are kept
+		host, _ = transports.Lookup("example.com")
+		So(host, ShouldEqual, "mx.example.com:25")
+	})
+
+	Convey("Testing Transports.Lookup()", t, func() {
+		transports := Transports{
+			Map: map[string]string{
+				"example.com":        "mx.example.com:25",
+				".example.com":       "sub.example.com:25",
+				"deep.a.example.com": "deep.example.com:25",
+			},
+		}
+
+		_, found := transports.Lookup("example.org")
+		So(found, ShouldBeFalse)
+
+		host, _ := transports.Lookup("EXAMPLE.com.")
+		So(host, ShouldEqual, "mx.example.com:25")
+		host, _ = transports.Lookup("b.a.example.com")
+		So(host, ShouldEqual, "sub.example.com:25")
+		host, _ = transports.Lookup("deep.a.example.com")
+		So(host, ShouldEqual, "deep.example.com:25")
+
+		transports.SetMap(map[string]string{"*": "smarthost.example.net:587"})
+		host, _ = transports.Lookup("example.org")
+		So(host, ShouldEqual, "smarthost.example.net:587")
+
+		So(CheckTransports(map[string]string{"example.com": "mx.example.com:25"}), ShouldEqual, nil)
+		So(CheckTransports(map[string]string{"example.com": "mx.example.com"}), ShouldNotEqual, nil)
+		So(CheckTransports(map[string]string{"": "mx.example.com:25"}), ShouldNotEqual, nil)
+	})
+
+}
@@ -0,0 +1,91 @@
+package helpers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// maxVerdicts is the number of verdicts after which expired verdicts are removed from a VerdictCache
+const maxVerdicts = 10000
+
+// VerdictCache remembers the verdict of a content filter for a message body,
+// so identical bulk messages (to many recipients, in separate transactions) are only scanned once.
+// Verdicts expire after TTL seconds, the cache is disabled if TTL is 0.
+type VerdictCache struct {
+	TTL int
+
+	mutex    sync.Mutex
+	verdicts map[string]verdict
+}
+
+type verdict struct {
+	result  string
+	expires time.Time
+}
+
+// Get returns the cached verdict for the body hash and whether it was found
+func (v *VerdictCache) Get(hash string) (string, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	entry, found := v.verdicts[hash]
+	if !found || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.result, true
+}
+
+// Set caches the verdict for the body hash
+func (v *VerdictCache) Set(hash string, result string) {
+	if v.TTL <= 0 {
+		return
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.verdicts == nil {
+		v.verdicts = make(map[string]verdict)
+	}
+	if len(v.verdicts) >= maxVerdicts {
+		now := time.Now()
+		for h, entry := range v.verdicts {
+			if now.After(entry.expires) {
+				delete(v.verdicts, h)
+			}
+		}
+		// Too many messages within the TTL, start over
+		if len(v.verdicts) >= maxVerdicts {
+			v.verdicts = make(map[string]verdict)
+		}
+	}
+	v.verdicts[hash] = verdict{
+		result:  result,
+		expires: time.Now().Add(time.Duration(v.TTL) * time.Second),
+	}
+}
+
+// BodyHash returns a hash of the body of the message (the part after the header),
+// ignoring differences in whitespace and line endings, so copies of a message which only differ
+// in their header fields (Received, To, Message-ID, ...) or in line wrapping have the same hash.
+func BodyHash(data []byte) string {
+	body := data
+	if i := bytes.Index(data, []byte("\r\n\r\n")); i >= 0 {
+		body = data[i+4:]
+	} else if i := bytes.Index(data, []byte("\n\n")); i >= 0 {
+		body = data[i+2:]
+	}
+
+	// runs of whitespace are hashed as a single space
+	hash := sha256.New()
+	for i, word := range bytes.Fields(body) {
+		if i > 0 {
+			hash.Write([]byte(" "))
+		}
+		hash.Write(word)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
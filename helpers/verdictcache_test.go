@@ -0,0 +1,37 @@
+package helpers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerdictCache(t *testing.T) {
+
+	Convey("Testing BodyHash()", t, func() {
+		a := BodyHash([]byte("To: a@example.com\r\n\r\nHello world!\r\nBye"))
+		b := BodyHash([]byte("To: b@example.com\r\nReceived: from x\r\n\r\nHello  world!\nBye\r\n"))
+		c := BodyHash([]byte("To: a@example.com\r\n\r\nHello world?\r\nBye"))
+		So(a, ShouldEqual, b)
+		So(a, ShouldNotEqual, c)
+		So(BodyHash([]byte("Hello world")), ShouldNotEqual, BodyHash([]byte("Helloworld")))
+	})
+
+	Convey("Testing VerdictCache", t, func() {
+		v := VerdictCache{TTL: 60}
+		_, found := v.Get("hash")
+		So(found, ShouldEqual, false)
+
+		v.Set("hash", "Eicar-Test-Signature")
+		result, found := v.Get("hash")
+		So(found, ShouldEqual, true)
+		So(result, ShouldEqual, "Eicar-Test-Signature")
+
+		// disabled cache
+		d := VerdictCache{}
+		d.Set("hash", "")
+		_, found = d.Get("hash")
+		So(found, ShouldEqual, false)
+	})
+
+}
@@ -0,0 +1,113 @@
+package helpers
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// XclientAttributes are the attributes of XCLIENT which are supported, as advertised in the reply to EHLO
+var XclientAttributes = []string{"NAME", "ADDR", "PORT", "PROTO", "HELO", "LOGIN"}
+
+// Xclient is the client an upstream proxy reported with XCLIENT (the Postfix extension),
+// the attributes it didn't report or which were unavailable are empty
+type Xclient struct {
+	// Name is the reverse DNS name of the client
+	Name string
+	Addr net.IP
+	Helo string
+	// Login is the user which authenticated at the proxy
+	Login string
+}
+
+// ParseXclient parses the attributes of an XCLIENT command (e.g. NAME=mx.example.org ADDR=192.0.2.1),
+// keyed by upper case name. The values are xtext, [UNAVAILABLE] and [TEMPUNAVAIL] mean they're unknown.
+func ParseXclient(attributes map[string]string) (Xclient, error) {
+	client := Xclient{}
+	if len(attributes) == 0 {
+		return client, errors.New("no attributes")
+	}
+	for name, value := range attributes {
+		known := false
+		for _, attribute := range XclientAttributes {
+			known = known || name == attribute
+		}
+		if !known {
+			return client, errors.New("unknown attribute " + name)
+		}
+		value, err := decodeXtext(value)
+		if err != nil {
+			return client, errors.New("bad value of " + name)
+		}
+		if value == "[UNAVAILABLE]" || value == "[TEMPUNAVAIL]" {
+			continue
+		}
+		switch name {
+		case "NAME":
+			client.Name = value
+		case "ADDR":
+			if client.Addr = ParseIp(value); client.Addr == nil {
+				return client, errors.New("bad address " + value)
+			}
+		case "HELO":
+			client.Helo = value
+		case "LOGIN":
+			client.Login = value
+		}
+	}
+	return client, nil
+}
+
+// decodeXtext decodes the +XX hex escapes of xtext (RFC 3461 section 4)
+func decodeXtext(s string) (string, error) {
+	if !isXtext(s) {
+		return "", errors.New("invalid xtext " + s)
+	}
+	decoded := []byte{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '+' {
+			decoded = append(decoded, s[i])
+			continue
+		}
+		c, _ := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		decoded = append(decoded, byte(c))
+		i += 2
+	}
+	return string(decoded), nil
+}
+
+// XclientSessions keeps the clients which were reported with XCLIENT, keyed by session ID,
+// for the handlers which use more than the address and HELO of the client
+type XclientSessions struct {
+	mutex   sync.Mutex
+	clients map[string]Xclient
+}
+
+// Set records the client of the session
+func (x *XclientSessions) Set(sessionId string, client Xclient) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.clients == nil {
+		x.clients = make(map[string]Xclient)
+	}
+	x.clients[sessionId] = client
+}
+
+// Get returns the client of the session, if a proxy reported it
+func (x *XclientSessions) Get(sessionId string) (Xclient, bool) {
+	if x == nil {
+		return Xclient{}, false
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	client, found := x.clients[sessionId]
+	return client, found
+}
+
+// Forget removes the client when the session ends
+func (x *XclientSessions) Forget(sessionId string) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	delete(x.clients, sessionId)
+}
@@ -0,0 +1,51 @@
+package helpers
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestXclient(t *testing.T) {
+
+	Convey("Testing ParseXclient", t, func() {
+		client, err := ParseXclient(map[string]string{"NAME": "mx.example.org", "ADDR": "IPV6:2001:db8::1", "HELO": "mx.example.org", "LOGIN": "bob+40example.com", "PROTO": "ESMTP"})
+		So(err, ShouldEqual, nil)
+		So(client.Name, ShouldEqual, "mx.example.org")
+		So(client.Addr.Equal(net.ParseIP("2001:db8::1")), ShouldEqual, true)
+		So(client.Helo, ShouldEqual, "mx.example.org")
+		So(client.Login, ShouldEqual, "bob@example.com")
+
+		client, err = ParseXclient(map[string]string{"NAME": "[UNAVAILABLE]", "ADDR": "192.0.2.1"})
+		So(err, ShouldEqual, nil)
+		So(client, ShouldResemble, Xclient{Addr: net.ParseIP("192.0.2.1").To4()})
+
+		_, err = ParseXclient(map[string]string{"ADDR": "mx.example.org"})
+		So(err, ShouldNotEqual, nil)
+		_, err = ParseXclient(map[string]string{"DESTADDR": "192.0.2.2"})
+		So(err, ShouldNotEqual, nil)
+		_, err = ParseXclient(map[string]string{"LOGIN": "bob+4"})
+		So(err, ShouldNotEqual, nil)
+		_, err = ParseXclient(map[string]string{})
+		So(err, ShouldNotEqual, nil)
+	})
+
+	Convey("Testing XclientSessions", t, func() {
+		x := &XclientSessions{}
+		_, found := x.Get("1")
+		So(found, ShouldEqual, false)
+		x.Set("1", Xclient{Name: "mx.example.org"})
+		client, found := x.Get("1")
+		So(found, ShouldEqual, true)
+		So(client.Name, ShouldEqual, "mx.example.org")
+		x.Forget("1")
+		_, found = x.Get("1")
+		So(found, ShouldEqual, false)
+
+		// the handlers work without them
+		_, found = (*XclientSessions)(nil).Get("1")
+		So(found, ShouldEqual, false)
+	})
+
+}
@@ -40,6 +40,12 @@ func SetLevel(level Level) {
 	logrus.SetLevel(logrus.Level(level))
 }
 
+// ParseLevel parses a level name (debug, info, warn, error, ...)
+func ParseLevel(name string) (Level, error) {
+	level, err := logrus.ParseLevel(name)
+	return Level(level), err
+}
+
 func Printf(format string, v ...interface{}) {
 	logrus.Printf(format, v...)
 }
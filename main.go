@@ -1,20 +1,54 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/gopistolet/gopistolet/admin"
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/control"
 	"github.com/gopistolet/gopistolet/handlers"
+	"github.com/gopistolet/gopistolet/handlers/queue"
+	"github.com/gopistolet/gopistolet/handlers/tlsrpt"
 	"github.com/gopistolet/gopistolet/helpers"
 	"github.com/gopistolet/gopistolet/log"
 	"github.com/gopistolet/smtp/mta"
 )
 
-var c mta.Config
+var c config.Config
+
+var configFile = flag.String("config", "config.json", "config file (JSON, YAML or TOML) ($GOPISTOLET_CONFIG)")
+
+var dkimKeygen = flag.String("dkim-keygen", "", "generate a DKIM key for the domain, print its DNS record and exit")
 
 func main() {
 
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
+	}
+
+	for _, override := range config.Overrides {
+		flag.String(override.Flag, "", override.Usage+" ($"+override.Env()+")")
+	}
+	flag.Parse()
+	flags := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	if _, ok := flags["config"]; !ok && os.Getenv("GOPISTOLET_CONFIG") != "" {
+		*configFile = os.Getenv("GOPISTOLET_CONFIG")
+	}
+
 	log.Timestamp()
 	log.SetLevel(log.DebugLevel)
 
@@ -29,25 +63,397 @@ func main() {
 	log.Println("GoPistolet at your service!")
 
 	// Default config
-	c = mta.Config{
-		Hostname:  "localhost",
-		Port:      25,
-		Blacklist: nixspamBlacklist,
+	c = config.Config{
+		Config: mta.Config{
+			Hostname: "localhost",
+			Port:     25,
+		},
 	}
 
-	// Load config from JSON file
-	err = helpers.DecodeFile("config.json", &c)
-	if err != nil {
+	// Load config from the config file, the environment variables and flags override it
+	err = config.Load(*configFile, &c)
+	if errors.Is(err, os.ErrNotExist) {
 		log.Warnln(err, "- Using default configuration instead.")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if err := config.ApplyOverrides(&c, os.Getenv, flags); err != nil {
+		log.Fatal(err)
+	}
+	if c.LogLevel != "" {
+		level, _ := log.ParseLevel(c.LogLevel)
+		log.SetLevel(level)
+	}
+
+	// Generate a DKIM key (the active one if the domain has none yet, the next one otherwise),
+	// with Dkim.DualSign an ed25519 key as well
+	if *dkimKeygen != "" {
+		if c.Dkim.Dir == "" {
+			log.Fatal("Dkim.Dir isn't configured")
+		}
+		keys, err := c.Dkim.GenerateAll(*dkimKeygen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, key := range keys {
+			zone, err := key.Zone()
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(zone)
+		}
+		return
+	}
+
+	// Count the rate limits with the other frontends of the cluster
+	if err := c.SharedState.Open(); err != nil {
+		log.Errorln("Shared state:", err)
+	}
+	if c.SharedState.State != nil {
+		c.RateLimits.Share(c.SharedState.State)
+		c.AuthLockout.Share(c.SharedState.State)
+		c.Callout.Domains.Share(c.SharedState.State, "callout:domains:")
+		c.Callout.Total.Share(c.SharedState.State, "callout:total:")
+	}
+
+	// Combine the available blacklists
+	// (the sessions refuse clients themselves with Backpressure and Shedding, which answer 421)
+	c.Shedding.Reputation = &c.Reputation
+	blacklists := helpers.Blacklists{&c.RateLimits}
+	if nixspamBlacklist != nil {
+		blacklists = append(blacklists, nixspamBlacklist)
+	}
+	c.Dnsbl.Health = &c.DnsHealth
+	if len(c.Dnsbl.Lists) > 0 {
+		blacklists = append(blacklists, &c.Dnsbl)
+	}
+
+	// Start the plugins, they get the events and can refuse clients
+	if len(c.Plugins) > 0 {
+		c.Plugins.Start(c.Hostname, &c.Events)
+		defer c.Plugins.Stop()
+		blacklists = append(blacklists, c.Plugins)
+	}
+	c.Blacklist = c.Events.Blacklist(c.Access.Blacklist(blacklists))
+
+	// The stored messages can't be read without the key
+	if c.Encryption.KeyFile != "" {
+		if err := c.Encryption.Load(); err != nil {
+			log.Fatal("Couldn't load the encryption key: ", err)
+		}
+	}
+
+	// Open the store of the queue
+	if c.Queue.Dir == "" {
+		c.Queue.Dir = "mailstore"
+	}
+	if err := c.Queue.Open(); err != nil {
+		log.Fatal("Couldn't open the queue: ", err)
+	}
+	defer c.Queue.Store.Close()
+	// Relay what was queued before a crash, and drop the partial writes
+	recovery, err := c.Queue.Store.Recover()
+	if err != nil {
+		log.Fatal("Couldn't recover the queue: ", err)
+	}
+	log.Printf("Queue: %d messages to relay, %d partial writes discarded", recovery.Resumed, recovery.Discarded)
+
+	// Watch the free disk space, the sessions refuse MAIL while it's low
+	if c.DiskWatchdog.MinFreeMB > 0 {
+		if len(c.DiskWatchdog.Paths) == 0 {
+			c.DiskWatchdog.Paths = []string{c.Queue.Dir, "."}
+		}
+		c.DiskWatchdog.OnLow = func(path string, freeMB uint64) {
+			alertPostmaster("Low disk space", fmt.Sprintf("Only %d MB are free on the volume of %s, new mail is refused until there's enough space again.", freeMB, path))
+		}
+		c.DiskWatchdog.OnRecover = func() {
+			alertPostmaster("Disk space recovered", "New mail is accepted again.")
+		}
+		c.DiskWatchdog.Start()
+		defer c.DiskWatchdog.Stop()
 	}
 
-	mta := mta.NewDefault(c, handlers.LoadHandlers(&c))
+	// Refuse new connections while overloaded
+	c.Backpressure.QueueDepth = c.Queue.Store.Len
+	c.Backpressure.Start()
+	defer c.Backpressure.Stop()
+
+	// Forget the clients which didn't send mail for a while
+	go func() {
+		for range time.Tick(time.Minute) {
+			c.RateLimits.Cleanup()
+			c.AuthLockout.Cleanup()
+			c.Callout.Domains.Cleanup()
+			c.Reputation.Cleanup()
+			c.Dnsbl.Cleanup()
+			c.Dsn.Cleanup()
+			c.Users.Cleanup()
+		}
+	}()
+
+	// Send the TLS reports of the outbound deliveries (they wait while the disk is running out of space)
+	go func() {
+		for range time.Tick(24 * time.Hour) {
+			if !c.DiskWatchdog.Low() {
+				tlsrpt.SendReports(&c)
+			}
+		}
+	}()
+
+	c.Reload.Record(config.ReloadConfig, *configFile, 0, nil)
+	c.Reload.Record(config.ReloadAccessRules, *configFile, len(c.AccessRules), nil)
+	loadTables()
+
+	if err := c.Users.Open(); err != nil {
+		log.Errorln("Couldn't open the user store:", err)
+	}
+	// Reload the users when the file changes
+	if c.Users.File != "" && (c.Users.Backend == "" || c.Users.Backend == config.UsersFile) {
+		if err := c.Users.Load(); err != nil {
+			log.Errorln("Couldn't load users:", err)
+		}
+		c.Users.Start()
+		defer c.Users.Stop()
+	}
+	if c.ClientCerts.CAFile != "" {
+		if err := c.ClientCerts.Load(); err != nil {
+			log.Errorln("Couldn't load the CAs of the client certificates:", err)
+		}
+	}
+
+	// Generate and rotate the DKIM keys
+	c.Dkim.Start()
+	defer c.Dkim.Stop()
+
+	// Refresh the public suffix list
+	c.PublicSuffixList.Start()
+	defer c.PublicSuffixList.Stop()
+
+	// Queue statistics
+	snapshots := &queue.Snapshots{Store: c.Queue.Store, Interval: c.Queue.SnapshotInterval}
+	snapshots.Start()
+	defer snapshots.Stop()
+
+	// Relay the queued mail for remote recipients when the link is up
+	forward := queue.NewForward(&c)
+	forward.Start()
+	defer forward.Stop()
+
+	// Profiling endpoints and queue statistics
+	admin := admin.New(&c)
+	admin.Queue = snapshots
+	admin.Panics = func() map[string]uint64 {
+		return map[string]uint64{"handlers": handlers.Panics(), "sessions": atomic.LoadUint64(&sessionPanics)}
+	}
+	admin.Start()
+	defer admin.Stop()
+
+	// The sockets from systemd socket activation replace the addresses of the listeners on their ports
+	sockets, err := helpers.SdListeners()
+	if err != nil {
+		log.Errorln("Couldn't use the sockets from systemd:", err)
+	}
+
+	// One server per address of a listener, they share the handlers
+	// (their lookups are canceled when the server shuts down)
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := handlers.LoadHandlers(&c)
+	handler.Context = ctx
+	var texts *helpers.Catalog
+	if c.Catalog.Customized("smtp.") {
+		texts = &c.Catalog
+	}
+	// Local programs (e.g. gopistolet-sendmail) inject messages on the control socket
+	control := control.New(&c)
+	control.Chain = handler
+	if err := control.Start(); err != nil {
+		log.Errorln("Control socket:", err)
+	}
+	defer control.Stop()
+
+	servers := []smtpServer{}
+	for _, listener := range c.AllListeners() {
+		mtaConfig := c.Config
+		mtaConfig.Ip = listener.Ip
+		mtaConfig.Port = listener.Port
+		switch listener.Role {
+		case config.RoleSubmission, config.RoleSubmissions:
+			// clients connect from dynamic IPs which are in the blocklists,
+			// but they must be authenticated (see sessionServer), unlike the clients of the MTA
+			mtaConfig.Blacklist = c.Events.Blacklist(c.Access.Blacklist(&c.RateLimits))
+		}
+		newServer := func(address string) *sessionServer {
+			server := newSessionServer(&c, mtaConfig, listener.Network(), address, handler, texts)
+			server.submission = listener.Role == config.RoleSubmission || listener.Role == config.RoleSubmissions
+			server.implicitTls = listener.Role == config.RoleSubmissions
+			servers = append(servers, server)
+			return server
+		}
+		if activated := takeSockets(sockets, listener.Port); len(activated) > 0 {
+			for _, socket := range activated {
+				newServer(socket.Addr().String()).listener = socket
+			}
+			continue
+		}
+		addresses, err := listener.Addresses()
+		if err != nil {
+			log.Errorf("Listener on port %d: %v", listener.Port, err)
+			continue
+		}
+		for _, address := range addresses {
+			newServer(address)
+		}
+	}
+	for name, socket := range sockets {
+		log.Errorf("The socket %s from systemd isn't on the port of a listener", name)
+		socket.Close()
+	}
 	go func() {
 		<-sigc
-		mta.Stop()
+		helpers.SdNotify("STOPPING=1")
+		for _, server := range servers {
+			server.Stop()
+		}
+		cancel()
+	}()
+
+	// Reload the config on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload()
+		}
 	}()
-	err = mta.ListenAndServe()
+
+	// Reload the config and the tables when their files change
+	if c.Reload.Interval > 0 {
+		go watchTables(time.Duration(c.Reload.Interval) * time.Second)
+	}
+
+	wg := sync.WaitGroup{}
+	for _, server := range servers {
+		if err := server.Listen(); err != nil {
+			log.Errorln(err)
+			continue
+		}
+		wg.Add(1)
+		go func(server smtpServer) {
+			defer wg.Done()
+			if err := server.Serve(); err != nil {
+				log.Errorln(err)
+			}
+		}(server)
+	}
+
+	// Tell systemd that the servers are bound, and keep its watchdog happy
+	helpers.SdNotify("READY=1")
+	stopWatchdog := make(chan struct{})
+	helpers.StartSdWatchdog(stopWatchdog)
+
+	wg.Wait()
+	close(stopWatchdog)
+}
+
+// reloading serializes the reloads on SIGHUP and the ones of watchTables
+var reloading sync.Mutex
+
+// reload reloads the parts of the config which can be changed at runtime.
+// Tables with errors don't replace the ones in use, the outcome is kept for the admin API.
+func reload() {
+	helpers.SdNotify("RELOADING=1")
+	defer helpers.SdNotify("READY=1")
+	reloading.Lock()
+	defer reloading.Unlock()
+
+	newConfig := config.Config{Config: c.Config}
+	err := config.Load(*configFile, &newConfig)
+	c.Reload.Record(config.ReloadConfig, *configFile, 0, err)
 	if err != nil {
-		log.Errorln(err)
+		log.Errorln(err, "- Keeping the current configuration.")
+		return
+	}
+
+	c.Access.Set(&newConfig.Access)
+	c.SetAccessRules(newConfig.AccessRules)
+	c.Reload.Record(config.ReloadAccessRules, *configFile, len(newConfig.AccessRules), nil)
+	c.Aliases.SetMap(newConfig.Aliases.Map)
+	c.Forward.Transports.SetMap(newConfig.Forward.Transports.Map)
+	loadTables()
+
+	if err := c.Users.Load(); err != nil {
+		log.Errorln("Couldn't reload users:", err, "- Keeping the current users.")
+	}
+	c.Users.Invalidate()
+
+	log.Println("Reloaded configuration")
+}
+
+// alertPostmaster mails a notice about the state of the server to the postmaster, if there's one
+func alertPostmaster(subject, text string) {
+	if c.Postmaster == "" {
+		return
+	}
+	data := fmt.Sprintf("From: <MAILER-DAEMON@%s>\r\nTo: <%s>\r\nSubject: %s: %s\r\nDate: %s\r\nMessage-ID: <%s@%s>\r\nAuto-Submitted: auto-generated\r\n\r\n%s\r\n",
+		c.Hostname, c.Postmaster, c.Hostname, subject, time.Now().Format(time.RFC1123Z), helpers.NewId(), c.Hostname, text)
+	if err := queue.Send(&c, "", c.Postmaster, []byte(data)); err != nil {
+		log.Errorf("Couldn't alert the postmaster: %v", err)
+	}
+}
+
+// takeSockets removes the sockets on the port from the sockets of systemd socket activation and returns them
+func takeSockets(sockets map[string]net.Listener, port uint32) []net.Listener {
+	taken := []net.Listener{}
+	for name, socket := range sockets {
+		if addr, ok := socket.Addr().(*net.TCPAddr); ok && uint32(addr.Port) == port {
+			taken = append(taken, socket)
+			delete(sockets, name)
+		}
+	}
+	return taken
+}
+
+// loadTables (re)loads the alias and transport files if they changed
+func loadTables() {
+	// a broken file is only logged once
+	err := c.Aliases.Load()
+	if c.Reload.Record(config.ReloadAliases, tableSource(c.Aliases.File), c.Aliases.Len(), err) && err != nil {
+		log.Errorln("Couldn't load aliases:", err, "- Keeping the current aliases.")
+	}
+	err = c.Forward.Transports.Load()
+	if c.Reload.Record(config.ReloadTransports, tableSource(c.Forward.Transports.File), c.Forward.Transports.Len(), err) && err != nil {
+		log.Errorln("Couldn't load transports:", err, "- Keeping the current transports.")
+	}
+}
+
+// tableSource is the file of a table, or the config file if the table only is in the config
+func tableSource(file string) string {
+	if file == "" {
+		return *configFile
+	}
+	return file
+}
+
+// watchTables reloads the config when the config file changes, and the tables when their files change
+func watchTables(interval time.Duration) {
+	modTime := func() time.Time {
+		info, err := os.Stat(*configFile)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+
+	last := modTime()
+	for range time.Tick(interval) {
+		if current := modTime(); !current.Equal(last) {
+			last = current
+			log.Println("Config file changed, reloading")
+			reload()
+			continue
+		}
+		reloading.Lock()
+		loadTables()
+		reloading.Unlock()
 	}
 }
@@ -0,0 +1,416 @@
+// Package plugin runs out-of-process plugins, so GoPistolet can be extended without recompiling it.
+//
+// A plugin is a program which reads requests from stdin and writes replies to stdout, one JSON object per line.
+// What it writes to stderr is logged. GoPistolet starts with a hello request, with which the plugin registers
+// for the phases and the events it wants:
+//
+//	> {"Type":"hello","Id":1,"Version":1,"Hostname":"mx.example.com"}
+//	< {"Id":1,"Phases":["connect","data"],"Events":["MessageQueued"]}
+//
+// In the connect phase the plugin can refuse a client, in the data phase it can drop a message, add header fields
+// or change its recipients (Data is the message in base64). A reply without Action continues.
+//
+//	> {"Type":"connect","Id":2,"Ip":"192.0.2.1"}
+//	< {"Id":2,"Action":"reject","Message":"listed in our blocklist"}
+//	> {"Type":"data","Id":3,"Message":{"SessionId":"...","Ip":"192.0.2.1","Helo":"...","From":"...","To":["..."],"Data":"..."}}
+//	< {"Id":3,"AddHeaders":["X-Checked: yes"],"Recipients":["bob@example.com"]}
+//
+// Events aren't answered:
+//
+//	> {"Type":"event","Event":"MessageQueued","Data":{"SessionId":"...","From":"...","To":["..."],"File":"..."}}
+//
+// Replies may come in any order, they're matched by Id. A plugin which doesn't answer within the Timeout,
+// or which crashed, doesn't stop the mail: the phase continues as if the plugin wasn't there.
+// A crashed plugin is restarted on the next request, at most once per RestartDelay.
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/events"
+	"github.com/gopistolet/gopistolet/log"
+)
+
+// Version of the protocol
+const Version = 1
+
+// Phases in which plugins are called
+const (
+	PhaseConnect = "connect"
+	PhaseData    = "data"
+)
+
+// Actions of the replies
+const (
+	ActionContinue = "continue"
+	ActionReject   = "reject"
+	ActionDrop     = "drop"
+)
+
+// RestartDelay is the minimum time between two starts of a plugin
+var RestartDelay = 10 * time.Second
+
+// ErrNotRunning is returned for requests to a plugin which isn't running
+var ErrNotRunning = errors.New("plugin isn't running")
+
+// Request is a request to a plugin
+type Request struct {
+	Type     string
+	Id       uint64   `json:",omitempty"`
+	Version  int      `json:",omitempty"`
+	Hostname string   `json:",omitempty"`
+	Ip       string   `json:",omitempty"`
+	Message  *Message `json:",omitempty"`
+	// Event is the name of the event in Data
+	Event string       `json:",omitempty"`
+	Data  events.Event `json:",omitempty"`
+}
+
+// Message is the message of the data phase
+type Message struct {
+	SessionId string
+	Ip        string
+	Helo      string
+	From      string
+	To        []string
+	Data      []byte
+}
+
+// Reply is the reply of a plugin
+type Reply struct {
+	Id uint64
+	// Phases and Events the plugin registers for (hello)
+	Phases []string `json:",omitempty"`
+	Events []string `json:",omitempty"`
+	// Action is continue (the default), reject or drop, Message is the reason
+	Action  string `json:",omitempty"`
+	Message string `json:",omitempty"`
+	// AddHeaders are header fields which are added to the message (data)
+	AddHeaders []string `json:",omitempty"`
+	// Recipients replace the recipients of the message if they aren't nil (data)
+	Recipients []string `json:",omitempty"`
+}
+
+// Plugin is a plugin process
+type Plugin struct {
+	// Name in the logs
+	Name string
+	// Command and its arguments
+	Command []string
+	// Seconds a plugin gets to answer a request (0 means 5)
+	Timeout int
+
+	// Hostname which is sent in the hello request
+	hostname string
+
+	mutex    sync.Mutex
+	running  bool
+	started  time.Time
+	stdin    io.WriteCloser
+	cmd      *exec.Cmd
+	done     chan struct{}
+	nextId   uint64
+	pending  map[uint64]chan Reply
+	phases   map[string]bool
+	events   map[string]bool
+	eventsC  chan Request
+	stopping bool
+}
+
+func (p *Plugin) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(p.Timeout) * time.Second
+}
+
+// Start starts the plugin and registers its phases and events
+func (p *Plugin) Start(hostname string) error {
+	p.mutex.Lock()
+	p.hostname = hostname
+	p.stopping = false
+	p.mutex.Unlock()
+	return p.start()
+}
+
+func (p *Plugin) start() error {
+	p.mutex.Lock()
+	if p.running || p.stopping || len(p.Command) == 0 {
+		p.mutex.Unlock()
+		return nil
+	}
+	if !p.started.IsZero() && time.Since(p.started) < RestartDelay {
+		p.mutex.Unlock()
+		return ErrNotRunning
+	}
+	p.started = time.Now()
+
+	cmd := exec.Command(p.Command[0], p.Command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		p.mutex.Unlock()
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		p.mutex.Unlock()
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		p.mutex.Unlock()
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		p.mutex.Unlock()
+		return err
+	}
+	p.cmd, p.stdin, p.running = cmd, stdin, true
+	p.done = make(chan struct{})
+	p.pending = make(map[uint64]chan Reply)
+	p.eventsC = make(chan Request, 100)
+	p.mutex.Unlock()
+
+	go p.read(stdout)
+	go p.logStderr(stderr)
+	go p.sendEvents(p.eventsC)
+	go func() {
+		err := cmd.Wait()
+		p.exited(cmd, err)
+	}()
+
+	reply, err := p.call(Request{Type: "hello", Version: Version, Hostname: p.hostname})
+	if err != nil {
+		p.kill(cmd)
+		return fmt.Errorf("no reply to hello: %v", err)
+	}
+	p.mutex.Lock()
+	p.phases, p.events = set(reply.Phases), set(reply.Events)
+	p.mutex.Unlock()
+	log.WithFields(log.Fields{"Plugin": p.Name}).Infof("Plugin started, phases %v, events %v", reply.Phases, reply.Events)
+	return nil
+}
+
+func set(list []string) map[string]bool {
+	s := make(map[string]bool, len(list))
+	for _, item := range list {
+		s[item] = true
+	}
+	return s
+}
+
+// read passes the replies to the waiting requests
+func (p *Plugin) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		reply := Reply{}
+		if err := json.Unmarshal(scanner.Bytes(), &reply); err != nil {
+			log.WithFields(log.Fields{"Plugin": p.Name}).Warnf("Plugin sent invalid reply: %v", err)
+			continue
+		}
+		p.mutex.Lock()
+		waiting, found := p.pending[reply.Id]
+		delete(p.pending, reply.Id)
+		p.mutex.Unlock()
+		if found {
+			waiting <- reply
+		}
+	}
+}
+
+func (p *Plugin) logStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		log.WithFields(log.Fields{"Plugin": p.Name}).Info(scanner.Text())
+	}
+}
+
+// exited cleans up after the process ended, the requests which are waiting fail
+func (p *Plugin) exited(cmd *exec.Cmd, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.cmd != cmd {
+		return
+	}
+	if !p.stopping {
+		log.WithFields(log.Fields{"Plugin": p.Name}).Errorf("Plugin exited: %v", err)
+	}
+	p.running = false
+	close(p.done)
+	for id, waiting := range p.pending {
+		close(waiting)
+		delete(p.pending, id)
+	}
+	close(p.eventsC)
+}
+
+func (p *Plugin) kill(cmd *exec.Cmd) {
+	p.mutex.Lock()
+	p.stdin.Close()
+	p.mutex.Unlock()
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// Stop ends the plugin: its stdin is closed, and it's killed if it doesn't exit within its timeout
+func (p *Plugin) Stop() {
+	p.mutex.Lock()
+	p.stopping = true
+	if !p.running {
+		p.mutex.Unlock()
+		return
+	}
+	cmd, done := p.cmd, p.done
+	p.stdin.Close()
+	p.mutex.Unlock()
+
+	go func() {
+		select {
+		case <-done:
+		case <-time.After(p.timeout()):
+			cmd.Process.Kill()
+		}
+	}()
+}
+
+// write sends a request to the plugin
+func (p *Plugin) write(request Request) error {
+	line, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.running {
+		return ErrNotRunning
+	}
+	_, err = p.stdin.Write(append(line, '\n'))
+	return err
+}
+
+// call sends a request and waits for the reply
+func (p *Plugin) call(request Request) (Reply, error) {
+	p.mutex.Lock()
+	if !p.running {
+		p.mutex.Unlock()
+		return Reply{}, ErrNotRunning
+	}
+	p.nextId++
+	request.Id = p.nextId
+	waiting := make(chan Reply, 1)
+	p.pending[request.Id] = waiting
+	p.mutex.Unlock()
+
+	if err := p.write(request); err != nil {
+		p.forget(request.Id)
+		return Reply{}, err
+	}
+	select {
+	case reply, ok := <-waiting:
+		if !ok {
+			return Reply{}, ErrNotRunning
+		}
+		return reply, nil
+	case <-time.After(p.timeout()):
+		p.forget(request.Id)
+		return Reply{}, fmt.Errorf("no reply within %v", p.timeout())
+	}
+}
+
+func (p *Plugin) forget(id uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.pending, id)
+}
+
+// Call sends the request of a phase to the plugin, if it registered for the phase.
+// It's restarted first if it crashed. The reply is nil if the plugin didn't register for the phase
+// or didn't answer.
+func (p *Plugin) Call(phase string, request Request) *Reply {
+	if err := p.start(); err != nil {
+		return nil
+	}
+	p.mutex.Lock()
+	registered := p.phases[phase]
+	p.mutex.Unlock()
+	if !registered {
+		return nil
+	}
+
+	request.Type = phase
+	reply, err := p.call(request)
+	if err != nil {
+		log.WithFields(log.Fields{"Plugin": p.Name}).Warnf("Plugin didn't answer the %s request: %v", phase, err)
+		return nil
+	}
+	reply.Action = strings.ToLower(reply.Action)
+	return &reply
+}
+
+// Notify passes the event to the plugin if it registered for it, it's meant to be subscribed to the event bus.
+// The events are sent in the background; if the plugin can't keep up, they're dropped.
+func (p *Plugin) Notify(event events.Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.running || !p.events[event.Name()] {
+		return
+	}
+	select {
+	case p.eventsC <- Request{Type: "event", Event: event.Name(), Data: event}:
+	default:
+		log.WithFields(log.Fields{"Plugin": p.Name}).Warnf("Plugin can't keep up, dropped %s event", event.Name())
+	}
+}
+
+func (p *Plugin) sendEvents(requests chan Request) {
+	for request := range requests {
+		if err := p.write(request); err != nil {
+			log.WithFields(log.Fields{"Plugin": p.Name}).Warnf("Couldn't send %s event: %v", request.Event, err)
+		}
+	}
+}
+
+// Plugins are the configured plugins
+type Plugins []*Plugin
+
+// Start starts the plugins, and subscribes them to the events of the bus
+func (plugins Plugins) Start(hostname string, bus *events.Bus) {
+	for _, p := range plugins {
+		if err := p.Start(hostname); err != nil {
+			log.WithFields(log.Fields{"Plugin": p.Name}).Errorf("Couldn't start plugin: %v", err)
+		}
+		bus.Subscribe(p.Notify)
+	}
+}
+
+// Stop stops the plugins
+func (plugins Plugins) Stop() {
+	for _, p := range plugins {
+		p.Stop()
+	}
+}
+
+// CheckIp calls the plugins in the connect phase, it refuses the client if one of them rejects it
+func (plugins Plugins) CheckIp(ip string) bool {
+	for _, p := range plugins {
+		reply := p.Call(PhaseConnect, Request{Ip: ip})
+		if reply != nil && (reply.Action == ActionReject || reply.Action == ActionDrop) {
+			log.WithFields(log.Fields{
+				"Ip":     ip,
+				"Plugin": p.Name,
+			}).Warnf("Plugin refused the client: %s", reply.Message)
+			return true
+		}
+	}
+	return false
+}
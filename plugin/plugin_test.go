@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopistolet/gopistolet/events"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestMain runs the test binary as plugin if GOPISTOLET_TEST_PLUGIN is set
+func TestMain(m *testing.M) {
+	if os.Getenv("GOPISTOLET_TEST_PLUGIN") != "" {
+		RunTestPlugin()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// RunTestPlugin is a plugin which refuses 192.0.2.66, crashes for 192.0.2.13, doesn't answer 192.0.2.42
+// and tells the last event to 192.0.2.99. It tags the messages and drops the ones with DROP in them.
+func RunTestPlugin() {
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	encoder := json.NewEncoder(os.Stdout)
+	lastEvent := ""
+	for scanner.Scan() {
+		request := struct {
+			Request
+			Data json.RawMessage
+		}{}
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			os.Exit(2)
+		}
+		reply := Reply{Id: request.Id}
+		switch request.Type {
+		case "hello":
+			reply.Phases = []string{PhaseConnect, PhaseData}
+			reply.Events = []string{"MessageQueued"}
+		case PhaseConnect:
+			switch request.Ip {
+			case "192.0.2.66":
+				reply.Action, reply.Message = "REJECT", "go away"
+			case "192.0.2.13":
+				os.Exit(1)
+			case "192.0.2.42":
+				continue
+			case "192.0.2.99":
+				reply.Message = lastEvent
+			}
+		case PhaseData:
+			if strings.Contains(string(request.Message.Data), "DROP") {
+				reply.Action = ActionDrop
+				break
+			}
+			reply.AddHeaders = []string{"X-Plugin: " + request.Message.From}
+			if request.Message.From == "redirect@example.com" {
+				reply.Recipients = []string{"bob@example.com", "not an address"}
+			}
+		case "event":
+			lastEvent = request.Event + " " + string(request.Data)
+			os.Stderr.WriteString("got " + request.Event + "\n")
+			continue
+		}
+		encoder.Encode(reply)
+	}
+}
+
+// testPlugin returns a plugin which runs the test binary
+func testPlugin() *Plugin {
+	os.Setenv("GOPISTOLET_TEST_PLUGIN", "1")
+	return &Plugin{Name: "test", Command: []string{os.Args[0]}, Timeout: 1}
+}
+
+func TestPlugin(t *testing.T) {
+
+	Convey("Testing phases", t, func() {
+		p := testPlugin()
+		So(p.Start("mx.example.com"), ShouldEqual, nil)
+		defer p.Stop()
+		plugins := Plugins{p}
+
+		So(plugins.CheckIp("192.0.2.1"), ShouldBeFalse)
+		So(plugins.CheckIp("192.0.2.66"), ShouldBeTrue)
+
+		reply := p.Call(PhaseData, Request{Message: &Message{From: "alice@example.com", Data: []byte("Subject: hi\r\n\r\nhi\r\n")}})
+		So(reply, ShouldNotBeNil)
+		So(reply.AddHeaders, ShouldResemble, []string{"X-Plugin: alice@example.com"})
+
+		// not registered
+		So(p.Call("rcpt", Request{}), ShouldBeNil)
+	})
+
+	Convey("Testing events", t, func() {
+		p := testPlugin()
+		So(p.Start("mx.example.com"), ShouldEqual, nil)
+		defer p.Stop()
+
+		bus := &events.Bus{}
+		bus.Subscribe(p.Notify)
+		bus.Publish(events.ConnectionOpened{Ip: "192.0.2.1"})
+		bus.Publish(events.MessageQueued{SessionId: "1", File: "queued"})
+
+		// the events are sent in the background
+		message := ""
+		for i := 0; i < 100 && message == ""; i++ {
+			time.Sleep(10 * time.Millisecond)
+			message = p.Call(PhaseConnect, Request{Ip: "192.0.2.99"}).Message
+		}
+		So(message, ShouldStartWith, "MessageQueued {")
+		So(message, ShouldContainSubstring, `"File":"queued"`)
+	})
+
+	Convey("Testing a plugin which doesn't answer or crashes", t, func() {
+		restartDelay := RestartDelay
+		defer func() { RestartDelay = restartDelay }()
+		RestartDelay = 0
+
+		p := testPlugin()
+		So(p.Start("mx.example.com"), ShouldEqual, nil)
+		defer p.Stop()
+
+		start := time.Now()
+		So(p.Call(PhaseConnect, Request{Ip: "192.0.2.42"}), ShouldBeNil)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, time.Second)
+
+		So(p.Call(PhaseConnect, Request{Ip: "192.0.2.13"}), ShouldBeNil)
+
+		// it's restarted
+		So(p.Call(PhaseConnect, Request{Ip: "192.0.2.66"}), ShouldNotBeNil)
+	})
+
+	Convey("Testing a command which isn't a plugin", t, func() {
+		p := &Plugin{Name: "true", Command: []string{"true"}, Timeout: 1}
+		So(p.Start("mx.example.com"), ShouldNotEqual, nil)
+		So(p.Call(PhaseConnect, Request{Ip: "192.0.2.1"}), ShouldBeNil)
+	})
+
+}
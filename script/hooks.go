@@ -0,0 +1,198 @@
+package script
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"go.starlark.net/starlark"
+)
+
+// Decision points at which the scripts run
+const (
+	HookRcpt    = "rcpt"
+	HookHeaders = "headers"
+	HookRoute   = "route"
+)
+
+// common are the variables and functions of every script (see Run)
+var common = []string{"ip", "helo", "session_id", "sender", "recipients", "size", "header", "headers"}
+
+// names are the variables and functions of the decision points, besides the common ones
+var names = map[string][]string{
+	HookRcpt:    {"rcpt", "accept", "reject", "redirect"},
+	HookHeaders: {"add_header", "remove_header"},
+	HookRoute:   {"domain", "route"},
+}
+
+// Hooks are the scripts of the decision points. They're compiled when they're first needed,
+// and again when the file changes.
+type Hooks struct {
+	// Rcpt runs for every recipient and can accept, reject or redirect it
+	Rcpt string
+	// Headers runs for every message and can add and remove header fields
+	Headers string
+	// Route runs for every destination domain of relayed mail and can pick the host it's relayed to
+	Route string
+	// MaxSteps a script can take (0 means 100000)
+	MaxSteps int
+	// Timeout of a script in milliseconds (0 means 100)
+	Timeout int
+
+	mutex    sync.Mutex
+	programs map[string]*loaded
+}
+
+// loaded is a compiled script file
+type loaded struct {
+	program *Program
+	modTime time.Time
+	err     error
+}
+
+// Message is what the scripts know about a message
+type Message struct {
+	SessionId string
+	Ip        string
+	Helo      string
+	From      string
+	To        []string
+	Data      []byte
+}
+
+func (h *Hooks) file(point string) string {
+	switch point {
+	case HookRcpt:
+		return h.Rcpt
+	case HookHeaders:
+		return h.Headers
+	case HookRoute:
+		return h.Route
+	}
+	return ""
+}
+
+// Enabled reports whether there's a script for the decision point
+func (h *Hooks) Enabled(point string) bool {
+	return h.file(point) != ""
+}
+
+// Limits returns the limits of the scripts
+func (h *Hooks) Limits() Limits {
+	limits := Limits{MaxSteps: h.MaxSteps, Timeout: time.Duration(h.Timeout) * time.Millisecond}
+	if limits.MaxSteps <= 0 {
+		limits.MaxSteps = 100000
+	}
+	if limits.Timeout <= 0 {
+		limits.Timeout = 100 * time.Millisecond
+	}
+	return limits
+}
+
+// Program returns the compiled script of the decision point, or nil if there's none
+func (h *Hooks) Program(point string) (*Program, error) {
+	filename := h.file(point)
+	if filename == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if l, found := h.programs[point]; found && l.modTime.Equal(info.ModTime()) {
+		return l.program, l.err
+	}
+	l := &loaded{modTime: info.ModTime()}
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	predeclared := append(append([]string{}, common...), names[point]...)
+	l.program, l.err = Compile(filename, string(source), predeclared)
+	if h.programs == nil {
+		h.programs = make(map[string]*loaded)
+	}
+	h.programs[point] = l
+	return l.program, l.err
+}
+
+// Check compiles the scripts, to report the errors when GoPistolet starts
+func (h *Hooks) Check() error {
+	for _, point := range []string{HookRcpt, HookHeaders, HookRoute} {
+		if _, err := h.Program(point); err != nil {
+			return fmt.Errorf("%s: %v", point, err)
+		}
+	}
+	return nil
+}
+
+// Run runs the script of the decision point for the message, with the variables and functions of the decision point.
+// Besides those, the script has the variables ip, helo, session_id, sender, recipients and size,
+// and the functions header(name) (the first value of the field or None) and headers(name) (all values).
+// What the script prints is logged.
+func (h *Hooks) Run(point string, message *Message, extra starlark.StringDict) error {
+	program, err := h.Program(point)
+	if err != nil || program == nil {
+		return err
+	}
+
+	header := make(map[string][]string)
+	fields, _ := helpers.SplitHeader(message.Data)
+	for _, field := range fields {
+		name := strings.ToLower(helpers.FieldName(field))
+		header[name] = append(header[name], helpers.FieldValue(field))
+	}
+	recipients := make([]starlark.Value, len(message.To))
+	for i, to := range message.To {
+		recipients[i] = starlark.String(to)
+	}
+
+	predeclared := starlark.StringDict{
+		"ip":         starlark.String(message.Ip),
+		"helo":       starlark.String(message.Helo),
+		"session_id": starlark.String(message.SessionId),
+		"sender":     starlark.String(message.From),
+		"recipients": starlark.NewList(recipients),
+		"size":       starlark.MakeInt(len(message.Data)),
+		"header": starlark.NewBuiltin("header", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &name); err != nil {
+				return nil, err
+			}
+			if values := header[strings.ToLower(name)]; len(values) > 0 {
+				return starlark.String(values[0]), nil
+			}
+			return starlark.None, nil
+		}),
+		"headers": starlark.NewBuiltin("headers", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &name); err != nil {
+				return nil, err
+			}
+			values := []starlark.Value{}
+			for _, value := range header[strings.ToLower(name)] {
+				values = append(values, starlark.String(value))
+			}
+			return starlark.NewList(values), nil
+		}),
+	}
+	for name, value := range extra {
+		predeclared[name] = value
+	}
+
+	_, err = program.Run(predeclared, h.Limits(), func(msg string) {
+		log.WithFields(log.Fields{
+			"Ip":        message.Ip,
+			"SessionId": message.SessionId,
+		}).Infof("Script %s: %s", point, msg)
+	})
+	return err
+}
@@ -0,0 +1,153 @@
+// Package script runs the Starlark scripts (https://github.com/google/starlark-go) of the decision points of GoPistolet.
+//
+// The scripts have the Starlark builtins and matches(pattern, s), a regular expression match,
+// and they may use if and for statements at the top level. There are no while loops, no recursion and no load.
+// The host adds its own functions and variables.
+//
+// A script can't reach the file system or the network, and it's stopped after MaxSteps steps or its Timeout.
+package script
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// fileOptions are the Starlark dialect of the scripts
+var fileOptions = &syntax.FileOptions{
+	TopLevelControl: true,
+	GlobalReassign:  true,
+}
+
+// Limits of the execution of a script
+type Limits struct {
+	// MaxSteps is the maximum number of computation steps of the script (0 means no limit)
+	MaxSteps int
+	// Timeout is the maximum time a script runs (0 means no limit)
+	Timeout time.Duration
+}
+
+// Program is a compiled script
+type Program struct {
+	name    string
+	program *starlark.Program
+}
+
+// Compile parses a script which uses the predeclared names of the host, the name is used in the errors.
+// The names the script uses which are neither predeclared nor builtins are errors.
+func Compile(name, source string, predeclared []string) (*Program, error) {
+	names := map[string]bool{"matches": true}
+	for _, n := range predeclared {
+		names[n] = true
+	}
+	_, program, err := starlark.SourceProgramOptions(fileOptions, name, source, func(n string) bool {
+		return names[n]
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Program{name: name, program: program}, nil
+}
+
+// Name returns the name of the program
+func (p *Program) Name() string {
+	return p.name
+}
+
+// Run executes the program with the predeclared variables and functions of the host,
+// print receives the output of the print function (nil discards it).
+// It returns the global variables of the script.
+func (p *Program) Run(predeclared starlark.StringDict, limits Limits, print func(msg string)) (starlark.StringDict, error) {
+	thread := &starlark.Thread{
+		Name: p.name,
+		Print: func(thread *starlark.Thread, msg string) {
+			if print != nil {
+				print(msg)
+			}
+		},
+	}
+	if limits.MaxSteps > 0 {
+		thread.SetMaxExecutionSteps(uint64(limits.MaxSteps))
+	}
+	if limits.Timeout > 0 {
+		timer := time.AfterFunc(limits.Timeout, func() {
+			thread.Cancel("timeout")
+		})
+		defer timer.Stop()
+	}
+
+	globals := make(starlark.StringDict, len(predeclared)+1)
+	globals["matches"] = starlark.NewBuiltin("matches", matches)
+	for name, value := range predeclared {
+		globals[name] = value
+	}
+	globals.Freeze()
+
+	result, err := p.program.Init(thread, globals)
+	if err != nil {
+		return nil, position(err)
+	}
+	return result, nil
+}
+
+// position prefixes the error of a script with the position in the script where it happened
+func position(err error) error {
+	evalErr, ok := err.(*starlark.EvalError)
+	if !ok {
+		return err
+	}
+	for i := len(evalErr.CallStack) - 1; i >= 0; i-- {
+		if pos := evalErr.CallStack[i].Pos; pos.Filename() != "<builtin>" {
+			return fmt.Errorf("%s: %s", pos, evalErr.Msg)
+		}
+	}
+	return err
+}
+
+// regexps caches the compiled regular expressions (Go's regexps run in linear time, so they're safe in a sandbox)
+var regexps = newRegexpCache(100)
+
+// matches(pattern, s) reports whether s contains a match of the regular expression
+func matches(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, s string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &pattern, &s); err != nil {
+		return nil, err
+	}
+	re, err := regexps.get(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return starlark.Bool(re.MatchString(s)), nil
+}
+
+// regexpCache keeps the compiled regular expressions, up to size of them
+type regexpCache struct {
+	mutex   sync.Mutex
+	size    int
+	regexps map[string]*regexp.Regexp
+}
+
+func newRegexpCache(size int) *regexpCache {
+	return &regexpCache{size: size, regexps: make(map[string]*regexp.Regexp)}
+}
+
+func (c *regexpCache) get(pattern string) (*regexp.Regexp, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if re, found := c.regexps[pattern]; found {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.regexps) >= c.size {
+		c.regexps = make(map[string]*regexp.Regexp)
+	}
+	c.regexps[pattern] = re
+	return re, nil
+}
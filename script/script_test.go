@@ -0,0 +1,171 @@
+package script
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.starlark.net/starlark"
+)
+
+func run(source string, predeclared starlark.StringDict) (starlark.StringDict, error) {
+	names := []string{}
+	for name := range predeclared {
+		names = append(names, name)
+	}
+	program, err := Compile("test", source, names)
+	if err != nil {
+		return nil, err
+	}
+	return program.Run(predeclared, Limits{MaxSteps: 10000, Timeout: time.Second}, nil)
+}
+
+func TestScript(t *testing.T) {
+
+	Convey("Testing expressions", t, func() {
+		tests := map[string]string{
+			"1 + 2 * 3":                 "7",
+			"-7 // 2":                   "-4",
+			"'ab' in 'xaby'":            "True",
+			"'yes' if 2 > 1 else 'no'":  `"yes"`,
+			"{'a': 1}.get('b', 2)":      "2",
+			"' Mixed '.strip().lower()": `"mixed"`,
+			"'user@example.com'.endswith('@example.com')": "True",
+			"matches('^[a-z]+@', 'bob@example.com')":      "True",
+			"matches('^[a-z]+@', '1@example.com')":        "False",
+		}
+		for expression, expected := range tests {
+			globals, err := run("result = "+expression, nil)
+			So(err, ShouldEqual, nil)
+			So(globals["result"].String(), ShouldEqual, expected)
+		}
+	})
+
+	Convey("Testing statements", t, func() {
+		addresses := starlark.NewList([]starlark.Value{
+			starlark.String("a@example.com"), starlark.String("b@skip.example"), starlark.String("c@example.com"),
+			starlark.String("d@example.org"), starlark.String("e@stop.example"), starlark.String("f@example.net"),
+		})
+		globals, err := run(`
+# count the domains
+counts = {}
+for address in addresses:
+    domain = address.split("@")[1]
+    if domain == "skip.example":
+        continue
+    elif domain == "stop.example":
+        break
+    counts[domain] = counts.get(domain, 0) + 1
+total = 0
+for domain in counts: total += counts[domain]
+`, starlark.StringDict{"addresses": addresses})
+		So(err, ShouldEqual, nil)
+		So(globals["counts"].String(), ShouldEqual, `{"example.com": 2, "example.org": 1}`)
+		So(globals["total"].String(), ShouldEqual, "3")
+	})
+
+	Convey("Testing host functions", t, func() {
+		called := starlark.Tuple{}
+		_, err := run("log('hello', 1)", starlark.StringDict{
+			"log": starlark.NewBuiltin("log", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				called = append(called, args...)
+				return starlark.None, nil
+			}),
+		})
+		So(err, ShouldEqual, nil)
+		So(called.String(), ShouldEqual, `("hello", 1)`)
+
+		printed := []string{}
+		program, err := Compile("test", "print('hello', sender)", []string{"sender"})
+		So(err, ShouldEqual, nil)
+		_, err = program.Run(starlark.StringDict{"sender": starlark.String("alice")}, Limits{}, func(msg string) {
+			printed = append(printed, msg)
+		})
+		So(err, ShouldEqual, nil)
+		So(printed, ShouldResemble, []string{"hello alice"})
+	})
+
+	Convey("Testing errors", t, func() {
+		for _, source := range []string{
+			"if True\n    pass",
+			"x = (1",
+			"  x = 1",
+			"while True: pass",
+			"x = undefined",
+			"x = 'unterminated",
+		} {
+			_, err := Compile("test", source, nil)
+			So(err, ShouldNotEqual, nil)
+		}
+
+		_, err := run("\nx = 1 // 0", nil)
+		So(err.Error(), ShouldEqual, "test:2:7: floored division by zero")
+		_, err = run("x = 'a' + 1", nil)
+		So(err, ShouldNotEqual, nil)
+		_, err = run("x = matches('(', 'a')", nil)
+		So(err.Error(), ShouldStartWith, "test:1:12: matches: error parsing regexp")
+		// the host's values can't be changed
+		_, err = run("addresses.append('x')", starlark.StringDict{"addresses": starlark.NewList(nil)})
+		So(err, ShouldNotEqual, nil)
+		_, err = run("load('other.star', 'x')", nil)
+		So(err, ShouldNotEqual, nil)
+		_, err = run("def f(n):\n    return f(n)\nf(1)", nil)
+		So(err.Error(), ShouldContainSubstring, "called recursively")
+	})
+
+	Convey("Testing limits", t, func() {
+		program, err := Compile("test", "l = range(10)\nfor a in l:\n    for b in l:\n        for c in l:\n            for d in l:\n                x = a + b + c + d", nil)
+		So(err, ShouldEqual, nil)
+		_, err = program.Run(nil, Limits{MaxSteps: 1000}, nil)
+		So(err.Error(), ShouldContainSubstring, "too many steps")
+		_, err = program.Run(nil, Limits{}, nil)
+		So(err, ShouldEqual, nil)
+
+		program, err = Compile("test", "l = range(1000)\nfor a in l:\n    for b in l:\n        for c in l:\n            x = a + b + c", nil)
+		So(err, ShouldEqual, nil)
+		_, err = program.Run(nil, Limits{Timeout: time.Millisecond}, nil)
+		So(err.Error(), ShouldContainSubstring, "timeout")
+	})
+
+	Convey("Testing hooks", t, func() {
+		dir, err := ioutil.TempDir("", "script")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "rcpt.star")
+		So(ioutil.WriteFile(filename, []byte("accept(sender, rcpt, header('subject'), header('x-none'), len(headers('received')), size)\n"), 0644), ShouldEqual, nil)
+
+		hooks := &Hooks{Rcpt: filename}
+		So(hooks.Enabled(HookRcpt), ShouldBeTrue)
+		So(hooks.Enabled(HookRoute), ShouldBeFalse)
+		So(hooks.Check(), ShouldEqual, nil)
+
+		message := &Message{From: "alice@example.com", To: []string{"bob@example.com"}, Data: []byte("Received: a\r\nReceived: b\r\nSubject: hi\r\n\r\nbody\r\n")}
+		var got starlark.Tuple
+		err = hooks.Run(HookRcpt, message, starlark.StringDict{
+			"rcpt": starlark.String("bob@example.com"),
+			"accept": starlark.NewBuiltin("accept", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				got = args
+				return starlark.None, nil
+			}),
+		})
+		So(err, ShouldEqual, nil)
+		So(got.String(), ShouldEqual, `("alice@example.com", "bob@example.com", "hi", None, 2, 47)`)
+
+		// the functions of the other decision points are unknown
+		So(ioutil.WriteFile(filename, []byte("route('mx')\n"), 0644), ShouldEqual, nil)
+		later := time.Now().Add(time.Second)
+		So(os.Chtimes(filename, later, later), ShouldEqual, nil)
+		So(hooks.Check(), ShouldNotEqual, nil)
+
+		// the script is compiled again when it changes
+		So(ioutil.WriteFile(filename, []byte("accept(\n"), 0644), ShouldEqual, nil)
+		later = later.Add(time.Second)
+		So(os.Chtimes(filename, later, later), ShouldEqual, nil)
+		So(hooks.Check(), ShouldNotEqual, nil)
+		So(hooks.Run(HookRcpt, message, nil), ShouldNotEqual, nil)
+	})
+
+}
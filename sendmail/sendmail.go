@@ -0,0 +1,164 @@
+// Package sendmail implements the command line of sendmail for gopistolet-sendmail,
+// so cron jobs and other programs which call sendmail can send mail through GoPistolet
+package sendmail
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/control"
+	"github.com/gopistolet/gopistolet/helpers"
+)
+
+// Options are the options of the command line
+type Options struct {
+	// From is the envelope sender (-f or -r), empty if it isn't given
+	From string
+	// FullName is the name of the sender (-F)
+	FullName string
+	// ExtractRecipients reads the recipients from the To, Cc and Bcc fields (-t),
+	// they're added to the recipients on the command line
+	ExtractRecipients bool
+	// IgnoreDots doesn't end the message at a line with a single dot (-i or -oi)
+	IgnoreDots bool
+	// Recipients on the command line
+	Recipients []string
+}
+
+// withValue are the options which take a value, in the same argument or in the next one.
+// Only -f, -r and -F are used, the others are accepted for compatibility.
+const withValue = "BCFLNORVXfhr"
+
+// Parse parses the arguments of sendmail, the options which don't apply are ignored
+func Parse(args []string) (*Options, error) {
+	options := &Options{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			options.Recipients = append(options.Recipients, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			// the options end at the first recipient
+			options.Recipients = append(options.Recipients, args[i:]...)
+			break
+		}
+
+		option := arg[1]
+		value := arg[2:]
+		if strings.IndexByte(withValue, option) >= 0 && value == "" {
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("option -%c needs a value", option)
+			}
+			i++
+			value = args[i]
+		}
+		switch option {
+		case 'f', 'r':
+			options.From = strings.TrimSuffix(strings.TrimPrefix(value, "<"), ">")
+			if value == "<>" || value == "" {
+				options.From = "<>"
+			}
+		case 'F':
+			options.FullName = value
+		case 't':
+			options.ExtractRecipients = true
+		case 'i':
+			options.IgnoreDots = true
+		case 'o':
+			if value == "i" {
+				options.IgnoreDots = true
+			}
+		case 'b':
+			// -bm (read the message from stdin) is the only mode
+			if value != "m" {
+				return nil, fmt.Errorf("mode -b%s isn't supported", value)
+			}
+		case 'B', 'C', 'L', 'N', 'O', 'R', 'V', 'X', 'h', 'e', 'v', 'n', 'U', 'G', 'd', 'q':
+		default:
+			return nil, fmt.Errorf("unknown option %s", arg)
+		}
+	}
+	return options, nil
+}
+
+// Read reads the message from r, and the recipients from its header with -t.
+// Bcc fields are removed when the recipients are read from the header.
+func (o *Options) Read(r io.Reader) (*control.Message, error) {
+	data := []byte{}
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if !o.IgnoreDots && string(bytes.TrimRight(line, "\r\n")) == "." {
+			break
+		}
+		data = append(data, line...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	message := &control.Message{
+		From:     o.From,
+		FullName: o.FullName,
+		To:       append([]string{}, o.Recipients...),
+	}
+	if o.ExtractRecipients {
+		fields, body := helpers.SplitHeader(data)
+		kept := []byte{}
+		for _, field := range fields {
+			name := strings.ToLower(helpers.FieldName(field))
+			if name == "to" || name == "cc" || name == "bcc" {
+				addresses, err := parseAddresses(helpers.FieldValue(field))
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", helpers.FieldName(field), err)
+				}
+				message.To = append(message.To, addresses...)
+			}
+			if name != "bcc" {
+				kept = append(kept, field...)
+			}
+		}
+		data = append(kept, body...)
+	}
+	message.Data = data
+
+	if len(message.To) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	return message, nil
+}
+
+// parseAddresses returns the addresses of an address list,
+// which may contain local names without domain (e.g. To: root)
+func parseAddresses(value string) ([]string, error) {
+	addresses := []string{}
+	if list, err := mail.ParseAddressList(value); err == nil {
+		for _, address := range list {
+			addresses = append(addresses, address.Address)
+		}
+		return addresses, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if address, err := mail.ParseAddress(item); err == nil {
+			addresses = append(addresses, address.Address)
+		} else if !strings.ContainsAny(item, " \t<>@\"") {
+			addresses = append(addresses, item)
+		} else {
+			return nil, err
+		}
+	}
+	return addresses, nil
+}
@@ -0,0 +1,59 @@
+package sendmail
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParse(t *testing.T) {
+
+	Convey("Testing Parse", t, func() {
+		// the command line of cronie
+		options, err := Parse([]string{"-FCronDaemon", "-i", "-B8BITMIME", "-oem", "-oi", "-t", "-f", "root"})
+		So(err, ShouldEqual, nil)
+		So(options, ShouldResemble, &Options{From: "root", FullName: "CronDaemon", ExtractRecipients: true, IgnoreDots: true})
+
+		options, err = Parse([]string{"-f<>", "-odb", "bob@example.com", "-v", "alice"})
+		So(err, ShouldEqual, nil)
+		So(options.From, ShouldEqual, "<>")
+		So(options.IgnoreDots, ShouldEqual, false)
+		So(options.Recipients, ShouldResemble, []string{"bob@example.com", "-v", "alice"})
+
+		options, err = Parse([]string{"-r", "<bob@example.com>", "--", "-alice"})
+		So(err, ShouldEqual, nil)
+		So(options.From, ShouldEqual, "bob@example.com")
+		So(options.Recipients, ShouldResemble, []string{"-alice"})
+
+		_, err = Parse([]string{"-bp"})
+		So(err.Error(), ShouldContainSubstring, "-bp")
+		_, err = Parse([]string{"-f"})
+		So(err.Error(), ShouldContainSubstring, "needs a value")
+		_, err = Parse([]string{"-Z"})
+		So(err.Error(), ShouldContainSubstring, "unknown option -Z")
+	})
+
+	Convey("Testing Read", t, func() {
+		options := &Options{Recipients: []string{"bob@example.com"}}
+		message, err := options.Read(strings.NewReader("Subject: test\n\nline\n.\nafter the dot\n"))
+		So(err, ShouldEqual, nil)
+		So(message.To, ShouldResemble, []string{"bob@example.com"})
+		So(string(message.Data), ShouldEqual, "Subject: test\n\nline\n")
+
+		options.IgnoreDots = true
+		message, err = options.Read(strings.NewReader("Subject: test\n\nline\n.\nafter the dot"))
+		So(err, ShouldEqual, nil)
+		So(string(message.Data), ShouldEqual, "Subject: test\n\nline\n.\nafter the dot")
+
+		options = &Options{ExtractRecipients: true, Recipients: []string{"carol@example.com"}}
+		message, err = options.Read(strings.NewReader("To: root, Alice <alice@example.com>\nCc: dave@example.com\nBcc: eve@example.com,\n\tmallory@example.com\nSubject: test\n\nbody\n"))
+		So(err, ShouldEqual, nil)
+		So(message.To, ShouldResemble, []string{"carol@example.com", "root", "alice@example.com", "dave@example.com", "eve@example.com", "mallory@example.com"})
+		So(string(message.Data), ShouldEqual, "To: root, Alice <alice@example.com>\nCc: dave@example.com\nSubject: test\n\nbody\n")
+
+		_, err = (&Options{}).Read(strings.NewReader("Subject: test\n\n"))
+		So(err.Error(), ShouldEqual, "no recipients")
+	})
+
+}
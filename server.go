@@ -0,0 +1,901 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopistolet/gopistolet/config"
+	"github.com/gopistolet/gopistolet/handlers/access"
+	"github.com/gopistolet/gopistolet/handlers/callout"
+	"github.com/gopistolet/gopistolet/handlers/maildir"
+	"github.com/gopistolet/gopistolet/handlers/submission"
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+	"github.com/gopistolet/smtp/mta"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// smtpServer is the SMTP server of a listener
+type smtpServer interface {
+	Listen() error
+	Serve() error
+	Stop()
+}
+
+// sessionServer accepts the connections itself instead of leaving it to the MTA,
+// so it can listen on IPv6 addresses (the MTA joins the IP and port without brackets),
+// the sessions can be recorded in the transcripts and the replies replaced
+type sessionServer struct {
+	config   *config.Config
+	mta      *mta.Mta
+	network  string
+	address  string
+	hostname string
+	// the texts of the replies are rewritten if texts isn't nil
+	texts *helpers.Catalog
+	// clients which a proxy reported with XCLIENT are checked in the blacklist
+	blacklist helpers.Blacklist
+	callout   *callout.Callout
+	access    *access.Access
+	// submission is true for the listeners of clients, which have to authenticate before they send mail
+	submission bool
+	// implicitTls starts TLS when the client connects, before the greeting (the submissions role)
+	implicitTls bool
+
+	mutex sync.Mutex
+	// listener is opened by Listen, unless it's a socket from systemd socket activation
+	listener net.Listener
+	stopped  bool
+	wg       sync.WaitGroup
+}
+
+// newSessionServer returns a server which listens on the address (host:port) of the network (tcp, tcp4 or tcp6).
+// The MTA config is the one of the listener, c has the transcripts, the extensions and the line endings of the sessions.
+func newSessionServer(c *config.Config, mtaConfig mta.Config, network, address string, handler mta.Handler, texts *helpers.Catalog) *sessionServer {
+	return &sessionServer{
+		config:    c,
+		mta:       mta.New(mtaConfig, handler),
+		network:   network,
+		address:   address,
+		hostname:  mtaConfig.Hostname,
+		texts:     texts,
+		blacklist: mtaConfig.Blacklist,
+		callout:   callout.New(c),
+		access:    access.New(c),
+	}
+}
+
+// Listen binds to the address, unless the server already has a listener
+func (s *sessionServer) Listen() error {
+	if s.implicitTls && s.mta.TlsConfig == nil {
+		return fmt.Errorf("Listener on %s: implicit TLS needs the TlsCert and the TlsKey", s.address)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener != nil || s.stopped {
+		return nil
+	}
+	listener, err := net.Listen(s.network, s.address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	return nil
+}
+
+// Serve accepts the connections of the listener until the server is stopped
+func (s *sessionServer) Serve() error {
+	s.mutex.Lock()
+	listener := s.listener
+	s.mutex.Unlock()
+	if listener == nil {
+		return nil
+	}
+	if s.implicitTls {
+		// the CAs of the client certificates can be reloaded
+		listener = tls.NewListener(listener, &tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return s.config.ClientCerts.TLSConfig(s.mta.TlsConfig), nil
+			},
+		})
+	}
+	if s.config.Transcripts.Enabled {
+		log.Warnln("Recording transcripts of the sessions on " + s.address)
+	}
+
+	defer s.wg.Wait()
+	// how long to wait after a temporary accept error, so running out of file descriptors doesn't busy-loop
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else {
+					delay *= 2
+				}
+				if delay > time.Second {
+					delay = time.Second
+				}
+				log.Warnf("Couldn't accept a connection on %s, retrying in %v: %v", s.address, delay, err)
+				time.Sleep(delay)
+				continue
+			}
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			if s.stopped {
+				return nil
+			}
+			return err
+		}
+		delay = 0
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+// serve handles a session and stores its transcript
+func (s *sessionServer) serve(conn net.Conn) {
+	defer s.wg.Done()
+	var transcript *helpers.Transcript
+	if s.config.Transcripts.Enabled {
+		conn, transcript = s.config.Transcripts.Conn(conn)
+	}
+	session := helpers.NewSessionConn(conn)
+	session.Normalize = s.config.Smuggling == config.SmugglingNormalize
+	protocol := smtp.NewMtaProtocol(session)
+	proto := &replyProtocol{
+		Protocol:   protocol,
+		config:     s.config,
+		session:    session,
+		state:      protocol.GetState(),
+		texts:      s.texts,
+		hostname:   s.hostname,
+		acceptance: &s.config.Acceptance,
+		xclient:    &s.config.Xclient,
+		blacklist:  s.blacklist,
+		callout:    s.callout,
+		access:     s.access,
+		submission: s.submission,
+	}
+	proto.proxy = s.config.XclientHosts.Contains(proto.GetIP())
+	// the clients which may connect during a connection flood get the connections, the others 421
+	if ip := proto.GetIP(); ip != nil {
+		if s.config.Shedding.Open(ip.String(), s.config.Access.Trusted(ip)) {
+			defer s.config.Shedding.Close(ip.String())
+		} else {
+			proto.shed = true
+		}
+	}
+	// the MTA keeps Secure when it resets the state, so STARTTLS isn't offered
+	proto.state.Secure = s.implicitTls
+	s.handleClient(proto, conn)
+	state := proto.GetState()
+	if proto.proxy {
+		s.config.Xclient.Forget(state.SessionId.String())
+	}
+	if proto.login != "" {
+		s.config.Logins.Forget(state.SessionId.String())
+	}
+	s.config.Tls.Forget(state.SessionId.String())
+	if transcript == nil {
+		return
+	}
+
+	if err := s.config.Transcripts.Finish(transcript, state.SessionId.String()); err != nil {
+		log.WithFields(log.Fields{
+			"Ip":        state.Ip.String(),
+			"SessionId": state.SessionId.String(),
+		}).Errorf("Couldn't save transcript: %v", err)
+	}
+}
+
+// handleClient lets the MTA handle the session. A panic only ends this session: the client gets 421
+// and the connection is closed.
+func (s *sessionServer) handleClient(proto *replyProtocol, conn net.Conn) {
+	defer func() {
+		if r := recover(); r != nil {
+			proto.recovered(r)
+			conn.Close()
+		}
+	}()
+	s.mta.HandleClient(proto)
+}
+
+// Stop stops accepting connections and lets the MTA end the sessions
+func (s *sessionServer) Stop() {
+	s.mutex.Lock()
+	s.stopped = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mutex.Unlock()
+	s.mta.Stop()
+}
+
+// localError is the reply code of the transactions which were aborted by a local error (RFC 5321 section 4.2.3)
+const localError smtp.StatusCode = 451
+
+// Reply codes of PRDR (draft-hall-prdr): the start of the replies per recipient after DATA,
+// and the final reply if all recipients refused the message
+const (
+	prdrStart    smtp.StatusCode = 353
+	prdrRejected smtp.StatusCode = 550
+)
+
+// bareLineEnding is the reply code of messages which were refused for their bare <CR> or <LF> line endings
+const bareLineEnding = smtp.NoValidRecipients
+
+// tooManyRecipients is the reply code of RCPT after MaxRecipients recipients (RFC 5321 section 4.5.3.1.10),
+// defaultMaxRecipients is the limit if MaxRecipients isn't set
+const (
+	tooManyRecipients    smtp.StatusCode = 452
+	defaultMaxRecipients                 = 100
+)
+
+// Reply codes of RCPT for local recipients which don't exist, and for senders which the MX hosts
+// of their domain refused in a callout
+const (
+	userUnknown    smtp.StatusCode = 550
+	senderRejected smtp.StatusCode = 550
+)
+
+// mailboxFull is the reply code of RCPT for local recipients whose mailbox reached its quota,
+// they may make room for the retry
+const mailboxFull smtp.StatusCode = 452
+
+// Reply codes of MAIL from clients whose HELO domain is listed in a rejecting DNS blocklist,
+// and of RCPT from clients which are greylisted (see helpers.Dnsbl)
+const (
+	dnsblRejected smtp.StatusCode = 554
+	greylisted    smtp.StatusCode = 451
+)
+
+// Reply codes of RCPT for the recipients which the access rules reject or defer
+const (
+	accessRejected smtp.StatusCode = 550
+	accessDeferred smtp.StatusCode = 450
+)
+
+// privateRejection is the reply code of every refusal of a sender or recipient with PrivateReplies
+const privateRejection smtp.StatusCode = 550
+
+// defaultMaxErrors is the number of error replies after which a session is closed if MaxErrors isn't set
+const defaultMaxErrors = 20
+
+// tlsRequired is the reply code of MAIL and RCPT for domains which only accept mail over TLS (RFC 3207 section 4)
+const tlsRequired smtp.StatusCode = 530
+
+// authRequired is the reply code of MAIL from clients of a submission listener which didn't authenticate (RFC 4954 section 6)
+const authRequired smtp.StatusCode = 530
+
+// Reply codes while the server is overloaded (see helpers.Backpressure): new sessions are refused
+// at the greeting, MAIL in the sessions which are open gets a temporary failure (RFC 3463 section 3.4),
+// as does MAIL while the disk is running out of space (see helpers.DiskWatchdog)
+const (
+	overloaded smtp.StatusCode = 421
+	systemFull smtp.StatusCode = 452
+)
+
+// xclientUnauthorized is the reply code of XCLIENT from clients which aren't trusted proxies
+const xclientUnauthorized smtp.StatusCode = 550
+
+// mtaReplies are the texts of the MTA's replies, with the keys of their texts in the catalog
+var mtaReplies = map[string]string{
+	"OK":                       "smtp.ok",
+	"Bye!":                     "smtp.bye",
+	"Server is going down.":    "smtp.shutting_down",
+	"Line too long.":           "smtp.line_too_long",
+	"Line too long":            "smtp.line_too_long",
+	"Sender ok":                "smtp.sender_ok",
+	"Sender and 8BITMIME ok":   "smtp.sender_8bitmime_ok",
+	"Sender already specified": "smtp.sender_specified",
+	"Need mail before RCPT":    "smtp.need_mail",
+	"Need mail before DATA":    "smtp.need_mail_data",
+	"Need RCPT before DATA":    "smtp.need_rcpt",
+	"Start mail input; end with <CRLF>.<CRLF>":          "smtp.start_data",
+	"Start 8BITMIME mail input; end with <CRLF>.<CRLF>": "smtp.start_data_8bitmime",
+	"Could not parse mail data":                         "smtp.data_incomplete",
+	"Mail delivered":                                    "smtp.delivered",
+	"STARTTLS is not implemented":                       "smtp.starttls_unavailable",
+	"Already in TLS mode":                               "smtp.already_tls",
+	"Ready for TLS handshake":                           "smtp.ready_tls",
+	"Command not implemented":                           "smtp.not_implemented",
+	"Command not recognized":                            "smtp.not_recognized",
+}
+
+// replyProtocol replaces the texts of the MTA's replies by the ones in the catalog (if texts isn't nil),
+// the codes stay the same. Replies which aren't in the catalog (e.g. syntax errors in parameters) are sent as they are.
+// The MTA acknowledges every message after the handlers, so a message which couldn't be stored
+// gets a 451 instead of the 250.
+//
+// It also implements the extensions the MTA doesn't know, which are advertised in the reply to EHLO:
+// for PRDR the session tells whether MAIL FROM asked for it, and the single reply after DATA is replaced
+// by a reply per recipient. The DSN parameters of MAIL and RCPT are registered for every recipient.
+// XCLIENT and AUTH are answered before the MTA sees them.
+//
+// Clients which got MaxErrors error replies are disconnected with 421.
+type replyProtocol struct {
+	smtp.Protocol
+	config     *config.Config
+	session    *helpers.SessionConn
+	state      *smtp.State
+	texts      *helpers.Catalog
+	hostname   string
+	acceptance *helpers.Acceptance
+
+	// prdr is true if the client asked for PRDR in the transaction, recipients are its recipients in order
+	prdr       bool
+	recipients []string
+	// mailParams are the parameters of MAIL in the transaction, for the DSN parameters of the recipients
+	mailParams map[string]string
+	// the sender of the transaction is verified with a callout at the first RCPT,
+	// senderRefusal is the reply of its MX hosts if they refused it
+	callout       *callout.Callout
+	senderChecked bool
+	senderRefusal string
+	// access refuses the recipients which the access rules reject or defer
+	access *access.Access
+
+	// errors is the number of error replies the client got
+	errors int
+
+	// proxy is true if the client may report its client with XCLIENT, which is checked in the blacklist
+	proxy     bool
+	xclient   *helpers.XclientSessions
+	blacklist helpers.Blacklist
+
+	// login is the user which authenticated with AUTH, which the clients of a submission listener
+	// (except the relay networks) need before MAIL
+	login      string
+	submission bool
+
+	// shed is true if ConnectionShedding refused the client, overloaded is true if the greeting refused the session
+	// (because of shed or Backpressure), it ends before the first command
+	shed       bool
+	overloaded bool
+}
+
+// errTooManyErrors ends the session of a client which got MaxErrors error replies
+var errTooManyErrors = errors.New("too many errors")
+
+// errXclientRejected ends the session of a client which a proxy reported and the blacklist refuses
+var errXclientRejected = errors.New("client reported with XCLIENT is blacklisted")
+
+// errOverloaded ends the session which the greeting refused because the server is overloaded
+var errOverloaded = errors.New("server overloaded")
+
+// errSessionPanicked ends the session in which the handling of a command panicked
+var errSessionPanicked = errors.New("session panicked")
+
+// sessionPanics counts the recovered panics of the sessions
+var sessionPanics uint64
+
+// GetCmd reads the next command for the MTA. The MTA reads the commands in a goroutine of their own,
+// so the panics of the commands which are answered here (e.g. XCLIENT and AUTH) are recovered here:
+// the client gets 421 and the session ends, the other sessions go on.
+func (p *replyProtocol) GetCmd() (cmd *smtp.Cmd, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.recovered(r)
+			cmd, err = nil, errSessionPanicked
+		}
+	}()
+	return p.getCmd()
+}
+
+// recovered logs the panic of a session and sends 421 to the client
+func (p *replyProtocol) recovered(r interface{}) {
+	atomic.AddUint64(&sessionPanics, 1)
+	state := p.GetState()
+	log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	}).Errorf("Session panicked: %v\n%s", r, debug.Stack())
+	p.Protocol.Send(smtp.Answer{Status: smtp.ShuttingDown, Message: "4.3.0 " + p.text("smtp.local_error")})
+}
+
+func (p *replyProtocol) getCmd() (*smtp.Cmd, error) {
+	if p.overloaded {
+		return nil, errOverloaded
+	}
+	for {
+		if p.errors >= p.maxErrors() {
+			state := p.GetState()
+			log.WithFields(log.Fields{
+				"Ip":        state.Ip.String(),
+				"SessionId": state.SessionId.String(),
+			}).Warnf("Closing the session after %d errors", p.errors)
+			p.Protocol.Send(smtp.Answer{Status: smtp.ShuttingDown, Message: "4.7.0 " + p.text("smtp.too_many_errors")})
+			return nil, errTooManyErrors
+		}
+		cmd, err := p.Protocol.GetCmd()
+		// every line the MTA parses is a command, also the ones which are too long
+		command := p.session.NextCommand()
+		if err != nil {
+			return cmd, err
+		}
+		if _, unknown := (*cmd).(smtp.UnknownCmd); unknown && command.Verb == "XCLIENT" {
+			if err := p.handleXclient(command); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, unknown := (*cmd).(smtp.UnknownCmd); unknown && command.Verb == "AUTH" {
+			p.handleAuth(command)
+			continue
+		}
+		intercepted, ok := p.intercept(*cmd, command)
+		if !ok {
+			continue
+		}
+		p.follow(intercepted, command)
+		return &intercepted, nil
+	}
+}
+
+// intercept answers the commands which the session refuses before the MTA sees them, it returns false for those.
+// MAIL and RCPT are returned as envelope parsed them, the other commands as the MTA parsed them.
+func (p *replyProtocol) intercept(cmd smtp.Cmd, command helpers.Command) (smtp.Cmd, bool) {
+	state := p.GetState()
+	strictHelo := p.config.StrictHelo && !p.config.Access.Trusted(state.Ip)
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+
+	switch command.Verb {
+	case "HELO", "EHLO":
+		if strictHelo && !helpers.IsHeloArgument(command.Args) {
+			logger.Warnf("Helo: refused greeting with invalid name '%s'", command.Args)
+			p.reply(smtp.Answer{Status: smtp.SyntaxErrorParam, Message: "5.5.2 " + p.text("smtp.helo_invalid")})
+			return nil, false
+		}
+	case "MAIL", "RCPT":
+		// XCLIENT reports the greeting of the client too
+		if command.Verb == "MAIL" && strictHelo && state.Hostname == "" {
+			logger.Warn("Helo: refused MAIL from client which didn't send HELO or EHLO")
+			p.reply(smtp.Answer{Status: smtp.BadSequence, Message: "5.5.1 " + p.text("smtp.need_helo")})
+			return nil, false
+		}
+		if command.Verb == "MAIL" && p.config.Backpressure.Overloaded() {
+			logger.Warn("Backpressure: refused MAIL")
+			p.reply(smtp.Answer{Status: systemFull, Message: "4.3.1 " + p.text("smtp.system_full")})
+			return nil, false
+		}
+		if command.Verb == "MAIL" && p.config.DiskWatchdog.Low() {
+			logger.Warn("Disk watchdog: refused MAIL")
+			p.reply(smtp.Answer{Status: systemFull, Message: "4.3.1 " + p.text("smtp.insufficient_storage")})
+			return nil, false
+		}
+		if command.Verb == "MAIL" && state.Secure {
+			// the handshake is done by now, also with implicit TLS
+			if tlsState, ok := p.session.ConnectionState(); ok {
+				p.config.Tls.Set(state.SessionId.String(), tlsState)
+			}
+		}
+		if command.Verb == "MAIL" && p.dnsblChecked(state) {
+			if lists := p.config.Dnsbl.Listed(context.Background(), "", state.Hostname, helpers.DnsblReject); len(lists) > 0 {
+				logger.Warnf("DNSBL: refused MAIL, HELO domain %s is listed in %s", state.Hostname, lists[0].Zone)
+				p.refuse(smtp.Answer{Status: dnsblRejected, Message: "5.7.1 " + p.text("smtp.dnsbl_rejected")})
+				return nil, false
+			}
+		}
+		if command.Verb == "MAIL" && p.submission && !p.config.MayRelay(state) {
+			logger.Warn("Refused MAIL from client which didn't authenticate on the submission port")
+			p.reply(smtp.Answer{Status: authRequired, Message: "5.7.0 " + p.text("smtp.auth_required")})
+			return nil, false
+		}
+		envelope, err := p.envelope(command)
+		if err != nil {
+			p.reply(esmtpReply(err))
+			return nil, false
+		}
+		if _, rcpt := envelope.(smtp.RcptCmd); rcpt && state.From != nil && len(state.To) >= p.maxRecipients() {
+			p.reply(smtp.Answer{Status: tooManyRecipients, Message: "4.5.3 " + p.text("smtp.too_many_recipients")})
+			return nil, false
+		}
+		var address *smtp.MailAddress
+		switch envelope := envelope.(type) {
+		case smtp.MailCmd:
+			address = envelope.From
+		case smtp.RcptCmd:
+			address = envelope.To
+		}
+		if !state.Secure && p.config.RequiresTls(address.GetDomain()) {
+			logger.Warnf("Refused %s for %s in a session without TLS", command.Verb, address.Address)
+			p.refuse(smtp.Answer{Status: tlsRequired, Message: "5.7.0 " + p.text("smtp.tls_required")})
+			return nil, false
+		}
+		if command.Verb == "MAIL" && p.login != "" {
+			// authenticated users only send as themselves (RFC 6409 section 6.1)
+			u, err := submission.LoginUser(p.config.Users.Store, p.login)
+			if err == nil {
+				err = submission.CheckSender(p.config.Users.Store, u, &smtp.State{From: address}, false)
+			}
+			if e, ok := err.(*helpers.EsmtpError); ok {
+				logger.Warnf("Refused sender %s of %s: %s", address.Address, p.login, e.Message)
+				p.reply(smtp.Answer{Status: smtp.StatusCode(e.Code), Message: e.Message})
+				return nil, false
+			}
+		}
+		if command.Verb == "RCPT" && !p.config.KnownRecipient(address.Address) {
+			logger.Infof("Refused unknown local recipient %s", address.Address)
+			p.refuse(smtp.Answer{Status: userUnknown, Message: "5.1.1 " + p.text("smtp.user_unknown")})
+			return nil, false
+		}
+		if command.Verb == "RCPT" && maildir.MailboxFull(p.config, address.Address) {
+			logger.Infof("Refused recipient %s with a full mailbox", address.Address)
+			p.reply(smtp.Answer{Status: mailboxFull, Message: "4.2.2 " + p.text("smtp.mailbox_full")})
+			return nil, false
+		}
+		if command.Verb == "RCPT" && state.From != nil {
+			switch action, _ := p.access.Action(state, address); action {
+			case access.ActionReject:
+				logger.Infof("Access: rejected recipient %s", address.Address)
+				p.refuse(smtp.Answer{Status: accessRejected, Message: "5.7.1 " + p.text("smtp.access_rejected")})
+				return nil, false
+			case access.ActionDefer:
+				logger.Infof("Access: deferred recipient %s", address.Address)
+				p.reply(smtp.Answer{Status: accessDeferred, Message: "4.7.1 " + p.text("smtp.access_deferred")})
+				return nil, false
+			}
+			// the sender is verified once per transaction
+			if !p.senderChecked {
+				p.senderRefusal, p.senderChecked = p.callout.Check(state), true
+			}
+			if p.senderRefusal != "" {
+				p.refuse(smtp.Answer{Status: senderRejected, Message: "5.1.7 " + p.text("smtp.sender_rejected")})
+				return nil, false
+			}
+			if p.dnsblChecked(state) && len(p.config.Dnsbl.Listed(context.Background(), state.Ip.String(), state.Hostname, helpers.DnsblGreylist)) > 0 &&
+				!p.config.Dnsbl.Greylist.Check(state.Ip.String(), state.From.Address, address.Address) {
+				logger.Infof("DNSBL: greylisted recipient %s", address.Address)
+				p.reply(smtp.Answer{Status: greylisted, Message: "4.7.1 " + p.text("smtp.greylisted")})
+				return nil, false
+			}
+		}
+		// the MTA's parser refuses valid paths, like the null sender and quoted local parts
+		return envelope, true
+	}
+	return cmd, true
+}
+
+// envelope parses MAIL FROM: and RCPT TO: with ParseEsmtpArgs (RFC 5321 section 4.1.2) instead of the MTA's parser,
+// which only knows BODY and drops the other parameters. The parameters have to belong to the extensions of the session.
+// The sender may be the null path (<>), "postmaster" without domain is a recipient at this host (RFC 5321 section 4.5.1).
+func (p *replyProtocol) envelope(command helpers.Command) (smtp.Cmd, error) {
+	prefix := "FROM:"
+	if command.Verb == "RCPT" {
+		prefix = "TO:"
+	}
+	if len(command.Args) < len(prefix) || !strings.EqualFold(command.Args[:len(prefix)], prefix) {
+		return nil, &helpers.EsmtpError{Code: 501, Message: "Syntax: " + command.Verb + " " + prefix + "<address>"}
+	}
+	path, params, err := helpers.ParseEsmtpArgs(command.Args[len(prefix):])
+	if err != nil {
+		return nil, err
+	}
+	if err := helpers.ValidateEsmtpParams(command.Verb, params, p.extensions()); err != nil {
+		return nil, err
+	}
+	local, domain, _ := helpers.ParsePath(path)
+
+	if command.Verb == "MAIL" {
+		if local != "" && domain == "" {
+			return nil, &helpers.EsmtpError{Code: 501, Message: "Sender address must have a domain"}
+		}
+		address := ""
+		if local != "" {
+			address = local + "@" + domain
+		}
+		return smtp.MailCmd{From: &smtp.MailAddress{Address: address}, EightBitMIME: strings.EqualFold(params["BODY"], "8BITMIME")}, nil
+	}
+	switch {
+	case local == "":
+		return nil, &helpers.EsmtpError{Code: 501, Message: "Recipient address must not be empty"}
+	case domain == "":
+		domain = p.hostname
+	}
+	return smtp.RcptCmd{To: &smtp.MailAddress{Address: local + "@" + domain}}, nil
+}
+
+// maxRecipients is the number of recipients a transaction may have
+func (p *replyProtocol) maxRecipients() int {
+	if p.config.MaxRecipients <= 0 {
+		return defaultMaxRecipients
+	}
+	return p.config.MaxRecipients
+}
+
+// extensions are the EHLO keywords of the extensions whose parameters MAIL and RCPT may have
+func (p *replyProtocol) extensions() map[string]bool {
+	extensions := map[string]bool{"8BITMIME": true, "DSN": true}
+	if p.acceptance != nil {
+		extensions["PRDR"] = true
+	}
+	return extensions
+}
+
+// esmtpReply is the reply to the error of checkEnvelope, with the enhanced status code
+// of a syntax error (501) or of a parameter which isn't implemented (555)
+func esmtpReply(err error) smtp.Answer {
+	e, ok := err.(*helpers.EsmtpError)
+	if !ok {
+		e = &helpers.EsmtpError{Code: int(smtp.SyntaxErrorParam), Message: err.Error()}
+	}
+	status := "5.5.2"
+	if e.Code == 555 {
+		status = "5.5.4"
+	}
+	return smtp.Answer{Status: smtp.StatusCode(e.Code), Message: status + " " + e.Message}
+}
+
+// StartTls starts TLS below the SessionConn, so it keeps following the commands and the message data.
+// The MTA's protocol is replaced, so it doesn't read the plaintext it buffered.
+// The client certificates are verified for AUTH EXTERNAL.
+func (p *replyProtocol) StartTls(config *tls.Config) error {
+	if err := p.session.StartTls(p.config.ClientCerts.TLSConfig(config)); err != nil {
+		return err
+	}
+	p.Protocol = smtp.NewMtaProtocol(p.session)
+	return nil
+}
+
+// GetState returns the state of the session, the MTA keeps it when the protocol is replaced by StartTls
+func (p *replyProtocol) GetState() *smtp.State {
+	return p.state
+}
+
+// follow keeps what the extensions need to know about the transaction
+func (p *replyProtocol) follow(cmd smtp.Cmd, command helpers.Command) {
+	state := p.GetState()
+	switch cmd := cmd.(type) {
+	case smtp.MailCmd:
+		if ok, _ := state.CanReceiveMail(); ok {
+			_, p.prdr = command.Params["PRDR"]
+			p.mailParams = command.Params
+			p.senderChecked = false
+		}
+	case smtp.RcptCmd:
+		if ok, _ := state.CanReceiveRcpt(); ok {
+			// the delivery handlers send the notifications the sender asked for
+			p.config.Dsn.Set(state.SessionId.String(), cmd.To.Address, p.mailParams, command.Params)
+		}
+	case smtp.DataCmd:
+		if ok, _ := state.CanReceiveData(); ok && p.acceptance != nil {
+			// the handlers may refuse the message for some recipients
+			p.acceptance.Prdr(state.SessionId.String(), p.prdr)
+			p.recipients = []string{}
+			for _, to := range state.To {
+				p.recipients = append(p.recipients, to.Address)
+			}
+		}
+	}
+}
+
+// handleXclient replaces the address and HELO of the client by the ones the proxy reported, and restarts the session
+// as if the client connected, like Postfix does. Other clients may not use XCLIENT.
+// It returns an error if the blacklist refuses the client, the session ends then.
+func (p *replyProtocol) handleXclient(command helpers.Command) error {
+	state := p.GetState()
+	logger := log.WithFields(log.Fields{
+		"Ip":        state.Ip.String(),
+		"SessionId": state.SessionId.String(),
+	})
+	if !p.proxy {
+		logger.Warnln("XCLIENT from a client which isn't in XclientHosts")
+		p.reply(smtp.Answer{Status: xclientUnauthorized, Message: "5.7.0 " + p.text("smtp.xclient_unauthorized")})
+		return nil
+	}
+	if state.From != nil {
+		p.reply(smtp.Answer{Status: smtp.BadSequence, Message: "5.5.1 " + p.text("smtp.xclient_transaction")})
+		return nil
+	}
+	client, err := helpers.ParseXclient(command.Params)
+	if err != nil {
+		p.reply(smtp.Answer{Status: smtp.SyntaxErrorParam, Message: "5.5.4 " + p.text("smtp.xclient_syntax", "error", err.Error())})
+		return nil
+	}
+
+	logger.Infof("XCLIENT: the proxy reported client %s (name %q, HELO %q, login %q)", client.Addr, client.Name, client.Helo, client.Login)
+	state.Reset()
+	// the session starts again, without the login of the proxy
+	if p.login != "" {
+		p.config.Logins.Forget(state.SessionId.String())
+		p.login = ""
+	}
+	if client.Addr != nil {
+		state.Ip = client.Addr
+	}
+	state.Hostname = client.Helo
+	p.xclient.Set(state.SessionId.String(), client)
+	if client.Addr != nil && p.blacklist != nil && p.blacklist.CheckIp(state.Ip.String()) {
+		p.reply(smtp.Answer{Status: smtp.NoValidRecipients, Message: "5.7.1 " + p.text("smtp.xclient_rejected")})
+		return errXclientRejected
+	}
+	p.Send(smtp.Answer{Status: smtp.Ready, Message: p.hostname + " Service Ready"})
+	return nil
+}
+
+func (p *replyProtocol) Send(cmd smtp.Cmd) {
+	if answer, ok := cmd.(smtp.MultiAnswer); ok && len(answer.Messages) > 1 && answer.Messages[0] == p.hostname {
+		// the extensions of EHLO end with OK
+		extensions := []string{"DSN"}
+		if p.acceptance != nil {
+			extensions = append(extensions, "PRDR")
+		}
+		if p.proxy {
+			extensions = append(extensions, "XCLIENT "+strings.Join(helpers.XclientAttributes, " "))
+		}
+		if mechanisms := p.authMechanisms(); len(mechanisms) > 0 {
+			extensions = append(extensions, "AUTH "+strings.Join(mechanisms, " "))
+		}
+		last := len(answer.Messages) - 1
+		answer.Messages = append(append(append([]string{}, answer.Messages[:last]...), extensions...), answer.Messages[last:]...)
+		cmd = answer
+	}
+	if answer, ok := cmd.(smtp.Answer); ok {
+		key, found := mtaReplies[answer.Message]
+		switch {
+		case answer.Status == smtp.Ready && answer.Message == p.hostname+" Service Ready":
+			if p.shed || p.config.Backpressure.Overloaded() {
+				state := p.GetState()
+				logger := log.WithFields(log.Fields{
+					"Ip":        state.Ip.String(),
+					"SessionId": state.SessionId.String(),
+				})
+				if p.shed {
+					logger.Warnln("Shedding: refused the session of an unknown client")
+				} else {
+					logger.Warnln("Backpressure: refused the session")
+				}
+				p.overloaded = true
+				p.reply(smtp.Answer{Status: overloaded, Message: "4.3.2 " + p.text("smtp.overloaded")})
+				return
+			}
+			key, found = "smtp.banner", true
+		case answer.Status == smtp.Ok && answer.Message == p.hostname:
+			key, found = "smtp.helo", true
+		}
+		if key == "smtp.data_incomplete" && p.session.Refused() {
+			state := p.GetState()
+			log.WithFields(log.Fields{
+				"Ip":        state.Ip.String(),
+				"SessionId": state.SessionId.String(),
+			}).Warnln("Smuggling: refused message with bare <CR> or <LF> line endings")
+			answer.Status, key = bareLineEnding, "smtp.bare_line_ending"
+			answer.Message = helpers.DefaultTexts[key]
+			cmd = answer
+		}
+		if key == "smtp.delivered" && p.acceptance != nil {
+			state := p.GetState()
+			rejected := p.acceptance.Rejected(state.SessionId.String())
+			if err := p.acceptance.Take(state.SessionId.String()); err != nil {
+				if e, refused := err.(*helpers.EsmtpError); refused {
+					// a handler refused the message with a reply of its own
+					p.reply(smtp.Answer{Status: smtp.StatusCode(e.Code), Message: e.Message})
+					return
+				}
+				log.WithFields(log.Fields{
+					"Ip":        state.Ip.String(),
+					"SessionId": state.SessionId.String(),
+				}).Warnf("Message not stored, the client has to retry it: %v", err)
+				answer.Status, key = localError, "smtp.not_stored"
+				answer.Message = helpers.DefaultTexts[key]
+				cmd = answer
+			} else if p.prdr {
+				p.sendPerRecipient(rejected)
+				return
+			}
+		}
+		if found && p.texts != nil {
+			// a text with line breaks would break the protocol
+			answer.Message = strings.NewReplacer("\r", " ", "\n", " ").Replace(p.texts.Text(key, "hostname", p.hostname))
+			cmd = answer
+		}
+	}
+	p.reply(cmd)
+}
+
+// reply sends a reply to a command, and counts the errors of the client: the replies with a 4xx or 5xx code,
+// except the ones which report a failure of the server
+func (p *replyProtocol) reply(cmd smtp.Cmd) {
+	if answer, ok := cmd.(smtp.Answer); ok && answer.Status >= 400 && answer.Status != smtp.ShuttingDown && answer.Status != localError {
+		p.errors++
+	}
+	p.Protocol.Send(cmd)
+}
+
+// refuse sends the refusal of a sender or recipient. With PrivateReplies, unauthenticated clients get the same
+// generic reply for every refusal, so they can't tell unknown users from policy refusals. The reason is logged.
+func (p *replyProtocol) refuse(answer smtp.Answer) {
+	if p.private() {
+		answer = smtp.Answer{Status: privateRejection, Message: "5.7.1 " + p.text("smtp.rejected")}
+	}
+	p.reply(answer)
+}
+
+// dnsblChecked reports whether the DNS blocklists apply to the client,
+// trusted clients and authenticated users skip them
+func (p *replyProtocol) dnsblChecked(state *smtp.State) bool {
+	return len(p.config.Dnsbl.Lists) > 0 && p.login == "" && !p.config.Access.Trusted(state.Ip)
+}
+
+// private reports whether the client only gets generic refusals
+func (p *replyProtocol) private() bool {
+	return p.config.PrivateReplies && !p.authenticated()
+}
+
+// authenticated reports whether the client authenticated with AUTH, or at a proxy which reported it with XCLIENT
+func (p *replyProtocol) authenticated() bool {
+	if p.login != "" {
+		return true
+	}
+	client, found := p.xclient.Get(p.GetState().SessionId.String())
+	return found && client.Login != ""
+}
+
+// maxErrors is the number of errors after which the session is closed
+func (p *replyProtocol) maxErrors() int {
+	if p.config.MaxErrors <= 0 {
+		return defaultMaxErrors
+	}
+	return p.config.MaxErrors
+}
+
+// sendPerRecipient sends the replies of PRDR after DATA: 353, a reply for each recipient
+// in the order of RCPT TO and the final reply, which only fails if all recipients refused the message
+func (p *replyProtocol) sendPerRecipient(rejected map[string]string) {
+	p.Protocol.Send(smtp.Answer{Status: prdrStart, Message: p.text("smtp.prdr_start")})
+	accepted := 0
+	for _, recipient := range p.recipients {
+		if reply, found := rejected[recipient]; found {
+			if p.private() {
+				reply = fmt.Sprintf("%d 5.7.1 %s", privateRejection, p.text("smtp.rejected"))
+			}
+			p.Protocol.Send(recipientReply(recipient, reply))
+			continue
+		}
+		accepted++
+		p.Protocol.Send(smtp.Answer{Status: smtp.Ok, Message: "2.1.5 " + p.text("smtp.prdr_accepted", "recipient", recipient)})
+	}
+	if accepted == 0 {
+		p.Protocol.Send(smtp.Answer{Status: prdrRejected, Message: "5.7.1 " + p.text("smtp.prdr_rejected")})
+		return
+	}
+	p.Protocol.Send(smtp.Answer{Status: smtp.Ok, Message: p.text("smtp.delivered")})
+}
+
+// text returns the text of a reply from the catalog, without line breaks
+func (p *replyProtocol) text(key string, variables ...string) string {
+	variables = append(variables, "hostname", p.hostname)
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(p.texts.Text(key, variables...))
+}
+
+// recipientReply is the reply of PRDR for a recipient which refused the message, with the recipient
+// after the enhanced status code, e.g. 550 5.1.1 <bob@example.com> User unknown
+func recipientReply(recipient, reply string) smtp.Answer {
+	fields := strings.SplitN(reply, " ", 3)
+	code, err := strconv.Atoi(fields[0])
+	if err != nil || code < 400 || code > 599 {
+		code = int(prdrRejected)
+	}
+	words := []string{"<" + recipient + ">"}
+	if len(fields) > 1 && strings.Count(fields[1], ".") == 2 {
+		words = []string{fields[1], words[0]}
+		fields = fields[1:]
+	}
+	words = append(words, fields[1:]...)
+	return smtp.Answer{Status: smtp.StatusCode(code), Message: strings.Join(words, " ")}
+}
@@ -0,0 +1,431 @@
+package sieve
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gopistolet/gopistolet/helpers"
+)
+
+// Message is the message a script is executed for
+type Message struct {
+	// From and To are the envelope sender and recipient
+	From string
+	To   string
+	// Delimiter separates the user and the detail of subaddresses (e.g. + in bob+lists@example.com)
+	Delimiter string
+
+	size   int
+	header map[string][]string
+}
+
+// NewMessage creates a Message from the message data and the envelope
+func NewMessage(data []byte, from, to string) *Message {
+	m := &Message{
+		From:   from,
+		To:     to,
+		size:   len(data),
+		header: make(map[string][]string),
+	}
+	fields, _ := helpers.SplitHeader(data)
+	for _, field := range fields {
+		name := strings.ToLower(helpers.FieldName(field))
+		m.header[name] = append(m.header[name], helpers.FieldValue(field))
+	}
+	return m
+}
+
+// execution is the state of the execution of a script
+type execution struct {
+	message      *Message
+	actions      []Action
+	implicitKeep bool
+	stopped      bool
+}
+
+// Execute runs the script for the message and returns the actions to take.
+// If no action cancels the implicit keep (discard, fileinto and redirect do),
+// the message is kept.
+func (s *Script) Execute(message *Message) ([]Action, error) {
+	e := &execution{message: message, implicitKeep: true}
+	if err := e.run(s.commands); err != nil {
+		return nil, err
+	}
+	if e.implicitKeep {
+		e.add(Action{Type: ActionKeep})
+	}
+	return e.actions, nil
+}
+
+// add adds the action, unless the same action was already taken
+func (e *execution) add(action Action) {
+	if action.Type != ActionVacation {
+		for _, a := range e.actions {
+			if a.Type == action.Type && a.Target == action.Target {
+				return
+			}
+		}
+	}
+	e.actions = append(e.actions, action)
+}
+
+func (e *execution) run(commands []*command) error {
+	// result of the last if/elsif, so elsif and else know whether to run
+	matched := false
+	for _, c := range commands {
+		if e.stopped {
+			return nil
+		}
+
+		switch c.name {
+		case "require":
+		case "if", "elsif":
+			if c.name == "elsif" && matched {
+				continue
+			}
+			result, err := e.test(c.tests[0])
+			if err != nil {
+				return err
+			}
+			matched = result
+			if result {
+				if err := e.run(c.block); err != nil {
+					return err
+				}
+			}
+		case "else":
+			if !matched {
+				if err := e.run(c.block); err != nil {
+					return err
+				}
+			}
+		case "stop":
+			e.stopped = true
+		case "keep":
+			e.add(Action{Type: ActionKeep})
+			e.implicitKeep = false
+		case "discard":
+			e.implicitKeep = false
+		case "fileinto", "redirect":
+			args, err := parseArgs(c.name, c.line, c.args, nil, 1)
+			if err != nil {
+				return err
+			}
+			e.add(Action{Type: c.name, Target: args.positional[0][0]})
+			e.implicitKeep = false
+		case "vacation":
+			vacation, err := parseVacation(c)
+			if err != nil {
+				return err
+			}
+			e.add(Action{Type: ActionVacation, Vacation: vacation})
+		}
+	}
+	return nil
+}
+
+func parseVacation(c *command) (*Vacation, error) {
+	args, err := parseArgs(c.name, c.line, c.args, map[string]tokenType{
+		"days":      tokenNumber,
+		"subject":   tokenString,
+		"from":      tokenString,
+		"addresses": tokenString,
+		"handle":    tokenString,
+		"mime":      tokenTag,
+	}, 1)
+	if err != nil {
+		return nil, err
+	}
+	v := &Vacation{
+		Reason:    args.positional[0][0],
+		Days:      7,
+		Addresses: args.values["addresses"].strings,
+		Mime:      args.tags["mime"],
+	}
+	if days, found := args.values["days"]; found {
+		v.Days = int(days.number)
+	}
+	if subject, found := args.values["subject"]; found {
+		v.Subject = subject.strings[0]
+	}
+	if from, found := args.values["from"]; found {
+		v.From = from.strings[0]
+	}
+	if handle, found := args.values["handle"]; found {
+		v.Handle = handle.strings[0]
+	}
+	return v, nil
+}
+
+// arguments are the parsed arguments of a command or test
+type arguments struct {
+	// tags without value (e.g. :is, :mime) and tags with a value (e.g. :days 7)
+	tags   map[string]bool
+	values map[string]argument
+	// positional string lists
+	positional [][]string
+}
+
+// parseArgs splits the arguments in tags and positional string lists.
+// Tags in withValue take the next argument (of the given type, or no argument for tokenTag).
+func parseArgs(name string, line int, args []argument, withValue map[string]tokenType, positional int) (*arguments, error) {
+	parsed := &arguments{tags: make(map[string]bool), values: make(map[string]argument)}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg.typ != tokenTag {
+			if arg.typ != tokenString {
+				return nil, fmt.Errorf("line %d: %s: unexpected number", line, name)
+			}
+			parsed.positional = append(parsed.positional, arg.strings)
+			continue
+		}
+		typ, hasValue := withValue[arg.tag]
+		if !hasValue || typ == tokenTag {
+			parsed.tags[arg.tag] = true
+			continue
+		}
+		if i+1 >= len(args) || args[i+1].typ != typ {
+			return nil, fmt.Errorf("line %d: %s: missing value for :%s", line, name, arg.tag)
+		}
+		parsed.values[arg.tag] = args[i+1]
+		i++
+	}
+	if len(parsed.positional) != positional {
+		return nil, fmt.Errorf("line %d: %s expects %d arguments", line, name, positional)
+	}
+	return parsed, nil
+}
+
+func (e *execution) test(t *test) (bool, error) {
+	switch t.name {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "not":
+		if len(t.tests) != 1 {
+			return false, fmt.Errorf("line %d: not expects one test", t.line)
+		}
+		result, err := e.test(t.tests[0])
+		return !result, err
+	case "allof", "anyof":
+		for _, sub := range t.tests {
+			result, err := e.test(sub)
+			if err != nil {
+				return false, err
+			}
+			if t.name == "anyof" && result {
+				return true, nil
+			}
+			if t.name == "allof" && !result {
+				return false, nil
+			}
+		}
+		return t.name == "allof", nil
+	case "exists":
+		args, err := parseArgs(t.name, t.line, t.args, nil, 1)
+		if err != nil {
+			return false, err
+		}
+		for _, name := range args.positional[0] {
+			if len(e.message.header[strings.ToLower(name)]) == 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "size":
+		over, under := false, false
+		var limit int64 = -1
+		for _, arg := range t.args {
+			switch {
+			case arg.typ == tokenTag && arg.tag == "over":
+				over = true
+			case arg.typ == tokenTag && arg.tag == "under":
+				under = true
+			case arg.typ == tokenNumber:
+				limit = arg.number
+			}
+		}
+		if over == under || limit < 0 {
+			return false, fmt.Errorf("line %d: size expects :over or :under and a number", t.line)
+		}
+		if over {
+			return int64(e.message.size) > limit, nil
+		}
+		return int64(e.message.size) < limit, nil
+	case "header", "address", "envelope":
+		return e.compareTest(t)
+	}
+	return false, fmt.Errorf("line %d: unknown test %s", t.line, t.name)
+}
+
+// compareTest runs the header, address and envelope tests
+func (e *execution) compareTest(t *test) (bool, error) {
+	args, err := parseArgs(t.name, t.line, t.args, map[string]tokenType{"comparator": tokenString}, 2)
+	if err != nil {
+		return false, err
+	}
+
+	matchType := "is"
+	for _, tag := range []string{"is", "contains", "matches"} {
+		if args.tags[tag] {
+			matchType = tag
+		}
+	}
+	addressPart := "all"
+	for _, tag := range []string{"all", "localpart", "domain", "user", "detail"} {
+		if args.tags[tag] {
+			addressPart = tag
+		}
+	}
+	comparator := "i;ascii-casemap"
+	if c, found := args.values["comparator"]; found {
+		comparator = c.strings[0]
+		if comparator != "i;ascii-casemap" && comparator != "i;octet" {
+			return false, fmt.Errorf("line %d: unsupported comparator %s", t.line, comparator)
+		}
+	}
+
+	values := []string{}
+	addPart := func(address string) {
+		if value, found := part(address, addressPart, e.message.Delimiter); found {
+			values = append(values, value)
+		}
+	}
+	for _, name := range args.positional[0] {
+		switch t.name {
+		case "header":
+			values = append(values, e.message.header[strings.ToLower(name)]...)
+		case "address":
+			for _, value := range e.message.header[strings.ToLower(name)] {
+				for _, address := range parseAddresses(value) {
+					addPart(address)
+				}
+			}
+		case "envelope":
+			switch strings.ToLower(name) {
+			case "from":
+				addPart(e.message.From)
+			case "to":
+				addPart(e.message.To)
+			}
+		}
+	}
+
+	for _, value := range values {
+		for _, key := range args.positional[1] {
+			if match(matchType, comparator, value, key) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// parseAddresses returns the addresses in a header value, or the value itself if it can't be parsed
+func parseAddresses(value string) []string {
+	list, err := mail.ParseAddressList(value)
+	if err != nil {
+		return []string{value}
+	}
+	addresses := []string{}
+	for _, a := range list {
+		addresses = append(addresses, a.Address)
+	}
+	return addresses
+}
+
+// part returns the part of the address (all, localpart, domain, user or detail),
+// false is returned for the detail of an address without one, which matches nothing
+func part(address, addressPart, delimiter string) (string, bool) {
+	local := address
+	i := strings.LastIndexByte(address, '@')
+	if i >= 0 {
+		local = address[:i]
+	}
+	switch addressPart {
+	case "localpart":
+		return local, true
+	case "domain":
+		if i < 0 {
+			return "", true
+		}
+		return address[i+1:], true
+	case "user", "detail":
+		j := -1
+		if delimiter != "" {
+			j = strings.Index(local, delimiter)
+		}
+		if addressPart == "user" {
+			if j > 0 {
+				return local[:j], true
+			}
+			return local, true
+		}
+		if j <= 0 {
+			return "", false
+		}
+		return local[j+len(delimiter):], true
+	}
+	return address, true
+}
+
+// match compares the value with the key
+func match(matchType, comparator, value, key string) bool {
+	if comparator == "i;ascii-casemap" {
+		value, key = asciiLower(value), asciiLower(key)
+	}
+	switch matchType {
+	case "contains":
+		return strings.Contains(value, key)
+	case "matches":
+		return wildcard(value, key)
+	}
+	return value == key
+}
+
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+// wildcard matches the value with a pattern in which * matches any sequence of characters,
+// ? matches one character and \ escapes the next character
+func wildcard(value, pattern string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(value); i++ {
+				if wildcard(value[i:], pattern) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if value == "" {
+				return false
+			}
+			_, size := utf8.DecodeRuneInString(value)
+			value, pattern = value[size:], pattern[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if value == "" || value[0] != pattern[0] {
+				return false
+			}
+			value, pattern = value[1:], pattern[1:]
+		}
+	}
+	return value == ""
+}
@@ -0,0 +1,188 @@
+package sieve
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokenIdentifier tokenType = iota
+	tokenTag
+	tokenNumber
+	tokenString
+	tokenSpecial // ; , ( ) [ ] { }
+	tokenEOF
+)
+
+type token struct {
+	typ    tokenType
+	text   string
+	number int64
+	line   int
+}
+
+// lex splits a script in tokens (RFC 5228 section 8.1)
+func lex(script string) ([]token, error) {
+	tokens := []token{}
+	line := 1
+	i := 0
+	for i < len(script) {
+		c := script[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		// comments
+		case c == '#':
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(script[i:i+2+end], "\n")
+			i += 2 + end + 2
+
+		case strings.ContainsRune(";,()[]{}", rune(c)):
+			tokens = append(tokens, token{typ: tokenSpecial, text: string(c), line: line})
+			i++
+
+		case c == '"':
+			s, n, err := quotedString(script[i:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			tokens = append(tokens, token{typ: tokenString, text: s, line: line})
+			line += strings.Count(script[i:i+n], "\n")
+			i += n
+
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(script) && script[i] >= '0' && script[i] <= '9' {
+				i++
+			}
+			number, err := strconv.ParseInt(script[start:i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid number: %v", line, err)
+			}
+			if i < len(script) {
+				switch script[i] {
+				case 'K', 'k':
+					number, i = number<<10, i+1
+				case 'M', 'm':
+					number, i = number<<20, i+1
+				case 'G', 'g':
+					number, i = number<<30, i+1
+				}
+			}
+			tokens = append(tokens, token{typ: tokenNumber, number: number, line: line})
+
+		case c == ':' || isIdentifierStart(c):
+			start := i
+			if c == ':' {
+				i++
+			}
+			for i < len(script) && isIdentifierChar(script[i]) {
+				i++
+			}
+			word := script[start:i]
+
+			// multi-line string: text: CRLF lines . CRLF
+			if word == "text" && i < len(script) && script[i] == ':' {
+				s, n, err := multiLineString(script[i+1:])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", line, err)
+				}
+				tokens = append(tokens, token{typ: tokenString, text: s, line: line})
+				line += strings.Count(script[i+1:i+1+n], "\n")
+				i += 1 + n
+				continue
+			}
+
+			if c == ':' {
+				if len(word) == 1 {
+					return nil, fmt.Errorf("line %d: empty tag", line)
+				}
+				tokens = append(tokens, token{typ: tokenTag, text: strings.ToLower(word[1:]), line: line})
+			} else {
+				tokens = append(tokens, token{typ: tokenIdentifier, text: strings.ToLower(word), line: line})
+			}
+
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+		}
+	}
+	tokens = append(tokens, token{typ: tokenEOF, line: line})
+	return tokens, nil
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierChar(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9')
+}
+
+// quotedString parses a quoted string, it returns the string and the number of bytes consumed
+func quotedString(s string) (string, int, error) {
+	b := strings.Builder{}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			// only \" and \\ are defined, other escaped characters are taken literally
+			if i+1 < len(s) {
+				i++
+			}
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// multiLineString parses the rest of a text: string, lines starting with a dot are dot-stuffed
+func multiLineString(s string) (string, int, error) {
+	// the rest of the line after text: may only contain whitespace or a comment
+	end := strings.IndexByte(s, '\n')
+	if end < 0 {
+		return "", 0, fmt.Errorf("unterminated multi-line string")
+	}
+	rest := strings.TrimSpace(s[:end])
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", 0, fmt.Errorf("unexpected %q after text:", rest)
+	}
+
+	b := strings.Builder{}
+	i := end + 1
+	for i < len(s) {
+		end := strings.IndexByte(s[i:], '\n')
+		if end < 0 {
+			break
+		}
+		line := strings.TrimSuffix(s[i:i+end], "\r")
+		i += end + 1
+		if line == "." {
+			return b.String(), i, nil
+		}
+		if strings.HasPrefix(line, "..") {
+			line = line[1:]
+		}
+		b.WriteString(line + "\r\n")
+	}
+	if strings.TrimRight(s[i:], "\r") == "." {
+		return b.String(), len(s), nil
+	}
+	return "", 0, fmt.Errorf("unterminated multi-line string")
+}
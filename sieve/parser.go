@@ -0,0 +1,187 @@
+package sieve
+
+import (
+	"fmt"
+)
+
+// command is a command with its arguments, tests and block (RFC 5228 section 8.2)
+type command struct {
+	name  string
+	args  []argument
+	tests []*test
+	block []*command
+	line  int
+}
+
+type test struct {
+	name  string
+	args  []argument
+	tests []*test
+	line  int
+}
+
+// argument is a tag, a number or a string list (a single string is a list of one string)
+type argument struct {
+	tag     string
+	number  int64
+	strings []string
+	typ     tokenType
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) special(s string) bool {
+	t := p.peek()
+	return t.typ == tokenSpecial && t.text == s
+}
+
+func (p *parser) expect(s string) error {
+	t := p.next()
+	if t.typ != tokenSpecial || t.text != s {
+		return fmt.Errorf("line %d: expected '%s'", t.line, s)
+	}
+	return nil
+}
+
+// commands parses commands until the end of the block or script
+func (p *parser) commands() ([]*command, error) {
+	commands := []*command{}
+	for {
+		t := p.peek()
+		if t.typ == tokenEOF || (t.typ == tokenSpecial && t.text == "}") {
+			return commands, nil
+		}
+		if t.typ != tokenIdentifier {
+			return nil, fmt.Errorf("line %d: expected command", t.line)
+		}
+		p.next()
+
+		c := &command{name: t.text, line: t.line}
+		args, tests, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		c.args, c.tests = args, tests
+
+		if p.special("{") {
+			p.next()
+			c.block, err = p.commands()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("}"); err != nil {
+				return nil, err
+			}
+		} else if err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		commands = append(commands, c)
+	}
+}
+
+// arguments parses the arguments followed by an optional test or test list
+func (p *parser) arguments() ([]argument, []*test, error) {
+	args := []argument{}
+	for {
+		t := p.peek()
+		switch {
+		case t.typ == tokenTag:
+			p.next()
+			args = append(args, argument{typ: tokenTag, tag: t.text})
+		case t.typ == tokenNumber:
+			p.next()
+			args = append(args, argument{typ: tokenNumber, number: t.number})
+		case t.typ == tokenString:
+			p.next()
+			args = append(args, argument{typ: tokenString, strings: []string{t.text}})
+		case t.typ == tokenSpecial && t.text == "[":
+			p.next()
+			list, err := p.stringList()
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, argument{typ: tokenString, strings: list})
+		case t.typ == tokenIdentifier:
+			t, err := p.test()
+			if err != nil {
+				return nil, nil, err
+			}
+			return args, []*test{t}, nil
+		case t.typ == tokenSpecial && t.text == "(":
+			tests, err := p.testList()
+			if err != nil {
+				return nil, nil, err
+			}
+			return args, tests, nil
+		default:
+			return args, nil, nil
+		}
+	}
+}
+
+// stringList parses the rest of a string list after the [
+func (p *parser) stringList() ([]string, error) {
+	list := []string{}
+	for {
+		t := p.next()
+		if t.typ != tokenString {
+			return nil, fmt.Errorf("line %d: expected string in string list", t.line)
+		}
+		list = append(list, t.text)
+		if p.special("]") {
+			p.next()
+			return list, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) test() (*test, error) {
+	t := p.next()
+	if t.typ != tokenIdentifier {
+		return nil, fmt.Errorf("line %d: expected test", t.line)
+	}
+	args, tests, err := p.arguments()
+	if err != nil {
+		return nil, err
+	}
+	return &test{name: t.text, args: args, tests: tests, line: t.line}, nil
+}
+
+func (p *parser) testList() ([]*test, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	tests := []*test{}
+	for {
+		t, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, t)
+		if p.special(")") {
+			p.next()
+			return tests, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
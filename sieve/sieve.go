@@ -0,0 +1,177 @@
+// Package sieve implements a subset of the Sieve mail filtering language (RFC 5228):
+// the control commands, keep, discard, redirect, fileinto, vacation (RFC 5230)
+// and the address, envelope, header, exists, size, allof, anyof, not, true and false tests,
+// with the :user and :detail address parts of subaddress (RFC 5233).
+package sieve
+
+import (
+	"fmt"
+)
+
+// Action types
+const (
+	ActionKeep     = "keep"
+	ActionDiscard  = "discard"
+	ActionFileInto = "fileinto"
+	ActionRedirect = "redirect"
+	ActionVacation = "vacation"
+)
+
+// Action is the result of executing a script
+type Action struct {
+	Type string
+	// Target is the folder for fileinto and the address for redirect
+	Target string
+	// Vacation contains the parameters of the vacation action
+	Vacation *Vacation
+}
+
+// Vacation contains the parameters of a vacation action (RFC 5230)
+type Vacation struct {
+	Reason    string
+	Days      int
+	Subject   string
+	From      string
+	Addresses []string
+	Handle    string
+	Mime      bool
+}
+
+// extensions are the supported extensions (for require)
+var extensions = map[string]bool{
+	"fileinto":   true,
+	"envelope":   true,
+	"vacation":   true,
+	"subaddress": true,
+}
+
+// knownCommands lists the supported commands and the extension they require
+var knownCommands = map[string]string{
+	"require":  "",
+	"if":       "",
+	"elsif":    "",
+	"else":     "",
+	"stop":     "",
+	"keep":     "",
+	"discard":  "",
+	"redirect": "",
+	"fileinto": "fileinto",
+	"vacation": "vacation",
+}
+
+// knownTests lists the supported tests and the extension they require
+var knownTests = map[string]string{
+	"address":  "",
+	"header":   "",
+	"exists":   "",
+	"size":     "",
+	"allof":    "",
+	"anyof":    "",
+	"not":      "",
+	"true":     "",
+	"false":    "",
+	"envelope": "envelope",
+}
+
+// knownTags lists the tags which require an extension
+var knownTags = map[string]string{
+	"user":   "subaddress",
+	"detail": "subaddress",
+}
+
+// Script is a parsed Sieve script
+type Script struct {
+	commands []*command
+}
+
+// Parse parses and validates a script
+func Parse(script string) (*Script, error) {
+	tokens, err := lex(script)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	commands, err := p.commands()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokenEOF {
+		return nil, fmt.Errorf("line %d: unexpected '%s'", t.line, t.text)
+	}
+
+	required := make(map[string]bool)
+	if err := validate(commands, required, true); err != nil {
+		return nil, err
+	}
+	return &Script{commands: commands}, nil
+}
+
+// validate checks that all commands and tests are known and their extensions are required,
+// require is only allowed at the start of the script
+func validate(commands []*command, required map[string]bool, top bool) error {
+	previous := ""
+	for i, c := range commands {
+		extension, known := knownCommands[c.name]
+		if !known {
+			return fmt.Errorf("line %d: unknown command %s", c.line, c.name)
+		}
+		if extension != "" && !required[extension] {
+			return fmt.Errorf("line %d: %s requires \"%s\"", c.line, c.name, extension)
+		}
+
+		switch c.name {
+		case "require":
+			if !top || (i > 0 && previous != "require") {
+				return fmt.Errorf("line %d: require must be at the start of the script", c.line)
+			}
+			if len(c.args) != 1 || c.args[0].typ != tokenString {
+				return fmt.Errorf("line %d: require expects a string list", c.line)
+			}
+			for _, name := range c.args[0].strings {
+				if !extensions[name] {
+					return fmt.Errorf("line %d: unsupported extension \"%s\"", c.line, name)
+				}
+				required[name] = true
+			}
+		case "elsif", "else":
+			if previous != "if" && previous != "elsif" {
+				return fmt.Errorf("line %d: %s without if", c.line, c.name)
+			}
+		}
+
+		if (c.name == "if" || c.name == "elsif") && len(c.tests) != 1 {
+			return fmt.Errorf("line %d: %s expects one test", c.line, c.name)
+		}
+		for _, t := range c.tests {
+			if err := validateTest(t, required); err != nil {
+				return err
+			}
+		}
+		if err := validate(c.block, required, false); err != nil {
+			return err
+		}
+		previous = c.name
+	}
+	return nil
+}
+
+func validateTest(t *test, required map[string]bool) error {
+	extension, known := knownTests[t.name]
+	if !known {
+		return fmt.Errorf("line %d: unknown test %s", t.line, t.name)
+	}
+	if extension != "" && !required[extension] {
+		return fmt.Errorf("line %d: %s requires \"%s\"", t.line, t.name, extension)
+	}
+	for _, arg := range t.args {
+		if extension := knownTags[arg.tag]; arg.typ == tokenTag && extension != "" && !required[extension] {
+			return fmt.Errorf("line %d: :%s requires \"%s\"", t.line, arg.tag, extension)
+		}
+	}
+	for _, sub := range t.tests {
+		if err := validateTest(sub, required); err != nil {
+			return err
+		}
+	}
+	return nil
+}
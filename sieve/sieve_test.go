@@ -0,0 +1,189 @@
+package sieve
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParse(t *testing.T) {
+
+	Convey("Testing valid scripts", t, func() {
+		scripts := []string{
+			`keep;`,
+			`# comment
+			/* multi-line
+			   comment */
+			require ["fileinto", "envelope"];
+			if header :contains "subject" "spam" { fileinto "Junk"; } elsif true { keep; } else { discard; }`,
+			`require "vacation";
+			vacation :days 3 :subject "Away" text:
+I'm away.
+..dot
+.
+;`,
+			`if size :over 100K { discard; stop; }`,
+			`require ["envelope", "subaddress"]; if envelope :detail "to" "lists" { keep; }`,
+		}
+		for _, script := range scripts {
+			_, err := Parse(script)
+			So(err, ShouldEqual, nil)
+		}
+	})
+
+	Convey("Testing invalid scripts", t, func() {
+		scripts := []string{
+			`keep`,
+			`unknown;`,
+			`fileinto "Junk";`,
+			`keep; require "fileinto";`,
+			`require "imap4flags";`,
+			`if address :user "to" "bob" { keep; }`,
+			`else { keep; }`,
+			`if { keep; }`,
+			`if header "subject" "x" { keep;`,
+			`if "subject" "x";`,
+			`redirect "unterminated;`,
+			`/* unterminated`,
+		}
+		for _, script := range scripts {
+			_, err := Parse(script)
+			So(err, ShouldNotEqual, nil)
+		}
+	})
+
+}
+
+func TestExecute(t *testing.T) {
+
+	data := []byte("From: Alice <alice@example.com>\r\n" +
+		"To: bob@example.org, carol@example.org\r\n" +
+		"Subject: [SPAM] Cheap\r\n" +
+		" offers\r\n" +
+		"List-Id: <news.example.com>\r\n" +
+		"\r\n" +
+		"Body\r\n")
+	message := NewMessage(data, "bounce@example.com", "bob@example.org")
+
+	execute := func(script string) []Action {
+		s, err := Parse(script)
+		So(err, ShouldEqual, nil)
+		actions, err := s.Execute(message)
+		So(err, ShouldEqual, nil)
+		return actions
+	}
+
+	Convey("Testing implicit keep", t, func() {
+		So(execute(`if false { discard; }`), ShouldResemble, []Action{{Type: ActionKeep}})
+		So(execute(`discard;`), ShouldBeEmpty)
+		So(execute(`require "fileinto"; fileinto "A"; fileinto "A"; keep;`), ShouldResemble, []Action{
+			{Type: ActionFileInto, Target: "A"},
+			{Type: ActionKeep},
+		})
+		So(execute(`redirect "dave@example.net";`), ShouldResemble, []Action{
+			{Type: ActionRedirect, Target: "dave@example.net"},
+		})
+		So(execute(`stop; discard;`), ShouldResemble, []Action{{Type: ActionKeep}})
+	})
+
+	Convey("Testing if, elsif and else", t, func() {
+		script := `require "fileinto";
+			if header :is "subject" "nope" { fileinto "A"; }
+			elsif header :contains "subject" "spam" { fileinto "B"; }
+			elsif true { fileinto "C"; }
+			else { fileinto "D"; }`
+		So(execute(script), ShouldResemble, []Action{{Type: ActionFileInto, Target: "B"}})
+	})
+
+	Convey("Testing tests", t, func() {
+		matches := func(test string) bool {
+			return len(execute(`if `+test+` { discard; }`)) == 0
+		}
+
+		So(matches(`header :contains "Subject" "cheap offers"`), ShouldEqual, true)
+		So(matches(`header :is :comparator "i;octet" "subject" "[spam] cheap offers"`), ShouldEqual, false)
+		So(matches(`header :matches "subject" "\\[SPAM\\]*"`), ShouldEqual, true)
+		So(matches(`header :matches "subject" "?SPAM?*"`), ShouldEqual, true)
+		So(matches(`header :matches "subject" "SPAM*"`), ShouldEqual, false)
+		So(matches(`header ["x-spam", "list-id"] :contains "news"`), ShouldEqual, true)
+
+		So(matches(`address :is "from" "alice@example.com"`), ShouldEqual, true)
+		So(matches(`address :domain "to" "example.org"`), ShouldEqual, true)
+		So(matches(`address :localpart "to" "carol"`), ShouldEqual, true)
+		So(matches(`address :localpart "to" "dave"`), ShouldEqual, false)
+
+		So(matches(`exists ["from", "list-id"]`), ShouldEqual, true)
+		So(matches(`exists ["from", "x-spam"]`), ShouldEqual, false)
+
+		So(matches(`size :over 10`), ShouldEqual, true)
+		So(matches(`size :under 1K`), ShouldEqual, true)
+		So(matches(`size :over 1M`), ShouldEqual, false)
+
+		So(matches(`allof (true, exists "from")`), ShouldEqual, true)
+		So(matches(`allof (true, false)`), ShouldEqual, false)
+		So(matches(`anyof (false, true)`), ShouldEqual, true)
+		So(matches(`not anyof (false, false)`), ShouldEqual, true)
+
+		s, err := Parse(`require "envelope"; if envelope :domain "from" "example.com" { discard; }`)
+		So(err, ShouldEqual, nil)
+		actions, err := s.Execute(message)
+		So(err, ShouldEqual, nil)
+		So(actions, ShouldBeEmpty)
+	})
+
+	Convey("Testing vacation", t, func() {
+		actions := execute(`require "vacation";
+			vacation :days 3 :subject "Away" :addresses ["bob@example.org"] "I'm away.";`)
+		So(len(actions), ShouldEqual, 2)
+		So(actions[0].Type, ShouldEqual, ActionVacation)
+		So(actions[0].Vacation, ShouldResemble, &Vacation{
+			Reason:    "I'm away.",
+			Days:      3,
+			Subject:   "Away",
+			Addresses: []string{"bob@example.org"},
+		})
+		So(actions[1].Type, ShouldEqual, ActionKeep)
+	})
+
+	Convey("Testing subaddresses", t, func() {
+		matches := func(address, test string) bool {
+			s, err := Parse(`require ["envelope", "subaddress"]; if ` + test + ` { discard; }`)
+			So(err, ShouldEqual, nil)
+			m := NewMessage(data, "bounce@example.com", address)
+			m.Delimiter = "+"
+			actions, err := s.Execute(m)
+			So(err, ShouldEqual, nil)
+			return len(actions) == 0
+		}
+		So(matches("bob+lists@example.org", `envelope :user "to" "bob"`), ShouldEqual, true)
+		So(matches("bob+lists@example.org", `envelope :detail "to" "lists"`), ShouldEqual, true)
+		So(matches("bob+@example.org", `envelope :detail "to" ""`), ShouldEqual, true)
+		So(matches("bob@example.org", `envelope :user "to" "bob"`), ShouldEqual, true)
+		// an address without a detail doesn't match any detail
+		So(matches("bob@example.org", `envelope :detail "to" ""`), ShouldEqual, false)
+		So(matches("bob@example.org", `envelope :detail "to" "*"`), ShouldEqual, false)
+		So(matches("bob@example.org", `not envelope :detail "to" "*"`), ShouldEqual, true)
+	})
+
+	Convey("Testing runtime errors", t, func() {
+		s, err := Parse(`if size 10 { keep; }`)
+		So(err, ShouldEqual, nil)
+		_, err = s.Execute(message)
+		So(err, ShouldNotEqual, nil)
+	})
+
+}
+
+func TestWildcard(t *testing.T) {
+
+	Convey("Testing wildcard()", t, func() {
+		So(wildcard("hello", "h*o"), ShouldEqual, true)
+		So(wildcard("hello", "h?llo"), ShouldEqual, true)
+		So(wildcard("hello", "*"), ShouldEqual, true)
+		So(wildcard("", "*"), ShouldEqual, true)
+		So(wildcard("hello", "h?lo"), ShouldEqual, false)
+		So(wildcard("a*b", "a\\*b"), ShouldEqual, true)
+		So(wildcard("axb", "a\\*b"), ShouldEqual, false)
+	})
+
+}
@@ -0,0 +1,271 @@
+// Package smtptest contains an in-memory smtp.Protocol and a scripted client,
+// to test handlers and policies with full SMTP sessions without sockets
+package smtptest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/smtp/mta"
+	"github.com/gopistolet/smtp/smtp"
+)
+
+// Timeout is how long the Client waits for a reply of the server
+var Timeout = 10 * time.Second
+
+// Protocol is an in-memory smtp.Protocol: the server reads the commands a Client sends,
+// and its replies are read by the Client
+type Protocol struct {
+	// TlsError is returned by StartTls, the session is "secure" after STARTTLS if it's nil
+	TlsError error
+
+	ip      net.IP
+	state   smtp.State
+	cmds    chan smtp.Cmd
+	replies chan smtp.Cmd
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// NewProtocol returns a Protocol for a client with the IP ip (127.0.0.1 if it's empty)
+func NewProtocol(ip string) *Protocol {
+	if ip == "" {
+		ip = "127.0.0.1"
+	}
+	return &Protocol{
+		ip:      net.ParseIP(ip),
+		cmds:    make(chan smtp.Cmd),
+		replies: make(chan smtp.Cmd),
+		closed:  make(chan struct{}),
+	}
+}
+
+// Send passes a reply of the server to the client
+func (p *Protocol) Send(cmd smtp.Cmd) {
+	select {
+	case p.replies <- cmd:
+	case <-p.closed:
+	}
+}
+
+// GetCmd waits for the next command of the client, it returns io.EOF when the client is closed
+func (p *Protocol) GetCmd() (*smtp.Cmd, error) {
+	select {
+	case cmd, ok := <-p.cmds:
+		if !ok {
+			return nil, io.EOF
+		}
+		return &cmd, nil
+	case <-p.closed:
+		return nil, io.EOF
+	}
+}
+
+// Close ends the session
+func (p *Protocol) Close() {
+	p.once.Do(func() { close(p.closed) })
+}
+
+// StartTls returns TlsError
+func (p *Protocol) StartTls(c *tls.Config) error {
+	return p.TlsError
+}
+
+// GetIP returns the IP of the client
+func (p *Protocol) GetIP() net.IP {
+	return p.ip
+}
+
+// GetState returns the state of the session
+func (p *Protocol) GetState() *smtp.State {
+	return &p.state
+}
+
+// Reply is a reply of the server, Code is 0 if the server didn't reply
+type Reply struct {
+	Code  int
+	Lines []string
+}
+
+func (r Reply) String() string {
+	if r.Code == 0 {
+		return "no reply"
+	}
+	return fmt.Sprintf("%d %s", r.Code, strings.Join(r.Lines, " / "))
+}
+
+// Client sends commands over a Protocol and reads the replies, one at a time
+type Client struct {
+	// Greeting is the reply with which the server opened the session
+	Greeting Reply
+
+	proto *Protocol
+}
+
+// Dial starts a session of a client with the IP ip with an MTA,
+// which passes the messages it accepts to handler
+func Dial(config mta.Config, handler mta.Handler, ip string) *Client {
+	p := NewProtocol(ip)
+	go mta.New(config, handler).HandleClient(p)
+	return NewClient(p)
+}
+
+// NewClient returns a client for a session on p which is served already, it reads the greeting
+func NewClient(p *Protocol) *Client {
+	c := &Client{proto: p}
+	c.Greeting = c.reply()
+	return c
+}
+
+// State returns the state of the session on the server. The server resets it after it replied
+// to a message, use a Recorder to check the messages.
+func (c *Client) State() *smtp.State {
+	return c.proto.GetState()
+}
+
+func (c *Client) reply() Reply {
+	select {
+	case cmd := <-c.proto.replies:
+		switch answer := cmd.(type) {
+		case smtp.Answer:
+			return Reply{Code: int(answer.Status), Lines: []string{answer.Message}}
+		case smtp.MultiAnswer:
+			return Reply{Code: int(answer.Status), Lines: answer.Messages}
+		}
+		return Reply{Lines: []string{cmd.String()}}
+	case <-c.proto.closed:
+		return Reply{}
+	case <-time.After(Timeout):
+		return Reply{}
+	}
+}
+
+// Cmd sends a command and returns the reply
+func (c *Client) Cmd(cmd smtp.Cmd) Reply {
+	select {
+	case c.proto.cmds <- cmd:
+	case <-c.proto.closed:
+		return Reply{}
+	}
+	return c.reply()
+}
+
+func (c *Client) Helo(domain string) Reply {
+	return c.Cmd(smtp.HeloCmd{Domain: domain})
+}
+
+func (c *Client) Ehlo(domain string) Reply {
+	return c.Cmd(smtp.EhloCmd{Domain: domain})
+}
+
+// Mail starts a transaction, from is "" for the null sender
+func (c *Client) Mail(from string) Reply {
+	return c.Cmd(smtp.MailCmd{From: &smtp.MailAddress{Address: from}})
+}
+
+func (c *Client) Rcpt(to string) Reply {
+	return c.Cmd(smtp.RcptCmd{To: &smtp.MailAddress{Address: to}})
+}
+
+// Data sends the message, dot-stuffed and with CRLF line endings.
+// It returns the reply to the message, or the reply to DATA if the server refused it.
+func (c *Client) Data(data []byte) Reply {
+	var buffer bytes.Buffer
+	w := textproto.NewWriter(bufio.NewWriter(&buffer)).DotWriter()
+	w.Write(data)
+	w.Close()
+
+	reply := c.Cmd(smtp.DataCmd{R: *smtp.NewDataReader(bufio.NewReader(&buffer))})
+	if reply.Code != int(smtp.StartData) {
+		return reply
+	}
+	return c.reply()
+}
+
+func (c *Client) Rset() Reply {
+	return c.Cmd(smtp.RsetCmd{})
+}
+
+func (c *Client) StartTls() Reply {
+	return c.Cmd(smtp.StartTlsCmd{})
+}
+
+// Quit ends the session with QUIT
+func (c *Client) Quit() Reply {
+	return c.Cmd(smtp.QuitCmd{})
+}
+
+// Close ends the session without QUIT, like a client which drops the connection
+func (c *Client) Close() {
+	c.proto.Close()
+}
+
+// Send sends a message in one transaction, it fails if one of the commands is refused
+func (c *Client) Send(from string, to []string, data []byte) error {
+	script := []Step{{smtp.MailCmd{From: &smtp.MailAddress{Address: from}}, 250}}
+	for _, recipient := range to {
+		script = append(script, Step{smtp.RcptCmd{To: &smtp.MailAddress{Address: recipient}}, 250})
+	}
+	if err := c.Run(script...); err != nil {
+		return err
+	}
+	if reply := c.Data(data); reply.Code != 250 {
+		return fmt.Errorf("DATA: %s", reply)
+	}
+	return nil
+}
+
+// Step of a script: a command and the reply code the server should answer with
+type Step struct {
+	Cmd  smtp.Cmd
+	Code int
+}
+
+// Run sends the commands of the script, it stops at the first reply with another code than expected
+func (c *Client) Run(script ...Step) error {
+	for i, step := range script {
+		if reply := c.Cmd(step.Cmd); reply.Code != step.Code {
+			return fmt.Errorf("step %d (%T): expected %d, got %s", i, step.Cmd, step.Code, reply)
+		}
+	}
+	return nil
+}
+
+// Recorder is an mta.Handler which passes the messages to Handler (if it isn't nil),
+// and records the states after Handler handled them
+type Recorder struct {
+	Handler mta.Handler
+
+	mutex  sync.Mutex
+	states []smtp.State
+}
+
+func (r *Recorder) Handle(state *smtp.State) {
+	if r.Handler != nil {
+		r.Handler.Handle(state)
+	}
+
+	// the MTA resets the state after the handler
+	handled := *state
+	handled.To = append([]*smtp.MailAddress{}, state.To...)
+	handled.Data = append([]byte{}, state.Data...)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.states = append(r.states, handled)
+}
+
+// States returns the recorded states
+func (r *Recorder) States() []smtp.State {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]smtp.State{}, r.states...)
+}
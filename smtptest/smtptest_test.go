@@ -0,0 +1,72 @@
+package smtptest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gopistolet/smtp/mta"
+	"github.com/gopistolet/smtp/smtp"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSession(t *testing.T) {
+
+	Convey("Testing a session over the in-memory Protocol", t, func() {
+		config := mta.Config{Hostname: "mx.example.com"}
+		recorder := &Recorder{Handler: mta.HandlerFunc(func(state *smtp.State) {
+			// a policy which drops one of the recipients
+			to := []*smtp.MailAddress{}
+			for _, recipient := range state.To {
+				if recipient.Address != "spamtrap@example.com" {
+					to = append(to, recipient)
+				}
+			}
+			state.To = to
+		})}
+
+		c := Dial(config, recorder, "192.0.2.1")
+		So(c.Greeting.Code, ShouldEqual, 220)
+		So(c.Greeting.String(), ShouldContainSubstring, "mx.example.com")
+
+		reply := c.Ehlo("client.example.org")
+		So(reply.Code, ShouldEqual, 250)
+		So(reply.Lines, ShouldContain, "8BITMIME")
+		So(c.State().Hostname, ShouldEqual, "client.example.org")
+
+		err := c.Send("alice@example.org", []string{"bob@example.com", "spamtrap@example.com"}, []byte("Subject: test\r\n\r\n.hidden\r\nHello\r\n"))
+		So(err, ShouldBeNil)
+
+		// commands out of sequence
+		So(c.Rcpt("bob@example.com").Code, ShouldEqual, 503)
+		err = c.Run(Step{smtp.DataCmd{}, 250})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "503")
+
+		// no TLS config
+		So(c.StartTls().Code, ShouldEqual, 502)
+
+		So(c.Quit().Code, ShouldEqual, 221)
+		// the session is over
+		So(c.Helo("client.example.org").Code, ShouldEqual, 0)
+
+		states := recorder.States()
+		So(len(states), ShouldEqual, 1)
+		So(states[0].From.Address, ShouldEqual, "alice@example.org")
+		So(len(states[0].To), ShouldEqual, 1)
+		So(states[0].To[0].Address, ShouldEqual, "bob@example.com")
+		So(states[0].Ip.String(), ShouldEqual, "192.0.2.1")
+		// the dot-stuffing is undone by the server
+		So(strings.Contains(string(states[0].Data), "\n.hidden\n"), ShouldBeTrue)
+	})
+
+	Convey("Testing a client which drops the connection", t, func() {
+		recorder := &Recorder{}
+		c := Dial(mta.Config{Hostname: "mx.example.com"}, recorder, "")
+		So(c.Mail("alice@example.org").Code, ShouldEqual, 250)
+		c.Close()
+		So(c.Rcpt("bob@example.com").Code, ShouldEqual, 0)
+		So(len(recorder.States()), ShouldEqual, 0)
+	})
+
+}
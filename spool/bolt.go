@@ -0,0 +1,240 @@
+package spool
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gopistolet/gopistolet/helpers"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltFile is the name of the database in the directory of the queue
+const BoltFile = "queue.db"
+
+// Buckets of the database
+var (
+	// entries contains the Entry of every message by id
+	entriesBucket = []byte("entries")
+	// order contains the ids by class rank and id, so they're scanned in the order they're relayed
+	orderBucket = []byte("order")
+	// messages contains the messages by id
+	messagesBucket = []byte("messages")
+	// failed contains the messages which failed permanently by id
+	failedBucket = []byte("failed")
+)
+
+// errNotQueued is returned for ids which aren't in the queue
+var errNotQueued = errors.New("message isn't queued")
+
+// BoltStore keeps the messages in a bbolt database, every change is an atomic transaction
+// which is flushed to the disk when it's committed, and the scheduler scans the entries without reading the messages
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens (or creates) the database in the directory,
+// it fails if another process has the database open
+func OpenBolt(dir string) (*BoltStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(dir, BoltFile), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{entriesBucket, orderBucket, messagesBucket, failedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// orderKey is the key of the message in the order bucket
+func orderKey(entry *Entry) []byte {
+	return append([]byte{byte(rank(entry.Class))}, entry.Id...)
+}
+
+// Add implements Store
+func (s *BoltStore) Add(message interface{}, class string) (string, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	if class == "" {
+		class = helpers.PriorityNormal
+	}
+	entry := &Entry{Id: helpers.NewId(), Class: class, Queued: time.Now()}
+	return entry.Id, s.db.Update(func(tx *bolt.Tx) error {
+		if err := putEntry(tx, entry); err != nil {
+			return err
+		}
+		if err := tx.Bucket(orderBucket).Put(orderKey(entry), []byte(entry.Id)); err != nil {
+			return err
+		}
+		return tx.Bucket(messagesBucket).Put([]byte(entry.Id), data)
+	})
+}
+
+// List implements Store
+func (s *BoltStore) List() ([]Entry, error) {
+	entries := []Entry{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(orderBucket).ForEach(func(_, id []byte) error {
+			entry, err := getEntry(tx, string(id))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, *entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// Len implements Store
+func (s *BoltStore) Len() (int, error) {
+	length := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		length = tx.Bucket(entriesBucket).Stats().KeyN
+		return nil
+	})
+	return length, err
+}
+
+// Read implements Store
+func (s *BoltStore) Read(id string, message interface{}) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(messagesBucket).Get([]byte(id))
+		if data == nil {
+			return errNotQueued
+		}
+		return json.Unmarshal(data, message)
+	})
+}
+
+// Retry implements Store, it counts the attempt and keeps the reason
+func (s *BoltStore) Retry(id string, message interface{}, reason string) error {
+	var data []byte
+	if message != nil {
+		var err error
+		if data, err = json.Marshal(message); err != nil {
+			return err
+		}
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		entry, err := getEntry(tx, id)
+		if err != nil {
+			return err
+		}
+		entry.Attempts++
+		entry.LastError = reason
+		if err := putEntry(tx, entry); err != nil {
+			return err
+		}
+		if data == nil {
+			return nil
+		}
+		return tx.Bucket(messagesBucket).Put([]byte(id), data)
+	})
+}
+
+// Fail implements Store
+func (s *BoltStore) Fail(id string, message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(failedBucket).Put([]byte(id), data)
+	})
+}
+
+// Remove implements Store
+func (s *BoltStore) Remove(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		entry, err := getEntry(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(orderBucket).Delete(orderKey(entry)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(messagesBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return tx.Bucket(entriesBucket).Delete([]byte(id))
+	})
+}
+
+// Recover implements Store. The transactions are atomic, so it only removes the entries
+// which lost their message or their place in the order, e.g. when the database was repaired.
+func (s *BoltStore) Recover() (Recovery, error) {
+	recovery := Recovery{}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		broken := []*Entry{}
+		err := tx.Bucket(entriesBucket).ForEach(func(id, data []byte) error {
+			entry := &Entry{}
+			if json.Unmarshal(data, entry) != nil {
+				entry.Id = string(id)
+				broken = append(broken, entry)
+				return nil
+			}
+			if tx.Bucket(messagesBucket).Get(id) == nil || tx.Bucket(orderBucket).Get(orderKey(entry)) == nil {
+				broken = append(broken, entry)
+				return nil
+			}
+			recovery.Resumed++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, entry := range broken {
+			if err := tx.Bucket(orderBucket).Delete(orderKey(entry)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(messagesBucket).Delete([]byte(entry.Id)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(entriesBucket).Delete([]byte(entry.Id)); err != nil {
+				return err
+			}
+			recovery.Discarded++
+		}
+		return nil
+	})
+	return recovery, err
+}
+
+// Close implements Store
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func getEntry(tx *bolt.Tx, id string) (*Entry, error) {
+	data := tx.Bucket(entriesBucket).Get([]byte(id))
+	if data == nil {
+		return nil, errNotQueued
+	}
+	entry := &Entry{}
+	return entry, json.Unmarshal(data, entry)
+}
+
+func putEntry(tx *bolt.Tx, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(entriesBucket).Put([]byte(entry.Id), data)
+}
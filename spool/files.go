@@ -0,0 +1,155 @@
+package spool
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gopistolet/gopistolet/helpers"
+)
+
+// FileStore keeps every message in a JSON file in Dir: <id>.json for normal messages,
+// <id>.<class>.json for the other classes. Failed messages are moved aside to <id>.json.failed.
+// The retry state isn't kept, so the entries have no attempts.
+// The files are written to <file>.tmp, flushed to the disk and renamed, so a crash can't leave a partial message.
+type FileStore struct {
+	Dir string
+}
+
+func (s *FileStore) filename(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
+
+// write encodes the message to the file durably
+func (s *FileStore) write(filename string, message interface{}) error {
+	data, err := json.MarshalIndent(message, "", "    ")
+	if err != nil {
+		return err
+	}
+	return helpers.WriteFileDurably(filename, data, 0600)
+}
+
+// Add implements Store
+func (s *FileStore) Add(message interface{}, class string) (string, error) {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return "", err
+	}
+	id := helpers.NewId()
+	if class != "" && class != helpers.PriorityNormal {
+		id += "." + class
+	}
+	return id, s.write(s.filename(id), message)
+}
+
+// List implements Store, the time a file was written is the time it was queued
+func (s *FileStore) List() ([]Entry, error) {
+	// no spool directory means nothing was queued yet
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	entries := []Entry{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		id := strings.TrimSuffix(file.Name(), ".json")
+		entries = append(entries, Entry{Id: id, Class: fileClass(id), Queued: file.ModTime()})
+	}
+	// the files are sorted by name, so by age within a class
+	sort.SliceStable(entries, func(i, j int) bool {
+		return rank(entries[i].Class) < rank(entries[j].Class)
+	})
+	return entries, nil
+}
+
+// fileClass returns the class in the id of a file
+func fileClass(id string) string {
+	for _, class := range helpers.PriorityClasses {
+		if strings.HasSuffix(id, "."+class) {
+			return class
+		}
+	}
+	return helpers.PriorityNormal
+}
+
+// Len implements Store, without reading the files
+func (s *FileStore) Len() (int, error) {
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	length := 0
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			length++
+		}
+	}
+	return length, nil
+}
+
+// Read implements Store
+func (s *FileStore) Read(id string, message interface{}) error {
+	return helpers.DecodeFile(s.filename(id), message)
+}
+
+// Retry implements Store
+func (s *FileStore) Retry(id string, message interface{}, reason string) error {
+	if message == nil {
+		return nil
+	}
+	return s.write(s.filename(id), message)
+}
+
+// Fail implements Store
+func (s *FileStore) Fail(id string, message interface{}) error {
+	return s.write(s.filename(id)+".failed", message)
+}
+
+// Remove implements Store
+func (s *FileStore) Remove(id string) error {
+	return os.Remove(s.filename(id))
+}
+
+// Recover implements Store, it removes the temporary files of interrupted writes
+// and moves the messages which can't be decoded aside to <id>.json.corrupt
+func (s *FileStore) Recover() (Recovery, error) {
+	recovery := Recovery{}
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil && !os.IsNotExist(err) {
+		return recovery, err
+	}
+	for _, file := range files {
+		name := filepath.Join(s.Dir, file.Name())
+		switch {
+		case file.IsDir():
+		case strings.HasSuffix(file.Name(), ".tmp"):
+			if err := os.Remove(name); err != nil {
+				return recovery, err
+			}
+			recovery.Discarded++
+		case strings.HasSuffix(file.Name(), ".json"):
+			data, err := ioutil.ReadFile(name)
+			if err != nil {
+				return recovery, err
+			}
+			if json.Valid(data) {
+				recovery.Resumed++
+				continue
+			}
+			if err := os.Rename(name, name+".corrupt"); err != nil {
+				return recovery, err
+			}
+			recovery.Discarded++
+		}
+	}
+	return recovery, nil
+}
+
+// Close implements Store
+func (s *FileStore) Close() error {
+	return nil
+}
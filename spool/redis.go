@@ -0,0 +1,265 @@
+package spool
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/gopistolet/gopistolet/helpers"
+)
+
+// RedisStore keeps the messages in a Redis server, so several instances can share the queue:
+// every instance accepts mail into it and relays the messages it leases (see Leaser).
+// The keys start with the prefix:
+//
+//	<prefix>queue:entries        hash of the entries by id
+//	<prefix>queue:order          sorted set of the ids, scored by class rank and queue time
+//	<prefix>queue:message:<id>   the message
+//	<prefix>queue:failed:<id>    the message which failed permanently
+//	<prefix>queue:lease:<id>     the instance which is relaying the message, it expires with the lease
+type RedisStore struct {
+	prefix string
+	// owner identifies the leases of this instance
+	owner string
+	pool  *redis.Pool
+}
+
+// OpenRedis opens the queue in the database of the Redis server at address (host:port),
+// it fails if the server can't be reached
+func OpenRedis(address, password string, database int, prefix string) (*RedisStore, error) {
+	s := &RedisStore{
+		prefix: prefix + "queue:",
+		owner:  helpers.NewId(),
+		pool: &redis.Pool{
+			MaxIdle:     8,
+			IdleTimeout: 5 * time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", address,
+					redis.DialPassword(password),
+					redis.DialDatabase(database),
+					redis.DialConnectTimeout(5*time.Second),
+					redis.DialReadTimeout(10*time.Second),
+					redis.DialWriteTimeout(10*time.Second))
+			},
+		},
+	}
+	conn := s.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		s.pool.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RedisStore) key(name, id string) string {
+	return s.prefix + name + ":" + id
+}
+
+// score orders the ids by class rank, then by queue time
+func score(entry *Entry) int64 {
+	return int64(rank(entry.Class))*1e13 + entry.Queued.UnixNano()/int64(time.Millisecond)
+}
+
+// Add implements Store
+func (s *RedisStore) Add(message interface{}, class string) (string, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	if class == "" {
+		class = helpers.PriorityNormal
+	}
+	entry := &Entry{Id: helpers.NewId(), Class: class, Queued: time.Now()}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+	conn.Send("MULTI")
+	conn.Send("SET", s.key("message", entry.Id), data)
+	conn.Send("HSET", s.prefix+"entries", entry.Id, encoded)
+	conn.Send("ZADD", s.prefix+"order", score(entry), entry.Id)
+	_, err = conn.Do("EXEC")
+	return entry.Id, err
+}
+
+// List implements Store
+func (s *RedisStore) List() ([]Entry, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("ZRANGE", s.prefix+"order", 0, -1))
+	if err != nil || len(ids) == 0 {
+		return []Entry{}, err
+	}
+	args := redis.Args{}.Add(s.prefix + "entries").AddFlat(ids)
+	values, err := redis.ByteSlices(conn.Do("HMGET", args...))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(values))
+	for _, data := range values {
+		// removed by another instance in the meantime
+		if data == nil {
+			continue
+		}
+		entry := Entry{}
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Len implements Store
+func (s *RedisStore) Len() (int, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	return redis.Int(conn.Do("ZCARD", s.prefix+"order"))
+}
+
+// Read implements Store
+func (s *RedisStore) Read(id string, message interface{}) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", s.key("message", id)))
+	if err == redis.ErrNil {
+		return errNotQueued
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, message)
+}
+
+// Retry implements Store, it counts the attempt, keeps the reason and gives the lease up
+func (s *RedisStore) Retry(id string, message interface{}, reason string) error {
+	var data []byte
+	if message != nil {
+		var err error
+		if data, err = json.Marshal(message); err != nil {
+			return err
+		}
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+	encoded, err := redis.Bytes(conn.Do("HGET", s.prefix+"entries", id))
+	if err == redis.ErrNil {
+		return errNotQueued
+	}
+	if err != nil {
+		return err
+	}
+	entry := &Entry{}
+	if err := json.Unmarshal(encoded, entry); err != nil {
+		return err
+	}
+	entry.Attempts++
+	entry.LastError = reason
+	if encoded, err = json.Marshal(entry); err != nil {
+		return err
+	}
+
+	conn.Send("MULTI")
+	conn.Send("HSET", s.prefix+"entries", id, encoded)
+	if data != nil {
+		conn.Send("SET", s.key("message", id), data)
+	}
+	conn.Send("DEL", s.key("lease", id))
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// Fail implements Store
+func (s *RedisStore) Fail(id string, message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("SET", s.key("failed", id), data)
+	return err
+}
+
+// Remove implements Store
+func (s *RedisStore) Remove(id string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("ZREM", s.prefix+"order", id)
+	conn.Send("HDEL", s.prefix+"entries", id)
+	conn.Send("DEL", s.key("message", id), s.key("lease", id))
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+	if removed, _ := redis.Int(values[0], nil); removed == 0 {
+		return errNotQueued
+	}
+	return nil
+}
+
+// Lease implements Leaser, with a key which expires after the duration.
+// Messages which another instance relayed in the meantime can't be leased.
+func (s *RedisStore) Lease(id string, duration time.Duration) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	ms := int64(duration / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	_, err := redis.String(conn.Do("SET", s.key("lease", id), s.owner, "NX", "PX", ms))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	queued, err := redis.Bool(conn.Do("EXISTS", s.key("message", id)))
+	if err == nil && !queued {
+		_, err = conn.Do("DEL", s.key("lease", id))
+	}
+	return queued, err
+}
+
+// Recover implements Store. The changes are atomic and the leases of the instances which crashed expire,
+// so it only removes the entries which lost their message, e.g. because the server was restored.
+func (s *RedisStore) Recover() (Recovery, error) {
+	recovery := Recovery{}
+	entries, err := s.List()
+	if err != nil {
+		return recovery, err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+	for _, entry := range entries {
+		exists, err := redis.Bool(conn.Do("EXISTS", s.key("message", entry.Id)))
+		if err != nil {
+			return recovery, err
+		}
+		if exists {
+			recovery.Resumed++
+			continue
+		}
+		if err := s.Remove(entry.Id); err != nil && err != errNotQueued {
+			return recovery, err
+		}
+		recovery.Discarded++
+	}
+	return recovery, nil
+}
+
+// Close implements Store
+func (s *RedisStore) Close() error {
+	return s.pool.Close()
+}
@@ -0,0 +1,90 @@
+// Package spool keeps the messages of the queue, in one JSON file per message,
+// in an embedded bbolt database or in a Redis server which several instances share
+package spool
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gopistolet/gopistolet/helpers"
+)
+
+// Store keeps the queued messages, which are encoded as JSON.
+// A message is on the disk when Add returns, so it can be acknowledged to the client.
+type Store interface {
+	// Add queues the message in a priority class, it returns its id
+	Add(message interface{}, class string) (string, error)
+	// List returns the queued messages, by class (high first) and by age within a class
+	List() ([]Entry, error)
+	// Len returns the number of queued messages
+	Len() (int, error)
+	// Read decodes the message with the id
+	Read(id string, message interface{}) error
+	// Retry keeps the message for a later attempt, reason is why it was deferred.
+	// The message replaces the queued one, unless it's nil.
+	Retry(id string, message interface{}, reason string) error
+	// Fail keeps the message which failed permanently aside, for the postmaster
+	Fail(id string, message interface{}) error
+	// Remove removes the message with the id
+	Remove(id string) error
+	// Recover checks the store after a (re)start: the complete messages stay queued
+	// and the writes which were interrupted by a crash are discarded
+	Recover() (Recovery, error)
+	Close() error
+}
+
+// Recovery is the outcome of Store.Recover
+type Recovery struct {
+	// Resumed is the number of queued messages which will be relayed
+	Resumed int
+	// Discarded is the number of partially written messages which were removed,
+	// they were never acknowledged to the client
+	Discarded int
+}
+
+// Leaser is implemented by the stores which several instances share: a message is only relayed
+// by the instance which leased it. The lease ends with Retry or Remove, and expires after the duration
+// if the instance dies while it's relaying the message, so another instance relays it then.
+type Leaser interface {
+	// Lease claims the message for the duration, it returns false if another instance holds it
+	Lease(id string, duration time.Duration) (bool, error)
+}
+
+// Entry is the envelope metadata and the retry state of a queued message
+type Entry struct {
+	Id    string
+	Class string
+	// Queued is the time the message was queued
+	Queued time.Time
+	// Attempts is the number of deferred attempts, and LastError the reason of the last one
+	Attempts  int
+	LastError string `json:",omitempty"`
+}
+
+// rank returns the position of the class in the order in which the classes are relayed
+func rank(class string) int {
+	for i, c := range helpers.PriorityClasses {
+		if c == class {
+			return i
+		}
+	}
+	return rank(helpers.PriorityNormal)
+}
+
+// Backends of the store
+const (
+	Files = "files"
+	Bolt  = "bolt"
+	Redis = "redis"
+)
+
+// Open opens the store of the backend in the directory, Redis is opened with OpenRedis
+func Open(backend, dir string) (Store, error) {
+	switch backend {
+	case "", Files:
+		return &FileStore{Dir: dir}, nil
+	case Bolt:
+		return OpenBolt(dir)
+	}
+	return nil, fmt.Errorf("unknown queue backend %q", backend)
+}
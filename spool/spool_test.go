@@ -0,0 +1,219 @@
+package spool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gopistolet/gopistolet/helpers"
+	bolt "go.etcd.io/bbolt"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type message struct {
+	To []string
+}
+
+func TestStores(t *testing.T) {
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	open := func(backend, dir string) (Store, error) {
+		if backend == Redis {
+			server.FlushAll()
+			return OpenRedis(server.Addr(), "", 0, "gopistolet:")
+		}
+		return Open(backend, dir)
+	}
+
+	for _, backend := range []string{Files, Bolt, Redis} {
+		Convey("Testing the "+backend+" store", t, func() {
+			dir, err := ioutil.TempDir("", "spool")
+			So(err, ShouldEqual, nil)
+			defer os.RemoveAll(dir)
+
+			store, err := open(backend, dir)
+			So(err, ShouldEqual, nil)
+			defer store.Close()
+
+			entries, err := store.List()
+			So(err, ShouldEqual, nil)
+			So(entries, ShouldBeEmpty)
+
+			bulk, err := store.Add(&message{To: []string{"bulk@example.com"}}, helpers.PriorityBulk)
+			So(err, ShouldEqual, nil)
+			normal, err := store.Add(&message{To: []string{"a@example.com", "b@example.com"}}, helpers.PriorityNormal)
+			So(err, ShouldEqual, nil)
+			high, err := store.Add(&message{To: []string{"high@example.com"}}, helpers.PriorityHigh)
+			So(err, ShouldEqual, nil)
+
+			// high first, bulk last
+			entries, err = store.List()
+			So(err, ShouldEqual, nil)
+			So(entries, ShouldHaveLength, 3)
+			So([]string{entries[0].Id, entries[1].Id, entries[2].Id}, ShouldResemble, []string{high, normal, bulk})
+			So([]string{entries[0].Class, entries[1].Class, entries[2].Class}, ShouldResemble, helpers.PriorityClasses)
+			So(entries[0].Queued.IsZero(), ShouldEqual, false)
+			length, err := store.Len()
+			So(err, ShouldEqual, nil)
+			So(length, ShouldEqual, 3)
+
+			m := message{}
+			So(store.Read(normal, &m), ShouldEqual, nil)
+			So(m.To, ShouldResemble, []string{"a@example.com", "b@example.com"})
+
+			So(store.Fail(normal, &message{To: []string{"a@example.com"}}), ShouldEqual, nil)
+			So(store.Retry(normal, &message{To: []string{"b@example.com"}}, "451 try again later"), ShouldEqual, nil)
+			So(store.Read(normal, &m), ShouldEqual, nil)
+			So(m.To, ShouldResemble, []string{"b@example.com"})
+			So(store.Retry(normal, nil, "connection refused"), ShouldEqual, nil)
+			So(store.Read(normal, &m), ShouldEqual, nil)
+			So(m.To, ShouldResemble, []string{"b@example.com"})
+
+			So(store.Remove(high), ShouldEqual, nil)
+			So(store.Read(high, &m), ShouldNotEqual, nil)
+			length, _ = store.Len()
+			So(length, ShouldEqual, 2)
+		})
+	}
+
+	Convey("Testing the retry state of the bolt store", t, func() {
+		dir, err := ioutil.TempDir("", "spool")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		store, err := OpenBolt(dir)
+		So(err, ShouldEqual, nil)
+		id, err := store.Add(&message{To: []string{"a@example.com"}}, "")
+		So(err, ShouldEqual, nil)
+		So(store.Retry(id, nil, "451 try again later"), ShouldEqual, nil)
+		So(store.Retry(id, nil, "connection refused"), ShouldEqual, nil)
+		So(store.Close(), ShouldEqual, nil)
+
+		// the queue survives a restart
+		store, err = OpenBolt(dir)
+		So(err, ShouldEqual, nil)
+		defer store.Close()
+		entries, err := store.List()
+		So(err, ShouldEqual, nil)
+		So(entries, ShouldHaveLength, 1)
+		So(entries[0].Class, ShouldEqual, helpers.PriorityNormal)
+		So(entries[0].Attempts, ShouldEqual, 2)
+		So(entries[0].LastError, ShouldEqual, "connection refused")
+		So(store.Remove("missing"), ShouldNotEqual, nil)
+	})
+
+	Convey("Testing the files of the files store", t, func() {
+		dir, err := ioutil.TempDir("", "spool")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		store := &FileStore{Dir: dir}
+		id, err := store.Add(&message{}, helpers.PriorityBulk)
+		So(err, ShouldEqual, nil)
+		So(store.Fail(id, &message{}), ShouldEqual, nil)
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		So(files, ShouldResemble, []string{filepath.Join(dir, id+".json"), filepath.Join(dir, id+".json.failed")})
+		So(id, ShouldEndWith, ".bulk")
+
+		_, err = Open("sql", dir)
+		So(err, ShouldNotEqual, nil)
+	})
+
+	Convey("Testing the recovery of the files store", t, func() {
+		dir, err := ioutil.TempDir("", "spool")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		store := &FileStore{Dir: dir}
+		id, err := store.Add(&message{To: []string{"a@example.com"}}, "")
+		So(err, ShouldEqual, nil)
+		// a crash in the middle of writes
+		ioutil.WriteFile(filepath.Join(dir, "1234.json.tmp"), []byte(`{"To": ["b@exa`), 0600)
+		ioutil.WriteFile(filepath.Join(dir, "5678.json"), []byte(`{"To": ["c@exa`), 0600)
+
+		recovery, err := store.Recover()
+		So(err, ShouldEqual, nil)
+		So(recovery, ShouldResemble, Recovery{Resumed: 1, Discarded: 2})
+		entries, _ := store.List()
+		So(entries, ShouldHaveLength, 1)
+		So(entries[0].Id, ShouldEqual, id)
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		So(files, ShouldHaveLength, 2)
+		So(files, ShouldContain, filepath.Join(dir, "5678.json.corrupt"))
+		So(files, ShouldContain, filepath.Join(dir, id+".json"))
+	})
+
+	Convey("Testing the leases of the redis store", t, func() {
+		server.FlushAll()
+		first, err := OpenRedis(server.Addr(), "", 0, "gopistolet:")
+		So(err, ShouldEqual, nil)
+		defer first.Close()
+		second, err := OpenRedis(server.Addr(), "", 0, "gopistolet:")
+		So(err, ShouldEqual, nil)
+		defer second.Close()
+
+		// the instances share the queue
+		id, err := first.Add(&message{To: []string{"a@example.com"}}, "")
+		So(err, ShouldEqual, nil)
+		entries, err := second.List()
+		So(err, ShouldEqual, nil)
+		So(entries, ShouldHaveLength, 1)
+
+		// only one of them relays the message
+		leased, err := first.Lease(id, time.Minute)
+		So(err, ShouldEqual, nil)
+		So(leased, ShouldEqual, true)
+		leased, _ = second.Lease(id, time.Minute)
+		So(leased, ShouldEqual, false)
+
+		// the lease ends with the attempt
+		So(first.Retry(id, nil, "451 try again later"), ShouldEqual, nil)
+		leased, _ = second.Lease(id, time.Minute)
+		So(leased, ShouldEqual, true)
+
+		// or when the instance died
+		server.FastForward(time.Minute)
+		leased, _ = first.Lease(id, time.Minute)
+		So(leased, ShouldEqual, true)
+
+		So(second.Remove(id), ShouldEqual, nil)
+		So(first.Remove(id), ShouldNotEqual, nil)
+		leased, _ = second.Lease(id, time.Minute)
+		So(leased, ShouldEqual, false)
+
+		_, err = OpenRedis("127.0.0.1:1", "", 0, "")
+		So(err, ShouldNotEqual, nil)
+	})
+
+	Convey("Testing the recovery of the bolt store", t, func() {
+		dir, err := ioutil.TempDir("", "spool")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+
+		store, err := OpenBolt(dir)
+		So(err, ShouldEqual, nil)
+		defer store.Close()
+		_, err = store.Add(&message{To: []string{"a@example.com"}}, "")
+		So(err, ShouldEqual, nil)
+		lost, err := store.Add(&message{To: []string{"b@example.com"}}, "")
+		So(err, ShouldEqual, nil)
+		store.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(messagesBucket).Delete([]byte(lost))
+		})
+
+		recovery, err := store.Recover()
+		So(err, ShouldEqual, nil)
+		So(recovery, ShouldResemble, Recovery{Resumed: 1, Discarded: 1})
+		length, _ := store.Len()
+		So(length, ShouldEqual, 1)
+	})
+
+}
@@ -0,0 +1,198 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/helpers"
+)
+
+// HTTPStore is a UserStore which asks an external HTTP endpoint, so an existing user system
+// can be used without sharing its database.
+//
+// Every lookup is a POST of an HTTPRequest as JSON to URL, which has to answer 200 with an HTTPResponse.
+// Lookups are cached for CacheTTL seconds, successful authentications as well (see helpers.AuthCache).
+type HTTPStore struct {
+	URL string
+	// Headers are added to every request, e.g. Authorization
+	Headers map[string]string
+	// Timeout in seconds of a request (default 10)
+	Timeout int
+	// CacheTTL in seconds, 0 disables the cache
+	CacheTTL int
+
+	client    *http.Client
+	authCache *helpers.AuthCache
+
+	mutex sync.Mutex
+	cache map[string]httpCacheEntry
+}
+
+// HTTPRequest is sent to the endpoint, Action is one of authenticate, user, domain or alias
+type HTTPRequest struct {
+	Action   string `json:"action"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Address  string `json:"address,omitempty"`
+}
+
+// HTTPResponse is the answer of the endpoint.
+// Ok reports whether the credentials are valid, the user exists, the domain is local or the address is an alias.
+// Name is the canonical name of the user, Destinations are the destinations of the alias.
+type HTTPResponse struct {
+	Ok           bool     `json:"ok"`
+	Name         string   `json:"name,omitempty"`
+	Destinations []string `json:"destinations,omitempty"`
+}
+
+type httpCacheEntry struct {
+	response HTTPResponse
+	expires  time.Time
+}
+
+// do posts the request and decodes the response
+func (s *HTTPStore) do(request HTTPRequest) (*HTTPResponse, error) {
+	s.mutex.Lock()
+	if s.client == nil {
+		timeout := s.Timeout
+		if timeout <= 0 {
+			timeout = 10
+		}
+		s.client = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	}
+	client := s.client
+	s.mutex.Unlock()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user lookup %s: %s", request.Action, resp.Status)
+	}
+	response := &HTTPResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(response); err != nil {
+		return nil, fmt.Errorf("user lookup %s: %v", request.Action, err)
+	}
+	return response, nil
+}
+
+// lookup does the request, or returns the cached response.
+// Negative responses are cached as well, failed requests aren't.
+func (s *HTTPStore) lookup(request HTTPRequest) (*HTTPResponse, error) {
+	key := request.Action + "\x00" + strings.ToLower(request.User+request.Domain+request.Address)
+
+	s.mutex.Lock()
+	entry, found := s.cache[key]
+	s.mutex.Unlock()
+	if found && time.Now().Before(entry.expires) {
+		return &entry.response, nil
+	}
+
+	response, err := s.do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.CacheTTL > 0 {
+		s.mutex.Lock()
+		if s.cache == nil {
+			s.cache = make(map[string]httpCacheEntry)
+		}
+		s.cache[key] = httpCacheEntry{
+			response: *response,
+			expires:  time.Now().Add(time.Duration(s.CacheTTL) * time.Second),
+		}
+		s.mutex.Unlock()
+	}
+	return response, nil
+}
+
+// Get returns the user, or nil if the endpoint doesn't know it.
+// The password isn't known, so the user can't be authenticated with CheckPassword.
+func (s *HTTPStore) Get(name string) (*User, error) {
+	response, err := s.lookup(HTTPRequest{Action: "user", User: name})
+	if err != nil || !response.Ok {
+		return nil, err
+	}
+	if response.Name != "" {
+		name = response.Name
+	}
+	return &User{Name: name, Password: "{HTTP}"}, nil
+}
+
+// Authenticate asks the endpoint to check the credentials
+func (s *HTTPStore) Authenticate(name, password string) (bool, error) {
+	s.mutex.Lock()
+	if s.authCache == nil {
+		s.authCache = &helpers.AuthCache{TTL: s.CacheTTL}
+	}
+	authCache := s.authCache
+	s.mutex.Unlock()
+
+	var err error
+	ok := authCache.Verify(name, password, func(name, password string) bool {
+		var response *HTTPResponse
+		response, err = s.do(HTTPRequest{Action: "authenticate", User: name, Password: password})
+		return err == nil && response.Ok
+	})
+	return ok, err
+}
+
+// Cleanup removes the expired lookups and authentications from the caches, it should be called periodically
+func (s *HTTPStore) Cleanup() {
+	s.mutex.Lock()
+	now := time.Now()
+	for key, entry := range s.cache {
+		if now.After(entry.expires) {
+			delete(s.cache, key)
+		}
+	}
+	authCache := s.authCache
+	s.mutex.Unlock()
+	if authCache != nil {
+		authCache.Cleanup()
+	}
+}
+
+// HasDomain asks the endpoint whether mail for the domain is delivered locally
+func (s *HTTPStore) HasDomain(domain string) (bool, error) {
+	response, err := s.lookup(HTTPRequest{Action: "domain", Domain: strings.TrimSuffix(domain, ".")})
+	if err != nil {
+		return false, err
+	}
+	return response.Ok, nil
+}
+
+// Alias asks the endpoint for the destinations of the alias
+func (s *HTTPStore) Alias(address string) ([]string, error) {
+	response, err := s.lookup(HTTPRequest{Action: "alias", Address: address})
+	if err != nil || !response.Ok {
+		return nil, err
+	}
+	return response.Destinations, nil
+}
@@ -0,0 +1,105 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHTTPStore(t *testing.T) {
+
+	Convey("Testing HTTPStore", t, func() {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.Header.Get("Authorization") != "Bearer token" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			request := HTTPRequest{}
+			json.NewDecoder(r.Body).Decode(&request)
+
+			response := HTTPResponse{}
+			switch request.Action {
+			case "authenticate":
+				response.Ok = request.User == "john@example.com" && request.Password == "secret"
+			case "user":
+				response.Ok = request.User == "john@example.com"
+				response.Name = "john@example.com"
+			case "domain":
+				response.Ok = request.Domain == "example.com"
+			case "alias":
+				if request.Address == "info@example.com" {
+					response.Ok = true
+					response.Destinations = []string{"john@example.com"}
+				}
+			}
+			json.NewEncoder(w).Encode(response)
+		}))
+		defer server.Close()
+
+		store := &HTTPStore{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}, CacheTTL: 60}
+
+		ok, err := store.Authenticate("john@example.com", "secret")
+		So(err, ShouldEqual, nil)
+		So(ok, ShouldEqual, true)
+		ok, err = store.Authenticate("john@example.com", "wrong")
+		So(err, ShouldEqual, nil)
+		So(ok, ShouldEqual, false)
+
+		u, err := store.Get("john@example.com")
+		So(err, ShouldEqual, nil)
+		So(u.Name, ShouldEqual, "john@example.com")
+		u, err = store.Get("jane@example.com")
+		So(err, ShouldEqual, nil)
+		So(u, ShouldEqual, nil)
+
+		ok, _ = store.HasDomain("example.com.")
+		So(ok, ShouldEqual, true)
+		ok, _ = store.HasDomain("example.org")
+		So(ok, ShouldEqual, false)
+
+		destinations, err := store.Alias("info@example.com")
+		So(err, ShouldEqual, nil)
+		So(destinations, ShouldResemble, []string{"john@example.com"})
+		destinations, _ = store.Alias("john@example.com")
+		So(destinations, ShouldEqual, nil)
+
+		Convey("Responses are cached", func() {
+			before := requests
+			store.Authenticate("john@example.com", "secret")
+			store.Get("jane@example.com")
+			store.Alias("info@example.com")
+			So(requests, ShouldEqual, before)
+
+			// failed authentications aren't cached
+			store.Authenticate("john@example.com", "wrong")
+			So(requests, ShouldEqual, before+1)
+		})
+
+		Convey("Expired responses are cleaned up", func() {
+			store.mutex.Lock()
+			for key, entry := range store.cache {
+				entry.expires = time.Now().Add(-time.Second)
+				store.cache[key] = entry
+			}
+			store.mutex.Unlock()
+			store.Cleanup()
+			So(store.cache, ShouldBeEmpty)
+		})
+
+		Convey("Errors are returned", func() {
+			store := &HTTPStore{URL: server.URL}
+			ok, err := store.Authenticate("john@example.com", "secret")
+			So(err, ShouldNotEqual, nil)
+			So(ok, ShouldEqual, false)
+			_, err = store.HasDomain("example.com")
+			So(err, ShouldNotEqual, nil)
+		})
+	})
+
+}
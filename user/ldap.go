@@ -0,0 +1,263 @@
+package user
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPStore is a UserStore backed by an LDAP directory or Active Directory.
+// Users are looked up with the service account (BindDN), and authenticated by binding as the user.
+type LDAPStore struct {
+	// URL of the directory server, ldap://host:389 or ldaps://host:636
+	URL string
+	// StartTLS upgrades ldap:// connections to TLS
+	StartTLS bool
+	// InsecureSkipVerify disables the verification of the server certificate
+	InsecureSkipVerify bool
+
+	// BindDN and BindPassword of the service account which searches the directory
+	BindDN       string
+	BindPassword string
+	// BaseDN below which users are searched
+	BaseDN string
+	// UserFilter finds a user by address, %s is replaced by the escaped address
+	// (default (mail=%s), (|(mail=%s)(proxyAddresses=smtp:%s)) for Active Directory)
+	UserFilter string
+	// MailAttribute contains the primary address of a user (default mail)
+	MailAttribute string
+	// AliasAttribute contains the other addresses of a user (e.g. mailAlternateAddress),
+	// mail to them is delivered to the primary address
+	AliasAttribute string
+	// Domains for which mail is delivered locally, the directory doesn't have a list of them
+	Domains []string
+
+	// PoolSize is the number of idle connections which are kept (default 4)
+	PoolSize int
+	// Timeout in seconds for connecting and for requests (default 10)
+	Timeout int
+
+	// dial connects to the directory, it can be replaced for testing
+	dial func() (ldapConn, error)
+	// pool is created once, by the first request
+	poolOnce sync.Once
+	pool     chan ldapConn
+}
+
+// ldapConn is the part of *ldap.Conn which is used
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	IsClosing() bool
+	Close()
+}
+
+// errNoUser is returned when the user isn't in the directory
+var errNoUser = errors.New("user not found")
+
+func (s *LDAPStore) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(s.Timeout) * time.Second
+}
+
+// connect dials the server and binds as the service account
+func (s *LDAPStore) connect() (ldapConn, error) {
+	if s.dial != nil {
+		return s.dial()
+	}
+
+	host := s.URL
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: s.InsecureSkipVerify}
+
+	conn, err := ldap.DialURL(s.URL, ldap.DialWithDialer(&net.Dialer{Timeout: s.timeout()}), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(s.timeout())
+	if s.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.BindDN != "" {
+		if err := conn.Bind(s.BindDN, s.BindPassword); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// get takes a connection from the pool, or connects if the pool is empty
+func (s *LDAPStore) get() (ldapConn, error) {
+	s.poolOnce.Do(func() {
+		size := s.PoolSize
+		if size <= 0 {
+			size = 4
+		}
+		s.pool = make(chan ldapConn, size)
+	})
+	for {
+		select {
+		case conn := <-s.pool:
+			if !conn.IsClosing() {
+				return conn, nil
+			}
+		default:
+			return s.connect()
+		}
+	}
+}
+
+// put returns a connection to the pool, it's closed if the pool is full or it failed
+func (s *LDAPStore) put(conn ldapConn, err error) {
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		conn.Close()
+		return
+	}
+	select {
+	case s.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// find searches the entry of the user with the address
+func (s *LDAPStore) find(conn ldapConn, address string) (*ldap.Entry, error) {
+	filter := s.UserFilter
+	if filter == "" {
+		filter = "(mail=%s)"
+	}
+	escaped := ldap.EscapeFilter(strings.ToLower(address))
+	filter = strings.Replace(filter, "%s", escaped, -1)
+
+	result, err := conn.Search(ldap.NewSearchRequest(s.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(s.timeout().Seconds()), false, filter, []string{s.mailAttribute()}, nil))
+	if err != nil {
+		return nil, err
+	}
+	switch len(result.Entries) {
+	case 0:
+		return nil, errNoUser
+	case 1:
+		return result.Entries[0], nil
+	}
+	return nil, fmt.Errorf("more than one user with address %s", address)
+}
+
+func (s *LDAPStore) mailAttribute() string {
+	if s.MailAttribute == "" {
+		return "mail"
+	}
+	return s.MailAttribute
+}
+
+// Get returns the user with the address, or nil if there is no such user.
+// The password isn't in the directory, so the user can't be authenticated with CheckPassword.
+func (s *LDAPStore) Get(name string) (*User, error) {
+	conn, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := s.find(conn, name)
+	if err == errNoUser {
+		s.put(conn, nil)
+		return nil, nil
+	}
+	s.put(conn, err)
+	if err != nil {
+		return nil, err
+	}
+	return &User{Name: entry.GetAttributeValue(s.mailAttribute()), Password: "{LDAP}"}, nil
+}
+
+// Authenticate binds as the user with the password
+func (s *LDAPStore) Authenticate(name, password string) (bool, error) {
+	// an empty password would be an unauthenticated bind, which always succeeds
+	if password == "" {
+		return false, nil
+	}
+
+	conn, err := s.get()
+	if err != nil {
+		return false, err
+	}
+	entry, err := s.find(conn, name)
+	if err == errNoUser {
+		s.put(conn, nil)
+		return false, nil
+	}
+	if err != nil {
+		s.put(conn, err)
+		return false, err
+	}
+
+	err = conn.Bind(entry.DN, password)
+	ok := err == nil
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		s.put(conn, err)
+		return false, err
+	}
+
+	// the connection is bound as the user now, bind as the service account again before it's reused
+	if s.BindDN != "" {
+		if err := conn.Bind(s.BindDN, s.BindPassword); err != nil {
+			s.put(conn, err)
+			return ok, nil
+		}
+	}
+	s.put(conn, nil)
+	return ok, nil
+}
+
+// HasDomain reports whether the domain is in Domains
+func (s *LDAPStore) HasDomain(domain string) (bool, error) {
+	for _, d := range s.Domains {
+		if strings.EqualFold(strings.TrimSuffix(d, "."), strings.TrimSuffix(domain, ".")) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Alias returns the primary address of the user who has the address in AliasAttribute
+func (s *LDAPStore) Alias(address string) ([]string, error) {
+	if s.AliasAttribute == "" {
+		return nil, nil
+	}
+
+	conn, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	filter := "(" + s.AliasAttribute + "=" + ldap.EscapeFilter(strings.ToLower(address)) + ")"
+	result, err := conn.Search(ldap.NewSearchRequest(s.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(s.timeout().Seconds()), false, filter, []string{s.mailAttribute()}, nil))
+	s.put(conn, err)
+	if err != nil {
+		return nil, err
+	}
+
+	var destinations []string
+	for _, entry := range result.Entries {
+		if mail := entry.GetAttributeValue(s.mailAttribute()); mail != "" {
+			destinations = append(destinations, mail)
+		}
+	}
+	return destinations, nil
+}
@@ -0,0 +1,150 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeLDAP is a directory with users (DN -> mail) and passwords (DN -> password)
+type fakeLDAP struct {
+	users     map[string]map[string][]string
+	passwords map[string]string
+	filters   []string
+	closed    bool
+}
+
+func (f *fakeLDAP) Bind(username, password string) error {
+	if f.passwords[username] == password {
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (f *fakeLDAP) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	f.filters = append(f.filters, request.Filter)
+	// only (attribute=value) filters are supported
+	parts := strings.SplitN(strings.Trim(request.Filter, "()"), "=", 2)
+	attribute, value := parts[0], parts[1]
+
+	result := &ldap.SearchResult{}
+	for dn, attributes := range f.users {
+		for _, v := range attributes[attribute] {
+			if v == value {
+				result.Entries = append(result.Entries, ldap.NewEntry(dn, attributes))
+			}
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeLDAP) IsClosing() bool { return f.closed }
+func (f *fakeLDAP) Close()          { f.closed = true }
+
+func TestLDAPStore(t *testing.T) {
+
+	Convey("Testing LDAPStore", t, func() {
+		conn := &fakeLDAP{
+			users: map[string]map[string][]string{
+				"uid=john,dc=example,dc=com": {
+					"mail":                 {"john@example.com"},
+					"mailAlternateAddress": {"j.doe@example.com"},
+				},
+			},
+			passwords: map[string]string{
+				"cn=admin,dc=example,dc=com": "admin",
+				"uid=john,dc=example,dc=com": "secret",
+			},
+		}
+		dials := 0
+		store := &LDAPStore{
+			BindDN:         "cn=admin,dc=example,dc=com",
+			BindPassword:   "admin",
+			BaseDN:         "dc=example,dc=com",
+			AliasAttribute: "mailAlternateAddress",
+			Domains:        []string{"example.com"},
+			dial: func() (ldapConn, error) {
+				dials++
+				return conn, nil
+			},
+		}
+
+		ok, err := store.Authenticate("john@example.com", "secret")
+		So(err, ShouldEqual, nil)
+		So(ok, ShouldEqual, true)
+
+		ok, err = store.Authenticate("JOHN@example.com", "wrong")
+		So(err, ShouldEqual, nil)
+		So(ok, ShouldEqual, false)
+
+		ok, err = store.Authenticate("john@example.com", "")
+		So(err, ShouldEqual, nil)
+		So(ok, ShouldEqual, false)
+
+		ok, err = store.Authenticate("jane@example.com", "secret")
+		So(err, ShouldEqual, nil)
+		So(ok, ShouldEqual, false)
+
+		// the connection is reused
+		So(dials, ShouldEqual, 1)
+
+		u, err := store.Get("john@example.com")
+		So(err, ShouldEqual, nil)
+		So(u.Name, ShouldEqual, "john@example.com")
+		So(u.CheckPassword("{LDAP}"), ShouldEqual, false)
+
+		u, err = store.Get("jane@example.com")
+		So(err, ShouldEqual, nil)
+		So(u, ShouldEqual, nil)
+
+		destinations, err := store.Alias("j.doe@example.com")
+		So(err, ShouldEqual, nil)
+		So(destinations, ShouldResemble, []string{"john@example.com"})
+
+		destinations, err = store.Alias("john@example.com")
+		So(err, ShouldEqual, nil)
+		So(destinations, ShouldEqual, nil)
+
+		ok, _ = store.HasDomain("EXAMPLE.com")
+		So(ok, ShouldEqual, true)
+		ok, _ = store.HasDomain("example.org")
+		So(ok, ShouldEqual, false)
+
+		// filters are escaped
+		store.Get("*)(uid=*")
+		So(conn.filters[len(conn.filters)-1], ShouldEqual, `(mail=\2a\29\28uid=\2a)`)
+
+		// closed connections aren't reused
+		conn.closed = true
+		store.Get("john@example.com")
+		So(dials, ShouldEqual, 2)
+	})
+
+	Convey("Testing the pool of LDAPStore with concurrent requests", t, func() {
+		store := &LDAPStore{
+			PoolSize: 2,
+			dial: func() (ldapConn, error) {
+				return &fakeLDAP{}, nil
+			},
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := store.get()
+				if err == nil {
+					store.put(conn, nil)
+				}
+			}()
+		}
+		wg.Wait()
+		So(cap(store.pool), ShouldEqual, 2)
+		So(len(store.pool), ShouldBeBetweenOrEqual, 1, 2)
+	})
+
+}
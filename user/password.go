@@ -0,0 +1,129 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password schemes, the stored password starts with the scheme in braces (like Dovecot),
+// e.g. {BLF-CRYPT}$2a$10$... Passwords without scheme are bcrypt or argon2id hashes if they
+// look like one, and plain text otherwise (so an old user DB keeps working).
+const (
+	SchemePlain    = "PLAIN"
+	SchemeBcrypt   = "BLF-CRYPT"
+	SchemeArgon2id = "ARGON2ID"
+)
+
+// DefaultScheme is used for new passwords, passwords with another scheme should be rehashed
+var DefaultScheme = SchemeBcrypt
+
+// argon2id parameters (RFC 9106 section 4, second recommended option)
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+
+	// bounds of the parameters of stored hashes, argon2 panics on zero parameters
+	// and a huge memory or time parameter would let one login exhaust the server
+	argon2MaxTime   = 16
+	argon2MaxMemory = 1024 * 1024
+	argon2MinKeyLen = 16
+	argon2MaxKeyLen = 128
+)
+
+// HashPassword hashes the password with the scheme, the result includes the scheme prefix
+func HashPassword(scheme, password string) (string, error) {
+	switch scheme {
+	case SchemeBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		return "{" + SchemeBcrypt + "}" + string(hash), nil
+	case SchemeArgon2id:
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("{%s}$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", SchemeArgon2id, argon2.Version,
+			argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case SchemePlain:
+		return "{" + SchemePlain + "}" + password, nil
+	}
+	return "", fmt.Errorf("unknown password scheme %s", scheme)
+}
+
+// passwordScheme splits a stored password in its scheme and hash
+func passwordScheme(stored string) (string, string) {
+	if strings.HasPrefix(stored, "{") {
+		if end := strings.IndexByte(stored, '}'); end > 0 {
+			return strings.ToUpper(stored[1:end]), stored[end+1:]
+		}
+	}
+	switch {
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		return SchemeBcrypt, stored
+	case strings.HasPrefix(stored, "$argon2id$"):
+		return SchemeArgon2id, stored
+	}
+	return SchemePlain, stored
+}
+
+// verifyPassword checks the password against the stored password in constant time
+func verifyPassword(stored, password string) bool {
+	scheme, hash := passwordScheme(stored)
+	switch scheme {
+	case SchemeBcrypt:
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case SchemeArgon2id:
+		ok, err := verifyArgon2id(hash, password)
+		return err == nil && ok
+	case SchemePlain:
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(password)) == 1
+	}
+	return false
+}
+
+// verifyArgon2id checks a password against a hash in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+func verifyArgon2id(hash, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, errors.New("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, errors.New("unsupported argon2id version")
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, errors.New("invalid argon2id parameters")
+	}
+	if time == 0 || time > argon2MaxTime || threads == 0 || memory < 8*uint32(threads) || memory > argon2MaxMemory {
+		return false, errors.New("argon2id parameters out of range")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, err
+	}
+	if len(key) < argon2MinKeyLen || len(key) > argon2MaxKeyLen {
+		return false, errors.New("invalid argon2id key length")
+	}
+	computed := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
@@ -0,0 +1,42 @@
+package user
+
+import (
+	"strings"
+)
+
+// MaySendAs reports whether the user may use the address as sender, in MAIL FROM and the From header:
+// its own address, an alias in the store which delivers to the user, or one of its SendAs entries.
+// SendAs entries are addresses, domains (@example.com for all its addresses) or * for any sender.
+// The null sender is always allowed, it's used for bounces.
+func MaySendAs(store UserStore, u *User, address string) (bool, error) {
+	if address == "" || strings.EqualFold(address, u.Name) {
+		return true, nil
+	}
+
+	for _, sendAs := range u.SendAs {
+		switch {
+		case sendAs == "*":
+			return true, nil
+		case strings.HasPrefix(sendAs, "@"):
+			if strings.HasSuffix(strings.ToLower(address), strings.ToLower(sendAs)) {
+				return true, nil
+			}
+		case strings.EqualFold(sendAs, address):
+			return true, nil
+		}
+	}
+
+	if store == nil {
+		return false, nil
+	}
+	destinations, err := store.Alias(address)
+	if err != nil {
+		return false, err
+	}
+	for _, destination := range destinations {
+		if strings.EqualFold(destination, u.Name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
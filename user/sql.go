@@ -0,0 +1,141 @@
+package user
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	// the drivers of the databases the SQLStore supports
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+// Schema creates the tables of the SQL store, it works with PostgreSQL and MySQL
+const Schema = `
+CREATE TABLE users (
+    name VARCHAR(255) NOT NULL PRIMARY KEY,
+    password VARCHAR(255) NOT NULL
+);
+CREATE TABLE domains (
+    name VARCHAR(255) NOT NULL PRIMARY KEY
+);
+CREATE TABLE aliases (
+    address VARCHAR(255) NOT NULL,
+    destination VARCHAR(255) NOT NULL
+);
+`
+
+// SQLStore is a UserStore in a PostgreSQL or MySQL database with the tables in Schema.
+// Names, domains and addresses are stored in lower case.
+type SQLStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// NewSQLStore uses the database, driver is the name of the database/sql driver
+// ("postgres" or "mysql"), both are compiled in
+func NewSQLStore(db *sql.DB, driver string) (*SQLStore, error) {
+	if driver != "postgres" && driver != "mysql" {
+		return nil, fmt.Errorf("unsupported SQL driver %s", driver)
+	}
+	return &SQLStore{db: db, driver: driver}, nil
+}
+
+// OpenSQL opens the database with the driver and data source name
+func OpenSQL(driver, dsn string) (*SQLStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return NewSQLStore(db, driver)
+}
+
+// Close closes the database
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// query rewrites the ? placeholders to $1, $2, ... for PostgreSQL
+func (s *SQLStore) query(query string) string {
+	if s.driver != "postgres" {
+		return query
+	}
+	b := strings.Builder{}
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Get returns the user with the name, or nil if there is no such user
+func (s *SQLStore) Get(name string) (*User, error) {
+	u := &User{}
+	err := s.db.QueryRow(s.query("SELECT name, password FROM users WHERE name = ?"), strings.ToLower(name)).Scan(&u.Name, &u.Password)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Authenticate checks the credentials, passwords which aren't hashed with the DefaultScheme
+// are rehashed in the database when they're correct
+func (s *SQLStore) Authenticate(name, password string) (bool, error) {
+	u, err := s.Get(name)
+	if err != nil {
+		return false, err
+	}
+	if u == nil {
+		checkDummy(password)
+		return false, nil
+	}
+	if !u.CheckPassword(password) {
+		return false, nil
+	}
+
+	if u.NeedsRehash() {
+		if err := u.SetPassword(password); err == nil {
+			// the user is authenticated either way, the old hash keeps working
+			s.db.Exec(s.query("UPDATE users SET password = ? WHERE name = ?"), u.Password, u.Name)
+		}
+	}
+	return true, nil
+}
+
+// HasDomain reports whether the domain is in the domains table
+func (s *SQLStore) HasDomain(domain string) (bool, error) {
+	var name string
+	err := s.db.QueryRow(s.query("SELECT name FROM domains WHERE name = ?"), strings.ToLower(domain)).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Alias returns the destinations of the address in the aliases table
+func (s *SQLStore) Alias(address string) ([]string, error) {
+	rows, err := s.db.Query(s.query("SELECT destination FROM aliases WHERE address = ? ORDER BY destination"), strings.ToLower(address))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var destinations []string
+	for rows.Next() {
+		var destination string
+		if err := rows.Scan(&destination); err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, destination)
+	}
+	return destinations, rows.Err()
+}
@@ -0,0 +1,20 @@
+package user
+
+// UserStore is where the users, the local domains and the aliases come from
+type UserStore interface {
+	// Get returns the user with the name, or nil if there is no such user
+	Get(name string) (*User, error)
+	// Authenticate checks the credentials of a user
+	Authenticate(name, password string) (bool, error)
+	// HasDomain reports whether mail for the domain is delivered locally
+	HasDomain(domain string) (bool, error)
+	// Alias returns the destinations of an alias, or nil if the address isn't an alias
+	Alias(address string) ([]string, error)
+}
+
+var (
+	_ UserStore = &UserDB{}
+	_ UserStore = &SQLStore{}
+	_ UserStore = &LDAPStore{}
+	_ UserStore = &HTTPStore{}
+)
@@ -0,0 +1,229 @@
+// Package user contains the users which may authenticate, and their passwords
+package user
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopistolet/gopistolet/helpers"
+	"github.com/gopistolet/gopistolet/log"
+)
+
+// User is a user which may authenticate, Password is the hashed password (see HashPassword)
+type User struct {
+	Name     string
+	Password string
+	// SendAs are the other senders the user may use, see MaySendAs
+	SendAs []string `json:",omitempty"`
+	// Spam scores from which mail for the user is flagged, delivered in the junk folder or refused,
+	// the thresholds of the config apply if they are 0 (see config.Spam)
+	SpamTagScore    float64 `json:",omitempty"`
+	SpamJunkScore   float64 `json:",omitempty"`
+	SpamRejectScore float64 `json:",omitempty"`
+}
+
+// SetPassword hashes the password with the DefaultScheme
+func (u *User) SetPassword(password string) error {
+	hash, err := HashPassword(DefaultScheme, password)
+	if err != nil {
+		return err
+	}
+	u.Password = hash
+	return nil
+}
+
+// CheckPassword reports whether the password is the password of the user
+func (u *User) CheckPassword(password string) bool {
+	return verifyPassword(u.Password, password)
+}
+
+// NeedsRehash reports whether the password isn't hashed with the DefaultScheme,
+// so it can be rehashed after the user logs in with the correct password
+func (u *User) NeedsRehash() bool {
+	scheme, _ := passwordScheme(u.Password)
+	return scheme != DefaultScheme
+}
+
+// dummy is checked for unknown users, so they take as long as known users
+var (
+	dummy     = &User{}
+	dummyOnce sync.Once
+)
+
+func checkDummy(password string) {
+	dummyOnce.Do(func() {
+		dummy.SetPassword("dummy")
+	})
+	dummy.CheckPassword(password)
+}
+
+// UserDB contains the users, keyed by their (lower case) name.
+// The users are loaded from File, a JSON file with a list of users, which is reloaded
+// every Interval seconds (default 30) when it has changed after Start is called.
+type UserDB struct {
+	File     string
+	Interval int
+
+	// OnLoad is called when the users are replaced by the ones of a changed File
+	OnLoad func() `json:"-"`
+
+	mutex   sync.RWMutex
+	users   map[string]*User
+	modTime time.Time
+	stop    chan struct{}
+}
+
+// LoadDB loads the users from a JSON file with a list of users
+func LoadDB(file string) (*UserDB, error) {
+	db := &UserDB{File: file}
+	if err := db.Load(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Load (re)loads the users from File if it has changed.
+// The new users replace the old ones at once, so lookups never see a partially loaded file.
+func (db *UserDB) Load() error {
+	if db.File == "" {
+		return nil
+	}
+
+	info, err := os.Stat(db.File)
+	if err != nil {
+		return err
+	}
+	db.mutex.RLock()
+	unchanged := db.users != nil && info.ModTime().Equal(db.modTime)
+	db.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	list := []*User{}
+	if err := helpers.DecodeFile(db.File, &list); err != nil {
+		return err
+	}
+	users := make(map[string]*User)
+	for _, u := range list {
+		users[strings.ToLower(u.Name)] = u
+	}
+
+	db.mutex.Lock()
+	db.users = users
+	db.modTime = info.ModTime()
+	onLoad := db.OnLoad
+	db.mutex.Unlock()
+
+	if onLoad != nil {
+		onLoad()
+	}
+	return nil
+}
+
+// Start reloads File periodically
+func (db *UserDB) Start() {
+	interval := time.Duration(db.Interval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	db.mutex.Lock()
+	db.stop = make(chan struct{})
+	stop := db.stop
+	db.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := db.Load(); err != nil {
+					log.Errorln("Couldn't reload users:", err, "- Keeping the current users.")
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops reloading File
+func (db *UserDB) Stop() {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.stop != nil {
+		close(db.stop)
+		db.stop = nil
+	}
+}
+
+// Save writes the users to a JSON file
+func (db *UserDB) Save(file string) error {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	users := []*User{}
+	for _, u := range db.users {
+		users = append(users, u)
+	}
+	return helpers.EncodeFile(file, users)
+}
+
+// Get returns the user with the name, or nil if there is no such user
+func (db *UserDB) Get(name string) (*User, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.users[strings.ToLower(name)], nil
+}
+
+// HasDomain reports whether one of the users is in the domain
+func (db *UserDB) HasDomain(domain string) (bool, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	for name := range db.users {
+		if strings.HasSuffix(name, "@"+strings.ToLower(domain)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Alias returns nil, the JSON file doesn't contain aliases (see helpers.Aliases)
+func (db *UserDB) Alias(address string) ([]string, error) {
+	return nil, nil
+}
+
+// Add adds the user, or replaces the user with the same name
+func (db *UserDB) Add(u *User) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.users == nil {
+		db.users = make(map[string]*User)
+	}
+	db.users[strings.ToLower(u.Name)] = u
+}
+
+// Authenticate checks the credentials, passwords which aren't hashed with the DefaultScheme
+// are rehashed when they're correct (call Save to keep the new hashes)
+func (db *UserDB) Authenticate(name, password string) (bool, error) {
+	u, _ := db.Get(name)
+	if u == nil {
+		checkDummy(password)
+		return false, nil
+	}
+
+	db.mutex.RLock()
+	ok := u.CheckPassword(password)
+	rehash := ok && u.NeedsRehash()
+	db.mutex.RUnlock()
+
+	if rehash {
+		db.mutex.Lock()
+		u.SetPassword(password)
+		db.mutex.Unlock()
+	}
+	return ok, nil
+}
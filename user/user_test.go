@@ -0,0 +1,121 @@
+package user
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPassword(t *testing.T) {
+
+	Convey("Testing password schemes", t, func() {
+		for _, scheme := range []string{SchemeBcrypt, SchemeArgon2id, SchemePlain} {
+			hash, err := HashPassword(scheme, "secret")
+			So(err, ShouldEqual, nil)
+			So(strings.HasPrefix(hash, "{"+scheme+"}"), ShouldEqual, true)
+			So(verifyPassword(hash, "secret"), ShouldEqual, true)
+			So(verifyPassword(hash, "wrong"), ShouldEqual, false)
+		}
+
+		_, err := HashPassword("MD5", "secret")
+		So(err, ShouldNotEqual, nil)
+
+		// without scheme prefix
+		hash, _ := HashPassword(SchemeBcrypt, "secret")
+		So(verifyPassword(strings.TrimPrefix(hash, "{BLF-CRYPT}"), "secret"), ShouldEqual, true)
+		So(verifyPassword("secret", "secret"), ShouldEqual, true)
+		So(verifyPassword("{UNKNOWN}secret", "secret"), ShouldEqual, false)
+		So(verifyPassword("{ARGON2ID}$argon2id$v=19$broken", "secret"), ShouldEqual, false)
+
+		// argon2id parameters out of range are refused instead of panicking
+		hash, _ = HashPassword(SchemeArgon2id, "secret")
+		for _, params := range []string{"m=65536,t=0,p=4", "m=0,t=3,p=4", "m=65536,t=3,p=0", "m=16,t=3,p=4", "m=4194304,t=3,p=4", "m=65536,t=1000,p=4"} {
+			So(verifyPassword(strings.Replace(hash, "m=65536,t=3,p=4", params, 1), "secret"), ShouldEqual, false)
+		}
+		// as is a key which is empty or too short
+		So(verifyPassword(hash[:strings.LastIndex(hash, "$")+1], "secret"), ShouldEqual, false)
+		So(verifyPassword(hash[:strings.LastIndex(hash, "$")+5], "secret"), ShouldEqual, false)
+	})
+
+	Convey("Testing User", t, func() {
+		u := &User{Name: "bob@example.com", Password: "secret"}
+		So(u.CheckPassword("secret"), ShouldEqual, true)
+		So(u.NeedsRehash(), ShouldEqual, true)
+
+		So(u.SetPassword("new secret"), ShouldEqual, nil)
+		So(u.CheckPassword("secret"), ShouldEqual, false)
+		So(u.CheckPassword("new secret"), ShouldEqual, true)
+		So(u.NeedsRehash(), ShouldEqual, false)
+
+		checkDummy("x")
+		So(dummy.NeedsRehash(), ShouldEqual, false)
+	})
+
+}
+
+func TestUserDB(t *testing.T) {
+
+	Convey("Testing UserDB", t, func() {
+		dir, err := ioutil.TempDir("", "users")
+		So(err, ShouldEqual, nil)
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "users.json")
+		So(ioutil.WriteFile(file, []byte(`[{"Name": "Bob@example.com", "Password": "{PLAIN}secret"}]`), 0600), ShouldEqual, nil)
+
+		db, err := LoadDB(file)
+		So(err, ShouldEqual, nil)
+		authenticate := func(name, password string) bool {
+			ok, err := db.Authenticate(name, password)
+			So(err, ShouldEqual, nil)
+			return ok
+		}
+		needsRehash := func(name string) bool {
+			u, err := db.Get(name)
+			So(err, ShouldEqual, nil)
+			return u.NeedsRehash()
+		}
+		So(authenticate("bob@example.com", "wrong"), ShouldEqual, false)
+		So(authenticate("alice@example.com", "secret"), ShouldEqual, false)
+		So(needsRehash("bob@example.com"), ShouldEqual, true)
+
+		// the plain text password is rehashed after a successful login
+		So(authenticate("bob@example.com", "secret"), ShouldEqual, true)
+		So(needsRehash("bob@example.com"), ShouldEqual, false)
+
+		So(db.Save(file), ShouldEqual, nil)
+		db, err = LoadDB(file)
+		So(err, ShouldEqual, nil)
+		So(authenticate("bob@example.com", "secret"), ShouldEqual, true)
+
+		local, err := db.HasDomain("Example.com")
+		So(err, ShouldEqual, nil)
+		So(local, ShouldEqual, true)
+		local, _ = db.HasDomain("example.org")
+		So(local, ShouldEqual, false)
+
+		_, err = LoadDB(filepath.Join(dir, "missing.json"))
+		So(err, ShouldNotEqual, nil)
+
+		Convey("The users are reloaded when the file changes", func() {
+			So(ioutil.WriteFile(file, []byte(`[{"Name": "alice@example.com", "Password": "{PLAIN}secret"}]`), 0600), ShouldEqual, nil)
+			later := time.Now().Add(time.Minute)
+			So(os.Chtimes(file, later, later), ShouldEqual, nil)
+			So(db.Load(), ShouldEqual, nil)
+			So(authenticate("alice@example.com", "secret"), ShouldEqual, true)
+			So(authenticate("bob@example.com", "secret"), ShouldEqual, false)
+
+			// a broken file doesn't replace the users
+			So(ioutil.WriteFile(file, []byte(`[{"Name": `), 0600), ShouldEqual, nil)
+			later = later.Add(time.Minute)
+			So(os.Chtimes(file, later, later), ShouldEqual, nil)
+			So(db.Load(), ShouldNotEqual, nil)
+			So(authenticate("alice@example.com", "secret"), ShouldEqual, true)
+		})
+	})
+
+}